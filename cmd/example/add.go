@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// addPollInterval is how often add -wait checks the status of the queued image
+const addPollInterval = 5 * time.Second
+
+// finishedStatuses maps the statuses an image does not leave by itself to whether it has a result
+var finishedStatuses = map[domain.ImageStatus]bool{
+	domain.StatusReadyToPublish: true,
+	domain.StatusPublished:      true,
+	domain.StatusPublishFailed:  true,
+	domain.StatusFailed:         false,
+	domain.StatusTimedOut:       false,
+	domain.StatusDuplicate:      false,
+	domain.StatusRejected:       false,
+	domain.StatusCensored:       false,
+}
+
+// stringList is a flag that can be repeated, collecting every value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// addCommand queues a prompt and prints the ID of the new image. With -wait it polls the image
+// until it finishes and, with -out, writes the generated file there.
+func addCommand(args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	prompt := flags.String("prompt", "", "Prompt to generate (required)")
+	style := flags.String("style", "", "Style, overriding DEFAULT_STYLE")
+	negativePrompt := flags.String("negative-prompt", "", "Negative prompt, overriding DEFAULT_NEGATIVE_PROMPT")
+	width := flags.Int("width", 0, "Width in pixels, overriding DEFAULT_IMAGE_WIDTH")
+	height := flags.Int("height", 0, "Height in pixels, overriding DEFAULT_IMAGE_HEIGHT")
+	priority := flags.Int("priority", 0, "Priority; higher is generated first")
+	callbackURL := flags.String("callback-url", "", "URL notified when the image finishes or fails")
+	var tags stringList
+	flags.Var(&tags, "tag", "Tag of the image; can be repeated")
+	wait := flags.Bool("wait", false, "Wait until the image is generated or fails")
+	out := flags.String("out", "", "Write the generated image to this file (requires -wait)")
+	timeout := flags.Duration("timeout", 10*time.Minute, "How long -wait waits for the image")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	if strings.TrimSpace(*prompt) == "" {
+		return errors.New("-prompt is required")
+	}
+	if *out != "" && !*wait {
+		return errors.New("-out requires -wait")
+	}
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	configureLogging(cfg, *verbose)
+
+	opts := []repository.CreateOption{}
+	if *width != 0 || *height != 0 {
+		w, h := *width, *height
+		if w == 0 {
+			w = cfg.DefaultImageWidth
+		}
+		if h == 0 {
+			h = cfg.DefaultImageHeight
+		}
+		if cfg.SnapDimensions {
+			w, h = domain.SnapDimension(w), domain.SnapDimension(h)
+		} else if err := domain.ValidateDimensions(w, h); err != nil {
+			return err
+		}
+		opts = append(opts, repository.WithSize(w, h))
+	}
+	if *style != "" {
+		opts = append(opts, repository.WithStyle(*style))
+	}
+	if *negativePrompt != "" {
+		opts = append(opts, repository.WithNegativePrompt(*negativePrompt))
+	}
+	if *priority != 0 {
+		opts = append(opts, repository.WithPriority(*priority))
+	}
+	if *callbackURL != "" {
+		opts = append(opts, repository.WithCallbackURL(*callbackURL))
+	}
+	if len(tags) > 0 {
+		opts = append(opts, repository.WithTags(tags...))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Queue.Backend == "redis" {
+		img := &domain.Image{Prompt: strings.TrimSpace(*prompt)}
+		for _, opt := range opts {
+			opt(img)
+		}
+		return addToQueue(ctx, cfg, img, *wait, *out, *timeout)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	repo := repository.NewPostgresImageRepository(db, repository.WithDefaultSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight))
+	return addImage(ctx, repo, os.Stdout, addRequest{
+		prompt:  strings.TrimSpace(*prompt),
+		opts:    opts,
+		wait:    *wait,
+		out:     *out,
+		timeout: *timeout,
+		poll:    addPollInterval,
+		load: func(ctx context.Context, img *domain.Image) ([]byte, error) {
+			store, err := storage.NewFromConfig(ctx, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize storage: %w", err)
+			}
+			writer := results.NewWriter(repo, wiring.ResultsConfig(cfg), results.WithStorage(store), results.WithLogger(appLog))
+			return writer.Load(ctx, img)
+		},
+	})
+}
+
+// addRequest is an image to queue with add and what to do once it is queued
+type addRequest struct {
+	prompt string
+	opts   []repository.CreateOption
+	// wait polls the image every poll until it finishes or timeout passed
+	wait    bool
+	timeout time.Duration
+	poll    time.Duration
+	// out is the file the generated image is written to, loaded with load
+	out  string
+	load func(ctx context.Context, img *domain.Image) ([]byte, error)
+}
+
+// addImage queues the image of req in repo and prints its ID to stdout, then waits for it and
+// writes it out as req asks
+func addImage(ctx context.Context, repo repository.ImageRepository, stdout io.Writer, req addRequest) error {
+	id, err := repo.CreateImage(ctx, req.prompt, req.opts...)
+	if err != nil {
+		return fmt.Errorf("failed to queue image: %w", err)
+	}
+	fmt.Fprintln(stdout, id)
+
+	if !req.wait {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, req.timeout)
+	defer cancel()
+	img, err := waitForImage(waitCtx, repo, id, req.poll)
+	if err != nil {
+		return err
+	}
+	if !finishedStatuses[img.Status] {
+		return fmt.Errorf("image %d ended in status %s: %s", id, img.Status, img.ErrorDescription)
+	}
+	appLog.Info("Image generated", "image_id", id, "status", img.Status)
+
+	if req.out == "" {
+		return nil
+	}
+	data, err := req.load(ctx, img)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(req.out, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+	appLog.Info("Image written", "image_id", id, "path", req.out)
+	return nil
+}
+
+// waitForImage polls the image with the given ID every interval until it reaches one of
+// finishedStatuses
+func waitForImage(ctx context.Context, repo repository.ImageRepository, id int, interval time.Duration) (*domain.Image, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		img, err := repo.GetImage(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image %d: %w", id, err)
+		}
+		if img == nil {
+			return nil, fmt.Errorf("image %d no longer exists", id)
+		}
+		if _, ok := finishedStatuses[img.Status]; ok {
+			return img, nil
+		}
+		appLog.Debug("Waiting for image", "image_id", id, "status", img.Status)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting for image %d in status %s: %w", id, img.Status, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
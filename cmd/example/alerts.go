@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/alert"
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+const (
+	// alertMinImages is the smallest pass the failure rate rule judges, so that a single failed
+	// image of a small pass does not alert
+	alertMinImages = 5
+	// alertTimeout bounds evaluating the rules and posting the alerts of a single pass
+	alertTimeout = 30 * time.Second
+)
+
+// alerter evaluates the alert rules after every workflow pass and posts the alerts that fire,
+// at most once per rule and workflow per ALERT_COOLDOWN
+type alerter struct {
+	repo     repository.ImageRepository
+	throttle *alert.Throttle
+	cfg      *config.AlertConfig
+	clk      clock.Clock
+
+	mu sync.Mutex
+	// lastGenerated is when a processor pass of this process last finished a generation or
+	// found nothing to wait for
+	lastGenerated time.Time
+}
+
+// newAlerter creates an alerter posting through notifier
+func newAlerter(repo repository.ImageRepository, notifier alert.Notifier, cfg *config.AlertConfig, clk clock.Clock) *alerter {
+	return &alerter{
+		repo:          repo,
+		throttle:      alert.NewThrottle(notifier, cfg.Cooldown, clk),
+		cfg:           cfg,
+		clk:           clk,
+		lastGenerated: clk.Now(),
+	}
+}
+
+// afterPass evaluates the rules for a finished pass of workflow in the background and posts the
+// alerts that fire; -once waits for it along with the webhooks. It is the AfterPass hook of the
+// workflows.
+func (a *alerter) afterPass(workflow string, summary workflows.Summary) {
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+
+		for _, al := range a.evaluate(ctx, workflow, summary) {
+			al.Workflow = workflow
+			al.Summary = summary.String()
+			sent, err := a.throttle.Notify(ctx, al)
+			switch {
+			case err != nil:
+				workflowLog.Warn("Error sending alert", "workflow", workflow, "rule", al.Rule, "error", err)
+			case sent:
+				workflowLog.Info("Sent alert", "workflow", workflow, "rule", al.Rule, "message", al.Message)
+			}
+		}
+	}()
+}
+
+// evaluate returns the alerts of the rules that fire after a pass of workflow
+func (a *alerter) evaluate(ctx context.Context, workflow string, summary workflows.Summary) []alert.Alert {
+	var alerts []alert.Alert
+	if rate := a.cfg.FailureRate; rate > 0 && summary.Images >= alertMinImages {
+		failures := summary.Errors + summary.TimedOut
+		if float64(failures)/float64(summary.Images) > rate {
+			alerts = append(alerts, alert.Alert{
+				Rule:    "failure_rate",
+				Message: fmt.Sprintf("%d of %d images failed or timed out, above the threshold of %.0f%%", failures, summary.Images, rate*100),
+			})
+		}
+	}
+
+	// The backlog is judged after generator passes, which are the ones expected to drain it,
+	// and the lack of generations after processor passes, which are the ones finishing them
+	var (
+		al  *alert.Alert
+		err error
+	)
+	switch workflow {
+	case "generator":
+		al, err = a.checkBacklog(ctx)
+	case "processor":
+		al, err = a.checkGenerations(ctx, summary)
+	}
+	if err != nil {
+		workflowLog.Warn("Error evaluating alert rules", "workflow", workflow, "error", err)
+	}
+	if al != nil {
+		alerts = append(alerts, *al)
+	}
+	return alerts
+}
+
+// checkBacklog alerts when the oldest ReadyToGenerate image has waited longer than
+// ALERT_BACKLOG_AGE
+func (a *alerter) checkBacklog(ctx context.Context) (*alert.Alert, error) {
+	if a.cfg.BacklogAge <= 0 {
+		return nil, nil
+	}
+	oldest, err := a.repo.ListImages(ctx, repository.ListFilter{
+		Status:    domain.StatusReadyToGenerate,
+		Limit:     1,
+		SortBy:    repository.SortByCreatedAt,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oldest queued image: %w", err)
+	}
+	if len(oldest) == 0 || oldest[0].CreatedAt.IsZero() {
+		return nil, nil
+	}
+	age := a.clk.Now().Sub(oldest[0].CreatedAt)
+	if age < a.cfg.BacklogAge {
+		return nil, nil
+	}
+	return &alert.Alert{
+		Rule:    "queue_backlog",
+		Message: fmt.Sprintf("image %d has been waiting for generation for %s", oldest[0].ID, age.Truncate(time.Minute)),
+	}, nil
+}
+
+// checkGenerations alerts when no generation finished for ALERT_NO_GENERATION_AFTER while images
+// were waiting for one
+func (a *alerter) checkGenerations(ctx context.Context, summary workflows.Summary) (*alert.Alert, error) {
+	if a.cfg.NoGenerationAfter <= 0 {
+		return nil, nil
+	}
+	now := a.clk.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if summary.Generated > 0 {
+		a.lastGenerated = now
+		return nil, nil
+	}
+
+	// An empty pipeline is idle rather than stuck
+	waiting := 0
+	for _, status := range []domain.ImageStatus{domain.StatusReadyToGenerate, domain.StatusClaimed, domain.StatusGenerate} {
+		n, err := a.repo.CountImages(ctx, repository.ListFilter{Status: status})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s images: %w", status, err)
+		}
+		waiting += n
+	}
+	if waiting == 0 {
+		a.lastGenerated = now
+		return nil, nil
+	}
+
+	if since := now.Sub(a.lastGenerated); since >= a.cfg.NoGenerationAfter {
+		return &alert.Alert{
+			Rule:    "no_generations",
+			Message: fmt.Sprintf("no generation finished for %s while %d images are waiting", since.Truncate(time.Minute), waiting),
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/alert"
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestAlerterRules(t *testing.T) {
+	var mu sync.Mutex
+	var texts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("alert payload is not JSON: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		texts = append(texts, payload.Text)
+	}))
+	defer srv.Close()
+
+	repo := repository.NewMemoryImageRepository()
+	if _, err := repo.CreateImage(context.Background(), "a lighthouse"); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	clk := clock.NewFake(time.Now())
+	cfg := &config.AlertConfig{Cooldown: time.Hour, FailureRate: 0.5, BacklogAge: 2 * time.Hour, NoGenerationAfter: 3 * time.Hour}
+	a := newAlerter(repo, alert.NewSlack(srv.URL), cfg, clk)
+
+	// pass runs the rules after a pass of workflow and checks that the posted alerts start with
+	// the given prefixes, in order
+	pass := func(workflow string, summary workflows.Summary, want ...string) {
+		t.Helper()
+		mu.Lock()
+		texts = nil
+		mu.Unlock()
+		a.afterPass(workflow, summary)
+		webhookDeliveries.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(texts) != len(want) {
+			t.Fatalf("after a %s pass of %s posted %q, want alerts %q", workflow, summary, texts, want)
+		}
+		for i, text := range texts {
+			if !strings.HasPrefix(text, want[i]) || !strings.HasSuffix(text, "\nLast pass: "+summary.String()) {
+				t.Errorf("alert %q, want %q with the summary of the pass", text, want[i])
+			}
+		}
+	}
+	failing := workflows.Summary{Images: 10, Submitted: 4, Errors: 4, TimedOut: 2}
+
+	// More than half of the images failed or timed out
+	pass("generator", failing, "[2xiang] failure_rate (generator): 6 of 10 images")
+	// The same rule stays quiet for the cooldown, while a small pass never alerts on its failures
+	pass("generator", failing)
+	pass("processor", workflows.Summary{Images: 4, Errors: 4})
+
+	// The image has waited for BacklogAge
+	clk.Advance(2 * time.Hour)
+	pass("generator", workflows.Summary{}, "[2xiang] queue_backlog (generator): image 1 has been waiting for generation for 2h0m0s")
+
+	// No generation finished for NoGenerationAfter while the image waits
+	clk.Advance(time.Hour)
+	pass("processor", workflows.Summary{}, "[2xiang] no_generations (processor): no generation finished for 3h0m0s while 1 images are waiting")
+	// A finished generation starts the wait over
+	pass("processor", workflows.Summary{Images: 1, Generated: 1})
+	clk.Advance(time.Hour)
+	pass("processor", workflows.Summary{Images: 1})
+
+	// Once the cooldowns ran out the rules alert again
+	pass("generator", failing, "[2xiang] failure_rate (generator)", "[2xiang] queue_backlog (generator)")
+}
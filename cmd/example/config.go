@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"text/tabwriter"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/redact"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+)
+
+// checkTimeout bounds each check of config validate
+const checkTimeout = 10 * time.Second
+
+// pinger is implemented by providers that can verify their credentials without generating
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// checkResult is the outcome of a single config validate check
+type checkResult struct {
+	name   string
+	err    error
+	detail string
+}
+
+// configCommand runs the config subcommands, validate and print, which load the configuration
+// without starting any workflow
+func configCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected a subcommand: validate or print")
+	}
+
+	switch args[0] {
+	case "validate":
+		return configValidateCommand(args[1:])
+	case "print":
+		return configPrintCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown config subcommand %q, expected validate or print", args[0])
+	}
+}
+
+// configValidateCommand loads the configuration, pings the database, or Redis with QUEUE=redis,
+// and checks the credentials of the selected providers, printing a report of every check. It
+// fails when any check does.
+func configValidateCommand(args []string) error {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	cfg, err := config.Load(configOptions(*configFile)...)
+	results := []checkResult{{name: "configuration", err: err}}
+	if err == nil {
+		configureLogging(cfg, false)
+		if cfg.Queue.Backend == "redis" {
+			results = append(results, checkQueue(cfg))
+		} else {
+			results = append(results, checkDatabase(cfg))
+		}
+		results = append(results, checkProviders(cfg)...)
+	}
+
+	failed := writeCheckReport(os.Stdout, results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// checkDatabase connects to the configured database
+func checkDatabase(cfg *config.Config) checkResult {
+	result := checkResult{name: "database"}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	result.err = db.PingContext(ctx)
+	return result
+}
+
+// checkQueue connects to the Redis server of the QUEUE=redis mode
+func checkQueue(cfg *config.Config) checkResult {
+	result := checkResult{name: "redis queue"}
+
+	q, err := wiring.NewRedisQueue(cfg)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer q.Close()
+
+	result.err = pingQueue(q)
+	return result
+}
+
+// checkProviders verifies the credentials of every selected provider that supports it
+func checkProviders(cfg *config.Config) []checkResult {
+	results := make([]checkResult, 0, len(cfg.Providers))
+	for _, name := range cfg.Providers {
+		result := checkResult{name: "provider " + name}
+
+		provider, err := wiring.NewProvider(name, cfg, metrics.Nop{}, appLog)
+		if err != nil {
+			result.err = err
+			results = append(results, result)
+			continue
+		}
+
+		p, ok := provider.(pinger)
+		if !ok {
+			result.detail = "credentials are not checked for this provider"
+			results = append(results, result)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		result.err = p.Ping(ctx)
+		cancel()
+		results = append(results, result)
+	}
+	return results
+}
+
+// writeCheckReport writes a line per check and returns the number of failed checks
+func writeCheckReport(w io.Writer, results []checkResult) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(tw, "FAIL\t%s\t%v\n", r.name, r.err)
+		case r.detail != "":
+			fmt.Fprintf(tw, "SKIP\t%s\t%s\n", r.name, r.detail)
+		default:
+			fmt.Fprintf(tw, "OK\t%s\t\n", r.name)
+		}
+	}
+	tw.Flush()
+	return failed
+}
+
+// configPrintCommand writes the effective configuration as JSON with its credentials masked.
+// Provider credentials are not required, so a partial configuration can be inspected.
+func configPrintCommand(args []string) error {
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return writeConfigJSON(os.Stdout, cfg)
+}
+
+// writeConfigJSON writes cfg to w as indented JSON with its credentials masked
+func writeConfigJSON(w io.Writer, cfg *config.Config) error {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(printable(reflect.ValueOf(cfg.Masked()).Elem())); err != nil {
+		return err
+	}
+	// Masked covers the credential fields; this catches a secret repeated in any other setting
+	_, err := w.Write(redact.New(cfg.Secrets()...).Bytes(out.Bytes()))
+	return err
+}
+
+// printable converts a configuration value for JSON output, writing durations like 30s
+// rather than as nanoseconds
+func printable(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if _, ok := v.Interface().(json.Marshaler); ok {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]any, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fields[v.Type().Field(i).Name] = printable(v.Field(i))
+			}
+		}
+		return fields
+	case reflect.Slice:
+		if v.IsNil() {
+			return []any{}
+		}
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = printable(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+)
+
+func TestWriteConfigJSONMasksSecrets(t *testing.T) {
+	cfg := &config.Config{
+		Provider:      "fusionbrain",
+		FusionBrain:   config.FusionBrainConfig{APIKey: "fb-api-key-0001", SecretKey: "fb-secret-key-0002"},
+		APIToken:      "api-token-0003",
+		HTTPAddr:      ":8080",
+		DB:            config.DBConfig{Host: "db", Password: "db-password-0004", URL: "postgres://images:db-url-pass-0005@db/images"},
+		CheckInterval: 2 * time.Second,
+		// A secret repeated in a setting that is not a credential
+		PromptPrefix: "fb-secret-key-0002",
+	}
+
+	var out bytes.Buffer
+	if err := writeConfigJSON(&out, cfg); err != nil {
+		t.Fatalf("writeConfigJSON() error = %v", err)
+	}
+	for _, secret := range []string{"fb-api-key-0001", "fb-secret-key-0002", "api-token-0003", "db-password-0004", "db-url-pass-0005"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, out.String())
+		}
+	}
+
+	var printed map[string]any
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatalf("printed configuration is not JSON: %v", err)
+	}
+	if printed["HTTPAddr"] != ":8080" || printed["CheckInterval"] != "2s" || printed["PromptPrefix"] != "****0002" {
+		t.Errorf("printed configuration = %v, want settings kept and durations readable", printed)
+	}
+	if fb, _ := printed["FusionBrain"].(map[string]any); fb["APIKey"] != "****0001" {
+		t.Errorf("printed FusionBrain = %v, want the key masked to its last four characters", printed["FusionBrain"])
+	}
+}
+
+func FuzzWriteConfigJSONMasksSecrets(f *testing.F) {
+	f.Add("fb-api-key-0001", "prefix ")
+	f.Add("p", "")
+	f.Add("abab1234ab", "abab1234")
+	f.Fuzz(func(t *testing.T, key, text string) {
+		secret, ok := testsupport.FuzzSecret(key)
+		if !ok {
+			t.Skip()
+		}
+		// A secret of its own per credential, each of which starts with secret
+		secrets := make([]string, 17)
+		for i := range secrets {
+			secrets[i] = fmt.Sprintf("%s-%02d", secret, i)
+		}
+		// The whole webhook URL is the credential of the alerts
+		webhook := "https://hooks.example.com/" + secrets[9]
+		cfg := &config.Config{
+			FusionBrain:       config.FusionBrainConfig{APIKey: secrets[0], SecretKey: secrets[1], ProxyURL: "http://proxy:" + secrets[2] + "@proxy:3128"},
+			OpenAI:            config.OpenAIConfig{APIKey: secrets[3]},
+			Replicate:         config.ReplicateConfig{APIToken: secrets[4]},
+			Stability:         config.StabilityConfig{APIKey: secrets[5]},
+			S3SecretAccessKey: secrets[6],
+			TelegramBotToken:  secrets[7],
+			WebhookSecret:     secrets[8],
+			Alerts:            config.AlertConfig{WebhookURL: webhook},
+			Events:            config.EventsConfig{NATSURL: "nats://events:" + secrets[10] + "@nats:4222", NATSPassword: secrets[11], NATSToken: secrets[12]},
+			Queue:             config.QueueConfig{RedisURL: "redis://:" + secrets[13] + "@redis:6379/0"},
+			APIToken:          secrets[14],
+			DB:                config.DBConfig{Password: secrets[15], URL: "postgres://images:" + secrets[16] + "@db/images"},
+			// Secrets repeated in settings that are not credentials
+			PromptPrefix: text + secrets[0] + text,
+			PromptSuffix: strings.Join(secrets[:9], text) + webhook + strings.Join(secrets[10:], text),
+		}
+
+		var out bytes.Buffer
+		if err := writeConfigJSON(&out, cfg); err != nil {
+			t.Fatalf("writeConfigJSON() error = %v", err)
+		}
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("printed configuration contains %q:\n%s", secret, out.String())
+		}
+		if !json.Valid(out.Bytes()) {
+			t.Fatalf("printed configuration is not JSON:\n%s", out.String())
+		}
+	})
+}
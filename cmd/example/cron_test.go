@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+	"github.com/robfig/cron/v3"
+)
+
+func TestCronJobSurvivesPanics(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	runs := 0
+	job := cronJob(context.Background(), repo, "generator", func(ctx context.Context) (workflows.Summary, error) {
+		runs++
+		if runs == 1 {
+			panic("unexpected provider response")
+		}
+		return workflows.Summary{}, nil
+	})
+	// The scheduler recovers panics of jobs the way startCronWorkflows sets it up
+	recovered := cron.NewChain(cron.Recover(cronLogger{appLog})).Then(cron.FuncJob(job))
+
+	recovered.Run()
+	// Neither the mutex nor the advisory lock is left held by the panicking run
+	recovered.Run()
+	if runs != 2 {
+		t.Errorf("pass ran %d times, want the run after the panic to go ahead", runs)
+	}
+	locked, err := repo.TryAdvisoryLock(context.Background(), cronLockKeys["generator"])
+	if err != nil || !locked {
+		t.Errorf("TryAdvisoryLock() = %v, %v, want the lock released", locked, err)
+	}
+}
+
+func TestCronJobSkipsOverlappingRuns(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var generatorRuns, processorRuns atomic.Int32
+	generator := cronJob(context.Background(), repo, "generator", func(ctx context.Context) (workflows.Summary, error) {
+		if generatorRuns.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return workflows.Summary{}, nil
+	})
+	processor := cronJob(context.Background(), repo, "processor", func(ctx context.Context) (workflows.Summary, error) {
+		processorRuns.Add(1)
+		return workflows.Summary{}, nil
+	})
+
+	slow := make(chan struct{})
+	go func() {
+		defer close(slow)
+		generator()
+	}()
+	<-started
+
+	// Triggers while the slow run is in progress return right away instead of queueing
+	for i := 0; i < 3; i++ {
+		returned := make(chan struct{})
+		go func() {
+			defer close(returned)
+			generator()
+		}()
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Fatal("overlapping trigger waited for the slow run")
+		}
+	}
+	// The processor does not wait for the generator either
+	processor()
+
+	close(release)
+	<-slow
+	if n := generatorRuns.Load(); n != 1 {
+		t.Errorf("generator ran %d times, want the overlapping triggers skipped", n)
+	}
+	if n := processorRuns.Load(); n != 1 {
+		t.Errorf("processor ran %d times during the slow generator run, want 1", n)
+	}
+
+	// Once the slow run finished, the next trigger runs again
+	generator()
+	if n := generatorRuns.Load(); n != 2 {
+		t.Errorf("generator ran %d times, want the trigger after the slow run to go ahead", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Delays between the startup attempts to reach the database, variables so tests can shorten them
+var (
+	dbRetryInitialDelay = 500 * time.Millisecond
+	dbRetryMaxDelay     = 5 * time.Second
+)
+
+// databasePinger is the part of *sql.DB waitForDatabase needs
+type databasePinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// waitForDatabase pings the database until it answers, retrying with exponential backoff for
+// up to window so the service survives a database that starts a few seconds after it. A zero
+// window pings once.
+func waitForDatabase(ctx context.Context, db databasePinger, window time.Duration) error {
+	deadline := time.Now().Add(window)
+	delay := dbRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if window == 0 {
+				return err
+			}
+			return fmt.Errorf("database not reachable after %s (%d attempts): %w", window, attempt, err)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		appLog.Warn("Database not reachable yet, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > dbRetryMaxDelay {
+			delay = dbRetryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePinger returns err from the first failures pings and nil after that
+type fakePinger struct {
+	failures int
+	err      error
+	pings    int
+}
+
+func (p *fakePinger) PingContext(ctx context.Context) error {
+	p.pings++
+	if p.pings <= p.failures {
+		return p.err
+	}
+	return nil
+}
+
+// shortRetryDelays shortens the delays between startup pings for the test
+func shortRetryDelays(t *testing.T) {
+	initial, max := dbRetryInitialDelay, dbRetryMaxDelay
+	dbRetryInitialDelay, dbRetryMaxDelay = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { dbRetryInitialDelay, dbRetryMaxDelay = initial, max })
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	shortRetryDelays(t)
+	errRefused := errors.New("connection refused")
+
+	t.Run("reachable", func(t *testing.T) {
+		db := &fakePinger{}
+		if err := waitForDatabase(context.Background(), db, time.Second); err != nil || db.pings != 1 {
+			t.Errorf("waitForDatabase() = %v after %d pings, want nil after 1", err, db.pings)
+		}
+	})
+
+	t.Run("starts late", func(t *testing.T) {
+		db := &fakePinger{failures: 5, err: errRefused}
+		if err := waitForDatabase(context.Background(), db, 10*time.Second); err != nil || db.pings != 6 {
+			t.Errorf("waitForDatabase() = %v after %d pings, want nil after 6", err, db.pings)
+		}
+	})
+
+	t.Run("no window", func(t *testing.T) {
+		db := &fakePinger{failures: 1, err: errRefused}
+		if err := waitForDatabase(context.Background(), db, 0); err != errRefused || db.pings != 1 {
+			t.Errorf("waitForDatabase() = %v after %d pings, want the ping error after 1", err, db.pings)
+		}
+	})
+
+	t.Run("window exceeded", func(t *testing.T) {
+		db := &fakePinger{failures: 1 << 30, err: errRefused}
+		start := time.Now()
+		err := waitForDatabase(context.Background(), db, 50*time.Millisecond)
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("waitForDatabase() gave up after %s, want about the 50ms window", elapsed)
+		}
+		if !errors.Is(err, errRefused) || !strings.Contains(err.Error(), "database not reachable after 50ms") {
+			t.Errorf("waitForDatabase() error = %v, want a clear message wrapping the ping error", err)
+		}
+		// Backing off up to the maximum delay keeps the attempts well below one per millisecond
+		if db.pings < 3 || db.pings > 40 {
+			t.Errorf("pinged %d times in 50ms, want backoff between the attempts", db.pings)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		db := &fakePinger{failures: 1 << 30, err: errRefused}
+		if err := waitForDatabase(ctx, db, time.Minute); err != errRefused || db.pings != 1 {
+			t.Errorf("waitForDatabase() = %v after %d pings, want to stop once canceled", err, db.pings)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/events"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// eventSender publishes the lifecycle events of images to the message broker
+type eventSender struct {
+	publisher *events.Publisher
+}
+
+// onOutcome publishes the event of the final status of img in the background, once the status
+// has been written. Deliveries that keep failing are logged and dropped. It is part of the
+// OnOutcome hook of the workflows.
+func (s *eventSender) onOutcome(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string) {
+	event, ok := events.NewEvent(img, status, errorDescription, time.Now())
+	if !ok {
+		return
+	}
+
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		attempts, err := s.publisher.Publish(ctx, event)
+		if err != nil && ctx.Err() == nil {
+			workflowLog.Warn("Error publishing event", "image_id", event.ID, "uuid", event.UUID, "event", event.Type, "attempt", attempts, "error", err)
+		}
+	}()
+}
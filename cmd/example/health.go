@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/health"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// providerCheckTTL is how long the result of a provider credential check is reused, so
+// frequent readiness probes don't count against the API quota
+const providerCheckTTL = time.Minute
+
+// healthChecks returns the readiness checks: the database and the credentials of the Fusion
+// Brain client among the providers of the service. The client is the one the service uses, so
+// its probes share the rate limiter and the metrics of the generations.
+func healthChecks(db *sql.DB, provider domain.ImageProvider) []health.Check {
+	checks := []health.Check{{Name: "database", Check: db.PingContext}}
+
+	providers := []domain.ImageProvider{provider}
+	if chain, ok := provider.(*service.FallbackProvider); ok {
+		providers = chain.Providers()
+	}
+	for _, p := range providers {
+		if client, ok := p.(*fusionbrain.Client); ok {
+			checks = append(checks, health.Check{Name: client.Name(), Check: health.Cached(client.Ping, providerCheckTTL)})
+		}
+	}
+
+	return checks
+}
+
+// newMetrics registers the workflow, queue depth, provider usage, connection pool and runtime
+// metrics with registry
+func newMetrics(registry *prometheus.Registry, repo repository.ImageRepository, db *sql.DB) (*metrics.Prometheus, error) {
+	prom, err := metrics.NewPrometheus(registry)
+	if err != nil {
+		return nil, err
+	}
+	registry.MustRegister(
+		metrics.NewQueueCollector(func(ctx context.Context) (map[string]int, error) {
+			counts, err := repo.CountByStatus(ctx)
+			if err != nil {
+				return nil, err
+			}
+			byName := make(map[string]int, len(counts))
+			for status, n := range counts {
+				byName[string(status)] = n
+			}
+			return byName, nil
+		}),
+		metrics.NewUsageCollector(func(ctx context.Context) (map[string]metrics.Usage, error) {
+			usage, err := repo.GetUsage(ctx, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			byProvider := make(map[string]metrics.Usage, len(usage))
+			for _, u := range usage {
+				byProvider[u.Provider] = metrics.Usage{Requests: u.Requests, Failures: u.Failures}
+			}
+			return byProvider, nil
+		}),
+		collectors.NewDBStatsCollector(db, metrics.Namespace),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return prom, nil
+}
+
+// serveHTTP serves the handler of httpHandler on addr until ctx is cancelled
+func serveHTTP(ctx context.Context, addr string, health http.Handler, registry *prometheus.Registry, images, profiles http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           httpHandler(health, registry, images, profiles),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	httpLog.Info("Serving health endpoints and metrics", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		httpLog.Error("Error serving HTTP", "error", err)
+	}
+}
+
+// httpHandler routes the health endpoints, the metrics in registry on /metrics and, unless they
+// are nil, the image API on /images and the profiling endpoints on /debug/pprof/
+func httpHandler(health http.Handler, registry *prometheus.Registry, images, profiles http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.Handle("/", health)
+	if images != nil {
+		mux.Handle("/images", images)
+		mux.Handle("/images/", images)
+		mux.Handle("/control", images)
+		mux.Handle("/control/", images)
+	}
+	if profiles != nil {
+		mux.Handle(pprofPrefix, profiles)
+	}
+	return mux
+}
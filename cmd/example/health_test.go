@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/infrastructure/openai"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewMetricsExportsPoolStats(t *testing.T) {
+	db := testsupport.OpenFakeDB(t)
+	db.SetMaxOpenConns(1)
+	registry := prometheus.NewRegistry()
+	if _, err := newMetrics(registry, repository.NewMemoryImageRepository(), db); err != nil {
+		t.Fatalf("newMetrics() error = %v", err)
+	}
+	gauges := []string{"go_sql_max_open_connections", "go_sql_open_connections", "go_sql_in_use_connections", "go_sql_idle_connections", "go_sql_wait_count_total"}
+
+	// The gauges are read from the pool on every scrape, so they follow contention as it happens
+	release := testsupport.ContendPool(t, db)
+	busy := `
+# HELP go_sql_idle_connections The number of idle connections.
+# TYPE go_sql_idle_connections gauge
+go_sql_idle_connections{db_name="xiang"} 0
+# HELP go_sql_in_use_connections The number of connections currently in use.
+# TYPE go_sql_in_use_connections gauge
+go_sql_in_use_connections{db_name="xiang"} 1
+# HELP go_sql_max_open_connections Maximum number of open connections to the database.
+# TYPE go_sql_max_open_connections gauge
+go_sql_max_open_connections{db_name="xiang"} 1
+# HELP go_sql_open_connections The number of established connections both in use and idle.
+# TYPE go_sql_open_connections gauge
+go_sql_open_connections{db_name="xiang"} 1
+# HELP go_sql_wait_count_total The total number of connections waited for.
+# TYPE go_sql_wait_count_total counter
+go_sql_wait_count_total{db_name="xiang"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(busy), gauges...)
+	release()
+	if err != nil {
+		t.Errorf("metrics during contention: %v", err)
+	}
+
+	released := strings.NewReplacer(
+		`go_sql_idle_connections{db_name="xiang"} 0`, `go_sql_idle_connections{db_name="xiang"} 1`,
+		`go_sql_in_use_connections{db_name="xiang"} 1`, `go_sql_in_use_connections{db_name="xiang"} 0`,
+	).Replace(busy)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(released), gauges...); err != nil {
+		t.Errorf("metrics after contention: %v", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "go_sql_wait_duration_seconds_total" {
+			if waited := family.GetMetric()[0].GetCounter().GetValue(); waited <= 0 {
+				t.Errorf("go_sql_wait_duration_seconds_total = %v, want the time the waiter spent", waited)
+			}
+			return
+		}
+	}
+	t.Error("go_sql_wait_duration_seconds_total is not exported")
+}
+
+// requestRecorder is a metrics.Hook counting the provider API requests reported to it
+type requestRecorder struct {
+	metrics.Nop
+	mu       sync.Mutex
+	requests int
+}
+
+func (r *requestRecorder) ObserveDuration(name string, _ time.Duration, _ map[string]string) {
+	if name == metrics.APIRequestDuration {
+		r.mu.Lock()
+		r.requests++
+		r.mu.Unlock()
+	}
+}
+
+func TestHealthChecksUseProviderOfService(t *testing.T) {
+	srv := fakeserver.New(fakeserver.WithCredentials("key", "secret"))
+	defer srv.Close()
+	hook := &requestRecorder{}
+	client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL), fusionbrain.WithMetrics(hook))
+	chain := service.NewFallbackProvider(openai.NewClient("sk-test", ""), client)
+
+	checks := healthChecks(testsupport.OpenFakeDB(t), chain)
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, ","); got != "database,fusionbrain" {
+		t.Fatalf("checks = %s, want database,fusionbrain", got)
+	}
+
+	// The probe goes through the client of the service, reporting to its metrics
+	if err := checks[1].Check(context.Background()); err != nil {
+		t.Fatalf("fusionbrain check error = %v", err)
+	}
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if hook.requests != 1 {
+		t.Errorf("service client made %d requests, want the probe", hook.requests)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// importColumns are the columns of an import file, the header of a CSV file and the keys of the
+// objects of a JSON file; only prompt is required
+var importColumns = []string{"prompt", "style", "negative_prompt", "width", "height", "priority", "tags", "scheduled_at"}
+
+// importTimeLayouts are the accepted forms of scheduled_at; those without a zone are local time
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// importRow is a row of an import file as written
+type importRow struct {
+	Prompt         string   `json:"prompt"`
+	Style          string   `json:"style"`
+	NegativePrompt string   `json:"negative_prompt"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	Priority       int      `json:"priority"`
+	Tags           []string `json:"tags"`
+	ScheduledAt    string   `json:"scheduled_at"`
+}
+
+// importLine is a row of an import file with its number: the line of a CSV record or the
+// position of a JSON object, counted from 1. err is set when the row could not be read.
+type importLine struct {
+	number int
+	row    importRow
+	err    error
+}
+
+// importNote explains why a row was not inserted
+type importNote struct {
+	number int
+	reason string
+}
+
+// importCommand queues the prompts of a CSV or JSON file, skipping invalid rows and prompts that
+// are already queued or generated. With -dry-run it only reports what it would insert.
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "CSV or JSON file of prompts (required)")
+	format := flags.String("format", "", "File format, csv or json (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "Report what would be inserted without inserting anything")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use -format csv or -format json", *format)
+	}
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	configureLogging(cfg, *verbose)
+	if cfg.Queue.Backend == "redis" {
+		return errors.New("import queues images in the database and does not support QUEUE=redis")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []importLine
+	if *format == "csv" {
+		lines, err = readImportCSV(f)
+	} else {
+		lines, err = readImportJSON(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *file, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	repo := repository.NewPostgresImageRepository(db, repository.WithDefaultSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight))
+
+	ids, err := importImages(ctx, repo, os.Stdout, cfg, lines, *dryRun)
+	if len(ids) > 0 {
+		appLog.Info("Images imported", "file", *file, "first_id", ids[0], "last_id", ids[len(ids)-1])
+	}
+	return err
+}
+
+// importImages validates lines and inserts the images of the valid rows into repo, skipping
+// duplicates, then writes the summary to stdout. With dryRun it lists the rows it would insert
+// instead. It returns the IDs of the inserted images, and an error when rows were invalid.
+func importImages(ctx context.Context, repo repository.ImageRepository, stdout io.Writer, cfg *config.Config, lines []importLine, dryRun bool) ([]int, error) {
+	var numbers []int
+	var images []*domain.Image
+	var invalid []importNote
+	for _, line := range lines {
+		img, err := line.image(cfg)
+		if err != nil {
+			invalid = append(invalid, importNote{line.number, err.Error()})
+			continue
+		}
+		numbers = append(numbers, line.number)
+		images = append(images, img)
+	}
+
+	keep, skipped, err := skipDuplicates(ctx, repo, cfg, numbers, images)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicates: %w", err)
+	}
+	var insert []*domain.Image
+	for _, i := range keep {
+		insert = append(insert, images[i])
+	}
+
+	var ids []int
+	if dryRun {
+		for _, i := range keep {
+			fmt.Fprintf(stdout, "row %d: would insert %q\n", numbers[i], abbreviate(images[i].Prompt, 60))
+		}
+	} else if len(insert) > 0 {
+		if ids, err = repo.CreateImages(ctx, insert); err != nil {
+			return nil, fmt.Errorf("failed to insert images: %w", err)
+		}
+	}
+
+	writeImportSummary(stdout, len(insert), skipped, invalid, dryRun)
+	if len(invalid) > 0 {
+		return ids, fmt.Errorf("%d invalid rows", len(invalid))
+	}
+	return ids, nil
+}
+
+// skipDuplicates returns the indexes of the images to insert, leaving out those with the prompt
+// hash of an earlier row or of an image in the database that has not failed
+func skipDuplicates(ctx context.Context, repo repository.ImageRepository, cfg *config.Config, numbers []int, images []*domain.Image) ([]int, []importNote, error) {
+	hashes := make([]string, len(images))
+	firstRow := make(map[string]int, len(images))
+	var unique []string
+	for i, img := range images {
+		hashes[i] = img.PromptHash(cfg.DefaultImageWidth, cfg.DefaultImageHeight)
+		if _, ok := firstRow[hashes[i]]; !ok {
+			firstRow[hashes[i]] = numbers[i]
+			unique = append(unique, hashes[i])
+		}
+	}
+
+	existing := map[string]int{}
+	if len(unique) > 0 {
+		var err error
+		if existing, err = repo.FindPromptHashes(ctx, unique); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var keep []int
+	var skipped []importNote
+	for i, hash := range hashes {
+		switch {
+		case existing[hash] != 0:
+			skipped = append(skipped, importNote{numbers[i], fmt.Sprintf("duplicate of image %d", existing[hash])})
+		case firstRow[hash] != numbers[i]:
+			skipped = append(skipped, importNote{numbers[i], fmt.Sprintf("duplicate of row %d", firstRow[hash])})
+		default:
+			keep = append(keep, i)
+		}
+	}
+	return keep, skipped, nil
+}
+
+// writeImportSummary prints the skipped and invalid rows followed by the counts
+func writeImportSummary(w io.Writer, inserted int, skipped, invalid []importNote, dryRun bool) {
+	for _, note := range skipped {
+		fmt.Fprintf(w, "row %d: skipped, %s\n", note.number, note.reason)
+	}
+	for _, note := range invalid {
+		fmt.Fprintf(w, "row %d: invalid, %s\n", note.number, note.reason)
+	}
+	verb := "Inserted"
+	if dryRun {
+		verb = "Would insert"
+	}
+	fmt.Fprintf(w, "%s: %d, skipped: %d, invalid: %d\n", verb, inserted, len(skipped), len(invalid))
+}
+
+// image validates the row like POST /images does and returns the image it queues
+func (l importLine) image(cfg *config.Config) (*domain.Image, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	row := l.row
+	img := &domain.Image{
+		Prompt:         strings.TrimSpace(row.Prompt),
+		Style:          strings.TrimSpace(row.Style),
+		NegativePrompt: strings.TrimSpace(row.NegativePrompt),
+		Priority:       row.Priority,
+	}
+
+	if (row.Width == 0) != (row.Height == 0) {
+		return nil, errors.New("width and height must be given together")
+	}
+	if row.Width != 0 && cfg.SnapDimensions {
+		row.Width, row.Height = domain.SnapDimension(row.Width), domain.SnapDimension(row.Height)
+	}
+	img.Width, img.Height = row.Width, row.Height
+
+	req := domain.ImageGenerationRequest{
+		Prompt:    img.Prompt,
+		Width:     cfg.DefaultImageWidth,
+		Height:    cfg.DefaultImageHeight,
+		NumImages: cfg.DefaultNumImages,
+		Style:     cfg.DefaultStyle,
+	}
+	if img.Width != 0 {
+		req.Width, req.Height = img.Width, img.Height
+	}
+	if img.Style != "" {
+		req.Style = img.Style
+	}
+	if err := req.Validate(domain.WithMaxPromptLength(cfg.MaxPromptLength)); err != nil {
+		// Validate joins every violation with newlines, which would break up the summary
+		return nil, errors.New(strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+
+	for _, tag := range row.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, errors.New("tags must not be empty")
+		}
+		img.Tags = append(img.Tags, tag)
+	}
+
+	if row.ScheduledAt != "" {
+		t, err := parseImportTime(strings.TrimSpace(row.ScheduledAt))
+		if err != nil {
+			return nil, err
+		}
+		img.ScheduledAt = t
+	}
+	return img, nil
+}
+
+// parseImportTime parses scheduled_at in one of importTimeLayouts
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("scheduled_at %q is not a time such as 2006-01-02 15:04 or 2006-01-02T15:04:05Z", value)
+}
+
+// skipBOM drops the UTF-8 byte order mark spreadsheet programs put at the start of exports
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	return br
+}
+
+// readImportCSV reads the rows of a CSV file with a header row naming its columns. Rows with
+// every field empty, as spreadsheets export after the data, are ignored.
+func readImportCSV(r io.Reader) ([]importLine, error) {
+	cr := csv.NewReader(skipBOM(r))
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["prompt"]; !ok {
+		return nil, errors.New("the prompt column is missing")
+	}
+
+	var lines []importLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		number, _ := cr.FieldPos(0)
+		line := importLine{number: number}
+		if len(record) != len(header) {
+			line.err = fmt.Errorf("has %d fields, the header has %d", len(record), len(header))
+		} else {
+			line.row, line.err = csvRow(record, columns)
+		}
+		lines = append(lines, line)
+	}
+}
+
+// csvRow converts a CSV record to a row. Tags are separated by commas or semicolons.
+func csvRow(record []string, columns map[string]int) (importRow, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	row := importRow{
+		Prompt:         field("prompt"),
+		Style:          field("style"),
+		NegativePrompt: field("negative_prompt"),
+		ScheduledAt:    field("scheduled_at"),
+	}
+	for _, number := range []struct {
+		name  string
+		value *int
+	}{{"width", &row.Width}, {"height", &row.Height}, {"priority", &row.Priority}} {
+		if v := field(number.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return row, fmt.Errorf("%s %q is not a whole number", number.name, v)
+			}
+			*number.value = n
+		}
+	}
+	for _, tag := range strings.FieldsFunc(field("tags"), func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			row.Tags = append(row.Tags, tag)
+		}
+	}
+	return row, nil
+}
+
+// readImportJSON reads the rows of a JSON file holding an array of objects. An object that does
+// not match importRow makes its row invalid, while malformed JSON fails the whole file.
+func readImportJSON(r io.Reader) ([]importLine, error) {
+	dec := json.NewDecoder(skipBOM(r))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("the file must hold a JSON array of rows")
+	}
+
+	var lines []importLine
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("row %d: %w", len(lines)+1, err)
+		}
+		line := importLine{number: len(lines) + 1}
+		rowDec := json.NewDecoder(bytes.NewReader(raw))
+		rowDec.DisallowUnknownFields()
+		if err := rowDec.Decode(&line.row); err != nil {
+			line.err = err
+		}
+		lines = append(lines, line)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
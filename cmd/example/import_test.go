@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// importConfig returns the settings an import validates rows against
+func importConfig() *config.Config {
+	return &config.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		DefaultStyle:       "DEFAULT",
+		MaxPromptLength:    100,
+	}
+}
+
+func TestReadImportCSV(t *testing.T) {
+	input := "\xef\xbb\xbfPrompt,style,width,height,priority,tags,scheduled_at\n" +
+		"a lighthouse,ANIME,512,768,5,\"coast; night\",2024-05-01 12:00\n" +
+		",,,,,,\n" +
+		"\"a harbour, at dawn\",,,,,,\n"
+	lines, err := readImportCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readImportCSV() error = %v", err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("readImportCSV() read %d rows, want 2 with the empty row ignored", len(lines))
+	}
+
+	// The byte order mark does not end up in the first column name
+	first := lines[0]
+	want := importRow{Prompt: "a lighthouse", Style: "ANIME", Width: 512, Height: 768, Priority: 5, Tags: []string{"coast", "night"}, ScheduledAt: "2024-05-01 12:00"}
+	if first.err != nil || first.number != 2 || fmt.Sprint(first.row) != fmt.Sprint(want) {
+		t.Errorf("first row = %d %+v, %v, want row 2 %+v", first.number, first.row, first.err, want)
+	}
+	if second := lines[1]; second.err != nil || second.number != 4 || second.row.Prompt != "a harbour, at dawn" {
+		t.Errorf("second row = %d %+v, %v, want row 4 with the quoted prompt", second.number, second.row, second.err)
+	}
+}
+
+func TestReadImportCSVErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "empty"},
+		{"only a BOM", "\xef\xbb\xbf", "empty"},
+		{"unknown column", "prompt,colour\n", `unknown column "colour"`},
+		{"repeated column", "prompt,style,Style\n", `column "style" appears twice`},
+		{"no prompt column", "style,width\nANIME,512\n", "prompt column is missing"},
+		{"bare quote", "prompt\na \"lighthouse\" at night\n", "bare \""},
+		{"unterminated quote", "prompt\n\"a lighthouse\n", "extraneous or missing \""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readImportCSV(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readImportCSV() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadImportJSON(t *testing.T) {
+	input := "\xef\xbb\xbf[\n" +
+		`{"prompt": "a lighthouse", "width": 512, "height": 768, "tags": ["coast"]},` + "\n" +
+		`{"prompt": "a harbour", "colour": "blue"},` + "\n" +
+		`{"prompt": "a forest", "width": "wide"}` + "\n]"
+	lines, err := readImportJSON(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readImportJSON() error = %v", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("readImportJSON() read %d rows, want 3", len(lines))
+	}
+	if l := lines[0]; l.err != nil || l.number != 1 || l.row.Prompt != "a lighthouse" || l.row.Width != 512 || len(l.row.Tags) != 1 {
+		t.Errorf("first row = %d %+v, %v, want the lighthouse", l.number, l.row, l.err)
+	}
+	// Objects that do not match a row make only their row invalid
+	if l := lines[1]; l.number != 2 || l.err == nil || !strings.Contains(l.err.Error(), "colour") {
+		t.Errorf("row with an unknown key error = %v, want it to name the key", l.err)
+	}
+	if l := lines[2]; l.number != 3 || l.err == nil {
+		t.Errorf("row with a text width error = %v, want an error", l.err)
+	}
+
+	for _, input := range []string{"", `{"prompt": "a lighthouse"}`, `[{"prompt": "a lighthouse"},`, `[{"prompt": "a lighthouse"} {"prompt": "a harbour"}]`} {
+		if _, err := readImportJSON(strings.NewReader(input)); err == nil {
+			t.Errorf("readImportJSON(%q) error = nil, want the file rejected", input)
+		}
+	}
+}
+
+func TestImportImagesMalformedRows(t *testing.T) {
+	input := "prompt,style,width,height,priority,scheduled_at\n" +
+		"a lighthouse,,,,,\n" +
+		"too,many,fields,,,,,\n" +
+		"a harbour,,wide,768,,\n" +
+		"a forest,,512,,,\n" +
+		"a desert,,500,500,,\n" +
+		"a river,WATERCOLOUR,,,,\n" +
+		",ANIME,,,,\n" +
+		"a meadow,,,,,next week\n" +
+		strings.Repeat("x", 101) + ",,,,,\n" +
+		"a mountain,,,,high,\n" +
+		"a city,ANIME,512,768,3,2024-05-01T12:00:00Z\n"
+	lines, err := readImportCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readImportCSV() error = %v", err)
+	}
+	repo := repository.NewMemoryImageRepository()
+	var stdout bytes.Buffer
+
+	ids, err := importImages(context.Background(), repo, &stdout, importConfig(), lines, false)
+	if err == nil || err.Error() != "9 invalid rows" {
+		t.Errorf("importImages() error = %v, want 9 invalid rows", err)
+	}
+
+	// The valid rows are inserted even though others are invalid
+	if len(ids) != 2 {
+		t.Fatalf("importImages() inserted %v, want the 2 valid rows", ids)
+	}
+	city, _ := repo.GetImage(context.Background(), ids[1])
+	if city.Prompt != "a city" || city.Style != "ANIME" || city.Width != 512 || city.Height != 768 || city.Priority != 3 ||
+		!city.ScheduledAt.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || city.Status != domain.StatusReadyToGenerate {
+		t.Errorf("imported image = %+v, want the fields of its row", city)
+	}
+
+	// Every invalid row is reported with its line and reason
+	for _, want := range []string{
+		"row 3: invalid, has 8 fields, the header has 6",
+		`row 4: invalid, width "wide" is not a whole number`,
+		"row 5: invalid, width and height must be given together",
+		"row 6: invalid, invalid image dimensions: width 500 must be a multiple of 64",
+		`row 7: invalid, invalid generation request: unknown style "WATERCOLOUR"`,
+		"row 8: invalid, invalid prompt: prompt is required",
+		`row 9: invalid, scheduled_at "next week" is not a time`,
+		"row 10: invalid, invalid prompt: prompt has 101 characters, at most 100 are allowed",
+		`row 11: invalid, priority "high" is not a whole number`,
+		"Inserted: 2, skipped: 0, invalid: 9\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, stdout.String())
+		}
+	}
+	// Several violations of a row stay on its line
+	if !strings.Contains(stdout.String(), "must be a multiple of 64; invalid image dimensions: height 500") {
+		t.Errorf("summary splits the violations of row 6 over lines:\n%s", stdout.String())
+	}
+}
+
+func TestImportImagesSkipsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	cfg := importConfig()
+	repo := repository.NewMemoryImageRepository(repository.WithMemoryDefaultSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight))
+	// Stating the default size makes no difference to the prompt hash
+	existing, err := repo.CreateImage(ctx, "a lighthouse", repository.WithSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight))
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	lines, err := readImportCSV(strings.NewReader("prompt\na lighthouse\na harbour\n  a harbour \na forest\n"))
+	if err != nil {
+		t.Fatalf("readImportCSV() error = %v", err)
+	}
+
+	// A dry run reports what would be inserted and inserts nothing
+	var stdout bytes.Buffer
+	ids, err := importImages(ctx, repo, &stdout, cfg, lines, true)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("importImages() dry run = %v, %v, want nothing inserted", ids, err)
+	}
+	want := fmt.Sprintf("row 3: would insert \"a harbour\"\nrow 5: would insert \"a forest\"\n"+
+		"row 2: skipped, duplicate of image %d\nrow 4: skipped, duplicate of row 3\n"+
+		"Would insert: 2, skipped: 2, invalid: 0\n", existing)
+	if stdout.String() != want {
+		t.Errorf("dry run printed:\n%s\nwant:\n%s", stdout.String(), want)
+	}
+	if images, _ := repo.ListImages(ctx, repository.ListFilter{}); len(images) != 1 {
+		t.Errorf("dry run left %d images, want only the existing one", len(images))
+	}
+
+	stdout.Reset()
+	ids, err = importImages(ctx, repo, &stdout, cfg, lines, false)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("importImages() = %v, %v, want the 2 new prompts inserted", ids, err)
+	}
+	if !strings.HasSuffix(stdout.String(), "Inserted: 2, skipped: 2, invalid: 0\n") {
+		t.Errorf("summary = %q, want 2 inserted and 2 skipped", stdout.String())
+	}
+}
+
+func TestImportImagesLargeFile(t *testing.T) {
+	const rows = 10000
+	var input strings.Builder
+	input.WriteString("\xef\xbb\xbfprompt,style,priority,tags\n")
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&input, "prompt number %d,ANIME,%d,batch;row-%d\n", i, i%10, i)
+	}
+
+	for _, format := range []string{"csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			data := input.String()
+			read := readImportCSV
+			if format == "json" {
+				var objects []string
+				for i := 1; i <= rows; i++ {
+					objects = append(objects, fmt.Sprintf(`{"prompt":"prompt number %d","style":"ANIME","priority":%d,"tags":["batch","row-%d"]}`, i, i%10, i))
+				}
+				data = "\xef\xbb\xbf[" + strings.Join(objects, ",\n") + "]"
+				read = readImportJSON
+			}
+			lines, err := read(strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("reading %d rows error = %v", rows, err)
+			}
+			if len(lines) != rows {
+				t.Fatalf("read %d rows, want %d", len(lines), rows)
+			}
+
+			repo := repository.NewMemoryImageRepository()
+			var stdout bytes.Buffer
+			ids, err := importImages(context.Background(), repo, &stdout, importConfig(), lines, false)
+			if err != nil {
+				t.Fatalf("importImages() error = %v", err)
+			}
+			if len(ids) != rows {
+				t.Fatalf("importImages() inserted %d images, want %d", len(ids), rows)
+			}
+			if stdout.String() != fmt.Sprintf("Inserted: %d, skipped: 0, invalid: 0\n", rows) {
+				t.Errorf("summary = %q", stdout.String())
+			}
+			last, _ := repo.GetImage(context.Background(), ids[rows-1])
+			if last.Prompt != fmt.Sprintf("prompt number %d", rows) || last.Style != "ANIME" || len(last.Tags) != 2 || last.Tags[1] != fmt.Sprintf("row-%d", rows) {
+				t.Errorf("last imported image = %+v, want the last row", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/errreport"
+	"github.com/basel-ax/2xiang/internal/logging"
+)
+
+// Loggers of the components of this command; configureLogging replaces them once the
+// configuration is loaded
+var (
+	appLog      = slog.Default()
+	workflowLog = slog.Default()
+	httpLog     = slog.Default()
+)
+
+// configureLogging builds the component loggers from LOG_FORMAT, LOG_LEVEL and LOG_LEVELS.
+// -verbose lowers the default level to debug; per-component levels still apply.
+func configureLogging(cfg *config.Config, verbose bool) *logging.Loggers {
+	level := cfg.LogLevel
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	loggers := logging.New(os.Stderr, logging.Options{
+		Format:  cfg.LogFormat,
+		Level:   level,
+		Levels:  cfg.LogLevels,
+		Secrets: cfg.Secrets(),
+	})
+	appLog = loggers.Logger("app")
+	workflowLog = loggers.Logger("workflow")
+	httpLog = loggers.Logger("http")
+	slog.SetDefault(appLog)
+
+	return loggers
+}
+
+// errorFlushTimeout bounds the wait for the error reports still queued on exit
+const errorFlushTimeout = 2 * time.Second
+
+// errorReporter is sent the errors of the workflows and of fatal once SENTRY_DSN configures it
+var errorReporter *errreport.Sentry
+
+// fatal logs msg with its attributes at error level, reports it to Sentry when configured and
+// exits with status 1
+func fatal(msg string, args ...any) {
+	appLog.Error(msg, args...)
+	if errorReporter != nil {
+		errorReporter.CaptureError(context.Background(), fatalError(msg, args), nil)
+		errorReporter.Flush(errorFlushTimeout)
+	}
+	os.Exit(1)
+}
+
+// fatalError builds the error fatal reports from msg and its "error" attribute, if any
+func fatalError(msg string, args []any) error {
+	for i := 0; i+1 < len(args); i += 2 {
+		if key, ok := args[i].(string); ok && key == "error" {
+			if err, ok := args[i+1].(error); ok {
+				return fmt.Errorf("%s: %w", msg, err)
+			}
+		}
+	}
+	return errors.New(msg)
+}
@@ -3,352 +3,639 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
-	"unicode/utf8"
 
+	"github.com/basel-ax/2xiang/internal/alert"
+	"github.com/basel-ax/2xiang/internal/api"
 	"github.com/basel-ax/2xiang/internal/config"
-	"github.com/basel-ax/2xiang/internal/domain"
-	"github.com/basel-ax/2xiang/internal/repository"
-	"github.com/basel-ax/2xiang/internal/service"
+	"github.com/basel-ax/2xiang/internal/errreport"
+	"github.com/basel-ax/2xiang/internal/health"
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/internal/publish"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/internal/stats"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/internal/webhook"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robfig/cron/v3"
 )
 
-const (
-	maxPromptLength = 999
-)
+// command is a subcommand of the binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// exitCode is returned by a command that has reported its failure already and only sets the
+// exit status, which main applies once the deferred cleanup of the command has run
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
 
-// truncatePrompt safely truncates a string to the specified length while preserving UTF-8 characters
-func truncatePrompt(s string, length int) string {
-	if utf8.RuneCountInString(s) <= length {
-		return s
+// commands lists the subcommands; run is assumed when the first argument is a flag, which
+// keeps invocations from before the subcommands working
+var commands = []command{
+	{"run", "Run the image workflows, the HTTP API or both", runCommand},
+	{"add", "Queue a prompt for generation and optionally wait for the image", addCommand},
+	{"import", "Queue the prompts of a CSV or JSON file, skipping duplicates", importCommand},
+	{"status", "Print the number of images per status and the latest failures", statusCommand},
+	{"pause", "Pause workflows in every process using the database", pauseCommand},
+	{"resume", "Resume paused workflows", resumeCommand},
+	{"config", "Validate the configuration and its connections, or print it with secrets masked", configCommand},
+	{"migrate", "Apply the database schema", migrateCommand},
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	var size, n int
-	for i := 0; i < length && n < len(s); i++ {
-		_, size = utf8.DecodeRuneInString(s[n:])
-		n += size
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err := cmd.run(args)
+			var code exitCode
+			if errors.As(err, &code) {
+				os.Exit(int(code))
+			}
+			if err != nil {
+				fatal(fmt.Sprintf("%s failed", name), "error", err)
+			}
+			return
+		}
 	}
 
-	return s[:n]
+	if name != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	}
+	usage()
+	if name != "help" {
+		os.Exit(2)
+	}
 }
 
-func main() {
-	// Parse command line flags
-	verbose := flag.Bool("verbose", false, "Enable verbose logging")
-	runGenerator := flag.Bool("generator", false, "Run image generation workflow")
-	runProcessor := flag.Bool("processor", false, "Run image processing workflow")
-	runCron := flag.Bool("cron", false, "Run workflows on schedule (generator every 5min, processor every 10min)")
-	flag.Parse()
+// usage prints the subcommands to stderr
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
 
-	// Configure logging
-	if *verbose {
-		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-		log.Println("Verbose logging enabled")
-	} else {
-		log.SetFlags(log.Ldate | log.Ltime)
+// configOptions returns the options loading the configuration file given by the -config flag
+func configOptions(path string) []config.LoadOption {
+	if path == "" {
+		return nil
+	}
+	return []config.LoadOption{config.WithFile(path)}
+}
+
+// openDatabase opens the configured database with its connection pool settings
+func openDatabase(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.GetDSN())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	return db, nil
+}
+
+// runCommand runs the selected workflows until a signal arrives, or a single pass of them with
+// -once, returning exitCode 1 when the pass or the self-test fails. Startup errors end the
+// process right away.
+func runCommand(args []string) error {
+	// Parse command line flags
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	runGenerator := flags.Bool("generator", false, "Run image generation workflow")
+	runProcessor := flags.Bool("processor", false, "Run image processing workflow")
+	runPublisher := flags.Bool("publisher", false, "Run image publishing workflow")
+	httpAddr := flags.String("http-addr", "", "Serve /healthz, /readyz and /metrics on this address, e.g. :8080 (overrides HTTP_ADDR)")
+	runOnce := flags.Bool("once", false, "Run each selected workflow a single pass and exit, with status 1 if any image hit an error")
+	runCron := flags.Bool("cron", false, "Run workflows on schedule (CRON_GENERATOR_SPEC, CRON_PROCESSOR_SPEC and CRON_PUBLISHER_SPEC)")
+	serveAPI := flags.Bool("serve", false, "Serve the image API on /images next to the health endpoints (requires HTTP_ADDR and API_TOKEN)")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	dryRun := flags.Bool("dry-run", false, "Log the requests the workflows would send instead of calling providers, publishing or writing to the database")
+	selfTest := flags.Bool("selftest", false, "Generate a few canned prompts against an in-process fake provider and in-memory storage, print a summary and exit, with status 1 on any failure")
+	flags.Parse(args)
+
+	// The self-test needs neither the database nor provider credentials
+	if *selfTest {
+		cfg, err := config.LoadWithoutProviders(append(configOptions(*configFile), config.WithoutDatabase())...)
+		if err != nil {
+			fatal("Failed to load configuration", "error", err)
+		}
+		configureLogging(cfg, *verbose)
+		if !runSelfTest(context.Background(), cfg, os.Stdout) {
+			return exitCode(1)
+		}
+		return nil
 	}
 
 	// Check if at least one workflow is selected
-	if !*runGenerator && !*runProcessor && !*runCron {
-		log.Fatal("Please specify at least one workflow to run: -generator, -processor, or -cron")
+	if !*runGenerator && !*runProcessor && !*runPublisher && !*runCron && !*serveAPI {
+		fatal("Please specify at least one workflow to run: -generator, -processor, -publisher, -cron, or -serve")
+	}
+	if *runOnce && *runCron {
+		fatal("-once runs the selected workflows a single time and cannot be combined with -cron")
+	}
+	if *runOnce && *serveAPI {
+		fatal("-once exits after a single pass and cannot be combined with -serve")
+	}
+	if *dryRun && *serveAPI {
+		fatal("-dry-run does not write to the database and cannot be combined with -serve")
 	}
 
 	// Load configuration
-	log.Println("Loading configuration...")
-	cfg, err := config.Load()
+	appLog.Info("Loading configuration")
+	// A dry run never calls the providers, so it needs no credentials
+	load := config.Load
+	if *dryRun {
+		load = config.LoadWithoutProviders
+	}
+	cfg, err := load(configOptions(*configFile)...)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", "error", err)
+	}
+
+	// Configure logging
+	loggers := configureLogging(cfg, *verbose)
+	appLog.Info("Configuration loaded successfully")
+	appLog.Debug("Verbose logging enabled", "verbose", *verbose)
+	if *httpAddr != "" {
+		cfg.HTTPAddr = *httpAddr
+	}
+	if *dryRun && cfg.HTTPAddr != "" {
+		appLog.Info("HTTP server disabled in dry run", "addr", cfg.HTTPAddr)
+		cfg.HTTPAddr = ""
+	}
+	if *serveAPI && (cfg.HTTPAddr == "" || cfg.APIToken == "") {
+		fatal("-serve requires HTTP_ADDR (or -http-addr) and API_TOKEN")
+	}
+	if cfg.PprofEnabled && cfg.HTTPAddr != "" && cfg.APIToken == "" {
+		fatal("PPROF_ENABLED requires API_TOKEN, which guards the profiling endpoints")
+	}
+
+	// Panics, permanently failed images and fatal errors are sent to Sentry when SENTRY_DSN is set
+	if cfg.SentryDSN != "" {
+		reporter, err := errreport.NewSentry(errreport.Config{
+			DSN:         cfg.SentryDSN,
+			Environment: cfg.SentryEnvironment,
+			Secrets:     cfg.Secrets(),
+		})
+		if err != nil {
+			fatal("Failed to initialize error reporting", "error", err)
+		}
+		errorReporter = reporter
+		defer reporter.Flush(errorFlushTimeout)
+		appLog.Info("Reporting errors to Sentry")
+	}
+
+	// Spans are exported when the standard OTEL_EXPORTER_OTLP_* variables name an endpoint
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		fatal("Failed to initialize tracing", "error", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			appLog.Warn("Error flushing trace spans", "error", err)
+		}
+	}()
+	if tracing.Enabled() {
+		appLog.Info("Exporting trace spans over OTLP")
+	}
+
+	// The Redis queue mode only runs the generator, without the database
+	if cfg.Queue.Backend == "redis" {
+		if !*runGenerator || *runProcessor || *runPublisher || *runCron || *serveAPI || *dryRun {
+			fatal("QUEUE=redis only runs the generator: use -generator, optionally with -once")
+		}
+		return runQueueGenerator(cfg, loggers, *runOnce)
 	}
-	log.Println("Configuration loaded successfully")
 
 	// Initialize database connection
-	log.Println("Initializing database connection...")
-	db, err := sql.Open("postgres", cfg.GetDSN())
+	appLog.Info("Initializing database connection")
+	db, err := openDatabase(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", "error", err)
 	}
 	defer db.Close()
-
-	// Configure connection pool
-	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
-	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
-	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
-	log.Println("Database connection established")
+	if err := waitForDatabase(context.Background(), db, cfg.DB.ConnectRetry); err != nil {
+		fatal("Failed to connect to database", "error", err)
+	}
+	appLog.Info("Database connection established")
 
 	// Initialize repository and service
-	imgRepo := repository.NewPostgresImageRepository(db)
-	log.Println("Initializing image generation service...")
-	imgService := service.NewImageGenerationService(cfg)
-	log.Println("Image generation service initialized")
+	repoOpts := []repository.PostgresOption{repository.WithDefaultSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight)}
+	if cfg.DB.ListenNotify {
+		repoOpts = append(repoOpts, repository.WithNotifications(cfg.GetDSN()))
+	}
+	var imgRepo repository.ImageRepository = repository.NewPostgresImageRepository(db, repoOpts...)
+	if *dryRun {
+		appLog.Info("Dry run: no requests are sent and nothing is written to the database")
+		imgRepo = repository.NewReadOnly(imgRepo, workflowLog)
+	}
+	if tracing.Enabled() {
+		imgRepo = repository.NewTraced(imgRepo)
+	}
+	appLog.Info("Initializing image generation service")
+	clk := clock.Real()
+	serviceOpts := []service.Option{service.WithClock(clk)}
+	if processors := wiring.PromptProcessors(cfg); len(processors) > 0 {
+		serviceOpts = append(serviceOpts, service.WithPromptProcessors(processors...))
+	}
+	if !*dryRun {
+		serviceOpts = append(serviceOpts, service.WithUsageRecorder(imgRepo))
+	}
+	var cache domain.ResultCache
+	if cfg.CacheEnabled && !*dryRun {
+		cache = service.NewMemoryCache(service.WithCacheClock(clk), service.WithMaxEntries(cfg.CacheMaxEntries))
+		if cfg.CacheBackend == "postgres" {
+			cache = repository.NewPostgresResultCache(db)
+		}
+		serviceOpts = append(serviceOpts, service.WithCache(cache, cfg.CacheTTL))
+		appLog.Info("Result cache enabled", "backend", cfg.CacheBackend, "ttl", cfg.CacheTTL, "max_entries", cfg.CacheMaxEntries)
+	}
+	// Metrics are exported on the HTTP server, so they are only collected when it is enabled
+	var hook metrics.Hook = metrics.Nop{}
+	registry := prometheus.NewRegistry()
+	if cfg.HTTPAddr != "" {
+		prom, err := newMetrics(registry, imgRepo, db)
+		if err != nil {
+			fatal("Failed to initialize metrics", "error", err)
+		}
+		hook = prom
+	}
+	var svc *service.ImageGenerationService
+	if *dryRun {
+		serviceOpts = append(serviceOpts, service.WithMetrics(hook))
+		svc = service.NewImageGenerationService(service.NewDryRunProvider(loggers.Logger("client")), wiring.ServiceConfig(cfg), serviceOpts...)
+		appLog.Info("Image generation service initialized", "providers", []string{service.DryRunProviderName})
+	} else {
+		svc, err = wiring.NewService(cfg, hook, loggers.Logger("client"), serviceOpts...)
+		if err != nil {
+			fatal("Failed to initialize image generation service", "error", err)
+		}
+		appLog.Info("Image generation service initialized", "providers", cfg.Providers)
+	}
+	var imgService domain.ImageGenerationService = svc
 
-	// Create context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
+	// Cancelling ctx stops the workflows from picking up more images, while images in flight
+	// keep going until kill is cancelled SHUTDOWN_TIMEOUT later
+	killCtx, kill := context.WithCancel(context.Background())
+	defer kill()
+	ctx, cancel := context.WithCancel(killCtx)
 	defer cancel()
+	ctx = workflows.WithHardKill(ctx, killCtx)
+
+	store, err := storage.NewFromConfig(ctx, cfg)
+	if err != nil {
+		fatal("Failed to initialize storage", "error", err)
+	}
+	if store != nil {
+		appLog.Info("Storing generated images outside the database", "storage", cfg.Storage)
+	}
+	pipeline, err := imageproc.NewFromConfig(cfg)
+	if err != nil {
+		fatal("Failed to initialize post-processing", "error", err)
+	}
+	watermark, err := imageproc.WatermarkFromConfig(cfg)
+	if err != nil {
+		fatal("Failed to load watermark", "error", err)
+	}
+	writer := results.NewWriter(imgRepo, wiring.ResultsConfig(cfg),
+		results.WithStorage(store),
+		results.WithPipeline(pipeline),
+		results.WithWatermark(watermark),
+		results.WithLogger(workflowLog),
+	)
+	// Webhooks, events and alerts are sent as the workflows report outcomes and finish passes
+	var hooks workflows.Hooks
+	if !*dryRun {
+		webhooks := &webhookSender{repo: imgRepo, notifier: webhook.NewNotifier(cfg.WebhookSecret, cfg.WebhookMaxAttempts)}
+		hooks.OnOutcome = webhooks.onOutcome
+		if cfg.Alerts.WebhookURL != "" {
+			hooks.AfterPass = newAlerter(imgRepo, alert.NewSlack(cfg.Alerts.WebhookURL), &cfg.Alerts, clk).afterPass
+		}
+
+		eventPublisher, err := wiring.NewEventPublisher(cfg)
+		if err != nil {
+			fatal("Failed to initialize event publishing", "error", err)
+		}
+		if eventPublisher != nil {
+			appLog.Info("Publishing image events to NATS", "subject", eventPublisher.Subject("image.*"))
+			defer eventPublisher.Close()
+			events := &eventSender{publisher: eventPublisher}
+			notifyWebhooks := hooks.OnOutcome
+			hooks.OnOutcome = func(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string) {
+				notifyWebhooks(ctx, img, status, errorDescription)
+				events.onOutcome(ctx, img, status, errorDescription)
+			}
+		}
+	}
+
+	var publisher domain.Publisher
+	if *dryRun {
+		publisher = publish.NewDryRun(workflowLog)
+	} else if publisher, err = publish.NewFromConfig(ctx, cfg); err != nil {
+		fatal("Failed to initialize publishers", "error", err)
+	}
+	if multi, ok := publisher.(*publish.Multi); ok {
+		multi.OnPartialFailure = func(img *domain.Image, name string, err error) {
+			workflowLog.Warn("Error publishing image", "workflow", "publisher", "image_id", img.ID, "publisher", name, "error", err)
+		}
+	}
+	if publisher == nil && *runPublisher {
+		fatal("The publisher workflow requires TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID, or PUBLISH_S3_BUCKET")
+	}
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal: %v, initiating shutdown...", sig)
-		cancel()
-	}()
+	go handleShutdown(sigChan, cfg.Workflow.ShutdownTimeout, cancel, kill)
+
+	healthState := health.NewState()
+	workflowCfg := wiring.WorkflowConfig(cfg)
+	workflowOpts := []workflows.Option{
+		workflows.WithLogger(workflowLog),
+		workflows.WithClock(clk),
+		workflows.WithMetrics(hook),
+		workflows.WithHealth(healthState),
+		workflows.WithHooks(hooks),
+		workflows.WithResults(writer),
+	}
+	if errorReporter != nil {
+		workflowOpts = append(workflowOpts, workflows.WithErrorReporter(errorReporter))
+	}
+	generator := workflows.NewGenerator(imgRepo, imgService, workflowCfg, workflowOpts...)
+	processor := workflows.NewProcessor(imgRepo, imgService, workflowCfg, workflowOpts...)
+	requeuer := workflows.NewRequeuer(imgRepo, workflowCfg, workflowOpts...)
+	pruner := workflows.NewPruner(imgRepo, workflowCfg, workflowOpts...)
+	var publisherWorkflow *workflows.Publisher
+	if publisher != nil {
+		publisherWorkflow = workflows.NewPublisher(imgRepo, publisher, workflowCfg, workflowOpts...)
+	}
+
+	if cfg.HTTPAddr != "" {
+		checks := healthChecks(db, svc.Provider())
+		var images http.Handler
+		if *serveAPI {
+			var opts []api.Option
+			if cfg.SnapDimensions {
+				opts = append(opts, api.WithSnapDimensions())
+			}
+			opts = append(opts,
+				api.WithLogger(httpLog),
+				api.WithRequestDefaults(domain.ImageGenerationRequest{
+					Width:     cfg.DefaultImageWidth,
+					Height:    cfg.DefaultImageHeight,
+					NumImages: cfg.DefaultNumImages,
+					Style:     cfg.DefaultStyle,
+				}),
+				api.WithMaxPromptLength(cfg.MaxPromptLength),
+			)
+			images = api.NewHandler(imgRepo, writer.Load, cfg.APIToken, opts...)
+		}
+		var profiles http.Handler
+		if cfg.PprofEnabled {
+			setProfileRates(cfg)
+			profiles = pprofHandler(cfg.APIToken)
+			appLog.Info("Serving profiling endpoints", "path", pprofPrefix)
+		}
+		go serveHTTP(ctx, cfg.HTTPAddr, health.NewHandler(healthState, checks...), registry, images, profiles)
+	}
+
+	// Run a single pass of the selected workflows in pipeline order
+	if *runOnce {
+		var passes []workflowPass
+		if *runGenerator {
+			passes = append(passes, workflowPass{"Generator", generator.RunOnce})
+		}
+		if *runProcessor {
+			passes = append(passes, workflowPass{"Processor", processor.RunOnce})
+		}
+		if *runPublisher {
+			passes = append(passes, workflowPass{"Publisher", publisherWorkflow.RunOnce})
+		}
+		ok := runPasses(ctx, os.Stdout, passes)
+		webhookDeliveries.Wait()
+
+		// Returning lets the error reports, spans and events of the failed pass be flushed
+		if !ok {
+			return exitCode(1)
+		}
+		return nil
+	}
+
+	// Log the connection pool and runtime stats, telling a pipeline starved for connections
+	// from one waiting on its provider
+	if cfg.StatsInterval > 0 {
+		go stats.NewReporter(db, cfg.StatsInterval, stats.WithLogger(loggers.Logger("stats"))).Run(ctx)
+	}
+
+	// Evict cached results that are never read again once per lifetime
+	if cache != nil && cfg.CacheTTL > 0 {
+		go service.RunCacheEviction(ctx, cache, clk, cfg.CacheTTL)
+	}
 
 	// Start selected workflows
+	var running sync.WaitGroup
 	if *runCron {
-		log.Println("Starting scheduled workflows...")
-		startCronWorkflows(ctx, imgRepo, imgService, cfg)
+		appLog.Info("Starting scheduled workflows")
+		startCronWorkflows(ctx, imgRepo, generator, processor, publisherWorkflow, requeuer, pruner, cfg)
 	} else {
 		if *runGenerator {
-			log.Println("Starting image generation workflow...")
-			go generateImagesWorkflow(ctx, imgRepo, imgService, cfg)
+			appLog.Info("Starting image generation workflow")
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				generator.Run(ctx)
+			}()
 		}
 
 		if *runProcessor {
-			log.Println("Starting image processing workflow...")
-			go processGeneratedImagesWorkflow(ctx, imgRepo, imgService, cfg)
+			appLog.Info("Starting image processing workflow")
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				processor.Run(ctx)
+			}()
+		}
+
+		if *runPublisher {
+			appLog.Info("Starting image publishing workflow")
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				publisherWorkflow.Run(ctx)
+			}()
 		}
 	}
 
-	// Wait for context cancellation
+	// Wait for context cancellation, then for the images in flight
 	<-ctx.Done()
-	log.Println("Shutting down gracefully...")
+	appLog.Info("Shutting down gracefully")
+	if drain(killCtx, &running) {
+		appLog.Info("Images in flight finished")
+	}
+	return nil
 }
 
-func startCronWorkflows(ctx context.Context, repo repository.ImageRepository, service *service.ImageGenerationService, cfg *config.Config) {
-	// Create a new cron scheduler
-	c := cron.New(cron.WithSeconds())
-
-	var cronMutex sync.Mutex
-
-	// Add generator workflow to run every 5 minutes
-	_, err := c.AddFunc("0 */3 * * * *", func() {
-		log.Println("[CRON] Attempting to start scheduled generator workflow...")
-		cronMutex.Lock()
-		defer cronMutex.Unlock()
-		log.Println("[CRON] Running scheduled generator workflow...")
-		generateImagesWorkflow(ctx, repo, service, cfg)
-		log.Println("[CRON] Finished scheduled generator workflow.")
-	})
+// startCronWorkflows runs passes of the workflows on their schedules until ctx is cancelled.
+// The publisher is only scheduled when there is one.
+func startCronWorkflows(ctx context.Context, repo repository.ImageRepository, generator *workflows.Generator, processor *workflows.Processor, publisher *workflows.Publisher, requeuer *workflows.Requeuer, pruner *workflows.Pruner, cfg *config.Config) {
+	// Create a new cron scheduler; a panic outside of the handling of a single image ends the
+	// run instead of the process
+	logger := cronLogger{appLog}
+	c := cron.New(cron.WithSeconds(), cron.WithLogger(logger), cron.WithChain(cron.Recover(logger)))
+
+	// Add generator workflow on CRON_GENERATOR_SPEC
+	_, err := c.AddFunc(cfg.CronGeneratorSpec, cronJob(ctx, repo, "generator", generator.RunOnce))
 	if err != nil {
-		log.Printf("Error scheduling generator workflow: %v", err)
+		appLog.Error("Error scheduling generator workflow", "error", err)
 		return
 	}
 
-	// Add processor workflow to run every 10 minutes
-	_, err = c.AddFunc("0 */7 * * * *", func() {
-		log.Println("[CRON] Attempting to start scheduled processor workflow...")
-		cronMutex.Lock()
-		defer cronMutex.Unlock()
-		log.Println("[CRON] Running scheduled processor workflow...")
-		processGeneratedImagesWorkflow(ctx, repo, service, cfg)
-		log.Println("[CRON] Finished scheduled processor workflow.")
-	})
+	// Add processor workflow on CRON_PROCESSOR_SPEC
+	_, err = c.AddFunc(cfg.CronProcessorSpec, cronJob(ctx, repo, "processor", processor.RunOnce))
 	if err != nil {
-		log.Printf("Error scheduling processor workflow: %v", err)
+		appLog.Error("Error scheduling processor workflow", "error", err)
 		return
 	}
 
+	// Add publisher workflow on CRON_PUBLISHER_SPEC when a publisher is configured
+	if publisher != nil {
+		_, err = c.AddFunc(cfg.CronPublisherSpec, cronJob(ctx, repo, "publisher", publisher.RunOnce))
+		if err != nil {
+			appLog.Error("Error scheduling publisher workflow", "error", err)
+			return
+		}
+	}
+
+	// Requeue transiently failed images every REQUEUE_FAILED_INTERVAL when enabled
+	if cfg.RequeueFailedInterval > 0 {
+		_, err = c.AddFunc(fmt.Sprintf("@every %s", cfg.RequeueFailedInterval), cronJob(ctx, repo, "requeuer", requeuer.RunOnce))
+		if err != nil {
+			appLog.Error("Error scheduling requeue of failed images", "error", err)
+			return
+		}
+	}
+
+	// Prune the event log hourly unless it is kept forever
+	if cfg.EventRetention > 0 {
+		_, err = c.AddFunc("@hourly", cronJob(ctx, repo, "pruner", pruner.RunOnce))
+		if err != nil {
+			appLog.Error("Error scheduling pruning of image events", "error", err)
+			return
+		}
+	}
+
 	// Start the cron scheduler
 	c.Start()
-	log.Println("Cron scheduler started successfully")
+	appLog.Info("Cron scheduler started successfully", "generator_spec", cfg.CronGeneratorSpec, "processor_spec", cfg.CronProcessorSpec, "publisher_spec", cfg.CronPublisherSpec)
 
-	// Keep the scheduler running until context is cancelled
+	// Keep the scheduler running until context is cancelled, then let running jobs finish
 	<-ctx.Done()
-	c.Stop()
-	log.Println("Cron scheduler stopped")
+	select {
+	case <-c.Stop().Done():
+	case <-workflows.WorkContext(ctx).Done():
+	}
+	appLog.Info("Cron scheduler stopped")
 }
 
-func generateImagesWorkflow(ctx context.Context, repo repository.ImageRepository, service *service.ImageGenerationService, cfg *config.Config) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Image generation workflow stopped")
-			return
-		case <-ticker.C:
-			// Get all images ready for generation
-			images, err := repo.GetAllReadyToGenerate(ctx)
-			if err != nil {
-				log.Printf("Error getting ready images: %v", err)
-				continue
-			}
+// cronLogger adapts a slog logger to the scheduler, which logs recovered job panics through it
+type cronLogger struct {
+	logger *slog.Logger
+}
 
-			if len(images) == 0 {
-				continue
-			}
+// Info logs routine scheduler messages at debug level
+func (l cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug(msg, keysAndValues...)
+}
 
-			for _, img := range images {
-				// Truncate prompt if it exceeds the maximum length
-				originalPrompt := img.Prompt
-				img.Prompt = truncatePrompt(img.Prompt, maxPromptLength)
-				if len(originalPrompt) != len(img.Prompt) {
-					log.Printf("Prompt for image ID %d was truncated from %d to %d characters", img.ID, len(originalPrompt), len(img.Prompt))
-				}
-
-				log.Printf("Processing image ID %d with prompt: %s", img.ID, img.Prompt)
-
-				// Create image generation request
-				req := domain.ImageGenerationRequest{
-					Prompt:         img.Prompt,
-					Width:          cfg.DefaultImageWidth,
-					Height:         cfg.DefaultImageHeight,
-					NumImages:      cfg.DefaultNumImages,
-					Style:          cfg.DefaultStyle,
-					NegativePrompt: cfg.DefaultNegativePrompt,
-				}
-
-				// Generate image
-				resp, err := service.GenerateImage(ctx, req)
-				if err != nil {
-					// Check if this is an INITIAL status response
-					if strings.Contains(err.Error(), "status code: 201") && strings.Contains(err.Error(), "INITIAL") {
-						// Extract UUID from error message
-						uuidMatch := regexp.MustCompile(`"uuid":"([^"]+)"`).FindStringSubmatch(err.Error())
-						if len(uuidMatch) > 1 {
-							uuid := uuidMatch[1]
-							log.Printf("Image generation initiated for ID %d with UUID: %s", img.ID, uuid)
-
-							// Update image UUID
-							if err := repo.UpdateUUID(ctx, img.ID, uuid); err != nil {
-								log.Printf("Error updating UUID for image ID %d: %v", img.ID, err)
-								continue
-							}
-
-							// Update status to Generate
-							if err := repo.UpdateStatus(ctx, img.ID, "Generate"); err != nil {
-								log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-								continue
-							}
-
-							log.Printf("Successfully initiated generation for image ID %d with UUID: %s", img.ID, uuid)
-							continue
-						}
-					}
-
-					// If it's not an INITIAL status or we couldn't extract UUID, handle as error
-					log.Printf("Error generating image ID %d: %v", img.ID, err)
-					if err := repo.UpdateStatus(ctx, img.ID, "Failed"); err != nil {
-						log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-					}
-					continue
-				}
-
-				// Handle successful response with UUID
-				log.Printf("Image generation initiated for ID %d with UUID: %s", img.ID, resp.UUID)
-
-				// Update image UUID
-				if err := repo.UpdateUUID(ctx, img.ID, resp.UUID); err != nil {
-					log.Printf("Error updating UUID for image ID %d: %v", img.ID, err)
-					continue
-				}
-
-				// Update status to Generate
-				if err := repo.UpdateStatus(ctx, img.ID, "Generate"); err != nil {
-					log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-					continue
-				}
-
-				log.Printf("Successfully initiated generation for image ID %d with UUID: %s", img.ID, resp.UUID)
-			}
-		}
-	}
+// Error logs scheduler errors, including panics recovered from jobs
+func (l cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, append(keysAndValues, "error", err)...)
 }
 
-func processGeneratedImagesWorkflow(ctx context.Context, repo repository.ImageRepository, service *service.ImageGenerationService, cfg *config.Config) {
-	ticker := time.NewTicker(5 * time.Second) // Using fixed interval for now
-	defer ticker.Stop()
+// cronLockKeys are the Postgres advisory lock keys of the scheduled workflows. Every replica
+// uses the same keys, so each workflow runs on one of them at a time.
+var cronLockKeys = map[string]int64{
+	"generator": 2_833_001,
+	"processor": 2_833_002,
+	"publisher": 2_833_003,
+	"requeuer":  2_833_004,
+	"pruner":    2_833_005,
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Image processing workflow stopped")
+// cronJob returns a scheduled job running a single pass of the named workflow. A trigger that
+// fires while the previous pass of the same workflow is still running, here or on another
+// replica holding its advisory lock, is skipped rather than queued; passes of different
+// workflows run independently. Triggers of a paused workflow do nothing.
+func cronJob(ctx context.Context, repo repository.ImageRepository, name string, pass func(ctx context.Context) (workflows.Summary, error)) func() {
+	var running sync.Mutex
+	key := cronLockKeys[name]
+	return func() {
+		log := workflowLog.With("workflow", name, "trigger", "cron")
+		if !running.TryLock() {
+			log.Warn("Skipping scheduled workflow, the previous run is still in progress")
 			return
-		case <-ticker.C:
-			// Get all images ready for status check
-			images, err := repo.GetAllReadyToCheck(ctx)
-			if err != nil {
-				log.Printf("Error getting images ready for check: %v", err)
-				continue
-			}
+		}
+		defer running.Unlock()
 
-			if len(images) == 0 {
-				continue
+		// The lock is taken on every trigger, so another replica takes over when this one stops
+		locked, err := repo.TryAdvisoryLock(ctx, key)
+		if err != nil {
+			log.Error("Error acquiring workflow lock", "error", err)
+			return
+		}
+		if !locked {
+			log.Info("Skipping scheduled workflow, another instance is running it")
+			return
+		}
+		defer func() {
+			if err := repo.AdvisoryUnlock(workflows.WorkContext(ctx), key); err != nil {
+				log.Error("Error releasing workflow lock", "error", err)
 			}
+		}()
 
-			for _, img := range images {
-				log.Printf("Starting status checks for image ID %d with UUID: %s", img.ID, img.UUID)
-
-				// Check status three times
-				for checkCount := 1; checkCount <= 3; checkCount++ {
-					log.Printf("Status check %d/3 for image ID %d with UUID: %s", checkCount, img.ID, img.UUID)
-
-					resp, err := service.CheckGenerationStatus(ctx, img.UUID)
-					if err != nil {
-						// Check for 404 status in error body
-						if strings.Contains(err.Error(), "\"status\":404") {
-							log.Printf("API returned 404 for image ID %d, resetting UUID and status", img.ID)
-							if err := repo.UpdateUUID(ctx, img.ID, ""); err != nil {
-								log.Printf("Error resetting UUID for image ID %d: %v", img.ID, err)
-								continue
-							}
-							if err := repo.UpdateStatus(ctx, img.ID, "ReadyToGenerate"); err != nil {
-								log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-								continue
-							}
-							log.Printf("Image ID %d reset to ReadyToGenerate due to 404 status", img.ID)
-							break // Move to next image after handling 404
-						}
-						log.Printf("Error getting status for image ID %d (check %d/3): %v", img.ID, checkCount, err)
-						continue
-					}
-
-					log.Printf("Status for image ID %d (check %d/3): %s", img.ID, checkCount, resp.Status)
-
-					// Handle different statuses
-					switch resp.Status {
-					case "DONE":
-						if len(resp.Files) > 0 {
-							log.Printf("Image ID %d generation completed, saving result", img.ID)
-							if err := repo.UpdateBase64(ctx, img.ID, resp.Files[0]); err != nil {
-								log.Printf("Error saving base64 for image ID %d: %v", img.ID, err)
-								continue
-							}
-							if err := repo.UpdateStatus(ctx, img.ID, "ReadyToPublish"); err != nil {
-								log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-								continue
-							}
-							log.Printf("Successfully saved and marked as ready to publish image ID %d", img.ID)
-							break // Move to next image after successful completion
-						}
-
-					case "FAILED":
-						log.Printf("Image ID %d generation failed", img.ID)
-						if err := repo.UpdateStatus(ctx, img.ID, "Failed"); err != nil {
-							log.Printf("Error updating status for image ID %d: %v", img.ID, err)
-						}
-						break // Move to next image after failure
-
-					default:
-						log.Printf("Image ID %d generation still in progress (check %d/3)", img.ID, checkCount)
-						if checkCount < 3 {
-							time.Sleep(2 * time.Second) // Wait 2 seconds between checks
-						}
-					}
-				}
-			}
+		log.Info("Running scheduled workflow")
+		summary, err := pass(ctx)
+		if err != nil {
+			log.Error("Scheduled workflow failed", "error", err)
+			return
 		}
+		log.Info("Finished scheduled workflow", summary.LogAttrs()...)
 	}
 }
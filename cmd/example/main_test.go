@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the output of the tests to their own failures
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	appLog, workflowLog, httpLog = discard, discard, discard
+	os.Exit(m.Run())
+}
+
+// keepLoggers restores the loggers after a test of a command that configures logging
+func keepLoggers(t *testing.T) {
+	app, workflow, http, def := appLog, workflowLog, httpLog, slog.Default()
+	t.Cleanup(func() {
+		appLog, workflowLog, httpLog = app, workflow, http
+		slog.SetDefault(def)
+	})
+}
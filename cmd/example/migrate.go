@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// migrateCommand applies the embedded database schema. It needs no provider credentials.
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	configureLogging(cfg, *verbose)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if err := repository.Migrate(ctx, db); err != nil {
+		return err
+	}
+	appLog.Info("Database schema applied")
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// reportPass prints the summary of a single workflow pass to w and reports whether it had no
+// errors
+func reportPass(w io.Writer, name string, summary workflows.Summary, err error) bool {
+	if err != nil {
+		workflowLog.Error("Workflow pass failed", "workflow", name, "error", err)
+		fmt.Fprintf(w, "%s: failed: %v\n", name, err)
+		return false
+	}
+	workflowLog.Info("Workflow pass finished", append([]any{"workflow", name}, summary.LogAttrs()...)...)
+	fmt.Fprintf(w, "%s: %s\n", name, summary)
+	return summary.Errors == 0
+}
+
+// workflowPass is a single pass of a workflow, named for the report
+type workflowPass struct {
+	name string
+	run  func(ctx context.Context) (workflows.Summary, error)
+}
+
+// runPasses runs the passes in order, reporting each to w, and reports whether none of them
+// had errors. A failed pass does not stop the ones after it.
+func runPasses(ctx context.Context, w io.Writer, passes []workflowPass) bool {
+	ok := true
+	for _, p := range passes {
+		summary, err := p.run(ctx)
+		ok = reportPass(w, p.name, summary, err) && ok
+	}
+	return ok
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// oncePasses returns the generator and processor passes over repo and svc
+func oncePasses(repo repository.ImageRepository, svc *testsupport.FakeImageGenerationService) []workflowPass {
+	cfg := workflows.DefaultConfig()
+	cfg.Concurrency = 1
+	cfg.CheckInterval = time.Millisecond
+	cfg.PollMaxInterval = time.Millisecond
+	logger := workflows.WithLogger(workflowLog)
+	generator := workflows.NewGenerator(repo, svc, cfg, logger)
+	processor := workflows.NewProcessor(repo, svc, cfg, logger)
+	return []workflowPass{{"Generator", generator.RunOnce}, {"Processor", processor.RunOnce}}
+}
+
+func TestRunPasses(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes map[string]testsupport.Outcome
+		want     bool
+		// wantOut are the lines of the report, without the durations
+		wantOut []string
+	}{
+		{
+			name:    "all generated",
+			want:    true,
+			wantOut: []string{"Generator: 2 images: 2 submitted in ", "Processor: 2 images: 2 generated in "},
+		},
+		{
+			name:     "submit error",
+			outcomes: map[string]testsupport.Outcome{"b": testsupport.SubmitError(errors.New("connection reset"))},
+			want:     false,
+			wantOut:  []string{"Generator: 2 images: 1 submitted, 1 failed, 1 errors (permanent 1) in ", "Processor: 1 images: 1 generated in "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			for _, prompt := range []string{"a", "b"} {
+				if _, err := repo.CreateImage(context.Background(), prompt); err != nil {
+					t.Fatalf("CreateImage() error = %v", err)
+				}
+			}
+			svc := testsupport.NewFakeImageGenerationService()
+			svc.Default = testsupport.DoneAfter(1)
+			for prompt, outcome := range tt.outcomes {
+				svc.On(prompt, outcome)
+			}
+
+			var out bytes.Buffer
+			if ok := runPasses(context.Background(), &out, oncePasses(repo, svc)); ok != tt.want {
+				t.Errorf("runPasses() = %v, want %v; output:\n%s", ok, tt.want, out.String())
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != len(tt.wantOut) {
+				t.Fatalf("output = %q, want %d lines", out.String(), len(tt.wantOut))
+			}
+			for i, want := range tt.wantOut {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("line %d = %q, want it to start with %q", i+1, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPassesContinuesAfterFailedPass(t *testing.T) {
+	var ran []string
+	pass := func(name string, err error) workflowPass {
+		return workflowPass{name, func(ctx context.Context) (workflows.Summary, error) {
+			ran = append(ran, name)
+			return workflows.Summary{}, err
+		}}
+	}
+
+	var out bytes.Buffer
+	ok := runPasses(context.Background(), &out, []workflowPass{pass("Generator", errors.New("database unreachable")), pass("Processor", nil)})
+	if ok {
+		t.Error("runPasses() = true after a failed pass")
+	}
+	if len(ran) != 2 {
+		t.Errorf("ran %v, want both passes", ran)
+	}
+	if want := "Generator: failed: database unreachable\nProcessor: 0 images\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunPassesWithoutPasses(t *testing.T) {
+	var out bytes.Buffer
+	if !runPasses(context.Background(), &out, nil) || out.Len() != 0 {
+		t.Errorf("runPasses() without passes = false or wrote %q", out.String())
+	}
+}
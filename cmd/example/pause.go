@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// pauseCommand pauses the workflows named in args in every process using the database
+func pauseCommand(args []string) error {
+	return setPausedCommand("pause", args, true)
+}
+
+// resumeCommand resumes the workflows named in args in every process using the database
+func resumeCommand(args []string) error {
+	return setPausedCommand("resume", args, false)
+}
+
+// setPausedCommand pauses or resumes the workflows named in args. It only needs the database
+// settings.
+func setPausedCommand(name string, args []string, paused bool) error {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] <workflow>...\n\nWorkflows: %s\n\nFlags:\n", name, strings.Join(domain.Workflows, ", "))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	workflows := flags.Args()
+	if len(workflows) == 0 {
+		return fmt.Errorf("name at least one workflow: %s", strings.Join(domain.Workflows, ", "))
+	}
+	for _, workflow := range workflows {
+		if !domain.IsWorkflow(workflow) {
+			return fmt.Errorf("unknown workflow %q, expected one of %s", workflow, strings.Join(domain.Workflows, ", "))
+		}
+	}
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	configureLogging(cfg, *verbose)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	repo := repository.NewPostgresImageRepository(db)
+	for _, workflow := range workflows {
+		if err := repo.SetPaused(ctx, workflow, paused); err != nil {
+			return fmt.Errorf("failed to %s %s: %w", name, workflow, err)
+		}
+		state := "resumed"
+		if paused {
+			state = "paused"
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", workflow, state)
+	}
+	return nil
+}
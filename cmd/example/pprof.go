@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/basel-ax/2xiang/internal/api"
+	"github.com/basel-ax/2xiang/internal/config"
+)
+
+// pprofPrefix is the path the profiling endpoints are served under
+const pprofPrefix = "/debug/pprof/"
+
+// pprofHandler serves the net/http/pprof endpoints under pprofPrefix to requests carrying
+// token as a bearer token. Importing net/http/pprof also registers them on
+// http.DefaultServeMux, which nothing in this command serves.
+func pprofHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pprofPrefix, pprof.Index)
+	mux.HandleFunc(pprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPrefix+"trace", pprof.Trace)
+	return api.RequireToken(token, mux)
+}
+
+// setProfileRates applies PPROF_MUTEX_FRACTION and PPROF_BLOCK_RATE, which are both off unless
+// configured since sampling contention slows down the workflows
+func setProfileRates(cfg *config.Config) {
+	runtime.SetMutexProfileFraction(cfg.PprofMutexFraction)
+	runtime.SetBlockProfileRate(cfg.PprofBlockRate)
+}
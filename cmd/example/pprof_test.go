@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fetch requests path from srv with token as bearer token, unless it is empty, and returns the
+// status and body
+func fetch(t *testing.T, srv *httptest.Server, path, token string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestPprofRoutes(t *testing.T) {
+	paths := []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline", "/debug/pprof/symbol"}
+	newServer := func(profiles http.Handler) *httptest.Server {
+		srv := httptest.NewServer(httpHandler(health.NewHandler(health.NewState()), prometheus.NewRegistry(), nil, profiles))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		srv := newServer(nil)
+		for _, path := range paths {
+			if status, _ := fetch(t, srv, path, "s3cret"); status != http.StatusNotFound {
+				t.Errorf("GET %s = %d, want 404 with profiling disabled", path, status)
+			}
+		}
+		if status, _ := fetch(t, srv, "/healthz", ""); status != http.StatusOK {
+			t.Errorf("GET /healthz = %d, want the other routes served", status)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		srv := newServer(pprofHandler("s3cret"))
+		for _, path := range paths {
+			for _, token := range []string{"", "wrong"} {
+				if status, body := fetch(t, srv, path, token); status != http.StatusUnauthorized || strings.Contains(body, "goroutine") {
+					t.Errorf("GET %s with token %q = %d, want 401 without a profile", path, token, status)
+				}
+			}
+			if status, _ := fetch(t, srv, path, "s3cret"); status != http.StatusOK {
+				t.Errorf("GET %s with the token = %d, want 200", path, status)
+			}
+		}
+		if _, body := fetch(t, srv, "/debug/pprof/goroutine?debug=1", "s3cret"); !strings.Contains(body, "goroutine profile:") {
+			t.Errorf("goroutine profile = %.200q, want a readable profile", body)
+		}
+	})
+
+	// Without an API token the profiles are never served, rather than served to anyone
+	t.Run("no token configured", func(t *testing.T) {
+		srv := newServer(pprofHandler(""))
+		if status, _ := fetch(t, srv, "/debug/pprof/heap", ""); status != http.StatusUnauthorized {
+			t.Errorf("GET /debug/pprof/heap = %d, want 401", status)
+		}
+	})
+}
+
+func TestSetProfileRates(t *testing.T) {
+	t.Cleanup(func() { setProfileRates(&config.Config{}) })
+
+	setProfileRates(&config.Config{PprofMutexFraction: 5, PprofBlockRate: 1000})
+	if got := runtime.SetMutexProfileFraction(-1); got != 5 {
+		t.Errorf("mutex profile fraction = %d, want 5", got)
+	}
+
+	setProfileRates(&config.Config{})
+	if got := runtime.SetMutexProfileFraction(-1); got != 0 {
+		t.Errorf("mutex profile fraction = %d, want sampling off", got)
+	}
+}
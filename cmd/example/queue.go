@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/logging"
+	"github.com/basel-ax/2xiang/internal/queue"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// runQueueGenerator runs the generator of the QUEUE=redis mode, which takes its jobs from Redis
+// and stores their results there without connecting to the database. With once it handles the
+// jobs waiting and exits.
+func runQueueGenerator(cfg *config.Config, loggers *logging.Loggers, once bool) error {
+	q, err := wiring.NewRedisQueue(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize queue: %w", err)
+	}
+	defer q.Close()
+	if err := pingQueue(q); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	appLog.Info("Connected to Redis queue", "prefix", cfg.Queue.Prefix)
+
+	imgService, err := wiring.NewService(cfg, metrics.Nop{}, loggers.Logger("client"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize image generation service: %w", err)
+	}
+	consumer := queue.NewConsumer(q, q, imgService, wiring.ConsumerConfig(cfg), workflowLog)
+
+	// Cancelling ctx stops taking jobs, while jobs in flight keep going until kill is cancelled
+	// SHUTDOWN_TIMEOUT later
+	killCtx, kill := context.WithCancel(context.Background())
+	defer kill()
+	ctx, cancel := context.WithCancel(killCtx)
+	defer cancel()
+	ctx = workflows.WithHardKill(ctx, killCtx)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go handleShutdown(sigChan, cfg.Workflow.ShutdownTimeout, cancel, kill)
+
+	if once {
+		handled, err := consumer.RunOnce(ctx)
+		if err != nil {
+			return fmt.Errorf("generator pass failed after %d jobs: %w", handled, err)
+		}
+		fmt.Printf("Generator: %d jobs\n", handled)
+		return nil
+	}
+
+	appLog.Info("Starting queue generator", "concurrency", cfg.Workflow.Concurrency)
+	var running sync.WaitGroup
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		consumer.Run(ctx)
+	}()
+
+	<-ctx.Done()
+	appLog.Info("Shutting down gracefully")
+	if drain(killCtx, &running) {
+		appLog.Info("Jobs in flight finished")
+	}
+	return nil
+}
+
+// pingQueue checks that the Redis server answers
+func pingQueue(q *queue.Redis) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	return q.Ping(ctx)
+}
+
+// addToQueue queues the prompt of img as a job of the QUEUE=redis mode and prints its ID. With
+// wait it polls the result of the job until there is one and, with out, writes the image there.
+func addToQueue(ctx context.Context, cfg *config.Config, img *domain.Image, wait bool, out string, timeout time.Duration) error {
+	if img.Priority != 0 || img.CallbackURL != "" || len(img.Tags) > 0 {
+		return errors.New("-priority, -callback-url and -tag are not supported with QUEUE=redis")
+	}
+
+	q, err := wiring.NewRedisQueue(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize queue: %w", err)
+	}
+	defer q.Close()
+
+	id, err := q.Enqueue(ctx, queue.Job{
+		Prompt:         img.Prompt,
+		Width:          img.Width,
+		Height:         img.Height,
+		Style:          img.Style,
+		NegativePrompt: img.NegativePrompt,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+
+	if !wait {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(addPollInterval)
+	defer ticker.Stop()
+	for {
+		res, err := q.GetResult(waitCtx, id)
+		if err != nil {
+			return err
+		}
+		if res != nil {
+			if res.Status != domain.StatusReadyToPublish {
+				return fmt.Errorf("job %d ended in status %s: %s", id, res.Status, res.Error)
+			}
+			appLog.Info("Image generated", "job_id", id, "uuid", res.UUID)
+			if out == "" {
+				return nil
+			}
+			if err := os.WriteFile(out, res.Image, 0o644); err != nil {
+				return fmt.Errorf("failed to write image: %w", err)
+			}
+			appLog.Info("Image written", "job_id", id, "path", out)
+			return nil
+		}
+		appLog.Debug("Waiting for job", "job_id", id)
+
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("gave up waiting for job %d: %w", id, waitCtx.Err())
+		case <-ticker.C:
+		}
+	}
+}
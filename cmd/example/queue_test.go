@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/logging"
+)
+
+func TestRunQueueGeneratorReturnsStartupErrors(t *testing.T) {
+	// An address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		name     string
+		redisURL string
+		want     string
+	}{
+		{name: "invalid URL", redisURL: "mysql://localhost", want: "failed to initialize queue"},
+		{name: "unreachable", redisURL: "redis://" + closed, want: "failed to connect to Redis"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Queue: config.QueueConfig{Backend: "redis", RedisURL: tt.redisURL}}
+			err := runQueueGenerator(cfg, logging.New(io.Discard, logging.Options{}), true)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runQueueGenerator() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
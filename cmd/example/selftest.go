@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// selfTestPrompts are the prompts queued by the self-test
+var selfTestPrompts = []string{
+	"a lighthouse on a rocky coast at dawn",
+	"a red bicycle leaning against a brick wall",
+	"a bowl of oranges on a wooden table",
+}
+
+const (
+	// selfTestKey is the API and secret key of the fake Fusion Brain server
+	selfTestKey = "selftest"
+	// selfTestMaxPasses bounds the processor passes waiting for the generations to finish
+	selfTestMaxPasses = 10
+)
+
+// selfTestResult is the outcome of one prompt of the self-test
+type selfTestResult struct {
+	prompt  string
+	id      int
+	status  domain.ImageStatus
+	elapsed time.Duration
+	err     error
+}
+
+// runSelfTest queues selfTestPrompts in an in-memory repository, generates them against an
+// in-process fake Fusion Brain server with the generator and processor workflows, and checks
+// that every image ends up ReadyToPublish with a result that decodes. Post-processing,
+// watermarking and thumbnails follow cfg, while storage, caching, webhooks and the providers
+// are replaced. It writes a summary to w and reports whether every image passed.
+func runSelfTest(ctx context.Context, base *config.Config, w io.Writer) bool {
+	start := time.Now()
+	cfg := *base
+	cfg.Providers = []string{fusionbrain.ProviderName}
+	cfg.CheckInterval = 10 * time.Millisecond
+	cfg.PollMaxInterval = 100 * time.Millisecond
+	cfg.GenerationTimeout = 10 * time.Second
+	cfg.PerImageTimeout = 10 * time.Second
+	cfg.Workflow.BatchSize = 0
+	cfg.PostProcessKeepOriginal = false
+
+	srv := fakeserver.New(fakeserver.WithCredentials(selfTestKey, selfTestKey))
+	defer srv.Close()
+
+	repo := repository.NewMemoryImageRepository(repository.WithMemoryDefaultSize(cfg.DefaultImageWidth, cfg.DefaultImageHeight))
+	client := fusionbrain.NewClient(selfTestKey, selfTestKey, fusionbrain.WithBaseURL(srv.URL))
+	opts := []service.Option{service.WithUsageRecorder(repo)}
+	if processors := wiring.PromptProcessors(&cfg); len(processors) > 0 {
+		opts = append(opts, service.WithPromptProcessors(processors...))
+	}
+	svc := service.NewImageGenerationService(client, wiring.ServiceConfig(&cfg), opts...)
+
+	fail := func(err error) bool {
+		fmt.Fprintf(w, "Self-test failed after %s: %v\n", time.Since(start).Round(time.Millisecond), err)
+		return false
+	}
+
+	pipeline, err := imageproc.NewFromConfig(&cfg)
+	if err != nil {
+		return fail(fmt.Errorf("failed to initialize post-processing: %w", err))
+	}
+	watermark, err := imageproc.WatermarkFromConfig(&cfg)
+	if err != nil {
+		return fail(fmt.Errorf("failed to load watermark: %w", err))
+	}
+	writer := results.NewWriter(repo, wiring.ResultsConfig(&cfg), results.WithPipeline(pipeline), results.WithWatermark(watermark), results.WithLogger(workflowLog))
+	workflowCfg := wiring.WorkflowConfig(&cfg)
+	workflowOpts := []workflows.Option{workflows.WithLogger(workflowLog), workflows.WithResults(writer)}
+	generator := workflows.NewGenerator(repo, svc, workflowCfg, workflowOpts...)
+	processor := workflows.NewProcessor(repo, svc, workflowCfg, workflowOpts...)
+
+	// Every generation reports processing once, so the processor has to check it again
+	for range selfTestPrompts {
+		srv.Enqueue(fakeserver.Initial(), fakeserver.Processing(), fakeserver.Done(fakeserver.Pixel))
+	}
+	ids, err := repo.BulkCreate(ctx, selfTestPrompts)
+	if err != nil {
+		return fail(fmt.Errorf("failed to queue prompts: %w", err))
+	}
+
+	if _, err := generator.RunOnce(ctx); err != nil {
+		return fail(fmt.Errorf("generator pass failed: %w", err))
+	}
+	for pass := 0; pass < selfTestMaxPasses; pass++ {
+		pending, err := repo.CountImages(ctx, repository.ListFilter{Status: domain.StatusGenerate})
+		if err != nil {
+			return fail(err)
+		}
+		if pending == 0 {
+			break
+		}
+		if _, err := processor.RunOnce(ctx); err != nil {
+			return fail(fmt.Errorf("processor pass failed: %w", err))
+		}
+	}
+
+	outcome := make([]selfTestResult, 0, len(ids))
+	for i, id := range ids {
+		outcome = append(outcome, checkSelfTestImage(ctx, repo, writer, selfTestPrompts[i], id))
+	}
+	return writeSelfTestReport(w, outcome, time.Since(start))
+}
+
+// checkSelfTestImage checks that the image with the given ID is ReadyToPublish with a result
+// that decodes
+func checkSelfTestImage(ctx context.Context, repo repository.ImageRepository, writer workflows.Results, prompt string, id int) selfTestResult {
+	result := selfTestResult{prompt: prompt, id: id}
+
+	img, err := repo.GetImage(ctx, id)
+	if err != nil || img == nil {
+		result.err = fmt.Errorf("image not found: %v", err)
+		return result
+	}
+	result.status = img.Status
+	result.elapsed = img.UpdatedAt.Sub(img.CreatedAt)
+
+	if img.Status != domain.StatusReadyToPublish {
+		result.err = fmt.Errorf("status %s, want %s", img.Status, domain.StatusReadyToPublish)
+		if img.ErrorDescription != "" {
+			result.err = fmt.Errorf("%w: %s", result.err, img.ErrorDescription)
+		}
+		return result
+	}
+
+	data, err := writer.Load(ctx, img)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if _, err := imageproc.Metadata(data); err != nil {
+		result.err = fmt.Errorf("result is not a valid image: %w", err)
+	}
+	return result
+}
+
+// writeSelfTestReport writes the outcome of every prompt and reports whether all of them passed
+func writeSelfTestReport(w io.Writer, results []selfTestResult, elapsed time.Duration) bool {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tRESULT\tSTATUS\tTIME\tPROMPT")
+	failed := 0
+	for _, r := range results {
+		verdict := "PASS"
+		if r.err != nil {
+			verdict = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", r.id, verdict, r.status, r.elapsed.Round(time.Millisecond), abbreviate(r.prompt, 40))
+	}
+	tw.Flush()
+
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "Image %d: %v\n", r.id, r.err)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "Self-test failed: %d of %d images in %s\n", failed, len(results), elapsed.Round(time.Millisecond))
+		return false
+	}
+	fmt.Fprintf(w, "Self-test passed: %d images in %s\n", len(results), elapsed.Round(time.Millisecond))
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// selfTestConfig loads the configuration of the self-test from the defaults
+func selfTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.LoadWithoutProviders(config.WithoutDatabase())
+	if err != nil {
+		t.Fatalf("LoadWithoutProviders() error = %v", err)
+	}
+	return cfg
+}
+
+func TestRunSelfTest(t *testing.T) {
+	var out bytes.Buffer
+	if !runSelfTest(context.Background(), selfTestConfig(t), &out) {
+		t.Fatalf("runSelfTest() = false, want it to pass\n%s", out.String())
+	}
+	if got := strings.Count(out.String(), "PASS"); got != len(selfTestPrompts) {
+		t.Errorf("summary has %d passed images, want %d\n%s", got, len(selfTestPrompts), out.String())
+	}
+	if !strings.Contains(out.String(), "Self-test passed: 3 images in ") {
+		t.Errorf("summary = %s, want the passed line with the timing", out.String())
+	}
+}
+
+func TestRunSelfTestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	if runSelfTest(ctx, selfTestConfig(t), &out) {
+		t.Fatalf("runSelfTest() with a canceled context = true, want it to fail\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Self-test failed") {
+		t.Errorf("summary = %s, want the failure reported", out.String())
+	}
+}
+
+func TestWriteSelfTestReport(t *testing.T) {
+	results := []selfTestResult{
+		{prompt: "a lighthouse on a rocky coast at dawn", id: 1, status: domain.StatusReadyToPublish, elapsed: 120 * time.Millisecond},
+		{prompt: "a red bicycle", id: 2, status: domain.StatusFailed, elapsed: 80 * time.Millisecond, err: errors.New("status Failed, want ReadyToPublish: internal error")},
+	}
+
+	var out bytes.Buffer
+	if writeSelfTestReport(&out, results, 250*time.Millisecond) {
+		t.Error("writeSelfTestReport() = true, want false with a failed image")
+	}
+	want := "ID  RESULT  STATUS          TIME   PROMPT\n" +
+		"1   PASS    ReadyToPublish  120ms  a lighthouse on a rocky coast at dawn\n" +
+		"2   FAIL    Failed          80ms   a red bicycle\n" +
+		"Image 2: status Failed, want ReadyToPublish: internal error\n" +
+		"Self-test failed: 1 of 2 images in 250ms\n"
+	if out.String() != want {
+		t.Errorf("writeSelfTestReport() wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
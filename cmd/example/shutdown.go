@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// handleShutdown calls stop on the first signal, then kill once timeout has passed or on a
+// second signal
+func handleShutdown(signals <-chan os.Signal, timeout time.Duration, stop, kill context.CancelFunc) {
+	sig := <-signals
+	appLog.Info("Received signal, finishing images in flight", "signal", sig, "timeout", timeout)
+	stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sig = <-signals:
+		appLog.Warn("Received second signal, aborting images in flight", "signal", sig)
+	case <-timer.C:
+		appLog.Warn("Shutdown timeout reached, aborting images in flight")
+	}
+	kill()
+}
+
+// drain waits until every workflow has returned and every webhook delivery has finished,
+// or until ctx is cancelled, and reports whether everything finished
+func drain(ctx context.Context, workflows *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		workflows.Wait()
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// shutdown runs handleShutdown with the given timeout and returns the signals it reads, the
+// stop and kill contexts it cancels, and a channel closed once it returned
+func shutdown(timeout time.Duration) (chan os.Signal, context.Context, context.Context, <-chan struct{}) {
+	signals := make(chan os.Signal, 2)
+	kill, killCancel := context.WithCancel(context.Background())
+	stop, stopCancel := context.WithCancel(kill)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleShutdown(signals, timeout, stopCancel, killCancel)
+	}()
+	return signals, stop, kill, done
+}
+
+// waitDone fails the test unless ch is closed within a second
+func waitDone(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("%s not done within 1s", what)
+	}
+}
+
+func TestHandleShutdownTimeout(t *testing.T) {
+	signals, stop, kill, done := shutdown(50 * time.Millisecond)
+	signals <- syscall.SIGTERM
+	waitDone(t, stop.Done(), "stop")
+	if kill.Err() != nil {
+		t.Fatal("kill cancelled together with stop, want a grace period")
+	}
+	waitDone(t, kill.Done(), "kill after the timeout")
+	waitDone(t, done, "handleShutdown")
+}
+
+func TestHandleShutdownSecondSignal(t *testing.T) {
+	signals, stop, kill, done := shutdown(time.Hour)
+	signals <- syscall.SIGTERM
+	waitDone(t, stop.Done(), "stop")
+	signals <- syscall.SIGINT
+	waitDone(t, kill.Done(), "kill after the second signal")
+	waitDone(t, done, "handleShutdown")
+}
+
+func TestDrain(t *testing.T) {
+	var running sync.WaitGroup
+	running.Add(1)
+	release := make(chan struct{})
+	go func() {
+		defer running.Done()
+		<-release
+	}()
+
+	// A workflow still running holds the drain until the context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if drain(ctx, &running) {
+		t.Error("drain() = true while a workflow is running")
+	}
+
+	close(release)
+	if !drain(context.Background(), &running) {
+		t.Error("drain() = false once every workflow returned")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/textutil"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// statusReport is the queue overview printed by the status command
+type statusReport struct {
+	Counts map[domain.ImageStatus]int `json:"counts"`
+	// OldestQueuedAgeSeconds is how long the oldest ReadyToGenerate image has waited, 0 without one
+	OldestQueuedAgeSeconds int64         `json:"oldest_queued_age_seconds"`
+	RecentFailures         []failedImage `json:"recent_failures"`
+	// UsageToday is the number of generation requests per provider since midnight UTC
+	UsageToday []domain.ProviderUsage `json:"usage_today"`
+	// PausedWorkflows are the workflows paused with the pause command
+	PausedWorkflows []string  `json:"paused_workflows"`
+	GeneratedAt     time.Time `json:"generated_at"`
+	oldestQueuedAge time.Duration
+}
+
+// failedImage is a recently failed image in a statusReport
+type failedImage struct {
+	ID        int       `json:"id"`
+	Prompt    string    `json:"prompt"`
+	Error     string    `json:"error"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// statusCommand prints the number of images per status, the age of the oldest queued image and
+// the latest failures, or with -history the prompt revisions and with -events the status
+// transitions of an image. It only reads the database and needs no provider credentials.
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	failures := flags.Int("failures", 10, "Number of recent failures to show")
+	history := flags.Int("history", 0, "Show the prompt revisions of the image with this ID instead")
+	events := flags.Int("events", 0, "Show the status transitions of the image with this ID instead")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	configFile := flags.String("config", "", "Read settings from this YAML or TOML file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	if *failures < 0 {
+		return fmt.Errorf("-failures must be at least 0")
+	}
+	if *history < 0 {
+		return fmt.Errorf("-history must be an image ID")
+	}
+	if *events < 0 {
+		return fmt.Errorf("-events must be an image ID")
+	}
+	if *history > 0 && *events > 0 {
+		return fmt.Errorf("-history and -events cannot be combined")
+	}
+
+	cfg, err := config.LoadWithoutProviders(configOptions(*configFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	configureLogging(cfg, *verbose)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	repo := repository.NewPostgresImageRepository(db)
+	if *history > 0 {
+		revisions, err := repo.GetPromptHistory(ctx, *history)
+		if err != nil {
+			return fmt.Errorf("failed to get prompt history: %w", err)
+		}
+		if len(revisions) == 0 {
+			return fmt.Errorf("image %d not found", *history)
+		}
+		if *asJSON {
+			return writeStatusJSON(os.Stdout, revisions)
+		}
+		return writeHistoryTable(os.Stdout, revisions)
+	}
+	if *events > 0 {
+		img, err := repo.GetImage(ctx, *events)
+		if err != nil {
+			return fmt.Errorf("failed to get image: %w", err)
+		}
+		if img == nil {
+			return fmt.Errorf("image %d not found", *events)
+		}
+		trail, err := repo.GetEvents(ctx, *events)
+		if err != nil {
+			return fmt.Errorf("failed to get events: %w", err)
+		}
+		if *asJSON {
+			if trail == nil {
+				trail = []domain.ImageEvent{}
+			}
+			return writeStatusJSON(os.Stdout, trail)
+		}
+		if err := writeEventsTable(os.Stdout, trail); err != nil {
+			return err
+		}
+		return writeRawResponse(os.Stdout, img.RawResponse)
+	}
+
+	report, err := buildStatusReport(ctx, repo, *failures, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeStatusJSON(os.Stdout, report)
+	}
+	return writeStatusTable(os.Stdout, report)
+}
+
+// buildStatusReport gathers the queue overview at now with up to failures recent failures
+func buildStatusReport(ctx context.Context, repo repository.ImageRepository, failures int, now time.Time) (*statusReport, error) {
+	counts, err := repo.CountByStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count images: %w", err)
+	}
+	report := &statusReport{Counts: counts, RecentFailures: []failedImage{}, GeneratedAt: now}
+
+	usage, err := repo.GetUsage(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get today's usage: %w", err)
+	}
+	report.UsageToday = append([]domain.ProviderUsage{}, usage...)
+
+	controls, err := repo.GetControls(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow controls: %w", err)
+	}
+	report.PausedWorkflows = []string{}
+	for _, c := range controls {
+		if c.Paused {
+			report.PausedWorkflows = append(report.PausedWorkflows, c.Workflow)
+		}
+	}
+
+	oldest, err := repo.ListImages(ctx, repository.ListFilter{
+		Status:    domain.StatusReadyToGenerate,
+		Limit:     1,
+		SortBy:    repository.SortByCreatedAt,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oldest queued image: %w", err)
+	}
+	if len(oldest) > 0 && !oldest[0].CreatedAt.IsZero() {
+		report.oldestQueuedAge = now.Sub(oldest[0].CreatedAt).Truncate(time.Second)
+		report.OldestQueuedAgeSeconds = int64(report.oldestQueuedAge / time.Second)
+	}
+
+	if failures > 0 {
+		failed, err := repo.ListImages(ctx, repository.ListFilter{
+			Status: domain.StatusFailed,
+			Limit:  failures,
+			SortBy: repository.SortByUpdatedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list failed images: %w", err)
+		}
+		for _, img := range failed {
+			report.RecentFailures = append(report.RecentFailures, failedImage{
+				ID:        img.ID,
+				Prompt:    img.Prompt,
+				Error:     img.ErrorDescription,
+				UpdatedAt: img.UpdatedAt,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// writeStatusJSON writes report as indented JSON
+func writeStatusJSON(w io.Writer, report any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// writeStatusTable writes report as aligned tables
+func writeStatusTable(w io.Writer, report *statusReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	statuses := make([]string, 0, len(report.Counts))
+	total := 0
+	for status, count := range report.Counts {
+		statuses = append(statuses, string(status))
+		total += count
+	}
+	sort.Strings(statuses)
+
+	fmt.Fprintln(tw, "STATUS\tIMAGES")
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "%s\t%d\n", status, report.Counts[domain.ImageStatus(status)])
+	}
+	fmt.Fprintf(tw, "Total\t%d\n", total)
+	fmt.Fprintln(tw)
+
+	if report.oldestQueuedAge > 0 {
+		fmt.Fprintf(tw, "Oldest queued image waiting for %s\n", report.oldestQueuedAge)
+	} else {
+		fmt.Fprintln(tw, "No images queued for generation")
+	}
+	if len(report.PausedWorkflows) > 0 {
+		fmt.Fprintf(tw, "Paused workflows: %s\n", strings.Join(report.PausedWorkflows, ", "))
+	}
+
+	if len(report.RecentFailures) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "ID\tFAILED AT\tPROMPT\tERROR")
+		for _, f := range report.RecentFailures {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", f.ID, f.UpdatedAt.Format(time.RFC3339), abbreviate(f.Prompt, 40), abbreviate(f.Error, 80))
+		}
+	}
+
+	if len(report.UsageToday) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "PROVIDER\tREQUESTS TODAY\tFAILURES")
+		for _, u := range report.UsageToday {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", u.Provider, u.Requests, u.Failures)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// writeHistoryTable writes the prompt revisions of an image as an aligned table
+func writeHistoryTable(w io.Writer, revisions []domain.PromptRevision) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tSOURCE\tCREATED AT\tPROMPT")
+	for _, rev := range revisions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", rev.Revision, rev.Source, rev.CreatedAt.Format(time.RFC3339), abbreviate(rev.Text, 80))
+	}
+	return tw.Flush()
+}
+
+// writeEventsTable writes the status transitions of an image as an aligned table
+func writeEventsTable(w io.Writer, events []domain.ImageEvent) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tFROM\tTO\tACTOR\tREASON")
+	for _, e := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format(time.RFC3339), e.From, e.To, e.Actor, abbreviate(e.Reason, 80))
+	}
+	return tw.Flush()
+}
+
+// writeRawResponse writes the stored provider response of an image indented, if it has one
+func writeRawResponse(w io.Writer, raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return fmt.Errorf("failed to format raw response: %w", err)
+	}
+	_, err := fmt.Fprintf(w, "\nProvider response:\n%s\n", indented.String())
+	return err
+}
+
+// abbreviate shortens s to at most length characters on a single line
+func abbreviate(s string, length int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len([]rune(s)) <= length {
+		return s
+	}
+	return textutil.TruncateWords(s, length-3) + "..."
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, rewriting the file instead with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run go test -update to accept it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// at is a fixed time on 2024-05-01 UTC, offset by d
+func at(d time.Duration) time.Time {
+	return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(d)
+}
+
+// sampleReport is a report with every section filled in
+func sampleReport() *statusReport {
+	return &statusReport{
+		Counts: map[domain.ImageStatus]int{
+			domain.StatusReadyToGenerate: 12,
+			domain.StatusGenerate:        3,
+			domain.StatusReadyToPublish:  1,
+			domain.StatusPublished:       240,
+			domain.StatusFailed:          2,
+		},
+		OldestQueuedAgeSeconds: 5430,
+		oldestQueuedAge:        90*time.Minute + 30*time.Second,
+		RecentFailures: []failedImage{
+			{ID: 261, Prompt: "a lighthouse on a cliff at dawn, painted in the style of the old masters", Error: "content policy violation", UpdatedAt: at(-time.Hour)},
+			{ID: 250, Prompt: "a\nharbour", Error: "failed to check generation status: 500 Internal Server Error", UpdatedAt: at(-3 * time.Hour)},
+		},
+		UsageToday: []domain.ProviderUsage{
+			{Provider: "fusionbrain", Day: at(-12 * time.Hour), Requests: 57, Failures: 2},
+			{Provider: "openai", Day: at(-12 * time.Hour), Requests: 4},
+		},
+		PausedWorkflows: []string{"publisher"},
+		GeneratedAt:     at(0),
+	}
+}
+
+func TestWriteStatusTable(t *testing.T) {
+	tests := []struct {
+		golden string
+		report *statusReport
+	}{
+		{golden: "status.golden", report: sampleReport()},
+		{golden: "status_empty.golden", report: &statusReport{
+			Counts:          map[domain.ImageStatus]int{},
+			RecentFailures:  []failedImage{},
+			UsageToday:      []domain.ProviderUsage{},
+			PausedWorkflows: []string{},
+			GeneratedAt:     at(0),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeStatusTable(&out, tt.report); err != nil {
+				t.Fatalf("writeStatusTable() error = %v", err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestWriteStatusJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeStatusJSON(&out, sampleReport()); err != nil {
+		t.Fatalf("writeStatusJSON() error = %v", err)
+	}
+	checkGolden(t, "status.json.golden", out.Bytes())
+}
+
+func TestWriteHistoryAndEvents(t *testing.T) {
+	var out bytes.Buffer
+	err := writeHistoryTable(&out, []domain.PromptRevision{
+		{Revision: 1, Source: domain.PromptSourceAuthor, Text: "a lighthouse", CreatedAt: at(-2 * time.Hour)},
+		{Revision: 2, Source: domain.PromptSourceProcessor, Text: "a lighthouse at dawn", CreatedAt: at(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("writeHistoryTable() error = %v", err)
+	}
+	checkGolden(t, "history.golden", out.Bytes())
+
+	out.Reset()
+	err = writeEventsTable(&out, []domain.ImageEvent{
+		{From: "", To: domain.StatusReadyToGenerate, Actor: "api", CreatedAt: at(-2 * time.Hour)},
+		{From: domain.StatusReadyToGenerate, To: domain.StatusGenerate, Actor: "generator", CreatedAt: at(-time.Hour)},
+		{From: domain.StatusGenerate, To: domain.StatusFailed, Actor: "processor", Reason: "content policy violation", CreatedAt: at(0)},
+	})
+	if err != nil {
+		t.Fatalf("writeEventsTable() error = %v", err)
+	}
+	if err := writeRawResponse(&out, json.RawMessage(`{"uuid":"fake-1","status":"FAIL","errorDescription":"content policy violation"}`)); err != nil {
+		t.Fatalf("writeRawResponse() error = %v", err)
+	}
+	checkGolden(t, "events.golden", out.Bytes())
+}
+
+func TestBuildStatusReport(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ctx := context.Background()
+	ids := make([]int, 4)
+	for i := range ids {
+		ids[i], _ = repo.CreateImage(ctx, "a lighthouse")
+	}
+	repo.MarkFailed(ctx, ids[0], "content policy violation", "permanent")
+	repo.MarkFailed(ctx, ids[1], "internal error", "permanent")
+	repo.SetPaused(ctx, "publisher", true)
+	oldest, _ := repo.GetImage(ctx, ids[2])
+	now := oldest.CreatedAt.Add(90*time.Second + 500*time.Millisecond)
+
+	report, err := buildStatusReport(ctx, repo, 1, now)
+	if err != nil {
+		t.Fatalf("buildStatusReport() error = %v", err)
+	}
+	if report.Counts[domain.StatusFailed] != 2 || report.Counts[domain.StatusReadyToGenerate] != 2 {
+		t.Errorf("counts = %v, want 2 failed and 2 queued", report.Counts)
+	}
+	if report.OldestQueuedAgeSeconds != 90 {
+		t.Errorf("oldest queued age = %ds, want 90s", report.OldestQueuedAgeSeconds)
+	}
+	// Only the most recent failure is listed
+	if len(report.RecentFailures) != 1 || report.RecentFailures[0].ID != ids[1] || report.RecentFailures[0].Error != "internal error" {
+		t.Errorf("recent failures = %+v, want the last one", report.RecentFailures)
+	}
+	if len(report.PausedWorkflows) != 1 || report.PausedWorkflows[0] != "publisher" {
+		t.Errorf("paused workflows = %v, want the publisher", report.PausedWorkflows)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/basel-ax/2xiang/internal/webhook"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// webhookStatuses are the final statuses announced to callback URLs
+var webhookStatuses = map[domain.ImageStatus]bool{
+	domain.StatusReadyToPublish: true,
+	domain.StatusFailed:         true,
+	domain.StatusTimedOut:       true,
+	domain.StatusCensored:       true,
+}
+
+// webhookDeliveries tracks deliveries in flight, so -once can wait for them before exiting
+var webhookDeliveries sync.WaitGroup
+
+// webhookSender notifies the callback URLs of images of their generation outcome
+type webhookSender struct {
+	repo     repository.ImageRepository
+	notifier *webhook.Notifier
+}
+
+// onOutcome reports the final status of img to its callback URL in the background, recording
+// whether the delivery succeeded. It is the OnOutcome hook of the workflows.
+func (w *webhookSender) onOutcome(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string) {
+	if img.CallbackURL == "" || !webhookStatuses[status] {
+		return
+	}
+
+	payload := webhook.Payload{
+		ID:          img.ID,
+		Status:      string(status),
+		UUID:        img.UUID,
+		Error:       errorDescription,
+		DownloadURL: img.FileURL,
+	}
+
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		attempts, err := w.notifier.Deliver(ctx, img.CallbackURL, payload)
+		if ctx.Err() != nil {
+			return
+		}
+
+		deliveryStatus := "delivered"
+		if err != nil {
+			workflowLog.Warn("Error delivering webhook", "image_id", img.ID, "uuid", img.UUID, "attempt", attempts, "error", err)
+			deliveryStatus = "failed"
+		}
+		if err := w.repo.UpdateWebhookStatus(ctx, img.ID, deliveryStatus, attempts); err != nil {
+			workflowLog.Error("Error recording webhook delivery", "image_id", img.ID, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/webhook"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// webhookStatusRepo records the webhook delivery statuses saved through it
+type webhookStatusRepo struct {
+	repository.ImageRepository
+
+	mu       sync.Mutex
+	statuses map[int]string
+	attempts map[int]int
+}
+
+func (r *webhookStatusRepo) UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[id], r.attempts[id] = status, attempts
+	return nil
+}
+
+func TestWebhookSenderRecordsDeliveryStatus(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[r.URL.Path]++
+		if r.URL.Path == "/failing" || (r.URL.Path == "/flaky" && calls[r.URL.Path] == 1) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	repo := &webhookStatusRepo{ImageRepository: repository.NewMemoryImageRepository(), statuses: map[int]string{}, attempts: map[int]int{}}
+	sender := &webhookSender{repo: repo, notifier: webhook.NewNotifier("secret", 3, webhook.WithRetryDelay(time.Millisecond))}
+
+	images := []struct {
+		img          *domain.Image
+		status       domain.ImageStatus
+		wantStatus   string
+		wantAttempts int
+	}{
+		{img: &domain.Image{ID: 1, CallbackURL: srv.URL + "/ok"}, status: domain.StatusReadyToPublish, wantStatus: "delivered", wantAttempts: 1},
+		{img: &domain.Image{ID: 2, CallbackURL: srv.URL + "/flaky"}, status: domain.StatusFailed, wantStatus: "delivered", wantAttempts: 2},
+		{img: &domain.Image{ID: 3, CallbackURL: srv.URL + "/failing"}, status: domain.StatusCensored, wantStatus: "failed", wantAttempts: 3},
+		// Neither images without a callback URL nor intermediate statuses are announced
+		{img: &domain.Image{ID: 4}, status: domain.StatusReadyToPublish},
+		{img: &domain.Image{ID: 5, CallbackURL: srv.URL + "/generate"}, status: domain.StatusGenerate},
+	}
+	for _, tt := range images {
+		sender.onOutcome(context.Background(), tt.img, tt.status, "")
+	}
+	webhookDeliveries.Wait()
+
+	for _, tt := range images {
+		if repo.statuses[tt.img.ID] != tt.wantStatus || repo.attempts[tt.img.ID] != tt.wantAttempts {
+			t.Errorf("image %d webhook status = %q after %d attempts, want %q after %d", tt.img.ID, repo.statuses[tt.img.ID], repo.attempts[tt.img.ID], tt.wantStatus, tt.wantAttempts)
+		}
+	}
+	if calls["/generate"] != 0 {
+		t.Errorf("intermediate status was delivered %d times", calls["/generate"])
+	}
+}
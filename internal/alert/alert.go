@@ -0,0 +1,145 @@
+// Package alert sends operator alerts, such as failure spikes and stuck queues, to chat webhooks.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+)
+
+// Alert is a single firing of an alert rule
+type Alert struct {
+	// Rule names the rule that fired, e.g. failure_rate
+	Rule string
+	// Workflow is the workflow whose pass fired the rule
+	Workflow string
+	Message  string
+	// Summary is the one-line summary of the pass that fired the rule
+	Summary string
+}
+
+// Text formats the alert as a single chat message
+func (a Alert) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[2xiang] %s (%s): %s", a.Rule, a.Workflow, a.Message)
+	if a.Summary != "" {
+		fmt.Fprintf(&b, "\nLast pass: %s", a.Summary)
+	}
+	return b.String()
+}
+
+// Notifier sends alerts. Implementations must be safe for concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Slack posts alerts to a Slack incoming webhook, or to any endpoint that accepts its payload,
+// such as a Discord webhook URL ending in /slack
+type Slack struct {
+	url        string
+	httpClient *http.Client
+}
+
+// Option configures optional Slack settings
+type Option func(*Slack)
+
+// WithHTTPClient overrides the HTTP client used to post alerts
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Slack) {
+		s.httpClient = client
+	}
+}
+
+// NewSlack creates a notifier posting to the webhook url
+func NewSlack(url string, opts ...Option) *Slack {
+	s := &Slack{
+		url: url,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// slackPayload is the JSON body of a Slack incoming webhook
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+// Notify posts the alert as a text message
+func (s *Slack) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(slackPayload{Text: a.Text()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Throttle passes on at most one alert per rule and workflow per cooldown. An alert that could
+// not be sent does not start the cooldown, so the next firing tries again.
+type Throttle struct {
+	notifier Notifier
+	cooldown time.Duration
+	clk      clock.Clock
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// NewThrottle wraps notifier so that each rule alerts at most once per cooldown of clk
+func NewThrottle(notifier Notifier, cooldown time.Duration, clk clock.Clock) *Throttle {
+	return &Throttle{notifier: notifier, cooldown: cooldown, clk: clk, sent: make(map[string]time.Time)}
+}
+
+// Notify sends the alert unless its rule already alerted within the cooldown, and reports
+// whether it was sent
+func (t *Throttle) Notify(ctx context.Context, a Alert) (bool, error) {
+	key := a.Rule + "/" + a.Workflow
+	now := t.clk.Now()
+
+	t.mu.Lock()
+	if last, ok := t.sent[key]; ok && now.Sub(last) < t.cooldown {
+		t.mu.Unlock()
+		return false, nil
+	}
+	// Reserve the slot, so that concurrent firings of the rule send a single alert
+	t.sent[key] = now
+	t.mu.Unlock()
+
+	if err := t.notifier.Notify(ctx, a); err != nil {
+		t.mu.Lock()
+		delete(t.sent, key)
+		t.mu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
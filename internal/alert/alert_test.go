@@ -0,0 +1,126 @@
+package alert_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/alert"
+	"github.com/basel-ax/2xiang/pkg/clock"
+)
+
+// chat is a webhook endpoint capturing the text of the payloads posted to it, answering with
+// status
+type chat struct {
+	status int
+
+	mu    sync.Mutex
+	texts []string
+}
+
+func (c *chat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.texts = append(c.texts, payload.Text)
+	c.mu.Unlock()
+	w.WriteHeader(c.status)
+}
+
+// newChat starts a chat endpoint answering with status and returns it with its URL
+func newChat(t *testing.T, status int) (*chat, string) {
+	t.Helper()
+	c := &chat{status: status}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+var firing = alert.Alert{Rule: "failure_rate", Workflow: "generator", Message: "6 of 10 images failed", Summary: "10 images: 6 failed in 1s"}
+
+func TestSlackNotify(t *testing.T) {
+	c, url := newChat(t, http.StatusOK)
+	if err := alert.NewSlack(url).Notify(context.Background(), firing); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	want := "[2xiang] failure_rate (generator): 6 of 10 images failed\nLast pass: 10 images: 6 failed in 1s"
+	if len(c.texts) != 1 || c.texts[0] != want {
+		t.Errorf("posted %q, want %q", c.texts, want)
+	}
+
+	_, url = newChat(t, http.StatusNotFound)
+	if err := alert.NewSlack(url).Notify(context.Background(), firing); err == nil {
+		t.Error("Notify() to a webhook answering 404 error = nil, want an error")
+	}
+}
+
+func TestAlertText(t *testing.T) {
+	a := alert.Alert{Rule: "queue_backlog", Workflow: "generator", Message: "image 3 has been waiting"}
+	if got, want := a.Text(), "[2xiang] queue_backlog (generator): image 3 has been waiting"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+// scriptedNotifier records the alerts it is asked to send and fails while err is set
+type scriptedNotifier struct {
+	err  error
+	sent []alert.Alert
+}
+
+func (n *scriptedNotifier) Notify(ctx context.Context, a alert.Alert) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, a)
+	return nil
+}
+
+func TestThrottle(t *testing.T) {
+	n := &scriptedNotifier{}
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	throttle := alert.NewThrottle(n, time.Hour, clk)
+	ctx := context.Background()
+
+	notify := func(a alert.Alert, want bool) {
+		t.Helper()
+		sent, err := throttle.Notify(ctx, a)
+		if err != nil || sent != want {
+			t.Errorf("Notify(%s/%s) = %v, %v, want %v", a.Rule, a.Workflow, sent, err, want)
+		}
+	}
+
+	notify(firing, true)
+	clk.Advance(59 * time.Minute)
+	notify(firing, false)
+	// Other rules and workflows have cooldowns of their own
+	notify(alert.Alert{Rule: "queue_backlog", Workflow: "generator"}, true)
+	notify(alert.Alert{Rule: "failure_rate", Workflow: "processor"}, true)
+	clk.Advance(time.Minute)
+	notify(firing, true)
+
+	// An alert that failed to send does not start the cooldown
+	clk.Advance(time.Hour)
+	n.err = errors.New("webhook down")
+	if sent, err := throttle.Notify(ctx, firing); sent || err == nil {
+		t.Errorf("Notify() with the webhook down = %v, %v, want an error", sent, err)
+	}
+	n.err = nil
+	notify(firing, true)
+	if len(n.sent) != 5 {
+		t.Errorf("sent %d alerts, want 5", len(n.sent))
+	}
+}
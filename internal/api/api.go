@@ -0,0 +1,588 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// LoadFunc returns the selected variant of an image decoded
+type LoadFunc func(ctx context.Context, img *domain.Image) ([]byte, error)
+
+// Option configures the API handler
+type Option func(*handler)
+
+// WithSnapDimensions rounds submitted sizes onto the supported grid instead of rejecting them
+func WithSnapDimensions() Option {
+	return func(h *handler) {
+		h.snapDimensions = true
+	}
+}
+
+// WithLogger logs failed requests to the given logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *handler) {
+		h.logger = logger
+	}
+}
+
+// WithRequestDefaults validates queued images with the parameters they leave out taken from
+// defaults, normally the configured generation defaults
+func WithRequestDefaults(defaults domain.ImageGenerationRequest) Option {
+	return func(h *handler) {
+		h.defaults = defaults
+	}
+}
+
+// WithMaxPromptLength rejects prompts longer than n characters
+func WithMaxPromptLength(n int) Option {
+	return func(h *handler) {
+		h.maxPromptLength = n
+	}
+}
+
+// handler serves the image API
+type handler struct {
+	repo            repository.ImageRepository
+	load            LoadFunc
+	token           string
+	snapDimensions  bool
+	defaults        domain.ImageGenerationRequest
+	maxPromptLength int
+	logger          *slog.Logger
+}
+
+// NewHandler serves the image API under /images and the workflow controls under /control.
+// Every request must carry token as a bearer token; errors are returned as JSON with a code and
+// a message.
+func NewHandler(repo repository.ImageRepository, load LoadFunc, token string, opts ...Option) http.Handler {
+	h := &handler{
+		repo:  repo,
+		load:  load,
+		token: token,
+		// Without WithRequestDefaults, images that leave out their size are assumed to be valid
+		defaults: domain.ImageGenerationRequest{Width: domain.MinImageDimension, Height: domain.MinImageDimension, NumImages: 1},
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP authenticates the request and routes it by path and method
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, h.token) {
+		unauthorized(w)
+		return
+	}
+
+	if r.URL.Path == "/control" || strings.HasPrefix(r.URL.Path, "/control/") {
+		h.control(w, r)
+		return
+	}
+
+	if r.URL.Path == "/images" {
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet+", "+http.MethodPost)
+		}
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/images/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+		return
+	}
+	idPart, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idPart)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("invalid image ID %q", idPart))
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.get(w, r, id)
+	case "result":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.result(w, r, id)
+	case "requeue":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.requeue(w, r, id)
+	case "prompt-history":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.promptHistory(w, r, id)
+	case "events":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.events(w, r, id)
+	case "variants":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.variants(w, r, id)
+	default:
+		index, ok := selectAction(action)
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+			return
+		}
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.selectVariant(w, r, id, index)
+	}
+}
+
+// RequireToken serves next only to requests carrying token as a bearer token, answering the others
+// like the image API does
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r carries token as a bearer token; an empty token rejects everything
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// unauthorized rejects a request without a valid bearer token
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="images"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token")
+}
+
+// createRequest is the body of POST /images
+type createRequest struct {
+	Prompt         string   `json:"prompt"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	Style          string   `json:"style"`
+	NegativePrompt string   `json:"negative_prompt"`
+	Priority       int      `json:"priority"`
+	CallbackURL    string   `json:"callback_url"`
+	Tags           []string `json:"tags"`
+	// Params are passed verbatim to the provider; numbers keep their precision as json.Number
+	Params map[string]interface{} `json:"params"`
+}
+
+// create queues a new image
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	opts, err := h.createOptions(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	id, err := h.repo.CreateImage(r.Context(), req.Prompt, opts...)
+	if err != nil {
+		h.internalError(w, r, "failed to queue image", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/images/%d", id))
+	writeJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// createOptions validates req and returns the repository options it asks for
+func (h *handler) createOptions(req *createRequest) ([]repository.CreateOption, error) {
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if (req.Width == 0) != (req.Height == 0) {
+		return nil, errors.New("width and height must be given together")
+	}
+	if req.Width != 0 && h.snapDimensions {
+		req.Width, req.Height = domain.SnapDimension(req.Width), domain.SnapDimension(req.Height)
+	}
+	if err := h.generationRequest(req).Validate(domain.WithMaxPromptLength(h.maxPromptLength)); err != nil {
+		return nil, err
+	}
+
+	var opts []repository.CreateOption
+	if req.Width != 0 {
+		opts = append(opts, repository.WithSize(req.Width, req.Height))
+	}
+	if req.Style != "" {
+		opts = append(opts, repository.WithStyle(req.Style))
+	}
+	if req.NegativePrompt != "" {
+		opts = append(opts, repository.WithNegativePrompt(req.NegativePrompt))
+	}
+	if req.Priority != 0 {
+		opts = append(opts, repository.WithPriority(req.Priority))
+	}
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("callback_url %q must be an absolute http or https URL", req.CallbackURL)
+		}
+		opts = append(opts, repository.WithCallbackURL(req.CallbackURL))
+	}
+	if len(req.Tags) > 0 {
+		for i, tag := range req.Tags {
+			req.Tags[i] = strings.TrimSpace(tag)
+			if req.Tags[i] == "" {
+				return nil, errors.New("tags must not be empty")
+			}
+		}
+		opts = append(opts, repository.WithTags(req.Tags...))
+	}
+	if len(req.Params) > 0 {
+		opts = append(opts, repository.WithParams(req.Params))
+	}
+	return opts, nil
+}
+
+// generationRequest returns the request the generator will send for req, with the parameters
+// req leaves out taken from the defaults
+func (h *handler) generationRequest(req *createRequest) domain.ImageGenerationRequest {
+	gen := h.defaults
+	gen.Prompt = req.Prompt
+	if req.Width != 0 {
+		gen.Width, gen.Height = req.Width, req.Height
+	}
+	if req.Style != "" {
+		gen.Style = req.Style
+	}
+	return gen
+}
+
+// metadataResponse describes the first generated file of an image
+type metadataResponse struct {
+	Width                int    `json:"width"`
+	Height               int    `json:"height"`
+	ByteSize             int    `json:"byte_size"`
+	Format               string `json:"format"`
+	GenerationDurationMS int64  `json:"generation_duration_ms"`
+}
+
+// imageResponse is the body of GET /images/{id}; it never includes image data
+type imageResponse struct {
+	ID              int                    `json:"id"`
+	Prompt          string                 `json:"prompt"`
+	Status          domain.ImageStatus     `json:"status"`
+	UUID            string                 `json:"uuid,omitempty"`
+	Provider        string                 `json:"provider,omitempty"`
+	Width           int                    `json:"width,omitempty"`
+	Height          int                    `json:"height,omitempty"`
+	Style           string                 `json:"style,omitempty"`
+	NegativePrompt  string                 `json:"negative_prompt,omitempty"`
+	Seed            int64                  `json:"seed,omitempty"`
+	Priority        int                    `json:"priority"`
+	Tags            []string               `json:"tags"`
+	Params          map[string]interface{} `json:"params,omitempty"`
+	Censored        bool                   `json:"censored"`
+	Error           string                 `json:"error,omitempty"`
+	RawResponse     json.RawMessage        `json:"raw_response,omitempty"`
+	Variants        int                    `json:"variants"`
+	SelectedVariant int                    `json:"selected_variant"`
+	Metadata        *metadataResponse      `json:"metadata,omitempty"`
+	CallbackURL     string                 `json:"callback_url,omitempty"`
+	FileURL         string                 `json:"file_url,omitempty"`
+	PublishedAt     *time.Time             `json:"published_at,omitempty"`
+	PublishedURL    string                 `json:"published_url,omitempty"`
+	Attempts        int                    `json:"attempts"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// newImageResponse describes img without its image data
+func newImageResponse(img *domain.Image) imageResponse {
+	resp := imageResponse{
+		ID:              img.ID,
+		Prompt:          img.Prompt,
+		Status:          img.Status,
+		UUID:            img.UUID,
+		Provider:        img.Provider,
+		Width:           img.Width,
+		Height:          img.Height,
+		Style:           img.Style,
+		NegativePrompt:  img.NegativePrompt,
+		Seed:            img.Seed,
+		Priority:        img.Priority,
+		Tags:            img.Tags,
+		Params:          img.Params,
+		Censored:        img.Censored,
+		Error:           img.ErrorDescription,
+		RawResponse:     img.RawResponse,
+		Variants:        img.Variants,
+		SelectedVariant: img.SelectedVariant,
+		CallbackURL:     img.CallbackURL,
+		FileURL:         img.FileURL,
+		PublishedURL:    img.PublishedURL,
+		Attempts:        img.Attempts,
+		CreatedAt:       img.CreatedAt,
+		UpdatedAt:       img.UpdatedAt,
+	}
+	if img.Metadata.Format != "" {
+		resp.Metadata = &metadataResponse{
+			Width:                img.Metadata.Width,
+			Height:               img.Metadata.Height,
+			ByteSize:             img.Metadata.ByteSize,
+			Format:               img.Metadata.Format,
+			GenerationDurationMS: img.Metadata.GenerationDuration.Milliseconds(),
+		}
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	if !img.PublishedAt.IsZero() {
+		resp.PublishedAt = &img.PublishedAt
+	}
+	return resp
+}
+
+// get returns the status and metadata of an image
+func (h *handler) get(w http.ResponseWriter, r *http.Request, id int) {
+	img, ok := h.image(w, r, id)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newImageResponse(img))
+}
+
+// result streams the selected variant of an image
+func (h *handler) result(w http.ResponseWriter, r *http.Request, id int) {
+	img, ok := h.image(w, r, id)
+	if !ok {
+		return
+	}
+	if !img.HasResult() {
+		writeError(w, http.StatusNotFound, "no_result", fmt.Sprintf("image %d has no result, its status is %s", id, img.Status))
+		return
+	}
+
+	data, err := h.load(r.Context(), img)
+	if err != nil {
+		h.internalError(w, r, "failed to load result", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// requeue queues an image for generation again
+func (h *handler) requeue(w http.ResponseWriter, r *http.Request, id int) {
+	img, ok := h.image(w, r, id)
+	if !ok {
+		return
+	}
+
+	requeued, err := h.repo.Requeue(repository.ContextWithActor(r.Context(), "api"), id)
+	if err != nil {
+		h.internalError(w, r, "failed to requeue image", err)
+		return
+	}
+	if !requeued {
+		writeError(w, http.StatusConflict, "conflict", fmt.Sprintf("image %d is %s and cannot be requeued", id, img.Status))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "status": domain.StatusReadyToGenerate})
+}
+
+// promptHistory lists the revisions of the prompt of an image, starting with the author's
+func (h *handler) promptHistory(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := h.image(w, r, id); !ok {
+		return
+	}
+
+	revisions, err := h.repo.GetPromptHistory(r.Context(), id)
+	if err != nil {
+		h.internalError(w, r, "failed to get prompt history", err)
+		return
+	}
+	if revisions == nil {
+		revisions = []domain.PromptRevision{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": revisions})
+}
+
+// events lists the status transitions of an image, oldest first
+func (h *handler) events(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := h.image(w, r, id); !ok {
+		return
+	}
+
+	events, err := h.repo.GetEvents(r.Context(), id)
+	if err != nil {
+		h.internalError(w, r, "failed to get events", err)
+		return
+	}
+	if events == nil {
+		events = []domain.ImageEvent{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": events})
+}
+
+// variantResponse describes a variant of an image without its image data
+type variantResponse struct {
+	Index         int       `json:"index"`
+	Provider      string    `json:"provider,omitempty"`
+	FilePath      string    `json:"file_path,omitempty"`
+	ProcessedPath string    `json:"processed_path,omitempty"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	Selected      bool      `json:"selected"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// variants lists the variants of an image
+func (h *handler) variants(w http.ResponseWriter, r *http.Request, id int) {
+	img, ok := h.image(w, r, id)
+	if !ok {
+		return
+	}
+
+	variants, err := h.repo.ListVariants(r.Context(), id)
+	if err != nil {
+		h.internalError(w, r, "failed to list variants", err)
+		return
+	}
+
+	items := make([]variantResponse, 0, len(variants))
+	for _, v := range variants {
+		items = append(items, variantResponse{
+			Index:         v.Index,
+			Provider:      v.Provider,
+			FilePath:      v.FilePath,
+			ProcessedPath: v.ProcessedPath,
+			Width:         v.Width,
+			Height:        v.Height,
+			Selected:      v.Index == img.SelectedVariant,
+			CreatedAt:     v.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+// selectAction parses the index out of a variants/{index}/select action
+func selectAction(action string) (int, bool) {
+	rest, ok := strings.CutPrefix(action, "variants/")
+	if !ok {
+		return 0, false
+	}
+	indexPart, ok := strings.CutSuffix(rest, "/select")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(indexPart)
+	return index, err == nil && index >= 0
+}
+
+// selectVariant makes a variant of an image the one that is published and served as its result
+func (h *handler) selectVariant(w http.ResponseWriter, r *http.Request, id, index int) {
+	if _, ok := h.image(w, r, id); !ok {
+		return
+	}
+
+	selected, err := h.repo.SelectVariant(r.Context(), id, index)
+	if err != nil {
+		h.internalError(w, r, "failed to select variant", err)
+		return
+	}
+	if !selected {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("image %d has no variant %d", id, index))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "selected_variant": index})
+}
+
+// image loads the image with the given ID, writing an error response when it cannot
+func (h *handler) image(w http.ResponseWriter, r *http.Request, id int) (*domain.Image, bool) {
+	img, err := h.repo.GetImage(r.Context(), id)
+	if err != nil {
+		h.internalError(w, r, "failed to load image", err)
+		return nil, false
+	}
+	if img == nil {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("image %d not found", id))
+		return nil, false
+	}
+	return img, true
+}
+
+// internalError logs err and answers with a generic message that does not leak it
+func (h *handler) internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.logger.Error("API request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeError(w, http.StatusInternalServerError, "internal", message)
+}
+
+// errorResponse is the body of every failed request
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// errorBody carries a stable machine-readable code and a human-readable message
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes a JSON error with the given status code
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: errorBody{Code: code, Message: message}})
+}
+
+// methodNotAllowed rejects a request whose path only supports allowed
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("only %s is allowed", allowed))
+}
+
+// writeJSON writes body with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,361 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/api"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+const testToken = "api-token"
+
+// pngData is the start of a PNG file, enough to detect its content type
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// newAPI serves the API over an empty memory repository; results load as pngData
+func newAPI(t *testing.T, opts ...api.Option) (http.Handler, *repository.MemoryImageRepository) {
+	t.Helper()
+	repo := repository.NewMemoryImageRepository()
+	load := func(ctx context.Context, img *domain.Image) ([]byte, error) {
+		return pngData, nil
+	}
+	return api.NewHandler(repo, load, testToken, opts...), repo
+}
+
+// discardLogger returns a logger that drops everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// do sends a request with the test token to h
+func do(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals the JSON body of rec into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+	}
+}
+
+// apiError is the JSON body of failed requests
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// wantError fails the test unless rec is a JSON error with the given status and code
+func wantError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) apiError {
+	t.Helper()
+	var body apiError
+	decode(t, rec, &body)
+	if rec.Code != status || body.Error.Code != code || body.Error.Message == "" {
+		t.Errorf("response = %d %s, want %d with error code %s and a message", rec.Code, rec.Body, status, code)
+	}
+	return body
+}
+
+// withResult stores a generated variant for the image with the given ID
+func withResult(t *testing.T, repo repository.ImageRepository, id int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := repo.SaveVariants(ctx, id, []domain.ImageVariant{{Index: 0, Provider: "fake", Data: "iVBORw0KGgo="}}); err != nil {
+		t.Fatalf("SaveVariants() error = %v", err)
+	}
+	if err := repo.UpdateStatus(ctx, id, domain.StatusReadyToPublish); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	h, _ := newAPI(t)
+	for _, header := range []string{"", "Bearer wrong-token", "Basic " + testToken, testToken} {
+		req := httptest.NewRequest(http.MethodGet, "/images", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		wantError(t, rec, http.StatusUnauthorized, "unauthorized")
+		if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: 401 without WWW-Authenticate", header)
+		}
+	}
+
+	// An empty token rejects every request, even one with an empty bearer token
+	empty := api.NewHandler(repository.NewMemoryImageRepository(), nil, "")
+	req := httptest.NewRequest(http.MethodGet, "/images", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	empty.ServeHTTP(rec, req)
+	wantError(t, rec, http.StatusUnauthorized, "unauthorized")
+}
+
+func TestCreateImage(t *testing.T) {
+	h, repo := newAPI(t)
+	rec := do(t, h, http.MethodPost, "/images", `{
+		"prompt": "  a lighthouse  ",
+		"width": 512,
+		"height": 768,
+		"style": "ANIME",
+		"negative_prompt": "people",
+		"priority": 5,
+		"callback_url": "https://example.com/hook",
+		"tags": ["coast"]
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /images = %d %s, want 201", rec.Code, rec.Body)
+	}
+	var created struct{ ID int }
+	decode(t, rec, &created)
+	if loc := rec.Header().Get("Location"); loc != "/images/1" || created.ID != 1 {
+		t.Errorf("POST /images = ID %d at %q, want ID 1 at /images/1", created.ID, loc)
+	}
+
+	img, err := repo.GetImage(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+	if img.Prompt != "a lighthouse" || img.Width != 512 || img.Height != 768 || img.Style != "ANIME" ||
+		img.NegativePrompt != "people" || img.Priority != 5 || img.CallbackURL != "https://example.com/hook" ||
+		len(img.Tags) != 1 || img.Status != domain.StatusReadyToGenerate {
+		t.Errorf("queued image = %+v, want the submitted fields", img)
+	}
+}
+
+func TestCreateImageValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "not JSON", body: `prompt=a lighthouse`},
+		{name: "unknown field", body: `{"prompt": "a lighthouse", "colour": "red"}`},
+		{name: "empty prompt", body: `{"prompt": "   "}`},
+		{name: "prompt too long", body: `{"prompt": "` + strings.Repeat("a", 101) + `"}`},
+		{name: "width without height", body: `{"prompt": "a lighthouse", "width": 512}`},
+		{name: "size off the grid", body: `{"prompt": "a lighthouse", "width": 500, "height": 500}`},
+		{name: "relative callback", body: `{"prompt": "a lighthouse", "callback_url": "/hook"}`},
+		{name: "callback scheme", body: `{"prompt": "a lighthouse", "callback_url": "ftp://example.com/hook"}`},
+		{name: "empty tag", body: `{"prompt": "a lighthouse", "tags": [" "]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, repo := newAPI(t, api.WithMaxPromptLength(100))
+			wantError(t, do(t, h, http.MethodPost, "/images", tt.body), http.StatusBadRequest, "invalid_request")
+			if n, _ := repo.CountImages(context.Background(), repository.ListFilter{}); n != 0 {
+				t.Errorf("repository holds %d images after a rejected request, want none", n)
+			}
+		})
+	}
+}
+
+func TestCreateImageReportsEveryViolation(t *testing.T) {
+	h, _ := newAPI(t, api.WithMaxPromptLength(100))
+	body := wantError(t, do(t, h, http.MethodPost, "/images", `{"prompt": "`+strings.Repeat("a", 101)+`", "width": 500, "height": 4096, "style": "OIL"}`), http.StatusBadRequest, "invalid_request")
+	// The API answers with the violations of domain.ImageGenerationRequest.Validate
+	for _, want := range []string{
+		"prompt has 101 characters, at most 100 are allowed",
+		"width 500 must be a multiple of 64",
+		"height 4096 must be between 128 and 2048",
+		`unknown style "OIL"`,
+	} {
+		if !strings.Contains(body.Error.Message, want) {
+			t.Errorf("error message = %q, want it to contain %q", body.Error.Message, want)
+		}
+	}
+}
+
+func TestCreateImageSnapsDimensions(t *testing.T) {
+	h, repo := newAPI(t, api.WithSnapDimensions())
+	rec := do(t, h, http.MethodPost, "/images", `{"prompt": "a lighthouse", "width": 500, "height": 700}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /images = %d %s, want 201", rec.Code, rec.Body)
+	}
+	img, _ := repo.GetImage(context.Background(), 1)
+	if img.Width != domain.SnapDimension(500) || img.Height != domain.SnapDimension(700) {
+		t.Errorf("queued size = %dx%d, want it snapped onto the grid", img.Width, img.Height)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	h, repo := newAPI(t)
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse", repository.WithTags("coast"))
+	withResult(t, repo, id)
+
+	rec := do(t, h, http.MethodGet, "/images/1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /images/1 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	var fields map[string]interface{}
+	decode(t, rec, &fields)
+	if fields["id"] != 1.0 || fields["prompt"] != "a lighthouse" || fields["status"] != string(domain.StatusReadyToPublish) || fields["variants"] != 1.0 {
+		t.Errorf("GET /images/1 = %s, want the image with its status and variants", rec.Body)
+	}
+	// The image data itself is only served by the result endpoint
+	for _, field := range []string{"base64", "data", "image", "file"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("GET /images/1 includes %q", field)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "iVBORw0KGgo") {
+		t.Error("GET /images/1 includes the image data")
+	}
+}
+
+func TestPromptHistory(t *testing.T) {
+	h, repo := newAPI(t)
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse on a cliff at dawn")
+	repo.AppendPromptRevision(context.Background(), id, "a lighthouse", domain.PromptSourceTruncation)
+
+	rec := do(t, h, http.MethodGet, "/images/1/prompt-history", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /images/1/prompt-history = %d %s, want 200", rec.Code, rec.Body)
+	}
+	var page struct {
+		Items []domain.PromptRevision `json:"items"`
+	}
+	decode(t, rec, &page)
+	if len(page.Items) != 2 || page.Items[0].Source != domain.PromptSourceAuthor || page.Items[1].Source != domain.PromptSourceTruncation || page.Items[1].Text != "a lighthouse" {
+		t.Errorf("GET /images/1/prompt-history = %s, want the author's revision and the truncation", rec.Body)
+	}
+
+	wantError(t, do(t, h, http.MethodGet, "/images/2/prompt-history", ""), http.StatusNotFound, "not_found")
+}
+
+func TestEvents(t *testing.T) {
+	h, repo := newAPI(t)
+	ctx := repository.ContextWithActor(context.Background(), "generator")
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+	repo.UpdateStatus(ctx, id, domain.StatusGenerate)
+	repo.UpdateStatusWithError(repository.ContextWithActor(ctx, "processor"), id, domain.StatusFailed, "internal error")
+
+	rec := do(t, h, http.MethodGet, "/images/1/events", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /images/1/events = %d %s, want 200", rec.Code, rec.Body)
+	}
+	var page struct {
+		Items []domain.ImageEvent `json:"items"`
+	}
+	decode(t, rec, &page)
+	if len(page.Items) != 2 ||
+		page.Items[0].To != domain.StatusGenerate || page.Items[0].Actor != "generator" ||
+		page.Items[1].From != domain.StatusGenerate || page.Items[1].To != domain.StatusFailed ||
+		page.Items[1].Actor != "processor" || page.Items[1].Reason != "internal error" {
+		t.Errorf("GET /images/1/events = %s, want the submission and the failure in order", rec.Body)
+	}
+
+	// An image without transitions has an empty list rather than null
+	repo.CreateImage(ctx, "a harbour")
+	if rec := do(t, h, http.MethodGet, "/images/2/events", ""); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"items":[]}` {
+		t.Errorf("GET /images/2/events = %d %s, want an empty list", rec.Code, rec.Body)
+	}
+
+	wantError(t, do(t, h, http.MethodGet, "/images/3/events", ""), http.StatusNotFound, "not_found")
+}
+
+func TestGetImageNotFound(t *testing.T) {
+	h, _ := newAPI(t)
+	for _, path := range []string{"/images/1", "/images/0", "/images/-1", "/images/abc", "/images/1/unknown", "/unknown"} {
+		wantError(t, do(t, h, http.MethodGet, path, ""), http.StatusNotFound, "not_found")
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h, repo := newAPI(t)
+	repo.CreateImage(context.Background(), "a lighthouse")
+	tests := []struct {
+		method, path, allow string
+	}{
+		{http.MethodDelete, "/images", "GET, POST"},
+		{http.MethodPost, "/images/1", "GET"},
+		{http.MethodDelete, "/images/1/result", "GET"},
+		{http.MethodGet, "/images/1/requeue", "POST"},
+	}
+	for _, tt := range tests {
+		rec := do(t, h, tt.method, tt.path, "")
+		wantError(t, rec, http.StatusMethodNotAllowed, "method_not_allowed")
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
+
+func TestGetResult(t *testing.T) {
+	h, repo := newAPI(t)
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse")
+
+	// An image that was not generated yet has no result
+	wantError(t, do(t, h, http.MethodGet, "/images/1/result", ""), http.StatusNotFound, "no_result")
+
+	withResult(t, repo, id)
+	rec := do(t, h, http.MethodGet, "/images/1/result", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != string(pngData) {
+		t.Fatalf("GET /images/1/result = %d %q, want the image data", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", ct)
+	}
+}
+
+func TestGetResultLoadError(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	load := func(ctx context.Context, img *domain.Image) ([]byte, error) {
+		return nil, errors.New("open /var/lib/images/1.png: permission denied")
+	}
+	h := api.NewHandler(repo, load, testToken, api.WithLogger(discardLogger()))
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse")
+	withResult(t, repo, id)
+
+	body := wantError(t, do(t, h, http.MethodGet, "/images/1/result", ""), http.StatusInternalServerError, "internal")
+	if strings.Contains(body.Error.Message, "/var/lib") {
+		t.Errorf("error message %q leaks the internal error", body.Error.Message)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	h, repo := newAPI(t)
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse")
+
+	// A queued image is not finished, so there is nothing to requeue
+	wantError(t, do(t, h, http.MethodPost, "/images/1/requeue", ""), http.StatusConflict, "conflict")
+
+	if err := repo.MarkFailed(context.Background(), id, "internal error", "permanent"); err != nil {
+		t.Fatalf("MarkFailed() error = %v", err)
+	}
+	rec := do(t, h, http.MethodPost, "/images/1/requeue", "")
+	var body struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	decode(t, rec, &body)
+	if rec.Code != http.StatusOK || body.ID != id || body.Status != string(domain.StatusReadyToGenerate) {
+		t.Errorf("POST /images/1/requeue = %d %s, want 200 with the image queued", rec.Code, rec.Body)
+	}
+	img, _ := repo.GetImage(context.Background(), id)
+	if img.Status != domain.StatusReadyToGenerate {
+		t.Errorf("image has status %s after the requeue, want %s", img.Status, domain.StatusReadyToGenerate)
+	}
+
+	wantError(t, do(t, h, http.MethodPost, "/images/2/requeue", ""), http.StatusNotFound, "not_found")
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// controlResponse is the pause state of a workflow in the /control responses
+type controlResponse struct {
+	Workflow string `json:"workflow"`
+	Paused   bool   `json:"paused"`
+	// UpdatedAt is when the workflow was last paused or resumed, absent if it never was
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// control routes the /control endpoints: GET /control lists the pause state of every workflow,
+// and POST /control/pause and /control/resume change it for the workflow query parameter
+func (h *handler) control(w http.ResponseWriter, r *http.Request) {
+	switch action := strings.TrimPrefix(r.URL.Path, "/control"); action {
+	case "", "/":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.listControls(w, r)
+	case "/pause", "/resume":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.setPaused(w, r, action == "/pause")
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	}
+}
+
+// listControls returns the pause state of every workflow
+func (h *handler) listControls(w http.ResponseWriter, r *http.Request) {
+	controls, err := h.controls(r)
+	if err != nil {
+		h.internalError(w, r, "failed to get workflow controls", err)
+		return
+	}
+
+	items := make([]controlResponse, 0, len(domain.Workflows))
+	for _, workflow := range domain.Workflows {
+		items = append(items, controls[workflow])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"workflows": items})
+}
+
+// setPaused pauses or resumes the workflow named by the workflow query parameter
+func (h *handler) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	workflow := r.URL.Query().Get("workflow")
+	if !domain.IsWorkflow(workflow) {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("workflow must be one of %s", strings.Join(domain.Workflows, ", ")))
+		return
+	}
+
+	if err := h.repo.SetPaused(r.Context(), workflow, paused); err != nil {
+		h.internalError(w, r, "failed to update workflow control", err)
+		return
+	}
+	controls, err := h.controls(r)
+	if err != nil {
+		h.internalError(w, r, "failed to get workflow controls", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, controls[workflow])
+}
+
+// controls returns the pause state of every workflow by name
+func (h *handler) controls(r *http.Request) (map[string]controlResponse, error) {
+	stored, err := h.repo.GetControls(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	controls := make(map[string]controlResponse, len(domain.Workflows))
+	for _, workflow := range domain.Workflows {
+		controls[workflow] = controlResponse{Workflow: workflow}
+	}
+	for _, c := range stored {
+		resp := controlResponse{Workflow: c.Workflow, Paused: c.Paused}
+		if updatedAt := c.UpdatedAt; !updatedAt.IsZero() {
+			resp.UpdatedAt = &updatedAt
+		}
+		controls[c.Workflow] = resp
+	}
+	return controls, nil
+}
@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// controlState is a workflow in the /control responses
+type controlState struct {
+	Workflow  string  `json:"workflow"`
+	Paused    bool    `json:"paused"`
+	UpdatedAt *string `json:"updated_at"`
+}
+
+func TestControl(t *testing.T) {
+	h, repo := newAPI(t)
+
+	// Every workflow is listed, running until it is first paused
+	var list struct {
+		Workflows []controlState `json:"workflows"`
+	}
+	rec := do(t, h, http.MethodGet, "/control", "")
+	decode(t, rec, &list)
+	if rec.Code != http.StatusOK || len(list.Workflows) == 0 {
+		t.Fatalf("GET /control = %d %s, want every workflow", rec.Code, rec.Body)
+	}
+	for _, w := range list.Workflows {
+		if w.Paused || w.UpdatedAt != nil {
+			t.Errorf("workflow %+v, want it running and never paused", w)
+		}
+	}
+
+	var state controlState
+	rec = do(t, h, http.MethodPost, "/control/pause?workflow=generator", "")
+	decode(t, rec, &state)
+	if rec.Code != http.StatusOK || state.Workflow != "generator" || !state.Paused || state.UpdatedAt == nil {
+		t.Errorf("POST /control/pause = %d %s, want the generator paused", rec.Code, rec.Body)
+	}
+	// The pause is stored, where the workflows read it
+	controls, err := repo.GetControls(context.Background())
+	if err != nil || len(controls) != 1 || controls[0].Workflow != "generator" || !controls[0].Paused {
+		t.Errorf("GetControls() = %+v, %v, want the generator paused", controls, err)
+	}
+
+	rec = do(t, h, http.MethodPost, "/control/resume?workflow=generator", "")
+	decode(t, rec, &state)
+	if rec.Code != http.StatusOK || state.Paused {
+		t.Errorf("POST /control/resume = %d %s, want the generator running", rec.Code, rec.Body)
+	}
+
+	wantError(t, do(t, h, http.MethodPost, "/control/pause?workflow=everything", ""), http.StatusBadRequest, "invalid_request")
+	wantError(t, do(t, h, http.MethodPost, "/control/pause", ""), http.StatusBadRequest, "invalid_request")
+	wantError(t, do(t, h, http.MethodPost, "/control/stop?workflow=generator", ""), http.StatusNotFound, "not_found")
+	if rec := do(t, h, http.MethodGet, "/control/pause?workflow=generator", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /control/pause = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Pagination limits of GET /images
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// sortColumns maps the sort query parameter of GET /images to repository columns
+var sortColumns = map[string]string{
+	"created_at": repository.SortByCreatedAt,
+	"updated_at": repository.SortByUpdatedAt,
+}
+
+// listResponse is the body of GET /images; its items are imageResponse values, or
+// domain.ImageSummary values with view=summary
+type listResponse struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// listQuery is the parsed query of GET /images
+type listQuery struct {
+	filter repository.ListFilter
+	// summary lists domain.ImageSummary values instead of full images
+	summary bool
+}
+
+// list returns a page of images matching the query, without their image data
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	query, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	filter := query.filter
+
+	images, err := h.repo.ListImages(r.Context(), filter)
+	if err != nil {
+		h.internalError(w, r, "failed to list images", err)
+		return
+	}
+	total, err := h.repo.CountImages(r.Context(), filter)
+	if err != nil {
+		h.internalError(w, r, "failed to count images", err)
+		return
+	}
+
+	resp := listResponse{
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}
+	if query.summary {
+		items := make([]domain.ImageSummary, 0, len(images))
+		for _, img := range images {
+			items = append(items, img.Summary())
+		}
+		resp.Items = items
+	} else {
+		items := make([]imageResponse, 0, len(images))
+		for _, img := range images {
+			items = append(items, newImageResponse(img))
+		}
+		resp.Items = items
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseListQuery validates the query parameters of GET /images: status, tag, limit, offset,
+// sort (created_at or updated_at), order (asc or desc) and view (full or summary)
+func parseListQuery(query url.Values) (listQuery, error) {
+	var parsed listQuery
+	parsed.filter = repository.ListFilter{
+		Limit:  defaultListLimit,
+		SortBy: repository.SortByCreatedAt,
+	}
+	filter := &parsed.filter
+
+	for name, values := range query {
+		if len(values) > 1 {
+			return parsed, fmt.Errorf("query parameter %q must be given once", name)
+		}
+		value := values[0]
+
+		switch name {
+		case "status":
+			status := domain.ImageStatus(value)
+			if !status.Valid() {
+				return parsed, fmt.Errorf("unknown status %q, expected one of %s", value, statusList())
+			}
+			filter.Status = status
+		case "tag":
+			if value = strings.TrimSpace(value); value == "" {
+				return parsed, fmt.Errorf("tag must not be empty")
+			}
+			filter.Tag = value
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxListLimit {
+				return parsed, fmt.Errorf("limit %q must be a number between 1 and %d", value, maxListLimit)
+			}
+			filter.Limit = limit
+		case "offset":
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return parsed, fmt.Errorf("offset %q must be a number of at least 0", value)
+			}
+			filter.Offset = offset
+		case "sort":
+			column, ok := sortColumns[value]
+			if !ok {
+				return parsed, fmt.Errorf("cannot sort by %q, expected created_at or updated_at", value)
+			}
+			filter.SortBy = column
+		case "order":
+			switch value {
+			case "asc":
+				filter.Ascending = true
+			case "desc":
+				filter.Ascending = false
+			default:
+				return parsed, fmt.Errorf("order %q must be asc or desc", value)
+			}
+		case "view":
+			switch value {
+			case "full":
+				parsed.summary = false
+			case "summary":
+				parsed.summary = true
+			default:
+				return parsed, fmt.Errorf("view %q must be full or summary", value)
+			}
+		default:
+			return parsed, fmt.Errorf("unknown query parameter %q, expected status, tag, limit, offset, sort, order or view", name)
+		}
+	}
+
+	return parsed, nil
+}
+
+// statusList returns the statuses GET /images can filter by in alphabetical order
+func statusList() string {
+	names := make([]string, 0, len(domain.Statuses))
+	for _, s := range domain.Statuses {
+		names = append(names, string(s))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
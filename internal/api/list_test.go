@@ -0,0 +1,192 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// listPage is the body of GET /images with the fields the tests look at
+type listPage struct {
+	Items []struct {
+		ID     int                `json:"id"`
+		Status domain.ImageStatus `json:"status"`
+		Tags   []string           `json:"tags"`
+	} `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// ids returns the IDs of the listed images in order
+func (p listPage) ids() []int {
+	ids := make([]int, 0, len(p.Items))
+	for _, item := range p.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// list requests GET path and decodes the page
+func list(t *testing.T, h http.Handler, path string) listPage {
+	t.Helper()
+	rec := do(t, h, http.MethodGet, path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s, want 200", path, rec.Code, rec.Body)
+	}
+	var page listPage
+	decode(t, rec, &page)
+	return page
+}
+
+func TestListImagesFilters(t *testing.T) {
+	h, repo := newAPI(t)
+	ctx := context.Background()
+	// Images 1-6: odd ones tagged blog, 2 and 5 failed
+	for i := 1; i <= 6; i++ {
+		var opts []repository.CreateOption
+		if i%2 == 1 {
+			opts = append(opts, repository.WithTags("blog"))
+		}
+		if _, err := repo.CreateImage(ctx, "a lighthouse", opts...); err != nil {
+			t.Fatalf("CreateImage() error = %v", err)
+		}
+	}
+	for _, id := range []int{2, 5} {
+		if err := repo.MarkFailed(ctx, id, "internal error", "permanent"); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		query     string
+		wantIDs   []int
+		wantTotal int
+	}{
+		{query: "", wantIDs: []int{6, 5, 4, 3, 2, 1}, wantTotal: 6},
+		{query: "?status=Failed", wantIDs: []int{5, 2}, wantTotal: 2},
+		{query: "?tag=blog", wantIDs: []int{5, 3, 1}, wantTotal: 3},
+		{query: "?status=Failed&tag=blog", wantIDs: []int{5}, wantTotal: 1},
+		{query: "?status=ReadyToGenerate&tag=blog&order=asc", wantIDs: []int{1, 3}, wantTotal: 2},
+		{query: "?limit=2", wantIDs: []int{6, 5}, wantTotal: 6},
+		{query: "?limit=2&offset=2", wantIDs: []int{4, 3}, wantTotal: 6},
+		{query: "?limit=4&offset=4", wantIDs: []int{2, 1}, wantTotal: 6},
+		{query: "?tag=blog&limit=1&offset=1&order=asc", wantIDs: []int{3}, wantTotal: 3},
+		{query: "?sort=created_at&order=asc", wantIDs: []int{1, 2, 3, 4, 5, 6}, wantTotal: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page := list(t, h, "/images"+tt.query)
+			if !reflect.DeepEqual(page.ids(), tt.wantIDs) || page.Total != tt.wantTotal {
+				t.Errorf("GET /images%s = IDs %v of %d, want %v of %d", tt.query, page.ids(), page.Total, tt.wantIDs, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestListImagesSortByUpdatedAt(t *testing.T) {
+	h, repo := newAPI(t)
+	createdIDs := make([]int, 3)
+	for i := range createdIDs {
+		createdIDs[i], _ = repo.CreateImage(context.Background(), "a lighthouse")
+	}
+	// The oldest image changes last
+	time.Sleep(time.Millisecond)
+	if err := repo.MarkFailed(context.Background(), createdIDs[0], "internal error", "permanent"); err != nil {
+		t.Fatalf("MarkFailed() error = %v", err)
+	}
+
+	if got := list(t, h, "/images?sort=updated_at").ids(); !reflect.DeepEqual(got, []int{1, 3, 2}) {
+		t.Errorf("sorted by updated_at = %v, want the updated image first", got)
+	}
+	if got := list(t, h, "/images?sort=updated_at&order=asc").ids(); !reflect.DeepEqual(got, []int{2, 3, 1}) {
+		t.Errorf("sorted by updated_at ascending = %v, want the updated image last", got)
+	}
+}
+
+func TestListImagesEmpty(t *testing.T) {
+	h, repo := newAPI(t)
+	// An empty page has an empty items array, never null, and keeps its pagination
+	for query, want := range map[string]string{
+		"":                             `{"items":[],"total":0,"limit":50,"offset":0}`,
+		"?status=Failed":               `{"items":[],"total":0,"limit":50,"offset":0}`,
+		"?tag=none&limit=10&offset=20": `{"items":[],"total":0,"limit":10,"offset":20}`,
+	} {
+		rec := do(t, h, http.MethodGet, "/images"+query, "")
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != want {
+			t.Errorf("GET /images%s = %d %s, want %s", query, rec.Code, rec.Body, want)
+		}
+	}
+
+	// An offset past the last image is an empty page as well, with the total still counted
+	repo.CreateImage(context.Background(), "a lighthouse")
+	page := list(t, h, "/images?offset=5")
+	if page.Items == nil || len(page.Items) != 0 || page.Total != 1 || page.Offset != 5 {
+		t.Errorf("GET /images?offset=5 = %+v, want no items of 1", page)
+	}
+}
+
+func TestListImagesFields(t *testing.T) {
+	h, repo := newAPI(t)
+	id, _ := repo.CreateImage(context.Background(), "a lighthouse", repository.WithTags("blog"))
+	withResult(t, repo, id)
+
+	rec := do(t, h, http.MethodGet, "/images", "")
+	if strings.Contains(rec.Body.String(), "iVBORw0KGgo") {
+		t.Errorf("GET /images = %s, want no image data", rec.Body)
+	}
+	var full struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	decode(t, rec, &full)
+	// The dashboard relies on these names
+	for _, field := range []string{"id", "prompt", "status", "priority", "tags", "censored", "variants", "selected_variant", "attempts", "created_at", "updated_at"} {
+		if _, ok := full.Items[0][field]; !ok {
+			t.Errorf("listed image has no %q field: %v", field, full.Items[0])
+		}
+	}
+
+	rec = do(t, h, http.MethodGet, "/images?view=summary", "")
+	var summary struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	decode(t, rec, &summary)
+	if len(summary.Items) != 1 || summary.Items[0]["id"] != float64(id) || len(summary.Items[0]) >= len(full.Items[0]) {
+		t.Errorf("GET /images?view=summary = %s, want fewer fields than the full view", rec.Body)
+	}
+}
+
+func TestListImagesInvalidQuery(t *testing.T) {
+	h, _ := newAPI(t)
+	tests := []struct {
+		query       string
+		wantMessage string
+	}{
+		{query: "status=Unknown", wantMessage: `unknown status "Unknown", expected one of`},
+		{query: "status=failed", wantMessage: `unknown status "failed"`},
+		{query: "tag=%20", wantMessage: "tag must not be empty"},
+		{query: "limit=0", wantMessage: `limit "0" must be a number between 1 and 200`},
+		{query: "limit=201", wantMessage: `limit "201" must be a number between 1 and 200`},
+		{query: "limit=ten", wantMessage: `limit "ten"`},
+		{query: "offset=-1", wantMessage: `offset "-1" must be a number of at least 0`},
+		{query: "sort=prompt", wantMessage: `cannot sort by "prompt", expected created_at or updated_at`},
+		{query: "order=up", wantMessage: `order "up" must be asc or desc`},
+		{query: "view=thumbnails", wantMessage: `view "thumbnails" must be full or summary`},
+		{query: "status=Failed&status=Published", wantMessage: `query parameter "status" must be given once`},
+		{query: "page=2", wantMessage: `unknown query parameter "page"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			body := wantError(t, do(t, h, http.MethodGet, "/images?"+tt.query, ""), http.StatusBadRequest, "invalid_request")
+			if !strings.Contains(body.Error.Message, tt.wantMessage) {
+				t.Errorf("error message = %q, want it to contain %q", body.Error.Message, tt.wantMessage)
+			}
+		})
+	}
+}
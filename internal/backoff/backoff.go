@@ -0,0 +1,30 @@
+// Package backoff computes the growing, randomized waits between the status checks of a
+// generation, shared by the service and the workflows so both poll a provider alike
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// Factor is the growth of the delay per attempt
+	Factor = 1.5
+	// JitterFraction is the maximum relative deviation Jitter applies to a delay
+	JitterFraction = 0.2
+)
+
+// Next grows the delay by Factor, capped at max; a max of zero means no cap
+func Next(delay, max time.Duration) time.Duration {
+	next := time.Duration(float64(delay) * Factor)
+	if max > 0 && next > max {
+		return max
+	}
+	return next
+}
+
+// Jitter randomizes the delay by up to ±JitterFraction so pollers don't synchronize
+func Jitter(delay time.Duration) time.Duration {
+	offset := (rand.Float64()*2 - 1) * JitterFraction * float64(delay)
+	return delay + time.Duration(offset)
+}
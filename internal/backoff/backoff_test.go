@@ -0,0 +1,39 @@
+package backoff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/backoff"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		delay, max, want time.Duration
+	}{
+		{delay: 2 * time.Second, max: 30 * time.Second, want: 3 * time.Second},
+		{delay: 3 * time.Second, max: 4 * time.Second, want: 4 * time.Second},
+		{delay: 4 * time.Second, max: 4 * time.Second, want: 4 * time.Second},
+		{delay: 40 * time.Second, max: 0, want: 60 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoff.Next(tt.delay, tt.max); got != tt.want {
+			t.Errorf("Next(%v, %v) = %v, want %v", tt.delay, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	const delay = 10 * time.Second
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		got := backoff.Jitter(delay)
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("Jitter(%v) = %v, want within 20%%", delay, got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Jitter(%v) returned %d distinct delays, want them randomized", delay, len(seen))
+	}
+}
@@ -1,16 +1,79 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/basel-ax/2xiang/internal/logging"
+	"github.com/basel-ax/2xiang/pkg/domain"
 	"github.com/joho/godotenv"
+	"github.com/robfig/cron/v3"
 )
 
+// AlertConfig holds the operator alerts evaluated after every workflow pass
+type AlertConfig struct {
+	// WebhookURL is the Slack-compatible webhook alerts are posted to; empty disables alerts
+	WebhookURL string
+	// Cooldown is the minimum time between two alerts of the same rule and workflow
+	Cooldown time.Duration
+	// FailureRate is the share of the images of a pass that may fail before it alerts; 0
+	// disables the rule
+	FailureRate float64
+	// BacklogAge is how long the oldest ReadyToGenerate image may wait before it alerts; 0
+	// disables the rule
+	BacklogAge time.Duration
+	// NoGenerationAfter is how long the processor may go without a finished generation while
+	// images are waiting before it alerts; 0 disables the rule
+	NoGenerationAfter time.Duration
+}
+
+// QueueConfig holds the settings of the queue the generator takes its jobs from
+type QueueConfig struct {
+	// Backend is db, the images table, or redis
+	Backend string
+	// RedisURL is the server of the redis backend, e.g. redis://:password@localhost:6379/0
+	RedisURL string
+	// Prefix is prepended to the Redis keys
+	Prefix string
+	// VisibilityTimeout is how long a delivered job stays hidden from other workers; it is
+	// extended while the job is handled
+	VisibilityTimeout time.Duration
+	// PollInterval is the wait between looks at an empty queue
+	PollInterval time.Duration
+	// MaxAttempts is how often a job that fails transiently is delivered
+	MaxAttempts int
+	// ResultTTL is how long results are kept in Redis; 0 keeps them
+	ResultTTL time.Duration
+}
+
+// EventsConfig holds the settings of the image lifecycle events published to NATS
+type EventsConfig struct {
+	// NATSURL is the server events are published to, e.g. nats://localhost:4222; empty disables
+	// events
+	NATSURL string
+	// NATSUser and NATSPassword, or NATSToken, authenticate with the server
+	NATSUser     string
+	NATSPassword string
+	NATSToken    string
+	// SubjectPrefix is prepended to the event types, e.g. xiang makes xiang.image.generated
+	SubjectPrefix string
+	// MaxAttempts is how often an event is published before it is dropped
+	MaxAttempts int
+}
+
 // DBConfig holds database configuration
 type DBConfig struct {
+	// URL is a postgres:// connection URL that replaces the individual connection settings
+	URL             string
 	Host            string
 	Port            int
 	User            string
@@ -20,121 +83,980 @@ type DBConfig struct {
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
+	// ConnectTimeout bounds establishing a single connection; 0 waits indefinitely
+	ConnectTimeout time.Duration
+	// ConnectRetry is how long startup keeps retrying to reach the database; 0 tries once
+	ConnectRetry time.Duration
+	// ListenNotify wakes the generator through LISTEN/NOTIFY as soon as images are queued
+	ListenNotify bool
+}
+
+// WorkflowConfig holds the knobs shared by the generator, processor and publisher workflows
+type WorkflowConfig struct {
+	// Concurrency is the number of images the generator, or GenerateBatch, submits in parallel
+	Concurrency int
+	// BatchSize bounds the images a single pass of a workflow picks up; 0 means no limit
+	BatchSize int
+	// RetryBaseDelay is the delay after the first failed publishing attempt; it doubles with
+	// every further attempt up to RetryMaxDelay
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// IdleBackoffMax caps the polling interval of idle workflows
+	IdleBackoffMax time.Duration
+	// ErrorBackoffMax caps the polling interval of workflows whose passes keep failing, e.g.
+	// while the database is unreachable
+	ErrorBackoffMax time.Duration
+	// ShutdownTimeout is the grace period of images in flight after SIGINT or SIGTERM
+	ShutdownTimeout time.Duration
+	// WorkerID identifies this process in the claims of the generator
+	WorkerID string
+	// ClaimLease is how long a claimed image stays reserved for the generator without renewal
+	ClaimLease time.Duration
+}
+
+// FusionBrainConfig holds the settings of the Fusion Brain provider
+type FusionBrainConfig struct {
+	APIKey             string
+	SecretKey          string
+	ProxyURL           string
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// OpenAIConfig holds the settings of the OpenAI provider
+type OpenAIConfig struct {
+	APIKey string
+	Model  string
+}
+
+// ReplicateConfig holds the settings of the Replicate provider
+type ReplicateConfig struct {
+	APIToken string
+	Model    string
+	Version  string
+}
+
+// StabilityConfig holds the settings of the Stability AI provider
+type StabilityConfig struct {
+	APIKey string
+	Engine string
 }
 
 // Config holds all configuration for the application
 type Config struct {
-	FusionBrainAPIKey     string
-	FusionBrainSecretKey  string
-	DefaultImageWidth     int
-	DefaultImageHeight    int
-	DefaultNumImages      int
-	DefaultStyle          string
-	DefaultNegativePrompt string
-	GenerationTimeout     time.Duration
-	CheckInterval         time.Duration
-	MaxAttempts           int
-	DB                    DBConfig
-}
-
-// Load loads the configuration from environment variables
-func Load() (*Config, error) {
-	// Load .env file
+	Provider                    string
+	Providers                   []string
+	FusionBrain                 FusionBrainConfig
+	OpenAI                      OpenAIConfig
+	Replicate                   ReplicateConfig
+	Stability                   StabilityConfig
+	APIRPS                      float64
+	APIBurst                    int
+	APIRateLimitExemptPipelines bool
+	DailyRequestCap             int
+	DailySpendCap               float64
+	RequestCost                 float64
+	DefaultImageWidth           int
+	DefaultImageHeight          int
+	SnapDimensions              bool
+	DefaultNumImages            int
+	MaxPromptLength             int
+	DefaultStyle                string
+	DefaultNegativePrompt       string
+	PromptPrefix                string
+	PromptSuffix                string
+	PromptBannedWords           []string
+	PromptRejectTerms           []domain.BannedTerm
+	GenerationTimeout           time.Duration
+	CheckInterval               time.Duration
+	PollMaxInterval             time.Duration
+	MaxAttempts                 int
+	NotFoundMaxResets           int
+	GeneratorInterval           time.Duration
+	ProcessorInterval           time.Duration
+	PerImageTimeout             time.Duration
+	DedupMode                   string
+	LegacyBase64                bool
+	CensoredRequeue             bool
+	CensoredNegativePrompt      string
+	CaptureRawResponses         bool
+	RawResponseMaxBytes         int
+	Storage                     string
+	StorageDir                  string
+	StorageFilename             string
+	S3Bucket                    string
+	S3Prefix                    string
+	S3Region                    string
+	S3Endpoint                  string
+	S3AccessKeyID               string
+	S3SecretAccessKey           string
+	S3UsePathStyle              bool
+	S3PresignTTL                time.Duration
+	PostProcessEnabled          bool
+	PostProcessMaxWidth         int
+	PostProcessMaxHeight        int
+	PostProcessFormat           string
+	PostProcessQuality          int
+	PostProcessKeepOriginal     bool
+	WatermarkFile               string
+	WatermarkPosition           string
+	WatermarkOpacity            float64
+	WatermarkMargin             int
+	ThumbnailMaxEdge            int
+	TelegramBotToken            string
+	TelegramChatID              string
+	PublishMaxAttempts          int
+	PublishS3Bucket             string
+	PublishS3Key                string
+	PublishS3CacheControl       string
+	PublishS3BaseURL            string
+	WebhookSecret               string
+	WebhookMaxAttempts          int
+	HTTPAddr                    string
+	APIToken                    string
+	StatsInterval               time.Duration
+	PprofEnabled                bool
+	PprofMutexFraction          int
+	PprofBlockRate              int
+	SentryDSN                   string
+	SentryEnvironment           string
+	LogFormat                   string
+	LogLevel                    slog.Level
+	LogLevels                   map[string]slog.Level
+	CronGeneratorSpec           string
+	CronProcessorSpec           string
+	CronPublisherSpec           string
+	RequeueFailedInterval       time.Duration
+	RequeueFailedAfter          time.Duration
+	RequeueFailedMaxAttempts    int
+	EventRetention              time.Duration
+	CacheEnabled                bool
+	CacheBackend                string
+	CacheTTL                    time.Duration
+	CacheMaxEntries             int
+	Workflow                    WorkflowConfig
+	Alerts                      AlertConfig
+	Events                      EventsConfig
+	Queue                       QueueConfig
+	DB                          DBConfig
+}
+
+// LoadOption configures Load and LoadWithoutProviders
+type LoadOption func(*loadOptions)
+
+// loadOptions holds the settings of LoadOption
+type loadOptions struct {
+	path       string
+	noDatabase bool
+}
+
+// WithFile reads settings from the YAML or TOML file at path, overriding CONFIG_FILE
+func WithFile(path string) LoadOption {
+	return func(o *loadOptions) {
+		o.path = path
+	}
+}
+
+// WithoutDatabase does not require the database settings, for modes that do not connect to it
+func WithoutDatabase() LoadOption {
+	return func(o *loadOptions) {
+		o.noDatabase = true
+	}
+}
+
+// Load loads the configuration from environment variables, the optional .env file (ENV_FILE
+// names another one) and the YAML or TOML file named by CONFIG_FILE, in that order of
+// precedence, with defaults for anything unset
+func Load(opts ...LoadOption) (*Config, error) {
+	return loadEnv(true, opts)
+}
+
+// LoadWithoutProviders loads the configuration like Load but does not require provider
+// credentials, for commands that only use the database
+func LoadWithoutProviders(opts ...LoadOption) (*Config, error) {
+	return loadEnv(false, opts)
+}
+
+// LoadFrom loads the configuration from a YAML or TOML document, format "yaml" or "toml",
+// mirroring the environment variables: DEFAULT_IMAGE_WIDTH is default_image_width and DB_HOST
+// is host in the db section. Environment variables override the document and defaults apply
+// to anything unset. The .env file is not read.
+func LoadFrom(r io.Reader, format string) (*Config, error) {
+	values, err := readFile(r, format)
+	if err != nil {
+		return nil, err
+	}
+	return loadValues(values, true, true)
+}
+
+// loadEnv loads the .env file and the configuration file into the configuration
+func loadEnv(requireProviders bool, opts []LoadOption) (*Config, error) {
+	o := loadOptions{path: os.Getenv("CONFIG_FILE")}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
+	if o.path == "" {
+		return load(os.Getenv, requireProviders, !o.noDatabase)
+	}
+
+	format, err := formatFromPath(o.path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(o.path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration file: %w", err)
+	}
+	defer f.Close()
+
+	values, err := readFile(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", o.path, err)
+	}
+	cfg, err := loadValues(values, requireProviders, !o.noDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", o.path, err)
+	}
+	return cfg, nil
+}
+
+// loadEnvFile sets the variables of the .env file, or the file named by ENV_FILE, that are not
+// set in the environment already. A missing .env file is skipped, a missing ENV_FILE is an error.
+func loadEnvFile() error {
+	path := os.Getenv("ENV_FILE")
+	if path != "" {
+		if err := godotenv.Load(path); err != nil {
+			return fmt.Errorf("error loading ENV_FILE %s: %w", path, err)
+		}
+		return nil
+	}
+
 	if err := godotenv.Load(); err != nil {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Debug("No .env file, using the environment only")
+			return nil
+		}
+		return fmt.Errorf("error loading .env file: %w", err)
 	}
+	return nil
+}
+
+// loadValues loads the configuration from environment variables falling back to values,
+// rejecting settings of values that are not part of the configuration
+func loadValues(values fileValues, requireProviders, requireDatabase bool) (*Config, error) {
+	used := make(map[string]bool)
+	cfg, err := load(func(name string) string {
+		used[name] = true
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return values[name].value
+	}, requireProviders, requireDatabase)
+	if err != nil {
+		return nil, err
+	}
+	if err := values.unknownKey(used); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
+// load loads the configuration from the settings returned by getenv, validating the settings of
+// the selected providers when requireProviders is set and of the database when requireDatabase is
+func load(getenv func(string) string, requireProviders, requireDatabase bool) (*Config, error) {
+	settings := &settings{getenv: getenv}
+	getenv = settings.lookup
 	config := &Config{
-		FusionBrainAPIKey:    os.Getenv("FUSION_BRAIN_API_KEY"),
-		FusionBrainSecretKey: os.Getenv("FUSION_BRAIN_SECRET_KEY"),
-		DefaultStyle:         os.Getenv("DEFAULT_STYLE"),
+		Provider: getenv("PROVIDER"),
+		FusionBrain: FusionBrainConfig{
+			APIKey:    getenv("FUSION_BRAIN_API_KEY"),
+			SecretKey: getenv("FUSION_BRAIN_SECRET_KEY"),
+			ProxyURL:  getenv("FUSION_BRAIN_PROXY_URL"),
+			CAFile:    getenv("FUSION_BRAIN_CA_FILE"),
+		},
+		OpenAI: OpenAIConfig{
+			APIKey: getenv("OPENAI_API_KEY"),
+			Model:  getenv("OPENAI_MODEL"),
+		},
+		Replicate: ReplicateConfig{
+			APIToken: getenv("REPLICATE_API_TOKEN"),
+			Model:    getenv("REPLICATE_MODEL"),
+			Version:  getenv("REPLICATE_VERSION"),
+		},
+		Stability: StabilityConfig{
+			APIKey: getenv("STABILITY_API_KEY"),
+			Engine: getenv("STABILITY_ENGINE"),
+		},
+		DefaultStyle:          getenv("DEFAULT_STYLE"),
+		DefaultNegativePrompt: getenv("DEFAULT_NEGATIVE_PROMPT"),
+		PromptPrefix:          getenv("PROMPT_PREFIX"),
+		PromptSuffix:          getenv("PROMPT_SUFFIX"),
+	}
+
+	if config.Provider == "" {
+		config.Provider = "fusionbrain" // default value
+	}
+
+	// PROVIDERS lists a fallback chain and takes precedence over PROVIDER; a provider listed
+	// twice is only tried once
+	for _, name := range strings.Split(getenv("PROVIDERS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !slices.Contains(config.Providers, name) {
+			config.Providers = append(config.Providers, name)
+		}
+	}
+	if len(config.Providers) == 0 {
+		config.Providers = []string{config.Provider}
+	}
+	config.Provider = config.Providers[0]
+
+	for _, word := range strings.Split(getenv("PROMPT_BANNED_WORDS"), ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			config.PromptBannedWords = append(config.PromptBannedWords, word)
+		}
+	}
+
+	// Terms prefixed with re: are regular expressions
+	for _, term := range strings.Split(getenv("PROMPT_REJECT_TERMS"), ",") {
+		if term = strings.TrimSpace(term); term == "" {
+			continue
+		}
+		if pattern, ok := strings.CutPrefix(term, "re:"); ok {
+			// An empty pattern would reject every prompt
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			config.PromptRejectTerms = append(config.PromptRejectTerms, domain.BannedTerm{Term: pattern, Regex: true})
+		} else {
+			config.PromptRejectTerms = append(config.PromptRejectTerms, domain.BannedTerm{Term: term})
+		}
+	}
+
+	if insecure, err := settings.parseBool("FUSION_BRAIN_INSECURE_SKIP_VERIFY"); err == nil {
+		config.FusionBrain.InsecureSkipVerify = insecure
+	}
+
+	// Overall request rate of the Fusion Brain client across all workflows; 0 disables the limit
+	if rps, err := settings.parseFloat("API_RPS"); err == nil && rps >= 0 {
+		config.APIRPS = rps
+	}
+
+	if burst, err := settings.atoi("API_BURST"); err == nil && burst > 0 {
+		config.APIBurst = burst
+	} else {
+		config.APIBurst = 1 // default value
+	}
+
+	if exempt, err := settings.parseBool("API_RATE_LIMIT_EXEMPT_PIPELINES"); err == nil {
+		config.APIRateLimitExemptPipelines = exempt
+	}
+
+	// Generation requests per UTC day across all providers after which the generator pauses; 0 disables the cap
+	if limit, err := settings.atoi("DAILY_REQUEST_CAP"); err == nil {
+		config.DailyRequestCap = limit
+	}
+
+	// Spend per UTC day at REQUEST_COST per generation request after which the generator pauses; 0 disables the cap
+	if limit, err := settings.parseFloat("DAILY_SPEND_CAP"); err == nil {
+		config.DailySpendCap = limit
+	}
+
+	if cost, err := settings.parseFloat("REQUEST_COST"); err == nil {
+		config.RequestCost = cost
 	}
 
 	// Load and parse numeric values
-	if width, err := strconv.Atoi(os.Getenv("DEFAULT_IMAGE_WIDTH")); err == nil {
+	if width, err := settings.atoi("DEFAULT_IMAGE_WIDTH"); err == nil {
 		config.DefaultImageWidth = width
 	} else {
 		config.DefaultImageWidth = 1024 // default value
 	}
 
-	if height, err := strconv.Atoi(os.Getenv("DEFAULT_IMAGE_HEIGHT")); err == nil {
+	if height, err := settings.atoi("DEFAULT_IMAGE_HEIGHT"); err == nil {
 		config.DefaultImageHeight = height
 	} else {
 		config.DefaultImageHeight = 1024 // default value
 	}
 
-	if numImages, err := strconv.Atoi(os.Getenv("DEFAULT_NUM_IMAGES")); err == nil {
+	if snap, err := settings.parseBool("SNAP_DIMENSIONS"); err == nil {
+		config.SnapDimensions = snap
+	}
+
+	if numImages, err := settings.atoi("DEFAULT_NUM_IMAGES"); err == nil {
 		config.DefaultNumImages = numImages
 	} else {
 		config.DefaultNumImages = 1 // default value
 	}
 
-	if timeout, err := strconv.Atoi(os.Getenv("DEFAULT_GENERATION_TIMEOUT")); err == nil {
+	if length, err := settings.atoi("MAX_PROMPT_LENGTH"); err == nil {
+		config.MaxPromptLength = length
+	} else {
+		config.MaxPromptLength = 999 // default value
+	}
+
+	if timeout, err := settings.atoi("DEFAULT_GENERATION_TIMEOUT"); err == nil {
 		config.GenerationTimeout = time.Duration(timeout) * time.Second
 	} else {
 		config.GenerationTimeout = 5 * time.Minute // default value
 	}
 
-	if interval, err := strconv.Atoi(os.Getenv("DEFAULT_CHECK_INTERVAL")); err == nil {
+	if interval, err := settings.atoi("DEFAULT_CHECK_INTERVAL"); err == nil {
 		config.CheckInterval = time.Duration(interval) * time.Second
 	} else {
 		config.CheckInterval = 2 * time.Second // default value
 	}
 
-	if attempts, err := strconv.Atoi(os.Getenv("DEFAULT_MAX_ATTEMPTS")); err == nil {
+	if maxInterval, err := settings.atoi("POLL_MAX_INTERVAL"); err == nil {
+		config.PollMaxInterval = time.Duration(maxInterval) * time.Second
+	} else {
+		config.PollMaxInterval = 30 * time.Second // default value
+	}
+
+	if attempts, err := settings.atoi("DEFAULT_MAX_ATTEMPTS"); err == nil {
 		config.MaxAttempts = attempts
 	} else {
 		config.MaxAttempts = 30 // default value
 	}
 
+	if resets, err := settings.atoi("NOT_FOUND_MAX_RESETS"); err == nil {
+		config.NotFoundMaxResets = resets
+	} else {
+		config.NotFoundMaxResets = 3 // default value
+	}
+
+	if interval, err := settings.atoi("GENERATOR_INTERVAL"); err == nil && interval > 0 {
+		config.GeneratorInterval = time.Duration(interval) * time.Second
+	} else {
+		config.GeneratorInterval = 10 * time.Second // default value
+	}
+
+	if interval, err := settings.atoi("PROCESSOR_INTERVAL"); err == nil && interval > 0 {
+		config.ProcessorInterval = time.Duration(interval) * time.Second
+	} else {
+		config.ProcessorInterval = 15 * time.Second // default value
+	}
+
+	// Workflow knobs; durations are Go durations such as 30s, or whole seconds
+	config.Workflow = WorkflowConfig{
+		Concurrency:     4,                // default value
+		BatchSize:       100,              // default value
+		RetryBaseDelay:  30 * time.Second, // default value
+		RetryMaxDelay:   time.Hour,        // default value
+		IdleBackoffMax:  2 * time.Minute,  // default value
+		ErrorBackoffMax: 2 * time.Minute,  // default value
+		ShutdownTimeout: 30 * time.Second, // default value
+		WorkerID:        defaultWorkerID(),
+		ClaimLease:      2 * time.Minute, // default value
+	}
+	if concurrency, err := settings.atoi("WORKER_CONCURRENCY"); err == nil {
+		config.Workflow.Concurrency = concurrency
+	}
+	if batchSize, err := settings.atoi("BATCH_SIZE"); err == nil {
+		config.Workflow.BatchSize = batchSize
+	}
+	if delay, err := settings.duration("RETRY_BASE_DELAY"); err == nil {
+		config.Workflow.RetryBaseDelay = delay
+	}
+	if delay, err := settings.duration("RETRY_MAX_DELAY"); err == nil {
+		config.Workflow.RetryMaxDelay = delay
+	}
+	// IDLE_MAX_INTERVAL is the name IDLE_BACKOFF_MAX had before
+	if max, err := settings.duration("IDLE_MAX_INTERVAL"); err == nil {
+		config.Workflow.IdleBackoffMax = max
+	}
+	if max, err := settings.duration("IDLE_BACKOFF_MAX"); err == nil {
+		config.Workflow.IdleBackoffMax = max
+	}
+	if max, err := settings.duration("ERROR_BACKOFF_MAX"); err == nil {
+		config.Workflow.ErrorBackoffMax = max
+	}
+	if timeout, err := settings.duration("SHUTDOWN_TIMEOUT"); err == nil {
+		config.Workflow.ShutdownTimeout = timeout
+	}
+	if id := getenv("WORKER_ID"); id != "" {
+		config.Workflow.WorkerID = id
+	}
+	if lease, err := settings.duration("CLAIM_LEASE"); err == nil {
+		config.Workflow.ClaimLease = lease
+	}
+
+	// Deadline of the provider calls and updates for a single image in the generator and processor
+	if timeout, err := settings.atoi("PER_IMAGE_TIMEOUT"); err == nil && timeout > 0 {
+		config.PerImageTimeout = time.Duration(timeout) * time.Second
+	} else {
+		config.PerImageTimeout = 60 * time.Second // default value
+	}
+
+	// off, reuse (copy the earlier image's result) or mark (set status Duplicate)
+	config.DedupMode = getenv("DEDUP_MODE")
+	switch config.DedupMode {
+	case "":
+		config.DedupMode = "off" // default value
+	case "off", "reuse", "mark":
+	default:
+		return nil, fmt.Errorf("invalid DEDUP_MODE %q, expected off, reuse or mark", config.DedupMode)
+	}
+
+	// Keep writing the first file to images.base64 for consumers that predate image_results
+	if legacy, err := settings.parseBool("LEGACY_BASE64"); err == nil {
+		config.LegacyBase64 = legacy
+	} else {
+		config.LegacyBase64 = true // default value
+	}
+
+	if requeue, err := settings.parseBool("CENSORED_REQUEUE"); err == nil {
+		config.CensoredRequeue = requeue
+	}
+
+	config.CensoredNegativePrompt = getenv("CENSORED_NEGATIVE_PROMPT")
+	if config.CensoredNegativePrompt == "" {
+		config.CensoredNegativePrompt = "nsfw, nudity, explicit, violence, gore, blood" // default value
+	}
+
+	// Keep the provider's status response of failed and censored generations in images.raw_response
+	if capture, err := settings.parseBool("CAPTURE_RAW_RESPONSES"); err == nil {
+		config.CaptureRawResponses = capture
+	}
+
+	if maxBytes, err := settings.atoi("RAW_RESPONSE_MAX_BYTES"); err == nil && maxBytes > 0 {
+		config.RawResponseMaxBytes = maxBytes
+	} else {
+		config.RawResponseMaxBytes = 16384 // default value
+	}
+
+	// db keeps base64 data in Postgres, fs writes decoded files below STORAGE_DIR, s3 uploads them to S3_BUCKET
+	config.Storage = getenv("STORAGE")
+	switch config.Storage {
+	case "":
+		config.Storage = "db" // default value
+	case "db", "fs":
+	case "s3":
+		if getenv("S3_BUCKET") == "" {
+			return nil, fmt.Errorf("S3_BUCKET is required when STORAGE=s3")
+		}
+	default:
+		return nil, fmt.Errorf("invalid STORAGE %q, expected db, fs or s3", config.Storage)
+	}
+
+	config.S3Bucket = getenv("S3_BUCKET")
+	config.S3Prefix = getenv("S3_PREFIX")
+	config.S3Region = getenv("S3_REGION")
+	if config.S3Region == "" {
+		config.S3Region = "us-east-1" // default value
+	}
+	config.S3Endpoint = getenv("S3_ENDPOINT")
+	config.S3AccessKeyID = getenv("S3_ACCESS_KEY_ID")
+	config.S3SecretAccessKey = getenv("S3_SECRET_ACCESS_KEY")
+	if pathStyle, err := settings.parseBool("S3_USE_PATH_STYLE"); err == nil {
+		config.S3UsePathStyle = pathStyle
+	}
+	if ttl, err := settings.atoi("S3_PRESIGN_TTL"); err == nil {
+		config.S3PresignTTL = time.Duration(ttl) * time.Second
+	}
+
+	config.StorageDir = getenv("STORAGE_DIR")
+	if config.StorageDir == "" {
+		config.StorageDir = "images" // default value
+	}
+
+	config.StorageFilename = getenv("STORAGE_FILENAME")
+	if config.StorageFilename == "" {
+		config.StorageFilename = "{id}_{uuid}_{index}.png" // default value
+	}
+
+	if enabled, err := settings.parseBool("POSTPROCESS_ENABLED"); err == nil {
+		config.PostProcessEnabled = enabled
+	}
+
+	if maxWidth, err := settings.atoi("POSTPROCESS_MAX_WIDTH"); err == nil {
+		config.PostProcessMaxWidth = maxWidth
+	}
+
+	if maxHeight, err := settings.atoi("POSTPROCESS_MAX_HEIGHT"); err == nil {
+		config.PostProcessMaxHeight = maxHeight
+	}
+
+	config.PostProcessFormat = getenv("POSTPROCESS_FORMAT")
+	switch config.PostProcessFormat {
+	case "":
+		config.PostProcessFormat = "jpeg" // default value
+	case "jpeg", "webp":
+	default:
+		return nil, fmt.Errorf("invalid POSTPROCESS_FORMAT %q, expected jpeg or webp", config.PostProcessFormat)
+	}
+
+	if quality, err := settings.atoi("POSTPROCESS_QUALITY"); err == nil && quality >= 1 && quality <= 100 {
+		config.PostProcessQuality = quality
+	} else {
+		config.PostProcessQuality = 85 // default value
+	}
+
+	if keep, err := settings.parseBool("POSTPROCESS_KEEP_ORIGINAL"); err == nil {
+		config.PostProcessKeepOriginal = keep
+	}
+	if config.PostProcessKeepOriginal && config.Storage == "db" {
+		return nil, fmt.Errorf("POSTPROCESS_KEEP_ORIGINAL requires STORAGE=fs or STORAGE=s3")
+	}
+
+	config.WatermarkFile = getenv("WATERMARK_FILE")
+	config.WatermarkPosition = getenv("WATERMARK_POSITION")
+	switch config.WatermarkPosition {
+	case "":
+		config.WatermarkPosition = "bottom-right" // default value
+	case "top-left", "top-right", "bottom-left", "bottom-right":
+	default:
+		return nil, fmt.Errorf("invalid WATERMARK_POSITION %q, expected top-left, top-right, bottom-left or bottom-right", config.WatermarkPosition)
+	}
+
+	if opacity, err := settings.parseFloat("WATERMARK_OPACITY"); err == nil && opacity >= 0 && opacity <= 1 {
+		config.WatermarkOpacity = opacity
+	} else {
+		config.WatermarkOpacity = 0.5 // default value
+	}
+
+	if margin, err := settings.atoi("WATERMARK_MARGIN"); err == nil && margin >= 0 {
+		config.WatermarkMargin = margin
+	} else {
+		config.WatermarkMargin = 16 // default value
+	}
+
+	if maxEdge, err := settings.atoi("THUMBNAIL_MAX_EDGE"); err == nil && maxEdge >= 0 {
+		config.ThumbnailMaxEdge = maxEdge
+	} else {
+		config.ThumbnailMaxEdge = 256 // default value
+	}
+
+	config.TelegramBotToken = getenv("TELEGRAM_BOT_TOKEN")
+	config.TelegramChatID = getenv("TELEGRAM_CHAT_ID")
+	if config.TelegramBotToken != "" && config.TelegramChatID == "" {
+		return nil, fmt.Errorf("TELEGRAM_CHAT_ID is required when TELEGRAM_BOT_TOKEN is set")
+	}
+
+	if attempts, err := settings.atoi("PUBLISH_MAX_ATTEMPTS"); err == nil && attempts > 0 {
+		config.PublishMaxAttempts = attempts
+	} else {
+		config.PublishMaxAttempts = 5 // default value
+	}
+
+	// The S3 publisher reuses the S3_* region, endpoint and credential settings
+	config.PublishS3Bucket = getenv("PUBLISH_S3_BUCKET")
+	config.PublishS3Key = getenv("PUBLISH_S3_KEY")
+	if config.PublishS3Key == "" {
+		config.PublishS3Key = "{id}_{uuid}.{ext}" // default value
+	}
+	config.PublishS3CacheControl = getenv("PUBLISH_S3_CACHE_CONTROL")
+	if config.PublishS3CacheControl == "" {
+		config.PublishS3CacheControl = "public, max-age=31536000, immutable" // default value
+	}
+	config.PublishS3BaseURL = getenv("PUBLISH_S3_BASE_URL")
+
+	config.WebhookSecret = getenv("WEBHOOK_SECRET")
+	if attempts, err := settings.atoi("WEBHOOK_MAX_ATTEMPTS"); err == nil && attempts > 0 {
+		config.WebhookMaxAttempts = attempts
+	} else {
+		config.WebhookMaxAttempts = 5 // default value
+	}
+
+	// Operator alerts; durations are Go durations such as 30m, or whole seconds
+	config.Alerts = AlertConfig{
+		WebhookURL:        getenv("ALERT_WEBHOOK_URL"),
+		Cooldown:          time.Hour,     // default value
+		FailureRate:       0.5,           // default value
+		BacklogAge:        6 * time.Hour, // default value
+		NoGenerationAfter: 6 * time.Hour, // default value
+	}
+	if cooldown, err := settings.duration("ALERT_COOLDOWN"); err == nil {
+		config.Alerts.Cooldown = cooldown
+	}
+	if rate, err := settings.parseFloat("ALERT_FAILURE_RATE"); err == nil {
+		config.Alerts.FailureRate = rate
+	}
+	if age, err := settings.duration("ALERT_BACKLOG_AGE"); err == nil {
+		config.Alerts.BacklogAge = age
+	}
+	if after, err := settings.duration("ALERT_NO_GENERATION_AFTER"); err == nil {
+		config.Alerts.NoGenerationAfter = after
+	}
+
+	// Image lifecycle events for downstream consumers
+	config.Events = EventsConfig{
+		NATSURL:       getenv("EVENTS_NATS_URL"),
+		NATSUser:      getenv("EVENTS_NATS_USER"),
+		NATSPassword:  getenv("EVENTS_NATS_PASSWORD"),
+		NATSToken:     getenv("EVENTS_NATS_TOKEN"),
+		SubjectPrefix: getenv("EVENTS_SUBJECT_PREFIX"),
+	}
+	if attempts, err := settings.atoi("EVENTS_MAX_ATTEMPTS"); err == nil && attempts > 0 {
+		config.Events.MaxAttempts = attempts
+	} else {
+		config.Events.MaxAttempts = 5 // default value
+	}
+
+	config.HTTPAddr = getenv("HTTP_ADDR")
+	// Bearer token required by the image API served with -serve
+	config.APIToken = getenv("API_TOKEN")
+
+	// Interval of the pool and runtime stats log lines of run; 0 disables them
+	if interval, err := settings.duration("STATS_INTERVAL"); err == nil {
+		config.StatsInterval = interval
+	} else {
+		config.StatsInterval = time.Minute // default value
+	}
+
+	// Profiling endpoints under /debug/pprof on HTTP_ADDR, guarded by API_TOKEN
+	if enabled, err := settings.parseBool("PPROF_ENABLED"); err == nil {
+		config.PprofEnabled = enabled
+	}
+	if fraction, err := settings.atoi("PPROF_MUTEX_FRACTION"); err == nil {
+		config.PprofMutexFraction = fraction
+	}
+	if rate, err := settings.atoi("PPROF_BLOCK_RATE"); err == nil {
+		config.PprofBlockRate = rate
+	}
+
+	// Sentry project panics, permanently failed images and startup errors are sent to; empty
+	// disables error reporting
+	config.SentryDSN = getenv("SENTRY_DSN")
+	config.SentryEnvironment = getenv("SENTRY_ENVIRONMENT")
+
+	config.LogFormat = getenv("LOG_FORMAT")
+	switch config.LogFormat {
+	case "":
+		config.LogFormat = "text" // default value
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, expected text or json", config.LogFormat)
+	}
+	config.LogLevel = slog.LevelInfo // default value
+	if value := getenv("LOG_LEVEL"); value != "" {
+		level, err := logging.ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+		config.LogLevel = level
+	}
+	levels, err := logging.ParseLevels(getenv("LOG_LEVELS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVELS: %w", err)
+	}
+	config.LogLevels = levels
+
+	// Schedules have a leading seconds field, matching the scheduler used by -cron
+	cronParser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	config.CronGeneratorSpec = getenv("CRON_GENERATOR_SPEC")
+	if config.CronGeneratorSpec == "" {
+		config.CronGeneratorSpec = "0 */3 * * * *" // default value
+	}
+	if _, err := cronParser.Parse(config.CronGeneratorSpec); err != nil {
+		return nil, fmt.Errorf("invalid CRON_GENERATOR_SPEC %q: %w", config.CronGeneratorSpec, err)
+	}
+	config.CronProcessorSpec = getenv("CRON_PROCESSOR_SPEC")
+	if config.CronProcessorSpec == "" {
+		config.CronProcessorSpec = "0 */7 * * * *" // default value
+	}
+	if _, err := cronParser.Parse(config.CronProcessorSpec); err != nil {
+		return nil, fmt.Errorf("invalid CRON_PROCESSOR_SPEC %q: %w", config.CronProcessorSpec, err)
+	}
+	config.CronPublisherSpec = getenv("CRON_PUBLISHER_SPEC")
+	if config.CronPublisherSpec == "" {
+		config.CronPublisherSpec = "0 */5 * * * *" // default value
+	}
+	if _, err := cronParser.Parse(config.CronPublisherSpec); err != nil {
+		return nil, fmt.Errorf("invalid CRON_PUBLISHER_SPEC %q: %w", config.CronPublisherSpec, err)
+	}
+
+	// How often -cron requeues images that failed transiently; 0 disables it
+	if interval, err := settings.atoi("REQUEUE_FAILED_INTERVAL"); err == nil && interval >= 0 {
+		config.RequeueFailedInterval = time.Duration(interval) * time.Second
+	}
+
+	if after, err := settings.atoi("REQUEUE_FAILED_AFTER"); err == nil && after >= 0 {
+		config.RequeueFailedAfter = time.Duration(after) * time.Second
+	} else {
+		config.RequeueFailedAfter = 30 * time.Minute // default value
+	}
+
+	if attempts, err := settings.atoi("REQUEUE_FAILED_MAX_ATTEMPTS"); err == nil && attempts > 0 {
+		config.RequeueFailedMaxAttempts = attempts
+	} else {
+		config.RequeueFailedMaxAttempts = 3 // default value
+	}
+
+	// How long -cron keeps the event log of status transitions; 0 keeps it forever
+	if retention, err := settings.atoi("EVENT_RETENTION"); err == nil && retention >= 0 {
+		config.EventRetention = time.Duration(retention) * time.Second
+	} else {
+		config.EventRetention = 90 * 24 * time.Hour // default value
+	}
+
+	if enabled, err := settings.parseBool("CACHE_ENABLED"); err == nil {
+		config.CacheEnabled = enabled
+	}
+
+	config.CacheBackend = getenv("CACHE_BACKEND")
+	switch config.CacheBackend {
+	case "":
+		config.CacheBackend = "memory" // default value
+	case "memory", "postgres":
+	default:
+		return nil, fmt.Errorf("invalid CACHE_BACKEND %q, expected memory or postgres", config.CacheBackend)
+	}
+
+	if ttl, err := settings.atoi("CACHE_TTL"); err == nil {
+		config.CacheTTL = time.Duration(ttl) * time.Second
+	} else {
+		config.CacheTTL = time.Hour // default value
+	}
+
+	if entries, err := settings.atoi("CACHE_MAX_ENTRIES"); err == nil && entries > 0 {
+		config.CacheMaxEntries = entries
+	} else {
+		config.CacheMaxEntries = 1000 // default value
+	}
+
+	// Generation jobs come from the images table unless QUEUE=redis
+	config.Queue = QueueConfig{
+		Backend:           getenv("QUEUE"),
+		RedisURL:          getenv("REDIS_URL"),
+		Prefix:            getenv("QUEUE_PREFIX"),
+		VisibilityTimeout: 10 * time.Minute,   // default value
+		PollInterval:      time.Second,        // default value
+		MaxAttempts:       3,                  // default value
+		ResultTTL:         7 * 24 * time.Hour, // default value
+	}
+	switch config.Queue.Backend {
+	case "":
+		config.Queue.Backend = "db" // default value
+	case "db", "redis":
+	default:
+		return nil, fmt.Errorf("invalid QUEUE %q, expected db or redis", config.Queue.Backend)
+	}
+	if config.Queue.RedisURL == "" {
+		config.Queue.RedisURL = "redis://localhost:6379/0" // default value
+	}
+	if config.Queue.Prefix == "" {
+		config.Queue.Prefix = "2xiang" // default value
+	}
+	if visibility, err := settings.duration("QUEUE_VISIBILITY_TIMEOUT"); err == nil {
+		config.Queue.VisibilityTimeout = visibility
+	}
+	if interval, err := settings.duration("QUEUE_POLL_INTERVAL"); err == nil {
+		config.Queue.PollInterval = interval
+	}
+	if attempts, err := settings.atoi("QUEUE_MAX_ATTEMPTS"); err == nil {
+		config.Queue.MaxAttempts = attempts
+	}
+	if ttl, err := settings.duration("QUEUE_RESULT_TTL"); err == nil {
+		config.Queue.ResultTTL = ttl
+	}
+
 	// Load database configuration
 	dbConfig := DBConfig{
-		Host:     os.Getenv("DB_HOST"),
-		User:     os.Getenv("DB_USER"),
-		Password: os.Getenv("DB_PASSWORD"),
-		Database: os.Getenv("DB_NAME"),
-		SSLMode:  os.Getenv("DB_SSL_MODE"),
+		URL:      getenv("DATABASE_URL"),
+		Host:     getenv("DB_HOST"),
+		User:     getenv("DB_USER"),
+		Password: getenv("DB_PASSWORD"),
+		Database: getenv("DB_NAME"),
+		SSLMode:  getenv("DB_SSL_MODE"),
+	}
+
+	if dbConfig.SSLMode == "" {
+		dbConfig.SSLMode = "disable" // default value
 	}
 
 	// Parse database port
-	if port, err := strconv.Atoi(os.Getenv("DB_PORT")); err == nil {
+	if port, err := settings.atoi("DB_PORT"); err == nil {
 		dbConfig.Port = port
 	} else {
 		dbConfig.Port = 5432 // default PostgreSQL port
 	}
 
 	// Parse connection pool settings
-	if maxOpenConns, err := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS")); err == nil {
+	if maxOpenConns, err := settings.atoi("DB_MAX_OPEN_CONNS"); err == nil {
 		dbConfig.MaxOpenConns = maxOpenConns
 	} else {
 		dbConfig.MaxOpenConns = 25 // default value
 	}
 
-	if maxIdleConns, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS")); err == nil {
+	if maxIdleConns, err := settings.atoi("DB_MAX_IDLE_CONNS"); err == nil {
 		dbConfig.MaxIdleConns = maxIdleConns
 	} else {
 		dbConfig.MaxIdleConns = 25 // default value
 	}
 
-	if connMaxLifetime, err := strconv.Atoi(os.Getenv("DB_CONN_MAX_LIFETIME")); err == nil {
+	if connMaxLifetime, err := settings.atoi("DB_CONN_MAX_LIFETIME"); err == nil {
 		dbConfig.ConnMaxLifetime = time.Duration(connMaxLifetime) * time.Second
 	} else {
 		dbConfig.ConnMaxLifetime = 5 * time.Minute // default value
 	}
 
+	if timeout, err := settings.duration("DB_CONNECT_TIMEOUT"); err == nil {
+		dbConfig.ConnectTimeout = timeout
+	} else {
+		dbConfig.ConnectTimeout = 10 * time.Second // default value
+	}
+
+	if retry, err := settings.duration("DB_CONNECT_RETRY"); err == nil {
+		dbConfig.ConnectRetry = retry
+	} else {
+		dbConfig.ConnectRetry = 30 * time.Second // default value
+	}
+
+	if listen, err := settings.parseBool("DB_LISTEN_NOTIFY"); err == nil {
+		dbConfig.ListenNotify = listen
+	}
+
 	config.DB = dbConfig
 
-	// Validate required fields
-	if config.FusionBrainAPIKey == "" {
-		return nil, fmt.Errorf("FUSION_BRAIN_API_KEY is required")
+	// Default sizes off the grid are snapped like the sizes of requests, rather than rejected
+	if config.SnapDimensions {
+		config.DefaultImageWidth = domain.SnapDimension(config.DefaultImageWidth)
+		config.DefaultImageHeight = domain.SnapDimension(config.DefaultImageHeight)
+	}
+
+	// Settings that are present but malformed are errors rather than replaced by their defaults
+	if err := errors.Join(settings.err(), config.Validate()); err != nil {
+		return nil, err
+	}
+
+	// Validate required fields of the selected providers
+	if requireProviders {
+		var errs []error
+		for _, name := range config.Providers {
+			errs = append(errs, config.validateProvider(name))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
 	}
-	if config.FusionBrainSecretKey == "" {
-		return nil, fmt.Errorf("FUSION_BRAIN_SECRET_KEY is required")
+	if config.FusionBrain.ProxyURL != "" {
+		if _, err := url.Parse(config.FusionBrain.ProxyURL); err != nil {
+			return nil, fmt.Errorf("invalid FUSION_BRAIN_PROXY_URL: %w", err)
+		}
 	}
 
-	// Validate database configuration
+	// The generator of the Redis queue mode does not connect to the database
+	if !requireDatabase || config.Queue.Backend == "redis" {
+		return config, nil
+	}
+
+	// Validate database configuration; DATABASE_URL takes precedence over the DB_* settings
+	if config.DB.URL != "" {
+		u, err := url.Parse(config.DB.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return nil, fmt.Errorf("invalid DATABASE_URL: scheme must be postgres or postgresql, not %q", u.Scheme)
+		}
+		return config, nil
+	}
 	if config.DB.Host == "" {
-		return nil, fmt.Errorf("DB_HOST is required")
+		return nil, fmt.Errorf("DB_HOST or DATABASE_URL is required")
 	}
 	if config.DB.User == "" {
 		return nil, fmt.Errorf("DB_USER is required")
@@ -149,8 +1071,82 @@ func Load() (*Config, error) {
 	return config, nil
 }
 
-// GetDSN returns the PostgreSQL connection string
+// validateProvider checks that the settings required by the named provider are present
+func (c *Config) validateProvider(name string) error {
+	switch name {
+	case "fusionbrain":
+		if c.FusionBrain.APIKey == "" {
+			return fmt.Errorf("FUSION_BRAIN_API_KEY is required")
+		}
+		if c.FusionBrain.SecretKey == "" {
+			return fmt.Errorf("FUSION_BRAIN_SECRET_KEY is required")
+		}
+	case "openai":
+		if c.OpenAI.APIKey == "" {
+			return fmt.Errorf("OPENAI_API_KEY is required")
+		}
+	case "replicate":
+		if c.Replicate.APIToken == "" {
+			return fmt.Errorf("REPLICATE_API_TOKEN is required")
+		}
+		if c.Replicate.Model == "" {
+			return fmt.Errorf("REPLICATE_MODEL is required")
+		}
+	case "stability":
+		if c.Stability.APIKey == "" {
+			return fmt.Errorf("STABILITY_API_KEY is required")
+		}
+	default:
+		return fmt.Errorf("unknown provider %q", name)
+	}
+	return nil
+}
+
+// GetDSN returns the PostgreSQL connection string: DATABASE_URL when set, otherwise a
+// keyword/value string of the DB_* settings. DB_CONNECT_TIMEOUT is added as connect_timeout
+// unless DATABASE_URL sets its own.
 func (c *Config) GetDSN() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		c.DB.Host, c.DB.Port, c.DB.User, c.DB.Password, c.DB.Database, c.DB.SSLMode)
+	timeout := connectTimeoutSeconds(c.DB.ConnectTimeout)
+	if c.DB.URL != "" {
+		u, err := url.Parse(c.DB.URL)
+		if err != nil || timeout == 0 || u.Query().Has("connect_timeout") {
+			return c.DB.URL
+		}
+		query := u.Query()
+		query.Set("connect_timeout", strconv.Itoa(timeout))
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		quoteDSNValue(c.DB.Host), c.DB.Port, quoteDSNValue(c.DB.User), quoteDSNValue(c.DB.Password),
+		quoteDSNValue(c.DB.Database), quoteDSNValue(c.DB.SSLMode), timeout)
+}
+
+// connectTimeoutSeconds converts a connect timeout to the whole seconds of connect_timeout,
+// rounding up so short timeouts do not turn into 0, which means no timeout
+func connectTimeoutSeconds(timeout time.Duration) int {
+	if timeout <= 0 {
+		return 0
+	}
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// quoteDSNValue quotes a keyword/value connection string value following the libpq rules:
+// empty values and values with spaces, quotes or backslashes are single-quoted, with quotes
+// and backslashes escaped by a backslash
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`+"\t\n\r") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+// defaultWorkerID identifies the process by its host name and process ID
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/robfig/cron/v3"
+)
+
+// loadMap loads the configuration from env alone, requiring neither providers nor a database
+func loadMap(t *testing.T, env map[string]string) (*Config, error) {
+	t.Helper()
+	return load(func(name string) string { return env[name] }, false, false)
+}
+
+func TestDefaultDimensions(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		want   int
+		hasErr bool
+	}{
+		{name: "unset", want: 1024},
+		{name: "minimum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "128"}, want: 128},
+		{name: "maximum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "2048"}, want: 2048},
+		{name: "below minimum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "64"}, hasErr: true},
+		{name: "above maximum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "2112"}, hasErr: true},
+		{name: "off the grid", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "1000"}, hasErr: true},
+		{name: "snapped", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "1000", "SNAP_DIMENSIONS": "true"}, want: 1024},
+		{name: "snapped up to minimum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "100", "SNAP_DIMENSIONS": "true"}, want: 128},
+		{name: "snapped down to maximum", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "3000", "SNAP_DIMENSIONS": "true"}, want: 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadMap(t, tt.env)
+			if tt.hasErr {
+				if err == nil {
+					t.Fatalf("load() = width %d, want an error", cfg.DefaultImageWidth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			if cfg.DefaultImageWidth != tt.want || cfg.DefaultImageHeight != 1024 {
+				t.Errorf("default size = %dx%d, want %dx1024", cfg.DefaultImageWidth, cfg.DefaultImageHeight, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptRejectTerms(t *testing.T) {
+	cfg, err := loadMap(t, map[string]string{"PROMPT_REJECT_TERMS": " gore, blood bath ,,re:nud(e|ity), re: "})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	want := []domain.BannedTerm{{Term: "gore"}, {Term: "blood bath"}, {Term: "nud(e|ity)", Regex: true}}
+	if !reflect.DeepEqual(cfg.PromptRejectTerms, want) {
+		t.Errorf("PromptRejectTerms = %+v, want %+v", cfg.PromptRejectTerms, want)
+	}
+}
+
+func TestPostProcessFormat(t *testing.T) {
+	for value, want := range map[string]string{"": "jpeg", "jpeg": "jpeg", "webp": "webp"} {
+		cfg, err := loadMap(t, map[string]string{"POSTPROCESS_FORMAT": value})
+		if err != nil {
+			t.Fatalf("load() of %q error = %v", value, err)
+		}
+		if cfg.PostProcessFormat != want {
+			t.Errorf("PostProcessFormat of %q = %q, want %q", value, cfg.PostProcessFormat, want)
+		}
+	}
+	for _, value := range []string{"png", "gif", "JPEG"} {
+		if _, err := loadMap(t, map[string]string{"POSTPROCESS_FORMAT": value}); err == nil {
+			t.Errorf("load() of %q succeeded, want an error", value)
+		}
+	}
+}
+
+func TestCronSpecs(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantGenerator string
+		wantProcessor string
+		wantPublisher string
+		wantErr       string
+	}{
+		{name: "defaults", wantGenerator: "0 */3 * * * *", wantProcessor: "0 */7 * * * *", wantPublisher: "0 */5 * * * *"},
+		{
+			name:          "custom",
+			env:           map[string]string{"CRON_GENERATOR_SPEC": "30 */5 * * * *", "CRON_PROCESSOR_SPEC": "@every 10m", "CRON_PUBLISHER_SPEC": "0 0 9 * * MON-FRI"},
+			wantGenerator: "30 */5 * * * *",
+			wantProcessor: "@every 10m",
+			wantPublisher: "0 0 9 * * MON-FRI",
+		},
+		{name: "descriptor", env: map[string]string{"CRON_GENERATOR_SPEC": "@hourly"}, wantGenerator: "@hourly", wantProcessor: "0 */7 * * * *", wantPublisher: "0 */5 * * * *"},
+		{name: "missing seconds field", env: map[string]string{"CRON_GENERATOR_SPEC": "*/5 * * * *"}, wantErr: "CRON_GENERATOR_SPEC"},
+		{name: "out of range", env: map[string]string{"CRON_GENERATOR_SPEC": "0 */3 25 * * *"}, wantErr: "CRON_GENERATOR_SPEC"},
+		{name: "typo", env: map[string]string{"CRON_PROCESSOR_SPEC": "0 */7 * * * MOM"}, wantErr: "CRON_PROCESSOR_SPEC"},
+		{name: "bad interval", env: map[string]string{"CRON_PROCESSOR_SPEC": "@every ten minutes"}, wantErr: "CRON_PROCESSOR_SPEC"},
+		{name: "publisher missing seconds field", env: map[string]string{"CRON_PUBLISHER_SPEC": "*/5 * * * *"}, wantErr: "CRON_PUBLISHER_SPEC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadMap(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("load() error = %v, want one naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			if cfg.CronGeneratorSpec != tt.wantGenerator || cfg.CronProcessorSpec != tt.wantProcessor || cfg.CronPublisherSpec != tt.wantPublisher {
+				t.Errorf("specs = %q, %q and %q, want %q, %q and %q", cfg.CronGeneratorSpec, cfg.CronProcessorSpec, cfg.CronPublisherSpec, tt.wantGenerator, tt.wantProcessor, tt.wantPublisher)
+			}
+
+			// Accepted specs are accepted by the scheduler of -cron as well
+			c := cron.New(cron.WithSeconds())
+			for _, spec := range []string{cfg.CronGeneratorSpec, cfg.CronProcessorSpec, cfg.CronPublisherSpec} {
+				if _, err := c.AddFunc(spec, func() {}); err != nil {
+					t.Errorf("scheduler rejects %q: %v", spec, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultCronSpecsSchedule(t *testing.T) {
+	cfg, err := loadMap(t, nil)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	// The generator runs every 3 minutes, the processor every 7 and the publisher every 5, on
+	// the minute
+	start := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+	for _, tt := range []struct {
+		spec string
+		want []string
+	}{
+		{spec: cfg.CronGeneratorSpec, want: []string{"10:03:00", "10:06:00", "10:09:00"}},
+		{spec: cfg.CronProcessorSpec, want: []string{"10:07:00", "10:14:00", "10:21:00"}},
+		{spec: cfg.CronPublisherSpec, want: []string{"10:05:00", "10:10:00", "10:15:00"}},
+	} {
+		schedule, err := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow).Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.spec, err)
+		}
+		next := start
+		for _, want := range tt.want {
+			if next = schedule.Next(next); next.Format("15:04:05") != want {
+				t.Errorf("%q runs at %s, want %s", tt.spec, next.Format("15:04:05"), want)
+			}
+		}
+	}
+}
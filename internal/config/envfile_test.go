@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envFileVars are the variables the .env files of the tests set
+var envFileVars = []string{"FUSION_BRAIN_API_KEY", "FUSION_BRAIN_SECRET_KEY", "DB_HOST", "DB_USER", "DB_PASSWORD", "DB_NAME"}
+
+// inDir runs the rest of the test in dir, unsetting the variables a .env file loaded there set
+func inDir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range envFileVars {
+		if _, set := os.LookupEnv(name); !set {
+			name := name
+			t.Cleanup(func() { os.Unsetenv(name) })
+		}
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// writeEnvFile writes a .env file with every required setting to path
+func writeEnvFile(t *testing.T, path string) {
+	t.Helper()
+	content := "FUSION_BRAIN_API_KEY=file-key\nFUSION_BRAIN_SECRET_KEY=file-secret\n" +
+		"DB_HOST=file-host\nDB_USER=images\nDB_PASSWORD=file-password\nDB_NAME=images\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setRequired sets every required setting in the environment, as a container would
+func setRequired(t *testing.T) {
+	t.Helper()
+	for name, value := range map[string]string{
+		"FUSION_BRAIN_API_KEY":    "env-key",
+		"FUSION_BRAIN_SECRET_KEY": "env-secret",
+		"DB_HOST":                 "env-host",
+		"DB_USER":                 "images",
+		"DB_PASSWORD":             "env-password",
+		"DB_NAME":                 "images",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadWithoutEnvFile(t *testing.T) {
+	inDir(t, t.TempDir())
+	setRequired(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() without a .env file error = %v", err)
+	}
+	if cfg.FusionBrain.APIKey != "env-key" || cfg.DB.Host != "env-host" {
+		t.Errorf("API key = %q, host = %q, want the environment", cfg.FusionBrain.APIKey, cfg.DB.Host)
+	}
+}
+
+func TestLoadWithoutEnvFileStillValidates(t *testing.T) {
+	inDir(t, t.TempDir())
+	for _, name := range envFileVars {
+		t.Setenv(name, "")
+	}
+	if _, err := Load(); err == nil || strings.Contains(err.Error(), ".env") {
+		t.Errorf("Load() without settings error = %v, want the missing settings reported", err)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	writeEnvFile(t, filepath.Join(dir, ".env"))
+	inDir(t, dir)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.FusionBrain.APIKey != "file-key" || cfg.DB.Password != "file-password" {
+		t.Errorf("API key = %q, password = %q, want the .env values", cfg.FusionBrain.APIKey, cfg.DB.Password)
+	}
+}
+
+func TestLoadEnvironmentOverridesEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	writeEnvFile(t, filepath.Join(dir, ".env"))
+	inDir(t, dir)
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("FUSION_BRAIN_API_KEY", "env-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DB.Host != "env-host" || cfg.FusionBrain.APIKey != "env-key" {
+		t.Errorf("host = %q, API key = %q, want the environment to win", cfg.DB.Host, cfg.FusionBrain.APIKey)
+	}
+	if cfg.FusionBrain.SecretKey != "file-secret" {
+		t.Errorf("secret key = %q, want the .env value of what the environment does not set", cfg.FusionBrain.SecretKey)
+	}
+}
+
+func TestLoadEnvFileOverride(t *testing.T) {
+	dir := t.TempDir()
+	// The default .env is ignored when ENV_FILE names another file
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("DB_HOST=default-env-host\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "production.env")
+	writeEnvFile(t, path)
+	inDir(t, dir)
+	t.Setenv("ENV_FILE", path)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DB.Host != "file-host" {
+		t.Errorf("host = %q, want the value of ENV_FILE", cfg.DB.Host)
+	}
+
+	// Unlike the default .env, a file named explicitly must exist
+	t.Setenv("ENV_FILE", filepath.Join(dir, "missing.env"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "error loading ENV_FILE") {
+		t.Errorf("Load() with a missing ENV_FILE error = %v, want it reported", err)
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileValue is a setting read from a configuration file with the line it was defined on
+type fileValue struct {
+	value string
+	line  int
+}
+
+// fileValues maps the environment variable names of the settings in a configuration file to
+// their values. File keys are the variable names in lower case, e.g. default_image_width for
+// DEFAULT_IMAGE_WIDTH, with the DB_ settings nested under db, e.g. db.host for DB_HOST. Lists
+// are joined with commas.
+type fileValues map[string]fileValue
+
+// formatFromPath returns the format of a configuration file from its extension
+func formatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".toml":
+		return "toml", nil
+	default:
+		return "", fmt.Errorf("unknown configuration file format of %s, expected .yaml, .yml or .toml", path)
+	}
+}
+
+// readFile parses a YAML or TOML configuration document
+func readFile(r io.Reader, format string) (fileValues, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "yaml":
+		return readYAML(data)
+	case "toml":
+		return readTOML(data)
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q, expected yaml or toml", format)
+	}
+}
+
+// readYAML parses a YAML configuration document
+func readYAML(data []byte) (fileValues, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	values := make(fileValues)
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of settings", root.Line)
+	}
+	if err := addYAMLMapping(values, "", root); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// addYAMLMapping adds the settings of a YAML mapping to values, prefixing their keys with prefix
+func addYAMLMapping(values fileValues, prefix string, mapping *yaml.Node) error {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode, valueNode := mapping.Content[i], mapping.Content[i+1]
+		key := prefix + keyNode.Value
+
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			if valueNode.Tag == "!!null" {
+				values[envName(key)] = fileValue{line: keyNode.Line}
+			} else {
+				values[envName(key)] = fileValue{value: valueNode.Value, line: keyNode.Line}
+			}
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(valueNode.Content))
+			for _, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: key %q: expected a list of values", item.Line, key)
+				}
+				items = append(items, item.Value)
+			}
+			values[envName(key)] = fileValue{value: strings.Join(items, ","), line: keyNode.Line}
+		case yaml.MappingNode:
+			if prefix != "" || key != "db" {
+				return fmt.Errorf("line %d: key %q: expected a value, only db holds nested settings", keyNode.Line, key)
+			}
+			if err := addYAMLMapping(values, key+".", valueNode); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("line %d: key %q: unsupported value", keyNode.Line, key)
+		}
+	}
+	return nil
+}
+
+// readTOML parses a TOML configuration document
+func readTOML(data []byte) (fileValues, error) {
+	var doc map[string]any
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, err
+	}
+
+	lines := tomlKeyLines(data)
+	values := make(fileValues)
+	for key, value := range doc {
+		if table, ok := value.(map[string]any); ok {
+			if key != "db" {
+				return nil, fmt.Errorf("line %d: key %q: expected a value, only db holds nested settings", lines[key], key)
+			}
+			for name, v := range table {
+				if err := addTOMLValue(values, "db."+name, v, lines["db."+name]); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := addTOMLValue(values, key, value, lines[key]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// addTOMLValue adds a single TOML setting to values
+func addTOMLValue(values fileValues, key string, value any, line int) error {
+	switch v := value.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := tomlScalar(item)
+			if !ok {
+				return fmt.Errorf("line %d: key %q: expected a list of values", line, key)
+			}
+			items = append(items, s)
+		}
+		values[envName(key)] = fileValue{value: strings.Join(items, ","), line: line}
+	default:
+		s, ok := tomlScalar(v)
+		if !ok {
+			return fmt.Errorf("line %d: key %q: unsupported value", line, key)
+		}
+		values[envName(key)] = fileValue{value: s, line: line}
+	}
+	return nil
+}
+
+// tomlScalar formats a TOML string, number or boolean the way it would be written in the environment
+func tomlScalar(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// tomlKeyLines returns the line every key of a TOML document is defined on, with keys of the
+// db table prefixed with "db.". The decoder does not report positions of valid documents.
+func tomlKeyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			table = strings.Trim(line, "[] ")
+			lines[table] = n
+			continue
+		}
+		key, _, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, seen := lines[key]; !seen {
+			lines[key] = n
+		}
+	}
+	return lines
+}
+
+// envName returns the environment variable of a file key, e.g. DB_HOST for db.host
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// fileKey returns the file key of an environment variable, e.g. db.host for DB_HOST
+func fileKey(name string) string {
+	key := strings.ToLower(name)
+	if rest, ok := strings.CutPrefix(key, "db_"); ok {
+		return "db." + rest
+	}
+	return key
+}
+
+// unknownKey returns an error for the first setting of values, in file order, that was never
+// looked up while loading the configuration
+func (values fileValues) unknownKey(used map[string]bool) error {
+	var unknown []string
+	for name := range values {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Slice(unknown, func(i, j int) bool {
+		return values[unknown[i]].line < values[unknown[j]].line
+	})
+	name := unknown[0]
+	return fmt.Errorf("line %d: unknown key %q", values[name].line, fileKey(name))
+}
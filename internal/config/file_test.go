@@ -0,0 +1,238 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileEnv are settings of every kind a configuration file can hold
+var fileEnv = map[string]string{
+	"PROVIDER":                "fusionbrain",
+	"FUSION_BRAIN_API_KEY":    "key",
+	"FUSION_BRAIN_SECRET_KEY": "secret",
+	"DEFAULT_IMAGE_WIDTH":     "768",
+	"DEFAULT_IMAGE_HEIGHT":    "512",
+	"SNAP_DIMENSIONS":         "true",
+	"API_RPS":                 "2.5",
+	"PROMPT_BANNED_WORDS":     "gore,violence",
+	"WORKER_CONCURRENCY":      "8",
+	"RETRY_BASE_DELAY":        "45s",
+	"LOG_LEVEL":               "debug",
+	"HTTP_ADDR":               ":8080",
+	"DB_HOST":                 "db.internal",
+	"DB_PORT":                 "6432",
+	"DB_USER":                 "images",
+	"DB_PASSWORD":             "p@ss word",
+	"DB_NAME":                 "images",
+	"DB_SSL_MODE":             "require",
+}
+
+// fileDocument returns env as the settings of a configuration file, with numbers, booleans and
+// lists typed the way someone would write them
+func fileDocument(env map[string]string) map[string]any {
+	doc := map[string]any{}
+	db := map[string]any{}
+	for name, value := range env {
+		var typed any = value
+		if strings.Contains(value, ",") {
+			typed = strings.Split(value, ",")
+		} else if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			typed = n
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			typed = f
+		} else if b, err := strconv.ParseBool(value); err == nil {
+			typed = b
+		}
+		if key, ok := strings.CutPrefix(fileKey(name), "db."); ok {
+			db[key] = typed
+		} else {
+			doc[fileKey(name)] = typed
+		}
+	}
+	doc["db"] = db
+	return doc
+}
+
+// encode writes doc in format
+func encode(t *testing.T, doc map[string]any, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	if format == "yaml" {
+		err = yaml.NewEncoder(&buf).Encode(doc)
+	} else {
+		err = toml.NewEncoder(&buf).Encode(doc)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	want, err := load(func(name string) string { return fileEnv[name] }, true, true)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	for _, format := range []string{"yaml", "toml"} {
+		t.Run(format, func(t *testing.T) {
+			data := encode(t, fileDocument(fileEnv), format)
+			values, err := readFile(bytes.NewReader(data), format)
+			if err != nil {
+				t.Fatalf("readFile() error = %v\n%s", err, data)
+			}
+			for name, value := range fileEnv {
+				if got := values[name]; got.value != value || got.line == 0 {
+					t.Errorf("%s = %q on line %d, want %q with its line", name, got.value, got.line, value)
+				}
+			}
+			if len(values) != len(fileEnv) {
+				t.Errorf("read %d settings, want %d", len(values), len(fileEnv))
+			}
+
+			got, err := LoadFrom(bytes.NewReader(data), format)
+			if err != nil {
+				t.Fatalf("LoadFrom() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadFrom() = %+v\nwant the configuration of the same environment %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileEnvironmentOverrides(t *testing.T) {
+	data := encode(t, fileDocument(fileEnv), "yaml")
+	t.Setenv("DEFAULT_IMAGE_WIDTH", "1024")
+	t.Setenv("DB_HOST", "db.override")
+
+	cfg, err := LoadFrom(bytes.NewReader(data), "yaml")
+	if err != nil {
+		t.Fatalf("LoadFrom() error = %v", err)
+	}
+	if cfg.DefaultImageWidth != 1024 || cfg.DB.Host != "db.override" {
+		t.Errorf("width = %d, host = %q, want the environment to win", cfg.DefaultImageWidth, cfg.DB.Host)
+	}
+	if cfg.DefaultImageHeight != 512 || cfg.DB.Port != 6432 {
+		t.Errorf("height = %d, port = %d, want the file values", cfg.DefaultImageHeight, cfg.DB.Port)
+	}
+	// Defaults apply to what neither sets
+	if cfg.MaxPromptLength != 999 || cfg.DefaultNumImages != 1 {
+		t.Errorf("max prompt length = %d, images = %d, want the defaults", cfg.MaxPromptLength, cfg.DefaultNumImages)
+	}
+}
+
+func TestLoadWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, encode(t, fileDocument(fileEnv), "yaml"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(WithFile(path))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DefaultImageWidth != 768 || cfg.DB.Host != "db.internal" {
+		t.Errorf("width = %d, host = %q, want the file values", cfg.DefaultImageWidth, cfg.DB.Host)
+	}
+
+	// CONFIG_FILE names the file when no option does
+	t.Setenv("CONFIG_FILE", path)
+	if cfg, err := Load(); err != nil || cfg.DefaultImageHeight != 512 {
+		t.Errorf("Load() with CONFIG_FILE = %v, want the file values", err)
+	}
+
+	if _, err := Load(WithFile(filepath.Join(t.TempDir(), "config.json"))); err == nil || !strings.Contains(err.Error(), "expected .yaml, .yml or .toml") {
+		t.Errorf("Load() of a .json file error = %v, want the format rejected", err)
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		doc     string
+		wantErr string
+	}{
+		{
+			name:    "yaml unknown key",
+			format:  "yaml",
+			doc:     "provider: fusionbrain\ndefualt_image_width: 768\n",
+			wantErr: `line 2: unknown key "defualt_image_width"`,
+		},
+		{
+			name:    "yaml unknown db key",
+			format:  "yaml",
+			doc:     "db:\n  host: localhost\n  hots: db\n",
+			wantErr: `line 3: unknown key "db.hots"`,
+		},
+		{
+			name:    "yaml nested section",
+			format:  "yaml",
+			doc:     "provider: fusionbrain\nfusion_brain:\n  api_key: key\n",
+			wantErr: `line 2: key "fusion_brain": expected a value, only db holds nested settings`,
+		},
+		{
+			name:    "yaml list of mappings",
+			format:  "yaml",
+			doc:     "prompt_banned_words:\n  - word: gore\n",
+			wantErr: `line 2: key "prompt_banned_words": expected a list of values`,
+		},
+		{
+			name:    "yaml syntax",
+			format:  "yaml",
+			doc:     "provider: fusionbrain\n  default_image_width: [768\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "toml unknown key",
+			format:  "toml",
+			doc:     "provider = \"fusionbrain\"\n\n[db]\nhost = \"localhost\"\nhots = \"db\"\n",
+			wantErr: `line 5: unknown key "db.hots"`,
+		},
+		{
+			name:    "toml nested table",
+			format:  "toml",
+			doc:     "provider = \"fusionbrain\"\n[fusion_brain]\napi_key = \"key\"\n",
+			wantErr: `line 2: key "fusion_brain": expected a value, only db holds nested settings`,
+		},
+		{
+			name:    "toml syntax",
+			format:  "toml",
+			doc:     "provider = \"fusionbrain\"\ndefault_image_width = 768 768\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "malformed value",
+			format:  "yaml",
+			doc:     "default_image_width: wide\n",
+			wantErr: `invalid DEFAULT_IMAGE_WIDTH "wide"`,
+		},
+		{
+			name:    "unknown format",
+			format:  "json",
+			doc:     "{}",
+			wantErr: `unknown configuration format "json", expected yaml or toml`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := readFile(strings.NewReader(tt.doc), tt.format)
+			if err == nil {
+				_, err = loadValues(values, false, false)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loading %q error = %v, want it to contain %q", tt.doc, err, tt.wantErr)
+			}
+		})
+	}
+}
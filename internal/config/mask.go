@@ -0,0 +1,99 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+)
+
+// MaskSecret hides secret but for its last four characters, see redact.Mask
+func MaskSecret(secret string) string {
+	return redact.Mask(secret)
+}
+
+// maskURLPassword masks the password of a URL with user info, like DATABASE_URL or a proxy URL
+func maskURLPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// The URL was validated while loading; an unparsable one is hidden entirely
+		return MaskSecret(rawURL)
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return rawURL
+	}
+	// Replace the user info textually, since url.URL would escape the asterisks of the mask
+	userinfo := u.User.String() + "@"
+	masked := url.User(u.User.Username()).String() + ":" + MaskSecret(password) + "@"
+	if !strings.Contains(rawURL, userinfo) {
+		return u.Redacted()
+	}
+	return strings.Replace(rawURL, userinfo, masked, 1)
+}
+
+// Masked returns a copy of the configuration with every credential masked by MaskSecret, safe
+// to print or log
+func (c *Config) Masked() *Config {
+	masked := *c
+	masked.FusionBrain.APIKey = MaskSecret(c.FusionBrain.APIKey)
+	masked.FusionBrain.SecretKey = MaskSecret(c.FusionBrain.SecretKey)
+	masked.FusionBrain.ProxyURL = maskURLPassword(c.FusionBrain.ProxyURL)
+	masked.OpenAI.APIKey = MaskSecret(c.OpenAI.APIKey)
+	masked.Replicate.APIToken = MaskSecret(c.Replicate.APIToken)
+	masked.Stability.APIKey = MaskSecret(c.Stability.APIKey)
+	masked.S3SecretAccessKey = MaskSecret(c.S3SecretAccessKey)
+	masked.TelegramBotToken = MaskSecret(c.TelegramBotToken)
+	masked.WebhookSecret = MaskSecret(c.WebhookSecret)
+	masked.Alerts.WebhookURL = MaskSecret(c.Alerts.WebhookURL)
+	masked.Events.NATSURL = maskURLPassword(c.Events.NATSURL)
+	masked.Events.NATSPassword = MaskSecret(c.Events.NATSPassword)
+	masked.Events.NATSToken = MaskSecret(c.Events.NATSToken)
+	masked.Queue.RedisURL = maskURLPassword(c.Queue.RedisURL)
+	masked.APIToken = MaskSecret(c.APIToken)
+	masked.SentryDSN = MaskSecret(c.SentryDSN)
+	masked.DB.Password = MaskSecret(c.DB.Password)
+	masked.DB.URL = maskURLPassword(c.DB.URL)
+	return &masked
+}
+
+// Secrets returns the credentials of the configuration that are set, including the passwords
+// of its URLs, e.g. to mask them with a redact.Redactor
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, secret := range []string{
+		c.FusionBrain.APIKey,
+		c.FusionBrain.SecretKey,
+		urlPassword(c.FusionBrain.ProxyURL),
+		c.OpenAI.APIKey,
+		c.Replicate.APIToken,
+		c.Stability.APIKey,
+		c.S3SecretAccessKey,
+		c.TelegramBotToken,
+		c.WebhookSecret,
+		c.Alerts.WebhookURL,
+		urlPassword(c.Events.NATSURL),
+		c.Events.NATSPassword,
+		c.Events.NATSToken,
+		urlPassword(c.Queue.RedisURL),
+		c.APIToken,
+		c.SentryDSN,
+		c.DB.Password,
+		urlPassword(c.DB.URL),
+	} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// urlPassword returns the password in the user info of rawURL, if any
+func urlPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return ""
+	}
+	password, _ := u.User.Password()
+	return password
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// secretConfig returns a configuration with every credential set to a distinct secret, and the
+// secrets by setting
+func secretConfig() (*Config, map[string]string) {
+	secrets := map[string]string{
+		"FUSION_BRAIN_API_KEY":    "fb-api-key-0001",
+		"FUSION_BRAIN_SECRET_KEY": "fb-secret-key-0002",
+		"FUSION_BRAIN_PROXY_URL":  "proxy-pass-0003",
+		"OPENAI_API_KEY":          "sk-openai-0004",
+		"REPLICATE_API_TOKEN":     "r8_replicate-0005",
+		"STABILITY_API_KEY":       "sk-stability-0006",
+		"S3_SECRET_ACCESS_KEY":    "s3-secret-0007",
+		"TELEGRAM_BOT_TOKEN":      "123456:telegram-0008",
+		"WEBHOOK_SECRET":          "webhook-secret-0009",
+		"ALERT_WEBHOOK_URL":       "https://hooks.example.com/services/alert-0010",
+		"EVENTS_NATS_URL":         "nats-pass-0011",
+		"EVENTS_NATS_PASSWORD":    "nats-password-0012",
+		"EVENTS_NATS_TOKEN":       "nats-token-0013",
+		"REDIS_URL":               "redis-pass-0014",
+		"API_TOKEN":               "api-token-0015",
+		"SENTRY_DSN":              "https://sentry-key-0016@o0.ingest.sentry.io/1",
+		"DB_PASSWORD":             "db-password-0017",
+		"DATABASE_URL":            "db-url-pass-0018",
+	}
+	cfg := &Config{}
+	cfg.FusionBrain.APIKey = secrets["FUSION_BRAIN_API_KEY"]
+	cfg.FusionBrain.SecretKey = secrets["FUSION_BRAIN_SECRET_KEY"]
+	cfg.FusionBrain.ProxyURL = "http://proxy-user:" + secrets["FUSION_BRAIN_PROXY_URL"] + "@proxy.example.com:3128"
+	cfg.OpenAI.APIKey = secrets["OPENAI_API_KEY"]
+	cfg.Replicate.APIToken = secrets["REPLICATE_API_TOKEN"]
+	cfg.Stability.APIKey = secrets["STABILITY_API_KEY"]
+	cfg.S3SecretAccessKey = secrets["S3_SECRET_ACCESS_KEY"]
+	cfg.TelegramBotToken = secrets["TELEGRAM_BOT_TOKEN"]
+	cfg.WebhookSecret = secrets["WEBHOOK_SECRET"]
+	cfg.Alerts.WebhookURL = secrets["ALERT_WEBHOOK_URL"]
+	cfg.Events.NATSURL = "nats://events:" + secrets["EVENTS_NATS_URL"] + "@nats:4222"
+	cfg.Events.NATSPassword = secrets["EVENTS_NATS_PASSWORD"]
+	cfg.Events.NATSToken = secrets["EVENTS_NATS_TOKEN"]
+	cfg.Queue.RedisURL = "redis://:" + secrets["REDIS_URL"] + "@redis:6379/0"
+	cfg.APIToken = secrets["API_TOKEN"]
+	cfg.SentryDSN = secrets["SENTRY_DSN"]
+	cfg.DB.Password = secrets["DB_PASSWORD"]
+	cfg.DB.URL = "postgres://images:" + secrets["DATABASE_URL"] + "@db:5432/images"
+	return cfg, secrets
+}
+
+func TestMaskSecret(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{secret: "", want: ""},
+		{secret: "abc", want: "****"},
+		{secret: "1234567", want: "****"},
+		{secret: "12345678", want: "****5678"},
+		{secret: "sk-abcdefghijklmnop", want: "****mnop"},
+		{secret: "пароль-секрет", want: "****крет"},
+	}
+	for _, tt := range tests {
+		if got := MaskSecret(tt.secret); got != tt.want {
+			t.Errorf("MaskSecret(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+}
+
+func TestMaskURLPassword(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "", want: ""},
+		{url: "postgres://db/images", want: "postgres://db/images"},
+		{url: "postgres://images@db/images", want: "postgres://images@db/images"},
+		{url: "postgres://images:db-password-1@db:5432/images?sslmode=require", want: "postgres://images:****rd-1@db:5432/images?sslmode=require"},
+		{url: "redis://:redis-pass-1@redis:6379/0", want: "redis://:****ss-1@redis:6379/0"},
+		{url: "postgres://images:p%40ss%20word-1@db/images", want: "postgres://images:****rd-1@db/images"},
+	}
+	for _, tt := range tests {
+		if got := maskURLPassword(tt.url); got != tt.want {
+			t.Errorf("maskURLPassword(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMaskedNeverLeaksSecrets(t *testing.T) {
+	cfg, secrets := secretConfig()
+	data, err := json.Marshal(cfg.Masked())
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for name, secret := range secrets {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("masked configuration contains %s in full", name)
+		}
+		// Only the last four characters are kept for telling keys apart
+		if !strings.Contains(string(data), "****"+secret[len(secret)-4:]) {
+			t.Errorf("masked configuration has no trace of %s", name)
+		}
+	}
+
+	// The original is left untouched
+	if cfg.FusionBrain.APIKey != secrets["FUSION_BRAIN_API_KEY"] || cfg.DB.Password != secrets["DB_PASSWORD"] {
+		t.Error("Masked() modified the configuration it was called on")
+	}
+}
+
+func TestSecretsListsEveryCredential(t *testing.T) {
+	cfg, secrets := secretConfig()
+	listed := strings.Join(cfg.Secrets(), "\n")
+	for name, secret := range secrets {
+		if !strings.Contains(listed, secret) {
+			t.Errorf("Secrets() does not include %s", name)
+		}
+	}
+	if got := (&Config{}).Secrets(); len(got) != 0 {
+		t.Errorf("Secrets() of an empty configuration = %q, want none", got)
+	}
+}
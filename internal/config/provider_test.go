@@ -0,0 +1,94 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadProviders loads env requiring the credentials of the selected providers
+func loadProviders(env map[string]string) (*Config, error) {
+	return load(func(name string) string { return env[name] }, true, false)
+}
+
+func TestProviderRequiredFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "fusionbrain by default", env: map[string]string{}, wantErr: "FUSION_BRAIN_API_KEY is required"},
+		{name: "fusionbrain secret", env: map[string]string{"FUSION_BRAIN_API_KEY": "key"}, wantErr: "FUSION_BRAIN_SECRET_KEY is required"},
+		{name: "fusionbrain", env: map[string]string{"FUSION_BRAIN_API_KEY": "key", "FUSION_BRAIN_SECRET_KEY": "secret"}},
+		{name: "openai key", env: map[string]string{"PROVIDER": "openai"}, wantErr: "OPENAI_API_KEY is required"},
+		// Only the selected provider needs credentials
+		{name: "openai", env: map[string]string{"PROVIDER": "openai", "OPENAI_API_KEY": "sk-test"}},
+		{name: "replicate token", env: map[string]string{"PROVIDER": "replicate", "REPLICATE_MODEL": "owner/model"}, wantErr: "REPLICATE_API_TOKEN is required"},
+		{name: "replicate model", env: map[string]string{"PROVIDER": "replicate", "REPLICATE_API_TOKEN": "r8_test"}, wantErr: "REPLICATE_MODEL is required"},
+		{name: "replicate", env: map[string]string{"PROVIDER": "replicate", "REPLICATE_API_TOKEN": "r8_test", "REPLICATE_MODEL": "owner/model"}},
+		{name: "stability key", env: map[string]string{"PROVIDER": "stability"}, wantErr: "STABILITY_API_KEY is required"},
+		{name: "stability", env: map[string]string{"PROVIDER": "stability", "STABILITY_API_KEY": "sk-test"}},
+		{name: "unknown", env: map[string]string{"PROVIDER": "midjourney"}, wantErr: `unknown provider "midjourney"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadProviders(tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("load() error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Commands that only use the database need no credentials at all
+	if _, err := loadMap(t, map[string]string{"PROVIDER": "openai"}); err != nil {
+		t.Errorf("load() without providers required error = %v", err)
+	}
+}
+
+func TestProviderFallbackList(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantList     []string
+		wantProvider string
+	}{
+		{name: "default", env: map[string]string{}, wantList: []string{"fusionbrain"}, wantProvider: "fusionbrain"},
+		{name: "PROVIDER", env: map[string]string{"PROVIDER": "openai"}, wantList: []string{"openai"}, wantProvider: "openai"},
+		{name: "PROVIDERS", env: map[string]string{"PROVIDERS": "openai,stability"}, wantList: []string{"openai", "stability"}, wantProvider: "openai"},
+		{name: "PROVIDERS over PROVIDER", env: map[string]string{"PROVIDER": "stability", "PROVIDERS": "fusionbrain,openai"}, wantList: []string{"fusionbrain", "openai"}, wantProvider: "fusionbrain"},
+		{name: "spaces and case", env: map[string]string{"PROVIDERS": " OpenAI , Stability "}, wantList: []string{"openai", "stability"}, wantProvider: "openai"},
+		{name: "duplicates tried once", env: map[string]string{"PROVIDERS": "openai,stability,openai"}, wantList: []string{"openai", "stability"}, wantProvider: "openai"},
+		{name: "empty entries", env: map[string]string{"PROVIDERS": ",openai,,"}, wantList: []string{"openai"}, wantProvider: "openai"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadMap(t, tt.env)
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Providers, tt.wantList) || cfg.Provider != tt.wantProvider {
+				t.Errorf("providers = %v (first %q), want %v (first %q)", cfg.Providers, cfg.Provider, tt.wantList, tt.wantProvider)
+			}
+		})
+	}
+}
+
+func TestProviderFallbackListRequiresEveryProvider(t *testing.T) {
+	env := map[string]string{"PROVIDERS": "openai,stability,replicate", "OPENAI_API_KEY": "sk-test"}
+	_, err := loadProviders(env)
+	// Every provider of the chain is checked and all gaps are reported together
+	for _, want := range []string{"STABILITY_API_KEY is required", "REPLICATE_API_TOKEN is required"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("load() error = %v, want it to contain %q", err, want)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "FUSION_BRAIN") {
+		t.Errorf("load() error = %v, want no Fusion Brain credentials required", err)
+	}
+}
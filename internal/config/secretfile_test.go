@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// secretFile writes content to a temporary file and returns its path
+func secretFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "no newline", content: "s3cret", want: "s3cret"},
+		{name: "trailing newline", content: "s3cret\n", want: "s3cret"},
+		{name: "windows newline", content: "s3cret\r\n", want: "s3cret"},
+		{name: "surrounding blank lines", content: "\n  s3cret  \n\n", want: "s3cret"},
+		{name: "inner spaces kept", content: "two words\n", want: "two words"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := secretFile(t, tt.content)
+			env := map[string]string{
+				"FUSION_BRAIN_API_KEY_FILE":    path,
+				"FUSION_BRAIN_SECRET_KEY_FILE": path,
+				"DB_PASSWORD_FILE":             path,
+				// Any setting has a _FILE variant, not only the secrets named above
+				"OPENAI_API_KEY_FILE": path,
+			}
+			cfg, err := loadMap(t, env)
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			for name, got := range map[string]string{
+				"FUSION_BRAIN_API_KEY":    cfg.FusionBrain.APIKey,
+				"FUSION_BRAIN_SECRET_KEY": cfg.FusionBrain.SecretKey,
+				"DB_PASSWORD":             cfg.DB.Password,
+				"OPENAI_API_KEY":          cfg.OpenAI.APIKey,
+			} {
+				if got != tt.want {
+					t.Errorf("%s = %q, want %q", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretFileErrors(t *testing.T) {
+	path := secretFile(t, "s3cret\n")
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name:     "both set",
+			env:      map[string]string{"DB_PASSWORD": "plain", "DB_PASSWORD_FILE": path},
+			wantErrs: []string{"DB_PASSWORD and DB_PASSWORD_FILE are both set, use only one"},
+		},
+		{
+			name:     "unreadable",
+			env:      map[string]string{"FUSION_BRAIN_SECRET_KEY_FILE": filepath.Join(t.TempDir(), "missing")},
+			wantErrs: []string{"failed to read FUSION_BRAIN_SECRET_KEY_FILE"},
+		},
+		{
+			name: "every problem reported",
+			env: map[string]string{
+				"DB_PASSWORD": "plain", "DB_PASSWORD_FILE": path,
+				"FUSION_BRAIN_API_KEY_FILE": filepath.Join(t.TempDir(), "missing"),
+			},
+			wantErrs: []string{"DB_PASSWORD and DB_PASSWORD_FILE are both set", "failed to read FUSION_BRAIN_API_KEY_FILE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMap(t, tt.env)
+			for _, want := range tt.wantErrs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("load() error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// sslModes are the DB_SSL_MODE values the PostgreSQL driver supports
+var sslModes = map[string]bool{
+	"disable":     true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// settings parses numeric and boolean settings, collecting the values that are set but malformed
+type settings struct {
+	getenv    func(string) string
+	malformed []error
+}
+
+// lookup returns the named setting. When NAME_FILE is set instead, the setting is read from the
+// file it names with surrounding whitespace trimmed, so secrets can be mounted as files.
+func (s *settings) lookup(name string) string {
+	value, path := s.getenv(name), s.getenv(name+"_FILE")
+	if path == "" {
+		return value
+	}
+	if value != "" {
+		s.malformed = append(s.malformed, fmt.Errorf("%s and %s_FILE are both set, use only one", name, name))
+		return value
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.malformed = append(s.malformed, fmt.Errorf("failed to read %s_FILE: %w", name, err))
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// atoi parses the named setting as a whole number; unset settings return an error without
+// being reported as malformed
+func (s *settings) atoi(name string) (int, error) {
+	value := s.lookup(name)
+	n, err := strconv.Atoi(value)
+	if err != nil && value != "" {
+		s.malformed = append(s.malformed, fmt.Errorf("invalid %s %q: expected a whole number", name, value))
+	}
+	return n, err
+}
+
+// parseFloat parses the named setting as a number, like atoi
+func (s *settings) parseFloat(name string) (float64, error) {
+	value := s.lookup(name)
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil && value != "" {
+		s.malformed = append(s.malformed, fmt.Errorf("invalid %s %q: expected a number", name, value))
+	}
+	return f, err
+}
+
+// parseBool parses the named setting as a boolean, like atoi
+func (s *settings) parseBool(name string) (bool, error) {
+	value := s.lookup(name)
+	b, err := strconv.ParseBool(value)
+	if err != nil && value != "" {
+		s.malformed = append(s.malformed, fmt.Errorf("invalid %s %q: expected true or false", name, value))
+	}
+	return b, err
+}
+
+// duration parses the named setting as a Go duration such as 1m30s, or as whole seconds, like atoi
+func (s *settings) duration(name string) (time.Duration, error) {
+	value := s.lookup(name)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil && value != "" {
+		s.malformed = append(s.malformed, fmt.Errorf("invalid %s %q: expected a duration such as 30s or 5m, or whole seconds", name, value))
+	}
+	return d, err
+}
+
+// err returns the malformed settings joined, or nil
+func (s *settings) err() error {
+	return errors.Join(s.malformed...)
+}
+
+// Validate checks the default image parameters and durations, returning every violation joined
+func (c *Config) Validate() error {
+	var errs []error
+
+	for _, d := range []struct {
+		name  string
+		value int
+	}{{"DEFAULT_IMAGE_WIDTH", c.DefaultImageWidth}, {"DEFAULT_IMAGE_HEIGHT", c.DefaultImageHeight}} {
+		if d.value < domain.MinImageDimension || d.value > domain.MaxImageDimension {
+			errs = append(errs, fmt.Errorf("%s %d must be between %d and %d", d.name, d.value, domain.MinImageDimension, domain.MaxImageDimension))
+		} else if d.value%domain.ImageDimensionStep != 0 && !c.SnapDimensions {
+			errs = append(errs, fmt.Errorf("%s %d must be a multiple of %d", d.name, d.value, domain.ImageDimensionStep))
+		}
+	}
+
+	if c.DefaultNumImages < 1 || c.DefaultNumImages > domain.MaxNumImages {
+		errs = append(errs, fmt.Errorf("DEFAULT_NUM_IMAGES %d must be between 1 and %d", c.DefaultNumImages, domain.MaxNumImages))
+	}
+
+	if c.MaxPromptLength < 1 {
+		errs = append(errs, fmt.Errorf("MAX_PROMPT_LENGTH %d must be at least 1", c.MaxPromptLength))
+	}
+
+	if !domain.ValidStyle(c.DefaultStyle) {
+		errs = append(errs, fmt.Errorf("unknown DEFAULT_STYLE %q, expected one of %s", c.DefaultStyle, strings.Join(domain.Styles, ", ")))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"DEFAULT_GENERATION_TIMEOUT", c.GenerationTimeout},
+		{"DEFAULT_CHECK_INTERVAL", c.CheckInterval},
+		{"POLL_MAX_INTERVAL", c.PollMaxInterval},
+		{"S3_PRESIGN_TTL", c.S3PresignTTL},
+		{"CACHE_TTL", c.CacheTTL},
+		{"STATS_INTERVAL", c.StatsInterval},
+		{"DB_CONN_MAX_LIFETIME", c.DB.ConnMaxLifetime},
+		{"DB_CONNECT_TIMEOUT", c.DB.ConnectTimeout},
+		{"DB_CONNECT_RETRY", c.DB.ConnectRetry},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+
+	if c.PprofMutexFraction < 0 || c.PprofBlockRate < 0 {
+		errs = append(errs, fmt.Errorf("PPROF_MUTEX_FRACTION and PPROF_BLOCK_RATE must not be negative"))
+	}
+
+	if c.NotFoundMaxResets < 0 {
+		errs = append(errs, fmt.Errorf("NOT_FOUND_MAX_RESETS %d must not be negative", c.NotFoundMaxResets))
+	}
+	if c.DailyRequestCap < 0 {
+		errs = append(errs, fmt.Errorf("DAILY_REQUEST_CAP %d must not be negative", c.DailyRequestCap))
+	}
+	if c.DailySpendCap < 0 || c.RequestCost < 0 {
+		errs = append(errs, fmt.Errorf("DAILY_SPEND_CAP and REQUEST_COST must not be negative"))
+	} else if c.DailySpendCap > 0 && c.RequestCost == 0 {
+		errs = append(errs, fmt.Errorf("REQUEST_COST is required when DAILY_SPEND_CAP is set"))
+	}
+
+	if !sslModes[c.DB.SSLMode] {
+		errs = append(errs, fmt.Errorf("unknown DB_SSL_MODE %q, expected disable, require, verify-ca or verify-full", c.DB.SSLMode))
+	}
+
+	errs = append(errs, c.Workflow.validate()...)
+	errs = append(errs, c.Alerts.validate()...)
+	errs = append(errs, c.Events.validate()...)
+	errs = append(errs, c.Queue.validate()...)
+
+	return errors.Join(errs...)
+}
+
+// validate checks the workflow knobs, returning every violation
+func (w WorkflowConfig) validate() []error {
+	var errs []error
+	if w.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("WORKER_CONCURRENCY %d must be at least 1", w.Concurrency))
+	}
+	if w.BatchSize < 0 {
+		errs = append(errs, fmt.Errorf("BATCH_SIZE %d must not be negative", w.BatchSize))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"RETRY_BASE_DELAY", w.RetryBaseDelay},
+		{"RETRY_MAX_DELAY", w.RetryMaxDelay},
+		{"IDLE_BACKOFF_MAX", w.IdleBackoffMax},
+		{"ERROR_BACKOFF_MAX", w.ErrorBackoffMax},
+		{"SHUTDOWN_TIMEOUT", w.ShutdownTimeout},
+		{"CLAIM_LEASE", w.ClaimLease},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.name))
+		}
+	}
+	if w.RetryMaxDelay < w.RetryBaseDelay {
+		errs = append(errs, fmt.Errorf("RETRY_MAX_DELAY %s must not be below RETRY_BASE_DELAY %s", w.RetryMaxDelay, w.RetryBaseDelay))
+	}
+	return errs
+}
+
+// validate checks the alert settings, returning every violation
+func (a AlertConfig) validate() []error {
+	var errs []error
+	if a.WebhookURL != "" {
+		if u, err := url.Parse(a.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("ALERT_WEBHOOK_URL must be an http or https URL"))
+		}
+	}
+	if a.Cooldown < 0 || a.BacklogAge < 0 || a.NoGenerationAfter < 0 {
+		errs = append(errs, fmt.Errorf("ALERT_COOLDOWN, ALERT_BACKLOG_AGE and ALERT_NO_GENERATION_AFTER must not be negative"))
+	}
+	if a.FailureRate < 0 || a.FailureRate > 1 {
+		errs = append(errs, fmt.Errorf("ALERT_FAILURE_RATE %g must be between 0 and 1", a.FailureRate))
+	}
+	return errs
+}
+
+// validate checks the event settings, returning every violation
+func (e EventsConfig) validate() []error {
+	var errs []error
+	if e.NATSURL != "" {
+		rawURL := e.NATSURL
+		if !strings.Contains(rawURL, "://") {
+			rawURL = "nats://" + rawURL
+		}
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+			errs = append(errs, fmt.Errorf("EVENTS_NATS_URL must be a nats:// or tls:// URL"))
+		}
+	}
+	if e.NATSToken != "" && (e.NATSUser != "" || e.NATSPassword != "") {
+		errs = append(errs, fmt.Errorf("EVENTS_NATS_TOKEN cannot be combined with EVENTS_NATS_USER and EVENTS_NATS_PASSWORD"))
+	}
+	return errs
+}
+
+// validate checks the queue settings, returning every violation
+func (q QueueConfig) validate() []error {
+	if q.Backend != "redis" {
+		return nil
+	}
+	var errs []error
+	if u, err := url.Parse(q.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		errs = append(errs, fmt.Errorf("REDIS_URL must be a redis:// or rediss:// URL"))
+	}
+	if q.VisibilityTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("QUEUE_VISIBILITY_TIMEOUT %s must be at least 1s", q.VisibilityTimeout))
+	}
+	if q.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("QUEUE_POLL_INTERVAL must be positive"))
+	}
+	if q.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("QUEUE_MAX_ATTEMPTS %d must be at least 1", q.MaxAttempts))
+	}
+	if q.ResultTTL < 0 {
+		errs = append(errs, fmt.Errorf("QUEUE_RESULT_TTL must not be negative"))
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFailureMatrix(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{name: "defaults", env: map[string]string{}},
+		{name: "width zero", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "0"}, wantErrs: []string{"DEFAULT_IMAGE_WIDTH 0 must be between 128 and 2048"}},
+		{name: "height too large", env: map[string]string{"DEFAULT_IMAGE_HEIGHT": "4096"}, wantErrs: []string{"DEFAULT_IMAGE_HEIGHT 4096 must be between 128 and 2048"}},
+		{name: "width off the grid", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "1000"}, wantErrs: []string{"DEFAULT_IMAGE_WIDTH 1000 must be a multiple of 64"}},
+		{name: "width off the grid snapped", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "1000", "SNAP_DIMENSIONS": "true"}},
+		{name: "no images", env: map[string]string{"DEFAULT_NUM_IMAGES": "0"}, wantErrs: []string{"DEFAULT_NUM_IMAGES 0 must be between 1 and 10"}},
+		{name: "negative images", env: map[string]string{"DEFAULT_NUM_IMAGES": "-2"}, wantErrs: []string{"DEFAULT_NUM_IMAGES -2 must be between 1 and 10"}},
+		{name: "too many images", env: map[string]string{"DEFAULT_NUM_IMAGES": "11"}, wantErrs: []string{"DEFAULT_NUM_IMAGES 11 must be between 1 and 10"}},
+		{name: "ten images", env: map[string]string{"DEFAULT_NUM_IMAGES": "10"}},
+		{name: "unknown style", env: map[string]string{"DEFAULT_STYLE": "anime"}, wantErrs: []string{`unknown DEFAULT_STYLE "anime", expected one of DEFAULT, KANDINSKY, UHD, ANIME`}},
+		{name: "known style", env: map[string]string{"DEFAULT_STYLE": "ANIME"}},
+		{name: "negative timeout", env: map[string]string{"DEFAULT_GENERATION_TIMEOUT": "-5"}, wantErrs: []string{"DEFAULT_GENERATION_TIMEOUT must not be negative"}},
+		{name: "negative check interval", env: map[string]string{"DEFAULT_CHECK_INTERVAL": "-1"}, wantErrs: []string{"DEFAULT_CHECK_INTERVAL must not be negative"}},
+		{name: "prompt length zero", env: map[string]string{"MAX_PROMPT_LENGTH": "0"}, wantErrs: []string{"MAX_PROMPT_LENGTH 0 must be at least 1"}},
+		{name: "malformed width", env: map[string]string{"DEFAULT_IMAGE_WIDTH": "abc"}, wantErrs: []string{`invalid DEFAULT_IMAGE_WIDTH "abc": expected a whole number`}},
+		{name: "malformed bool", env: map[string]string{"SNAP_DIMENSIONS": "yes"}, wantErrs: []string{`invalid SNAP_DIMENSIONS "yes": expected true or false`}},
+		{name: "malformed float", env: map[string]string{"API_RPS": "fast"}, wantErrs: []string{`invalid API_RPS "fast": expected a number`}},
+		{name: "unknown ssl mode", env: map[string]string{"DB_SSL_MODE": "prefer"}, wantErrs: []string{`unknown DB_SSL_MODE "prefer"`}},
+		{
+			name: "every violation reported at once",
+			env: map[string]string{
+				"DEFAULT_IMAGE_WIDTH":        "0",
+				"DEFAULT_IMAGE_HEIGHT":       "abc",
+				"DEFAULT_NUM_IMAGES":         "-1",
+				"DEFAULT_STYLE":              "PHOTO",
+				"DEFAULT_GENERATION_TIMEOUT": "-1",
+			},
+			wantErrs: []string{
+				"DEFAULT_IMAGE_WIDTH 0 must be between",
+				`invalid DEFAULT_IMAGE_HEIGHT "abc"`,
+				"DEFAULT_NUM_IMAGES -1 must be between",
+				`unknown DEFAULT_STYLE "PHOTO"`,
+				"DEFAULT_GENERATION_TIMEOUT must not be negative",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMap(t, tt.env)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("load() error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("load() error = nil, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("load() error = %v, want it to contain %q", err, want)
+				}
+			}
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.wantErrs) {
+				t.Errorf("load() reported %d violations, want %d:\n%v", got, len(tt.wantErrs), err)
+			}
+		})
+	}
+}
+
+func TestValidateDirectly(t *testing.T) {
+	cfg, err := loadMap(t, map[string]string{})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() of the defaults error = %v", err)
+	}
+
+	// Library users building a Config by hand get the same checks
+	cfg.DefaultImageWidth = 100
+	cfg.Workflow.Concurrency = 0
+	cfg.Workflow.RetryMaxDelay = cfg.Workflow.RetryBaseDelay / 2
+	err = cfg.Validate()
+	for _, want := range []string{"DEFAULT_IMAGE_WIDTH 100", "WORKER_CONCURRENCY 0 must be at least 1", "RETRY_MAX_DELAY 15s must not be below RETRY_BASE_DELAY 30s"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkflowKnobs(t *testing.T) {
+	cfg, err := loadMap(t, map[string]string{})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	want := WorkflowConfig{
+		Concurrency:     4,
+		BatchSize:       100,
+		RetryBaseDelay:  30 * time.Second,
+		RetryMaxDelay:   time.Hour,
+		IdleBackoffMax:  2 * time.Minute,
+		ErrorBackoffMax: 2 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		WorkerID:        cfg.Workflow.WorkerID,
+		ClaimLease:      2 * time.Minute,
+	}
+	if cfg.Workflow != want {
+		t.Errorf("default workflow knobs = %+v, want %+v", cfg.Workflow, want)
+	}
+	if cfg.Workflow.WorkerID == "" {
+		t.Error("default WorkerID is empty, want the host name and process ID")
+	}
+
+	cfg, err = loadMap(t, map[string]string{
+		"WORKER_CONCURRENCY": "16",
+		"BATCH_SIZE":         "0",
+		"RETRY_BASE_DELAY":   "1m30s",
+		"RETRY_MAX_DELAY":    "7200",
+		"IDLE_BACKOFF_MAX":   "45s",
+		"ERROR_BACKOFF_MAX":  "5m",
+		"SHUTDOWN_TIMEOUT":   "10",
+		"WORKER_ID":          "replica-2",
+		"CLAIM_LEASE":        "90s",
+	})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	want = WorkflowConfig{
+		Concurrency:     16,
+		BatchSize:       0,
+		RetryBaseDelay:  90 * time.Second,
+		RetryMaxDelay:   2 * time.Hour,
+		IdleBackoffMax:  45 * time.Second,
+		ErrorBackoffMax: 5 * time.Minute,
+		ShutdownTimeout: 10 * time.Second,
+		WorkerID:        "replica-2",
+		ClaimLease:      90 * time.Second,
+	}
+	if cfg.Workflow != want {
+		t.Errorf("workflow knobs = %+v, want %+v", cfg.Workflow, want)
+	}
+
+	// IDLE_MAX_INTERVAL is the old name of IDLE_BACKOFF_MAX, which wins when both are set
+	cfg, _ = loadMap(t, map[string]string{"IDLE_MAX_INTERVAL": "1m"})
+	if cfg.Workflow.IdleBackoffMax != time.Minute {
+		t.Errorf("IdleBackoffMax from IDLE_MAX_INTERVAL = %v, want 1m", cfg.Workflow.IdleBackoffMax)
+	}
+	cfg, _ = loadMap(t, map[string]string{"IDLE_MAX_INTERVAL": "1m", "IDLE_BACKOFF_MAX": "3m"})
+	if cfg.Workflow.IdleBackoffMax != 3*time.Minute {
+		t.Errorf("IdleBackoffMax = %v, want IDLE_BACKOFF_MAX", cfg.Workflow.IdleBackoffMax)
+	}
+}
+
+func TestWorkflowKnobTypos(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "RETRY_BASE_DELAY", value: "30x", wantErr: `invalid RETRY_BASE_DELAY "30x": expected a duration such as 30s or 5m, or whole seconds`},
+		{name: "RETRY_MAX_DELAY", value: "1 h", wantErr: `invalid RETRY_MAX_DELAY "1 h"`},
+		{name: "IDLE_BACKOFF_MAX", value: "two minutes", wantErr: `invalid IDLE_BACKOFF_MAX "two minutes"`},
+		{name: "SHUTDOWN_TIMEOUT", value: "30S", wantErr: `invalid SHUTDOWN_TIMEOUT "30S"`},
+		{name: "CLAIM_LEASE", value: "2.5", wantErr: `invalid CLAIM_LEASE "2.5"`},
+		{name: "WORKER_CONCURRENCY", value: "4.0", wantErr: `invalid WORKER_CONCURRENCY "4.0": expected a whole number`},
+		{name: "BATCH_SIZE", value: "100 ", wantErr: `invalid BATCH_SIZE "100 "`},
+		// Well-formed values out of range fail as loudly
+		{name: "WORKER_CONCURRENCY", value: "0", wantErr: "WORKER_CONCURRENCY 0 must be at least 1"},
+		{name: "BATCH_SIZE", value: "-1", wantErr: "BATCH_SIZE -1 must not be negative"},
+		{name: "SHUTDOWN_TIMEOUT", value: "0s", wantErr: "SHUTDOWN_TIMEOUT must be positive"},
+		{name: "RETRY_BASE_DELAY", value: "-30s", wantErr: "RETRY_BASE_DELAY must be positive"},
+		{name: "RETRY_MAX_DELAY", value: "10s", wantErr: "RETRY_MAX_DELAY 10s must not be below RETRY_BASE_DELAY 30s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			_, err := loadMap(t, map[string]string{tt.name: tt.value})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
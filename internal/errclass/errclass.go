@@ -0,0 +1,122 @@
+// Package errclass classifies errors from providers and the network so workflows
+// can decide whether an image should be retried or marked Failed.
+package errclass
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// statusCoder is implemented by provider errors that carry an HTTP status
+type statusCoder interface {
+	HTTPStatus() int
+}
+
+// httpStatus extracts the HTTP status from err, if it carries one
+func httpStatus(err error) (int, bool) {
+	var sc statusCoder
+	if errors.As(err, &sc) {
+		return sc.HTTPStatus(), true
+	}
+	return 0, false
+}
+
+// IsNotFound reports whether the requested generation does not exist at the provider
+func IsNotFound(err error) bool {
+	status, ok := httpStatus(err)
+	return ok && status == http.StatusNotFound
+}
+
+// IsCensored reports whether the provider censored the generation
+func IsCensored(err error) bool {
+	return errors.Is(err, domain.ErrCensored)
+}
+
+// IsRateLimited reports whether the provider throttled the request or its quota is used up
+func IsRateLimited(err error) bool {
+	if errors.Is(err, domain.ErrQuotaExceeded) {
+		return true
+	}
+	status, ok := httpStatus(err)
+	return ok && status == http.StatusTooManyRequests
+}
+
+// IsAuth reports whether the provider rejected the credentials
+func IsAuth(err error) bool {
+	status, ok := httpStatus(err)
+	return ok && (status == http.StatusUnauthorized || status == http.StatusForbidden)
+}
+
+// IsRetryable reports whether the operation may succeed if attempted again later.
+// Network failures, timeouts, cancellation, throttling, exhausted quotas and unavailable
+// providers are retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if IsCensored(err) || errors.Is(err, domain.ErrInvalidPrompt) || errors.Is(err, domain.ErrInvalidRequest) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, domain.ErrProviderUnavailable) || errors.Is(err, domain.ErrQuotaExceeded) {
+		return true
+	}
+
+	if status, ok := httpStatus(err); ok {
+		return status == http.StatusRequestTimeout ||
+			status == http.StatusTooManyRequests ||
+			status >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// IsPermanent reports whether the operation will keep failing no matter how often it is retried
+func IsPermanent(err error) bool {
+	return err != nil && !IsRetryable(err)
+}
+
+// Classes of failures recorded with Failed images
+const (
+	// ClassTransient failures came from outages, timeouts, throttling or the provider failing
+	// the generation, and may succeed when the image is generated again
+	ClassTransient = "transient"
+	// ClassCensored failures were censored by the provider
+	ClassCensored = "censored"
+	// ClassInvalid failures were rejected for their parameters, e.g. invalid dimensions
+	ClassInvalid = "invalid"
+	// ClassAuth failures were rejected for the credentials
+	ClassAuth = "auth"
+	// ClassPermanent covers every other failure
+	ClassPermanent = "permanent"
+)
+
+// RequeueableClasses are the classes of failures worth generating again after a while
+var RequeueableClasses = []string{ClassTransient}
+
+// Class returns the class of the failure err
+func Class(err error) string {
+	switch {
+	case IsCensored(err):
+		return ClassCensored
+	case errors.Is(err, domain.ErrInvalidDimensions), errors.Is(err, domain.ErrInvalidPrompt), errors.Is(err, domain.ErrInvalidRequest):
+		return ClassInvalid
+	case IsAuth(err):
+		return ClassAuth
+	case IsRetryable(err), errors.Is(err, domain.ErrGenerationFailed), errors.Is(err, domain.ErrGenerationTimeout):
+		return ClassTransient
+	}
+	return ClassPermanent
+}
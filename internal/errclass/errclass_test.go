@@ -0,0 +1,94 @@
+package errclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// statusError is a provider error carrying an HTTP status, unwrapping like the clients' errors
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("unexpected status code: %d", int(e)) }
+func (e statusError) HTTPStatus() int { return int(e) }
+func (e statusError) Unwrap() error   { return domain.ErrorForStatus(int(e)) }
+
+// timeoutError is a network timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		class       string
+		retryable   bool
+		auth        bool
+		notFound    bool
+		rateLimited bool
+		censored    bool
+	}{
+		{name: "nil", err: nil, class: ClassPermanent},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, class: ClassTransient, retryable: true},
+		{name: "canceled", err: context.Canceled, class: ClassTransient, retryable: true},
+		{name: "wrapped deadline", err: fmt.Errorf("failed to send request: %w", context.DeadlineExceeded), class: ClassTransient, retryable: true},
+		{name: "network timeout", err: &net.OpError{Op: "dial", Err: timeoutError{}}, class: ClassTransient, retryable: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, class: ClassTransient, retryable: true},
+		{name: "EOF", err: fmt.Errorf("failed to decode response: %w", io.EOF), class: ClassTransient, retryable: true},
+		{name: "provider unavailable", err: domain.ErrProviderUnavailable, class: ClassTransient, retryable: true},
+		{name: "quota exceeded", err: domain.ErrQuotaExceeded, class: ClassTransient, retryable: true, rateLimited: true},
+		{name: "400", err: statusError(http.StatusBadRequest), class: ClassInvalid},
+		{name: "401", err: statusError(http.StatusUnauthorized), class: ClassAuth, auth: true},
+		{name: "402", err: statusError(http.StatusPaymentRequired), class: ClassTransient, retryable: true, rateLimited: true},
+		{name: "403", err: statusError(http.StatusForbidden), class: ClassAuth, auth: true},
+		{name: "404", err: statusError(http.StatusNotFound), class: ClassPermanent, notFound: true},
+		{name: "408", err: statusError(http.StatusRequestTimeout), class: ClassTransient, retryable: true},
+		{name: "409", err: statusError(http.StatusConflict), class: ClassPermanent},
+		{name: "422", err: statusError(http.StatusUnprocessableEntity), class: ClassInvalid},
+		{name: "429", err: statusError(http.StatusTooManyRequests), class: ClassTransient, retryable: true, rateLimited: true},
+		{name: "500", err: statusError(http.StatusInternalServerError), class: ClassTransient, retryable: true},
+		{name: "503", err: statusError(http.StatusServiceUnavailable), class: ClassTransient, retryable: true},
+		{name: "wrapped 401", err: fmt.Errorf("failed to get pipeline ID: %w", statusError(http.StatusUnauthorized)), class: ClassAuth, auth: true},
+		{name: "censored", err: domain.ErrCensored, class: ClassCensored, censored: true},
+		{name: "invalid prompt", err: domain.ErrInvalidPrompt, class: ClassInvalid},
+		{name: "invalid request", err: fmt.Errorf("%w: width too large", domain.ErrInvalidRequest), class: ClassInvalid},
+		{name: "invalid dimensions", err: domain.ErrInvalidDimensions, class: ClassInvalid},
+		{name: "generation failed", err: &domain.GenerationFailedError{Description: "internal"}, class: ClassTransient},
+		{name: "generation timeout", err: domain.ErrGenerationTimeout, class: ClassTransient},
+		{name: "unknown", err: errors.New("something broke"), class: ClassPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Class(tt.err); got != tt.class {
+				t.Errorf("Class() = %q, want %q", got, tt.class)
+			}
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.retryable)
+			}
+			if got, want := IsPermanent(tt.err), tt.err != nil && !tt.retryable; got != want {
+				t.Errorf("IsPermanent() = %v, want %v", got, want)
+			}
+			if got := IsAuth(tt.err); got != tt.auth {
+				t.Errorf("IsAuth() = %v, want %v", got, tt.auth)
+			}
+			if got := IsNotFound(tt.err); got != tt.notFound {
+				t.Errorf("IsNotFound() = %v, want %v", got, tt.notFound)
+			}
+			if got := IsRateLimited(tt.err); got != tt.rateLimited {
+				t.Errorf("IsRateLimited() = %v, want %v", got, tt.rateLimited)
+			}
+			if got := IsCensored(tt.err); got != tt.censored {
+				t.Errorf("IsCensored() = %v, want %v", got, tt.censored)
+			}
+		})
+	}
+}
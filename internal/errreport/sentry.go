@@ -0,0 +1,76 @@
+// Package errreport sends unexpected failures, such as panics, permanently failed generations and
+// startup errors, to Sentry.
+package errreport
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+)
+
+// Config holds the settings of the Sentry reporter
+type Config struct {
+	// DSN is the Sentry project errors are sent to
+	DSN string
+	// Environment tags every event, e.g. production; empty leaves it to SENTRY_ENVIRONMENT
+	Environment string
+	// Secrets are masked in the messages of every event before it is sent, so that a provider
+	// error quoting a credential does not leak it
+	Secrets []string
+}
+
+// Sentry reports errors to Sentry. Events are sent in the background, so CaptureError never
+// blocks; Flush waits for the events still queued.
+type Sentry struct {
+	client *sentry.Client
+}
+
+// NewSentry creates a reporter sending to the project of cfg.DSN
+func NewSentry(cfg Config) (*Sentry, error) {
+	if cfg.DSN == "" {
+		return nil, errors.New("sentry DSN is required")
+	}
+	secrets := redact.New(cfg.Secrets...)
+
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              cfg.DSN,
+		Environment:      cfg.Environment,
+		AttachStacktrace: true,
+		BeforeSend: func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+			scrub(event, secrets)
+			return event
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Sentry{client: client}, nil
+}
+
+// CaptureError sends err tagged with tags, e.g. the image ID and workflow name
+func (s *Sentry) CaptureError(ctx context.Context, err error, tags map[string]string) {
+	scope := sentry.NewScope()
+	for key, value := range tags {
+		if value != "" {
+			scope.SetTag(key, value)
+		}
+	}
+	s.client.CaptureException(err, &sentry.EventHint{Context: ctx}, scope)
+}
+
+// Flush waits up to timeout for the queued events to be sent and reports whether they were
+func (s *Sentry) Flush(timeout time.Duration) bool {
+	return s.client.Flush(timeout)
+}
+
+// scrub masks secrets in the messages of event
+func scrub(event *sentry.Event, secrets *redact.Redactor) {
+	event.Message = secrets.String(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Value = secrets.String(event.Exception[i].Value)
+	}
+}
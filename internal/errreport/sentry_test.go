@@ -0,0 +1,93 @@
+package errreport_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errreport"
+)
+
+// sentryServer is a fake Sentry project that records the bodies of the events it receives
+type sentryServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+// newSentryServer starts a sentryServer that is closed when the test ends
+func newSentryServer(t *testing.T) *sentryServer {
+	t.Helper()
+	s := &sentryServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// dsn returns a DSN sending to s
+func (s *sentryServer) dsn() string {
+	return strings.Replace(s.URL, "http://", "http://public@", 1) + "/1"
+}
+
+// events returns the bodies received so far
+func (s *sentryServer) events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func TestSentryCaptureError(t *testing.T) {
+	srv := newSentryServer(t)
+	// The secret is made at run time, as the source lines in the stack trace would quote a literal
+	secret := fmt.Sprintf("sk-live-%d", time.Now().UnixNano())
+	reporter, err := errreport.NewSentry(errreport.Config{DSN: srv.dsn(), Environment: "test", Secrets: []string{secret}})
+	if err != nil {
+		t.Fatalf("NewSentry() error = %v", err)
+	}
+
+	reporter.CaptureError(context.Background(), errors.New("provider rejected key "+secret), map[string]string{
+		"workflow": "generator",
+		"image_id": "42",
+		"provider": "fusionbrain",
+		"trace_id": "",
+	})
+	if !reporter.Flush(5 * time.Second) {
+		t.Fatal("Flush() = false, want the event sent")
+	}
+
+	events := srv.events()
+	if len(events) != 1 {
+		t.Fatalf("received %d events, want 1", len(events))
+	}
+	event := events[0]
+	if strings.Contains(event, secret) {
+		t.Errorf("event %s contains the secret", event)
+	}
+	for _, want := range []string{`"workflow":"generator"`, `"image_id":"42"`, `"provider":"fusionbrain"`, "provider rejected key", `"environment":"test"`} {
+		if !strings.Contains(event, want) {
+			t.Errorf("event has no %s: %s", want, event)
+		}
+	}
+	// Empty tags, e.g. the trace ID with tracing off, are left out
+	if strings.Contains(event, `"trace_id"`) {
+		t.Errorf("event has an empty trace_id tag: %s", event)
+	}
+}
+
+func TestNewSentryRequiresDSN(t *testing.T) {
+	if _, err := errreport.NewSentry(errreport.Config{}); err == nil {
+		t.Error("NewSentry() without a DSN error = nil, want an error")
+	}
+}
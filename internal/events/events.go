@@ -0,0 +1,159 @@
+// Package events publishes image lifecycle events, such as finished and failed generations, to
+// a message broker for downstream consumers.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// Event types, which are also the subjects the events are published to
+const (
+	TypeGenerated = "image.generated"
+	TypeFailed    = "image.failed"
+	TypeCensored  = "image.censored"
+	TypePublished = "image.published"
+)
+
+// eventTypes maps the final statuses of images to the type of their event; TimedOut images are
+// reported as failed
+var eventTypes = map[domain.ImageStatus]string{
+	domain.StatusReadyToPublish: TypeGenerated,
+	domain.StatusFailed:         TypeFailed,
+	domain.StatusTimedOut:       TypeFailed,
+	domain.StatusCensored:       TypeCensored,
+	domain.StatusPublished:      TypePublished,
+}
+
+// Event is the JSON body of a published event
+type Event struct {
+	Type     string `json:"type"`
+	ID       int    `json:"id"`
+	UUID     string `json:"uuid,omitempty"`
+	Status   string `json:"status"`
+	Provider string `json:"provider,omitempty"`
+	// Location is where the result can be found: the published URL of published images, the
+	// download URL of the stored file otherwise, if the storage provides one
+	Location string    `json:"location,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// NewEvent returns the event announcing that img reached status at now, and false for statuses
+// without an event
+func NewEvent(img *domain.Image, status domain.ImageStatus, errorDescription string, now time.Time) (Event, bool) {
+	typ, ok := eventTypes[status]
+	if !ok {
+		return Event{}, false
+	}
+
+	location := img.FileURL
+	if status == domain.StatusPublished && img.PublishedURL != "" {
+		location = img.PublishedURL
+	}
+	return Event{
+		Type:     typ,
+		ID:       img.ID,
+		UUID:     img.UUID,
+		Status:   string(status),
+		Provider: img.Provider,
+		Location: location,
+		Error:    errorDescription,
+		Time:     now.UTC(),
+	}, true
+}
+
+// Transport delivers messages to a broker, e.g. a NATS server or a Kafka cluster, where subject
+// names the NATS subject or Kafka topic. Implementations must be safe for concurrent use.
+type Transport interface {
+	// Publish delivers data to subject, returning once the broker accepted it
+	Publish(ctx context.Context, subject string, data []byte) error
+	// Close releases the connection to the broker
+	Close() error
+}
+
+// Publisher publishes events to a transport, retrying failed deliveries with exponential
+// backoff
+type Publisher struct {
+	transport   Transport
+	prefix      string
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+// Option configures optional Publisher settings
+type Option func(*Publisher)
+
+// WithSubjectPrefix publishes events to prefix.<type> rather than <type>
+func WithSubjectPrefix(prefix string) Option {
+	return func(p *Publisher) {
+		p.prefix = prefix
+	}
+}
+
+// WithRetryDelay sets the delay after the first failed delivery; it doubles with every retry
+func WithRetryDelay(delay time.Duration) Option {
+	return func(p *Publisher) {
+		p.retryDelay = delay
+	}
+}
+
+// NewPublisher creates a publisher delivering events through transport in up to maxAttempts
+// attempts
+func NewPublisher(transport Transport, maxAttempts int, opts ...Option) *Publisher {
+	p := &Publisher{
+		transport:   transport,
+		maxAttempts: max(maxAttempts, 1),
+		retryDelay:  time.Second,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Subject returns the subject events of type typ are published to
+func (p *Publisher) Subject(typ string) string {
+	if p.prefix == "" {
+		return typ
+	}
+	return p.prefix + "." + typ
+}
+
+// Publish delivers e until the broker accepts it or the attempts are used up, and returns the
+// number of attempts made
+func (p *Publisher) Publish(ctx context.Context, e Event) (int, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	subject := p.Subject(e.Type)
+	delay := p.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := p.transport.Publish(ctx, subject, body)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt >= p.maxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Close closes the transport
+func (p *Publisher) Close() error {
+	return p.transport.Close()
+}
@@ -0,0 +1,164 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// natsDefaultPort is the client port of NATS servers
+	natsDefaultPort = "4222"
+	// natsReconnectWait is the wait between attempts to reconnect a dropped connection
+	natsReconnectWait = 250 * time.Millisecond
+)
+
+// NATS publishes messages to a NATS server through the nats.go client. It connects on the first
+// publish, reconnects in the background while the connection is down and confirms every message
+// with a flush, so a returned publish has been processed by the server. Messages are not
+// buffered while reconnecting; publishing fails instead, so the Publisher retries it. It is safe
+// for concurrent use.
+type NATS struct {
+	url      string
+	addr     string
+	user     string
+	password string
+	token    string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+// NATSOption configures optional NATS settings
+type NATSOption func(*NATS)
+
+// WithNATSCredentials authenticates with user and password
+func WithNATSCredentials(user, password string) NATSOption {
+	return func(n *NATS) {
+		n.user = user
+		n.password = password
+	}
+}
+
+// WithNATSToken authenticates with token
+func WithNATSToken(token string) NATSOption {
+	return func(n *NATS) {
+		n.token = token
+	}
+}
+
+// WithNATSTimeout bounds connecting and every publish; the default is 10 seconds
+func WithNATSTimeout(timeout time.Duration) NATSOption {
+	return func(n *NATS) {
+		n.timeout = timeout
+	}
+}
+
+// NewNATS creates a transport for the server at rawURL, e.g. nats://localhost:4222, or
+// tls://host:4222 to require TLS. User and password in the URL are used unless
+// WithNATSCredentials sets others. Nothing is dialed until the first publish.
+func NewNATS(rawURL string, opts ...NATSOption) (*NATS, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "nats://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("invalid NATS URL: unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid NATS URL: missing host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = natsDefaultPort
+	}
+
+	n := &NATS{
+		addr:    net.JoinHostPort(u.Hostname(), port),
+		timeout: 10 * time.Second,
+	}
+	if u.User != nil {
+		n.user = u.User.Username()
+		n.password, _ = u.User.Password()
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	// The credentials are passed as options, so that WithNATSCredentials overrides the URL
+	n.url = (&url.URL{Scheme: u.Scheme, Host: n.addr}).String()
+
+	return n, nil
+}
+
+// Publish sends data to subject and waits until the server confirms it
+func (n *NATS) Publish(ctx context.Context, subject string, data []byte) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid NATS subject %q", subject)
+	}
+
+	conn, err := n.connection()
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS at %s: %w", n.addr, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	if err := conn.Publish(subject, data); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+	if err := conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the server, if any
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return nil
+}
+
+// connection returns the connection to the server, connecting unless it is open
+func (n *NATS) connection() (*nats.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil && !n.conn.IsClosed() {
+		return n.conn, nil
+	}
+
+	opts := []nats.Option{
+		nats.Name("2xiang"),
+		nats.Timeout(n.timeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait),
+		nats.ReconnectBufSize(-1),
+	}
+	if n.user != "" || n.password != "" {
+		opts = append(opts, nats.UserInfo(n.user, n.password))
+	}
+	if n.token != "" {
+		opts = append(opts, nats.Token(n.token))
+	}
+	conn, err := nats.Connect(n.url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conn = conn
+	return conn, nil
+}
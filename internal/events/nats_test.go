@@ -0,0 +1,300 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/events"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// natsMessage is a message published to a natsServer
+type natsMessage struct {
+	subject string
+	data    string
+}
+
+// natsServer is a minimal NATS server speaking enough of the client protocol for publishing:
+// it records CONNECT options and published messages and answers PINGs. With reject set it
+// refuses every client with that error.
+type natsServer struct {
+	ln     net.Listener
+	reject string
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	connects []map[string]any
+	messages []natsMessage
+}
+
+// newNATSServer starts a server on a free local port
+func newNATSServer(t *testing.T) *natsServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	s := &natsServer{ln: ln}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.drop()
+	})
+	return s
+}
+
+// url returns the client URL of the server
+func (s *natsServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *natsServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle speaks the protocol with a single client
+func (s *natsServer) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, `INFO {"server_id":"test","version":"2.2.0","go":"go1.21","host":"127.0.0.1","proto":1,"max_payload":1048576}`+"\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(op) {
+		case "CONNECT":
+			var options map[string]any
+			json.Unmarshal([]byte(args), &options)
+			s.mu.Lock()
+			s.connects = append(s.connects, options)
+			s.mu.Unlock()
+			if s.reject != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", s.reject)
+				return
+			}
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			fields := strings.Fields(args)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, natsMessage{subject: fields[0], data: string(payload[:size])})
+			s.mu.Unlock()
+		}
+	}
+}
+
+// drop closes the connections of every client
+func (s *natsServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// received returns the messages published so far
+func (s *natsServer) received() []natsMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]natsMessage(nil), s.messages...)
+}
+
+// connectOptions returns the CONNECT options of the clients so far
+func (s *natsServer) connectOptions() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]any(nil), s.connects...)
+}
+
+func TestNATSPublish(t *testing.T) {
+	srv := newNATSServer(t)
+	n, err := events.NewNATS(srv.url(), events.WithNATSCredentials("cms", "s3cret"), events.WithNATSTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("NewNATS() error = %v", err)
+	}
+	defer n.Close()
+
+	// Nothing is dialed until the first publish
+	if got := srv.connectOptions(); len(got) != 0 {
+		t.Fatalf("NewNATS() connected %d times, want no connection yet", len(got))
+	}
+	ctx := context.Background()
+	for _, msg := range []string{`{"id":1}`, `{"id":2}`} {
+		if err := n.Publish(ctx, "image.generated", []byte(msg)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	// A returned publish has reached the server
+	want := []natsMessage{{subject: "image.generated", data: `{"id":1}`}, {subject: "image.generated", data: `{"id":2}`}}
+	if got := srv.received(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("server received %v, want %v", got, want)
+	}
+	connects := srv.connectOptions()
+	if len(connects) != 1 {
+		t.Fatalf("connected %d times, want one connection for both messages", len(connects))
+	}
+	if c := connects[0]; c["user"] != "cms" || c["pass"] != "s3cret" || c["name"] != "2xiang" {
+		t.Errorf("CONNECT options = %v, want the credentials and name", c)
+	}
+}
+
+func TestNATSCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		url  func(addr string) string
+		opts []events.NATSOption
+		want map[string]any
+	}{
+		{
+			name: "in the URL",
+			url:  func(addr string) string { return "nats://cms:from-url@" + addr },
+			want: map[string]any{"user": "cms", "pass": "from-url"},
+		},
+		{
+			name: "overriding the URL",
+			url:  func(addr string) string { return "nats://cms:from-url@" + addr },
+			opts: []events.NATSOption{events.WithNATSCredentials("admin", "from-option")},
+			want: map[string]any{"user": "admin", "pass": "from-option"},
+		},
+		{
+			name: "token",
+			url:  func(addr string) string { return addr },
+			opts: []events.NATSOption{events.WithNATSToken("t0ken")},
+			want: map[string]any{"auth_token": "t0ken"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newNATSServer(t)
+			n, err := events.NewNATS(tt.url(srv.ln.Addr().String()), tt.opts...)
+			if err != nil {
+				t.Fatalf("NewNATS() error = %v", err)
+			}
+			defer n.Close()
+			if err := n.Publish(context.Background(), "image.failed", []byte(`{}`)); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			connect := srv.connectOptions()[0]
+			for key, want := range tt.want {
+				if connect[key] != want {
+					t.Errorf("CONNECT %s = %v, want %v", key, connect[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNATSReconnectsAfterDrop(t *testing.T) {
+	srv := newNATSServer(t)
+	n, err := events.NewNATS(srv.url(), events.WithNATSTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewNATS() error = %v", err)
+	}
+	defer n.Close()
+	ctx := context.Background()
+	if err := n.Publish(ctx, "image.generated", []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	// Publishing fails while the connection is down rather than buffering, and succeeds again
+	// once the client reconnected
+	srv.drop()
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Publish(ctx, "image.generated", []byte(`{"id":2}`)) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Publish() still failing 5s after the connection dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := srv.received()
+	if len(got) != 2 || got[1].data != `{"id":2}` {
+		t.Errorf("server received %v, want both messages once", got)
+	}
+	if len(srv.connectOptions()) != 2 {
+		t.Errorf("connected %d times, want a reconnect", len(srv.connectOptions()))
+	}
+}
+
+func TestNATSErrors(t *testing.T) {
+	srv := newNATSServer(t)
+	srv.reject = "Authorization Violation"
+	n, err := events.NewNATS(srv.url(), events.WithNATSTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewNATS() error = %v", err)
+	}
+	defer n.Close()
+	if err := n.Publish(context.Background(), "image.generated", []byte(`{}`)); err == nil || !strings.Contains(strings.ToLower(err.Error()), "authorization") {
+		t.Errorf("Publish() to a server refusing the client error = %v, want the authorization error", err)
+	}
+	if err := n.Publish(context.Background(), "image generated", []byte(`{}`)); err == nil {
+		t.Error("Publish() to a subject with a space error = nil")
+	}
+
+	for _, rawURL := range []string{"http://localhost:4222", "nats://", "nats://[::1"} {
+		if _, err := events.NewNATS(rawURL); err == nil {
+			t.Errorf("NewNATS(%q) error = nil, want an invalid URL", rawURL)
+		}
+	}
+}
+
+func TestPublisherOverNATS(t *testing.T) {
+	srv := newNATSServer(t)
+	n, err := events.NewNATS(srv.url())
+	if err != nil {
+		t.Fatalf("NewNATS() error = %v", err)
+	}
+	p := events.NewPublisher(n, 3, events.WithSubjectPrefix("cms"))
+	defer p.Close()
+
+	img := &domain.Image{ID: 7, UUID: "uuid-7", Provider: "fake", FileURL: "https://cdn.example.com/7.png"}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e, ok := events.NewEvent(img, domain.StatusReadyToPublish, "", now)
+	if !ok {
+		t.Fatal("NewEvent() of ReadyToPublish = false, want an event")
+	}
+	if attempts, err := p.Publish(context.Background(), e); err != nil || attempts != 1 {
+		t.Fatalf("Publish() = %d, %v, want a single attempt", attempts, err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 || got[0].subject != "cms.image.generated" {
+		t.Fatalf("server received %v, want the event on cms.image.generated", got)
+	}
+	var body events.Event
+	if err := json.Unmarshal([]byte(got[0].data), &body); err != nil {
+		t.Fatalf("event is not JSON: %v", err)
+	}
+	if body != e {
+		t.Errorf("event = %+v, want %+v", body, e)
+	}
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds how long a single readiness check may take
+const checkTimeout = 5 * time.Second
+
+// response is the JSON body of both endpoints; components maps each component to "ok" or its error
+type response struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// NewHandler serves /healthz, which succeeds while the process is up, and /readyz, which
+// succeeds when every check passes and no workflow is stale. Failures return 503 with a JSON
+// body naming the failing components.
+func NewHandler(state *State, checks ...Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, response{Status: "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready := true
+		components := make(map[string]string, len(checks))
+		for _, c := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			err := c.Check(ctx)
+			cancel()
+			if err != nil {
+				ready = false
+				components[c.Name] = err.Error()
+				continue
+			}
+			components[c.Name] = "ok"
+		}
+
+		for name, err := range state.Workflows(time.Now()) {
+			if err != nil {
+				ready = false
+				components[name] = err.Error()
+				continue
+			}
+			components[name] = "ok"
+		}
+
+		if !ready {
+			writeJSON(w, http.StatusServiceUnavailable, response{Status: "unavailable", Components: components})
+			return
+		}
+		writeJSON(w, http.StatusOK, response{Status: "ok", Components: components})
+	})
+	return mux
+}
+
+// writeJSON writes body with the given status code
+func writeJSON(w http.ResponseWriter, status int, body response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
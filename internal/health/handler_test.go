@@ -0,0 +1,205 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/health"
+)
+
+// response is the JSON body of the endpoints
+type response struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// get requests path from h and decodes the JSON response
+func get(t *testing.T, h http.Handler, path string) (int, response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s Content-Type = %q, want application/json", path, ct)
+	}
+	var body response
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s body %q is not JSON: %v", path, rec.Body, err)
+	}
+	return rec.Code, body
+}
+
+// check returns a readiness check failing with err, or passing when err is nil
+func check(name string, err error) health.Check {
+	return health.Check{Name: name, Check: func(ctx context.Context) error { return err }}
+}
+
+func TestReadyz(t *testing.T) {
+	errDB := errors.New("dial tcp: connection refused")
+	errProvider := errors.New("fusionbrain: unauthorized")
+	tests := []struct {
+		name       string
+		checks     []health.Check
+		workflows  func(s *health.State)
+		wantStatus int
+		want       map[string]string
+	}{
+		{
+			name:   "ready",
+			checks: []health.Check{check("database", nil), check("provider", nil)},
+			workflows: func(s *health.State) {
+				s.Tick("generator", time.Minute)
+				s.Tick("processor", time.Minute)
+			},
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"database": "ok", "provider": "ok", "generator": "ok", "processor": "ok"},
+		},
+		{
+			name:       "database unreachable",
+			checks:     []health.Check{check("database", errDB), check("provider", nil)},
+			workflows:  func(s *health.State) { s.Tick("generator", time.Minute) },
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]string{"database": errDB.Error(), "provider": "ok", "generator": "ok"},
+		},
+		{
+			name:       "provider credentials rejected",
+			checks:     []health.Check{check("database", nil), check("provider", errProvider)},
+			workflows:  func(s *health.State) { s.Tick("generator", time.Minute) },
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]string{"database": "ok", "provider": errProvider.Error(), "generator": "ok"},
+		},
+		{
+			name:   "workflow degraded",
+			checks: []health.Check{check("database", nil)},
+			workflows: func(s *health.State) {
+				s.Tick("generator", time.Minute)
+				s.Tick("processor", time.Minute)
+				s.Degraded("processor", errDB)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]string{"database": "ok", "generator": "ok", "processor": "degraded: " + errDB.Error()},
+		},
+		{
+			name:   "degraded workflow recovered",
+			checks: []health.Check{check("database", nil)},
+			workflows: func(s *health.State) {
+				s.Degraded("generator", errDB)
+				s.Tick("generator", time.Minute)
+			},
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"database": "ok", "generator": "ok"},
+		},
+		{
+			name:       "no workflow reported yet",
+			checks:     []health.Check{check("database", nil)},
+			workflows:  func(s *health.State) {},
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"database": "ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := health.NewState()
+			tt.workflows(state)
+			status, body := get(t, health.NewHandler(state, tt.checks...), "/readyz")
+			if status != tt.wantStatus {
+				t.Errorf("GET /readyz status = %d, want %d", status, tt.wantStatus)
+			}
+			wantBody := "ok"
+			if tt.wantStatus != http.StatusOK {
+				wantBody = "unavailable"
+			}
+			if body.Status != wantBody || !reflect.DeepEqual(body.Components, tt.want) {
+				t.Errorf("GET /readyz = %+v, want status %q and components %v", body, wantBody, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyzStaleWorkflow(t *testing.T) {
+	state := health.NewState()
+	state.Tick("publisher", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	status, body := get(t, health.NewHandler(state), "/readyz")
+	if status != http.StatusServiceUnavailable || !strings.HasPrefix(body.Components["publisher"], "last pass ") {
+		t.Errorf("GET /readyz = %d %+v, want 503 naming the stale publisher", status, body)
+	}
+}
+
+func TestReadyzCheckTimeout(t *testing.T) {
+	// A check gets a context with a deadline, so a hanging dependency cannot block the probe
+	bounded := health.Check{Name: "provider", Check: func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("no deadline")
+		}
+		return nil
+	}}
+	if status, body := get(t, health.NewHandler(health.NewState(), bounded), "/readyz"); status != http.StatusOK {
+		t.Errorf("GET /readyz = %d %+v, want 200", status, body)
+	}
+}
+
+func TestHealthzIgnoresFailures(t *testing.T) {
+	state := health.NewState()
+	state.Tick("generator", time.Minute)
+	state.Degraded("generator", errors.New("database unreachable"))
+	h := health.NewHandler(state, check("database", errors.New("connection refused")))
+
+	status, body := get(t, h, "/healthz")
+	if status != http.StatusOK || body.Status != "ok" || len(body.Components) != 0 {
+		t.Errorf("GET /healthz = %d %+v, want 200 while the process is up", status, body)
+	}
+}
+
+func TestStateWorkflows(t *testing.T) {
+	state := health.NewState()
+	state.Tick("generator", time.Minute)
+
+	if err := state.Workflows(time.Now().Add(time.Minute))["generator"]; err != nil {
+		t.Errorf("Workflows() within twice the interval = %v, want nil", err)
+	}
+	if err := state.Workflows(time.Now().Add(3 * time.Minute))["generator"]; err == nil {
+		t.Error("Workflows() after three intervals = nil, want the workflow stale")
+	}
+
+	// A nil State ignores reports, for workflows running without a health server
+	var none *health.State
+	none.Tick("generator", time.Minute)
+	none.Degraded("generator", errors.New("failed"))
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	failing := errors.New("unauthorized")
+	cached := health.Cached(func(ctx context.Context) error {
+		calls++
+		return failing
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := cached(context.Background()); !errors.Is(err, failing) {
+			t.Errorf("check %d = %v, want %v", i+1, err, failing)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("check ran %d times within the TTL, want 1", calls)
+	}
+
+	expiring := health.Cached(func(ctx context.Context) error {
+		calls++
+		return nil
+	}, time.Nanosecond)
+	expiring(context.Background())
+	time.Sleep(time.Millisecond)
+	expiring(context.Background())
+	if calls != 3 {
+		t.Errorf("check ran %d times, want again once the TTL passed", calls-1)
+	}
+}
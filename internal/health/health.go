@@ -0,0 +1,97 @@
+// Package health reports liveness and readiness of the workflows over HTTP.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State records when each workflow last completed a pass
+type State struct {
+	mu        sync.Mutex
+	workflows map[string]tick
+}
+
+// tick is the last successful pass of a workflow and the interval until its next one
+type tick struct {
+	at   time.Time
+	next time.Duration
+	// degraded is the error of the passes failing since the last successful one
+	degraded error
+}
+
+// NewState creates an empty health state
+func NewState() *State {
+	return &State{workflows: make(map[string]tick)}
+}
+
+// Tick records that the named workflow completed a pass and expects the next one within next.
+// It is safe to call on a nil State.
+func (s *State) Tick(name string, next time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workflows[name] = tick{at: time.Now(), next: next}
+}
+
+// Degraded records that the passes of the named workflow keep failing with err; the next Tick
+// clears it. It is safe to call on a nil State.
+func (s *State) Degraded(name string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.workflows[name]
+	t.degraded = err
+	s.workflows[name] = t
+}
+
+// Workflows returns every workflow that reported a pass, mapped to nil or, when its passes keep
+// failing or its last pass is older than twice its interval, an error
+func (s *State) Workflows(now time.Time) map[string]error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workflows := make(map[string]error, len(s.workflows))
+	for name, t := range s.workflows {
+		var err error
+		if t.degraded != nil {
+			err = fmt.Errorf("degraded: %w", t.degraded)
+		} else if age := now.Sub(t.at); age > 2*t.next {
+			err = fmt.Errorf("last pass %s ago, expected every %s", age.Round(time.Second), t.next)
+		}
+		workflows[name] = err
+	}
+	return workflows
+}
+
+// Check is a named readiness check of a dependency, e.g. the database
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Cached wraps check so it runs at most once per ttl; in between the last result is returned.
+// It keeps probes from hammering external APIs.
+func Cached(check func(ctx context.Context) error, ttl time.Duration) func(ctx context.Context) error {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		last    error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < ttl {
+			return last
+		}
+		last = check(ctx)
+		checked = time.Now()
+		return last
+	}
+}
@@ -0,0 +1,25 @@
+package imageproc
+
+import "github.com/basel-ax/2xiang/internal/config"
+
+// NewFromConfig creates the configured post-processing pipeline, or nil when it is disabled
+func NewFromConfig(cfg *config.Config) (*Pipeline, error) {
+	if !cfg.PostProcessEnabled {
+		return nil, nil
+	}
+
+	var steps []Step
+	if cfg.PostProcessMaxWidth > 0 || cfg.PostProcessMaxHeight > 0 {
+		steps = append(steps, Resize{MaxWidth: cfg.PostProcessMaxWidth, MaxHeight: cfg.PostProcessMaxHeight})
+	}
+
+	return NewPipeline(cfg.PostProcessFormat, cfg.PostProcessQuality, steps...)
+}
+
+// WatermarkFromConfig loads the configured watermark, or returns nil when none is set
+func WatermarkFromConfig(cfg *config.Config) (*Watermark, error) {
+	if cfg.WatermarkFile == "" {
+		return nil, nil
+	}
+	return LoadWatermark(cfg.WatermarkFile, cfg.WatermarkPosition, cfg.WatermarkOpacity, cfg.WatermarkMargin)
+}
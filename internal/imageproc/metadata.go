@@ -0,0 +1,48 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// Metadata reads the dimensions and format of an encoded image from its header
+func Metadata(data []byte) (domain.ImageMetadata, error) {
+	return MetadataFrom(bytes.NewReader(data))
+}
+
+// MetadataFrom reads the dimensions and format of an encoded image from its header and counts
+// its size by reading r to the end, without holding the image in memory. An error reading r is
+// returned wrapped as it is, ahead of a header that cannot be decoded.
+func MetadataFrom(r io.Reader) (domain.ImageMetadata, error) {
+	counter := &countingReader{r: r}
+	cfg, format, headerErr := image.DecodeConfig(counter)
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return domain.ImageMetadata{ByteSize: counter.n}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if headerErr != nil {
+		return domain.ImageMetadata{ByteSize: counter.n}, fmt.Errorf("failed to decode image header: %w", headerErr)
+	}
+
+	return domain.ImageMetadata{
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		ByteSize: counter.n,
+		Format:   format,
+	}, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,92 @@
+package imageproc_test
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// encodeJPEG returns a JPEG of the given size
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, filled(width, height, color.White), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want domain.ImageMetadata
+	}{
+		{name: "png", data: encodePNG(t, 640, 480, color.White), want: domain.ImageMetadata{Width: 640, Height: 480, Format: "png"}},
+		{name: "jpeg", data: encodeJPEG(t, 300, 200), want: domain.ImageMetadata{Width: 300, Height: 200, Format: "jpeg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.ByteSize = len(tt.data)
+			for name, metadata := range map[string]func() (domain.ImageMetadata, error){
+				"Metadata":     func() (domain.ImageMetadata, error) { return imageproc.Metadata(tt.data) },
+				"MetadataFrom": func() (domain.ImageMetadata, error) { return imageproc.MetadataFrom(bytes.NewReader(tt.data)) },
+			} {
+				got, err := metadata()
+				if err != nil {
+					t.Fatalf("%s() error = %v", name, err)
+				}
+				if got != tt.want {
+					t.Errorf("%s() = %+v, want %+v", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// brokenReader returns the start of an image and then an error
+type brokenReader struct {
+	data []byte
+	err  error
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestMetadataErrors(t *testing.T) {
+	data := []byte("definitely not an image")
+	meta, err := imageproc.Metadata(data)
+	if err == nil {
+		t.Fatal("Metadata() of data that is not an image succeeded")
+	}
+	if meta.ByteSize != len(data) || meta.Width != 0 || meta.Format != "" {
+		t.Errorf("Metadata() = %+v, want only the byte size", meta)
+	}
+
+	// A failing read is reported as it is, even after a valid header
+	readErr := errors.New("connection reset")
+	png := encodePNG(t, 64, 64, color.White)
+	if _, err := imageproc.MetadataFrom(&brokenReader{data: png[:len(png)/2], err: readErr}); !errors.Is(err, readErr) {
+		t.Errorf("MetadataFrom() error = %v, want %v", err, readErr)
+	}
+	if _, err := imageproc.MetadataFrom(&brokenReader{err: io.ErrUnexpectedEOF}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("MetadataFrom() of an empty failing reader error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// A truncated header is a decode error
+	if _, err := imageproc.Metadata(png[:10]); err == nil {
+		t.Error("Metadata() of a truncated PNG succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+// Package imageproc post-processes generated images before they are stored.
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	// Some Replicate models return WebP
+	_ "golang.org/x/image/webp"
+)
+
+// Output formats supported by Encode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// Step transforms a decoded image
+type Step interface {
+	Apply(img image.Image) (image.Image, error)
+}
+
+// Pipeline decodes an image, applies its steps in order and encodes the result
+type Pipeline struct {
+	Steps   []Step
+	Format  string
+	Quality int
+}
+
+// NewPipeline creates a pipeline encoding to format, which must be FormatJPEG, FormatPNG or
+// FormatWebP; quality only applies to JPEG, as WebP is encoded losslessly
+func NewPipeline(format string, quality int, steps ...Step) (*Pipeline, error) {
+	switch format {
+	case FormatJPEG, FormatPNG, FormatWebP:
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	return &Pipeline{Steps: steps, Format: format, Quality: quality}, nil
+}
+
+// Run processes the encoded image data and returns the re-encoded result
+func (p *Pipeline) Run(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	for _, step := range p.Steps {
+		if img, err = step.Apply(img); err != nil {
+			return nil, err
+		}
+	}
+
+	return Encode(img, p.Format, p.Quality)
+}
+
+// Ext returns the file extension of the pipeline's output format, including the dot
+func (p *Pipeline) Ext() string {
+	switch p.Format {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatWebP:
+		return ".webp"
+	}
+	return ".png"
+}
+
+// Encode encodes img in the given format
+func Encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatJPEG:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+	case FormatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode png: %w", err)
+		}
+	case FormatWebP:
+		if err := EncodeWebP(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode webp: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// thumbnailQuality keeps thumbnails small while hiding most compression artifacts
+const thumbnailQuality = 75
+
+// Thumbnail returns a JPEG of the encoded image scaled down so its longest edge is at most maxEdge
+func Thumbnail(data []byte, maxEdge int) ([]byte, error) {
+	p, err := NewPipeline(FormatJPEG, thumbnailQuality, Resize{MaxWidth: maxEdge, MaxHeight: maxEdge})
+	if err != nil {
+		return nil, err
+	}
+	return p.Run(data)
+}
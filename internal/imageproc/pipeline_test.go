@@ -0,0 +1,178 @@
+package imageproc_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/imageproc"
+)
+
+// encodePNG returns a PNG of the given size filled with c
+func encodePNG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decodeConfig returns the size and format of an encoded image
+func decodeConfig(t *testing.T, data []byte) (int, int, string) {
+	t.Helper()
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig() error = %v", err)
+	}
+	return cfg.Width, cfg.Height, format
+}
+
+func TestPipelineResizesAndConverts(t *testing.T) {
+	tests := []struct {
+		name                string
+		width, height       int
+		maxWidth, maxHeight int
+		format              string
+		wantWidth           int
+		wantHeight          int
+	}{
+		{name: "landscape to jpeg", width: 2048, height: 1024, maxWidth: 1200, maxHeight: 630, format: imageproc.FormatJPEG, wantWidth: 1200, wantHeight: 600},
+		{name: "square bound by height", width: 1024, height: 1024, maxWidth: 1200, maxHeight: 630, format: imageproc.FormatJPEG, wantWidth: 630, wantHeight: 630},
+		{name: "portrait bound by height", width: 768, height: 1536, maxWidth: 1200, maxHeight: 630, format: imageproc.FormatPNG, wantWidth: 315, wantHeight: 630},
+		{name: "width only", width: 2000, height: 1000, maxWidth: 500, format: imageproc.FormatPNG, wantWidth: 500, wantHeight: 250},
+		{name: "height only", width: 2000, height: 1000, maxHeight: 100, format: imageproc.FormatJPEG, wantWidth: 200, wantHeight: 100},
+		{name: "smaller is not enlarged", width: 800, height: 400, maxWidth: 1200, maxHeight: 630, format: imageproc.FormatJPEG, wantWidth: 800, wantHeight: 400},
+		{name: "extreme aspect keeps a pixel", width: 4000, height: 2, maxWidth: 100, format: imageproc.FormatPNG, wantWidth: 100, wantHeight: 1},
+		{name: "landscape to webp", width: 2048, height: 1024, maxWidth: 1200, maxHeight: 630, format: imageproc.FormatWebP, wantWidth: 1200, wantHeight: 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := imageproc.NewPipeline(tt.format, 90, imageproc.Resize{MaxWidth: tt.maxWidth, MaxHeight: tt.maxHeight})
+			if err != nil {
+				t.Fatalf("NewPipeline() error = %v", err)
+			}
+			out, err := p.Run(encodePNG(t, tt.width, tt.height, color.White))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			width, height, format := decodeConfig(t, out)
+			if width != tt.wantWidth || height != tt.wantHeight || format != tt.format {
+				t.Errorf("Run() = %dx%d %s, want %dx%d %s", width, height, format, tt.wantWidth, tt.wantHeight, tt.format)
+			}
+		})
+	}
+}
+
+func TestPipelineExt(t *testing.T) {
+	for format, want := range map[string]string{imageproc.FormatJPEG: ".jpg", imageproc.FormatPNG: ".png", imageproc.FormatWebP: ".webp"} {
+		p, err := imageproc.NewPipeline(format, 90)
+		if err != nil {
+			t.Fatalf("NewPipeline(%q) error = %v", format, err)
+		}
+		if got := p.Ext(); got != want {
+			t.Errorf("Ext() of %s = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	for _, format := range []string{"", "gif", "JPEG"} {
+		if _, err := imageproc.NewPipeline(format, 90); err == nil {
+			t.Errorf("NewPipeline(%q) succeeded, want an unsupported format error", format)
+		}
+	}
+
+	p, err := imageproc.NewPipeline(imageproc.FormatJPEG, 90)
+	if err != nil {
+		t.Fatalf("NewPipeline() error = %v", err)
+	}
+	if _, err := p.Run([]byte("not an image")); err == nil {
+		t.Error("Run() of data that is not an image succeeded")
+	}
+}
+
+func TestPipelineQuality(t *testing.T) {
+	// A noisy image, so the JPEG quality shows in the size
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7919)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+
+	sizes := make(map[int]int)
+	for _, quality := range []int{20, 95} {
+		p, err := imageproc.NewPipeline(imageproc.FormatJPEG, quality)
+		if err != nil {
+			t.Fatalf("NewPipeline() error = %v", err)
+		}
+		out, err := p.Run(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		sizes[quality] = len(out)
+	}
+	if sizes[20] >= sizes[95] {
+		t.Errorf("JPEG of quality 20 has %d bytes, want fewer than the %d of quality 95", sizes[20], sizes[95])
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	if p, err := imageproc.NewFromConfig(&config.Config{}); p != nil || err != nil {
+		t.Errorf("NewFromConfig() when disabled = %+v, %v, want none", p, err)
+	}
+
+	p, err := imageproc.NewFromConfig(&config.Config{PostProcessEnabled: true, PostProcessFormat: imageproc.FormatJPEG, PostProcessQuality: 85, PostProcessMaxWidth: 1200, PostProcessMaxHeight: 630})
+	if err != nil {
+		t.Fatalf("NewFromConfig() error = %v", err)
+	}
+	out, err := p.Run(encodePNG(t, 2048, 1024, color.White))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if width, height, format := decodeConfig(t, out); width != 1200 || height != 600 || format != imageproc.FormatJPEG {
+		t.Errorf("Run() = %dx%d %s, want 1200x600 jpeg", width, height, format)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		maxEdge       int
+		wantWidth     int
+		wantHeight    int
+	}{
+		{name: "square", width: 1024, height: 1024, maxEdge: 256, wantWidth: 256, wantHeight: 256},
+		{name: "landscape", width: 1536, height: 1024, maxEdge: 256, wantWidth: 256, wantHeight: 171},
+		{name: "portrait", width: 640, height: 1280, maxEdge: 100, wantWidth: 50, wantHeight: 100},
+		{name: "smaller is not enlarged", width: 64, height: 32, maxEdge: 256, wantWidth: 64, wantHeight: 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, err := imageproc.Thumbnail(encodePNG(t, tt.width, tt.height, color.White), tt.maxEdge)
+			if err != nil {
+				t.Fatalf("Thumbnail() error = %v", err)
+			}
+			width, height, format := decodeConfig(t, thumb)
+			if width != tt.wantWidth || height != tt.wantHeight || format != imageproc.FormatJPEG {
+				t.Errorf("Thumbnail() = %dx%d %s, want %dx%d jpeg", width, height, format, tt.wantWidth, tt.wantHeight)
+			}
+			if edge := max(width, height); edge > tt.maxEdge {
+				t.Errorf("Thumbnail() has a %dpx edge, want at most %d", edge, tt.maxEdge)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package imageproc
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Resize scales images down to fit within MaxWidth×MaxHeight, preserving the aspect ratio.
+// Smaller images are left untouched and a zero bound is ignored.
+type Resize struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Apply returns img scaled to fit the bounds
+func (r Resize) Apply(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	w, h := fitWithin(b.Dx(), b.Dy(), r.MaxWidth, r.MaxHeight)
+	if w == b.Dx() && h == b.Dy() {
+		return img, nil
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst, nil
+}
+
+// fitWithin returns the largest size with the aspect ratio of w×h that fits maxW×maxH
+// without enlarging it
+func fitWithin(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && float64(h)*scale > float64(maxH) {
+		scale = float64(maxH) / float64(h)
+	}
+	if scale == 1.0 {
+		return w, h
+	}
+	return max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+}
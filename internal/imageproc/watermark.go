@@ -0,0 +1,84 @@
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// Watermark positions
+const (
+	TopLeft     = "top-left"
+	TopRight    = "top-right"
+	BottomLeft  = "bottom-left"
+	BottomRight = "bottom-right"
+)
+
+// Watermark composites a logo onto a corner of images
+type Watermark struct {
+	Mark     image.Image
+	Position string
+	Opacity  float64 // from 0 (invisible) to 1 (opaque)
+	Margin   int     // distance from the edges in pixels
+}
+
+// LoadWatermark reads the logo from a PNG file
+func LoadWatermark(path, position string, opacity float64, margin int) (*Watermark, error) {
+	switch position {
+	case TopLeft, TopRight, BottomLeft, BottomRight:
+	default:
+		return nil, fmt.Errorf("unknown watermark position %q", position)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watermark: %w", err)
+	}
+	defer f.Close()
+
+	mark, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark: %w", err)
+	}
+
+	return &Watermark{Mark: mark, Position: position, Opacity: opacity, Margin: margin}, nil
+}
+
+// Apply returns a copy of img with the watermark drawn onto it. A watermark that does not
+// fit inside the margins is scaled down; on tiny images the margins are dropped as well.
+func (w *Watermark) Apply(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	margin := w.Margin
+	if b.Dx() <= 2*margin || b.Dy() <= 2*margin {
+		margin = 0
+	}
+
+	mark := w.Mark
+	mb := mark.Bounds()
+	mw, mh := fitWithin(mb.Dx(), mb.Dy(), b.Dx()-2*margin, b.Dy()-2*margin)
+	if mw != mb.Dx() || mh != mb.Dy() {
+		scaled := image.NewRGBA(image.Rect(0, 0, mw, mh))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+		mark = scaled
+	}
+
+	x, y := margin, margin
+	if w.Position == TopRight || w.Position == BottomRight {
+		x = b.Dx() - margin - mw
+	}
+	if w.Position == BottomLeft || w.Position == BottomRight {
+		y = b.Dy() - margin - mh
+	}
+
+	opacity := uint8(min(max(w.Opacity, 0), 1) * 255)
+	mask := image.NewUniform(color.Alpha{A: opacity})
+	draw.DrawMask(dst, image.Rect(x, y, x+mw, y+mh), mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+
+	return dst, nil
+}
@@ -0,0 +1,158 @@
+package imageproc_test
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+)
+
+var (
+	blue = color.NRGBA{B: 255, A: 255}
+	red  = color.NRGBA{R: 255, A: 255}
+)
+
+// filled returns an image of the given size filled with c
+func filled(width, height int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// near reports whether the colors differ by at most 2 in every channel
+func near(a, b color.Color) bool {
+	ca, cb := color.NRGBAModel.Convert(a).(color.NRGBA), color.NRGBAModel.Convert(b).(color.NRGBA)
+	diff := func(x, y uint8) bool { return int(x)-int(y) <= 2 && int(y)-int(x) <= 2 }
+	return diff(ca.R, cb.R) && diff(ca.G, cb.G) && diff(ca.B, cb.B) && diff(ca.A, cb.A)
+}
+
+// checkRegion fails unless the pixels of img inside region are inside and all others outside
+func checkRegion(t *testing.T, img image.Image, region image.Rectangle, inside, outside color.Color) {
+	t.Helper()
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			want := outside
+			if (image.Point{X: x, Y: y}).In(region) {
+				want = inside
+			}
+			if got := img.At(x, y); !near(got, want) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v with the watermark at %v", x, y, color.NRGBAModel.Convert(got), want, region)
+			}
+		}
+	}
+}
+
+func TestWatermarkPositions(t *testing.T) {
+	tests := []struct {
+		position string
+		want     image.Rectangle
+	}{
+		{position: imageproc.TopLeft, want: image.Rect(5, 5, 15, 13)},
+		{position: imageproc.TopRight, want: image.Rect(85, 5, 95, 13)},
+		{position: imageproc.BottomLeft, want: image.Rect(5, 67, 15, 75)},
+		{position: imageproc.BottomRight, want: image.Rect(85, 67, 95, 75)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.position, func(t *testing.T) {
+			w := &imageproc.Watermark{Mark: filled(10, 8, red), Position: tt.position, Opacity: 1, Margin: 5}
+			out, err := w.Apply(filled(100, 80, blue))
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if b := out.Bounds(); b.Dx() != 100 || b.Dy() != 80 {
+				t.Fatalf("Apply() = %dx%d, want the 100x80 of the image", b.Dx(), b.Dy())
+			}
+			checkRegion(t, out, tt.want, red, blue)
+		})
+	}
+}
+
+func TestWatermarkOpacity(t *testing.T) {
+	tests := []struct {
+		opacity float64
+		want    color.NRGBA
+	}{
+		{opacity: 0, want: blue},
+		{opacity: 0.5, want: color.NRGBA{R: 127, B: 128, A: 255}},
+		{opacity: 1, want: red},
+		{opacity: 2, want: red},
+	}
+	for _, tt := range tests {
+		w := &imageproc.Watermark{Mark: filled(10, 10, red), Position: imageproc.TopLeft, Opacity: tt.opacity}
+		out, err := w.Apply(filled(20, 20, blue))
+		if err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		checkRegion(t, out, image.Rect(0, 0, 10, 10), tt.want, blue)
+	}
+}
+
+func TestWatermarkScalesDownOnSmallImages(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		margin        int
+		want          image.Rectangle
+	}{
+		// The 40x20 mark is fitted into the 20x20 area inside the margins
+		{name: "within the margins", width: 30, height: 30, margin: 5, want: image.Rect(5, 15, 25, 25)},
+		// Margins that leave no room are dropped
+		{name: "margins dropped", width: 8, height: 8, margin: 4, want: image.Rect(0, 4, 8, 8)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &imageproc.Watermark{Mark: filled(40, 20, red), Position: imageproc.BottomLeft, Opacity: 1, Margin: tt.margin}
+			out, err := w.Apply(filled(tt.width, tt.height, blue))
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			checkRegion(t, out, tt.want, red, blue)
+		})
+	}
+}
+
+func TestWatermarkDoesNotModifyTheSource(t *testing.T) {
+	src := filled(20, 20, blue)
+	w := &imageproc.Watermark{Mark: filled(10, 10, red), Position: imageproc.TopLeft, Opacity: 1}
+	if _, err := w.Apply(src); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	checkRegion(t, src, image.Rectangle{}, red, blue)
+}
+
+func TestLoadWatermark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(path, encodePNG(t, 12, 6, red), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	w, err := imageproc.LoadWatermark(path, imageproc.BottomRight, 0.8, 16)
+	if err != nil {
+		t.Fatalf("LoadWatermark() error = %v", err)
+	}
+	if b := w.Mark.Bounds(); b.Dx() != 12 || b.Dy() != 6 || w.Position != imageproc.BottomRight || w.Opacity != 0.8 || w.Margin != 16 {
+		t.Errorf("LoadWatermark() = %+v with a %dx%d mark, want the settings and the 12x6 logo", w, b.Dx(), b.Dy())
+	}
+
+	if _, err := imageproc.LoadWatermark(path, "center", 1, 0); err == nil {
+		t.Error("LoadWatermark() with an unknown position succeeded")
+	}
+	if _, err := imageproc.LoadWatermark(filepath.Join(t.TempDir(), "missing.png"), imageproc.TopLeft, 1, 0); err == nil {
+		t.Error("LoadWatermark() of a missing file succeeded")
+	}
+	notImage := filepath.Join(t.TempDir(), "logo.txt")
+	if err := os.WriteFile(notImage, []byte("logo"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := imageproc.LoadWatermark(notImage, imageproc.TopLeft, 1, 0); err == nil {
+		t.Error("LoadWatermark() of a file that is not an image succeeded")
+	}
+}
@@ -0,0 +1,343 @@
+package imageproc
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// maxWebPSize is the largest width and height a WebP image can have
+const maxWebPSize = 1 << 14
+
+// codeLengthOrder is the order the lengths of the code length code are written in
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes img to w as a lossless WebP. The standard library and golang.org/x/image
+// only decode WebP, so this is a small VP8L encoder: pixels are stored as Huffman-coded literals
+// after the subtract green transform, without backward references or a color cache.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxWebPSize || b.Dy() > maxWebPSize {
+		return fmt.Errorf("cannot encode a %dx%d image as webp", b.Dx(), b.Dy())
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	}
+
+	// Pixels in the order they are coded: green, then red and blue minus green, then alpha
+	pixels := make([][4]byte, 0, b.Dx()*b.Dy())
+	opaque := true
+	for y := 0; y < b.Dy(); y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+4*b.Dx()]
+		for x := 0; x < len(row); x += 4 {
+			r, g, b, a := row[x], row[x+1], row[x+2], row[x+3]
+			pixels = append(pixels, [4]byte{g, r - g, b - g, a})
+			opaque = opaque && a == 0xff
+		}
+	}
+	var freqs [4][]int
+	for i := range freqs {
+		freqs[i] = make([]int, 256)
+	}
+	for _, p := range pixels {
+		for i, v := range p {
+			freqs[i][v]++
+		}
+	}
+
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(b.Dx()-1), 14)
+	bw.write(uint32(b.Dy()-1), 14)
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3)
+	// The subtract green transform, and no other
+	bw.write(1, 1)
+	bw.write(2, 2)
+	bw.write(0, 1)
+	// No color cache and a single group of prefix codes
+	bw.write(0, 1)
+	bw.write(0, 1)
+
+	// The green alphabet also holds the 24 length codes of backward references
+	green := append(freqs[0], make([]int, 24)...)
+	var codes [4][]huffmanCode
+	codes[0] = bw.writePrefixCode(green)
+	codes[1] = bw.writePrefixCode(freqs[1])
+	codes[2] = bw.writePrefixCode(freqs[2])
+	codes[3] = bw.writePrefixCode(freqs[3])
+	// The distance code of backward references, which are never used
+	bw.writePrefixCode(make([]int, 40))
+
+	for _, p := range pixels {
+		for i, v := range p {
+			bw.writeCode(codes[i][v])
+		}
+	}
+	data := bw.bytes()
+
+	pad := len(data) % 2
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if pad == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// bitWriter packs bits least significant first, as VP8L reads them
+type bitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.n -= 8
+	}
+}
+
+// bytes returns the bits written, the last byte padded with zeros
+func (w *bitWriter) bytes() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.n = 0, 0
+	}
+	return w.buf
+}
+
+// huffmanCode is the code of a symbol with its bits reversed, so that it is written most
+// significant bit first
+type huffmanCode struct {
+	bits   uint32
+	length uint
+}
+
+func (w *bitWriter) writeCode(c huffmanCode) {
+	w.write(c.bits, c.length)
+}
+
+// writePrefixCode writes a prefix code for symbols with the given frequencies and returns the
+// codes of the symbols
+func (w *bitWriter) writePrefixCode(freqs []int) []huffmanCode {
+	var used []int
+	for symbol, f := range freqs {
+		if f > 0 {
+			used = append(used, symbol)
+		}
+	}
+	// One or two symbols below 256 fit a simple code, whose single symbol takes no bits
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		w.write(1, 1)
+		lengths := make([]int, len(freqs))
+		for _, symbol := range used {
+			w.write(uint32(symbol), 8)
+			lengths[symbol] = 1
+		}
+		return canonicalCodes(lengths)
+	}
+
+	lengths := codeLengths(freqs, 15)
+	// The lengths are run-length coded: 17 and 18 stand for 3 to 10 and 11 to 138 zeros
+	type token struct{ symbol, extra int }
+	var tokens []token
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{symbol: lengths[i]})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, token{symbol: 18, extra: run - 11})
+		case run >= 3:
+			tokens = append(tokens, token{symbol: 17, extra: run - 3})
+		default:
+			tokens = append(tokens, token{symbol: 0})
+			run = 1
+		}
+		i += run
+	}
+
+	lengthFreqs := make([]int, len(codeLengthOrder))
+	for _, t := range tokens {
+		lengthFreqs[t.symbol]++
+	}
+	lengthLengths := codeLengths(lengthFreqs, 7)
+	n := len(codeLengthOrder)
+	for n > 4 && lengthLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.write(0, 1)
+	w.write(uint32(n-4), 4)
+	for _, symbol := range codeLengthOrder[:n] {
+		w.write(uint32(lengthLengths[symbol]), 3)
+	}
+	// Lengths are given for the whole alphabet
+	w.write(0, 1)
+	lengthCodes := canonicalCodes(lengthLengths)
+	for _, t := range tokens {
+		w.writeCode(lengthCodes[t.symbol])
+		switch t.symbol {
+		case 17:
+			w.write(uint32(t.extra), 3)
+		case 18:
+			w.write(uint32(t.extra), 7)
+		}
+	}
+	return canonicalCodes(lengths)
+}
+
+// codeLengths returns the lengths of a Huffman code for symbols with the given frequencies, none
+// longer than limit. Frequencies are halved until the code fits, which flattens the tree.
+func codeLengths(freqs []int, limit int) []int {
+	weights := append([]int(nil), freqs...)
+	for {
+		lengths, longest := huffmanLengths(weights)
+		if longest <= limit {
+			return lengths
+		}
+		for i, f := range weights {
+			if f > 0 {
+				weights[i] = (f + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanLengths returns the lengths of a Huffman code for symbols with the given frequencies,
+// and the longest of them
+func huffmanLengths(freqs []int) ([]int, int) {
+	lengths := make([]int, len(freqs))
+	var h nodeHeap
+	for _, f := range freqs {
+		if f > 0 {
+			h.nodes = append(h.nodes, node{weight: f, id: len(h.parents)})
+			h.parents = append(h.parents, -1)
+		}
+	}
+	if len(h.parents) == 1 {
+		for symbol, f := range freqs {
+			if f > 0 {
+				lengths[symbol] = 1
+			}
+		}
+		return lengths, 1
+	}
+	heap.Init(&h)
+	for h.Len() > 1 {
+		a, b := heap.Pop(&h).(node), heap.Pop(&h).(node)
+		parent := len(h.parents)
+		h.parents = append(h.parents, -1)
+		h.parents[a.id], h.parents[b.id] = parent, parent
+		heap.Push(&h, node{weight: a.weight + b.weight, id: parent})
+	}
+
+	longest, leaf := 0, 0
+	for symbol, f := range freqs {
+		if f == 0 {
+			continue
+		}
+		depth := 0
+		for p := h.parents[leaf]; p != -1; p = h.parents[p] {
+			depth++
+		}
+		lengths[symbol] = depth
+		longest = max(longest, depth)
+		leaf++
+	}
+	return lengths, longest
+}
+
+// node is a leaf or an inner node of a Huffman tree being built
+type node struct {
+	weight, id int
+}
+
+// nodeHeap orders the roots of a Huffman forest by weight, ties broken by age for a
+// deterministic code
+type nodeHeap struct {
+	nodes   []node
+	parents []int
+}
+
+func (h nodeHeap) Len() int { return len(h.nodes) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h.nodes[i].weight != h.nodes[j].weight {
+		return h.nodes[i].weight < h.nodes[j].weight
+	}
+	return h.nodes[i].id < h.nodes[j].id
+}
+func (h nodeHeap) Swap(i, j int) { h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i] }
+func (h *nodeHeap) Push(x any)   { h.nodes = append(h.nodes, x.(node)) }
+func (h *nodeHeap) Pop() any {
+	n := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return n
+}
+
+// canonicalCodes returns the canonical Huffman codes of symbols with the given code lengths. A
+// code of a single symbol takes no bits.
+func canonicalCodes(lengths []int) []huffmanCode {
+	var count [16]int
+	symbols := 0
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+			symbols++
+		}
+	}
+	codes := make([]huffmanCode, len(lengths))
+	if symbols == 1 {
+		return codes
+	}
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed = reversed<<1 | c>>i&1
+		}
+		codes[symbol] = huffmanCode{bits: reversed, length: uint(l)}
+	}
+	return codes
+}
@@ -0,0 +1,85 @@
+package imageproc_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"golang.org/x/image/webp"
+)
+
+// painted returns an image of the given size whose pixels are set by fill
+func painted(width, height int, fill func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func TestEncodeWebPIsLossless(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	gradient := painted(300, 200, func(x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 0xff}
+	})
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "single color", img: painted(64, 32, func(int, int) color.NRGBA { return color.NRGBA{R: 200, G: 30, B: 90, A: 0xff} })},
+		{name: "two colors", img: painted(17, 9, func(x, y int) color.NRGBA { return color.NRGBA{G: uint8(x % 2 * 0xff), A: 0xff} })},
+		{name: "gradient", img: gradient},
+		{name: "noise with alpha", img: painted(97, 61, func(int, int) color.NRGBA {
+			return color.NRGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: uint8(rnd.Intn(256)), A: uint8(rnd.Intn(256))}
+		})},
+		// Fibonacci frequencies make a Huffman code 20 bits long, which must be cut to 15
+		{name: "skewed", img: painted(256, 180, func(x, y int) color.NRGBA {
+			i, v, n, next := y*256+x, 0, 1, 1
+			for i >= n && v < 24 {
+				i -= n
+				n, next = next, n+next
+				v++
+			}
+			return color.NRGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 0xff}
+		})},
+		{name: "sub image", img: gradient.SubImage(image.Rect(10, 20, 110, 70))},
+		{name: "single pixel", img: painted(1, 1, func(int, int) color.NRGBA { return color.NRGBA{A: 0x80} })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := imageproc.EncodeWebP(&buf, tt.img); err != nil {
+				t.Fatalf("EncodeWebP() error = %v", err)
+			}
+			got, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("webp.Decode() error = %v", err)
+			}
+			b := tt.img.Bounds()
+			if got.Bounds().Dx() != b.Dx() || got.Bounds().Dy() != b.Dy() {
+				t.Fatalf("decoded %v, want %dx%d", got.Bounds(), b.Dx(), b.Dy())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y))
+					if c := color.NRGBAModel.Convert(got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)); c != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPRejectsSize(t *testing.T) {
+	for _, rect := range []image.Rectangle{image.Rect(0, 0, 0, 10), image.Rect(0, 0, 16385, 1)} {
+		if err := imageproc.EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(rect)); err == nil {
+			t.Errorf("EncodeWebP() of a %dx%d image succeeded", rect.Dx(), rect.Dy())
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+const (
+	defaultBaseURL = "https://api.openai.com/v1"
+
+	// DefaultModel is used when no model is configured
+	DefaultModel = "dall-e-3"
+
+	// ProviderName identifies the OpenAI provider
+	ProviderName = "openai"
+)
+
+// Client represents the OpenAI images API client.
+// Generation is synchronous, so GenerateImage returns the finished images and nothing is kept
+// to check the status of later.
+type Client struct {
+	httpClient *http.Client
+	apiKey     string
+	model      string
+	baseURL    string
+}
+
+// Option configures optional Client settings
+type Option func(*Client)
+
+// WithBaseURL overrides the API base URL, e.g. for Azure OpenAI or compatible gateways
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewClient creates a new OpenAI images API client
+func NewClient(apiKey, model string, opts ...Option) *Client {
+	if model == "" {
+		model = DefaultModel
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			// Images are rendered before the response is sent
+			Timeout: 2 * time.Minute,
+		},
+		apiKey:  apiKey,
+		model:   model,
+		baseURL: defaultBaseURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Name returns the provider name
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// GenerateImage generates the image and returns it with the DONE status
+func (c *Client) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	params := map[string]interface{}{
+		"model":  c.model,
+		"prompt": req.Prompt,
+		"n":      req.NumImages,
+		"size":   fmt.Sprintf("%dx%d", req.Width, req.Height),
+	}
+
+	// gpt-image models always return base64 and reject the response_format parameter
+	if strings.HasPrefix(c.model, "dall-e") {
+		params["response_format"] = "b64_json"
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/images/generations", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	files := make([]string, 0, len(result.Data))
+	for _, item := range result.Data {
+		files = append(files, item.B64JSON)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	return &domain.ImageGenerationResponse{
+		UUID:   id,
+		Status: "DONE",
+		Files:  files,
+	}, nil
+}
+
+// CheckGenerationStatus always fails with a 404 APIError: every generation is DONE when
+// GenerateImage returns it, with its files, so there is never a pending one to look up
+func (c *Client) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	return nil, &APIError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("generation %s is not pending, results are returned by GenerateImage", uuid),
+	}
+}
+
+// newID returns a random identifier in UUID format
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
@@ -0,0 +1,178 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// recorded returns a handler answering with the recorded response in testdata/name and status
+func recorded(t *testing.T, status int, name string) http.HandlerFunc {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read recorded response: %v", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+func TestGenerateImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		wantFormat bool
+	}{
+		{name: "dall-e", model: "dall-e-3", wantFormat: true},
+		{name: "default model", model: "", wantFormat: true},
+		{name: "gpt-image", model: "gpt-image-1", wantFormat: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params map[string]interface{}
+			handler := recorded(t, http.StatusOK, "generations.json")
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/images/generations" {
+					http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+					return
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+					http.Error(w, "unexpected authorization "+got, http.StatusUnauthorized)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				handler(w, r)
+			}))
+			defer srv.Close()
+
+			c := NewClient("sk-test", tt.model, WithBaseURL(srv.URL+"/v1/"))
+			resp, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+				Prompt:    "a lighthouse",
+				Width:     1024,
+				Height:    1792,
+				NumImages: 1,
+			})
+			if err != nil {
+				t.Fatalf("GenerateImage() error = %v", err)
+			}
+			if resp.Status != "DONE" || resp.UUID == "" || len(resp.Files) != 1 || resp.Files[0] == "" {
+				t.Errorf("GenerateImage() = %+v, want a DONE generation with one file", resp)
+			}
+
+			wantModel := tt.model
+			if wantModel == "" {
+				wantModel = DefaultModel
+			}
+			if params["model"] != wantModel || params["prompt"] != "a lighthouse" || params["size"] != "1024x1792" || params["n"] != float64(1) {
+				t.Errorf("request params = %v, want model %s, the prompt, size 1024x1792 and n 1", params, wantModel)
+			}
+			if _, ok := params["response_format"]; ok != tt.wantFormat {
+				t.Errorf("request has response_format = %v, want %v", ok, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestGenerateImageErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		class    string
+		wantCode string
+	}{
+		{name: "content policy", status: http.StatusBadRequest, response: "content_policy_violation.json", class: errclass.ClassCensored, wantCode: "content_policy_violation"},
+		{name: "quota", status: http.StatusTooManyRequests, response: "insufficient_quota.json", class: errclass.ClassTransient, wantCode: "insufficient_quota"},
+		{name: "invalid key", status: http.StatusUnauthorized, response: "invalid_api_key.json", class: errclass.ClassAuth, wantCode: "invalid_api_key"},
+		{name: "rate limit", status: http.StatusTooManyRequests, response: "rate_limit_exceeded.json", class: errclass.ClassTransient, wantCode: "rate_limit_exceeded"},
+		{name: "invalid size", status: http.StatusBadRequest, response: "invalid_size.json", class: errclass.ClassInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(recorded(t, tt.status, tt.response))
+			defer srv.Close()
+
+			c := NewClient("sk-test", "", WithBaseURL(srv.URL))
+			_, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			if got := errclass.Class(err); got != tt.class {
+				t.Errorf("GenerateImage() error = %v of class %s, want %s", err, got, tt.class)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GenerateImage() error = %v, want an *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.wantCode || apiErr.Message == "" {
+				t.Errorf("APIError = %+v, want status %d, code %q and the message", apiErr, tt.status, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCheckGenerationStatusKeepsNothing(t *testing.T) {
+	srv := httptest.NewServer(recorded(t, http.StatusOK, "generations.json"))
+	defer srv.Close()
+
+	c := NewClient("sk-test", "", WithBaseURL(srv.URL))
+	resp, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	// The result was returned by GenerateImage and is not kept for a later lookup
+	if _, err := c.CheckGenerationStatus(context.Background(), resp.UUID); !errclass.IsNotFound(err) {
+		t.Errorf("CheckGenerationStatus() error = %v, want a not found error", err)
+	}
+}
+
+// domainErrors are the errors of the domain a client error may translate to
+var domainErrors = []error{domain.ErrCensored, domain.ErrQuotaExceeded, domain.ErrInvalidPrompt, domain.ErrProviderUnavailable, domain.ErrGenerationFailed}
+
+func TestErrorTranslation(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		// want is the only error of the domain the client error matches, nil for none
+		want error
+	}{
+		{name: "content policy", status: http.StatusBadRequest, response: "content_policy_violation.json", want: domain.ErrCensored},
+		{name: "insufficient quota", status: http.StatusTooManyRequests, response: "insufficient_quota.json", want: domain.ErrQuotaExceeded},
+		// OpenAI reports an exhausted budget with a 400, which would otherwise be an invalid prompt
+		{name: "billing limit", status: http.StatusBadRequest, response: "billing_hard_limit_reached.json", want: domain.ErrQuotaExceeded},
+		{name: "rate limit", status: http.StatusTooManyRequests, response: "rate_limit_exceeded.json", want: domain.ErrQuotaExceeded},
+		{name: "invalid size", status: http.StatusBadRequest, response: "invalid_size.json", want: domain.ErrInvalidPrompt},
+		{name: "invalid key", status: http.StatusUnauthorized, response: "invalid_api_key.json"},
+		{name: "server error", status: http.StatusInternalServerError, response: "server_error.json", want: domain.ErrProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(recorded(t, tt.status, tt.response))
+			defer srv.Close()
+
+			_, err := NewClient("sk-test", "", WithBaseURL(srv.URL)).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GenerateImage() error = %v, want the *APIError kept for context", err)
+			}
+			for _, target := range domainErrors {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, target, got, !got)
+				}
+			}
+		})
+	}
+}
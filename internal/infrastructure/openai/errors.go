@@ -0,0 +1,72 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// contentPolicyViolation is the error code OpenAI uses for refused prompts
+const contentPolicyViolation = "content_policy_violation"
+
+// quotaCodes are the error codes OpenAI uses when the account has run out of credits, some of
+// which come with a 400 status
+var quotaCodes = map[string]bool{
+	"insufficient_quota":         true,
+	"billing_hard_limit_reached": true,
+}
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, code: %s, message: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// HTTPStatus returns the HTTP status of the failed response
+func (e *APIError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// Unwrap maps content policy rejections onto domain.ErrCensored and the status onto the other
+// errors of the domain
+func (e *APIError) Unwrap() error {
+	if e.Code == contentPolicyViolation {
+		return domain.ErrCensored
+	}
+	if quotaCodes[e.Code] {
+		return domain.ErrQuotaExceeded
+	}
+	return domain.ErrorForStatus(e.StatusCode)
+}
+
+// newAPIError builds an APIError from a non-successful response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+	}
+
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Error.Message != "" {
+		apiErr.Code = payload.Error.Code
+		apiErr.Message = payload.Error.Message
+	}
+
+	return apiErr
+}
@@ -0,0 +1,280 @@
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+const (
+	defaultBaseURL = "https://api.replicate.com/v1"
+
+	// maxOutputSize bounds the download of a single output file
+	maxOutputSize = 32 << 20
+
+	// ProviderName identifies the Replicate provider
+	ProviderName = "replicate"
+)
+
+// Client represents the Replicate predictions API client.
+// The prediction ID is used as the generation UUID.
+type Client struct {
+	httpClient *http.Client
+	apiToken   string
+	model      string
+	version    string
+	baseURL    string
+}
+
+// Option configures optional Client settings
+type Option func(*Client)
+
+// WithVersion pins predictions to a specific model version
+func WithVersion(version string) Option {
+	return func(c *Client) {
+		c.version = version
+	}
+}
+
+// WithBaseURL overrides the API base URL
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewClient creates a new Replicate client for the given "owner/name" model
+func NewClient(apiToken, model string, opts ...Option) *Client {
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		apiToken: apiToken,
+		model:    model,
+		baseURL:  defaultBaseURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// prediction is the subset of the Replicate prediction object used by the client
+type prediction struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Output json.RawMessage `json:"output"`
+	Error  interface{}     `json:"error"`
+}
+
+// Name returns the provider name
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// GenerateImage creates a prediction and returns its ID as the UUID
+func (c *Client) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	input := map[string]interface{}{
+		"prompt":      req.Prompt,
+		"width":       req.Width,
+		"height":      req.Height,
+		"num_outputs": req.NumImages,
+	}
+	if req.NegativePrompt != "" {
+		input["negative_prompt"] = req.NegativePrompt
+	}
+	if req.Seed != 0 {
+		input["seed"] = req.Seed
+	}
+
+	params := map[string]interface{}{
+		"input": input,
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s/predictions", c.baseURL, c.model)
+	if c.version != "" {
+		endpoint = c.baseURL + "/predictions"
+		params["version"] = c.version
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	var result prediction
+	if err := c.do(httpReq, &result); err != nil {
+		return nil, err
+	}
+
+	return &domain.ImageGenerationResponse{
+		UUID:   result.ID,
+		Status: mapStatus(result.Status),
+	}, nil
+}
+
+// CheckGenerationStatus fetches the prediction and downloads its outputs once it succeeded.
+// The deadline of ctx bounds the status request only: downloads are bounded by the timeout of
+// the HTTP client instead, so a large output is not cut off by a short status check timeout,
+// and stop when ctx is cancelled.
+func (c *Client) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/predictions/"+uuid, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	var result prediction
+	if err := c.do(httpReq, &result); err != nil {
+		return nil, err
+	}
+
+	resp := &domain.ImageGenerationResponse{
+		UUID:   result.ID,
+		Status: mapStatus(result.Status),
+	}
+
+	if result.Error != nil {
+		resp.ErrorDescription = fmt.Sprint(result.Error)
+		resp.Censored = strings.Contains(strings.ToLower(resp.ErrorDescription), "nsfw")
+	}
+
+	if resp.Status != "DONE" {
+		return resp, nil
+	}
+
+	urls, err := outputURLs(result.Output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+
+	downloadCtx, cancel := downloadContext(ctx)
+	defer cancel()
+	for _, outputURL := range urls {
+		data, err := c.download(downloadCtx, outputURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download output: %w", err)
+		}
+		resp.Files = append(resp.Files, base64.StdEncoding.EncodeToString(data))
+	}
+
+	return resp, nil
+}
+
+// do sends an authenticated API request and decodes the JSON response
+func (c *Client) do(httpReq *http.Request, out interface{}) error {
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiToken)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Predictions are created with 201, everything else answers 200
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return newAPIError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// download fetches an output file. The API token is only sent to the API host itself.
+func (c *Client) download(ctx context.Context, outputURL string) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", outputURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if api, err := url.Parse(c.baseURL); err == nil && httpReq.URL.Host == api.Host {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiToken)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOutputSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output: %w", err)
+	}
+	if len(data) > maxOutputSize {
+		return nil, fmt.Errorf("output exceeds %d bytes", maxOutputSize)
+	}
+	return data, nil
+}
+
+// downloadContext returns a context with the values of ctx that ignores its deadline but is
+// cancelled along with it
+func downloadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	downloadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			cancel()
+		}
+	})
+	return downloadCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// outputURLs handles models returning a single URL as well as a list of URLs
+func outputURLs(output json.RawMessage) ([]string, error) {
+	if len(output) == 0 || string(output) == "null" {
+		return nil, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(output, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// mapStatus translates Replicate prediction statuses onto the shared status vocabulary
+func mapStatus(status string) string {
+	switch status {
+	case "starting":
+		return "INITIAL"
+	case "processing":
+		return "PROCESSING"
+	case "succeeded":
+		return "DONE"
+	case "failed", "canceled":
+		return "FAIL"
+	default:
+		return status
+	}
+}
@@ -0,0 +1,236 @@
+package replicate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+func TestGenerateImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantPath string
+	}{
+		{name: "model", wantPath: "/v1/models/owner/model/predictions"},
+		{name: "version", opts: []Option{WithVersion("abc123")}, wantPath: "/v1/predictions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != tt.wantPath || r.Header.Get("Authorization") != "Bearer r8-token" {
+					http.Error(w, "unexpected request", http.StatusNotFound)
+					return
+				}
+				json.NewDecoder(r.Body).Decode(&params)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"pred-1","status":"starting","output":null,"error":null}`))
+			}))
+			defer srv.Close()
+
+			c := NewClient("r8-token", "owner/model", append(tt.opts, WithBaseURL(srv.URL+"/v1"))...)
+			resp, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+				Prompt:         "a lighthouse",
+				Width:          1024,
+				Height:         768,
+				NumImages:      2,
+				NegativePrompt: "fog",
+				Seed:           42,
+			})
+			if err != nil {
+				t.Fatalf("GenerateImage() error = %v", err)
+			}
+			if resp.UUID != "pred-1" || resp.Status != "INITIAL" {
+				t.Errorf("GenerateImage() = %+v, want pred-1 INITIAL", resp)
+			}
+
+			input, _ := params["input"].(map[string]interface{})
+			if input["prompt"] != "a lighthouse" || input["width"] != float64(1024) || input["height"] != float64(768) ||
+				input["num_outputs"] != float64(2) || input["negative_prompt"] != "fog" || input["seed"] != float64(42) {
+				t.Errorf("request input = %v, want the parameters of the request", input)
+			}
+			if _, ok := params["webhook"]; ok {
+				t.Error("request asks for a webhook nothing receives")
+			}
+			if tt.opts != nil && params["version"] != "abc123" {
+				t.Errorf("request version = %v, want abc123", params["version"])
+			}
+		})
+	}
+}
+
+// predictionServer serves the prediction pred-1 with the given status and output, in which
+// {srv} stands for the server URL, and its output files under /files/, each one after delay
+func predictionServer(status, output string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/predictions/pred-1":
+			output := strings.ReplaceAll(output, "{srv}", "http://"+r.Host)
+			w.Write([]byte(`{"id":"pred-1","status":"` + status + `","output":` + output + `,"error":null}`))
+		case strings.HasPrefix(r.URL.Path, "/files/"):
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("image " + strings.TrimPrefix(r.URL.Path, "/files/")))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCheckGenerationStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		output     string
+		wantStatus string
+		wantFiles  []string
+	}{
+		{name: "starting", status: "starting", output: "null", wantStatus: "INITIAL"},
+		{name: "processing", status: "processing", output: "null", wantStatus: "PROCESSING"},
+		{name: "failed", status: "failed", output: "null", wantStatus: "FAIL"},
+		{name: "canceled", status: "canceled", output: "null", wantStatus: "FAIL"},
+		{name: "single output", status: "succeeded", output: `"{srv}/files/a.png"`, wantStatus: "DONE", wantFiles: []string{"image a.png"}},
+		{name: "output list", status: "succeeded", output: `["{srv}/files/a.png","{srv}/files/b.png"]`, wantStatus: "DONE", wantFiles: []string{"image a.png", "image b.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := predictionServer(tt.status, tt.output, 0)
+			defer srv.Close()
+
+			c := NewClient("r8-token", "owner/model", WithBaseURL(srv.URL+"/v1"))
+			resp, err := c.CheckGenerationStatus(context.Background(), "pred-1")
+			if err != nil {
+				t.Fatalf("CheckGenerationStatus() error = %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("Status = %s, want %s", resp.Status, tt.wantStatus)
+			}
+			if len(resp.Files) != len(tt.wantFiles) {
+				t.Fatalf("got %d files, want %d", len(resp.Files), len(tt.wantFiles))
+			}
+			for i, want := range tt.wantFiles {
+				if got, _ := base64.StdEncoding.DecodeString(resp.Files[i]); string(got) != want {
+					t.Errorf("file %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckGenerationStatusDownloadsPastTheCheckDeadline(t *testing.T) {
+	srv := predictionServer("succeeded", `"{srv}/files/a.png"`, 200*time.Millisecond)
+	defer srv.Close()
+
+	// The status check deadline expires while the output is downloaded
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c := NewClient("r8-token", "owner/model", WithBaseURL(srv.URL+"/v1"))
+	resp, err := c.CheckGenerationStatus(ctx, "pred-1")
+	if err != nil {
+		t.Fatalf("CheckGenerationStatus() error = %v", err)
+	}
+	if len(resp.Files) != 1 {
+		t.Errorf("got %d files, want 1", len(resp.Files))
+	}
+}
+
+func TestCheckGenerationStatusStopsDownloadsOnCancel(t *testing.T) {
+	srv := predictionServer("succeeded", `"{srv}/files/a.png"`, time.Minute)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	c := NewClient("r8-token", "owner/model", WithBaseURL(srv.URL+"/v1"))
+	if _, err := c.CheckGenerationStatus(ctx, "pred-1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckGenerationStatus() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDownloadIsBounded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxOutputSize+1))
+	}))
+	defer srv.Close()
+
+	c := NewClient("r8-token", "owner/model", WithBaseURL(srv.URL+"/v1"))
+	if _, err := c.download(context.Background(), srv.URL+"/files/huge.png"); err == nil {
+		t.Error("download() of an oversized output succeeded")
+	}
+}
+
+func TestDownloadSendsTokenToAPIHostOnly(t *testing.T) {
+	var apiAuth, cdnAuth string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiAuth = r.Header.Get("Authorization")
+	}))
+	defer api.Close()
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cdnAuth = r.Header.Get("Authorization")
+	}))
+	defer cdn.Close()
+
+	c := NewClient("r8-token", "owner/model", WithBaseURL(api.URL+"/v1"))
+	if _, err := c.download(context.Background(), api.URL+"/files/a.png"); err != nil {
+		t.Fatalf("download() from the API host error = %v", err)
+	}
+	if _, err := c.download(context.Background(), cdn.URL+"/a.png"); err != nil {
+		t.Fatalf("download() from another host error = %v", err)
+	}
+	if apiAuth != "Bearer r8-token" || cdnAuth != "" {
+		t.Errorf("Authorization sent to the API = %q and elsewhere = %q, want the token to the API only", apiAuth, cdnAuth)
+	}
+}
+
+// domainErrors are the errors of the domain a client error may translate to
+var domainErrors = []error{domain.ErrCensored, domain.ErrQuotaExceeded, domain.ErrInvalidPrompt, domain.ErrProviderUnavailable, domain.ErrGenerationFailed}
+
+func TestErrorTranslation(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// want is the only error of the domain the client error matches, nil for none
+		want error
+	}{
+		{name: "invalid input", status: http.StatusUnprocessableEntity, body: `{"detail":"- input.width: Must be less than or equal to 1440"}`, want: domain.ErrInvalidPrompt},
+		{name: "out of credit", status: http.StatusPaymentRequired, body: `{"detail":"You have insufficient credit to run this model"}`, want: domain.ErrQuotaExceeded},
+		{name: "throttled", status: http.StatusTooManyRequests, body: `{"detail":"Request was throttled. Expected available in 1 second."}`, want: domain.ErrQuotaExceeded},
+		{name: "unauthenticated", status: http.StatusUnauthorized, body: `{"detail":"Invalid token."}`},
+		{name: "model not found", status: http.StatusNotFound, body: `{"detail":"Not found."}`},
+		{name: "server error", status: http.StatusServiceUnavailable, body: `upstream unavailable`, want: domain.ErrProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := NewClient("r8-token", "owner/model", WithBaseURL(srv.URL+"/v1")).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GenerateImage() error = %v, want the *APIError kept for context", err)
+			}
+			for _, target := range domainErrors {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, target, got, !got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Detail     string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, detail: %s", e.StatusCode, e.Detail)
+}
+
+// HTTPStatus returns the HTTP status of the failed response
+func (e *APIError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// Unwrap maps the status onto the errors of the domain, e.g. domain.ErrQuotaExceeded
+func (e *APIError) Unwrap() error {
+	return domain.ErrorForStatus(e.StatusCode)
+}
+
+// newAPIError builds an APIError from a non-successful response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Detail:     string(body),
+	}
+
+	var payload struct {
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Detail != "" {
+		apiErr.Detail = payload.Detail
+	}
+
+	return apiErr
+}
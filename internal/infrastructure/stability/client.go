@@ -0,0 +1,197 @@
+package stability
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+const (
+	defaultBaseURL = "https://api.stability.ai"
+
+	// DefaultEngine is used when no engine is configured
+	DefaultEngine = "stable-diffusion-xl-1024-v1-0"
+
+	// ProviderName identifies the Stability AI provider
+	ProviderName = "stability"
+)
+
+// finishReason values of a generated artifact
+const (
+	finishSuccess         = "SUCCESS"
+	finishContentFiltered = "CONTENT_FILTERED"
+)
+
+// Client represents the Stability AI text-to-image API client.
+// Generation is synchronous, so GenerateImage returns the finished images and nothing is kept
+// to check the status of later.
+type Client struct {
+	httpClient *http.Client
+	apiKey     string
+	engine     string
+	baseURL    string
+}
+
+// Option configures optional Client settings
+type Option func(*Client)
+
+// WithBaseURL overrides the API base URL
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewClient creates a new Stability AI client generating with the given engine
+func NewClient(apiKey, engine string, opts ...Option) *Client {
+	if engine == "" {
+		engine = DefaultEngine
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			// Images are rendered before the response is sent
+			Timeout: 2 * time.Minute,
+		},
+		apiKey:  apiKey,
+		engine:  engine,
+		baseURL: defaultBaseURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Name returns the provider name
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// textPrompt is a weighted prompt; negative weights steer the generation away from the text
+type textPrompt struct {
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
+}
+
+// GenerateImage generates the image and returns it with the DONE status. Artifacts removed by
+// the content filter are dropped; the result is censored when the filter removed all of them.
+func (c *Client) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	params := map[string]interface{}{
+		"text_prompts": []textPrompt{{Text: req.Prompt, Weight: 1}},
+		"width":        req.Width,
+		"height":       req.Height,
+		"samples":      req.NumImages,
+	}
+	if req.NegativePrompt != "" {
+		params["text_prompts"] = []textPrompt{
+			{Text: req.Prompt, Weight: 1},
+			{Text: req.NegativePrompt, Weight: -1},
+		}
+	}
+	if req.Seed != 0 {
+		params["seed"] = req.Seed
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	endpoint := c.baseURL + "/v1/generation/" + url.PathEscape(c.engine) + "/text-to-image"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result struct {
+		Artifacts []struct {
+			Base64       string `json:"base64"`
+			Seed         int64  `json:"seed"`
+			FinishReason string `json:"finishReason"`
+		} `json:"artifacts"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	generation := &domain.ImageGenerationResponse{
+		UUID:   id,
+		Status: "DONE",
+		Files:  make([]string, 0, len(result.Artifacts)),
+	}
+	filtered := false
+	for _, artifact := range result.Artifacts {
+		switch artifact.FinishReason {
+		case finishContentFiltered:
+			filtered = true
+		case finishSuccess, "":
+			generation.Files = append(generation.Files, artifact.Base64)
+			if generation.Seed == 0 {
+				generation.Seed = artifact.Seed
+			}
+		}
+	}
+	if len(generation.Files) == 0 {
+		if filtered {
+			generation.Censored = true
+		} else {
+			generation.Status = "FAIL"
+			generation.ErrorDescription = "no image was generated"
+		}
+	}
+
+	return generation, nil
+}
+
+// CheckGenerationStatus always fails with a 404 APIError: every generation is finished when
+// GenerateImage returns it, with its files, so there is never a pending one to look up
+func (c *Client) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	return nil, &APIError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("generation %s is not pending, results are returned by GenerateImage", uuid),
+	}
+}
+
+// newID returns a random identifier in UUID format
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
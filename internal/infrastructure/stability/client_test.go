@@ -0,0 +1,174 @@
+package stability
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// respond returns a handler answering with body and status, recording the request params
+func respond(status int, body string, params *map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if params != nil {
+			json.NewDecoder(r.Body).Decode(params)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestGenerateImage(t *testing.T) {
+	var params map[string]interface{}
+	var path, auth string
+	handler := respond(http.StatusOK, `{"artifacts":[
+		{"base64":"iVBORw0KGgo=","seed":42,"finishReason":"SUCCESS"},
+		{"base64":"","seed":43,"finishReason":"CONTENT_FILTERED"},
+		{"base64":"R0lGODlh","seed":44,"finishReason":"SUCCESS"}]}`, &params)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		handler(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewClient("sk-test", "", WithBaseURL(srv.URL))
+	resp, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+		Prompt:         "a lighthouse",
+		NegativePrompt: "fog",
+		Width:          1024,
+		Height:         1024,
+		NumImages:      3,
+		Seed:           42,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	// The filtered artifact is dropped and the rest returned right away
+	if resp.Status != "DONE" || resp.UUID == "" || len(resp.Files) != 2 || resp.Files[1] != "R0lGODlh" || resp.Seed != 42 || resp.Censored {
+		t.Errorf("GenerateImage() = %+v, want a DONE generation with the two unfiltered files", resp)
+	}
+	if path != "/v1/generation/"+DefaultEngine+"/text-to-image" || auth != "Bearer sk-test" {
+		t.Errorf("request to %s with %q, want the default engine and the key", path, auth)
+	}
+	prompts, _ := params["text_prompts"].([]interface{})
+	if len(prompts) != 2 || params["samples"] != float64(3) || params["seed"] != float64(42) {
+		t.Errorf("request params = %v, want the prompt, the negative prompt, 3 samples and the seed", params)
+	}
+}
+
+func TestGenerateImageFilteredOrEmpty(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   string
+		wantCensored bool
+	}{
+		{name: "all filtered", body: `{"artifacts":[{"finishReason":"CONTENT_FILTERED"}]}`, wantStatus: "DONE", wantCensored: true},
+		{name: "no artifacts", body: `{"artifacts":[]}`, wantStatus: "FAIL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(respond(http.StatusOK, tt.body, nil))
+			defer srv.Close()
+
+			resp, err := NewClient("sk-test", "", WithBaseURL(srv.URL)).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			if err != nil {
+				t.Fatalf("GenerateImage() error = %v", err)
+			}
+			if resp.Status != tt.wantStatus || resp.Censored != tt.wantCensored || len(resp.Files) != 0 {
+				t.Errorf("GenerateImage() = %+v, want status %s, censored %v and no files", resp, tt.wantStatus, tt.wantCensored)
+			}
+		})
+	}
+}
+
+func TestGenerateImageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		class  string
+	}{
+		{name: "moderated prompt", status: http.StatusBadRequest, body: `{"name":"invalid_prompts","message":"Invalid prompts detected"}`, class: errclass.ClassCensored},
+		{name: "invalid size", status: http.StatusBadRequest, body: `{"name":"invalid_sdxl_v1_dimensions","message":"dimensions are invalid"}`, class: errclass.ClassInvalid},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"name":"unauthorized","message":"missing authorization header"}`, class: errclass.ClassAuth},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: `{"name":"rate_limit_exceeded","message":"slow down"}`, class: errclass.ClassTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(respond(tt.status, tt.body, nil))
+			defer srv.Close()
+
+			_, err := NewClient("sk-test", "", WithBaseURL(srv.URL)).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			if got := errclass.Class(err); got != tt.class {
+				t.Errorf("GenerateImage() error = %v of class %s, want %s", err, got, tt.class)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Message == "" {
+				t.Errorf("GenerateImage() error = %#v, want an *APIError with status %d and the message", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckGenerationStatusKeepsNothing(t *testing.T) {
+	srv := httptest.NewServer(respond(http.StatusOK, `{"artifacts":[{"base64":"iVBORw0KGgo=","finishReason":"SUCCESS"}]}`, nil))
+	defer srv.Close()
+
+	c := NewClient("sk-test", "", WithBaseURL(srv.URL))
+	resp, err := c.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	// The result was returned by GenerateImage and is not kept for a later lookup
+	_, err = c.CheckGenerationStatus(context.Background(), resp.UUID)
+	var apiErr *APIError
+	if !errclass.IsNotFound(err) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("CheckGenerationStatus() error = %v, want a 404 APIError", err)
+	}
+}
+
+// domainErrors are the errors of the domain a client error may translate to
+var domainErrors = []error{domain.ErrCensored, domain.ErrQuotaExceeded, domain.ErrInvalidPrompt, domain.ErrProviderUnavailable, domain.ErrGenerationFailed}
+
+func TestErrorTranslation(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// want is the only error of the domain the client error matches, nil for none
+		want error
+	}{
+		{name: "moderated prompt", status: http.StatusBadRequest, body: `{"name":"invalid_prompts","message":"Invalid prompts detected"}`, want: domain.ErrCensored},
+		{name: "invalid size", status: http.StatusBadRequest, body: `{"name":"invalid_sdxl_v1_dimensions","message":"dimensions are invalid"}`, want: domain.ErrInvalidPrompt},
+		{name: "out of credits", status: http.StatusPaymentRequired, body: `{"name":"insufficient_balance","message":"You do not have enough credits"}`, want: domain.ErrQuotaExceeded},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: `{"name":"rate_limit_exceeded","message":"slow down"}`, want: domain.ErrQuotaExceeded},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"name":"unauthorized","message":"missing authorization header"}`},
+		{name: "server error", status: http.StatusInternalServerError, body: `{"name":"internal_error","message":"an unexpected server error occurred"}`, want: domain.ErrProviderUnavailable},
+		{name: "body not JSON", status: http.StatusBadGateway, body: `<html>bad gateway</html>`, want: domain.ErrProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(respond(tt.status, tt.body, nil))
+			defer srv.Close()
+
+			_, err := NewClient("sk-test", "", WithBaseURL(srv.URL)).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: 1024, Height: 1024, NumImages: 1})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GenerateImage() error = %v, want the *APIError kept for context", err)
+			}
+			for _, target := range domainErrors {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, target, got, !got)
+				}
+			}
+		})
+	}
+}
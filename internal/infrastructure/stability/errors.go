@@ -0,0 +1,60 @@
+package stability
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// invalidPrompts is the error name Stability AI uses for prompts refused by its moderation
+const invalidPrompts = "invalid_prompts"
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Name       string
+	Message    string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, name: %s, message: %s", e.StatusCode, e.Name, e.Message)
+}
+
+// HTTPStatus returns the HTTP status of the failed response
+func (e *APIError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// Unwrap maps moderated prompts onto domain.ErrCensored and the status onto the other errors
+// of the domain
+func (e *APIError) Unwrap() error {
+	if e.Name == invalidPrompts {
+		return domain.ErrCensored
+	}
+	return domain.ErrorForStatus(e.StatusCode)
+}
+
+// newAPIError builds an APIError from a non-successful response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+	}
+
+	var payload struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Message != "" {
+		apiErr.Name = payload.Name
+		apiErr.Message = payload.Message
+	}
+
+	return apiErr
+}
@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/internal/textutil"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+const (
+	defaultBaseURL = "https://api.telegram.org"
+
+	// PublisherName identifies the Telegram publisher
+	PublisherName = "telegram"
+
+	// maxCaptionLength is the longest photo caption Telegram accepts, in characters
+	maxCaptionLength = 1024
+)
+
+// Client posts images to a Telegram chat through the Bot API
+type Client struct {
+	httpClient *http.Client
+	token      string
+	chatID     string
+	baseURL    string
+}
+
+// Option configures optional Client settings
+type Option func(*Client)
+
+// WithBaseURL overrides the Bot API base URL, e.g. for a local Bot API server
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewClient creates a client posting to chatID, either a numeric ID or an @channel username
+func NewClient(token, chatID string, opts ...Option) *Client {
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: time.Minute,
+		},
+		token:   token,
+		chatID:  chatID,
+		baseURL: defaultBaseURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Name returns the publisher name
+func (c *Client) Name() string {
+	return PublisherName
+}
+
+// Publish sends the image as a photo captioned with its prompt and returns a link to the
+// message, which is empty for private chats
+func (c *Client) Publish(ctx context.Context, img *domain.Image, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("chat_id", c.chatID); err != nil {
+		return "", fmt.Errorf("failed to write chat_id: %w", err)
+	}
+	if err := w.WriteField("caption", caption(img.Prompt)); err != nil {
+		return "", fmt.Errorf("failed to write caption: %w", err)
+	}
+	part, err := w.CreateFormFile("photo", fmt.Sprintf("%d.png", img.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to create photo part: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write photo: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to close multipart body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/bot"+c.token+"/sendPhoto", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The URL contains the bot token, so drop it from the error
+		return "", fmt.Errorf("failed to send photo: %w", redactURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp)
+	}
+
+	var result struct {
+		Result struct {
+			MessageID int `json:"message_id"`
+			Chat      struct {
+				Username string `json:"username"`
+			} `json:"chat"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if result.Result.Chat.Username == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("https://t.me/%s/%d", result.Result.Chat.Username, result.Result.MessageID), nil
+}
+
+// caption truncates the prompt to the caption limit without splitting characters
+func caption(prompt string) string {
+	if utf8.RuneCountInString(prompt) <= maxCaptionLength {
+		return prompt
+	}
+	return textutil.TruncateRunes(prompt, maxCaptionLength-1) + "…"
+}
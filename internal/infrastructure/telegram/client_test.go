@@ -0,0 +1,218 @@
+package telegram_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/internal/infrastructure/telegram"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+const testToken = "123456:secret-token"
+
+// sentPhoto is a sendPhoto request received by fakeTelegram
+type sentPhoto struct {
+	ChatID   string
+	Caption  string
+	Filename string
+	Photo    []byte
+}
+
+// fakeTelegram is a fake Bot API answering sendPhoto for testToken
+type fakeTelegram struct {
+	*httptest.Server
+
+	// Username is the username of the chat in responses, empty for private chats
+	Username string
+
+	mu       sync.Mutex
+	sent     []sentPhoto
+	failures []failure
+}
+
+// failure is a response the server answers the next request with
+type failure struct {
+	status int
+	body   string
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{Username: "channel"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTelegram) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/bot"+testToken+"/sendPhoto" {
+		http.Error(w, `{"ok":false,"error_code":404,"description":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.failures) > 0 {
+		fail := f.failures[0]
+		f.failures = f.failures[1:]
+		w.WriteHeader(fail.status)
+		io.WriteString(w, fail.body)
+		return
+	}
+
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		http.Error(w, `{"ok":false,"error_code":400,"description":"Bad Request: there is no photo in the request"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	photo, _ := io.ReadAll(file)
+	f.sent = append(f.sent, sentPhoto{ChatID: r.FormValue("chat_id"), Caption: r.FormValue("caption"), Filename: header.Filename, Photo: photo})
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"ok":true,"result":{"message_id":`+strconv.Itoa(len(f.sent)+41)+`,"chat":{"id":-100,"username":"`+f.Username+`"}}}`)
+}
+
+// failNext answers the next request with status and body
+func (f *fakeTelegram) failNext(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, failure{status: status, body: body})
+}
+
+// photos returns the photos sent so far
+func (f *fakeTelegram) photos() []sentPhoto {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentPhoto(nil), f.sent...)
+}
+
+func TestPublishSendsPhoto(t *testing.T) {
+	srv := newFakeTelegram(t)
+	client := telegram.NewClient(testToken, "@channel", telegram.WithBaseURL(srv.URL+"/"))
+
+	url, err := client.Publish(context.Background(), &domain.Image{ID: 7, Prompt: "a lighthouse"}, []byte("png data"))
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if url != "https://t.me/channel/42" {
+		t.Errorf("Publish() = %q, want the message link", url)
+	}
+
+	sent := srv.photos()
+	if len(sent) != 1 {
+		t.Fatalf("server received %d photos, want 1", len(sent))
+	}
+	want := sentPhoto{ChatID: "@channel", Caption: "a lighthouse", Filename: "7.png", Photo: []byte("png data")}
+	if got := sent[0]; got.ChatID != want.ChatID || got.Caption != want.Caption || got.Filename != want.Filename || string(got.Photo) != string(want.Photo) {
+		t.Errorf("server received %+v, want %+v", got, want)
+	}
+}
+
+func TestPublishToPrivateChat(t *testing.T) {
+	srv := newFakeTelegram(t)
+	srv.Username = ""
+	client := telegram.NewClient(testToken, "-100", telegram.WithBaseURL(srv.URL))
+
+	url, err := client.Publish(context.Background(), &domain.Image{ID: 1, Prompt: "a lighthouse"}, []byte("png data"))
+	if err != nil || url != "" {
+		t.Errorf("Publish() = %q, %v, want no link to a private chat", url, err)
+	}
+}
+
+func TestPublishTruncatesCaption(t *testing.T) {
+	srv := newFakeTelegram(t)
+	client := telegram.NewClient(testToken, "@channel", telegram.WithBaseURL(srv.URL))
+
+	prompt := strings.Repeat("маяк ", 300)
+	if _, err := client.Publish(context.Background(), &domain.Image{ID: 1, Prompt: prompt}, []byte("png data")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	caption := srv.photos()[0].Caption
+	if n := utf8.RuneCountInString(caption); n != 1024 || !utf8.ValidString(caption) || !strings.HasSuffix(caption, "…") {
+		t.Errorf("caption has %d characters ending in %q, want 1024 valid ones ending in an ellipsis", n, caption[len(caption)-6:])
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		body           string
+		wantDesc       string
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:     "bad request",
+			status:   http.StatusBadRequest,
+			body:     `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+			wantDesc: "Bad Request: chat not found",
+		},
+		{
+			name:           "throttled",
+			status:         http.StatusTooManyRequests,
+			body:           `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`,
+			wantDesc:       "Too Many Requests: retry after 5",
+			wantRetryAfter: 5 * time.Second,
+		},
+		{
+			name:     "not json",
+			status:   http.StatusBadGateway,
+			body:     "<html>Bad Gateway</html>",
+			wantDesc: "<html>Bad Gateway</html>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeTelegram(t)
+			srv.failNext(tt.status, tt.body)
+			client := telegram.NewClient(testToken, "@channel", telegram.WithBaseURL(srv.URL))
+
+			_, err := client.Publish(context.Background(), &domain.Image{ID: 1, Prompt: "a lighthouse"}, []byte("png data"))
+			var apiErr *telegram.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Publish() error = %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.HTTPStatus() != tt.status || apiErr.Description != tt.wantDesc || apiErr.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("APIError = %+v, want status %d, description %q and retry after %v", apiErr, tt.status, tt.wantDesc, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestPublishKeepsTokenOutOfErrors(t *testing.T) {
+	srv := newFakeTelegram(t)
+	client := telegram.NewClient(testToken, "@channel", telegram.WithBaseURL(srv.URL))
+	srv.Close()
+
+	_, err := client.Publish(context.Background(), &domain.Image{ID: 1, Prompt: "a lighthouse"}, []byte("png data"))
+	if err == nil {
+		t.Fatal("Publish() to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Publish() error = %q, want the bot token left out", err)
+	}
+}
+
+func TestPublishRespectsCancellation(t *testing.T) {
+	srv := newFakeTelegram(t)
+	client := telegram.NewClient(testToken, "@channel", telegram.WithBaseURL(srv.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.Publish(ctx, &domain.Image{ID: 1, Prompt: "a lighthouse"}, []byte("png data")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Publish() error = %v, want %v", err, context.Canceled)
+	}
+	if sent := srv.photos(); len(sent) != 0 {
+		t.Errorf("server received %d photos, want none", len(sent))
+	}
+}
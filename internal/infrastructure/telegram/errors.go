@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// APIError is returned when the Bot API rejects a request
+type APIError struct {
+	StatusCode  int
+	Description string
+	// RetryAfter is how long Telegram asks to wait before retrying a throttled request
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, description: %s", e.StatusCode, e.Description)
+}
+
+// HTTPStatus returns the HTTP status of the failed response
+func (e *APIError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// newAPIError builds an APIError from a non-successful response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+
+	apiErr := &APIError{
+		StatusCode:  resp.StatusCode,
+		Description: string(body),
+	}
+
+	var payload struct {
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Description != "" {
+		apiErr.Description = payload.Description
+		apiErr.RetryAfter = time.Duration(payload.Parameters.RetryAfter) * time.Second
+	}
+
+	return apiErr
+}
+
+// redactURL strips the request URL, which contains the bot token, from transport errors
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+// Package logging builds slog loggers with a level per component.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+)
+
+// Options configures the loggers built by New
+type Options struct {
+	// Format is text or json
+	Format string
+	// Level applies to components without an override
+	Level slog.Level
+	// Levels overrides the level of individual components, e.g. client=debug
+	Levels map[string]slog.Level
+	// Secrets are masked wherever they appear in a message or attribute, e.g. in a provider
+	// error quoting a response body
+	Secrets []string
+}
+
+// Loggers hands out a logger per component, all writing through the same handler
+type Loggers struct {
+	handler slog.Handler
+	opts    Options
+}
+
+// New creates loggers writing to w in the configured format
+func New(w io.Writer, opts Options) *Loggers {
+	// The component handlers filter by level, so the shared handler lets everything through
+	handlerOpts := &slog.HandlerOptions{Level: slog.Level(-8)}
+
+	var handler slog.Handler = slog.NewTextHandler(w, handlerOpts)
+	if opts.Format == "json" {
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	}
+	if secrets := redact.New(opts.Secrets...); secrets != nil {
+		handler = &redactHandler{secrets: secrets, handler: handler}
+	}
+	return &Loggers{handler: handler, opts: opts}
+}
+
+// Handler returns the handler of a component, which drops records below the component's level
+func (l *Loggers) Handler(component string) slog.Handler {
+	level, ok := l.opts.Levels[component]
+	if !ok {
+		level = l.opts.Level
+	}
+	return &levelHandler{
+		level:   level,
+		handler: l.handler.WithAttrs([]slog.Attr{slog.String("component", component)}),
+	}
+}
+
+// Logger returns the logger of a component; its records carry a component attribute
+func (l *Loggers) Logger(component string) *slog.Logger {
+	return slog.New(l.Handler(component))
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// ParseLevels parses comma-separated component=level pairs, e.g. "client=debug,workflow=info"
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		component, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid component level %q, expected component=level", pair)
+		}
+		level, err := ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(component)] = level
+	}
+	return levels, nil
+}
+
+// levelHandler drops records below level before passing them on
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+// Enabled implements slog.Handler
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.handler.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithGroup(name)}
+}
+
+// redactHandler masks secrets in the records before passing them on
+type redactHandler struct {
+	secrets *redact.Redactor
+	handler slog.Handler
+}
+
+// Enabled implements slog.Handler
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, h.secrets.String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, masked)
+}
+
+// WithAttrs implements slog.Handler
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = h.attr(a)
+	}
+	return &redactHandler{secrets: h.secrets, handler: h.handler.WithAttrs(masked)}
+}
+
+// WithGroup implements slog.Handler
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{secrets: h.secrets, handler: h.handler.WithGroup(name)}
+}
+
+// attr masks the secrets in the value of a. Errors and other values are formatted the way the
+// handlers would format them and replaced by the masked text only when it differs.
+func (h *redactHandler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.secrets.String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		masked := make([]any, len(group))
+		for i, ga := range group {
+			masked[i] = h.attr(ga)
+		}
+		return slog.Group(a.Key, masked...)
+	case slog.KindAny:
+		var text string
+		if err, ok := v.Any().(error); ok {
+			text = err.Error()
+		} else {
+			text = fmt.Sprint(v.Any())
+		}
+		if masked := h.secrets.String(text); masked != text {
+			return slog.String(a.Key, masked)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
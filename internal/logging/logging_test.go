@@ -0,0 +1,65 @@
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/logging"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+)
+
+// stringer formats as its text, like a value logged with fmt
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+// logEverywhere logs text through every path that reaches the handler: the message, string,
+// error, fmt and group attributes, and attributes and groups added to a logger
+func logEverywhere(loggers *logging.Loggers, text string) {
+	log := loggers.Logger("client")
+	log.Info(text, "body", text, "error", fmt.Errorf("request failed: %w", errors.New(text)), "value", stringer(text))
+	log.Warn("Response", slog.Group("response", "body", text, slog.Group("headers", "x-key", text)))
+	log.With("request", text).WithGroup("retry").Error("Retrying", "reason", text)
+	slog.New(loggers.Handler("workflow")).With(slog.Group("image", "prompt", text)).Info("Processing image")
+}
+
+func FuzzLoggingMasksSecrets(f *testing.F) {
+	f.Add("abcdefghijkl", "rejected key ")
+	f.Add("short", "")
+	f.Add("abab1234ab", "abab1234")
+	f.Add("sk-x", "line\nbreak \"quoted\" ")
+	f.Fuzz(func(t *testing.T, key, text string) {
+		secret, ok := testsupport.FuzzSecret(key)
+		if !ok {
+			t.Skip()
+		}
+		for _, format := range []string{"text", "json"} {
+			var out bytes.Buffer
+			loggers := logging.New(&out, logging.Options{Format: format, Level: slog.LevelDebug, Secrets: []string{"unrelated-secret", secret}})
+			logEverywhere(loggers, text+secret+text+secret)
+
+			if strings.Contains(out.String(), secret) {
+				t.Fatalf("%s logs contain %q:\n%s", format, secret, out.String())
+			}
+			if n := strings.Count(out.String(), "\n"); n != 4 {
+				t.Fatalf("%s logs have %d lines, want every record logged:\n%s", format, n, out.String())
+			}
+		}
+	})
+}
+
+func TestLevels(t *testing.T) {
+	var out bytes.Buffer
+	loggers := logging.New(&out, logging.Options{Level: slog.LevelWarn, Levels: map[string]slog.Level{"client": slog.LevelDebug}})
+
+	loggers.Logger("client").Debug("Request")
+	loggers.Logger("workflow").Info("Processing image")
+	loggers.Logger("workflow").Warn("Retrying")
+	if got := out.String(); !strings.Contains(got, "msg=Request component=client") || strings.Contains(got, "Processing image") || !strings.Contains(got, "msg=Retrying component=workflow") {
+		t.Errorf("logs = %q, want the client debug record and the workflow warning only", got)
+	}
+}
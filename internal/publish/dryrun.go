@@ -0,0 +1,29 @@
+package publish
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// DryRun logs the images it is given instead of publishing them
+type DryRun struct {
+	logger *slog.Logger
+}
+
+// NewDryRun creates a DryRun publisher logging to logger
+func NewDryRun(logger *slog.Logger) *DryRun {
+	return &DryRun{logger: logger}
+}
+
+// Name returns "dry-run"
+func (d *DryRun) Name() string {
+	return "dry-run"
+}
+
+// Publish logs img and reports no URL
+func (d *DryRun) Publish(ctx context.Context, img *domain.Image, data []byte) (string, error) {
+	d.logger.Info("Dry run: would publish image", "image_id", img.ID, "uuid", img.UUID, "bytes", len(data))
+	return "", nil
+}
@@ -0,0 +1,104 @@
+// Package publish ships finished images to one or more destinations.
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/infrastructure/telegram"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// NewFromConfig creates the configured publishers, or nil when none is configured.
+// More than one publisher is wrapped in a Multi, with S3 first so its stable URL is recorded.
+func NewFromConfig(ctx context.Context, cfg *config.Config) (domain.Publisher, error) {
+	var publishers []domain.Publisher
+
+	if cfg.PublishS3Bucket != "" {
+		store, err := storage.NewS3(ctx, storage.S3Options{
+			Bucket:          cfg.PublishS3Bucket,
+			Region:          cfg.S3Region,
+			Endpoint:        cfg.S3Endpoint,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			UsePathStyle:    cfg.S3UsePathStyle,
+			CacheControl:    cfg.PublishS3CacheControl,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure S3 publisher: %w", err)
+		}
+		baseURL := cfg.PublishS3BaseURL
+		if baseURL == "" {
+			baseURL = DefaultS3BaseURL(cfg.PublishS3Bucket, cfg.S3Region, cfg.S3Endpoint)
+		}
+		publishers = append(publishers, NewS3(store, cfg.PublishS3Key, baseURL))
+	}
+
+	if cfg.TelegramBotToken != "" {
+		publishers = append(publishers, telegram.NewClient(cfg.TelegramBotToken, cfg.TelegramChatID))
+	}
+
+	switch len(publishers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return publishers[0], nil
+	default:
+		return NewMulti(publishers...), nil
+	}
+}
+
+// Multi publishes every image to all of its publishers. Publishing only fails when every
+// publisher fails; failures of the others are reported to OnPartialFailure.
+type Multi struct {
+	publishers []domain.Publisher
+
+	// OnPartialFailure is called for each publisher that failed while another one succeeded
+	OnPartialFailure func(img *domain.Image, publisher string, err error)
+}
+
+// NewMulti creates a publisher shipping images to all of the given publishers, in order
+func NewMulti(publishers ...domain.Publisher) *Multi {
+	return &Multi{publishers: publishers}
+}
+
+// Name returns the publisher name
+func (m *Multi) Name() string {
+	return "multi"
+}
+
+// Publish ships the image to every publisher and returns the first non-empty URL.
+// When all publishers fail the returned error joins their errors.
+func (m *Multi) Publish(ctx context.Context, img *domain.Image, data []byte) (string, error) {
+	var (
+		url       string
+		published bool
+		failures  []error
+		failed    []string
+	)
+	for _, p := range m.publishers {
+		u, err := p.Publish(ctx, img, data)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", p.Name(), err))
+			failed = append(failed, p.Name())
+			continue
+		}
+		published = true
+		if url == "" {
+			url = u
+		}
+	}
+
+	if !published {
+		return "", errors.Join(failures...)
+	}
+	if m.OnPartialFailure != nil {
+		for i, err := range failures {
+			m.OnPartialFailure(img, failed[i], err)
+		}
+	}
+	return url, nil
+}
@@ -0,0 +1,188 @@
+package publish_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/publish"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// bucket is a fake S3 API storing the objects uploaded to it, or rejecting every upload
+type bucket struct {
+	mu           sync.Mutex
+	objects      map[string][]byte
+	cacheControl map[string]string
+	reject       bool
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || b.reject {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[r.URL.Path] = data
+	b.cacheControl[r.URL.Path] = r.Header.Get("Cache-Control")
+}
+
+// newS3Publisher returns an S3 publisher to a fake bucket named public
+func newS3Publisher(t *testing.T, keyTemplate string) (*publish.S3, *bucket) {
+	t.Helper()
+	b := &bucket{objects: map[string][]byte{}, cacheControl: map[string]string{}}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	store, err := storage.NewS3(context.Background(), storage.S3Options{
+		Bucket:          "public",
+		Region:          "us-east-1",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		UsePathStyle:    true,
+		CacheControl:    "public, max-age=31536000, immutable",
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	return publish.NewS3(store, keyTemplate, "https://cdn.example.com/images/"), b
+}
+
+// stubPublisher returns url or err from every Publish and counts the calls
+type stubPublisher struct {
+	name  string
+	url   string
+	err   error
+	calls int
+}
+
+func (s *stubPublisher) Name() string { return s.name }
+
+func (s *stubPublisher) Publish(ctx context.Context, img *domain.Image, data []byte) (string, error) {
+	s.calls++
+	return s.url, s.err
+}
+
+var (
+	pngData  = []byte("\x89PNG\r\n\x1a\n rest of the png")
+	jpegData = []byte("\xff\xd8\xff\xe0 rest of the jpeg")
+)
+
+func TestS3Publish(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		data    []byte
+		wantKey string
+	}{
+		{name: "png", key: "{id}_{uuid}.{ext}", data: pngData, wantKey: "7_uuid-1.png"},
+		{name: "jpeg", key: "{id}_{uuid}.{ext}", data: jpegData, wantKey: "7_uuid-1.jpg"},
+		{name: "directories", key: "daily/{uuid}/{id}.{ext}", data: pngData, wantKey: "daily/uuid-1/7.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, b := newS3Publisher(t, tt.key)
+			url, err := p.Publish(context.Background(), &domain.Image{ID: 7, UUID: "uuid-1"}, tt.data)
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if want := "https://cdn.example.com/images/" + tt.wantKey; url != want {
+				t.Errorf("Publish() = %q, want %q", url, want)
+			}
+			object := "/public/" + tt.wantKey
+			if string(b.objects[object]) != string(tt.data) || b.cacheControl[object] != "public, max-age=31536000, immutable" {
+				t.Errorf("bucket holds %q with Cache-Control %q at %s, want the data with the configured header", b.objects[object], b.cacheControl[object], object)
+			}
+
+			// Publishing again overwrites the same object
+			if again, err := p.Publish(context.Background(), &domain.Image{ID: 7, UUID: "uuid-1"}, tt.data); err != nil || again != url {
+				t.Errorf("second Publish() = %q, %v, want the same URL", again, err)
+			}
+			if len(b.objects) != 1 {
+				t.Errorf("bucket holds %d objects, want 1", len(b.objects))
+			}
+		})
+	}
+}
+
+func TestS3PublishEscapesURL(t *testing.T) {
+	p, _ := newS3Publisher(t, "{uuid} final.{ext}")
+	url, err := p.Publish(context.Background(), &domain.Image{ID: 7, UUID: "uuid-1"}, pngData)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if want := "https://cdn.example.com/images/uuid-1%20final.png"; url != want {
+		t.Errorf("Publish() = %q, want %q", url, want)
+	}
+}
+
+func TestDefaultS3BaseURL(t *testing.T) {
+	if got := publish.DefaultS3BaseURL("public", "eu-west-1", ""); got != "https://public.s3.eu-west-1.amazonaws.com" {
+		t.Errorf("DefaultS3BaseURL() on AWS = %q", got)
+	}
+	if got := publish.DefaultS3BaseURL("public", "us-east-1", "http://minio:9000/"); got != "http://minio:9000/public" {
+		t.Errorf("DefaultS3BaseURL() with an endpoint = %q", got)
+	}
+}
+
+func TestMultiPartialFailure(t *testing.T) {
+	s3, b := newS3Publisher(t, "{id}.{ext}")
+	b.reject = true
+	telegram := &stubPublisher{name: "telegram", url: "https://t.me/channel/42"}
+	m := publish.NewMulti(s3, telegram)
+	var partial []string
+	m.OnPartialFailure = func(img *domain.Image, publisher string, err error) {
+		partial = append(partial, publisher)
+	}
+
+	url, err := m.Publish(context.Background(), &domain.Image{ID: 7, UUID: "uuid-1"}, pngData)
+	if err != nil || url != telegram.url {
+		t.Errorf("Publish() = %q, %v, want the URL of the publisher that succeeded", url, err)
+	}
+	if len(partial) != 1 || partial[0] != publish.S3Name {
+		t.Errorf("partial failures = %v, want the S3 one", partial)
+	}
+}
+
+func TestMultiFirstURLWins(t *testing.T) {
+	s3, b := newS3Publisher(t, "{id}.{ext}")
+	telegram := &stubPublisher{name: "telegram", url: "https://t.me/channel/42"}
+	url, err := publish.NewMulti(s3, telegram).Publish(context.Background(), &domain.Image{ID: 7}, pngData)
+	if err != nil || url != "https://cdn.example.com/images/7.png" {
+		t.Errorf("Publish() = %q, %v, want the stable S3 URL", url, err)
+	}
+	if telegram.calls != 1 || len(b.objects) != 1 {
+		t.Errorf("published %d times to Telegram and %d objects to S3, want each once", telegram.calls, len(b.objects))
+	}
+
+	// Publishers without a URL leave it to the next one
+	private := &stubPublisher{name: "private"}
+	if url, err := publish.NewMulti(private, telegram).Publish(context.Background(), &domain.Image{ID: 7}, pngData); err != nil || url != telegram.url {
+		t.Errorf("Publish() = %q, %v, want the first non-empty URL", url, err)
+	}
+}
+
+func TestMultiAllFail(t *testing.T) {
+	s3, b := newS3Publisher(t, "{id}.{ext}")
+	b.reject = true
+	throttled := errors.New("too many requests")
+	telegram := &stubPublisher{name: "telegram", err: throttled}
+	m := publish.NewMulti(s3, telegram)
+	m.OnPartialFailure = func(img *domain.Image, publisher string, err error) {
+		t.Errorf("OnPartialFailure(%s) called when every publisher failed", publisher)
+	}
+
+	_, err := m.Publish(context.Background(), &domain.Image{ID: 7}, pngData)
+	if !errors.Is(err, throttled) || !strings.Contains(err.Error(), "s3: ") || !strings.Contains(err.Error(), "telegram: ") {
+		t.Errorf("Publish() error = %v, want the errors of both publishers", err)
+	}
+}
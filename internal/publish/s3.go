@@ -0,0 +1,73 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// S3Name identifies the S3 publisher
+const S3Name = "s3"
+
+// S3 uploads images to a public bucket under a stable key
+type S3 struct {
+	store   *storage.S3
+	key     string
+	baseURL string
+}
+
+// NewS3 creates a publisher uploading to store. The key template supports {id}, {uuid} and
+// {ext}, the extension matching the image format; public URLs are the key appended to baseURL.
+func NewS3(store *storage.S3, keyTemplate, baseURL string) *S3 {
+	return &S3{
+		store:   store,
+		key:     keyTemplate,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// DefaultS3BaseURL returns the public URL of a bucket: path style below a custom endpoint,
+// virtual-hosted style on AWS
+func DefaultS3BaseURL(bucket, region, endpoint string) string {
+	if endpoint != "" {
+		return strings.TrimRight(endpoint, "/") + "/" + bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+}
+
+// Name returns the publisher name
+func (p *S3) Name() string {
+	return S3Name
+}
+
+// Publish uploads the image and returns its public URL. Keys are derived from the image, so
+// publishing it again overwrites the same object.
+func (p *S3) Publish(ctx context.Context, img *domain.Image, data []byte) (string, error) {
+	key := strings.ReplaceAll(storage.Key(p.key, img.ID, img.UUID, 0), "{ext}", extension(data))
+
+	location, err := p.store.Save(ctx, key, data)
+	if err != nil {
+		return "", err
+	}
+
+	return p.baseURL + "/" + (&url.URL{Path: location}).EscapedPath(), nil
+}
+
+// extension returns the file extension for the format of data, defaulting to png
+func extension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return "jpg"
+	case "image/webp":
+		return "webp"
+	case "image/gif":
+		return "gif"
+	default:
+		return "png"
+	}
+}
@@ -0,0 +1,243 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// ConsumerConfig holds the settings of a Consumer
+type ConsumerConfig struct {
+	// Concurrency is the number of jobs handled in parallel
+	Concurrency int
+	// Visibility is how long a delivered job stays hidden from other consumers; it is extended
+	// while the job is handled
+	Visibility time.Duration
+	// PollInterval is the wait before looking for jobs again after the queue was empty
+	PollInterval time.Duration
+	// MaxAttempts is how often a job that fails transiently is delivered before it fails for
+	// good; it waits RetryBaseDelay, doubled with every attempt up to RetryMaxDelay, in between
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// Defaults fills in the request fields a job leaves unset
+	Defaults domain.ImageGenerationRequest
+}
+
+// Consumer generates the images of the jobs of a queue and stores their results
+type Consumer struct {
+	queue   Queue
+	results ResultStore
+	service domain.ImageGenerationService
+	cfg     ConsumerConfig
+	log     *slog.Logger
+}
+
+// NewConsumer creates a consumer of the jobs in queue, storing their outcome in results
+func NewConsumer(queue Queue, results ResultStore, service domain.ImageGenerationService, cfg ConsumerConfig, logger *slog.Logger) *Consumer {
+	cfg.Concurrency = max(cfg.Concurrency, 1)
+	cfg.MaxAttempts = max(cfg.MaxAttempts, 1)
+	return &Consumer{queue: queue, results: results, service: service, cfg: cfg, log: logger}
+}
+
+// Run handles jobs with Concurrency workers until ctx is cancelled. Jobs in flight finish under
+// the WorkContext of ctx; a job abandoned by a hard kill is delivered again once its visibility
+// ends.
+func (c *Consumer) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.work(ctx, false)
+		}()
+	}
+	workers.Wait()
+	c.log.Info("Queue consumer stopped", "workflow", "generator")
+}
+
+// RunOnce handles jobs until the queue is empty and returns the number of jobs handled and
+// the first error of a worker
+func (c *Consumer) RunOnce(ctx context.Context) (int, error) {
+	var (
+		workers sync.WaitGroup
+		mu      sync.Mutex
+		handled int
+		first   error
+	)
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			n, err := c.work(ctx, true)
+			mu.Lock()
+			defer mu.Unlock()
+			handled += n
+			if first == nil {
+				first = err
+			}
+		}()
+	}
+	workers.Wait()
+	return handled, first
+}
+
+// work handles jobs until ctx is cancelled or, with untilEmpty, the queue is empty. It returns
+// the number of jobs handled and, with untilEmpty, the error that stopped it.
+func (c *Consumer) work(ctx context.Context, untilEmpty bool) (int, error) {
+	handled := 0
+	failures := 0
+	for ctx.Err() == nil {
+		d, err := c.queue.Dequeue(ctx, c.cfg.Visibility)
+		switch {
+		case err != nil && untilEmpty:
+			return handled, err
+		case err != nil:
+			failures++
+			if failures == 1 {
+				c.log.Error("Error dequeuing job, retrying", "workflow", "generator", "error", err)
+			}
+			c.sleep(ctx, backoff(c.cfg.PollInterval, c.cfg.RetryMaxDelay, failures))
+			continue
+		case d == nil && untilEmpty:
+			return handled, nil
+		case d == nil:
+			failures = 0
+			c.sleep(ctx, c.cfg.PollInterval)
+			continue
+		}
+
+		if failures > 0 {
+			c.log.Info("Dequeuing jobs again", "workflow", "generator", "failures", failures)
+			failures = 0
+		}
+		if err := c.handle(ctx, d); err != nil {
+			c.log.Error("Error handling job", "workflow", "generator", "job_id", d.Job.ID, "attempt", d.Attempt, "error", err)
+		}
+		handled++
+	}
+	return handled, nil
+}
+
+// handle generates the image of a delivered job, stores the result and acknowledges the job.
+// A transient failure returns the job to the queue while attempts are left.
+func (c *Consumer) handle(ctx context.Context, d *Delivery) error {
+	work := workflows.WorkContext(ctx)
+	log := c.log.With("workflow", "generator", "job_id", d.Job.ID, "attempt", d.Attempt)
+
+	stop := c.keepHidden(work, d)
+	res, err := c.service.GenerateAndWait(work, c.request(d.Job))
+	stop()
+	if work.Err() != nil {
+		// Killed in flight; the job is delivered again once its visibility ends
+		return work.Err()
+	}
+
+	result := Result{ID: d.Job.ID, FinishedAt: time.Now()}
+	switch {
+	case err == nil:
+		result.Status = domain.StatusReadyToPublish
+		result.UUID, result.Provider, result.Image = res.UUID, res.Provider, res.Data
+		log.Info("Image generated", "uuid", res.UUID, "provider", res.Provider)
+	case errclass.IsCensored(err):
+		result.Status = domain.StatusCensored
+		result.Error = err.Error()
+		log.Warn("Image censored", "error", err)
+	case errclass.IsRetryable(err) && d.Attempt < c.cfg.MaxAttempts:
+		delay := backoff(c.cfg.RetryBaseDelay, c.cfg.RetryMaxDelay, d.Attempt)
+		log.Warn("Error generating image, retrying later", "retry_in", delay, "error", err)
+		return c.queue.Nack(work, d, delay)
+	default:
+		result.Status = domain.StatusFailed
+		result.Error = err.Error()
+		log.Error("Image generation failed", "error_class", errclass.Class(err), "error", err)
+	}
+
+	if err := c.results.SaveResult(work, result); err != nil {
+		// Generate the image again later rather than losing its outcome
+		if nackErr := c.queue.Nack(work, d, c.cfg.RetryBaseDelay); nackErr != nil {
+			log.Error("Error returning job", "error", nackErr)
+		}
+		return err
+	}
+	if err := c.queue.Ack(work, d); err != nil {
+		return fmt.Errorf("failed to acknowledge job: %w", err)
+	}
+	return nil
+}
+
+// request returns the generation request of job, with the defaults filled in
+func (c *Consumer) request(job Job) domain.ImageGenerationRequest {
+	req := c.cfg.Defaults
+	req.Prompt = job.Prompt
+	if job.Width != 0 && job.Height != 0 {
+		req.Width, req.Height = job.Width, job.Height
+	}
+	if job.Style != "" {
+		req.Style = job.Style
+	}
+	if job.NegativePrompt != "" {
+		req.NegativePrompt = job.NegativePrompt
+	}
+	req.Seed = job.Seed
+	req.Extra = job.Params
+	return req
+}
+
+// keepHidden extends the visibility of d every third of it until the returned function is
+// called, so other consumers do not pick up a job that takes long
+func (c *Consumer) keepHidden(ctx context.Context, d *Delivery) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(max(c.cfg.Visibility/3, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				held, err := c.queue.Extend(ctx, d, c.cfg.Visibility)
+				if err != nil && ctx.Err() == nil {
+					c.log.Warn("Error extending job visibility", "workflow", "generator", "job_id", d.Job.ID, "error", err)
+				} else if err == nil && !held {
+					c.log.Warn("Job no longer held, it may be generated twice", "workflow", "generator", "job_id", d.Job.ID)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// sleep waits for d or until ctx is cancelled
+func (c *Consumer) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// backoff returns base doubled for every attempt after the first, up to maxDelay
+func backoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if maxDelay > 0 && d > maxDelay {
+		d = maxDelay
+	}
+	return d
+}
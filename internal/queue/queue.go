@@ -0,0 +1,73 @@
+// Package queue hands generation jobs to the generator through a message queue instead of the
+// images table, so workers need no access to the database. Jobs are delivered with a
+// visibility timeout: a job that is not acknowledged in time is delivered again.
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// Job is a prompt queued for generation along with its request overrides
+type Job struct {
+	// ID is assigned by Enqueue
+	ID     int    `json:"id"`
+	Prompt string `json:"prompt"`
+	// Width, Height, Style and NegativePrompt override the configured defaults when set
+	Width          int                    `json:"width,omitempty"`
+	Height         int                    `json:"height,omitempty"`
+	Style          string                 `json:"style,omitempty"`
+	NegativePrompt string                 `json:"negative_prompt,omitempty"`
+	Seed           int64                  `json:"seed,omitempty"`
+	Params         map[string]interface{} `json:"params,omitempty"`
+	EnqueuedAt     time.Time              `json:"enqueued_at"`
+}
+
+// Delivery is a job handed to a consumer, which must Ack or Nack it before its visibility
+// timeout expires
+type Delivery struct {
+	Job Job
+	// Attempt counts the deliveries of the job, starting at 1
+	Attempt int
+}
+
+// Result is the outcome of a job
+type Result struct {
+	ID int
+	// Status is ReadyToPublish, Failed or Censored
+	Status   domain.ImageStatus
+	UUID     string
+	Provider string
+	// Image is the first generated file, decoded
+	Image      []byte
+	Error      string
+	FinishedAt time.Time
+}
+
+// Queue stores jobs until a consumer acknowledges them. Implementations must be safe for
+// concurrent use.
+type Queue interface {
+	// Enqueue queues job and returns the ID assigned to it
+	Enqueue(ctx context.Context, job Job) (int, error)
+	// Dequeue hands out the oldest job, hidden from other consumers for visibility. It returns
+	// nil when no job is waiting.
+	Dequeue(ctx context.Context, visibility time.Duration) (*Delivery, error)
+	// Extend hides a delivered job for another visibility from now and reports whether the
+	// delivery was still held
+	Extend(ctx context.Context, d *Delivery, visibility time.Duration) (bool, error)
+	// Ack removes a delivered job from the queue
+	Ack(ctx context.Context, d *Delivery) error
+	// Nack returns a delivered job to the queue, to be delivered again after delay
+	Nack(ctx context.Context, d *Delivery, delay time.Duration) error
+}
+
+// ResultStore keeps the results of jobs, the narrow write access of a queue consumer.
+// Implementations must be safe for concurrent use.
+type ResultStore interface {
+	// SaveResult stores the outcome of a job, replacing an earlier one
+	SaveResult(ctx context.Context, r Result) error
+	// GetResult returns the outcome of the job with the given ID, or nil while there is none
+	GetResult(ctx context.Context, id int) (*Result, error)
+}
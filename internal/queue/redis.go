@@ -0,0 +1,276 @@
+package queue
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// The scripts run atomically on the server. Jobs are kept in the jobs hash by ID; pending is a
+// list of the IDs waiting, pushed on the left and popped on the right, and inflight a sorted
+// set of the IDs delivered, scored by the Unix milliseconds their visibility ends.
+var (
+	// enqueueScript stores a job and appends it to the queue
+	enqueueScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return redis.call('LPUSH', KEYS[2], ARGV[1])`)
+
+	// dequeueScript returns the jobs whose visibility ended to the front of the queue, then
+	// delivers the oldest one as {id, job, attempt}
+	dequeueScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('RPUSH', KEYS[1], id)
+end
+local id = redis.call('RPOP', KEYS[1])
+if not id then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[2], id)
+local attempt = redis.call('HINCRBY', KEYS[4], id, 1)
+return {id, redis.call('HGET', KEYS[3], id), attempt}`)
+
+	// extendScript moves the end of the visibility of a delivered job, if it is still held
+	extendScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1`)
+
+	// ackScript forgets a job
+	ackScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1`)
+)
+
+// Redis is a Queue and ResultStore on a Redis server, with every key under a prefix
+type Redis struct {
+	client *redis.Client
+	prefix string
+	// resultTTL is how long results are kept; zero keeps them
+	resultTTL time.Duration
+	timeout   time.Duration
+	clk       clock.Clock
+}
+
+// RedisOption configures optional Redis settings
+type RedisOption func(*Redis)
+
+// WithPrefix sets the prefix of the keys; the default is 2xiang
+func WithPrefix(prefix string) RedisOption {
+	return func(r *Redis) {
+		r.prefix = prefix
+	}
+}
+
+// WithResultTTL expires results ttl after they are saved
+func WithResultTTL(ttl time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.resultTTL = ttl
+	}
+}
+
+// WithTimeout bounds connecting and every command; the default is 10 seconds
+func WithTimeout(timeout time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.timeout = timeout
+	}
+}
+
+// WithClock sets the clock that visibility timeouts are measured on
+func WithClock(c clock.Clock) RedisOption {
+	return func(r *Redis) {
+		r.clk = c
+	}
+}
+
+// NewRedis creates a queue on the server at rawURL, e.g. redis://:password@localhost:6379/0,
+// or rediss:// for TLS. Nothing is dialed until the first command.
+func NewRedis(rawURL string, opts ...RedisOption) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL: unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid Redis URL: missing host")
+	}
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	// redis://password@host is a common shorthand for the password alone
+	if u.User != nil {
+		if _, ok := u.User.Password(); !ok {
+			options.Username, options.Password = "", u.User.Username()
+		}
+	}
+
+	r := &Redis{prefix: "2xiang", timeout: 10 * time.Second, clk: clock.Real()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	options.DialTimeout = r.timeout
+	options.ReadTimeout = r.timeout
+	options.WriteTimeout = r.timeout
+	r.client = redis.NewClient(options)
+
+	return r, nil
+}
+
+// key returns the key of name under the prefix
+func (r *Redis) key(name string) string {
+	return r.prefix + ":" + name
+}
+
+// Ping checks that the server is reachable
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close closes the connection to the server
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Enqueue stores job under a new ID and appends it to the queue
+func (r *Redis) Enqueue(ctx context.Context, job Job) (int, error) {
+	id, err := r.client.Incr(ctx, r.key("next_id")).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign job ID: %w", err)
+	}
+	job.ID = int(id)
+	if job.EnqueuedAt.IsZero() {
+		job.EnqueuedAt = r.clk.Now().UTC()
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if err := enqueueScript.Run(ctx, r.client, []string{r.key("jobs"), r.key("pending")}, job.ID, data).Err(); err != nil {
+		return 0, fmt.Errorf("failed to queue job: %w", err)
+	}
+	return job.ID, nil
+}
+
+// Dequeue delivers the oldest waiting job, first returning the jobs whose visibility ended to
+// the front of the queue
+func (r *Redis) Dequeue(ctx context.Context, visibility time.Duration) (*Delivery, error) {
+	now := r.clk.Now()
+	keys := []string{r.key("pending"), r.key("inflight"), r.key("jobs"), r.key("attempts")}
+	reply, err := dequeueScript.Run(ctx, r.client, keys, now.UnixMilli(), now.Add(visibility).UnixMilli()).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dequeue job: %w", err)
+	}
+	if len(reply) != 3 {
+		return nil, fmt.Errorf("failed to dequeue job: unexpected reply %v", reply)
+	}
+
+	rawID, _ := reply[0].(string)
+	data, _ := reply[1].(string)
+	attempt, _ := reply[2].(int64)
+	id, _ := strconv.Atoi(rawID)
+	d := &Delivery{Attempt: int(attempt)}
+	if err := json.Unmarshal([]byte(data), &d.Job); err != nil {
+		// A job that cannot be read never will be, so it fails rather than being delivered again
+		err = fmt.Errorf("failed to unmarshal job %d: %w", id, err)
+		d.Job.ID = id
+		if saveErr := r.SaveResult(ctx, Result{ID: id, Status: domain.StatusFailed, Error: err.Error(), FinishedAt: now}); saveErr != nil {
+			return nil, errors.Join(err, saveErr)
+		}
+		return nil, errors.Join(err, r.Ack(ctx, d))
+	}
+	return d, nil
+}
+
+// Extend hides a delivered job for another visibility from now
+func (r *Redis) Extend(ctx context.Context, d *Delivery, visibility time.Duration) (bool, error) {
+	held, err := extendScript.Run(ctx, r.client, []string{r.key("inflight")}, d.Job.ID, r.clk.Now().Add(visibility).UnixMilli()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to extend visibility of job %d: %w", d.Job.ID, err)
+	}
+	return held == 1, nil
+}
+
+// Ack removes a delivered job along with its delivery count
+func (r *Redis) Ack(ctx context.Context, d *Delivery) error {
+	keys := []string{r.key("inflight"), r.key("jobs"), r.key("attempts")}
+	if err := ackScript.Run(ctx, r.client, keys, d.Job.ID).Err(); err != nil {
+		return fmt.Errorf("failed to acknowledge job %d: %w", d.Job.ID, err)
+	}
+	return nil
+}
+
+// Nack keeps a delivered job hidden for delay, after which the next Dequeue returns it to the
+// front of the queue
+func (r *Redis) Nack(ctx context.Context, d *Delivery, delay time.Duration) error {
+	err := r.client.ZAddXX(ctx, r.key("inflight"), redis.Z{Score: float64(r.clk.Now().Add(delay).UnixMilli()), Member: d.Job.ID}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to return job %d: %w", d.Job.ID, err)
+	}
+	return nil
+}
+
+// SaveResult stores the outcome of a job in the hash result:<id>
+func (r *Redis) SaveResult(ctx context.Context, res Result) error {
+	key := r.key("result:" + strconv.Itoa(res.ID))
+	err := r.client.HSet(ctx, key,
+		"status", string(res.Status),
+		"uuid", res.UUID,
+		"provider", res.Provider,
+		"image", base64.StdEncoding.EncodeToString(res.Image),
+		"error", res.Error,
+		"finished_at", res.FinishedAt.UTC().Format(time.RFC3339Nano)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save result of job %d: %w", res.ID, err)
+	}
+	if r.resultTTL > 0 {
+		if err := r.client.PExpire(ctx, key, r.resultTTL).Err(); err != nil {
+			return fmt.Errorf("failed to expire result of job %d: %w", res.ID, err)
+		}
+	}
+	return nil
+}
+
+// GetResult returns the outcome of the job with the given ID, or nil while there is none
+func (r *Redis) GetResult(ctx context.Context, id int) (*Result, error) {
+	fields, err := r.client.HGetAll(ctx, r.key("result:"+strconv.Itoa(id))).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get result of job %d: %w", id, err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	res := &Result{
+		ID:       id,
+		Status:   domain.ImageStatus(fields["status"]),
+		UUID:     fields["uuid"],
+		Provider: fields["provider"],
+		Error:    fields["error"],
+	}
+	if res.Image, err = base64.StdEncoding.DecodeString(fields["image"]); err != nil {
+		return nil, fmt.Errorf("failed to decode result of job %d: %w", id, err)
+	}
+	res.FinishedAt, _ = time.Parse(time.RFC3339Nano, fields["finished_at"])
+	return res, nil
+}
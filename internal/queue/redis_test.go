@@ -0,0 +1,328 @@
+package queue_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/basel-ax/2xiang/internal/queue"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// visibility is the visibility timeout of the deliveries in the tests
+const visibility = 30 * time.Second
+
+// newTestRedis returns a queue on an in-memory Redis server whose visibility timeouts are
+// measured on the returned fake clock
+func newTestRedis(t *testing.T, opts ...queue.RedisOption) (*queue.Redis, *miniredis.Miniredis, *clock.Fake) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	r, err := queue.NewRedis("redis://"+mr.Addr(), append([]queue.RedisOption{queue.WithClock(clk)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewRedis() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, mr, clk
+}
+
+// enqueue queues a job for every prompt and returns their IDs
+func enqueue(t *testing.T, r *queue.Redis, prompts ...string) []int {
+	t.Helper()
+	ids := make([]int, 0, len(prompts))
+	for _, prompt := range prompts {
+		id, err := r.Enqueue(context.Background(), queue.Job{Prompt: prompt})
+		if err != nil {
+			t.Fatalf("Enqueue(%q) error = %v", prompt, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// dequeue delivers the next job, failing the test on an error
+func dequeue(t *testing.T, r *queue.Redis) *queue.Delivery {
+	t.Helper()
+	d, err := r.Dequeue(context.Background(), visibility)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return d
+}
+
+func TestRedisEnqueueDequeue(t *testing.T) {
+	r, _, clk := newTestRedis(t)
+	ctx := context.Background()
+	if err := r.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if d := dequeue(t, r); d != nil {
+		t.Fatalf("Dequeue() of an empty queue = %+v, want nil", d)
+	}
+
+	job := queue.Job{Prompt: "a lighthouse", Width: 512, Height: 768, Style: "ANIME", Seed: 42, Params: map[string]interface{}{"steps": 30.0}}
+	first, err := r.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	second := enqueue(t, r, "a harbour")[0]
+	if first != 1 || second != 2 {
+		t.Errorf("Enqueue() IDs = %d, %d, want 1, 2", first, second)
+	}
+
+	// Jobs are delivered oldest first, with everything they were queued with
+	d := dequeue(t, r)
+	if d == nil || d.Job.ID != first || d.Attempt != 1 {
+		t.Fatalf("Dequeue() = %+v, want job %d on attempt 1", d, first)
+	}
+	if d.Job.Prompt != job.Prompt || d.Job.Width != 512 || d.Job.Height != 768 || d.Job.Style != "ANIME" || d.Job.Seed != 42 || d.Job.Params["steps"] != 30.0 {
+		t.Errorf("Dequeue() job = %+v, want the fields of %+v", d.Job, job)
+	}
+	if !d.Job.EnqueuedAt.Equal(clk.Now()) {
+		t.Errorf("Dequeue() EnqueuedAt = %v, want %v", d.Job.EnqueuedAt, clk.Now())
+	}
+	if d := dequeue(t, r); d == nil || d.Job.ID != second {
+		t.Fatalf("second Dequeue() = %+v, want job %d", d, second)
+	}
+	if d := dequeue(t, r); d != nil {
+		t.Errorf("Dequeue() with every job in flight = %+v, want nil", d)
+	}
+}
+
+func TestRedisVisibilityTimeout(t *testing.T) {
+	r, _, clk := newTestRedis(t)
+	ctx := context.Background()
+	id := enqueue(t, r, "a lighthouse")[0]
+
+	d := dequeue(t, r)
+	if d == nil || d.Job.ID != id {
+		t.Fatalf("Dequeue() = %+v, want job %d", d, id)
+	}
+
+	// The job stays hidden until its visibility ends, then it is delivered again
+	clk.Advance(visibility - time.Millisecond)
+	if d := dequeue(t, r); d != nil {
+		t.Fatalf("Dequeue() before the visibility ended = %+v, want nil", d)
+	}
+	clk.Advance(time.Millisecond)
+	again := dequeue(t, r)
+	if again == nil || again.Job.ID != id || again.Attempt != 2 {
+		t.Fatalf("Dequeue() after the visibility ended = %+v, want job %d on attempt 2", again, id)
+	}
+
+	// The redelivery starts a new visibility, so the job is not handed out a third time yet
+	clk.Advance(visibility / 2)
+	if d := dequeue(t, r); d != nil {
+		t.Fatalf("Dequeue() during the new visibility = %+v, want nil", d)
+	}
+
+	// Once acknowledged, the job is gone for good
+	if err := r.Ack(ctx, again); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	clk.Advance(2 * visibility)
+	if d := dequeue(t, r); d != nil {
+		t.Errorf("Dequeue() after Ack() = %+v, want nil", d)
+	}
+	if held, err := r.Extend(ctx, again, visibility); err != nil || held {
+		t.Errorf("Extend() after Ack() = %t, %v, want false", held, err)
+	}
+}
+
+func TestRedisExtend(t *testing.T) {
+	r, _, clk := newTestRedis(t)
+	ctx := context.Background()
+	enqueue(t, r, "a lighthouse")
+	d := dequeue(t, r)
+
+	// Extending moves the end of the visibility to a visibility from now
+	clk.Advance(20 * time.Second)
+	if held, err := r.Extend(ctx, d, visibility); err != nil || !held {
+		t.Fatalf("Extend() = %t, %v, want true", held, err)
+	}
+	clk.Advance(20 * time.Second)
+	if d := dequeue(t, r); d != nil {
+		t.Fatalf("Dequeue() after Extend() = %+v, want the job still hidden", d)
+	}
+	clk.Advance(10 * time.Second)
+	if again := dequeue(t, r); again == nil || again.Job.ID != d.Job.ID || again.Attempt != 2 {
+		t.Fatalf("Dequeue() after the extended visibility = %+v, want job %d on attempt 2", again, d.Job.ID)
+	}
+}
+
+func TestRedisNack(t *testing.T) {
+	r, _, clk := newTestRedis(t)
+	ctx := context.Background()
+	ids := enqueue(t, r, "a lighthouse", "a harbour")
+	d := dequeue(t, r)
+
+	if err := r.Nack(ctx, d, 5*time.Second); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	// The returned job waits for its delay, while the jobs behind it are delivered
+	if next := dequeue(t, r); next == nil || next.Job.ID != ids[1] {
+		t.Fatalf("Dequeue() right after Nack() = %+v, want job %d", next, ids[1])
+	}
+	clk.Advance(5*time.Second - time.Millisecond)
+	if again := dequeue(t, r); again != nil {
+		t.Fatalf("Dequeue() during the delay = %+v, want nil", again)
+	}
+	clk.Advance(time.Millisecond)
+	if again := dequeue(t, r); again == nil || again.Job.ID != ids[0] || again.Attempt != 2 {
+		t.Fatalf("Dequeue() after the delay = %+v, want job %d on attempt 2", again, ids[0])
+	}
+}
+
+func TestRedisMalformedJobFails(t *testing.T) {
+	r, mr, clk := newTestRedis(t)
+	ctx := context.Background()
+	mr.HSet("2xiang:jobs", "7", "{not json")
+	if _, err := mr.Lpush("2xiang:pending", "7"); err != nil {
+		t.Fatalf("Lpush() error = %v", err)
+	}
+
+	if d, err := r.Dequeue(ctx, visibility); err == nil || d != nil {
+		t.Fatalf("Dequeue() of a malformed job = %+v, %v, want an error", d, err)
+	}
+
+	// The job fails instead of being delivered again
+	res, err := r.GetResult(ctx, 7)
+	if err != nil {
+		t.Fatalf("GetResult() error = %v", err)
+	}
+	if res == nil || res.Status != domain.StatusFailed || !strings.Contains(res.Error, "unmarshal job 7") {
+		t.Errorf("GetResult() = %+v, want a failed result", res)
+	}
+	clk.Advance(2 * visibility)
+	if d := dequeue(t, r); d != nil {
+		t.Errorf("Dequeue() after the malformed job = %+v, want nil", d)
+	}
+	if mr.Exists("2xiang:jobs") && mr.HGet("2xiang:jobs", "7") != "" {
+		t.Error("malformed job is still stored")
+	}
+}
+
+func TestRedisResults(t *testing.T) {
+	r, mr, _ := newTestRedis(t, queue.WithPrefix("cms"), queue.WithResultTTL(time.Hour))
+	ctx := context.Background()
+
+	if res, err := r.GetResult(ctx, 1); err != nil || res != nil {
+		t.Fatalf("GetResult() of an unknown job = %+v, %v, want nil", res, err)
+	}
+	want := queue.Result{
+		ID:         1,
+		Status:     domain.StatusReadyToPublish,
+		UUID:       "uuid-1",
+		Provider:   "fake",
+		Image:      []byte{0x89, 'P', 'N', 'G', 0},
+		FinishedAt: time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC),
+	}
+	if err := r.SaveResult(ctx, want); err != nil {
+		t.Fatalf("SaveResult() error = %v", err)
+	}
+	got, err := r.GetResult(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetResult() error = %v", err)
+	}
+	if got == nil || got.Status != want.Status || got.UUID != want.UUID || got.Provider != want.Provider ||
+		string(got.Image) != string(want.Image) || !got.FinishedAt.Equal(want.FinishedAt) {
+		t.Errorf("GetResult() = %+v, want %+v", got, want)
+	}
+
+	// Results live under the prefix and expire after the TTL
+	if ttl := mr.TTL("cms:result:1"); ttl != time.Hour {
+		t.Errorf("TTL of the result = %v, want 1h", ttl)
+	}
+	mr.FastForward(time.Hour)
+	if res, err := r.GetResult(ctx, 1); err != nil || res != nil {
+		t.Errorf("GetResult() after the TTL = %+v, %v, want nil", res, err)
+	}
+}
+
+func TestNewRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("s3cret")
+
+	// redis://password@host is the password alone
+	for _, rawURL := range []string{"redis://:s3cret@" + mr.Addr(), "redis://s3cret@" + mr.Addr()} {
+		r, err := queue.NewRedis(rawURL, queue.WithTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("NewRedis(%q) error = %v", rawURL, err)
+		}
+		if err := r.Ping(context.Background()); err != nil {
+			t.Errorf("Ping() with %q error = %v", rawURL, err)
+		}
+		r.Close()
+	}
+
+	r, err := queue.NewRedis("redis://:wrong@"+mr.Addr(), queue.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewRedis() error = %v", err)
+	}
+	defer r.Close()
+	if err := r.Ping(context.Background()); err == nil {
+		t.Error("Ping() with a wrong password error = nil")
+	}
+
+	for _, rawURL := range []string{"http://localhost:6379", "redis://", "redis://[::1", "redis://localhost:6379/db"} {
+		if _, err := queue.NewRedis(rawURL); err == nil {
+			t.Errorf("NewRedis(%q) error = nil, want an invalid URL", rawURL)
+		}
+	}
+}
+
+func TestConsumerOverRedis(t *testing.T) {
+	r, _, clk := newTestRedis(t)
+	ctx := context.Background()
+	fake := testsupport.NewFakeImageGenerationService().
+		On("forbidden", testsupport.Censored()).
+		On("broken", testsupport.Failed("bad prompt")).
+		On("busy", testsupport.SubmitError(domain.ErrProviderUnavailable))
+	ids := enqueue(t, r, "a lighthouse", "forbidden", "broken", "busy")
+
+	cfg := queue.ConsumerConfig{Visibility: visibility, MaxAttempts: 2, RetryBaseDelay: time.Minute, RetryMaxDelay: time.Hour}
+	c := queue.NewConsumer(r, r, fake, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if n, err := c.RunOnce(ctx); err != nil || n != 4 {
+		t.Fatalf("RunOnce() = %d, %v, want 4 jobs handled", n, err)
+	}
+
+	want := map[int]domain.ImageStatus{
+		ids[0]: domain.StatusReadyToPublish,
+		ids[1]: domain.StatusCensored,
+		ids[2]: domain.StatusFailed,
+	}
+	for id, status := range want {
+		res, err := r.GetResult(ctx, id)
+		if err != nil {
+			t.Fatalf("GetResult(%d) error = %v", id, err)
+		}
+		if res == nil || res.Status != status {
+			t.Errorf("GetResult(%d) = %+v, want status %s", id, res, status)
+		}
+	}
+
+	// The transient failure is retried after the delay and fails once its attempts are used up
+	if res, _ := r.GetResult(ctx, ids[3]); res != nil {
+		t.Fatalf("GetResult() of the retried job = %+v, want none yet", res)
+	}
+	if n, err := c.RunOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("RunOnce() during the retry delay = %d, %v, want no jobs", n, err)
+	}
+	clk.Advance(time.Minute)
+	if n, err := c.RunOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("RunOnce() after the retry delay = %d, %v, want 1 job", n, err)
+	}
+	res, err := r.GetResult(ctx, ids[3])
+	if err != nil {
+		t.Fatalf("GetResult() error = %v", err)
+	}
+	if res == nil || res.Status != domain.StatusFailed || !strings.Contains(res.Error, domain.ErrProviderUnavailable.Error()) {
+		t.Errorf("GetResult() of the retried job = %+v, want failed after 2 attempts", res)
+	}
+}
@@ -0,0 +1,106 @@
+// Package rawresponse prepares provider responses for storage: secrets are stripped, bulky
+// strings such as base64 files are dropped and the result is capped in size, while staying
+// valid JSON.
+package rawresponse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces the values of fields that look like secrets
+const Redacted = "[REDACTED]"
+
+// maxStringLength is the length above which a string is dropped as bulk data, e.g. a base64 file
+const maxStringLength = 1024
+
+// secretFields are the substrings of lower-cased field names whose values are redacted
+var secretFields = []string{"key", "secret", "token", "password", "authorization", "credential", "cookie"}
+
+// Sanitize returns body as JSON fit for storage. Fields whose names look like secrets are
+// redacted and strings longer than 1024 bytes are replaced with a note of their size. A body that
+// is not JSON, or still longer than maxBytes, is stored as the string field body of an object,
+// cut to maxBytes and flagged with truncated and the original size; maxBytes of zero means no
+// limit. An empty body returns nil.
+func Sanitize(body []byte, maxBytes int) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		if clean, err := json.Marshal(scrub(v)); err == nil {
+			if maxBytes <= 0 || len(clean) <= maxBytes {
+				return clean
+			}
+			body = clean
+		}
+	}
+	return wrap(body, maxBytes)
+}
+
+// scrub redacts secrets and drops bulky strings from a decoded JSON value
+func scrub(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if isSecret(k) {
+				v[k] = Redacted
+				continue
+			}
+			v[k] = scrub(field)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = scrub(item)
+		}
+		return v
+	case string:
+		if len(v) > maxStringLength {
+			return fmt.Sprintf("[%d bytes omitted]", len(v))
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// isSecret reports whether a field named name holds a secret
+func isSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap stores body as a string, cut to maxBytes on a character boundary when it is longer
+func wrap(body []byte, maxBytes int) []byte {
+	out := struct {
+		Truncated bool   `json:"truncated,omitempty"`
+		Size      int    `json:"size,omitempty"`
+		Body      string `json:"body"`
+	}{Body: string(body)}
+
+	if maxBytes > 0 && len(body) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(body[cut]) {
+			cut--
+		}
+		out.Truncated = true
+		out.Size = len(body)
+		out.Body = string(body[:cut])
+	}
+
+	data, _ := json.Marshal(out)
+	return data
+}
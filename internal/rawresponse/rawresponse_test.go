@@ -0,0 +1,72 @@
+package rawresponse_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/internal/rawresponse"
+)
+
+func TestSanitize(t *testing.T) {
+	long := strings.Repeat("A", 2000)
+	tests := []struct {
+		name     string
+		body     string
+		maxBytes int
+		want     string
+	}{
+		{name: "empty", body: "  \n", maxBytes: 100, want: ""},
+		{name: "unchanged", body: `{"status":"FAIL","errorDescription":"internal error"}`, maxBytes: 100, want: `{"errorDescription":"internal error","status":"FAIL"}`},
+		{name: "secrets redacted", body: `{"X-Key":"k","nested":{"apiSecret":"s","accessToken":"t"},"items":[{"password":"p"}]}`, maxBytes: 0,
+			want: `{"X-Key":"[REDACTED]","items":[{"password":"[REDACTED]"}],"nested":{"accessToken":"[REDACTED]","apiSecret":"[REDACTED]"}}`},
+		{name: "files omitted", body: `{"result":{"files":["` + long + `"]}}`, maxBytes: 0, want: `{"result":{"files":["[2000 bytes omitted]"]}}`},
+		{name: "numbers kept", body: `{"seed":12345678901234567890}`, maxBytes: 0, want: `{"seed":12345678901234567890}`},
+		{name: "not JSON", body: "502 Bad Gateway", maxBytes: 100, want: `{"body":"502 Bad Gateway"}`},
+		{name: "trailing data", body: `{"a":1} {"b":2}`, maxBytes: 100, want: `{"body":"{\"a\":1} {\"b\":2}"}`},
+		{name: "not JSON truncated", body: "Bad Gateway", maxBytes: 3, want: `{"truncated":true,"size":11,"body":"Bad"}`},
+		{name: "JSON truncated", body: `{"errorDescription":"internal error"}`, maxBytes: 10, want: `{"truncated":true,"size":37,"body":"{\"errorDes"}`},
+		// A cut never splits a character: "маяк" takes two bytes a letter
+		{name: "cut on a character boundary", body: "маяк", maxBytes: 3, want: `{"truncated":true,"size":8,"body":"м"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rawresponse.Sanitize([]byte(tt.body), tt.maxBytes)
+			if string(got) != tt.want {
+				t.Errorf("Sanitize(%q, %d) = %s, want %s", tt.body, tt.maxBytes, got, tt.want)
+			}
+			if len(got) > 0 && !json.Valid(got) {
+				t.Errorf("Sanitize(%q, %d) = %s, want valid JSON", tt.body, tt.maxBytes, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeTruncatesOversizedBodies(t *testing.T) {
+	// Many small fields survive the scrub, so only the size limit shortens the body
+	fields := make([]string, 500)
+	for i := range fields {
+		fields[i] = `"detail` + strconv.Itoa(i) + `":"the model reported an error"`
+	}
+	body := "{" + strings.Join(fields, ",") + "}"
+	const maxBytes = 1024
+
+	got := rawresponse.Sanitize([]byte(body), maxBytes)
+	var stored struct {
+		Truncated bool   `json:"truncated"`
+		Size      int    `json:"size"`
+		Body      string `json:"body"`
+	}
+	if err := json.Unmarshal(got, &stored); err != nil {
+		t.Fatalf("Sanitize() = %s, want a JSON object: %v", got, err)
+	}
+	if !stored.Truncated || stored.Size <= maxBytes || len(stored.Body) > maxBytes || !utf8.ValidString(stored.Body) {
+		t.Errorf("Sanitize() = truncated %v, size %d, body of %d bytes, want the body cut to %d bytes",
+			stored.Truncated, stored.Size, len(stored.Body), maxBytes)
+	}
+	if !strings.HasPrefix(stored.Body, `{"detail`) {
+		t.Errorf("Sanitize() body = %.40s..., want the start of the response", stored.Body)
+	}
+}
@@ -0,0 +1,109 @@
+// Package redact masks configured secrets, such as API keys and passwords, in text bound for
+// logs, errors and configuration dumps.
+package redact
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maskedSuffix is the number of trailing characters Mask leaves readable
+const maskedSuffix = 4
+
+// Mask hides a secret, keeping its last four characters so operators can tell which key is
+// configured. Secrets too short to keep anything back, or not valid UTF-8, are hidden entirely.
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	n := utf8.RuneCountInString(secret)
+	if n < 2*maskedSuffix || !utf8.ValidString(secret) {
+		return "****"
+	}
+	runes := []rune(secret)
+	return "****" + string(runes[n-maskedSuffix:])
+}
+
+// Redactor replaces every occurrence of a set of secrets with its Mask. A nil Redactor leaves
+// text unchanged. It is safe for concurrent use.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// New creates a redactor of secrets; empty ones are ignored. It returns nil without secrets.
+func New(secrets ...string) *Redactor {
+	var values []string
+	for _, secret := range secrets {
+		if secret != "" {
+			values = append(values, secret)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// The replacer tries the secrets in order, so a secret containing another one goes first
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, secret := range values {
+		pairs = append(pairs, secret, Mask(secret))
+	}
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// String returns s with its secrets masked
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	// The characters a mask keeps can make up a secret again with the text after it, e.g. in
+	// overlapping or repeated occurrences, so masking goes on until nothing is left to replace.
+	// Every pass that changes s leaves fewer characters outside asterisks, or as many in less
+	// text, so this ends.
+	for {
+		masked := r.replacer.Replace(s)
+		if masked == s {
+			return s
+		}
+		s = masked
+	}
+}
+
+// Bytes returns b with its secrets masked; b itself is not modified
+func (r *Redactor) Bytes(b []byte) []byte {
+	if r == nil || b == nil {
+		return b
+	}
+	return []byte(r.String(string(b)))
+}
+
+// Error returns err with its secrets masked from its message. The original error stays
+// reachable with errors.Is and errors.As.
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	msg := err.Error()
+	masked := r.String(msg)
+	if masked == msg {
+		return err
+	}
+	return &redactedError{msg: masked, err: err}
+}
+
+// redactedError carries the masked message of err
+type redactedError struct {
+	msg string
+	err error
+}
+
+// Error implements the error interface
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the original error
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
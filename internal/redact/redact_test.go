@@ -0,0 +1,108 @@
+package redact_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+)
+
+func TestRedactorString(t *testing.T) {
+	r := redact.New("sk-abcdefghijkl", "sk-abcdefghijkl-extended", "", "short")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "no secrets here", want: "no secrets here"},
+		{in: "key=sk-abcdefghijkl", want: "key=****ijkl"},
+		// The longer secret is masked as a whole rather than leaving its tail readable
+		{in: "key=sk-abcdefghijkl-extended", want: "key=****nded"},
+		{in: "sk-abcdefghijkl and sk-abcdefghijkl", want: "****ijkl and ****ijkl"},
+		{in: "password short", want: "password ****"},
+	}
+	for _, tt := range tests {
+		if got := r.String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := string(r.Bytes([]byte(tt.in))); got != tt.want {
+			t.Errorf("Bytes(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	r := redact.New("", "")
+	if r != nil {
+		t.Fatalf("New() without secrets = %v, want nil", r)
+	}
+	err := errors.New("sk-abcdefghijkl")
+	if r.String("sk-abcdefghijkl") != "sk-abcdefghijkl" || r.Error(err) != err || r.Bytes(nil) != nil {
+		t.Error("nil Redactor changed its input")
+	}
+}
+
+func TestRedactorError(t *testing.T) {
+	r := redact.New("sk-abcdefghijkl")
+	err := fmt.Errorf("failed to authenticate with sk-abcdefghijkl: %w", fs.ErrPermission)
+
+	redacted := r.Error(err)
+	if redacted.Error() != "failed to authenticate with ****ijkl: permission denied" {
+		t.Errorf("Error() = %q, want the key masked", redacted)
+	}
+	if !errors.Is(redacted, fs.ErrPermission) {
+		t.Errorf("Error() = %v, want the original error reachable", redacted)
+	}
+
+	clean := errors.New("connection refused")
+	if r.Error(clean) != clean || r.Error(nil) != nil {
+		t.Error("Error() wrapped an error without secrets")
+	}
+}
+
+func TestRedactorMasksRepeats(t *testing.T) {
+	tests := []struct {
+		secret string
+		in     string
+		want   string
+	}{
+		// Masking the first occurrence would leave the second readable
+		{secret: "abab1234ab", in: "abab1234abab1234ab", want: "****34****34ab"},
+		{secret: "aaaaaaaa", in: "aaaaaaaaaaaa", want: "********aaaa"},
+	}
+	for _, tt := range tests {
+		if got := redact.New(tt.secret).String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func FuzzRedactor(f *testing.F) {
+	f.Add("sk-abcdefghijkl", "sk-abcdefghijkl-extended", "key=", " and more")
+	f.Add("abab1234ab", "", "abab1234", "ab")
+	f.Add("aaaaaaaa", "aaaa", "aaaa", "aaaa")
+	f.Add("pässwörter", "short", "\xff", "wört")
+	f.Fuzz(func(t *testing.T, secret, other, before, after string) {
+		// A secret holding the asterisks of a mask may be its own mask, which is left as it is
+		if strings.Contains(secret, "*") || strings.Contains(other, "*") {
+			t.Skip()
+		}
+		r := redact.New(secret, other)
+		in := before + secret + other + secret + secret + after
+
+		out := r.String(in)
+		for _, s := range []string{secret, other} {
+			if s != "" && strings.Contains(out, s) {
+				t.Fatalf("String(%q) = %q, want %q masked", in, out, s)
+			}
+		}
+		if got := string(r.Bytes([]byte(in))); got != out {
+			t.Fatalf("Bytes(%q) = %q, want %q like String", in, got, out)
+		}
+		if got := r.Error(errors.New(in)); got.Error() != out {
+			t.Fatalf("Error(%q) = %q, want %q like String", in, got, out)
+		}
+	})
+}
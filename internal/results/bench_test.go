@@ -0,0 +1,140 @@
+package results_test
+
+import (
+	"context"
+	"encoding/base64"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+const (
+	// benchFileSize is the decoded size of the files of the benchmarks
+	benchFileSize = 8 << 20
+	// benchConcurrency is how many files are saved at once, like a processor checking that many
+	// generations in parallel
+	benchConcurrency = 10
+)
+
+// largePNG returns a valid PNG padded to benchFileSize, base64 encoded like provider responses
+func largePNG(b *testing.B) string {
+	b.Helper()
+	data, err := base64.StdEncoding.DecodeString(testPNG(b, 64, 64))
+	if err != nil {
+		b.Fatalf("DecodeString() error = %v", err)
+	}
+	padded := make([]byte, benchFileSize)
+	copy(padded, data)
+	return base64.StdEncoding.EncodeToString(padded)
+}
+
+// heapPeak samples the bytes of live heap objects until stop is called, which returns the
+// highest sample above the heap in use when sampling started
+func heapPeak() (stop func() uint64) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	read := func() uint64 {
+		metrics.Read(sample)
+		return sample[0].Value.Uint64()
+	}
+	runtime.GC()
+	base := read()
+	peak := base
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				peak = max(peak, read())
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak - base
+	}
+}
+
+// benchSaves saves benchConcurrency files at once with save per iteration and reports the
+// highest heap growth of an iteration in MB
+func benchSaves(b *testing.B, save func(i int) error) {
+	b.ReportAllocs()
+	var peak uint64
+	for n := 0; n < b.N; n++ {
+		stop := heapPeak()
+		var wg sync.WaitGroup
+		errs := make([]error, benchConcurrency)
+		for i := 0; i < benchConcurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = save(i)
+			}(i)
+		}
+		wg.Wait()
+		peak = max(peak, stop())
+		for _, err := range errs {
+			if err != nil {
+				b.Fatalf("saving error = %v", err)
+			}
+		}
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
+
+// BenchmarkSave compares the memory of saving 10 concurrent 8MB files to filesystem storage
+// by decoding each file whole, as results were saved before, with the streaming Writer.Save
+func BenchmarkSave(b *testing.B) {
+	file := largePNG(b)
+	ctx := context.Background()
+	store, err := storage.NewFileSystem(b.TempDir())
+	if err != nil {
+		b.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	b.Run("buffered", func(b *testing.B) {
+		b.SetBytes(benchConcurrency * benchFileSize)
+		benchSaves(b, func(i int) error {
+			data, err := base64.StdEncoding.DecodeString(file)
+			if err != nil {
+				return err
+			}
+			if _, err := imageproc.Metadata(data); err != nil {
+				return err
+			}
+			_, err = store.Save(ctx, storage.Key("{id}_{uuid}_{index}.png", i, "bench", 0), data)
+			return err
+		})
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		repo := repository.NewMemoryImageRepository()
+		w := results.NewWriter(repo, results.Config{Filename: "{id}_{uuid}_{index}.png"}, results.WithStorage(store))
+		images := make([]int, benchConcurrency)
+		for i := range images {
+			images[i] = newImage(b, repo).ID
+		}
+		b.SetBytes(benchConcurrency * benchFileSize)
+		benchSaves(b, func(i int) error {
+			img, err := repo.GetImage(ctx, images[i])
+			if err != nil {
+				return err
+			}
+			return w.Save(ctx, img, "bench", []string{file})
+		})
+	})
+}
@@ -0,0 +1,330 @@
+// Package results persists the files of finished generations and loads them for publishing.
+package results
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Config holds the settings of a Writer
+type Config struct {
+	// Filename is the STORAGE_FILENAME template of the keys of stored files
+	Filename string
+	// ThumbnailMaxEdge is the longest edge of thumbnails; zero disables them
+	ThumbnailMaxEdge int
+	// KeepOriginal stores post-processed images next to the originals instead of replacing them
+	KeepOriginal bool
+	// LegacyBase64 also stores the base64 data of the first variant in the images row
+	LegacyBase64 bool
+}
+
+// Writer persists the files of finished generations
+type Writer struct {
+	repo      repository.ImageRepository
+	store     storage.Storage      // nil keeps base64 data in the database
+	pipeline  *imageproc.Pipeline  // nil disables post-processing
+	watermark *imageproc.Watermark // nil disables watermarking
+	cfg       Config
+	log       *slog.Logger
+}
+
+// Option configures optional Writer settings
+type Option func(*Writer)
+
+// WithStorage writes decoded files to store and keeps only their locations in the database
+func WithStorage(store storage.Storage) Option {
+	return func(w *Writer) {
+		w.store = store
+	}
+}
+
+// WithPipeline post-processes files before they are stored
+func WithPipeline(pipeline *imageproc.Pipeline) Option {
+	return func(w *Writer) {
+		w.pipeline = pipeline
+	}
+}
+
+// WithWatermark stamps watermark on files after post-processing, unless an image skips it
+func WithWatermark(watermark *imageproc.Watermark) Option {
+	return func(w *Writer) {
+		w.watermark = watermark
+	}
+}
+
+// WithLogger sets the logger of errors that do not fail a save
+func WithLogger(logger *slog.Logger) Option {
+	return func(w *Writer) {
+		w.log = logger
+	}
+}
+
+// NewWriter creates a writer saving results through repo. Without options the files are kept
+// base64 encoded in the database as they are.
+func NewWriter(repo repository.ImageRepository, cfg Config, opts ...Option) *Writer {
+	w := &Writer{repo: repo, cfg: cfg, log: slog.Default()}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Save persists the base64 files of a finished generation of img as its variants. With a storage
+// backend the decoded files are written there and only their locations are kept in the database.
+// Files that are not post-processed are decoded as streams, so they are never held in memory
+// decoded as well.
+func (w *Writer) Save(ctx context.Context, img *domain.Image, uuid string, files []string) error {
+	variants := make([]domain.ImageVariant, 0, len(files))
+	var first []byte
+	var firstMeta domain.ImageMetadata
+	var firstMetaErr error
+	for i, file := range files {
+		v, data, err := w.storeFile(ctx, img, uuid, i, file)
+		if err != nil {
+			return fmt.Errorf("failed to store file %d: %w", i, err)
+		}
+		var meta domain.ImageMetadata
+		var metaErr error
+		if data != nil {
+			meta, metaErr = imageproc.Metadata(data)
+		} else if meta, metaErr = imageproc.MetadataFrom(decodeFile(file)); isCorrupt(metaErr) {
+			return fmt.Errorf("failed to decode file %d: %w", i, metaErr)
+		}
+		if metaErr == nil {
+			v.Width, v.Height = meta.Width, meta.Height
+		}
+		if i == 0 {
+			first, firstMeta, firstMetaErr = data, meta, metaErr
+		}
+		variants = append(variants, v)
+	}
+
+	if err := SaveVariants(ctx, w.repo, img.ID, variants, w.cfg.LegacyBase64); err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	w.saveMetadata(ctx, img, firstMeta, firstMetaErr)
+
+	if w.cfg.ThumbnailMaxEdge > 0 {
+		// Thumbnails need the whole image decoded
+		if first == nil {
+			var err error
+			if first, err = base64.StdEncoding.DecodeString(files[0]); err != nil {
+				return fmt.Errorf("failed to decode file 0: %w", err)
+			}
+		}
+		if err := w.saveThumbnail(ctx, img, uuid, first); err != nil {
+			return err
+		}
+	}
+
+	// Storages such as S3 can hand out a download URL for the first file
+	if urler, ok := w.store.(storage.URLer); ok {
+		url, err := urler.URL(ctx, variants[0].FilePath)
+		if err != nil {
+			return fmt.Errorf("failed to get file URL: %w", err)
+		}
+		if url != "" {
+			if err := w.repo.UpdateFileURL(ctx, img.ID, url); err != nil {
+				return fmt.Errorf("failed to save file URL: %w", err)
+			}
+			img.FileURL = url
+		}
+	}
+
+	return nil
+}
+
+// storeFile post-processes a single base64 file and stores it inline or in the storage backend
+// as a variant of img. It also returns the stored image decoded, which is nil when the file was
+// not post-processed.
+func (w *Writer) storeFile(ctx context.Context, img *domain.Image, uuid string, index int, file string) (domain.ImageVariant, []byte, error) {
+	res := domain.ImageVariant{Index: index, Provider: img.Provider}
+	pipeline := w.pipelineFor(img)
+	key := storage.Key(w.cfg.Filename, img.ID, uuid, index)
+	if pipeline == nil {
+		if w.store == nil {
+			res.Data = file
+			return res, nil, nil
+		}
+		var err error
+		if res.FilePath, err = w.store.SaveStream(ctx, key, decodeFile(file)); isCorrupt(err) {
+			return res, nil, fmt.Errorf("failed to decode: %w", err)
+		}
+		return res, nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(file)
+	if err != nil {
+		return res, nil, fmt.Errorf("failed to decode: %w", err)
+	}
+
+	if pipeline != nil {
+		processed, err := pipeline.Run(data)
+		if err != nil {
+			return res, nil, fmt.Errorf("failed to post-process: %w", err)
+		}
+
+		// Watermarking alone always replaces the original, which must not be published unstamped
+		if w.cfg.KeepOriginal && w.pipeline != nil {
+			processedKey := replaceExt(key, "-processed"+pipeline.Ext())
+			if res.ProcessedPath, err = w.store.Save(ctx, processedKey, processed); err != nil {
+				return res, nil, err
+			}
+		} else {
+			data = processed
+			key = replaceExt(key, pipeline.Ext())
+		}
+	}
+
+	if w.store == nil {
+		res.Data = base64.StdEncoding.EncodeToString(data)
+		return res, data, nil
+	}
+
+	res.FilePath, err = w.store.Save(ctx, key, data)
+	return res, data, err
+}
+
+// saveMetadata records the metadata of the first file of a generation, read with the error
+// readErr, along with the generation time set on img. Failures are only logged since the image
+// itself has already been saved.
+func (w *Writer) saveMetadata(ctx context.Context, img *domain.Image, meta domain.ImageMetadata, readErr error) {
+	if readErr != nil {
+		w.log.Warn("Error reading metadata", "image_id", img.ID, "error", readErr)
+	}
+	meta.GenerationDuration = img.Metadata.GenerationDuration
+
+	if err := w.repo.UpdateMetadata(ctx, img.ID, meta); err != nil {
+		w.log.Error("Error saving metadata", "image_id", img.ID, "error", err)
+	}
+}
+
+// saveThumbnail stores a small JPEG of the first file of a generation, given decoded as data
+func (w *Writer) saveThumbnail(ctx context.Context, img *domain.Image, uuid string, data []byte) error {
+	thumb, err := imageproc.Thumbnail(data, w.cfg.ThumbnailMaxEdge)
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+
+	if w.store == nil {
+		if err := w.repo.UpdateThumbnail(ctx, img.ID, base64.StdEncoding.EncodeToString(thumb)); err != nil {
+			return fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+		return nil
+	}
+
+	key := replaceExt(storage.Key(w.cfg.Filename, img.ID, uuid, 0), "-thumb.jpg")
+	path, err := w.store.Save(ctx, key, thumb)
+	if err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+	if err := w.repo.UpdateThumbnailPath(ctx, img.ID, path); err != nil {
+		return fmt.Errorf("failed to save thumbnail path: %w", err)
+	}
+	return nil
+}
+
+// Load returns the selected variant of an image decoded, preferring its post-processed copy.
+// Without the selected variant the first one is used.
+func (w *Writer) Load(ctx context.Context, img *domain.Image) ([]byte, error) {
+	selected, err := w.repo.GetResult(ctx, img.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load variant: %w", err)
+	}
+
+	// Images generated before image_results existed only have the base64 column
+	if selected == nil {
+		data, err := w.repo.GetImageData(ctx, img.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load image data: %w", err)
+		}
+		if data == "" {
+			return nil, fmt.Errorf("image %d has no stored result", img.ID)
+		}
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	location := selected.ProcessedPath
+	if location == "" {
+		location = selected.FilePath
+	}
+	if location == "" {
+		return base64.StdEncoding.DecodeString(selected.Data)
+	}
+	if w.store == nil {
+		return nil, fmt.Errorf("image %d is stored at %s but no storage is configured", img.ID, location)
+	}
+	return w.store.Load(ctx, location)
+}
+
+// pipelineFor returns the post-processing pipeline for img with the watermark stamped last,
+// or nil when the image needs no processing
+func (w *Writer) pipelineFor(img *domain.Image) *imageproc.Pipeline {
+	if w.watermark == nil || img.SkipWatermark {
+		return w.pipeline
+	}
+
+	if w.pipeline == nil {
+		return &imageproc.Pipeline{Steps: []imageproc.Step{w.watermark}, Format: imageproc.FormatPNG}
+	}
+	p := *w.pipeline
+	p.Steps = append(append([]imageproc.Step(nil), w.pipeline.Steps...), w.watermark)
+	return &p
+}
+
+// decodeFile returns a reader decoding a base64 file as it is read
+func decodeFile(file string) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(file))
+}
+
+// isCorrupt reports whether err comes from a file that is not valid base64
+func isCorrupt(err error) bool {
+	var corrupt base64.CorruptInputError
+	return errors.As(err, &corrupt)
+}
+
+// replaceExt replaces the extension of key with ext
+func replaceExt(key, ext string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ext
+}
+
+// SaveVariants stores every variant of an image and mirrors the first one into the images row:
+// its file path, or its base64 data when legacyBase64 is set
+func SaveVariants(ctx context.Context, repo repository.ImageRepository, id int, variants []domain.ImageVariant, legacyBase64 bool) error {
+	if err := repo.SaveVariants(ctx, id, variants); err != nil {
+		return fmt.Errorf("failed to save variants: %w", err)
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	first := variants[0]
+	if first.FilePath != "" {
+		if err := repo.UpdateFilePath(ctx, id, first.FilePath); err != nil {
+			return fmt.Errorf("failed to save file path: %w", err)
+		}
+	} else if legacyBase64 {
+		if err := repo.UpdateBase64(ctx, id, first.Data); err != nil {
+			return fmt.Errorf("failed to save base64: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package results_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/imageproc"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/internal/storage"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// red is the color of the first pixel of testPNG
+var red = color.NRGBA{R: 255, A: 255}
+
+// testPNG returns a PNG of the given size as base64, red at the origin and blue elsewhere
+func testPNG(t testing.TB, width, height int) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+	img.Set(0, 0, red)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// newImage creates an image in repo and returns it
+func newImage(t testing.TB, repo repository.ImageRepository) *domain.Image {
+	t.Helper()
+	id, err := repo.CreateImage(context.Background(), "a lighthouse")
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	img, err := repo.GetImage(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+	return img
+}
+
+// decodeStored decodes the PNG stored for the first variant of img
+func decodeStored(t *testing.T, repo repository.ImageRepository, img *domain.Image) (string, image.Image) {
+	t.Helper()
+	variant, err := repo.GetResult(context.Background(), img.ID)
+	if err != nil || variant == nil || variant.FilePath == "" {
+		t.Fatalf("GetResult() = %+v, %v, want a variant stored as a file", variant, err)
+	}
+	f, err := os.Open(variant.FilePath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("png.Decode(%s) error = %v", variant.FilePath, err)
+	}
+	return variant.FilePath, decoded
+}
+
+func TestWriterStoresDecodedPNG(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fs, err := storage.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	w := results.NewWriter(repo, results.Config{Filename: "{uuid}_{index}.png"}, results.WithStorage(fs))
+
+	// Two images sharing a generation, as duplicates do, get a file each under the same name
+	first, second := newImage(t, repo), newImage(t, repo)
+	file := testPNG(t, 4, 3)
+	for _, img := range []*domain.Image{first, second} {
+		if err := w.Save(context.Background(), img, "uuid", []string{file}); err != nil {
+			t.Fatalf("Save(%d) error = %v", img.ID, err)
+		}
+	}
+
+	firstPath, firstPNG := decodeStored(t, repo, first)
+	secondPath, secondPNG := decodeStored(t, repo, second)
+	if firstPath == secondPath {
+		t.Errorf("both images are stored at %s", firstPath)
+	}
+	for _, decoded := range []image.Image{firstPNG, secondPNG} {
+		if b := decoded.Bounds(); b.Dx() != 4 || b.Dy() != 3 {
+			t.Errorf("stored PNG is %dx%d, want 4x3", b.Dx(), b.Dy())
+		}
+		if got := color.NRGBAModel.Convert(decoded.At(0, 0)); got != red {
+			t.Errorf("stored PNG has %v at the origin, want %v", got, red)
+		}
+	}
+	if img, _ := repo.GetImage(context.Background(), first.ID); img.Metadata.Width != 4 || img.Metadata.Format != "png" {
+		t.Errorf("metadata = %+v, want the 4x3 PNG recorded", img.Metadata)
+	}
+}
+
+func TestWriterRejectsCorruptFiles(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	root := t.TempDir()
+	fs, err := storage.NewFileSystem(root)
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	w := results.NewWriter(repo, results.Config{Filename: "{id}_{uuid}_{index}.png"}, results.WithStorage(fs))
+
+	img := newImage(t, repo)
+	if err := w.Save(context.Background(), img, "uuid", []string{"not base64!"}); err == nil {
+		t.Fatal("Save() of a corrupt file succeeded")
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("storage directory holds %d entries after a corrupt file, want none", len(entries))
+	}
+	if variant, _ := repo.GetResult(context.Background(), img.ID); variant != nil {
+		t.Errorf("GetResult() = %+v, want no variant saved", variant)
+	}
+}
+
+func TestWriterWatermarksUnlessSkipped(t *testing.T) {
+	mark := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 4; i++ {
+		mark.Set(i%2, i/2, red)
+	}
+	watermark := &imageproc.Watermark{Mark: mark, Position: imageproc.BottomRight, Opacity: 1}
+
+	for _, skip := range []bool{false, true} {
+		repo := repository.NewMemoryImageRepository()
+		fs, err := storage.NewFileSystem(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFileSystem() error = %v", err)
+		}
+		w := results.NewWriter(repo, results.Config{Filename: "{id}_{uuid}_{index}.png"}, results.WithStorage(fs), results.WithWatermark(watermark))
+
+		img := newImage(t, repo)
+		img.SkipWatermark = skip
+		if err := w.Save(context.Background(), img, "uuid", []string{testPNG(t, 4, 3)}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		_, decoded := decodeStored(t, repo, img)
+		want := color.NRGBA{B: 255, A: 255}
+		if !skip {
+			want = red
+		}
+		if got := color.NRGBAModel.Convert(decoded.At(3, 2)); got != want {
+			t.Errorf("with SkipWatermark %v the bottom right pixel is %v, want %v", skip, got, want)
+		}
+	}
+}
+
+func TestWriterStoresThumbnails(t *testing.T) {
+	for _, withStorage := range []bool{false, true} {
+		repo := repository.NewMemoryImageRepository()
+		var opts []results.Option
+		if withStorage {
+			fs, err := storage.NewFileSystem(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFileSystem() error = %v", err)
+			}
+			opts = append(opts, results.WithStorage(fs))
+		}
+		w := results.NewWriter(repo, results.Config{Filename: "{id}_{uuid}_{index}.png", ThumbnailMaxEdge: 16}, opts...)
+
+		img := newImage(t, repo)
+		if err := w.Save(context.Background(), img, "uuid", []string{testPNG(t, 64, 32)}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		thumb, path, err := repo.GetThumbnail(context.Background(), img.ID)
+		if err != nil {
+			t.Fatalf("GetThumbnail() error = %v", err)
+		}
+		if withStorage {
+			if !strings.HasSuffix(path, "-thumb.jpg") || thumb != nil {
+				t.Fatalf("GetThumbnail() = %d bytes at %q, want only a -thumb.jpg location", len(thumb), path)
+			}
+			if thumb, err = os.ReadFile(path); err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+		} else if path != "" {
+			t.Fatalf("GetThumbnail() location = %q, want the thumbnail in the database", path)
+		}
+
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatalf("DecodeConfig() error = %v", err)
+		}
+		if cfg.Width != 16 || cfg.Height != 8 || format != "jpeg" {
+			t.Errorf("thumbnail with storage %v is a %dx%d %s, want a 16x8 jpeg", withStorage, cfg.Width, cfg.Height, format)
+		}
+	}
+}
+
+func TestWriterRecordsMetadata(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 30, 20)), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	pngData, _ := base64.StdEncoding.DecodeString(testPNG(t, 8, 6))
+
+	tests := []struct {
+		name string
+		data []byte
+		want domain.ImageMetadata
+	}{
+		{name: "png", data: pngData, want: domain.ImageMetadata{Width: 8, Height: 6, ByteSize: len(pngData), Format: "png"}},
+		{name: "jpeg", data: jpg.Bytes(), want: domain.ImageMetadata{Width: 30, Height: 20, ByteSize: jpg.Len(), Format: "jpeg"}},
+		// Undecodable files are still saved, with only their size recorded
+		{name: "not an image", data: []byte("not an image"), want: domain.ImageMetadata{ByteSize: 12}},
+	}
+	for _, tt := range tests {
+		for _, withStorage := range []bool{false, true} {
+			repo := repository.NewMemoryImageRepository()
+			var opts []results.Option
+			if withStorage {
+				fs, err := storage.NewFileSystem(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewFileSystem() error = %v", err)
+				}
+				opts = append(opts, results.WithStorage(fs))
+			}
+			w := results.NewWriter(repo, results.Config{Filename: "{id}_{uuid}_{index}.png"}, opts...)
+
+			img := newImage(t, repo)
+			img.Metadata.GenerationDuration = 3 * time.Second
+			if err := w.Save(context.Background(), img, "uuid", []string{base64.StdEncoding.EncodeToString(tt.data)}); err != nil {
+				t.Fatalf("%s: Save() error = %v", tt.name, err)
+			}
+			if variant, err := repo.GetResult(context.Background(), img.ID); err != nil || variant == nil {
+				t.Errorf("%s: GetResult() = %v, %v, want the file saved", tt.name, variant, err)
+			}
+
+			want := tt.want
+			want.GenerationDuration = 3 * time.Second
+			if saved, _ := repo.GetImage(context.Background(), img.ID); saved.Metadata != want {
+				t.Errorf("%s with storage %v: metadata = %+v, want %+v", tt.name, withStorage, saved.Metadata, want)
+			}
+		}
+	}
+}
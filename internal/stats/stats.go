@@ -0,0 +1,95 @@
+// Package stats periodically logs the database connection pool and Go runtime statistics, which
+// tell a pipeline starved for connections from one waiting on its provider.
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// Snapshot is a single reading of the pool and runtime statistics
+type Snapshot struct {
+	Pool sql.DBStats
+	// Goroutines is the number of goroutines, including the ones blocked on the pool
+	Goroutines int
+	// HeapAlloc and HeapObjects are the bytes and number of allocated heap objects
+	HeapAlloc   uint64
+	HeapObjects uint64
+}
+
+// Take reads the statistics of db and the runtime
+func Take(db *sql.DB) Snapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return Snapshot{
+		Pool:        db.Stats(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapObjects: mem.HeapObjects,
+	}
+}
+
+// Reporter logs a Snapshot at a fixed interval
+type Reporter struct {
+	db       *sql.DB
+	interval time.Duration
+	log      *slog.Logger
+}
+
+// Option configures a Reporter
+type Option func(*Reporter)
+
+// WithLogger sets the logger the snapshots are written to
+func WithLogger(log *slog.Logger) Option {
+	return func(r *Reporter) {
+		r.log = log
+	}
+}
+
+// NewReporter creates a reporter of the pool of db taking a snapshot every interval
+func NewReporter(db *sql.DB, interval time.Duration, opts ...Option) *Reporter {
+	r := &Reporter{
+		db:       db,
+		interval: interval,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run logs a snapshot every interval until ctx is cancelled
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Report()
+		}
+	}
+}
+
+// Report takes a snapshot and logs it
+func (r *Reporter) Report() Snapshot {
+	s := Take(r.db)
+	r.log.Info("Pool and runtime stats",
+		"open", s.Pool.OpenConnections,
+		"in_use", s.Pool.InUse,
+		"idle", s.Pool.Idle,
+		"max_open", s.Pool.MaxOpenConnections,
+		"wait_count", s.Pool.WaitCount,
+		"wait_duration", s.Pool.WaitDuration,
+		"goroutines", s.Goroutines,
+		"heap_alloc_bytes", s.HeapAlloc,
+		"heap_objects", s.HeapObjects,
+	)
+	return s
+}
@@ -0,0 +1,121 @@
+package stats_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/stats"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+)
+
+// logBuffer collects log lines written concurrently
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the JSON log records written so far
+func (b *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestReportUnderPoolContention(t *testing.T) {
+	db := testsupport.OpenFakeDB(t)
+	db.SetMaxOpenConns(1)
+	var logs logBuffer
+	r := stats.NewReporter(db, time.Minute, stats.WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	idle := r.Report()
+	if idle.Pool.InUse != 0 || idle.Pool.WaitCount != 0 || idle.Goroutines == 0 || idle.HeapAlloc == 0 || idle.HeapObjects == 0 {
+		t.Errorf("Report() of an idle pool = %+v, want nothing in use and the runtime stats", idle)
+	}
+
+	// While the only connection is taken, another caller waits for it
+	release := testsupport.ContendPool(t, db)
+	busy := r.Report()
+	release()
+	if busy.Pool.MaxOpenConnections != 1 || busy.Pool.OpenConnections != 1 || busy.Pool.InUse != 1 || busy.Pool.WaitCount != 1 {
+		t.Errorf("Report() during contention = %+v, want the connection in use and a wait", busy.Pool)
+	}
+
+	after := r.Report()
+	if after.Pool.InUse != 0 || after.Pool.Idle != 1 || after.Pool.WaitCount != 1 || after.Pool.WaitDuration <= 0 {
+		t.Errorf("Report() after contention = %+v, want the connection idle and the wait counted", after.Pool)
+	}
+
+	records := logs.records(t)
+	if len(records) != 3 {
+		t.Fatalf("logged %d records, want one per report", len(records))
+	}
+	want := map[string]any{"msg": "Pool and runtime stats", "open": 1.0, "in_use": 1.0, "idle": 0.0, "max_open": 1.0, "wait_count": 1.0}
+	for key, value := range want {
+		if records[1][key] != value {
+			t.Errorf("logged %s = %v, want %v", key, records[1][key], value)
+		}
+	}
+	for _, key := range []string{"wait_duration", "goroutines", "heap_alloc_bytes", "heap_objects"} {
+		if _, ok := records[1][key]; !ok {
+			t.Errorf("logged record has no %s: %v", key, records[1])
+		}
+	}
+}
+
+func TestRunReportsEveryInterval(t *testing.T) {
+	db := testsupport.OpenFakeDB(t)
+	var logs logBuffer
+	r := stats.NewReporter(db, 5*time.Millisecond, stats.WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(logs.records(t)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Run() logged %d reports in 5s, want one every 5ms", len(logs.records(t)))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after ctx was cancelled")
+	}
+	// Nothing is reported once Run returned
+	n := len(logs.records(t))
+	time.Sleep(20 * time.Millisecond)
+	if got := len(logs.records(t)); got != n {
+		t.Errorf("Run() logged %d reports after returning", got-n)
+	}
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCollisions bounds how many numbered alternatives Save tries for a taken filename
+const maxCollisions = 1000
+
+// FileSystem stores images as files below a root directory
+type FileSystem struct {
+	root string
+}
+
+// NewFileSystem creates a filesystem storage rooted at dir, creating it if needed
+func NewFileSystem(dir string) (*FileSystem, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage directory: %w", err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileSystem{root: root}, nil
+}
+
+// Save writes data to key below the root. Existing files are never overwritten: a taken
+// name gets a numeric suffix, e.g. 1_uuid_0-1.png, and the path actually written is returned.
+func (f *FileSystem) Save(ctx context.Context, key string, data []byte) (string, error) {
+	return f.SaveStream(ctx, key, bytes.NewReader(data))
+}
+
+// SaveStream copies r to key below the root like Save. A file that cannot be written in full,
+// e.g. because reading r fails, is removed again.
+func (f *FileSystem) SaveStream(ctx context.Context, key string, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(f.root, filepath.FromSlash(key))
+	if !f.contains(path) {
+		return "", fmt.Errorf("storage key %q escapes the storage directory", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 0; i < maxCollisions; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+
+		file, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create file: %w", err)
+		}
+
+		_, err = io.Copy(file, r)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(candidate)
+			return "", fmt.Errorf("failed to write file: %w", err)
+		}
+		return candidate, nil
+	}
+
+	return "", fmt.Errorf("failed to find a free filename for %q", key)
+}
+
+// Load reads the file at location
+func (f *FileSystem) Load(ctx context.Context, location string) ([]byte, error) {
+	if !f.contains(location) {
+		return nil, fmt.Errorf("location %q is outside the storage directory", location)
+	}
+	return os.ReadFile(location)
+}
+
+// Delete removes the file at location; deleting a missing file is not an error
+func (f *FileSystem) Delete(ctx context.Context, location string) error {
+	if !f.contains(location) {
+		return fmt.Errorf("location %q is outside the storage directory", location)
+	}
+	if err := os.Remove(location); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// contains reports whether path lies below the root directory
+func (f *FileSystem) contains(path string) bool {
+	rel, err := filepath.Rel(f.root, filepath.Clean(path))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,132 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/storage"
+)
+
+// newFileSystem returns a filesystem storage rooted at a new temporary directory
+func newFileSystem(t *testing.T) (*storage.FileSystem, string) {
+	t.Helper()
+	root := t.TempDir()
+	fs, err := storage.NewFileSystem(root)
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	return fs, root
+}
+
+func TestFileSystemRoundTrip(t *testing.T) {
+	fs, root := newFileSystem(t)
+	ctx := context.Background()
+
+	location, err := fs.Save(ctx, "images/1_uuid_0.png", []byte("data"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if want := filepath.Join(root, "images", "1_uuid_0.png"); location != want {
+		t.Errorf("Save() = %q, want %q", location, want)
+	}
+
+	data, err := fs.Load(ctx, location)
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Load() = %q, %v, want the saved data", data, err)
+	}
+
+	if err := fs.Delete(ctx, location); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := os.Stat(location); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still exists after Delete(), stat error = %v", err)
+	}
+	// Deleting again is not an error
+	if err := fs.Delete(ctx, location); err != nil {
+		t.Errorf("Delete() of a missing file error = %v", err)
+	}
+}
+
+func TestFileSystemCollisions(t *testing.T) {
+	fs, root := newFileSystem(t)
+	ctx := context.Background()
+
+	// A taken name gets a numeric suffix, and no file is overwritten
+	want := []string{"1_uuid_0.png", "1_uuid_0-1.png", "1_uuid_0-2.png"}
+	for i, name := range want {
+		location, err := fs.Save(ctx, "1_uuid_0.png", []byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Save() #%d error = %v", i+1, err)
+		}
+		if location != filepath.Join(root, name) {
+			t.Errorf("Save() #%d = %q, want %s", i+1, location, name)
+		}
+	}
+	for i, name := range want {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || !bytes.Equal(data, []byte{byte(i)}) {
+			t.Errorf("%s = %v, %v, want the data of save #%d", name, data, err, i+1)
+		}
+	}
+}
+
+func TestFileSystemStaysInRoot(t *testing.T) {
+	fs, root := newFileSystem(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"../escape.png", "a/../../escape.png", ".."} {
+		if location, err := fs.Save(ctx, key, []byte("data")); err == nil {
+			t.Errorf("Save(%q) = %q, want an error", key, location)
+		}
+	}
+	outside := filepath.Join(filepath.Dir(root), "outside.png")
+	if _, err := fs.Load(ctx, outside); err == nil {
+		t.Errorf("Load(%q) succeeded outside the root", outside)
+	}
+	if err := fs.Delete(ctx, outside); err == nil {
+		t.Errorf("Delete(%q) succeeded outside the root", outside)
+	}
+}
+
+// failingReader returns some data and then an error
+type failingReader struct{ read bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+func TestFileSystemRemovesPartialFiles(t *testing.T) {
+	fs, root := newFileSystem(t)
+
+	if _, err := fs.SaveStream(context.Background(), "1_uuid_0.png", &failingReader{}); err == nil {
+		t.Fatal("SaveStream() succeeded with a failing reader")
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("storage directory holds %d entries after a failed save, want none", len(entries))
+	}
+}
+
+func TestFileSystemRespectsCancellation(t *testing.T) {
+	fs, root := newFileSystem(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := fs.Save(ctx, "1_uuid_0.png", []byte("data")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Save() error = %v, want %v", err, context.Canceled)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("storage directory holds %d entries, want none", len(entries))
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3MaxAttempts is how often the SDK retries requests that fail with transient errors
+const s3MaxAttempts = 5
+
+// S3Options configures an S3 storage
+type S3Options struct {
+	Bucket          string
+	Prefix          string
+	Region          string
+	Endpoint        string // custom endpoint, e.g. a MinIO server
+	AccessKeyID     string // static credentials; the default AWS chain is used when empty
+	SecretAccessKey string
+	UsePathStyle    bool
+	PresignTTL      time.Duration // lifetime of URLs returned by URL; zero disables presigning
+	CacheControl    string        // Cache-Control header stored with every object, if set
+}
+
+// S3 stores images as objects in an S3 compatible bucket
+type S3 struct {
+	client       *s3.Client
+	presigner    *s3.PresignClient
+	bucket       string
+	prefix       string
+	presignTTL   time.Duration
+	cacheControl string
+}
+
+// NewS3 creates an S3 storage; requests are retried on transient errors
+func NewS3(ctx context.Context, opts S3Options) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	loadOpts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(opts.Region),
+		awsconfig.WithRetryMaxAttempts(s3MaxAttempts),
+	}
+	if opts.AccessKeyID != "" {
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.UsePathStyle
+	})
+
+	return &S3{
+		client:       client,
+		presigner:    s3.NewPresignClient(client),
+		bucket:       opts.Bucket,
+		prefix:       strings.Trim(opts.Prefix, "/"),
+		presignTTL:   opts.PresignTTL,
+		cacheControl: opts.CacheControl,
+	}, nil
+}
+
+// Save uploads data under the prefixed key and returns the object key
+func (s *S3) Save(ctx context.Context, key string, data []byte) (string, error) {
+	return s.put(ctx, key, bytes.NewReader(data))
+}
+
+// SaveStream uploads the data read from r like Save. The upload needs its length up front, so
+// r is spooled to a temporary file first rather than to memory.
+func (s *S3) SaveStream(ctx context.Context, key string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "2xiang-upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return "", fmt.Errorf("failed to spool upload: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to spool upload: %w", err)
+	}
+	return s.put(ctx, key, tmp)
+}
+
+// put uploads body under the prefixed key and returns the object key
+func (s *S3) put(ctx context.Context, key string, body io.ReadSeeker) (string, error) {
+	objectKey := path.Join(s.prefix, key)
+
+	contentType := mime.TypeByExtension(path.Ext(objectKey))
+	if contentType == "" {
+		contentType = "image/png"
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(objectKey),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if s.cacheControl != "" {
+		input.CacheControl = aws.String(s.cacheControl)
+	}
+	_, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload object: %w", err)
+	}
+
+	return objectKey, nil
+}
+
+// Load downloads the object stored under location
+func (s *S3) Load(ctx context.Context, location string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(location),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object: %w", err)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// Delete removes the object stored under location
+func (s *S3) Delete(ctx context.Context, location string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(location),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// URL returns a presigned GET URL for location, or an empty string when presigning is disabled
+func (s *S3) URL(ctx context.Context, location string) (string, error) {
+	if s.presignTTL <= 0 {
+		return "", nil
+	}
+
+	req, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(location),
+	}, s3.WithPresignExpires(s.presignTTL))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object URL: %w", err)
+	}
+	return req.URL, nil
+}
@@ -0,0 +1,64 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/storage"
+)
+
+// TestS3MinIO runs against the S3-compatible server at TEST_S3_ENDPOINT, e.g. MinIO, with an
+// existing TEST_S3_BUCKET and the TEST_S3_ACCESS_KEY_ID and TEST_S3_SECRET_ACCESS_KEY credentials
+func TestS3MinIO(t *testing.T) {
+	endpoint, bucket := os.Getenv("TEST_S3_ENDPOINT"), os.Getenv("TEST_S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		t.Skip("TEST_S3_ENDPOINT and TEST_S3_BUCKET are not set")
+	}
+	ctx := context.Background()
+	s, err := storage.NewS3(ctx, storage.S3Options{
+		Bucket:          bucket,
+		Prefix:          "integration-" + time.Now().Format("20060102150405"),
+		Region:          "us-east-1",
+		Endpoint:        endpoint,
+		AccessKeyID:     os.Getenv("TEST_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("TEST_S3_SECRET_ACCESS_KEY"),
+		UsePathStyle:    true,
+		PresignTTL:      time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+
+	location, err := s.Save(ctx, "1_uuid_0.png", []byte("data"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.Delete(context.Background(), location); err != nil {
+			t.Errorf("Delete() error = %v", err)
+		}
+	})
+	if data, err := s.Load(ctx, location); err != nil || string(data) != "data" {
+		t.Fatalf("Load() = %q, %v, want the saved data", data, err)
+	}
+
+	url, err := s.URL(ctx, location)
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET presigned URL error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "data" || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("GET presigned URL = %d %s %q, want the PNG object", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+}
@@ -0,0 +1,265 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/storage"
+)
+
+// fakeObject is an object stored by fakeS3
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	cacheControl string
+}
+
+// fakeS3 implements the path-style object requests of the S3 API that S3 sends
+type fakeS3 struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	puts     int
+	failPuts []int // statuses answered to the next PUT requests
+	hang     bool  // hang blocks every request until it is cancelled
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string]fakeObject)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests are signed in the header, presigned URLs in the query
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") &&
+		!strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), "access/") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	f.mu.Lock()
+	if f.hang {
+		f.mu.Unlock()
+		// The request context is only cancelled once the body is read
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		return
+	}
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		f.puts++
+		if len(f.failPuts) > 0 {
+			status := f.failPuts[0]
+			f.failPuts = f.failPuts[1:]
+			s3Error(w, status, http.StatusText(status))
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type"), cacheControl: r.Header.Get("Cache-Control")}
+	case http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Write(obj.data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// s3Error writes an error response in the XML shape of S3
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+// object returns the object stored under key, the path of a path-style request
+func (f *fakeS3) object(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return obj, ok
+}
+
+// putRequests returns how many PUT requests the server received
+func (f *fakeS3) putRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.puts
+}
+
+// newS3 returns an S3 storage of bucket images with prefix generated on srv
+func newS3(t *testing.T, srv *fakeS3, presignTTL time.Duration) *storage.S3 {
+	t.Helper()
+	s, err := storage.NewS3(context.Background(), storage.S3Options{
+		Bucket:          "images",
+		Prefix:          "/generated/",
+		Region:          "us-east-1",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		UsePathStyle:    true,
+		PresignTTL:      presignTTL,
+		CacheControl:    "public, max-age=60",
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	return s
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	tests := []struct {
+		key             string
+		wantContentType string
+	}{
+		{key: "1_uuid_0.png", wantContentType: "image/png"},
+		{key: "1_uuid_0-thumb.jpg", wantContentType: "image/jpeg"},
+		{key: "1_uuid_0", wantContentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			srv := newFakeS3(t)
+			s := newS3(t, srv, 0)
+			ctx := context.Background()
+
+			location, err := s.Save(ctx, tt.key, []byte("data"))
+			if err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if want := "generated/" + tt.key; location != want {
+				t.Errorf("Save() = %q, want %q", location, want)
+			}
+			obj, ok := srv.object("images/" + location)
+			if !ok {
+				t.Fatalf("no object uploaded to images/%s", location)
+			}
+			if obj.contentType != tt.wantContentType || obj.cacheControl != "public, max-age=60" {
+				t.Errorf("object has Content-Type %q and Cache-Control %q, want %q and the configured one", obj.contentType, obj.cacheControl, tt.wantContentType)
+			}
+
+			data, err := s.Load(ctx, location)
+			if err != nil || string(data) != "data" {
+				t.Fatalf("Load() = %q, %v, want the saved data", data, err)
+			}
+			if err := s.Delete(ctx, location); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, ok := srv.object("images/" + location); ok {
+				t.Error("object still exists after Delete()")
+			}
+		})
+	}
+}
+
+func TestS3SaveStream(t *testing.T) {
+	srv := newFakeS3(t)
+	location, err := newS3(t, srv, 0).SaveStream(context.Background(), "1_uuid_0.png", strings.NewReader("streamed"))
+	if err != nil {
+		t.Fatalf("SaveStream() error = %v", err)
+	}
+	if obj, _ := srv.object("images/" + location); string(obj.data) != "streamed" {
+		t.Errorf("object holds %q, want the streamed data", obj.data)
+	}
+}
+
+func TestS3RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []int
+		wantErr   bool
+		wantPuts  int
+		wantSaved bool
+	}{
+		{name: "503 is retried", failures: []int{http.StatusServiceUnavailable}, wantPuts: 2, wantSaved: true},
+		{name: "500 is retried", failures: []int{http.StatusInternalServerError}, wantPuts: 2, wantSaved: true},
+		{name: "403 is not", failures: []int{http.StatusForbidden}, wantErr: true, wantPuts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeS3(t)
+			srv.failPuts = tt.failures
+			_, err := newS3(t, srv, 0).Save(context.Background(), "1_uuid_0.png", []byte("data"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Save() error = %v, want error %v", err, tt.wantErr)
+			}
+			if n := srv.putRequests(); n != tt.wantPuts {
+				t.Errorf("server received %d PUT requests, want %d", n, tt.wantPuts)
+			}
+			if _, ok := srv.object("images/generated/1_uuid_0.png"); ok != tt.wantSaved {
+				t.Errorf("object saved = %v, want %v", ok, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestS3RespectsCancellation(t *testing.T) {
+	srv := newFakeS3(t)
+	srv.hang = true
+	s := newS3(t, srv, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.Save(ctx, "1_uuid_0.png", []byte("data"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Save() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Save() returned %v after the deadline, want it to stop retrying", elapsed)
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	srv := newFakeS3(t)
+	ctx := context.Background()
+
+	if url, err := newS3(t, srv, 0).URL(ctx, "generated/1_uuid_0.png"); err != nil || url != "" {
+		t.Errorf("URL() without presigning = %q, %v, want none", url, err)
+	}
+
+	url, err := newS3(t, srv, time.Hour).URL(ctx, "generated/1_uuid_0.png")
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	if !strings.HasPrefix(url, srv.URL+"/images/generated/1_uuid_0.png?") || !strings.Contains(url, "X-Amz-Expires=3600") {
+		t.Errorf("URL() = %q, want a presigned URL of the object valid for an hour", url)
+	}
+
+	// The presigned URL downloads the object without credentials of its own
+	if _, err := newS3(t, srv, 0).Save(ctx, "1_uuid_0.png", []byte("data")); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET presigned URL error = %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "data" {
+		t.Errorf("GET presigned URL = %d %q, want the object", resp.StatusCode, body)
+	}
+}
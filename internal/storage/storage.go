@@ -0,0 +1,67 @@
+// Package storage persists decoded images outside of the database.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/basel-ax/2xiang/internal/config"
+)
+
+// Storage saves generated images and returns a location they can be loaded from
+type Storage interface {
+	// Save stores data under key and returns its location, which may differ from key
+	// when a file with the same name already exists
+	Save(ctx context.Context, key string, data []byte) (string, error)
+
+	// SaveStream stores the data read from r like Save, without holding it in memory
+	SaveStream(ctx context.Context, key string, r io.Reader) (string, error)
+
+	// Load returns the data stored at a location returned by Save
+	Load(ctx context.Context, location string) ([]byte, error)
+
+	// Delete removes the data stored at a location returned by Save
+	Delete(ctx context.Context, location string) error
+}
+
+// URLer is implemented by storages that can hand out URLs for stored images
+type URLer interface {
+	// URL returns a URL the data at location can be downloaded from, or an empty string
+	URL(ctx context.Context, location string) (string, error)
+}
+
+// NewFromConfig creates the configured storage backend, or nil when images are kept in the database
+func NewFromConfig(ctx context.Context, cfg *config.Config) (Storage, error) {
+	switch cfg.Storage {
+	case "db":
+		return nil, nil
+	case "fs":
+		return NewFileSystem(cfg.StorageDir)
+	case "s3":
+		return NewS3(ctx, S3Options{
+			Bucket:          cfg.S3Bucket,
+			Prefix:          cfg.S3Prefix,
+			Region:          cfg.S3Region,
+			Endpoint:        cfg.S3Endpoint,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			UsePathStyle:    cfg.S3UsePathStyle,
+			PresignTTL:      cfg.S3PresignTTL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
+
+// Key expands a filename template; {id}, {uuid} and {index} are replaced by the image ID,
+// the generation UUID and the position of the file in the generation
+func Key(template string, id int, uuid string, index int) string {
+	return strings.NewReplacer(
+		"{id}", strconv.Itoa(id),
+		"{uuid}", uuid,
+		"{index}", strconv.Itoa(index),
+	).Replace(template)
+}
@@ -0,0 +1,79 @@
+package testsupport_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// placeholder is the cover used when no image could be generated
+var placeholder = []byte("placeholder")
+
+// coverFor is a caller of GenerateAndWait under test: it returns the generated cover of a post,
+// or the placeholder when the provider censors the prompt or takes too long
+func coverFor(ctx context.Context, svc domain.ImageGenerationService, title string) ([]byte, error) {
+	result, err := svc.GenerateAndWait(ctx, domain.ImageGenerationRequest{Prompt: "a cover for " + title})
+	switch {
+	case errors.Is(err, domain.ErrCensored), errors.Is(err, domain.ErrGenerationTimeout):
+		return placeholder, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to generate the cover of %q: %w", title, err)
+	}
+	return result.Data, nil
+}
+
+// Example tests every path of a caller of GenerateAndWait with one scripted fake
+func Example() {
+	fake := testsupport.NewFakeImageGenerationService().
+		On("a cover for lighthouses", testsupport.DoneAfter(2)).
+		On("a cover for forbidden things", testsupport.Censored()).
+		On("a cover for slow things", testsupport.Timeout()).
+		On("a cover for broken things", testsupport.Failed("internal error"))
+	fake.PollInterval = time.Millisecond
+	fake.GenerationTimeout = 20 * time.Millisecond
+
+	for _, title := range []string{"lighthouses", "forbidden things", "slow things", "broken things"} {
+		cover, err := coverFor(context.Background(), fake, title)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %v\n", title, err)
+		case string(cover) == string(placeholder):
+			fmt.Printf("%s: placeholder\n", title)
+		default:
+			fmt.Printf("%s: %d bytes of PNG\n", title, len(cover))
+		}
+	}
+	// Output:
+	// lighthouses: 75 bytes of PNG
+	// forbidden things: placeholder
+	// slow things: placeholder
+	// broken things: failed to generate the cover of "broken things": generation failed: internal error
+}
+
+func ExampleFakeImageGenerationService_Calls() {
+	fake := testsupport.NewFakeImageGenerationService().On("a lighthouse", testsupport.DoneAfter(1))
+	ctx := context.Background()
+
+	// A caller that submits and polls by itself, like the workflows do
+	resp, err := fake.GenerateImage(ctx, domain.ImageGenerationRequest{Prompt: "a lighthouse", Style: "ANIME"})
+	for err == nil && resp.Status != "DONE" {
+		resp, err = fake.CheckGenerationStatus(ctx, resp.UUID)
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+
+	// The calls show what the caller asked for, and that it polled once while PROCESSING and
+	// once more for the result
+	for _, call := range fake.Calls() {
+		fmt.Printf("%s %q %q\n", call.Method, call.Request.Style, call.UUID)
+	}
+	// Output:
+	// GenerateImage "ANIME" ""
+	// CheckGenerationStatus "" "fake-1"
+	// CheckGenerationStatus "" "fake-1"
+}
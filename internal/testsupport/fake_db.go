@@ -0,0 +1,76 @@
+package testsupport
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// errNoStatements is returned for every statement run on a fake database
+var errNoStatements = errors.New("testsupport: the fake database runs no statements")
+
+// fakeConnector opens connections that accept nothing but being pinged and closed
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errNoStatements }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errNoStatements }
+
+// OpenFakeDB opens a connection pool that runs no statements, for testing code that only
+// manages connections, such as pool statistics. It is closed when the test ends.
+func OpenFakeDB(t testing.TB) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(fakeConnector{})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// ContendPool takes every connection db may open and has one more caller wait for a
+// connection. The returned function hands the connections back, lets the waiter through and
+// returns once it is done, leaving db with one wait in its statistics.
+func ContendPool(t testing.TB, db *sql.DB) (release func()) {
+	t.Helper()
+	ctx := context.Background()
+	held := make([]*sql.Conn, db.Stats().MaxOpenConnections)
+	if len(held) == 0 {
+		t.Fatal("ContendPool() needs a pool with SetMaxOpenConns")
+	}
+	for i := range held {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Conn() error = %v", err)
+		}
+		held[i] = conn
+	}
+
+	waiting := db.Stats().WaitCount
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if conn, err := db.Conn(ctx); err == nil {
+			conn.Close()
+		}
+	}()
+	for db.Stats().WaitCount == waiting {
+		time.Sleep(time.Millisecond)
+	}
+
+	return func() {
+		for _, conn := range held {
+			conn.Close()
+		}
+		<-done
+	}
+}
@@ -0,0 +1,330 @@
+// Package testsupport provides fakes of the domain interfaces for testing code that depends on
+// them. A FakeImageGenerationService answers every prompt with a scripted Outcome following the
+// status semantics of the real service:
+//
+//	fake := testsupport.NewFakeImageGenerationService().
+//		On("a lighthouse", testsupport.DoneAfter(2)).
+//		On("forbidden", testsupport.Censored())
+//
+//	_, err := fake.GenerateAndWait(ctx, domain.ImageGenerationRequest{Prompt: "forbidden"})
+//	// errors.Is(err, domain.ErrCensored) == true
+//
+//	calls := fake.Calls()
+//	// calls[0].Method == testsupport.MethodGenerateAndWait
+//
+// A FakeProvider scripts a domain.ImageProvider the same way, e.g. to test provider chains.
+package testsupport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+)
+
+// Methods of domain.ImageGenerationService, as recorded in a Call
+const (
+	MethodGenerateImage         = "GenerateImage"
+	MethodCheckGenerationStatus = "CheckGenerationStatus"
+	MethodGenerateAndWait       = "GenerateAndWait"
+)
+
+// Outcome scripts how a generation of a prompt ends
+type Outcome struct {
+	// Polls is how many status checks report PROCESSING before the final status; with none a
+	// successful generation is DONE as soon as it is submitted
+	Polls int
+	// Files are the base64 files of a successful generation
+	Files    []string
+	Censored bool
+	// Fail makes the final status FAIL with ErrorDescription
+	Fail             bool
+	ErrorDescription string
+	// Hang keeps the generation PROCESSING forever, so waiting for it times out
+	Hang bool
+	// Err is returned by GenerateImage instead of submitting the generation
+	Err error
+}
+
+// Done is a generation that is DONE as soon as it is submitted, with the given base64 files or
+// a 1x1 PNG
+func Done(files ...string) Outcome {
+	return DoneAfter(0, files...)
+}
+
+// DoneAfter is a generation that is DONE after polls status checks, with the given base64 files
+// or a 1x1 PNG
+func DoneAfter(polls int, files ...string) Outcome {
+	if len(files) == 0 {
+		files = []string{fakeserver.Pixel}
+	}
+	return Outcome{Polls: polls, Files: files}
+}
+
+// Failed is a generation whose first status check reports FAIL with description
+func Failed(description string) Outcome {
+	return Outcome{Fail: true, ErrorDescription: description}
+}
+
+// Censored is a generation the provider censors
+func Censored() Outcome {
+	return Outcome{Files: []string{fakeserver.Pixel}, Censored: true}
+}
+
+// Timeout is a generation that never finishes
+func Timeout() Outcome {
+	return Outcome{Hang: true}
+}
+
+// SubmitError is a generation the provider refuses with err, e.g. domain.ErrQuotaExceeded
+func SubmitError(err error) Outcome {
+	return Outcome{Err: err}
+}
+
+// Call is a call received by a FakeImageGenerationService
+type Call struct {
+	Method string
+	// Request is the request of GenerateImage and GenerateAndWait calls
+	Request domain.ImageGenerationRequest
+	// UUID is the generation of CheckGenerationStatus calls
+	UUID string
+}
+
+// generation is a submitted generation and the status checks it has seen
+type generation struct {
+	outcome Outcome
+	checks  int
+}
+
+// FakeImageGenerationService implements domain.ImageGenerationService with scripted outcomes.
+// It is safe for concurrent use; configure it before sharing it.
+type FakeImageGenerationService struct {
+	// Default is the outcome of prompts without one, Done() by default
+	Default Outcome
+	// Provider is reported in every response, "fake" by default
+	Provider string
+	// PollInterval is the simulated wait between the status checks of GenerateAndWait,
+	// 10ms by default
+	PollInterval time.Duration
+	// GenerationTimeout bounds GenerateAndWait like DEFAULT_GENERATION_TIMEOUT does the real
+	// service, 1s by default
+	GenerationTimeout time.Duration
+
+	mu          sync.Mutex
+	outcomes    map[string]Outcome
+	generations map[string]*generation
+	calls       []Call
+	nextID      int
+}
+
+// NewFakeImageGenerationService creates a fake that generates every prompt right away
+func NewFakeImageGenerationService() *FakeImageGenerationService {
+	return &FakeImageGenerationService{
+		Default:           Done(),
+		Provider:          "fake",
+		PollInterval:      10 * time.Millisecond,
+		GenerationTimeout: time.Second,
+		outcomes:          make(map[string]Outcome),
+		generations:       make(map[string]*generation),
+	}
+}
+
+// On scripts the outcome of every generation of prompt and returns the fake for chaining
+func (f *FakeImageGenerationService) On(prompt string, outcome Outcome) *FakeImageGenerationService {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outcomes[prompt] = outcome
+	return f
+}
+
+// Calls returns the calls received so far, oldest first
+func (f *FakeImageGenerationService) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// record appends a call
+func (f *FakeImageGenerationService) record(call Call) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// GenerateImage submits a generation with the outcome scripted for its prompt
+func (f *FakeImageGenerationService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	f.record(Call{Method: MethodGenerateImage, Request: req})
+	return f.submit(ctx, req)
+}
+
+// submit starts a generation of req
+func (f *FakeImageGenerationService) submit(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	outcome, ok := f.outcomes[req.Prompt]
+	if !ok {
+		outcome = f.Default
+	}
+	if outcome.Err != nil {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("failed to generate image: %w", outcome.Err)
+	}
+	f.nextID++
+	uuid := "fake-" + strconv.Itoa(f.nextID)
+	g := &generation{outcome: outcome}
+	f.generations[uuid] = g
+	f.mu.Unlock()
+
+	resp := &domain.ImageGenerationResponse{UUID: uuid, Status: "INITIAL", Provider: f.Provider, Prompt: req.Prompt, SubmittedAt: time.Now()}
+	if outcome.Polls == 0 && !outcome.Fail && !outcome.Hang {
+		f.finish(resp, outcome)
+	}
+	return resp, nil
+}
+
+// CheckGenerationStatus advances a submitted generation by one status check
+func (f *FakeImageGenerationService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	f.record(Call{Method: MethodCheckGenerationStatus, UUID: uuid})
+	return f.check(ctx, uuid)
+}
+
+// check reports the status of a generation after one more status check
+func (f *FakeImageGenerationService) check(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	g, ok := f.generations[uuid]
+	var checks int
+	if ok {
+		g.checks++
+		checks = g.checks
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("failed to check generation status: generation %s not found", uuid)
+	}
+
+	resp := &domain.ImageGenerationResponse{UUID: uuid, Status: "PROCESSING", Provider: f.Provider}
+	if g.outcome.Hang || checks <= g.outcome.Polls {
+		return resp, nil
+	}
+	f.finish(resp, g.outcome)
+	return resp, nil
+}
+
+// finish sets the final status of outcome on resp
+func (f *FakeImageGenerationService) finish(resp *domain.ImageGenerationResponse, outcome Outcome) {
+	resp.CompletedAt = time.Now()
+	if outcome.Fail {
+		resp.Status = "FAIL"
+		resp.ErrorDescription = outcome.ErrorDescription
+		resp.RawResponse = rawStatus(resp)
+		return
+	}
+	resp.Status = "DONE"
+	resp.Files = outcome.Files
+	resp.Censored = outcome.Censored
+	if resp.Censored {
+		resp.RawResponse = rawStatus(resp)
+	}
+}
+
+// rawStatus renders resp like a FusionBrain status response, without its files
+func rawStatus(resp *domain.ImageGenerationResponse) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"uuid":             resp.UUID,
+		"status":           resp.Status,
+		"errorDescription": resp.ErrorDescription,
+		"result":           map[string]interface{}{"censored": resp.Censored},
+	})
+	return data
+}
+
+// GenerateAndWait submits the request and polls it every PollInterval until it finishes within
+// GenerationTimeout, returning errors that wrap the same domain errors as the real service
+func (f *FakeImageGenerationService) GenerateAndWait(ctx context.Context, req domain.ImageGenerationRequest) (*domain.GenerationResult, error) {
+	f.record(Call{Method: MethodGenerateAndWait, Request: req})
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, f.GenerationTimeout)
+	defer cancel()
+
+	resp, err := f.submit(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for resp.Status != "DONE" {
+		if resp.Status == "FAIL" {
+			return nil, &domain.GenerationFailedError{UUID: resp.UUID, Description: resp.ErrorDescription, RawResponse: resp.RawResponse}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx)
+		case <-time.After(f.PollInterval):
+		}
+		if resp, err = f.check(ctx, resp.UUID); err != nil {
+			if ctx.Err() != nil {
+				return nil, waitError(ctx)
+			}
+			return nil, err
+		}
+	}
+
+	if resp.Censored {
+		return nil, fmt.Errorf("%w: generation %s", domain.ErrCensored, resp.UUID)
+	}
+	if len(resp.Files) == 0 {
+		return nil, fmt.Errorf("%w: no files returned for generation %s", domain.ErrGenerationFailed, resp.UUID)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Files[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return &domain.GenerationResult{
+		Data:     data,
+		UUID:     resp.UUID,
+		Provider: resp.Provider,
+		Elapsed:  time.Since(start),
+	}, nil
+}
+
+// waitError describes why waiting for a generation stopped once ctx is done, like the real
+// service: a deadline wraps domain.ErrGenerationTimeout
+func waitError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %w", domain.ErrGenerationTimeout, ctx.Err())
+	}
+	return fmt.Errorf("stopped waiting for generation: %w", ctx.Err())
+}
+
+// FakeProvider implements domain.ImageProvider with the scripted outcomes of a
+// FakeImageGenerationService, for testing the service and provider chains
+type FakeProvider struct {
+	*FakeImageGenerationService
+}
+
+// NewFakeProvider creates a fake provider with the given name that generates every prompt right
+// away
+func NewFakeProvider(name string) *FakeProvider {
+	f := NewFakeImageGenerationService()
+	f.Provider = name
+	return &FakeProvider{FakeImageGenerationService: f}
+}
+
+// Name returns the name of the provider
+func (p *FakeProvider) Name() string {
+	return p.Provider
+}
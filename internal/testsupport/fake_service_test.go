@@ -0,0 +1,104 @@
+package testsupport_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+func TestFakeGenerateAndWaitOutcomes(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome testsupport.Outcome
+		wantErr error
+	}{
+		{name: "done", outcome: testsupport.Done()},
+		{name: "done after polls", outcome: testsupport.DoneAfter(3)},
+		{name: "failed", outcome: testsupport.Failed("internal error"), wantErr: domain.ErrGenerationFailed},
+		{name: "censored", outcome: testsupport.Censored(), wantErr: domain.ErrCensored},
+		{name: "timeout", outcome: testsupport.Timeout(), wantErr: domain.ErrGenerationTimeout},
+		{name: "submit error", outcome: testsupport.SubmitError(domain.ErrQuotaExceeded), wantErr: domain.ErrQuotaExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testsupport.NewFakeImageGenerationService().On("a lighthouse", tt.outcome)
+			fake.PollInterval = time.Millisecond
+			fake.GenerationTimeout = 50 * time.Millisecond
+
+			result, err := fake.GenerateAndWait(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse"})
+			if tt.wantErr == nil {
+				if err != nil || len(result.Data) == 0 || result.Provider != "fake" {
+					t.Errorf("GenerateAndWait() = %+v, %v, want the image", result, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GenerateAndWait() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFakeStatusSemantics(t *testing.T) {
+	fake := testsupport.NewFakeImageGenerationService().
+		On("slow", testsupport.DoneAfter(2)).
+		On("broken", testsupport.Failed("internal error"))
+	ctx := context.Background()
+
+	// A generation reports PROCESSING for its polls, then its final status on every later check
+	resp, err := fake.GenerateImage(ctx, domain.ImageGenerationRequest{Prompt: "slow"})
+	if err != nil || resp.Status != "INITIAL" {
+		t.Fatalf("GenerateImage() = %+v, %v, want INITIAL", resp, err)
+	}
+	var statuses []string
+	for i := 0; i < 4; i++ {
+		check, err := fake.CheckGenerationStatus(ctx, resp.UUID)
+		if err != nil {
+			t.Fatalf("CheckGenerationStatus() error = %v", err)
+		}
+		statuses = append(statuses, check.Status)
+	}
+	if want := []string{"PROCESSING", "PROCESSING", "DONE", "DONE"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+
+	// A failure keeps its description and the raw response of the provider
+	resp, _ = fake.GenerateImage(ctx, domain.ImageGenerationRequest{Prompt: "broken"})
+	check, err := fake.CheckGenerationStatus(ctx, resp.UUID)
+	if err != nil || check.Status != "FAIL" || check.ErrorDescription != "internal error" || len(check.RawResponse) == 0 {
+		t.Errorf("CheckGenerationStatus() = %+v, %v, want FAIL with the description", check, err)
+	}
+
+	if _, err := fake.CheckGenerationStatus(ctx, "unknown"); err == nil {
+		t.Error("CheckGenerationStatus() of an unknown generation error = nil, want an error")
+	}
+	if n := len(fake.Calls()); n != 8 {
+		t.Errorf("%d calls recorded, want 8", n)
+	}
+}
+
+func TestFakeHonorsCancellation(t *testing.T) {
+	fake := testsupport.NewFakeImageGenerationService().On("a lighthouse", testsupport.Timeout())
+	fake.GenerationTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := fake.GenerateAndWait(ctx, domain.ImageGenerationRequest{Prompt: "a lighthouse"})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, domain.ErrGenerationTimeout) {
+		t.Errorf("GenerateAndWait() error = %v, want it canceled rather than timed out", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GenerateAndWait() returned after %v, want it to stop waiting on cancel", elapsed)
+	}
+
+	// A canceled context submits nothing
+	if _, err := fake.GenerateImage(ctx, domain.ImageGenerationRequest{Prompt: "a lighthouse"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateImage() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,20 @@
+package testsupport
+
+// SecretPrefix starts every secret FuzzSecret makes, so that a secret cannot be found in the
+// fixed text around it, such as log keys, by chance
+const SecretPrefix = "sk-"
+
+// FuzzSecret turns fuzzed input into a secret like an API key: SecretPrefix followed by s. It
+// reports false for an s that is empty or holds anything but ASCII letters, digits, '-' and '_',
+// which JSON, log and URL encoders would escape, hiding a leaked secret from a plain search.
+func FuzzSecret(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return "", false
+		}
+	}
+	return SecretPrefix + s, true
+}
@@ -0,0 +1,51 @@
+// Package textutil truncates text by characters rather than bytes
+package textutil
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TruncateRunes returns the first n runes of s, or s itself when it is not longer. Invalid UTF-8
+// in s is replaced by U+FFFD first, so the result is always valid UTF-8.
+func TruncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+
+	runes := 0
+	for i := range s {
+		if runes == n {
+			return s[:i]
+		}
+		runes++
+	}
+	return s
+}
+
+// TruncateWords returns at most n runes of s without splitting a word, dropping whitespace at
+// the cut. A first word longer than n runes is cut like TruncateRunes does.
+func TruncateWords(s string, n int) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+	cut := TruncateRunes(s, n)
+	if len(cut) == len(s) {
+		return cut
+	}
+
+	// The cut ends between words when the next rune is a space
+	if next, _ := utf8.DecodeRuneInString(s[len(cut):]); unicode.IsSpace(next) {
+		return strings.TrimRightFunc(cut, unicode.IsSpace)
+	}
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i >= 0 {
+		if words := strings.TrimRightFunc(cut[:i], unicode.IsSpace); words != "" {
+			return words
+		}
+	}
+	return cut
+}
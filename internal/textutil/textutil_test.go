@@ -0,0 +1,130 @@
+package textutil_test
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/internal/textutil"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "a lighthouse", n: 20, want: "a lighthouse"},
+		{s: "a lighthouse", n: 12, want: "a lighthouse"},
+		{s: "a lighthouse", n: 5, want: "a lig"},
+		{s: "a lighthouse", n: 0, want: ""},
+		{s: "a lighthouse", n: -1, want: ""},
+		{s: "", n: 3, want: ""},
+		// Multi-byte characters count once and are never split
+		{s: "маяк у скалы", n: 4, want: "маяк"},
+		{s: "灯台と崖", n: 2, want: "灯台"},
+		{s: "🌊🌅⛵", n: 2, want: "🌊🌅"},
+		// Invalid bytes become U+FFFD and count as one character each
+		{s: "ab\xffcd", n: 3, want: "ab�"},
+	}
+	for _, tt := range tests {
+		if got := textutil.TruncateRunes(tt.s, tt.n); got != tt.want {
+			t.Errorf("TruncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateWords(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "a lighthouse on a cliff", n: 50, want: "a lighthouse on a cliff"},
+		{s: "a lighthouse on a cliff", n: 15, want: "a lighthouse on"},
+		{s: "a lighthouse on a cliff", n: 14, want: "a lighthouse"},
+		{s: "a lighthouse on a cliff", n: 12, want: "a lighthouse"},
+		{s: "a lighthouse on a cliff", n: 13, want: "a lighthouse"},
+		// A first word longer than n is cut inside the word
+		{s: "lighthouses everywhere", n: 5, want: "light"},
+		{s: "  lighthouse", n: 5, want: "  lig"},
+		{s: "маяк у скалы", n: 8, want: "маяк у"},
+		{s: "маяк у скалы", n: 0, want: ""},
+	}
+	for _, tt := range tests {
+		if got := textutil.TruncateWords(tt.s, tt.n); got != tt.want {
+			t.Errorf("TruncateWords(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+// fuzzSeeds are the corpus of the fuzz targets: ASCII, multi-byte scripts, emoji with
+// combining marks, whitespace runs and invalid UTF-8
+var fuzzSeeds = []string{
+	"",
+	"a lighthouse on a cliff",
+	"маяк у скалы",
+	"灯台と崖、夜明け",
+	"🌊🌅⛵ é",
+	"  leading\tand trailing \n",
+	"\xff\xfe broken \xc3",
+	strings.Repeat("word ", 300),
+}
+
+// checkTruncated fails when out is not valid UTF-8 or longer than n runes
+func checkTruncated(t *testing.T, name, s string, n int, out string) {
+	t.Helper()
+	if !utf8.ValidString(out) {
+		t.Fatalf("%s(%q, %d) = %q, want valid UTF-8", name, s, n, out)
+	}
+	limit := n
+	if limit < 0 {
+		limit = 0
+	}
+	if got := utf8.RuneCountInString(out); got > limit {
+		t.Fatalf("%s(%q, %d) = %q with %d runes, want at most %d", name, s, n, out, got, limit)
+	}
+}
+
+func FuzzTruncateRunes(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s, 5)
+		f.Add(s, 1000)
+	}
+	f.Fuzz(func(t *testing.T, s string, n int) {
+		out := textutil.TruncateRunes(s, n)
+		checkTruncated(t, "TruncateRunes", s, n, out)
+
+		// The result is a prefix of the valid text, and all of it when it fits
+		valid := strings.ToValidUTF8(s, string(utf8.RuneError))
+		if !strings.HasPrefix(valid, out) {
+			t.Fatalf("TruncateRunes(%q, %d) = %q, want a prefix of the input", s, n, out)
+		}
+		if n >= utf8.RuneCountInString(valid) && out != valid {
+			t.Fatalf("TruncateRunes(%q, %d) = %q, want the whole input", s, n, out)
+		}
+	})
+}
+
+func FuzzTruncateWords(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s, 7)
+		f.Add(s, 1000)
+	}
+	f.Fuzz(func(t *testing.T, s string, n int) {
+		out := textutil.TruncateWords(s, n)
+		checkTruncated(t, "TruncateWords", s, n, out)
+
+		valid := strings.ToValidUTF8(s, string(utf8.RuneError))
+		if !strings.HasPrefix(valid, out) {
+			t.Fatalf("TruncateWords(%q, %d) = %q, want a prefix of the input", s, n, out)
+		}
+		// A shortened result never ends in whitespace unless it is all whitespace
+		if out != valid && strings.TrimSpace(out) != "" {
+			if last, _ := utf8.DecodeLastRuneInString(out); unicode.IsSpace(last) {
+				t.Fatalf("TruncateWords(%q, %d) = %q, want no whitespace at the cut", s, n, out)
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// Package tracing records OpenTelemetry spans across the workflows, the image generation service,
+// the provider clients and the repository. Until Setup finds an OTLP endpoint or Use installs a
+// provider, every span is a no-op that allocates nothing.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of every span
+const instrumentation = "github.com/basel-ax/2xiang"
+
+// serviceName is the service.name of the spans unless OTEL_SERVICE_NAME overrides it
+const serviceName = "2xiang"
+
+var (
+	// tracer starts the spans; nil disables tracing
+	tracer trace.Tracer
+	// noopSpan is returned by Start while tracing is disabled
+	noopSpan = trace.SpanFromContext(context.Background())
+)
+
+// Enabled reports whether spans are recorded
+func Enabled() bool {
+	return tracer != nil
+}
+
+// Use records the spans with provider from now on; nil disables tracing again. It must be called
+// before the workflows start.
+func Use(provider trace.TracerProvider) {
+	if provider == nil {
+		tracer = nil
+		return
+	}
+	tracer = provider.Tracer(instrumentation)
+}
+
+// Setup exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard OTEL_* variables, and
+// leaves tracing disabled otherwise or with OTEL_SDK_DISABLED=true. The returned function
+// flushes the spans still buffered and is never nil.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return shutdown, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return shutdown, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return shutdown, errors.Join(err, exporter.Shutdown(ctx))
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	Use(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, noopSpan
+	}
+	return tracer.Start(ctx, name)
+}
+
+// End ends span, marking it failed with err unless err is nil
+func End(span trace.Span, err error) {
+	if err != nil && span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the ID of the trace of the span in ctx, or "" when the span is not recorded
+func TraceID(ctx context.Context) string {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return ""
+	}
+	return span.SpanContext().TraceID().String()
+}
+
+// Logger returns log with the trace_id of the span in ctx, which correlates the log entries of
+// an image with its trace, or log itself when the span is not recorded
+func Logger(ctx context.Context, log *slog.Logger) *slog.Logger {
+	id := TraceID(ctx)
+	if id == "" {
+		return log
+	}
+	return log.With("trace_id", id)
+}
@@ -0,0 +1,110 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDisabledTracingAllocatesNothing(t *testing.T) {
+	tracing.Use(nil)
+	if tracing.Enabled() {
+		t.Fatal("Enabled() = true after Use(nil)")
+	}
+	ctx := context.Background()
+	err := errors.New("provider unavailable")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		spanCtx, span := tracing.Start(ctx, "generator.pass")
+		if spanCtx != ctx || span.IsRecording() {
+			t.Fatal("Start() while disabled returned a recording span")
+		}
+		tracing.End(span, err)
+		if tracing.TraceID(spanCtx) != "" {
+			t.Fatal("TraceID() while disabled is not empty")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("disabled spans allocate %v times, want 0", allocs)
+	}
+
+	log := slog.Default()
+	if got := tracing.Logger(ctx, log); got != log {
+		t.Error("Logger() while disabled returned a new logger")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracing.Use(provider)
+	t.Cleanup(func() { tracing.Use(nil) })
+	if !tracing.Enabled() {
+		t.Fatal("Enabled() = false after Use()")
+	}
+
+	ctx, pass := tracing.Start(context.Background(), "generator.pass")
+	imageCtx, image := tracing.Start(ctx, "generator.image")
+	tracing.End(image, errors.New("provider unavailable"))
+	tracing.End(pass, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "generator.image" || spans[1].Name != "generator.pass" {
+		t.Fatalf("recorded %v, want the image and pass spans", spans)
+	}
+	if spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("image span is not a child of the pass span")
+	}
+	if spans[0].Status.Code != codes.Error || spans[0].Status.Description != "provider unavailable" || len(spans[0].Events) != 1 {
+		t.Errorf("failed span status = %v with %d events, want the error recorded", spans[0].Status, len(spans[0].Events))
+	}
+	if spans[1].Status.Code != codes.Unset {
+		t.Errorf("successful span status = %v, want unset", spans[1].Status)
+	}
+
+	// Log entries of the image carry the trace ID of its pass
+	traceID := spans[1].SpanContext.TraceID().String()
+	if got := tracing.TraceID(imageCtx); got != "" {
+		t.Errorf("TraceID() of an ended span = %q, want empty", got)
+	}
+	liveCtx, live := tracing.Start(ctx, "generator.image")
+	defer live.End()
+	if got := tracing.TraceID(liveCtx); got != traceID {
+		t.Errorf("TraceID() = %q, want %q", got, traceID)
+	}
+	var out bytes.Buffer
+	tracing.Logger(liveCtx, slog.New(slog.NewTextHandler(&out, nil))).Info("Image submitted")
+	if !strings.Contains(out.String(), "trace_id="+traceID) {
+		t.Errorf("log entry = %q, want the trace_id", out.String())
+	}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown, err := tracing.Setup(context.Background())
+	if err != nil || shutdown == nil {
+		t.Fatalf("Setup() = %v, want no error and a shutdown function", err)
+	}
+	if tracing.Enabled() {
+		t.Error("Setup() without an endpoint enabled tracing")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+
+	// OTEL_SDK_DISABLED wins over a configured endpoint
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	if _, err := tracing.Setup(context.Background()); err != nil || tracing.Enabled() {
+		t.Errorf("Setup() with OTEL_SDK_DISABLED = %v, enabled %t, want tracing disabled", err, tracing.Enabled())
+	}
+}
@@ -0,0 +1,130 @@
+// Package webhook notifies callback URLs when images finish or fail.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, prefixed with "sha256="
+const SignatureHeader = "X-Signature-256"
+
+// Payload is the JSON body posted to an image's callback URL
+type Payload struct {
+	ID          int    `json:"id"`
+	Status      string `json:"status"`
+	UUID        string `json:"uuid"`
+	Error       string `json:"error,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// Notifier delivers payloads, retrying failed deliveries with exponential backoff
+type Notifier struct {
+	httpClient  *http.Client
+	secret      []byte
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+// Option configures optional Notifier settings
+type Option func(*Notifier)
+
+// WithHTTPClient overrides the HTTP client used for deliveries
+func WithHTTPClient(client *http.Client) Option {
+	return func(n *Notifier) {
+		n.httpClient = client
+	}
+}
+
+// WithRetryDelay sets the delay after the first failed delivery; it doubles with every retry
+func WithRetryDelay(delay time.Duration) Option {
+	return func(n *Notifier) {
+		n.retryDelay = delay
+	}
+}
+
+// NewNotifier creates a notifier signing payloads with secret; an empty secret sends them unsigned
+func NewNotifier(secret string, maxAttempts int, opts ...Option) *Notifier {
+	n := &Notifier{
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		secret:      []byte(secret),
+		maxAttempts: max(maxAttempts, 1),
+		retryDelay:  time.Second,
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	return n
+}
+
+// Sign returns the value of SignatureHeader for body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver posts payload to url until it is accepted with a 2xx status, the receiver rejects it
+// with a 4xx status other than 408 or 429, or the attempts are used up. It returns the number
+// of attempts made.
+func (n *Notifier) Deliver(ctx context.Context, url string, payload Payload) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(ctx, url, body)
+		if err == nil {
+			return attempt, nil
+		}
+		if !retry || attempt >= n.maxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth retrying
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
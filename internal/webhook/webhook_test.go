@@ -0,0 +1,188 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/webhook"
+)
+
+// delivery is a request received by receiver
+type delivery struct {
+	signature string
+	body      []byte
+	at        time.Time
+}
+
+// receiver answers deliveries with the scripted statuses in turn, and 204 once they run out
+type receiver struct {
+	mu         sync.Mutex
+	statuses   []int
+	deliveries []delivery
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries = append(r.deliveries, delivery{signature: req.Header.Get(webhook.SignatureHeader), body: body, at: time.Now()})
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// received returns the deliveries so far
+func (r *receiver) received() []delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+// newReceiver starts a receiver answering with statuses and returns it with its URL
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	t.Helper()
+	r := &receiver{statuses: statuses}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv.URL
+}
+
+var payload = webhook.Payload{ID: 7, Status: "ReadyToPublish", UUID: "uuid-1", DownloadURL: "https://cdn.example.com/7.png"}
+
+func TestSign(t *testing.T) {
+	// The HMAC-SHA256 test vector of the quick brown fox
+	got := webhook.Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog"))
+	if want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	r, url := newReceiver(t)
+	attempts, err := webhook.NewNotifier("shared-secret", 3).Deliver(context.Background(), url, payload)
+	if err != nil || attempts != 1 {
+		t.Fatalf("Deliver() = %d, %v, want a single successful attempt", attempts, err)
+	}
+
+	got := r.received()[0]
+	// The receiver verifies the signature over the exact body it received
+	if want := webhook.Sign([]byte("shared-secret"), got.body); !hmac.Equal([]byte(got.signature), []byte(want)) {
+		t.Errorf("signature = %q, want %q", got.signature, want)
+	}
+	if got.signature == webhook.Sign([]byte("other-secret"), got.body) {
+		t.Error("signature also verifies with another secret")
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(got.body, &fields); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	want := map[string]interface{}{"id": 7.0, "status": "ReadyToPublish", "uuid": "uuid-1", "download_url": "https://cdn.example.com/7.png"}
+	if len(fields) != len(want) {
+		t.Errorf("body = %s, want only %v", got.body, want)
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("body field %s = %v, want %v", k, fields[k], v)
+		}
+	}
+}
+
+func TestDeliverUnsigned(t *testing.T) {
+	r, url := newReceiver(t)
+	if _, err := webhook.NewNotifier("", 1).Deliver(context.Background(), url, payload); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	if sig := r.received()[0].signature; sig != "" {
+		t.Errorf("signature = %q, want none without a secret", sig)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "500s then success", statuses: []int{500, 500}, maxAttempts: 5, wantAttempts: 3},
+		{name: "500s exhaust the attempts", statuses: []int{500, 502, 503, 500}, maxAttempts: 3, wantAttempts: 3, wantErr: true},
+		{name: "408 and 429 are retried", statuses: []int{408, 429}, maxAttempts: 3, wantAttempts: 3},
+		{name: "400 is final", statuses: []int{400}, maxAttempts: 5, wantAttempts: 1, wantErr: true},
+		{name: "410 is final", statuses: []int{410}, maxAttempts: 5, wantAttempts: 1, wantErr: true},
+		{name: "single attempt", statuses: []int{500}, maxAttempts: 0, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, url := newReceiver(t, tt.statuses...)
+			n := webhook.NewNotifier("secret", tt.maxAttempts, webhook.WithRetryDelay(time.Millisecond))
+
+			attempts, err := n.Deliver(context.Background(), url, payload)
+			if (err != nil) != tt.wantErr || attempts != tt.wantAttempts {
+				t.Errorf("Deliver() = %d, %v, want %d attempts and error %v", attempts, err, tt.wantAttempts, tt.wantErr)
+			}
+			received := r.received()
+			if len(received) != tt.wantAttempts {
+				t.Fatalf("receiver got %d deliveries, want %d", len(received), tt.wantAttempts)
+			}
+			// Every retry carries the same signed body
+			for _, d := range received[1:] {
+				if string(d.body) != string(received[0].body) || d.signature != received[0].signature {
+					t.Errorf("retry sent %s signed %s, want the first delivery repeated", d.body, d.signature)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverBacksOffExponentially(t *testing.T) {
+	r, url := newReceiver(t, 500, 500, 500)
+	n := webhook.NewNotifier("secret", 4, webhook.WithRetryDelay(20*time.Millisecond))
+	if _, err := n.Deliver(context.Background(), url, payload); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+
+	received := r.received()
+	for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond} {
+		if gap := received[i+1].at.Sub(received[i].at); gap < min {
+			t.Errorf("retry %d came after %v, want at least %v", i+1, gap, min)
+		}
+	}
+}
+
+func TestDeliverStopsWhenCancelled(t *testing.T) {
+	r, url := newReceiver(t, 500, 500, 500)
+	n := webhook.NewNotifier("secret", 5, webhook.WithRetryDelay(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	attempts, err := n.Deliver(ctx, url, payload)
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+		t.Errorf("Deliver() = %d, %v, want 1 attempt ending with %v", attempts, err, context.DeadlineExceeded)
+	}
+	if got := len(r.received()); got != 1 {
+		t.Errorf("receiver got %d deliveries, want 1", got)
+	}
+}
+
+func TestDeliverRetriesUnreachableReceivers(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	attempts, err := webhook.NewNotifier("secret", 2, webhook.WithRetryDelay(time.Millisecond)).Deliver(context.Background(), url, payload)
+	if err == nil || attempts != 2 {
+		t.Errorf("Deliver() = %d, %v, want 2 failed attempts", attempts, err)
+	}
+}
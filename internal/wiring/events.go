@@ -0,0 +1,29 @@
+package wiring
+
+import (
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/events"
+)
+
+// NewEventPublisher creates the publisher of image lifecycle events, or returns nil when
+// EVENTS_NATS_URL is not set
+func NewEventPublisher(cfg *config.Config) (*events.Publisher, error) {
+	if cfg.Events.NATSURL == "" {
+		return nil, nil
+	}
+
+	var opts []events.NATSOption
+	if cfg.Events.NATSUser != "" || cfg.Events.NATSPassword != "" {
+		opts = append(opts, events.WithNATSCredentials(cfg.Events.NATSUser, cfg.Events.NATSPassword))
+	}
+	if cfg.Events.NATSToken != "" {
+		opts = append(opts, events.WithNATSToken(cfg.Events.NATSToken))
+	}
+	transport, err := events.NewNATS(cfg.Events.NATSURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NATS transport: %w", err)
+	}
+	return events.NewPublisher(transport, cfg.Events.MaxAttempts, events.WithSubjectPrefix(cfg.Events.SubjectPrefix)), nil
+}
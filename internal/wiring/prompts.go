@@ -0,0 +1,21 @@
+package wiring
+
+import (
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+// PromptProcessors returns the built-in processors enabled in the configuration, in the order
+// they are applied: the template is added before banned words are stripped, so that they are
+// stripped from the final prompt
+func PromptProcessors(cfg *config.Config) []domain.PromptProcessor {
+	var processors []domain.PromptProcessor
+	if cfg.PromptPrefix != "" || cfg.PromptSuffix != "" {
+		processors = append(processors, prompts.NewTemplate(cfg.PromptPrefix, cfg.PromptSuffix))
+	}
+	if len(cfg.PromptBannedWords) > 0 {
+		processors = append(processors, prompts.NewBannedWords(cfg.PromptBannedWords))
+	}
+	return processors
+}
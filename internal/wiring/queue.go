@@ -0,0 +1,34 @@
+package wiring
+
+import (
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/queue"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// NewRedisQueue creates the Redis queue of the QUEUE=redis mode
+func NewRedisQueue(cfg *config.Config) (*queue.Redis, error) {
+	return queue.NewRedis(cfg.Queue.RedisURL,
+		queue.WithPrefix(cfg.Queue.Prefix),
+		queue.WithResultTTL(cfg.Queue.ResultTTL),
+	)
+}
+
+// ConsumerConfig returns the settings of the generator of the QUEUE=redis mode
+func ConsumerConfig(cfg *config.Config) queue.ConsumerConfig {
+	return queue.ConsumerConfig{
+		Concurrency:    cfg.Workflow.Concurrency,
+		Visibility:     cfg.Queue.VisibilityTimeout,
+		PollInterval:   cfg.Queue.PollInterval,
+		MaxAttempts:    cfg.Queue.MaxAttempts,
+		RetryBaseDelay: cfg.Workflow.RetryBaseDelay,
+		RetryMaxDelay:  cfg.Workflow.RetryMaxDelay,
+		Defaults: domain.ImageGenerationRequest{
+			Width:          cfg.DefaultImageWidth,
+			Height:         cfg.DefaultImageHeight,
+			NumImages:      cfg.DefaultNumImages,
+			Style:          cfg.DefaultStyle,
+			NegativePrompt: cfg.DefaultNegativePrompt,
+		},
+	}
+}
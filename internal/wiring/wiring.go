@@ -0,0 +1,133 @@
+// Package wiring builds the public packages from the application configuration.
+package wiring
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/infrastructure/openai"
+	"github.com/basel-ax/2xiang/internal/infrastructure/replicate"
+	"github.com/basel-ax/2xiang/internal/infrastructure/stability"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"golang.org/x/time/rate"
+)
+
+// ServiceConfig returns the request defaults and timing of the image generation service
+func ServiceConfig(cfg *config.Config) service.Config {
+	return service.Config{
+		DefaultImageWidth:  cfg.DefaultImageWidth,
+		DefaultImageHeight: cfg.DefaultImageHeight,
+		DefaultNumImages:   cfg.DefaultNumImages,
+		SnapDimensions:     cfg.SnapDimensions,
+		MaxPromptLength:    cfg.MaxPromptLength,
+		CheckInterval:      cfg.CheckInterval,
+		PollMaxInterval:    cfg.PollMaxInterval,
+		GenerationTimeout:  cfg.GenerationTimeout,
+		Concurrency:        cfg.Workflow.Concurrency,
+	}
+}
+
+// NewService creates an image generation service backed by the configured providers.
+// More than one provider is wrapped in a FallbackProvider tried in the configured order.
+// The service and its providers report to hook, and providers log requests to logger.
+func NewService(cfg *config.Config, hook metrics.Hook, logger *slog.Logger, opts ...service.Option) (*service.ImageGenerationService, error) {
+	providers := make([]domain.ImageProvider, 0, len(cfg.Providers))
+	for _, name := range cfg.Providers {
+		provider, err := NewProvider(name, cfg, hook, logger)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	var provider domain.ImageProvider
+	switch len(providers) {
+	case 0:
+		return nil, fmt.Errorf("no providers configured")
+	case 1:
+		provider = providers[0]
+	default:
+		provider = service.NewFallbackProvider(providers...)
+	}
+	opts = append(opts, service.WithMetrics(hook))
+	return service.NewImageGenerationService(provider, ServiceConfig(cfg), opts...), nil
+}
+
+// NewProvider creates the named provider from the configuration; providers that support it
+// report request durations to hook and log requests to logger. A Fusion Brain provider limits
+// its requests to API_RPS, shared by everything using it.
+func NewProvider(name string, cfg *config.Config, hook metrics.Hook, logger *slog.Logger) (domain.ImageProvider, error) {
+	switch name {
+	case fusionbrain.ProviderName:
+		opts, err := clientOptions(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure Fusion Brain client: %w", err)
+		}
+		opts = append(opts, fusionbrain.WithMetrics(hook), fusionbrain.WithLogger(logger))
+		if cfg.APIRPS > 0 {
+			limiter := rate.NewLimiter(rate.Limit(cfg.APIRPS), cfg.APIBurst)
+			opts = append(opts, fusionbrain.WithRateLimiter(limiter, cfg.APIRateLimitExemptPipelines))
+		}
+		return fusionbrain.NewClient(cfg.FusionBrain.APIKey, cfg.FusionBrain.SecretKey, opts...), nil
+
+	case openai.ProviderName:
+		return openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.Model), nil
+
+	case replicate.ProviderName:
+		var opts []replicate.Option
+		if cfg.Replicate.Version != "" {
+			opts = append(opts, replicate.WithVersion(cfg.Replicate.Version))
+		}
+		return replicate.NewClient(cfg.Replicate.APIToken, cfg.Replicate.Model, opts...), nil
+
+	case stability.ProviderName:
+		return stability.NewClient(cfg.Stability.APIKey, cfg.Stability.Engine), nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+}
+
+// clientOptions builds the Fusion Brain client options from the proxy and TLS settings
+func clientOptions(cfg *config.Config) ([]fusionbrain.Option, error) {
+	var opts []fusionbrain.Option
+
+	if cfg.FusionBrain.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.FusionBrain.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		opts = append(opts, fusionbrain.WithProxy(proxyURL))
+	}
+
+	if cfg.FusionBrain.CAFile != "" || cfg.FusionBrain.InsecureSkipVerify {
+		tlsConfig := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cfg.FusionBrain.InsecureSkipVerify,
+		}
+
+		if cfg.FusionBrain.CAFile != "" {
+			pem, err := os.ReadFile(cfg.FusionBrain.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", cfg.FusionBrain.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		opts = append(opts, fusionbrain.WithTLSConfig(tlsConfig))
+	}
+
+	return opts, nil
+}
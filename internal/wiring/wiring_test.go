@@ -0,0 +1,105 @@
+package wiring_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/wiring"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+)
+
+func TestNewProvider(t *testing.T) {
+	cfg := &config.Config{
+		FusionBrain: config.FusionBrainConfig{APIKey: "key", SecretKey: "secret"},
+		OpenAI:      config.OpenAIConfig{APIKey: "sk-test"},
+		Replicate:   config.ReplicateConfig{APIToken: "r8_test", Model: "owner/model"},
+		Stability:   config.StabilityConfig{APIKey: "sk-test"},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, name := range []string{"fusionbrain", "openai", "replicate", "stability"} {
+		t.Run(name, func(t *testing.T) {
+			provider, err := wiring.NewProvider(name, cfg, metrics.Nop{}, logger)
+			if err != nil {
+				t.Fatalf("NewProvider(%q) error = %v", name, err)
+			}
+			if provider.Name() != name {
+				t.Errorf("NewProvider(%q) created provider %q", name, provider.Name())
+			}
+		})
+	}
+
+	if _, err := wiring.NewProvider("midjourney", cfg, metrics.Nop{}, logger); err == nil {
+		t.Error("NewProvider() of an unknown provider error = nil")
+	}
+}
+
+func TestNewServiceFallbackChain(t *testing.T) {
+	cfg := &config.Config{
+		Providers: []string{"openai", "stability"},
+		OpenAI:    config.OpenAIConfig{APIKey: "sk-test"},
+		Stability: config.StabilityConfig{APIKey: "sk-test"},
+	}
+	svc, err := wiring.NewService(cfg, metrics.Nop{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	// The providers are chained in the configured order
+	if next, ok := svc.NextProvider("openai"); !ok || next != "stability" {
+		t.Errorf("NextProvider(openai) = %q, %v, want stability", next, ok)
+	}
+	if next, ok := svc.NextProvider("stability"); ok {
+		t.Errorf("NextProvider(stability) = %q, want the end of the chain", next)
+	}
+
+	if _, err := wiring.NewService(&config.Config{}, metrics.Nop{}, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewService() without providers error = nil")
+	}
+}
+
+func TestWorkflowConfig(t *testing.T) {
+	cfg := &config.Config{
+		GeneratorInterval: 2 * time.Second,
+		ProcessorInterval: 3 * time.Second,
+		PerImageTimeout:   time.Minute,
+		CheckInterval:     4 * time.Second,
+		PollMaxInterval:   20 * time.Second,
+		MaxAttempts:       7,
+		NotFoundMaxResets: 2,
+		Workflow: config.WorkflowConfig{
+			Concurrency:     6,
+			BatchSize:       50,
+			WorkerID:        "worker-a",
+			ErrorBackoffMax: time.Minute,
+		},
+	}
+	got := wiring.WorkflowConfig(cfg)
+	if got.GeneratorInterval != cfg.GeneratorInterval || got.ProcessorInterval != cfg.ProcessorInterval || got.PublisherInterval <= 0 {
+		t.Errorf("intervals = %v, %v, %v, want the configured ones and a publisher interval", got.GeneratorInterval, got.ProcessorInterval, got.PublisherInterval)
+	}
+	if got.Concurrency != 6 || got.BatchSize != 50 || got.WorkerID != "worker-a" || got.ErrorBackoffMax != time.Minute {
+		t.Errorf("workflow settings = %+v, want the WORKFLOW_* settings", got)
+	}
+	if got.PerImageTimeout != time.Minute || got.CheckInterval != 4*time.Second || got.PollMaxInterval != 20*time.Second ||
+		got.MaxAttempts != 7 || got.NotFoundMaxResets != 2 {
+		t.Errorf("status check settings = %+v, want the configured ones", got)
+	}
+}
+
+func TestPromptProcessorsStripTemplate(t *testing.T) {
+	cfg := &config.Config{PromptSuffix: ", gore and all", PromptBannedWords: []string{"gore"}}
+	prompt := "a lighthouse"
+	for _, p := range wiring.PromptProcessors(cfg) {
+		var err error
+		if prompt, err = p.Process(context.Background(), prompt); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+	if strings.Contains(prompt, "gore") {
+		t.Errorf("processed prompt = %q, want the banned word of the suffix stripped", prompt)
+	}
+}
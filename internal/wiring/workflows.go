@@ -0,0 +1,65 @@
+package wiring
+
+import (
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/config"
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// publishInterval is the polling interval of the publisher workflow while images are queued
+const publishInterval = 5 * time.Second
+
+// WorkflowConfig returns the settings of the image workflows
+func WorkflowConfig(cfg *config.Config) workflows.Config {
+	return workflows.Config{
+		GeneratorInterval:        cfg.GeneratorInterval,
+		ProcessorInterval:        cfg.ProcessorInterval,
+		PublisherInterval:        publishInterval,
+		IdleBackoffMax:           cfg.Workflow.IdleBackoffMax,
+		ErrorBackoffMax:          cfg.Workflow.ErrorBackoffMax,
+		BatchSize:                cfg.Workflow.BatchSize,
+		Concurrency:              cfg.Workflow.Concurrency,
+		PerImageTimeout:          cfg.PerImageTimeout,
+		WorkerID:                 cfg.Workflow.WorkerID,
+		ClaimLease:               cfg.Workflow.ClaimLease,
+		DefaultImageWidth:        cfg.DefaultImageWidth,
+		DefaultImageHeight:       cfg.DefaultImageHeight,
+		DefaultNumImages:         cfg.DefaultNumImages,
+		DefaultStyle:             cfg.DefaultStyle,
+		DefaultNegativePrompt:    cfg.DefaultNegativePrompt,
+		MaxPromptLength:          cfg.MaxPromptLength,
+		PromptRejectTerms:        cfg.PromptRejectTerms,
+		DedupMode:                cfg.DedupMode,
+		DailyRequestCap:          cfg.DailyRequestCap,
+		DailySpendCap:            cfg.DailySpendCap,
+		RequestCost:              cfg.RequestCost,
+		CensoredRequeue:          cfg.CensoredRequeue,
+		CensoredNegativePrompt:   cfg.CensoredNegativePrompt,
+		CaptureRawResponses:      cfg.CaptureRawResponses,
+		RawResponseMaxBytes:      cfg.RawResponseMaxBytes,
+		LegacyBase64:             cfg.LegacyBase64,
+		CheckInterval:            cfg.CheckInterval,
+		PollMaxInterval:          cfg.PollMaxInterval,
+		MaxAttempts:              cfg.MaxAttempts,
+		GenerationTimeout:        cfg.GenerationTimeout,
+		NotFoundMaxResets:        cfg.NotFoundMaxResets,
+		PublishMaxAttempts:       cfg.PublishMaxAttempts,
+		RetryBaseDelay:           cfg.Workflow.RetryBaseDelay,
+		RetryMaxDelay:            cfg.Workflow.RetryMaxDelay,
+		RequeueFailedAfter:       cfg.RequeueFailedAfter,
+		RequeueFailedMaxAttempts: cfg.RequeueFailedMaxAttempts,
+		EventRetention:           cfg.EventRetention,
+	}
+}
+
+// ResultsConfig returns the settings of the writer of finished generations
+func ResultsConfig(cfg *config.Config) results.Config {
+	return results.Config{
+		Filename:         cfg.StorageFilename,
+		ThumbnailMaxEdge: cfg.ThumbnailMaxEdge,
+		KeepOriginal:     cfg.PostProcessKeepOriginal,
+		LegacyBase64:     cfg.LegacyBase64,
+	}
+}
@@ -0,0 +1,122 @@
+// Package clock abstracts the passage of time, so that polling, backoff and deadlines can be
+// driven by a Fake in tests instead of real waiting.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers
+type Clock interface {
+	Now() time.Time
+	// NewTimer creates a timer that fires once after d
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a ticker that fires every d
+	NewTicker(d time.Duration) Ticker
+	// After returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is a single event created by a Clock, like time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a repeating event created by a Clock, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real returns the Clock of the time package
+func Real() Clock {
+	return realClock{}
+}
+
+// realClock implements Clock with the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Sleep waits for d on c, returning early with the error of ctx once it is done
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+// WithTimeout is context.WithTimeout with the deadline measured on c. The context of any other
+// clock than Real reports the deadline of parent, since the standard library compares deadlines
+// with the real time.
+func WithTimeout(parent context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx := &timeoutContext{Context: parent, done: make(chan struct{})}
+	t := c.NewTimer(d)
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx.finish(parent.Err())
+		case <-t.C():
+			ctx.finish(context.DeadlineExceeded)
+		case <-ctx.done:
+		}
+	}()
+	return ctx, func() {
+		t.Stop()
+		ctx.finish(context.Canceled)
+	}
+}
+
+// timeoutContext is the context of WithTimeout for clocks other than Real. Its values and
+// deadline are those of the embedded parent.
+type timeoutContext struct {
+	context.Context
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *timeoutContext) Done() <-chan struct{} { return c.done }
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// finish ends the context with err unless it has already ended
+func (c *timeoutContext) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
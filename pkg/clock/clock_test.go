@@ -0,0 +1,205 @@
+package clock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+)
+
+// start is the time every fake clock of the tests starts at
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// received returns the value waiting on c, failing the test when there is none
+func received(t *testing.T, c <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case at := <-c:
+		return at
+	default:
+		t.Fatal("channel has no value, want one")
+		return time.Time{}
+	}
+}
+
+// empty fails the test when a value is waiting on c
+func empty(t *testing.T, c <-chan time.Time) {
+	t.Helper()
+	select {
+	case at := <-c:
+		t.Fatalf("channel received %v, want nothing yet", at)
+	default:
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	clk := clock.NewFake(start)
+	timer := clk.NewTimer(2 * time.Second)
+
+	clk.Advance(2*time.Second - time.Nanosecond)
+	empty(t, timer.C())
+	clk.Advance(time.Nanosecond)
+	if at := received(t, timer.C()); !at.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("timer fired at %v, want %v", at, start.Add(2*time.Second))
+	}
+
+	// A stopped timer never fires, and a reset one fires after its new duration
+	if timer.Stop() {
+		t.Error("Stop() of a fired timer = true, want false")
+	}
+	timer.Reset(time.Second)
+	if !timer.Stop() {
+		t.Error("Stop() of a pending timer = false, want true")
+	}
+	clk.Advance(time.Hour)
+	empty(t, timer.C())
+
+	timer.Reset(0)
+	received(t, timer.C())
+}
+
+func TestFakeAdvanceFiresInOrder(t *testing.T) {
+	clk := clock.NewFake(start)
+	ticker := clk.NewTicker(3 * time.Second)
+	late := clk.NewTimer(5 * time.Second)
+	early := clk.After(time.Second)
+
+	// Each waiter sees the clock at its own due time, not at the end of the advance
+	clk.Advance(10 * time.Second)
+	if at := received(t, early); !at.Equal(start.Add(time.Second)) {
+		t.Errorf("early timer fired at %v, want %v", at, start.Add(time.Second))
+	}
+	if at := received(t, late.C()); !at.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("late timer fired at %v, want %v", at, start.Add(5*time.Second))
+	}
+	// The ticks at 6 and 9s are dropped while the one at 3s is unread
+	if at := received(t, ticker.C()); !at.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("ticker fired at %v, want %v", at, start.Add(3*time.Second))
+	}
+	empty(t, ticker.C())
+	if got := clk.Now(); !got.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(10*time.Second))
+	}
+
+	clk.Advance(2 * time.Second)
+	if at := received(t, ticker.C()); !at.Equal(start.Add(12 * time.Second)) {
+		t.Errorf("ticker fired at %v, want %v", at, start.Add(12*time.Second))
+	}
+	ticker.Stop()
+	clk.Advance(time.Minute)
+	empty(t, ticker.C())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	clk := clock.NewFake(start)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		clk.BlockUntil(2)
+	}()
+
+	clk.NewTimer(time.Second)
+	select {
+	case <-done:
+		t.Fatal("BlockUntil(2) returned with one timer pending")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.NewTicker(time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BlockUntil(2) did not return with two timers pending")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	clk := clock.NewFake(start)
+	errs := make(chan error, 1)
+	go func() { errs <- clock.Sleep(context.Background(), clk, time.Minute) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	if err := <-errs; err != nil {
+		t.Errorf("Sleep() error = %v, want nil once the clock advanced", err)
+	}
+
+	// Cancelling the context ends the sleep without advancing the clock
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { errs <- clock.Sleep(ctx, clk, time.Minute) }()
+	clk.BlockUntil(1)
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWithTimeoutOnFake(t *testing.T) {
+	clk := clock.NewFake(start)
+	ctx, cancel := clock.WithTimeout(context.Background(), clk, time.Minute)
+	defer cancel()
+
+	clk.Advance(time.Minute - time.Nanosecond)
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("Err() before the deadline = %v, want nil", err)
+	}
+	clk.Advance(time.Nanosecond)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not done after the deadline passed on the clock")
+	}
+	if err := ctx.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Err() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWithTimeoutEnds(t *testing.T) {
+	type key struct{}
+	tests := []struct {
+		name string
+		end  func(parentCancel, cancel context.CancelFunc)
+		want error
+	}{
+		{name: "cancel", end: func(_, cancel context.CancelFunc) { cancel() }, want: context.Canceled},
+		{name: "parent canceled", end: func(parentCancel, _ context.CancelFunc) { parentCancel() }, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, parentCancel := context.WithCancel(context.WithValue(context.Background(), key{}, "v"))
+			defer parentCancel()
+			ctx, cancel := clock.WithTimeout(parent, clock.NewFake(start), time.Minute)
+			defer cancel()
+			if got := ctx.Value(key{}); got != "v" {
+				t.Errorf("Value() = %v, want the value of the parent", got)
+			}
+
+			tt.end(parentCancel, cancel)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("context not done")
+			}
+			if err := ctx.Err(); !errors.Is(err, tt.want) {
+				t.Errorf("Err() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTimeoutOnReal(t *testing.T) {
+	ctx, cancel := clock.WithTimeout(context.Background(), clock.Real(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("Deadline() reports none, want the real deadline")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not done after its timeout")
+	}
+	if err := ctx.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Err() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Workflows are the names of the workflows that can be paused
+var Workflows = []string{"generator", "processor", "publisher", "requeuer", "pruner"}
+
+// IsWorkflow reports whether name is one of Workflows
+func IsWorkflow(name string) bool {
+	return slices.Contains(Workflows, name)
+}
+
+// WorkflowControl is the pause state of a workflow, shared by every process using the database
+type WorkflowControl struct {
+	Workflow string `json:"workflow"`
+	Paused   bool   `json:"paused"`
+	// UpdatedAt is when the workflow was last paused or resumed
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Dimension limits accepted by the image providers
+const (
+	MinImageDimension  = 128
+	MaxImageDimension  = 2048
+	ImageDimensionStep = 64
+)
+
+// ErrInvalidDimensions is returned when a width or height is outside the supported grid
+var ErrInvalidDimensions = errors.New("invalid image dimensions")
+
+// ValidateDimensions checks that width and height are within [MinImageDimension, MaxImageDimension]
+// and multiples of ImageDimensionStep
+func ValidateDimensions(width, height int) error {
+	for _, d := range []struct {
+		name  string
+		value int
+	}{{"width", width}, {"height", height}} {
+		if d.value < MinImageDimension || d.value > MaxImageDimension {
+			return fmt.Errorf("%w: %s %d must be between %d and %d", ErrInvalidDimensions, d.name, d.value, MinImageDimension, MaxImageDimension)
+		}
+		if d.value%ImageDimensionStep != 0 {
+			return fmt.Errorf("%w: %s %d must be a multiple of %d", ErrInvalidDimensions, d.name, d.value, ImageDimensionStep)
+		}
+	}
+	return nil
+}
+
+// SnapDimension rounds v to the nearest multiple of ImageDimensionStep within the supported range
+func SnapDimension(v int) int {
+	v = (v + ImageDimensionStep/2) / ImageDimensionStep * ImageDimensionStep
+	if v < MinImageDimension {
+		return MinImageDimension
+	}
+	if v > MaxImageDimension {
+		return MaxImageDimension
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package domain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+func TestValidateDimensions(t *testing.T) {
+	tests := []struct {
+		width, height int
+		valid         bool
+	}{
+		{width: 128, height: 128, valid: true},
+		{width: 2048, height: 2048, valid: true},
+		{width: 1024, height: 576, valid: true},
+		{width: 64, height: 1024},
+		{width: 127, height: 1024},
+		{width: 129, height: 1024},
+		{width: 2047, height: 1024},
+		{width: 2049, height: 1024},
+		{width: 2112, height: 1024},
+		{width: 1024, height: 0},
+		{width: 1024, height: -64},
+		{width: 1024, height: 1000},
+		{width: 1024, height: 2112},
+	}
+	for _, tt := range tests {
+		err := domain.ValidateDimensions(tt.width, tt.height)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateDimensions(%d, %d) error = %v", tt.width, tt.height, err)
+		}
+		if !tt.valid && !errors.Is(err, domain.ErrInvalidDimensions) {
+			t.Errorf("ValidateDimensions(%d, %d) error = %v, want %v", tt.width, tt.height, err, domain.ErrInvalidDimensions)
+		}
+	}
+}
+
+func TestSnapDimension(t *testing.T) {
+	tests := []struct {
+		v, want int
+	}{
+		{v: -64, want: 128},
+		{v: 0, want: 128},
+		{v: 127, want: 128},
+		{v: 128, want: 128},
+		{v: 159, want: 128},
+		{v: 160, want: 192},
+		{v: 1000, want: 1024},
+		{v: 1024, want: 1024},
+		{v: 2047, want: 2048},
+		{v: 2048, want: 2048},
+		{v: 2079, want: 2048},
+		{v: 2080, want: 2048},
+		{v: 4096, want: 2048},
+	}
+	for _, tt := range tests {
+		got := domain.SnapDimension(tt.v)
+		if got != tt.want {
+			t.Errorf("SnapDimension(%d) = %d, want %d", tt.v, got, tt.want)
+		}
+		if err := domain.ValidateDimensions(got, got); err != nil {
+			t.Errorf("SnapDimension(%d) = %d, which is invalid: %v", tt.v, got, err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package domain defines the images, generation requests and statuses shared by every other
+// package, the interfaces providers and services implement, and the errors they are matched
+// by. It has no dependencies outside the standard library.
+//
+//	req := domain.ImageGenerationRequest{
+//		Prompt: "a lighthouse at dawn", Width: 1024, Height: 1024, NumImages: 1, Style: "ANIME",
+//	}
+//	if err := req.Validate(); errors.Is(err, domain.ErrInvalidDimensions) {
+//		// ...
+//	}
+package domain
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrCensored is returned when the provider refuses or censors a generation
+	ErrCensored = errors.New("generation censored by provider")
+
+	// ErrGenerationTimeout is returned when a generation does not finish in time
+	ErrGenerationTimeout = errors.New("generation timed out")
+
+	// ErrGenerationFailed is returned when the provider reports a failed generation
+	ErrGenerationFailed = errors.New("generation failed")
+
+	// ErrQuotaExceeded is returned when the provider throttles requests or the account has run
+	// out of credits
+	ErrQuotaExceeded = errors.New("provider quota exceeded")
+
+	// ErrInvalidPrompt is returned when the provider rejects the parameters of a request
+	ErrInvalidPrompt = errors.New("invalid prompt")
+
+	// ErrProviderUnavailable is returned when the provider cannot be reached or is temporarily
+	// unable to take requests
+	ErrProviderUnavailable = errors.New("provider unavailable")
+)
+
+// GenerationFailedError is a generation the provider reported as failed, with the status
+// response it was reported in
+type GenerationFailedError struct {
+	UUID        string
+	Description string
+	// RawResponse is the status response body, nil if the provider does not keep it
+	RawResponse []byte
+}
+
+// Error implements the error interface
+func (e *GenerationFailedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrGenerationFailed, e.Description)
+}
+
+// Unwrap lets errors.Is match ErrGenerationFailed
+func (e *GenerationFailedError) Unwrap() error {
+	return ErrGenerationFailed
+}
+
+// ErrorForStatus returns the error above matching an HTTP status a provider responded with,
+// or nil when none does. Providers unwrap their API errors to it.
+func ErrorForStatus(status int) error {
+	switch {
+	case status == http.StatusTooManyRequests, status == http.StatusPaymentRequired:
+		return ErrQuotaExceeded
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return ErrInvalidPrompt
+	case status >= http.StatusInternalServerError:
+		return ErrProviderUnavailable
+	}
+	return nil
+}
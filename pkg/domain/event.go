@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// ImageEvent records a status transition of an image, who made it and why
+type ImageEvent struct {
+	ID      int         `json:"id"`
+	ImageID int         `json:"image_id"`
+	From    ImageStatus `json:"from_status"`
+	To      ImageStatus `json:"to_status"`
+	// Actor is the component that changed the status, e.g. generator or api, if known
+	Actor string `json:"actor,omitempty"`
+	// Reason explains the change, e.g. the error of a failure, if there is one
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
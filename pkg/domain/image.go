@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Image represents an image generation request and its status. Optional values that were never
+// set, such as the UUID of an image not yet submitted, are the zero value of their field.
+type Image struct {
+	ID     int         `json:"id"`
+	Prompt string      `json:"prompt"`
+	UUID   string      `json:"uuid,omitempty"`
+	Status ImageStatus `json:"status"`
+	// LegacyResult is set when the legacy base64 column holds a copy of the first generated
+	// file, which only GetImageData loads; never marshaled, see HasResult
+	LegacyResult bool  `json:"-"`
+	Seed         int64 `json:"seed,omitempty"`
+	// Width and Height override the configured defaults when non-zero
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Style and NegativePrompt override the configured defaults when non-empty
+	Style          string `json:"style,omitempty"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	// SkipWatermark excludes the image from the configured watermark
+	SkipWatermark bool `json:"skip_watermark"`
+	// Censored is set once a generation of the image has been censored
+	Censored bool `json:"censored"`
+	// Variants is the number of generated files stored for the image
+	Variants int `json:"variants"`
+	// SelectedVariant is the index of the variant that is published and served as the result
+	SelectedVariant int `json:"selected_variant"`
+	// GenerationStartedAt is when the image was submitted, zero if unknown
+	GenerationStartedAt time.Time `json:"generation_started_at"`
+	// Metadata describes the stored result once generation completed
+	Metadata ImageMetadata `json:"metadata"`
+	// CallbackURL is notified when the image finishes or fails
+	CallbackURL string `json:"callback_url,omitempty"`
+	// FileURL is a download URL of the first generated file, if the storage provides one
+	FileURL string `json:"file_url,omitempty"`
+	// PublishAttempts counts failed attempts to publish the image
+	PublishAttempts int `json:"publish_attempts"`
+	// PublishedAt is when the image was published, zero if it has not been
+	PublishedAt time.Time `json:"published_at"`
+	// PublishedURL is where the published image can be found, if the publisher reported one
+	PublishedURL string `json:"published_url,omitempty"`
+	// Priority orders images waiting for generation, higher first
+	Priority int `json:"priority"`
+	// ScheduledAt holds the image back from generation until then; zero generates it right away
+	ScheduledAt time.Time `json:"scheduled_at"`
+	// Tags are free-form labels for finding images, e.g. the campaign they belong to
+	Tags []string `json:"tags"`
+	// Params are extra generation parameters passed verbatim to the provider
+	Params map[string]interface{} `json:"params,omitempty"`
+	// Provider is the image provider that accepted the image, if any
+	Provider string `json:"provider,omitempty"`
+	// WorkerID is the generator that claimed the image last
+	WorkerID string `json:"worker_id,omitempty"`
+	// LeaseExpiresAt is when the claim of WorkerID lapses; it only applies while the image is
+	// Claimed
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	// ErrorDescription explains the latest failure of the image
+	ErrorDescription string `json:"error_description,omitempty"`
+	// RawResponse is the provider's status response of the latest failed or censored generation
+	// when CAPTURE_RAW_RESPONSES is on; only GetImage loads it
+	RawResponse json.RawMessage `json:"raw_response,omitempty"`
+	// Attempts is the number of times the image was requeued automatically after a transient failure
+	Attempts int `json:"attempts"`
+	// ResetCount is the number of times the image was reset to ReadyToGenerate because the
+	// provider no longer knew its generation
+	ResetCount int       `json:"reset_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ImageMetadata describes the first generated file of an image
+type ImageMetadata struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	ByteSize int    `json:"byte_size"`
+	Format   string `json:"format"`
+	// GenerationDuration is marshaled as generation_duration_ms
+	GenerationDuration time.Duration `json:"-"`
+}
+
+// ImageVariant is one generated file of an image, stored inline as base64 or in a storage backend
+type ImageVariant struct {
+	ID      int `json:"id"`
+	ImageID int `json:"image_id"`
+	// Index is the position of the file in the response of the provider
+	Index    int    `json:"index"`
+	Provider string `json:"provider,omitempty"`
+	// Data is the file as base64 when stored inline, never marshaled
+	Data     string `json:"-"`
+	FilePath string `json:"file_path,omitempty"`
+	// ProcessedPath is the location of the post-processed copy when the original is kept as well
+	ProcessedPath string `json:"processed_path,omitempty"`
+	// Width and Height are the dimensions of the stored file, zero if unknown
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,140 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// DryRunStatus is the generation status of requests that were logged instead of submitted
+const DryRunStatus = "DRY_RUN"
+
+// ImageGenerationRequest represents the parameters for image generation
+type ImageGenerationRequest struct {
+	Prompt         string
+	Width          int
+	Height         int
+	NumImages      int
+	Style          string
+	NegativePrompt string
+	// Seed makes the generation reproducible; zero lets the provider choose
+	Seed int64
+	// Extra holds provider parameters without a field of their own, passed verbatim. Only
+	// FusionBrain uses them, adding them to generateParams.
+	Extra map[string]interface{}
+}
+
+// ImageGenerationResponse represents the response from the image generation service
+type ImageGenerationResponse struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	// Files are the generated images as base64, never marshaled; see HasResult
+	Files            []string `json:"-"`
+	Censored         bool     `json:"censored"`
+	ErrorDescription string   `json:"error_description,omitempty"`
+	// Provider is the name of the provider that handled the request
+	Provider string `json:"provider,omitempty"`
+	// Seed is the seed reported back by the provider, zero if unknown
+	Seed int64 `json:"seed,omitempty"`
+	// Prompt is the prompt submitted after processing, empty if unknown
+	Prompt string `json:"prompt,omitempty"`
+	// SubmittedAt is when the request was submitted to the provider, zero if unknown
+	SubmittedAt time.Time `json:"submitted_at"`
+	// CompletedAt is when the generation was seen to be DONE, zero until then
+	CompletedAt time.Time `json:"completed_at"`
+	// GenerationTime is how long the provider took, as reported by it or measured between
+	// SubmittedAt and CompletedAt; zero if unknown. It is marshaled as generation_time_ms.
+	GenerationTime time.Duration `json:"-"`
+	// RawResponse is the status response body of a failed or censored generation as the
+	// provider sent it, nil if the provider does not keep it
+	RawResponse []byte `json:"-"`
+}
+
+// GenerationResult is a finished generation with its first image decoded
+type GenerationResult struct {
+	Data     []byte
+	UUID     string
+	Provider string
+	Censored bool
+	Elapsed  time.Duration
+}
+
+// ImageGenerationService defines the interface for image generation operations
+type ImageGenerationService interface {
+	// GenerateImage generates an image based on the provided prompt
+	GenerateImage(ctx context.Context, req ImageGenerationRequest) (*ImageGenerationResponse, error)
+
+	// CheckGenerationStatus checks the status of an image generation request
+	CheckGenerationStatus(ctx context.Context, uuid string) (*ImageGenerationResponse, error)
+
+	// GenerateAndWait submits the request and blocks until the image is ready
+	GenerateAndWait(ctx context.Context, req ImageGenerationRequest) (*GenerationResult, error)
+}
+
+// ImageProvider defines a backend that generates images, e.g. Fusion Brain
+type ImageProvider interface {
+	// Name returns a short identifier of the provider
+	Name() string
+
+	// GenerateImage submits an image generation request to the provider
+	GenerateImage(ctx context.Context, req ImageGenerationRequest) (*ImageGenerationResponse, error)
+
+	// CheckGenerationStatus checks the status of a previously submitted request
+	CheckGenerationStatus(ctx context.Context, uuid string) (*ImageGenerationResponse, error)
+}
+
+// ProviderChain is implemented by services and providers that try several providers in order
+type ProviderChain interface {
+	// NextProvider returns the provider tried after the named one, if there is one
+	NextProvider(name string) (string, bool)
+}
+
+// providerKey is the context key of the provider set by ContextWithProvider
+type providerKey struct{}
+
+// ContextWithProvider names the provider a request made with ctx is meant for: the one that
+// accepted a generation whose status is checked, or the one a provider chain submits to first.
+// Single providers ignore it.
+func ContextWithProvider(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, providerKey{}, name)
+}
+
+// ProviderFromContext returns the provider set by ContextWithProvider, empty if there is none
+func ProviderFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(providerKey{}).(string)
+	return name
+}
+
+// ResultCache stores finished generations keyed by their request parameters
+type ResultCache interface {
+	// Get returns the cached response for key, or nil if there is none or it expired
+	Get(ctx context.Context, key string) (*ImageGenerationResponse, error)
+
+	// Set stores the response for key for the given time to live
+	Set(ctx context.Context, key string, resp *ImageGenerationResponse, ttl time.Duration) error
+
+	// EvictExpired removes the expired entries and returns how many there were
+	EvictExpired(ctx context.Context) (int, error)
+}
+
+// ResultCacher is implemented by services that cache results. A generation that finishes after
+// it was submitted is cached by whoever sees it finish, under the request it was submitted
+// with, which the service cannot keep across passes and processes.
+type ResultCacher interface {
+	// CacheResult stores resp, a finished generation of req, if it is cacheable
+	CacheResult(ctx context.Context, req ImageGenerationRequest, resp *ImageGenerationResponse)
+}
+
+// PromptProcessor rewrites a prompt before it is submitted to a provider
+type PromptProcessor interface {
+	// Process returns the rewritten prompt, or an error if the prompt must not be submitted
+	Process(ctx context.Context, prompt string) (string, error)
+}
+
+// Publisher ships a finished image to an external destination, e.g. a Telegram chat
+type Publisher interface {
+	// Name returns a short identifier of the publisher
+	Name() string
+
+	// Publish ships the decoded image and returns a URL it can be found at, or an empty string
+	Publish(ctx context.Context, img *Image, data []byte) (string, error)
+}
@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// HasResult reports whether a generated file of the image is stored, inline or as a variant
+func (img *Image) HasResult() bool {
+	return img.Variants > 0 || img.LegacyResult
+}
+
+// Age is how long ago the image was queued at now, zero if unknown
+func (img *Image) Age(now time.Time) time.Duration {
+	if img.CreatedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(img.CreatedAt)
+}
+
+// MarshalJSON writes the image without its image data, adding has_result and age_seconds and
+// leaving out timestamps that are not set
+func (img Image) MarshalJSON() ([]byte, error) {
+	// image has the fields of Image without its methods, so marshaling it does not recurse
+	type image Image
+	return json.Marshal(struct {
+		image
+		GenerationStartedAt *time.Time `json:"generation_started_at,omitempty"`
+		PublishedAt         *time.Time `json:"published_at,omitempty"`
+		ScheduledAt         *time.Time `json:"scheduled_at,omitempty"`
+		LeaseExpiresAt      *time.Time `json:"lease_expires_at,omitempty"`
+		HasResult           bool       `json:"has_result"`
+		AgeSeconds          int64      `json:"age_seconds"`
+	}{
+		image:               image(img),
+		GenerationStartedAt: optionalTime(img.GenerationStartedAt),
+		PublishedAt:         optionalTime(img.PublishedAt),
+		ScheduledAt:         optionalTime(img.ScheduledAt),
+		LeaseExpiresAt:      optionalTime(img.LeaseExpiresAt),
+		HasResult:           img.HasResult(),
+		AgeSeconds:          int64(img.Age(time.Now()) / time.Second),
+	})
+}
+
+// MarshalJSON writes the metadata with the generation duration in milliseconds
+func (m ImageMetadata) MarshalJSON() ([]byte, error) {
+	type metadata ImageMetadata
+	return json.Marshal(struct {
+		metadata
+		GenerationDurationMS int64 `json:"generation_duration_ms"`
+	}{metadata(m), m.GenerationDuration.Milliseconds()})
+}
+
+// HasResult reports whether the response carries generated files
+func (r *ImageGenerationResponse) HasResult() bool {
+	return len(r.Files) > 0
+}
+
+// MarshalJSON writes the response without its files, adding has_result and the generation time
+// in milliseconds and leaving out timestamps that are not set
+func (r ImageGenerationResponse) MarshalJSON() ([]byte, error) {
+	type response ImageGenerationResponse
+	return json.Marshal(struct {
+		response
+		SubmittedAt      *time.Time `json:"submitted_at,omitempty"`
+		CompletedAt      *time.Time `json:"completed_at,omitempty"`
+		GenerationTimeMS int64      `json:"generation_time_ms,omitempty"`
+		HasResult        bool       `json:"has_result"`
+	}{
+		response:         response(r),
+		SubmittedAt:      optionalTime(r.SubmittedAt),
+		CompletedAt:      optionalTime(r.CompletedAt),
+		GenerationTimeMS: r.GenerationTime.Milliseconds(),
+		HasResult:        r.HasResult(),
+	})
+}
+
+// ImageSummary is the short view of an image for listings
+type ImageSummary struct {
+	ID        int         `json:"id"`
+	Prompt    string      `json:"prompt"`
+	Status    ImageStatus `json:"status"`
+	Provider  string      `json:"provider,omitempty"`
+	Tags      []string    `json:"tags"`
+	HasResult bool        `json:"has_result"`
+	Variants  int         `json:"variants"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Summary returns the short view of the image
+func (img *Image) Summary() ImageSummary {
+	tags := img.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return ImageSummary{
+		ID:        img.ID,
+		Prompt:    img.Prompt,
+		Status:    img.Status,
+		Provider:  img.Provider,
+		Tags:      tags,
+		HasResult: img.HasResult(),
+		Variants:  img.Variants,
+		CreatedAt: img.CreatedAt,
+		UpdatedAt: img.UpdatedAt,
+	}
+}
+
+// optionalTime returns nil for the zero time, so it can be left out of JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,166 @@
+package domain_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// ageSeconds matches the age of an image, the one field measured against the current time
+var ageSeconds = regexp.MustCompile(`"age_seconds": -?\d+`)
+
+// checkGolden marshals v indented and compares it with testdata/name, rewriting the file
+// instead with -update
+func checkGolden(t *testing.T, name string, v interface{}) []byte {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	got = append(ageSeconds.ReplaceAll(got, []byte(`"age_seconds": "<age>"`)), '\n')
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+		return got
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("JSON differs from %s (run go test -update to accept it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+	return got
+}
+
+// at is a fixed time on 2024-05-01 UTC, offset by d
+func at(d time.Duration) time.Time {
+	return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(d)
+}
+
+// fullImage returns an image with every field set
+func fullImage() domain.Image {
+	return domain.Image{
+		ID:                  42,
+		Prompt:              "a lighthouse at dawn",
+		UUID:                "fb-0b7a",
+		Status:              domain.StatusPublished,
+		LegacyResult:        true,
+		Seed:                1234,
+		Width:               1024,
+		Height:              768,
+		Style:               "ANIME",
+		NegativePrompt:      "fog",
+		SkipWatermark:       true,
+		Variants:            2,
+		SelectedVariant:     1,
+		GenerationStartedAt: at(-time.Hour),
+		Metadata:            domain.ImageMetadata{Width: 1024, Height: 768, ByteSize: 183_204, Format: "png", GenerationDuration: 41_500 * time.Millisecond},
+		CallbackURL:         "https://example.com/hook",
+		FileURL:             "https://cdn.example.com/42.png",
+		PublishAttempts:     1,
+		PublishedAt:         at(-10 * time.Minute),
+		PublishedURL:        "https://t.me/channel/7",
+		Priority:            5,
+		ScheduledAt:         at(-2 * time.Hour),
+		Tags:                []string{"blog", "spring"},
+		Params:              map[string]interface{}{"guidance": 7},
+		Provider:            "fusionbrain",
+		WorkerID:            "host-1:4242",
+		LeaseExpiresAt:      at(-55 * time.Minute),
+		ErrorDescription:    "publisher timed out",
+		RawResponse:         json.RawMessage(`{"status":"DONE"}`),
+		Attempts:            2,
+		ResetCount:          1,
+		CreatedAt:           at(-3 * time.Hour),
+		UpdatedAt:           at(-10 * time.Minute),
+	}
+}
+
+func TestImageJSON(t *testing.T) {
+	got := checkGolden(t, "image.json.golden", fullImage())
+	if bytes.Contains(got, []byte("legacy")) {
+		t.Errorf("marshaled image = %s, want no legacy result flag", got)
+	}
+
+	// Unset timestamps are left out and the tags are an empty list rather than null
+	checkGolden(t, "image_minimal.json.golden", domain.Image{ID: 1, Prompt: "a lighthouse", Status: domain.StatusReadyToGenerate, Tags: []string{}})
+}
+
+func TestImageJSONAge(t *testing.T) {
+	img := domain.Image{ID: 1, CreatedAt: time.Now().Add(-90 * time.Second)}
+	data, err := json.Marshal(img)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var decoded struct {
+		AgeSeconds int64 `json:"age_seconds"`
+	}
+	json.Unmarshal(data, &decoded)
+	if decoded.AgeSeconds < 90 || decoded.AgeSeconds > 95 {
+		t.Errorf("age_seconds = %d, want about 90", decoded.AgeSeconds)
+	}
+}
+
+func TestImageGenerationResponseJSON(t *testing.T) {
+	got := checkGolden(t, "response.json.golden", domain.ImageGenerationResponse{
+		UUID:             "fb-0b7a",
+		Status:           "DONE",
+		Files:            []string{"iVBORw0KGgoAAAANSUhEUg=="},
+		Censored:         true,
+		ErrorDescription: "content policy violation",
+		Provider:         "fusionbrain",
+		Seed:             1234,
+		Prompt:           "a lighthouse at dawn",
+		SubmittedAt:      at(-time.Minute),
+		CompletedAt:      at(0),
+		GenerationTime:   41_500 * time.Millisecond,
+		RawResponse:      []byte(`{"status":"DONE"}`),
+	})
+	// The files are only reported as has_result
+	if bytes.Contains(got, []byte("iVBORw0KGgo")) || !bytes.Contains(got, []byte(`"has_result": true`)) {
+		t.Errorf("marshaled response = %s, want the files left out", got)
+	}
+
+	checkGolden(t, "response_pending.json.golden", domain.ImageGenerationResponse{UUID: "fb-0b7a", Status: "INITIAL"})
+}
+
+func TestImageSummaryJSON(t *testing.T) {
+	img := fullImage()
+	checkGolden(t, "summary.json.golden", img.Summary())
+
+	bare := domain.Image{ID: 1, Prompt: "a lighthouse", Status: domain.StatusReadyToGenerate}
+	if data, _ := json.Marshal(bare.Summary()); !strings.Contains(string(data), `"tags":[]`) {
+		t.Errorf("summary = %s, want the tags as an empty list", data)
+	}
+}
+
+func TestImageJSONRoundTrip(t *testing.T) {
+	// The wire format decodes back into the fields it carries
+	want := fullImage()
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got domain.Image
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got.ID != want.ID || got.Status != want.Status || !got.PublishedAt.Equal(want.PublishedAt) || !got.CreatedAt.Equal(want.CreatedAt) ||
+		got.Params["guidance"] != float64(7) || string(got.RawResponse) != string(want.RawResponse) || got.LegacyResult {
+		t.Errorf("decoded image = %+v, want %+v without the legacy result", got, want)
+	}
+}
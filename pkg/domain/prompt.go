@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NormalizePrompt trims the prompt and collapses runs of whitespace into single spaces
+func NormalizePrompt(prompt string) string {
+	return strings.Join(strings.Fields(prompt), " ")
+}
+
+// PromptHash returns the SHA-256 of the normalized prompt together with the image's own
+// generation parameters, used to detect duplicate requests. A width or height left to the
+// defaults is hashed as defaultWidth or defaultHeight, so it matches a request stating them.
+func (img *Image) PromptHash(defaultWidth, defaultHeight int) string {
+	width, height := img.Width, img.Height
+	if width == 0 {
+		width = defaultWidth
+	}
+	if height == 0 {
+		height = defaultHeight
+	}
+	key := NormalizePrompt(img.Prompt)
+	if width != 0 || height != 0 {
+		key += fmt.Sprintf("\x00%dx%d", width, height)
+	}
+	if img.Style != "" || img.NegativePrompt != "" {
+		key += fmt.Sprintf("\x00%s\x00%s", img.Style, NormalizePrompt(img.NegativePrompt))
+	}
+	// A pinned seed or extra parameters ask for a different picture of the same prompt
+	if img.Seed != 0 {
+		key += fmt.Sprintf("\x00seed=%d", img.Seed)
+	}
+	if len(img.Params) > 0 {
+		// Maps are marshaled with sorted keys, so equal parameters hash the same
+		params, _ := json.Marshal(img.Params)
+		key += "\x00params=" + string(params)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// BannedTerm is a word, phrase or regular expression that gets prompts rejected
+type BannedTerm struct {
+	ID    int
+	Term  string
+	Regex bool
+}
+
+// PromptSource is what produced a revision of a prompt
+type PromptSource string
+
+const (
+	// PromptSourceAuthor is the prompt as it was queued
+	PromptSourceAuthor PromptSource = "author"
+	// PromptSourceTruncation is the prompt cut down to MAX_PROMPT_LENGTH by the generator
+	PromptSourceTruncation PromptSource = "truncation"
+	// PromptSourceProcessor is the prompt rewritten by the prompt processors of the service
+	PromptSourceProcessor PromptSource = "processor"
+	// PromptSourceManual is the prompt edited by hand
+	PromptSourceManual PromptSource = "manual"
+)
+
+// PromptRevision is one version of the prompt of an image, numbered from 1 for the author's
+type PromptRevision struct {
+	ImageID   int          `json:"image_id"`
+	Revision  int          `json:"revision"`
+	Text      string       `json:"text"`
+	Source    PromptSource `json:"source"`
+	CreatedAt time.Time    `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+func TestPromptHash(t *testing.T) {
+	base := domain.Image{Prompt: "a lighthouse"}
+	hash := base.PromptHash(1024, 1024)
+	tests := []struct {
+		name string
+		img  domain.Image
+		same bool
+	}{
+		{name: "normalized prompt", img: domain.Image{Prompt: "  a   lighthouse "}, same: true},
+		{name: "default size stated", img: domain.Image{Prompt: "a lighthouse", Width: 1024, Height: 1024}, same: true},
+		{name: "default width stated", img: domain.Image{Prompt: "a lighthouse", Width: 1024}, same: true},
+		{name: "other size", img: domain.Image{Prompt: "a lighthouse", Width: 512, Height: 512}},
+		{name: "other style", img: domain.Image{Prompt: "a lighthouse", Style: "ANIME"}},
+		{name: "negative prompt", img: domain.Image{Prompt: "a lighthouse", NegativePrompt: "fog"}},
+		{name: "seed", img: domain.Image{Prompt: "a lighthouse", Seed: 42}},
+		{name: "params", img: domain.Image{Prompt: "a lighthouse", Params: map[string]interface{}{"steps": 30}}},
+	}
+	for _, tt := range tests {
+		if got := tt.img.PromptHash(1024, 1024); (got == hash) != tt.same {
+			t.Errorf("%s: PromptHash() equal to the plain prompt's = %v, want %v", tt.name, got == hash, tt.same)
+		}
+	}
+
+	seeded := domain.Image{Prompt: "a lighthouse", Seed: 7}
+	if seeded.PromptHash(1024, 1024) == (&domain.Image{Prompt: "a lighthouse", Seed: 8}).PromptHash(1024, 1024) {
+		t.Error("PromptHash() of different seeds is equal")
+	}
+	a := domain.Image{Prompt: "a lighthouse", Params: map[string]interface{}{"steps": 30, "guidance": 7}}
+	b := domain.Image{Prompt: "a lighthouse", Params: map[string]interface{}{"guidance": 7, "steps": 30}}
+	if a.PromptHash(1024, 1024) != b.PromptHash(1024, 1024) {
+		t.Error("PromptHash() depends on the order the params were set in")
+	}
+	// The defaults only fill in a size left unset
+	if base.PromptHash(1024, 1024) == base.PromptHash(512, 512) {
+		t.Error("PromptHash() ignores the default size")
+	}
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNumImages is the largest number of images a single request may ask for
+const MaxNumImages = 10
+
+// ErrInvalidRequest is wrapped by violations of ImageGenerationRequest.Validate that concern
+// neither the prompt nor the dimensions
+var ErrInvalidRequest = errors.New("invalid generation request")
+
+// ValidateOption configures ImageGenerationRequest.Validate
+type ValidateOption func(*validation)
+
+// validation holds the limits ImageGenerationRequest.Validate checks against
+type validation struct {
+	maxPromptLength int
+}
+
+// WithMaxPromptLength rejects prompts longer than n characters; without it the length is not
+// limited
+func WithMaxPromptLength(n int) ValidateOption {
+	return func(v *validation) {
+		v.maxPromptLength = n
+	}
+}
+
+// Validate checks the request before it is sent to a provider and returns every violation
+// joined, or nil. Violations wrap ErrInvalidPrompt, ErrInvalidDimensions or ErrInvalidRequest.
+func (r ImageGenerationRequest) Validate(opts ...ValidateOption) error {
+	var v validation
+	for _, opt := range opts {
+		opt(&v)
+	}
+
+	var errs []error
+	if strings.TrimSpace(r.Prompt) == "" {
+		errs = append(errs, fmt.Errorf("%w: prompt is required", ErrInvalidPrompt))
+	} else if length := utf8.RuneCountInString(r.Prompt); v.maxPromptLength > 0 && length > v.maxPromptLength {
+		errs = append(errs, fmt.Errorf("%w: prompt has %d characters, at most %d are allowed", ErrInvalidPrompt, length, v.maxPromptLength))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value int
+	}{{"width", r.Width}, {"height", r.Height}} {
+		if d.value < MinImageDimension || d.value > MaxImageDimension {
+			errs = append(errs, fmt.Errorf("%w: %s %d must be between %d and %d", ErrInvalidDimensions, d.name, d.value, MinImageDimension, MaxImageDimension))
+		} else if d.value%ImageDimensionStep != 0 {
+			errs = append(errs, fmt.Errorf("%w: %s %d must be a multiple of %d", ErrInvalidDimensions, d.name, d.value, ImageDimensionStep))
+		}
+	}
+
+	if r.NumImages < 1 || r.NumImages > MaxNumImages {
+		errs = append(errs, fmt.Errorf("%w: number of images %d must be between 1 and %d", ErrInvalidRequest, r.NumImages, MaxNumImages))
+	}
+
+	if !ValidStyle(r.Style) {
+		errs = append(errs, fmt.Errorf("%w: unknown style %q, expected one of %s", ErrInvalidRequest, r.Style, strings.Join(Styles, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
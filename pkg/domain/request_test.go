@@ -0,0 +1,112 @@
+package domain_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// validRequest returns a request passing every rule, for the cases to break one at a time
+func validRequest() domain.ImageGenerationRequest {
+	return domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 768, NumImages: 1, Style: "ANIME"}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*domain.ImageGenerationRequest)
+		wantErr error
+		wantMsg string
+	}{
+		{name: "valid", modify: func(r *domain.ImageGenerationRequest) {}},
+		{name: "empty prompt", modify: func(r *domain.ImageGenerationRequest) { r.Prompt = "" }, wantErr: domain.ErrInvalidPrompt, wantMsg: "prompt is required"},
+		{name: "blank prompt", modify: func(r *domain.ImageGenerationRequest) { r.Prompt = " \t\n" }, wantErr: domain.ErrInvalidPrompt, wantMsg: "prompt is required"},
+		{name: "prompt at the limit", modify: func(r *domain.ImageGenerationRequest) { r.Prompt = strings.Repeat("a", 1000) }},
+		{name: "prompt too long", modify: func(r *domain.ImageGenerationRequest) { r.Prompt = strings.Repeat("a", 5000) }, wantErr: domain.ErrInvalidPrompt, wantMsg: "prompt has 5000 characters, at most 1000 are allowed"},
+		// The limit counts characters rather than bytes
+		{name: "multi-byte prompt at the limit", modify: func(r *domain.ImageGenerationRequest) { r.Prompt = strings.Repeat("маяк", 250) }},
+		{name: "width too small", modify: func(r *domain.ImageGenerationRequest) { r.Width = 17 }, wantErr: domain.ErrInvalidDimensions, wantMsg: "width 17 must be between 128 and 2048"},
+		{name: "width zero", modify: func(r *domain.ImageGenerationRequest) { r.Width = 0 }, wantErr: domain.ErrInvalidDimensions, wantMsg: "width 0 must be between 128 and 2048"},
+		{name: "height too large", modify: func(r *domain.ImageGenerationRequest) { r.Height = 2112 }, wantErr: domain.ErrInvalidDimensions, wantMsg: "height 2112 must be between 128 and 2048"},
+		{name: "height off the grid", modify: func(r *domain.ImageGenerationRequest) { r.Height = 1000 }, wantErr: domain.ErrInvalidDimensions, wantMsg: "height 1000 must be a multiple of 64"},
+		{name: "no images", modify: func(r *domain.ImageGenerationRequest) { r.NumImages = 0 }, wantErr: domain.ErrInvalidRequest, wantMsg: "number of images 0 must be between 1 and 10"},
+		{name: "too many images", modify: func(r *domain.ImageGenerationRequest) { r.NumImages = 11 }, wantErr: domain.ErrInvalidRequest, wantMsg: "number of images 11 must be between 1 and 10"},
+		{name: "most images", modify: func(r *domain.ImageGenerationRequest) { r.NumImages = domain.MaxNumImages }},
+		{name: "unknown style", modify: func(r *domain.ImageGenerationRequest) { r.Style = "anime" }, wantErr: domain.ErrInvalidRequest, wantMsg: `unknown style "anime", expected one of DEFAULT, KANDINSKY, UHD, ANIME`},
+		{name: "no style", modify: func(r *domain.ImageGenerationRequest) { r.Style = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRequest()
+			tt.modify(&req)
+			err := req.Validate(domain.WithMaxPromptLength(1000))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Validate() error = %v, want %v with %q", err, tt.wantErr, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestValidateWithoutPromptLimit(t *testing.T) {
+	req := validRequest()
+	req.Prompt = strings.Repeat("a", 5000)
+	if err := req.Validate(); err != nil {
+		t.Errorf("Validate() without a limit error = %v, want long prompts allowed", err)
+	}
+}
+
+func TestValidateReportsEveryViolation(t *testing.T) {
+	req := domain.ImageGenerationRequest{Prompt: "", Width: 17, Height: 1000, NumImages: 0, Style: "OIL"}
+	err := req.Validate(domain.WithMaxPromptLength(1000))
+
+	want := strings.Join([]string{
+		"invalid prompt: prompt is required",
+		"invalid image dimensions: width 17 must be between 128 and 2048",
+		"invalid image dimensions: height 1000 must be a multiple of 64",
+		"invalid generation request: number of images 0 must be between 1 and 10",
+		`invalid generation request: unknown style "OIL", expected one of DEFAULT, KANDINSKY, UHD, ANIME`,
+	}, "\n")
+	if err == nil || err.Error() != want {
+		t.Fatalf("Validate() error = %v, want\n%s", err, want)
+	}
+	for _, target := range []error{domain.ErrInvalidPrompt, domain.ErrInvalidDimensions, domain.ErrInvalidRequest} {
+		if !errors.Is(err, target) {
+			t.Errorf("Validate() error does not match %v", target)
+		}
+	}
+}
+
+func FuzzValidatePromptLength(f *testing.F) {
+	for _, prompt := range []string{"a lighthouse", "", " \t", "маяк у скалы", "🌊🌅⛵", "\xff\xfe", strings.Repeat("маяк", 300)} {
+		f.Add(prompt, 10)
+		f.Add(prompt, 1000)
+	}
+	f.Fuzz(func(t *testing.T, prompt string, limit int) {
+		if limit < 1 {
+			t.Skip("the limit is at least 1 in any configuration")
+		}
+		req := validRequest()
+		req.Prompt = prompt
+		err := req.Validate(domain.WithMaxPromptLength(limit))
+
+		// Only the prompt may break a rule, and it does so exactly when it is blank or has more
+		// characters than the limit
+		blank := strings.TrimSpace(prompt) == ""
+		tooLong := utf8.RuneCountInString(prompt) > limit
+		if got, want := errors.Is(err, domain.ErrInvalidPrompt), blank || tooLong; got != want {
+			t.Fatalf("Validate(%q, limit %d) error = %v, want an invalid prompt %v", prompt, limit, err, want)
+		}
+		if err != nil && !errors.Is(err, domain.ErrInvalidPrompt) {
+			t.Fatalf("Validate(%q, limit %d) error = %v, want only the prompt rejected", prompt, limit, err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ImageStatus is the stage of an image in the generation and publishing pipeline
+type ImageStatus string
+
+const (
+	// StatusReadyToGenerate images wait for the generator
+	StatusReadyToGenerate ImageStatus = "ReadyToGenerate"
+	// StatusClaimed images were claimed by a generator, which holds a lease on them until they
+	// are submitted
+	StatusClaimed ImageStatus = "Claimed"
+	// StatusGenerate images were submitted and wait for the processor
+	StatusGenerate ImageStatus = "Generate"
+	// StatusReadyToPublish images were generated and wait for the publisher
+	StatusReadyToPublish ImageStatus = "ReadyToPublish"
+	// StatusPublished images were published
+	StatusPublished ImageStatus = "Published"
+	// StatusPublishFailed images could not be published within PUBLISH_MAX_ATTEMPTS
+	StatusPublishFailed ImageStatus = "PublishFailed"
+	// StatusFailed images could not be generated
+	StatusFailed ImageStatus = "Failed"
+	// StatusTimedOut images were submitted but did not finish within the generation timeout
+	StatusTimedOut ImageStatus = "TimedOut"
+	// StatusDuplicate images repeat the prompt of an earlier image
+	StatusDuplicate ImageStatus = "Duplicate"
+	// StatusRejected images have a prompt containing a banned term
+	StatusRejected ImageStatus = "Rejected"
+	// StatusCensored images were censored by the provider
+	StatusCensored ImageStatus = "Censored"
+)
+
+// Statuses lists every ImageStatus in pipeline order
+var Statuses = []ImageStatus{
+	StatusReadyToGenerate,
+	StatusClaimed,
+	StatusGenerate,
+	StatusReadyToPublish,
+	StatusPublished,
+	StatusPublishFailed,
+	StatusFailed,
+	StatusTimedOut,
+	StatusDuplicate,
+	StatusRejected,
+	StatusCensored,
+}
+
+// ValidTransitions maps each status to the statuses an image may move to from it. Every
+// finished image can be requeued to StatusReadyToGenerate.
+var ValidTransitions = map[ImageStatus][]ImageStatus{
+	StatusReadyToGenerate: {StatusClaimed, StatusGenerate, StatusReadyToPublish, StatusFailed, StatusDuplicate, StatusRejected, StatusCensored},
+	StatusClaimed:         {StatusReadyToGenerate, StatusGenerate, StatusReadyToPublish, StatusFailed, StatusDuplicate, StatusRejected, StatusCensored},
+	StatusGenerate:        {StatusReadyToGenerate, StatusReadyToPublish, StatusFailed, StatusTimedOut, StatusCensored},
+	StatusReadyToPublish:  {StatusPublished, StatusPublishFailed, StatusFailed},
+	StatusPublished:       {StatusReadyToGenerate},
+	StatusPublishFailed:   {StatusReadyToGenerate},
+	StatusFailed:          {StatusReadyToGenerate},
+	StatusTimedOut:        {StatusReadyToGenerate},
+	StatusDuplicate:       {StatusReadyToGenerate},
+	StatusRejected:        {StatusReadyToGenerate},
+	StatusCensored:        {StatusReadyToGenerate},
+}
+
+// ErrInvalidTransition is matched by every TransitionError
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// TransitionError is returned when an image cannot move from its status to another
+type TransitionError struct {
+	ID   int
+	From ImageStatus
+	To   ImageStatus
+}
+
+// Error implements the error interface
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("image %d cannot move from %s to %s", e.ID, e.From, e.To)
+}
+
+// Unwrap lets errors.Is match ErrInvalidTransition
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// Valid reports whether s is one of Statuses
+func (s ImageStatus) Valid() bool {
+	_, ok := ValidTransitions[s]
+	return ok
+}
+
+// CanTransition reports whether an image may move from one status to another. Keeping the
+// status is always allowed, so repeating an update is harmless.
+func CanTransition(from, to ImageStatus) bool {
+	if from == to {
+		return from.Valid()
+	}
+	for _, s := range ValidTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionsTo returns the statuses from which an image may move to the given one, including
+// that status itself
+func TransitionsTo(to ImageStatus) []ImageStatus {
+	var from []ImageStatus
+	for _, s := range Statuses {
+		if CanTransition(s, to) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
@@ -0,0 +1,123 @@
+package domain_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// legalTransitions is the status graph spelled out edge by edge, so a change to
+// domain.ValidTransitions has to be made here as well
+var legalTransitions = map[[2]domain.ImageStatus]bool{
+	{domain.StatusReadyToGenerate, domain.StatusClaimed}:        true,
+	{domain.StatusReadyToGenerate, domain.StatusGenerate}:       true,
+	{domain.StatusReadyToGenerate, domain.StatusReadyToPublish}: true,
+	{domain.StatusReadyToGenerate, domain.StatusFailed}:         true,
+	{domain.StatusReadyToGenerate, domain.StatusDuplicate}:      true,
+	{domain.StatusReadyToGenerate, domain.StatusRejected}:       true,
+	{domain.StatusReadyToGenerate, domain.StatusCensored}:       true,
+
+	{domain.StatusClaimed, domain.StatusReadyToGenerate}: true,
+	{domain.StatusClaimed, domain.StatusGenerate}:        true,
+	{domain.StatusClaimed, domain.StatusReadyToPublish}:  true,
+	{domain.StatusClaimed, domain.StatusFailed}:          true,
+	{domain.StatusClaimed, domain.StatusDuplicate}:       true,
+	{domain.StatusClaimed, domain.StatusRejected}:        true,
+	{domain.StatusClaimed, domain.StatusCensored}:        true,
+
+	{domain.StatusGenerate, domain.StatusReadyToGenerate}: true,
+	{domain.StatusGenerate, domain.StatusReadyToPublish}:  true,
+	{domain.StatusGenerate, domain.StatusFailed}:          true,
+	{domain.StatusGenerate, domain.StatusTimedOut}:        true,
+	{domain.StatusGenerate, domain.StatusCensored}:        true,
+
+	{domain.StatusReadyToPublish, domain.StatusPublished}:     true,
+	{domain.StatusReadyToPublish, domain.StatusPublishFailed}: true,
+	{domain.StatusReadyToPublish, domain.StatusFailed}:        true,
+
+	// Every finished image can be requeued
+	{domain.StatusPublished, domain.StatusReadyToGenerate}:     true,
+	{domain.StatusPublishFailed, domain.StatusReadyToGenerate}: true,
+	{domain.StatusFailed, domain.StatusReadyToGenerate}:        true,
+	{domain.StatusTimedOut, domain.StatusReadyToGenerate}:      true,
+	{domain.StatusDuplicate, domain.StatusReadyToGenerate}:     true,
+	{domain.StatusRejected, domain.StatusReadyToGenerate}:      true,
+	{domain.StatusCensored, domain.StatusReadyToGenerate}:      true,
+}
+
+func TestCanTransition(t *testing.T) {
+	for _, from := range domain.Statuses {
+		for _, to := range domain.Statuses {
+			// Keeping the status is always allowed
+			want := from == to || legalTransitions[[2]domain.ImageStatus{from, to}]
+			if got := domain.CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestCanTransitionUnknownStatus(t *testing.T) {
+	typo := domain.ImageStatus("ReadyToGenrate")
+	for _, s := range domain.Statuses {
+		if domain.CanTransition(s, typo) || domain.CanTransition(typo, s) {
+			t.Errorf("CanTransition() allows moving between %s and the unknown %s", s, typo)
+		}
+	}
+	if domain.CanTransition(typo, typo) || domain.CanTransition("", "") {
+		t.Error("CanTransition() allows an unknown status to be kept")
+	}
+}
+
+func TestStatusesAreComplete(t *testing.T) {
+	if len(domain.Statuses) != len(domain.ValidTransitions) {
+		t.Errorf("Statuses has %d entries and ValidTransitions %d, want every status in both", len(domain.Statuses), len(domain.ValidTransitions))
+	}
+	for _, s := range domain.Statuses {
+		if !s.Valid() {
+			t.Errorf("%s.Valid() = false, want every listed status valid", s)
+		}
+	}
+	for _, s := range []domain.ImageStatus{"", "readytogenerate", "Generating", "ReadyToGenrate"} {
+		if s.Valid() {
+			t.Errorf("%q.Valid() = true, want false", s)
+		}
+	}
+}
+
+func TestTransitionsTo(t *testing.T) {
+	tests := []struct {
+		to   domain.ImageStatus
+		want []domain.ImageStatus
+	}{
+		{to: domain.StatusPublished, want: []domain.ImageStatus{domain.StatusReadyToPublish, domain.StatusPublished}},
+		{to: domain.StatusTimedOut, want: []domain.ImageStatus{domain.StatusGenerate, domain.StatusTimedOut}},
+		{to: domain.StatusCensored, want: []domain.ImageStatus{domain.StatusReadyToGenerate, domain.StatusClaimed, domain.StatusGenerate, domain.StatusCensored}},
+		{to: domain.StatusReadyToGenerate, want: []domain.ImageStatus{
+			domain.StatusReadyToGenerate, domain.StatusClaimed, domain.StatusGenerate, domain.StatusPublished, domain.StatusPublishFailed,
+			domain.StatusFailed, domain.StatusTimedOut, domain.StatusDuplicate, domain.StatusRejected, domain.StatusCensored,
+		}},
+	}
+	for _, tt := range tests {
+		if got := domain.TransitionsTo(tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TransitionsTo(%s) = %v, want %v", tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	err := fmt.Errorf("failed to update status: %w", &domain.TransitionError{ID: 7, From: domain.StatusGenerate, To: domain.StatusPublished})
+	if !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Errorf("errors.Is(%v, ErrInvalidTransition) = false, want true", err)
+	}
+	var transitionErr *domain.TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.ID != 7 {
+		t.Errorf("errors.As(%v) = %+v, want the transition", err, transitionErr)
+	}
+	if want := "failed to update status: image 7 cannot move from Generate to Published"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,17 @@
+package domain
+
+// Styles are the values of ImageGenerationRequest.Style known to the providers
+var Styles = []string{"DEFAULT", "KANDINSKY", "UHD", "ANIME"}
+
+// ValidStyle reports whether style is one of Styles; the empty style leaves it to the provider
+func ValidStyle(style string) bool {
+	if style == "" {
+		return true
+	}
+	for _, s := range Styles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
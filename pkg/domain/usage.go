@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// ProviderUsage counts the generation requests submitted to a provider on a UTC day
+type ProviderUsage struct {
+	Provider string `json:"provider"`
+	// Day is midnight UTC of the day counted
+	Day      time.Time `json:"day"`
+	Requests int       `json:"requests"`
+	// Failures are the requests the provider did not accept
+	Failures int `json:"failures"`
+}
+
+// UsageRecorder counts the generation requests submitted to providers per UTC day
+type UsageRecorder interface {
+	// RecordUsage counts a request submitted to provider on day, a failed one if failed is set
+	RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) error
+}
+
+// UsageDay returns midnight UTC of the day t falls on, which provider quotas are counted by
+func UsageDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
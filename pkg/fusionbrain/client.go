@@ -0,0 +1,444 @@
+package fusionbrain
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultBaseURL = "https://api-key.fusionbrain.ai"
+
+	// ProviderName identifies the Fusion Brain provider
+	ProviderName = "fusionbrain"
+)
+
+// Client represents the Fusion Brain API client
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	secretKey  string
+	proxyURL   *url.URL
+	tlsConfig  *tls.Config
+	metrics    metrics.Hook
+	logger     *slog.Logger
+	// secrets masks the credentials in the response bodies and descriptions the errors and
+	// results quote, in case the API or a proxy echoes the request headers
+	secrets *redact.Redactor
+
+	limiter         *rate.Limiter
+	exemptPipelines bool
+}
+
+// Option configures optional Client settings
+type Option func(*Client)
+
+// WithProxy routes all API requests through the given proxy.
+// Credentials in the URL user info are sent as Proxy-Authorization.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *Client) {
+		c.proxyURL = proxyURL
+	}
+}
+
+// WithBaseURL overrides the API base URL, e.g. for a fakeserver.Server in tests
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for API connections
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithMetrics reports the duration of every API request to the given hook
+func WithMetrics(hook metrics.Hook) Option {
+	return func(c *Client) {
+		c.metrics = hook
+	}
+}
+
+// WithLogger logs every API request at debug level to the given logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithRateLimiter makes every API request wait for a token of limiter, which may be shared
+// with other clients. With exemptPipelines the pipeline listings used to check availability
+// are sent without waiting.
+func WithRateLimiter(limiter *rate.Limiter, exemptPipelines bool) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+		c.exemptPipelines = exemptPipelines
+	}
+}
+
+// NewClient creates a new Fusion Brain API client
+func NewClient(apiKey, secretKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:   defaultBaseURL,
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		metrics:   metrics.Nop{},
+		logger:    slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	proxyPassword := ""
+	if c.proxyURL != nil {
+		proxyPassword, _ = c.proxyURL.User.Password()
+	}
+	c.secrets = redact.New(apiKey, secretKey, proxyPassword)
+
+	var transport http.RoundTripper = c.newTransport()
+	if c.limiter != nil {
+		transport = &rateLimitedTransport{
+			base:            transport,
+			limiter:         c.limiter,
+			exemptPipelines: c.exemptPipelines,
+			metrics:         c.metrics,
+		}
+	}
+
+	c.httpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+
+	return c
+}
+
+// newTransport builds the HTTP transport from the configured proxy and TLS settings.
+// Without an explicit proxy the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables apply.
+func (c *Client) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.Proxy = http.ProxyFromEnvironment
+	if c.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(c.proxyURL)
+	}
+
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig
+	}
+
+	return transport
+}
+
+// reservedGenerateParams are the generateParams keys set by the client, which extra parameters
+// may not override
+var reservedGenerateParams = []string{"query", "seed"}
+
+// mergeExtra adds the extra parameters of a request to generateParams verbatim. It fails
+// without changing generateParams when any of them is reserved.
+func mergeExtra(generateParams, extra map[string]interface{}) error {
+	var conflicts []string
+	for key := range extra {
+		if slices.Contains(reservedGenerateParams, key) {
+			conflicts = append(conflicts, key)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("extra parameters conflict with generateParams set by the client: %s", strings.Join(conflicts, ", "))
+	}
+
+	for key, value := range extra {
+		generateParams[key] = value
+	}
+	return nil
+}
+
+// Name returns the provider name
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// GenerateImage implements the image generation request
+func (c *Client) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	// First, get the pipeline ID
+	pipelineID, err := c.getPipelineID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pipeline ID: %w", err)
+	}
+
+	body, contentType, err := encodeRunRequest(pipelineID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/key/api/v1/pipeline/run", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("X-Key", "Key "+c.apiKey)
+	httpReq.Header.Set("X-Secret", "Secret "+c.secretKey)
+
+	// Send request
+	resp, err := c.do(httpReq, "run")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The run endpoint answers 201 Created with the INITIAL status for accepted requests
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp, c.secrets)
+	}
+
+	var result struct {
+		UUID   string `json:"uuid"`
+		Status string `json:"status"`
+		// PipelineStatus is set instead of a UUID when the pipeline does not take requests,
+		// e.g. DISABLED_BY_QUEUE
+		PipelineStatus string `json:"pipeline_status"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.UUID == "" && result.PipelineStatus != "" {
+		return nil, fmt.Errorf("%w: pipeline status %s", domain.ErrProviderUnavailable, c.secrets.String(result.PipelineStatus))
+	}
+
+	return &domain.ImageGenerationResponse{
+		UUID:   result.UUID,
+		Status: result.Status,
+	}, nil
+}
+
+// encodeRunRequest encodes the multipart body of a run request for req and returns it with its
+// Content-Type. The API rejects bodies whose params differ in shape, so changes here must keep
+// the field names and nesting of params.
+func encodeRunRequest(pipelineID string, req domain.ImageGenerationRequest) (*bytes.Buffer, string, error) {
+	// Prepare the request body
+	generateParams := map[string]interface{}{
+		"query": req.Prompt,
+	}
+	if req.Seed != 0 {
+		generateParams["seed"] = req.Seed
+	}
+	if err := mergeExtra(generateParams, req.Extra); err != nil {
+		return nil, "", err
+	}
+
+	params := map[string]interface{}{
+		"type":           "GENERATE",
+		"width":          req.Width,
+		"height":         req.Height,
+		"numImages":      req.NumImages,
+		"generateParams": generateParams,
+	}
+
+	if req.Style != "" {
+		params["style"] = req.Style
+	}
+
+	if req.NegativePrompt != "" {
+		params["negativePromptDecoder"] = req.NegativePrompt
+	}
+
+	// Create multipart form
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	// Add pipeline_id
+	if err := writer.WriteField("pipeline_id", pipelineID); err != nil {
+		return nil, "", fmt.Errorf("failed to write pipeline_id: %w", err)
+	}
+
+	// Add params as JSON
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	paramsPart := make(map[string][]string)
+	paramsPart["Content-Disposition"] = append(paramsPart["Content-Disposition"], "form-data; name=\"params\"")
+	paramsPart["Content-Type"] = append(paramsPart["Content-Type"], "application/json")
+
+	paramsWriter, err := writer.CreatePart(paramsPart)
+	if err != nil {
+		return nil, "", fmt.Errorf("paramsWriter - writer.CreatePart: %s", err)
+	}
+
+	_, err = paramsWriter.Write(paramsJSON)
+	if err != nil {
+		return nil, "", fmt.Errorf("paramsWriter- paramsWriter.Write: %s", err)
+	}
+
+	if err := writer.WriteField("params", string(paramsJSON)); err != nil {
+		return nil, "", fmt.Errorf("failed to write params: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	return body, writer.FormDataContentType(), nil
+}
+
+// CheckGenerationStatus checks the status of an image generation request
+func (c *Client) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/key/api/v1/pipeline/status/%s", c.baseURL, uuid), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("X-Key", "Key "+c.apiKey)
+	httpReq.Header.Set("X-Secret", "Secret "+c.secretKey)
+
+	resp, err := c.do(httpReq, "status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, c.secrets)
+	}
+
+	var result struct {
+		UUID             string `json:"uuid"`
+		Status           string `json:"status"`
+		ErrorDescription string `json:"errorDescription"`
+		Result           struct {
+			Files    []string `json:"files"`
+			Censored bool     `json:"censored"`
+			Seed     int64    `json:"seed"`
+		} `json:"result"`
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	status := &domain.ImageGenerationResponse{
+		UUID:             result.UUID,
+		Status:           result.Status,
+		Files:            result.Result.Files,
+		Censored:         result.Result.Censored,
+		ErrorDescription: c.secrets.String(result.ErrorDescription),
+		Seed:             result.Result.Seed,
+	}
+	// Failed and censored generations keep the body, whose details errorDescription often lacks
+	if status.Status == "FAIL" || status.Censored {
+		status.RawResponse = c.secrets.Bytes(body)
+	}
+	return status, nil
+}
+
+// Ping validates the credentials by listing the available pipelines
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.getPipelineID(ctx)
+	return err
+}
+
+// getPipelineID retrieves the pipeline ID for the Kandinsky model
+func (c *Client) getPipelineID(ctx context.Context) (string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/key/api/v1/pipelines", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("X-Key", "Key "+c.apiKey)
+	httpReq.Header.Set("X-Secret", "Secret "+c.secretKey)
+
+	resp, err := c.do(httpReq, "pipelines")
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp, c.secrets)
+	}
+
+	var pipelines []struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&pipelines); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(pipelines) == 0 {
+		return "", fmt.Errorf("%w: no pipelines found", domain.ErrProviderUnavailable)
+	}
+
+	return pipelines[0].ID, nil
+}
+
+// do sends the request under a span and reports its duration, labelled with the endpoint and
+// status code
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "fusionbrain.request")
+	if span.IsRecording() {
+		req = req.WithContext(ctx)
+		span.SetAttributes(
+			attribute.String("provider", ProviderName),
+			attribute.String("endpoint", endpoint),
+			attribute.String("http.request.method", req.Method),
+		)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+
+	elapsed := time.Since(start)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		if span.IsRecording() {
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= http.StatusBadRequest {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		}
+	}
+	tracing.End(span, err)
+	c.metrics.ObserveDuration(metrics.APIRequestDuration, elapsed, map[string]string{
+		"provider":    ProviderName,
+		"endpoint":    endpoint,
+		"status_code": status,
+	})
+	c.logger.Debug("API request", "provider", ProviderName, "endpoint", endpoint, "status_code", status, "duration", elapsed)
+
+	return resp, err
+}
@@ -0,0 +1,442 @@
+package fusionbrain_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+)
+
+// newTestClient starts a fake server and returns a client of it
+func newTestClient(t *testing.T, opts ...fakeserver.Option) (*fusionbrain.Client, *fakeserver.Server) {
+	t.Helper()
+	srv := fakeserver.New(append([]fakeserver.Option{fakeserver.WithCredentials("key", "secret")}, opts...)...)
+	t.Cleanup(srv.Close)
+	return fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL)), srv
+}
+
+// generateParams returns the generateParams of the only run request srv received
+func generateParams(t *testing.T, srv *fakeserver.Server) map[string]interface{} {
+	t.Helper()
+	runs := srv.Runs()
+	if len(runs) != 1 {
+		t.Fatalf("server received %d run requests, want 1", len(runs))
+	}
+	params, ok := runs[0].Params["generateParams"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("params = %v, want generateParams in them", runs[0].Params)
+	}
+	return params
+}
+
+func TestGenerateImageSeed(t *testing.T) {
+	tests := []struct {
+		name string
+		seed int64
+		// want is the seed in the params JSON, empty when it must be omitted
+		want json.Number
+	}{
+		{name: "zero is omitted", seed: 0},
+		{name: "set", seed: 42, want: "42"},
+		{name: "beyond float64 precision", seed: 1<<53 + 1, want: "9007199254740993"},
+		{name: "negative", seed: -7, want: "-7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t)
+			_, err := client.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+				Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1, Seed: tt.seed,
+			})
+			if err != nil {
+				t.Fatalf("GenerateImage() error = %v", err)
+			}
+
+			params := generateParams(t, srv)
+			seed, ok := params["seed"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("generateParams = %v, want no seed", params)
+				}
+				return
+			}
+			if seed != tt.want {
+				t.Errorf("seed = %v, want %s", seed, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGenerationStatusEchoesSeed(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.Script("fake-seeded", fakeserver.Step{Status: "DONE", Files: []string{fakeserver.Pixel}, Seed: 1234})
+
+	resp, err := client.CheckGenerationStatus(context.Background(), "fake-seeded")
+	if err != nil {
+		t.Fatalf("CheckGenerationStatus() error = %v", err)
+	}
+	if resp.Seed != 1234 {
+		t.Errorf("Seed = %d, want the echoed 1234", resp.Seed)
+	}
+}
+
+func TestGenerateImageExtraParams(t *testing.T) {
+	client, srv := newTestClient(t)
+	// Decoded the way the generator reads them from the params column
+	var extra map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"promptWeights":[{"text":"lighthouse","weight":1.5}],"guidance":7,"futureFlag":true,"nested":{"a":null}}`), &extra); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	_, err := client.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+		Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1, Seed: 42, Style: "ANIME", Extra: extra,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	// The extras sit next to query and seed in generateParams, and nowhere else
+	got, _ := json.Marshal(srv.Runs()[0].Params)
+	want := `{"generateParams":{"futureFlag":true,"guidance":7,"nested":{"a":null},"promptWeights":[{"text":"lighthouse","weight":1.5}],"query":"a lighthouse","seed":42},` +
+		`"height":1024,"numImages":1,"style":"ANIME","type":"GENERATE","width":1024}`
+	if string(got) != want {
+		t.Errorf("params = %s, want %s", got, want)
+	}
+}
+
+func TestGenerateImageExtraConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]interface{}
+		wantErr string
+	}{
+		{name: "query", extra: map[string]interface{}{"query": "a harbour"}, wantErr: "conflict with generateParams set by the client: query"},
+		{name: "seed", extra: map[string]interface{}{"seed": 1, "guidance": 7}, wantErr: "conflict with generateParams set by the client: seed"},
+		{name: "every conflict", extra: map[string]interface{}{"seed": 1, "query": "a harbour"}, wantErr: "conflict with generateParams set by the client: query, seed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t)
+			_, err := client.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+				Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1, Extra: tt.extra,
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GenerateImage() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if runs := srv.Runs(); len(runs) != 0 {
+				t.Errorf("server received %d run requests, want the request rejected before sending", len(runs))
+			}
+		})
+	}
+}
+
+// domainErrors are the errors of the domain a client error may translate to
+var domainErrors = []error{domain.ErrCensored, domain.ErrQuotaExceeded, domain.ErrInvalidPrompt, domain.ErrProviderUnavailable, domain.ErrGenerationFailed}
+
+func TestErrorTranslation(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint fakeserver.Endpoint
+		status   int
+		body     string
+		// want is the only error of the domain the client error matches, nil for none
+		want error
+	}{
+		{name: "run throttled", endpoint: fakeserver.EndpointRun, status: http.StatusTooManyRequests, want: domain.ErrQuotaExceeded},
+		{name: "run out of credits", endpoint: fakeserver.EndpointRun, status: http.StatusPaymentRequired, want: domain.ErrQuotaExceeded},
+		{name: "run invalid params", endpoint: fakeserver.EndpointRun, status: http.StatusBadRequest, want: domain.ErrInvalidPrompt},
+		{name: "run unprocessable", endpoint: fakeserver.EndpointRun, status: http.StatusUnprocessableEntity, want: domain.ErrInvalidPrompt},
+		{name: "run server error", endpoint: fakeserver.EndpointRun, status: http.StatusInternalServerError, want: domain.ErrProviderUnavailable},
+		{name: "run overloaded", endpoint: fakeserver.EndpointRun, status: http.StatusServiceUnavailable, want: domain.ErrProviderUnavailable},
+		{name: "run unauthorized", endpoint: fakeserver.EndpointRun, status: http.StatusUnauthorized},
+		// The status in the body wins over the one of the response
+		{name: "status in body", endpoint: fakeserver.EndpointRun, status: http.StatusBadRequest, body: `{"status":429,"message":"too many requests"}`, want: domain.ErrQuotaExceeded},
+		{name: "pipelines unavailable", endpoint: fakeserver.EndpointPipelines, status: http.StatusBadGateway, want: domain.ErrProviderUnavailable},
+		{name: "status throttled", endpoint: fakeserver.EndpointStatus, status: http.StatusTooManyRequests, want: domain.ErrQuotaExceeded},
+		{name: "status not found", endpoint: fakeserver.EndpointStatus, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t)
+			srv.FailNextWith(tt.endpoint, tt.status, tt.body, nil, 1)
+
+			var err error
+			if tt.endpoint == fakeserver.EndpointStatus {
+				_, err = client.CheckGenerationStatus(context.Background(), "fake-1")
+			} else {
+				_, err = client.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1})
+			}
+			var apiErr *fusionbrain.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want the *APIError kept for context", err)
+			}
+			for _, target := range domainErrors {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, target, got, !got)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineDisabledIsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/pipelines") {
+			w.Write([]byte(`[{"id":"pipeline-1","name":"Kandinsky","status":"ACTIVE"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"pipeline_status":"DISABLED_BY_QUEUE"}`))
+	}))
+	defer srv.Close()
+
+	_, err := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL)).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1})
+	if !errors.Is(err, domain.ErrProviderUnavailable) || !strings.Contains(err.Error(), "DISABLED_BY_QUEUE") {
+		t.Errorf("GenerateImage() error = %v, want domain.ErrProviderUnavailable with the pipeline status", err)
+	}
+}
+
+func TestGenerateImageRequest(t *testing.T) {
+	client, srv := newTestClient(t)
+	resp, err := client.GenerateImage(context.Background(), domain.ImageGenerationRequest{
+		Prompt: "a lighthouse", Width: 1024, Height: 768, NumImages: 2, Style: "ANIME", NegativePrompt: "fog",
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if resp.UUID != "fake-1" || resp.Status != "INITIAL" {
+		t.Errorf("GenerateImage() = %+v, want fake-1 INITIAL", resp)
+	}
+
+	run := srv.Runs()[0]
+	if run.UUID != "fake-1" || run.PipelineID != fakeserver.DefaultPipelineID {
+		t.Errorf("run request = %+v, want fake-1 on the listed pipeline", run)
+	}
+	params := run.Params
+	if params["type"] != "GENERATE" || params["width"] != json.Number("1024") || params["height"] != json.Number("768") ||
+		params["numImages"] != json.Number("2") || params["style"] != "ANIME" || params["negativePromptDecoder"] != "fog" {
+		t.Errorf("params = %v, want the fields of the request", params)
+	}
+	if run.Header.Get("X-Key") != "Key key" || run.Header.Get("X-Secret") != "Secret secret" {
+		t.Errorf("run headers = %v, want the credentials", run.Header)
+	}
+	if n := srv.Requests(fakeserver.EndpointPipelines); n != 1 {
+		t.Errorf("pipelines endpoint received %d requests, want 1", n)
+	}
+}
+
+func TestCheckGenerationStatusProgression(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.Enqueue(fakeserver.Initial(), fakeserver.Processing(), fakeserver.Done(fakeserver.Pixel, fakeserver.Pixel))
+	submitted, err := client.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 2})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	// The last step repeats once it is reached
+	for i, want := range []string{"INITIAL", "PROCESSING", "DONE", "DONE"} {
+		resp, err := client.CheckGenerationStatus(context.Background(), submitted.UUID)
+		if err != nil {
+			t.Fatalf("CheckGenerationStatus() error = %v", err)
+		}
+		if resp.UUID != submitted.UUID || resp.Status != want {
+			t.Errorf("check %d = %s %s, want %s", i, resp.UUID, resp.Status, want)
+		}
+		if wantFiles := want == "DONE"; (len(resp.Files) == 2) != wantFiles || resp.RawResponse != nil {
+			t.Errorf("check %d has %d files and raw response %s, want the files once DONE and no raw response", i, len(resp.Files), resp.RawResponse)
+		}
+	}
+	if n := srv.StatusChecks(submitted.UUID); n != 4 {
+		t.Errorf("server reported %d status checks, want 4", n)
+	}
+}
+
+func TestCheckGenerationStatusFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		step         fakeserver.Step
+		wantStatus   string
+		wantCensored bool
+	}{
+		{name: "failed", step: fakeserver.Fail("internal error"), wantStatus: "FAIL"},
+		{name: "censored", step: fakeserver.Step{Status: "DONE", Files: []string{fakeserver.Pixel}, Censored: true}, wantStatus: "DONE", wantCensored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t)
+			srv.Script("fake-scripted", tt.step)
+
+			resp, err := client.CheckGenerationStatus(context.Background(), "fake-scripted")
+			if err != nil {
+				t.Fatalf("CheckGenerationStatus() error = %v", err)
+			}
+			if resp.Status != tt.wantStatus || resp.Censored != tt.wantCensored || resp.ErrorDescription != tt.step.ErrorDescription {
+				t.Errorf("CheckGenerationStatus() = %+v, want status %s, censored %v", resp, tt.wantStatus, tt.wantCensored)
+			}
+			// The body is kept for diagnosing the failure
+			if !strings.Contains(string(resp.RawResponse), `"uuid":"fake-scripted"`) {
+				t.Errorf("raw response = %s, want the status body", resp.RawResponse)
+			}
+		})
+	}
+}
+
+func TestClientAgainstFaults(t *testing.T) {
+	t.Run("unknown generation", func(t *testing.T) {
+		client, _ := newTestClient(t)
+		_, err := client.CheckGenerationStatus(context.Background(), "fake-missing")
+		var apiErr *fusionbrain.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatus() != http.StatusNotFound {
+			t.Errorf("CheckGenerationStatus() error = %v, want a 404 *APIError", err)
+		}
+	})
+
+	t.Run("throttled once", func(t *testing.T) {
+		client, srv := newTestClient(t)
+		srv.FailNext(fakeserver.EndpointRun, http.StatusTooManyRequests, 1)
+		req := domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1}
+		if _, err := client.GenerateImage(context.Background(), req); !errors.Is(err, domain.ErrQuotaExceeded) {
+			t.Errorf("first GenerateImage() error = %v, want domain.ErrQuotaExceeded", err)
+		}
+		if resp, err := client.GenerateImage(context.Background(), req); err != nil || resp.UUID == "" {
+			t.Errorf("second GenerateImage() = %+v, %v, want it accepted", resp, err)
+		}
+		// The throttled request is recorded without a UUID
+		if runs := srv.Runs(); len(runs) != 2 || runs[0].UUID != "" || runs[1].UUID == "" {
+			t.Errorf("runs = %+v, want the throttled one without a UUID", runs)
+		}
+	})
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		_, srv := newTestClient(t)
+		client := fusionbrain.NewClient("key", "wrong", fusionbrain.WithBaseURL(srv.URL))
+		err := client.Ping(context.Background())
+		var apiErr *fusionbrain.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatus() != http.StatusUnauthorized || strings.Contains(err.Error(), "wrong") {
+			t.Errorf("Ping() error = %v, want a 401 without the secret", err)
+		}
+	})
+
+	t.Run("no pipelines", func(t *testing.T) {
+		client, _ := newTestClient(t, fakeserver.WithPipelines())
+		if err := client.Ping(context.Background()); !errors.Is(err, domain.ErrProviderUnavailable) {
+			t.Errorf("Ping() error = %v, want domain.ErrProviderUnavailable", err)
+		}
+	})
+
+	t.Run("slow status", func(t *testing.T) {
+		client, srv := newTestClient(t)
+		srv.Script("fake-slow", fakeserver.Done(fakeserver.Pixel))
+		srv.SetLatency(fakeserver.EndpointStatus, time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.CheckGenerationStatus(ctx, "fake-slow")
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+			t.Errorf("CheckGenerationStatus() error = %v after %s, want the deadline to end it", err, time.Since(start))
+		}
+	})
+}
+
+// echoServer answers every request with status and a body built by body from the credential
+// headers, like an API or proxy reflecting the request
+func echoServer(t *testing.T, status func(path string) int, body func(path, echo string) string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echo := r.Header.Get("X-Key") + " " + r.Header.Get("X-Secret")
+		w.WriteHeader(status(r.URL.Path))
+		w.Write([]byte(body(r.URL.Path, echo)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func FuzzClientMasksEchoedCredentials(f *testing.F) {
+	f.Add("api-key-0001", "secret-key-0002", "reflected: ")
+	f.Add("k", "s", "")
+	f.Add("abab1234ab", "abab1234", "abab1234")
+	f.Fuzz(func(t *testing.T, apiKey, secretKey, text string) {
+		apiKey, ok := testsupport.FuzzSecret(apiKey)
+		secretKey, ok2 := testsupport.FuzzSecret(secretKey)
+		if !ok || !ok2 {
+			t.Skip()
+		}
+		quoted, _ := json.Marshal(text)
+		req := domain.ImageGenerationRequest{Prompt: "a lighthouse", Width: 1024, Height: 1024, NumImages: 1}
+		ctx := context.Background()
+
+		var leaks []string
+		check := func(where, got string) {
+			for _, secret := range []string{apiKey, secretKey} {
+				if strings.Contains(got, secret) {
+					leaks = append(leaks, fmt.Sprintf("%s quotes %q: %s", where, secret, got))
+				}
+			}
+		}
+
+		// Each endpoint failing with the credentials in its error body
+		for _, failing := range []string{"/key/api/v1/pipelines", "/key/api/v1/pipeline/run", "/key/api/v1/pipeline/status/fake-uuid"} {
+			srv := echoServer(t, func(path string) int {
+				switch {
+				case path == failing:
+					return http.StatusBadRequest
+				case strings.HasSuffix(path, "/run"):
+					return http.StatusCreated
+				}
+				return http.StatusOK
+			}, func(path, echo string) string {
+				if path == "/key/api/v1/pipelines" && path != failing {
+					return `[{"id":"pipeline-1","name":"Kandinsky","status":"ACTIVE"}]`
+				}
+				return `{"error":` + string(quoted) + `,"headers":"` + echo + `"}`
+			})
+			client := fusionbrain.NewClient(apiKey, secretKey, fusionbrain.WithBaseURL(srv.URL))
+			if strings.HasSuffix(failing, "fake-uuid") {
+				_, err := client.CheckGenerationStatus(ctx, "fake-uuid")
+				check("status error", fmt.Sprint(err))
+			} else {
+				_, err := client.GenerateImage(ctx, req)
+				check(failing+" error", fmt.Sprint(err))
+			}
+		}
+
+		// A disabled pipeline and a failed generation describing themselves with the credentials
+		srv := echoServer(t, func(path string) int {
+			if strings.HasSuffix(path, "/run") {
+				return http.StatusCreated
+			}
+			return http.StatusOK
+		}, func(path, echo string) string {
+			switch {
+			case strings.HasSuffix(path, "/pipelines"):
+				return `[{"id":"pipeline-1","name":"Kandinsky","status":"ACTIVE"}]`
+			case strings.HasSuffix(path, "/run"):
+				return `{"pipeline_status":"` + echo + `"}`
+			}
+			return `{"uuid":"fake-uuid","status":"FAIL","errorDescription":"` + echo + `","detail":` + string(quoted) + `}`
+		})
+		client := fusionbrain.NewClient(apiKey, secretKey, fusionbrain.WithBaseURL(srv.URL))
+		_, err := client.GenerateImage(ctx, req)
+		check("pipeline status error", fmt.Sprint(err))
+		resp, err := client.CheckGenerationStatus(ctx, "fake-uuid")
+		if err != nil {
+			t.Fatalf("CheckGenerationStatus() error = %v", err)
+		}
+		check("error description", resp.ErrorDescription)
+		check("raw response", string(resp.RawResponse))
+
+		if len(leaks) > 0 {
+			t.Fatal(strings.Join(leaks, "\n"))
+		}
+	})
+}
@@ -0,0 +1,15 @@
+// Package fusionbrain is a client of the Fusion Brain image generation API implementing
+// domain.ImageProvider. Requests are submitted with GenerateImage and polled with
+// CheckGenerationStatus until they are DONE or FAIL:
+//
+//	client := fusionbrain.NewClient(apiKey, secretKey)
+//	resp, err := client.GenerateImage(ctx, domain.ImageGenerationRequest{
+//		Prompt: "a lighthouse at dawn", Width: 1024, Height: 1024, NumImages: 1,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	status, err := client.CheckGenerationStatus(ctx, resp.UUID)
+//
+// Package fakeserver provides a fake of the API for tests.
+package fusionbrain
@@ -0,0 +1,57 @@
+package fusionbrain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/basel-ax/2xiang/internal/redact"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	// ResponseStatus is the status embedded in the JSON error body, if any.
+	// The API sometimes reports e.g. a missing generation only there.
+	ResponseStatus int
+	Body           string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatus returns the status reported by the API, preferring the one from the error body
+func (e *APIError) HTTPStatus() int {
+	if e.ResponseStatus != 0 {
+		return e.ResponseStatus
+	}
+	return e.StatusCode
+}
+
+// Unwrap maps the status onto the errors of the domain, e.g. domain.ErrQuotaExceeded
+func (e *APIError) Unwrap() error {
+	return domain.ErrorForStatus(e.HTTPStatus())
+}
+
+// newAPIError builds an APIError from a non-successful response, masking secrets in its body
+func newAPIError(resp *http.Response, secrets *redact.Redactor) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       secrets.String(string(body)),
+	}
+
+	var payload struct {
+		Status int `json:"status"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		apiErr.ResponseStatus = payload.Status
+	}
+
+	return apiErr
+}
@@ -0,0 +1,387 @@
+// Package fakeserver provides a fake Fusion Brain API for tests. A Server implements the
+// pipelines, run and status endpoints on an httptest.Server; point a client at it with
+// fusionbrain.WithBaseURL(srv.URL).
+//
+//	srv := fakeserver.New(fakeserver.WithCredentials("key", "secret"))
+//	defer srv.Close()
+//	srv.Enqueue(fakeserver.Initial(), fakeserver.Processing(), fakeserver.Done(png))
+//	client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL))
+package fakeserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint names an endpoint of the API, as used in faults and latency settings
+type Endpoint string
+
+const (
+	// EndpointPipelines lists the available pipelines
+	EndpointPipelines Endpoint = "pipelines"
+	// EndpointRun submits a generation
+	EndpointRun Endpoint = "run"
+	// EndpointStatus reports the status of a generation
+	EndpointStatus Endpoint = "status"
+)
+
+// DefaultPipelineID is the ID of the single pipeline listed unless WithPipelines is used
+const DefaultPipelineID = "fake-pipeline"
+
+// Pixel is a 1x1 PNG as base64, the file of generations that are not scripted
+var Pixel = func() string {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}()
+
+// Step is one status a generation reports, in the shape of the status endpoint
+type Step struct {
+	Status           string
+	Files            []string
+	Censored         bool
+	Seed             int64
+	ErrorDescription string
+}
+
+// Initial is the status of a generation that was accepted
+func Initial() Step {
+	return Step{Status: "INITIAL"}
+}
+
+// Processing is the status of a generation in progress
+func Processing() Step {
+	return Step{Status: "PROCESSING"}
+}
+
+// Done is the status of a finished generation with the given base64 files
+func Done(files ...string) Step {
+	return Step{Status: "DONE", Files: files}
+}
+
+// Censored is the status of a finished generation the API censored
+func Censored() Step {
+	return Step{Status: "DONE", Files: []string{Pixel}, Censored: true}
+}
+
+// Fail is the status of a failed generation
+func Fail(description string) Step {
+	return Step{Status: "FAIL", ErrorDescription: description}
+}
+
+// RunRequest is a run request received by the Server
+type RunRequest struct {
+	// UUID is the UUID the Server assigned, empty when the request was answered with a fault
+	UUID       string
+	PipelineID string
+	// Params is the decoded params part; numbers are json.Number
+	Params map[string]interface{}
+	Header http.Header
+}
+
+// fault is a scripted error response
+type fault struct {
+	status int
+	body   string
+	header http.Header
+}
+
+// generation is a submitted or scripted generation
+type generation struct {
+	steps  []Step
+	checks int
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithCredentials makes the Server answer 401 to requests without the given API and secret keys
+func WithCredentials(apiKey, secretKey string) Option {
+	return func(s *Server) {
+		s.apiKey = apiKey
+		s.secretKey = secretKey
+	}
+}
+
+// WithPipelines sets the IDs of the listed pipelines; without any the listing is empty
+func WithPipelines(ids ...string) Option {
+	return func(s *Server) {
+		s.pipelines = ids
+	}
+}
+
+// WithDefaultSteps sets the status progression of generations that are not scripted with
+// Enqueue, Initial followed by Done(Pixel) by default
+func WithDefaultSteps(steps ...Step) Option {
+	return func(s *Server) {
+		s.defaultSteps = steps
+	}
+}
+
+// Server is a fake Fusion Brain API. Every status check of a generation advances it one step
+// through its progression and the last step repeats. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	apiKey       string
+	secretKey    string
+	pipelines    []string
+	defaultSteps []Step
+
+	mu          sync.Mutex
+	generations map[string]*generation
+	queued      [][]Step
+	faults      map[Endpoint][]fault
+	latency     map[Endpoint]time.Duration
+	runs        []RunRequest
+	requests    map[Endpoint]int
+	nextID      int
+}
+
+// New starts a Server; Close stops it
+func New(opts ...Option) *Server {
+	s := &Server{
+		pipelines:    []string{DefaultPipelineID},
+		defaultSteps: []Step{Initial(), Done(Pixel)},
+		generations:  make(map[string]*generation),
+		faults:       make(map[Endpoint][]fault),
+		latency:      make(map[Endpoint]time.Duration),
+		requests:     make(map[Endpoint]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Enqueue scripts the status progression of the next submitted generation. Generations take
+// the queued progressions in order, and the defaults once none is left.
+func (s *Server) Enqueue(steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued = append(s.queued, steps)
+}
+
+// Script sets the status progression of the generation with the given UUID, which need not
+// have been submitted, restarting it from its first step
+func (s *Server) Script(uuid string, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generations[uuid] = &generation{steps: steps}
+}
+
+// FailNext answers the next times requests to endpoint with the given status code and a JSON
+// error body, e.g. 429 for rate limiting or 404 for a generation that is gone
+func (s *Server) FailNext(endpoint Endpoint, status, times int) {
+	body := fmt.Sprintf(`{"status":%d,"message":%q}`, status, http.StatusText(status))
+	var header http.Header
+	if status == http.StatusTooManyRequests {
+		header = http.Header{"Retry-After": {"1"}}
+	}
+	s.FailNextWith(endpoint, status, body, header, times)
+}
+
+// FailNextWith answers the next times requests to endpoint with the given response
+func (s *Server) FailNextWith(endpoint Endpoint, status int, body string, header http.Header, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < times; i++ {
+		s.faults[endpoint] = append(s.faults[endpoint], fault{status: status, body: body, header: header})
+	}
+}
+
+// SetLatency delays every response of endpoint by d, or of every endpoint when endpoint is
+// empty. Requests whose context ends first are abandoned.
+func (s *Server) SetLatency(endpoint Endpoint, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if endpoint == "" {
+		for _, e := range []Endpoint{EndpointPipelines, EndpointRun, EndpointStatus} {
+			s.latency[e] = d
+		}
+		return
+	}
+	s.latency[endpoint] = d
+}
+
+// Runs returns the run requests received so far, oldest first
+func (s *Server) Runs() []RunRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RunRequest(nil), s.runs...)
+}
+
+// Requests returns how many requests endpoint received, faults included
+func (s *Server) Requests(endpoint Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// StatusChecks returns how many times the status of the generation with the given UUID was
+// reported
+func (s *Server) StatusChecks(uuid string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.generations[uuid]; ok {
+		return g.checks
+	}
+	return 0
+}
+
+// serveHTTP authenticates and routes a request
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var endpoint Endpoint
+	var uuid string
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/key/api/v1/pipelines":
+		endpoint = EndpointPipelines
+	case r.Method == http.MethodPost && r.URL.Path == "/key/api/v1/pipeline/run":
+		endpoint = EndpointRun
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/key/api/v1/pipeline/status/"):
+		endpoint = EndpointStatus
+		uuid = strings.TrimPrefix(r.URL.Path, "/key/api/v1/pipeline/status/")
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"status": http.StatusNotFound, "message": "no such endpoint"})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[endpoint]++
+	latency := s.latency[endpoint]
+	var f *fault
+	if faults := s.faults[endpoint]; len(faults) > 0 {
+		f = &faults[0]
+		s.faults[endpoint] = faults[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.apiKey != "" && (r.Header.Get("X-Key") != "Key "+s.apiKey || r.Header.Get("X-Secret") != "Secret "+s.secretKey) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"status": http.StatusUnauthorized, "message": "invalid credentials"})
+		return
+	}
+
+	if f != nil {
+		if endpoint == EndpointRun {
+			s.recordRun(r, "")
+		}
+		for key, values := range f.header {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+		return
+	}
+
+	switch endpoint {
+	case EndpointPipelines:
+		s.servePipelines(w)
+	case EndpointRun:
+		s.serveRun(w, r)
+	case EndpointStatus:
+		s.serveStatus(w, uuid)
+	}
+}
+
+// servePipelines lists the configured pipelines
+func (s *Server) servePipelines(w http.ResponseWriter) {
+	pipelines := make([]map[string]string, 0, len(s.pipelines))
+	for _, id := range s.pipelines {
+		pipelines = append(pipelines, map[string]string{"id": id, "name": "Kandinsky", "status": "ACTIVE"})
+	}
+	writeJSON(w, http.StatusOK, pipelines)
+}
+
+// serveRun accepts a generation and assigns it the next queued progression
+func (s *Server) serveRun(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	uuid := "fake-" + strconv.Itoa(s.nextID)
+	steps := s.defaultSteps
+	if len(s.queued) > 0 {
+		steps = s.queued[0]
+		s.queued = s.queued[1:]
+	}
+	s.generations[uuid] = &generation{steps: steps}
+	s.mu.Unlock()
+
+	s.recordRun(r, uuid)
+	writeJSON(w, http.StatusCreated, map[string]string{"uuid": uuid, "status": "INITIAL"})
+}
+
+// recordRun records a run request, assigned uuid unless it was answered with a fault
+func (s *Server) recordRun(r *http.Request, uuid string) {
+	run := RunRequest{UUID: uuid, Header: r.Header.Clone()}
+	if r.MultipartForm != nil || r.ParseMultipartForm(1<<20) == nil {
+		run.PipelineID = r.FormValue("pipeline_id")
+		if values := r.MultipartForm.Value["params"]; len(values) > 0 {
+			dec := json.NewDecoder(strings.NewReader(values[0]))
+			dec.UseNumber()
+			dec.Decode(&run.Params)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, run)
+}
+
+// serveStatus reports the current step of a generation and advances it
+func (s *Server) serveStatus(w http.ResponseWriter, uuid string) {
+	s.mu.Lock()
+	g, ok := s.generations[uuid]
+	var step Step
+	if ok && len(g.steps) > 0 {
+		step = g.steps[min(g.checks, len(g.steps)-1)]
+		g.checks++
+	}
+	s.mu.Unlock()
+
+	if !ok || len(g.steps) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"status": http.StatusNotFound, "message": "generation not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"uuid":             uuid,
+		"status":           step.Status,
+		"errorDescription": step.ErrorDescription,
+		"result": map[string]interface{}{
+			"files":    step.Files,
+			"censored": step.Censored,
+			"seed":     step.Seed,
+		},
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
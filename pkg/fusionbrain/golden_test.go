@@ -0,0 +1,196 @@
+package fusionbrain_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, rewriting the file instead with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run go test -update to accept it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// recordingServer answers like the API and keeps the raw run request it received
+type recordingServer struct {
+	*httptest.Server
+	// status is the body of every status response
+	status string
+
+	mu  sync.Mutex
+	run []byte
+}
+
+// newRecordingServer starts a server whose status responses have the given body
+func newRecordingServer(t *testing.T, status string) *recordingServer {
+	t.Helper()
+	s := &recordingServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/key/api/v1/pipelines":
+		fmt.Fprint(w, `[{"id":"pipeline-1","name":"Kandinsky","status":"ACTIVE"}]`)
+	case r.URL.Path == "/key/api/v1/pipeline/run":
+		dump, err := dumpRunRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.run = dump
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"uuid":"u-1","status":"INITIAL","status_time":0}`)
+	case strings.HasPrefix(r.URL.Path, "/key/api/v1/pipeline/status/"):
+		fmt.Fprint(w, s.status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// runRequest returns the dump of the last run request
+func (s *recordingServer) runRequest() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run
+}
+
+// dumpRunRequest renders the request line, every header in order and the body, with the
+// random multipart boundary replaced by BOUNDARY
+func dumpRunRequest(r *http.Request) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	boundary := params["boundary"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s %s\n", r.Method, r.URL.Path)
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			fmt.Fprintf(&out, "%s: %s\n", name, strings.ReplaceAll(value, boundary, "BOUNDARY"))
+		}
+	}
+	out.WriteString("\n")
+	out.Write(bytes.ReplaceAll(body, []byte(boundary), []byte("BOUNDARY")))
+	return out.Bytes(), nil
+}
+
+func TestRunRequestGolden(t *testing.T) {
+	base := domain.ImageGenerationRequest{Prompt: "a lighthouse on a cliff", Width: 1024, Height: 768, NumImages: 1}
+	tests := []struct {
+		golden string
+		modify func(*domain.ImageGenerationRequest)
+	}{
+		{golden: "run_minimal.golden", modify: func(r *domain.ImageGenerationRequest) {}},
+		{golden: "run_style.golden", modify: func(r *domain.ImageGenerationRequest) { r.Style = "ANIME" }},
+		{golden: "run_negative_prompt.golden", modify: func(r *domain.ImageGenerationRequest) { r.NegativePrompt = "fog, people" }},
+		{golden: "run_seed.golden", modify: func(r *domain.ImageGenerationRequest) { r.Seed = 4242 }},
+		{golden: "run_extra.golden", modify: func(r *domain.ImageGenerationRequest) {
+			r.Extra = map[string]interface{}{"guidanceScale": 7.5, "sampler": map[string]interface{}{"name": "ddim", "steps": 50}}
+		}},
+		{golden: "run_full.golden", modify: func(r *domain.ImageGenerationRequest) {
+			r.Prompt = "маяк на скале, \"dawn\" & <waves>"
+			r.Style = "KANDINSKY"
+			r.NegativePrompt = "fog"
+			r.Seed = 7
+			r.NumImages = 2
+			r.Extra = map[string]interface{}{"guidanceScale": 4}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			srv := newRecordingServer(t, `{}`)
+			client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL))
+			req := base
+			tt.modify(&req)
+
+			if _, err := client.GenerateImage(context.Background(), req); err != nil {
+				t.Fatalf("GenerateImage() error = %v", err)
+			}
+			checkGolden(t, tt.golden, srv.runRequest())
+		})
+	}
+}
+
+func TestStatusResponseGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		status string
+	}{
+		{golden: "status_processing.golden", status: `{"uuid":"u-1","status":"PROCESSING","status_time":3}`},
+		{golden: "status_done.golden", status: `{"uuid":"u-1","status":"DONE","result":{"files":["iVBORw0KGgo="],"censored":false,"seed":4242},"generationTime":12}`},
+		{golden: "status_censored.golden", status: `{"uuid":"u-1","status":"DONE","result":{"files":["iVBORw0KGgo="],"censored":true}}`},
+		{golden: "status_fail.golden", status: `{"uuid":"u-1","status":"FAIL","errorDescription":"internal error"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			srv := newRecordingServer(t, tt.status)
+			client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL))
+
+			resp, err := client.CheckGenerationStatus(context.Background(), "u-1")
+			if err != nil {
+				t.Fatalf("CheckGenerationStatus() error = %v", err)
+			}
+			// Every field the client fills in, including those the JSON of the response leaves out
+			got, err := json.MarshalIndent(struct {
+				UUID             string
+				Status           string
+				Files            []string
+				Censored         bool
+				Seed             int64
+				ErrorDescription string
+				RawResponse      json.RawMessage
+			}{resp.UUID, resp.Status, resp.Files, resp.Censored, resp.Seed, resp.ErrorDescription, resp.RawResponse}, "", "  ")
+			if err != nil {
+				t.Fatalf("json.MarshalIndent() error = %v", err)
+			}
+			checkGolden(t, tt.golden, append(got, '\n'))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package fusionbrain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport waits for a token of limiter before sending each request, so every
+// workflow sharing the client stays within one overall request rate
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+	// exemptPipelines sends pipeline listings, used for availability checks, without a token
+	exemptPipelines bool
+	metrics         metrics.Hook
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.exemptPipelines && strings.HasSuffix(req.URL.Path, "/pipelines") {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		// The limiter gives up early when the next token would arrive after the deadline;
+		// report it as the timeout it is so the image is retried later
+		return nil, fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+	t.metrics.ObserveDuration(metrics.APIRateLimitWait, time.Since(start), map[string]string{"provider": ProviderName})
+
+	return t.base.RoundTrip(req)
+}
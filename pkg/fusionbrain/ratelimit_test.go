@@ -0,0 +1,153 @@
+package fusionbrain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"golang.org/x/time/rate"
+)
+
+// recordingTransport answers every request with 200 and records when it was sent
+type recordingTransport struct {
+	mu    sync.Mutex
+	sent  []time.Time
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, time.Now())
+	t.paths = append(t.paths, req.URL.Path)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+// waitRecorder is a metrics hook that counts the rate limit waits it observes
+type waitRecorder struct {
+	metrics.Nop
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (r *waitRecorder) ObserveDuration(name string, d time.Duration, labels map[string]string) {
+	if name != metrics.APIRateLimitWait {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waits = append(r.waits, d)
+}
+
+// send issues a GET of path through transport
+func send(ctx context.Context, transport http.RoundTripper, path string) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, defaultBaseURL+path, nil)
+	resp, err := transport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestRateLimitedTransportSpacesRequests(t *testing.T) {
+	base := &recordingTransport{}
+	hook := &waitRecorder{}
+	// 20 requests per second without a burst leaves 50ms between requests
+	transport := &rateLimitedTransport{base: base, limiter: rate.NewLimiter(20, 1), metrics: hook}
+
+	// Both workflows share the transport, so the spacing holds across goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := send(context.Background(), transport, "/key/api/v1/text2image/status/uuid"); err != nil {
+				t.Errorf("RoundTrip() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(base.sent) != 6 {
+		t.Fatalf("sent %d requests, want 6", len(base.sent))
+	}
+	// A little slack for the scheduler; the limiter never lets requests through early
+	const minGap = 45 * time.Millisecond
+	for i := 1; i < len(base.sent); i++ {
+		if gap := base.sent[i].Sub(base.sent[i-1]); gap < minGap {
+			t.Errorf("request %d sent %v after the previous one, want at least %v", i, gap, minGap)
+		}
+	}
+	if total := base.sent[5].Sub(base.sent[0]); total < 5*minGap {
+		t.Errorf("6 requests took %v, want at least %v", total, 5*minGap)
+	}
+	if len(hook.waits) != 6 {
+		t.Errorf("observed %d rate limit waits, want one per request", len(hook.waits))
+	}
+}
+
+func TestRateLimitedTransportRespectsContext(t *testing.T) {
+	base := &recordingTransport{}
+	// A single token that is not replenished within the test
+	transport := &rateLimitedTransport{base: base, limiter: rate.NewLimiter(rate.Every(time.Hour), 1), metrics: metrics.Nop{}}
+	if err := send(context.Background(), transport, "/run"); err != nil {
+		t.Fatalf("first RoundTrip() error = %v", err)
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		err := send(ctx, transport, "/run")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RoundTrip() error = %v, want %v", err, context.Canceled)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("RoundTrip() returned after %v, want it to stop waiting on cancellation", elapsed)
+		}
+	})
+
+	t.Run("deadline before the next token", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		// The limiter refuses right away instead of waiting out the deadline
+		if err := send(ctx, transport, "/run"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("RoundTrip() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	if len(base.sent) != 1 {
+		t.Errorf("sent %d requests, want the waiting ones dropped", len(base.sent))
+	}
+}
+
+func TestRateLimitedTransportExemptsPipelines(t *testing.T) {
+	base := &recordingTransport{}
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	transport := &rateLimitedTransport{base: base, limiter: limiter, exemptPipelines: true, metrics: metrics.Nop{}}
+
+	for i := 0; i < 3; i++ {
+		if err := send(context.Background(), transport, "/key/api/v1/pipelines"); err != nil {
+			t.Fatalf("pipelines RoundTrip() error = %v", err)
+		}
+	}
+	// The availability checks left the token for the generation request
+	if err := send(context.Background(), transport, "/key/api/v1/pipeline/run"); err != nil {
+		t.Fatalf("run RoundTrip() error = %v", err)
+	}
+	if len(base.sent) != 4 {
+		t.Errorf("sent %d requests, want 4", len(base.sent))
+	}
+
+	// Without the exemption the listings take tokens like any other request
+	transport.exemptPipelines = false
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := send(ctx, transport, "/key/api/v1/pipelines"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pipelines RoundTrip() without the exemption error = %v, want it rate limited", err)
+	}
+}
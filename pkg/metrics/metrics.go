@@ -0,0 +1,59 @@
+// Package metrics defines the hook through which components report instrumentation events.
+package metrics
+
+import "time"
+
+// Counter and histogram names reported through the Hook
+const (
+	CacheHits   = "cache_hits_total"
+	CacheMisses = "cache_misses_total"
+
+	// ImagesGenerated counts images that reached ReadyToPublish; labels: none
+	ImagesGenerated = "images_generated_total"
+	// ImagesFailed counts images that were marked Failed; labels: none
+	ImagesFailed = "images_failed_total"
+	// ImagesTimedOut counts images that were marked TimedOut; labels: none
+	ImagesTimedOut = "images_timed_out_total"
+	// ImagesCensored counts images that were marked Censored; labels: none
+	ImagesCensored = "images_censored_total"
+	// ImagesPublished counts images that were published; labels: none
+	ImagesPublished = "images_published_total"
+	// ImagesSubmitted counts images a provider accepted for generation; labels: none
+	ImagesSubmitted = "images_submitted_total"
+	// ImagesSkipped counts images a workflow pass left for a later pass after a retryable error
+	// or a timeout; labels: workflow
+	ImagesSkipped = "images_skipped_total"
+	// ImageErrors counts images whose handling hit an error; labels: workflow and class, the
+	// error class as stored with failed images
+	ImageErrors = "image_errors_total"
+
+	// APIRequestDuration is the duration of provider API requests;
+	// labels: provider, endpoint and status_code ("error" when no response was received)
+	APIRequestDuration = "api_request_duration_seconds"
+	// APIRateLimitWait is how long provider API requests waited for the shared rate limiter,
+	// showing how close the configured API_RPS is to saturation; labels: provider
+	APIRateLimitWait = "api_rate_limit_wait_seconds"
+	// WorkflowPassDuration is the duration of a single pass of a workflow; labels: workflow
+	WorkflowPassDuration = "workflow_pass_duration_seconds"
+	// GenerationDuration is how long finished generations took from submission to completion;
+	// labels: provider
+	GenerationDuration = "generation_duration_seconds"
+)
+
+// Hook receives instrumentation events. Implementations must be safe for concurrent use.
+type Hook interface {
+	// IncCounter increments the named counter
+	IncCounter(name string, labels map[string]string)
+
+	// ObserveDuration records a duration for the named histogram
+	ObserveDuration(name string, d time.Duration, labels map[string]string)
+}
+
+// Nop is a Hook that discards all events
+type Nop struct{}
+
+// IncCounter implements Hook
+func (Nop) IncCounter(string, map[string]string) {}
+
+// ObserveDuration implements Hook
+func (Nop) ObserveDuration(string, time.Duration, map[string]string) {}
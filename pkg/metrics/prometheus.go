@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace prefixes every exported Prometheus metric, e.g. xiang_images_generated_total
+const Namespace = "xiang"
+
+// QueueDepth is the number of images per status, collected on every scrape; labels: status
+const QueueDepth = "images_queue_depth"
+
+// ProviderRequestsToday and ProviderFailuresToday are the generation requests submitted to each
+// provider on the current UTC day and the ones it did not accept, collected on every scrape;
+// labels: provider
+const (
+	ProviderRequestsToday = "provider_requests_today"
+	ProviderFailuresToday = "provider_failures_today"
+)
+
+// counterLabels and histogramLabels list the label names of every known metric;
+// events for other names are dropped
+var (
+	counterLabels = map[string][]string{
+		CacheHits:       nil,
+		CacheMisses:     nil,
+		ImagesGenerated: nil,
+		ImagesFailed:    nil,
+		ImagesTimedOut:  nil,
+		ImagesCensored:  nil,
+		ImagesPublished: nil,
+		ImagesSubmitted: nil,
+		ImagesSkipped:   {"workflow"},
+		ImageErrors:     {"workflow", "class"},
+	}
+	histogramLabels = map[string][]string{
+		APIRequestDuration:   {"provider", "endpoint", "status_code"},
+		APIRateLimitWait:     {"provider"},
+		WorkflowPassDuration: {"workflow"},
+		GenerationDuration:   {"provider"},
+	}
+)
+
+// Prometheus is a Hook exporting events as Prometheus counters and histograms
+type Prometheus struct {
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// NewPrometheus creates the known metrics and registers them with reg
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	p := &Prometheus{
+		counters:   make(map[string]*prometheus.CounterVec, len(counterLabels)),
+		histograms: make(map[string]*prometheus.HistogramVec, len(histogramLabels)),
+	}
+
+	for name, labels := range counterLabels {
+		vec := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: Namespace, Name: name}, labels)
+		if err := reg.Register(vec); err != nil {
+			return nil, err
+		}
+		p.counters[name] = vec
+	}
+	for name, labels := range histogramLabels {
+		vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      name,
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, labels)
+		if err := reg.Register(vec); err != nil {
+			return nil, err
+		}
+		p.histograms[name] = vec
+	}
+
+	return p, nil
+}
+
+// IncCounter implements Hook
+func (p *Prometheus) IncCounter(name string, labels map[string]string) {
+	vec, ok := p.counters[name]
+	if !ok {
+		return
+	}
+	if counter, err := vec.GetMetricWith(labels); err == nil {
+		counter.Inc()
+	}
+}
+
+// ObserveDuration implements Hook
+func (p *Prometheus) ObserveDuration(name string, d time.Duration, labels map[string]string) {
+	vec, ok := p.histograms[name]
+	if !ok {
+		return
+	}
+	if histogram, err := vec.GetMetricWith(labels); err == nil {
+		histogram.Observe(d.Seconds())
+	}
+}
+
+// queueCollector reports the number of images per status when scraped
+type queueCollector struct {
+	desc  *prometheus.Desc
+	count func(ctx context.Context) (map[string]int, error)
+}
+
+// NewQueueCollector creates a collector exporting QueueDepth from count, e.g. the repository's
+// CountByStatus. Statuses without images are not reported.
+func NewQueueCollector(count func(ctx context.Context) (map[string]int, error)) prometheus.Collector {
+	return &queueCollector{
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", QueueDepth), "Number of images per status", []string{"status"}, nil),
+		count: count,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), status)
+	}
+}
+
+// Usage is the number of generation requests submitted to a provider and the failures among them
+type Usage struct {
+	Requests int
+	Failures int
+}
+
+// usageCollector reports the requests submitted to each provider today when scraped
+type usageCollector struct {
+	requests *prometheus.Desc
+	failures *prometheus.Desc
+	usage    func(ctx context.Context) (map[string]Usage, error)
+}
+
+// NewUsageCollector creates a collector exporting ProviderRequestsToday and
+// ProviderFailuresToday from usage, keyed by provider, e.g. the repository's GetUsage for today
+func NewUsageCollector(usage func(ctx context.Context) (map[string]Usage, error)) prometheus.Collector {
+	return &usageCollector{
+		requests: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", ProviderRequestsToday), "Generation requests submitted to the provider today (UTC)", []string{"provider"}, nil),
+		failures: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", ProviderFailuresToday), "Generation requests the provider did not accept today (UTC)", []string{"provider"}, nil),
+		usage:    usage,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.failures
+}
+
+// Collect implements prometheus.Collector
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	usage, err := c.usage(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.requests, err)
+		return
+	}
+	for provider, u := range usage {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.GaugeValue, float64(u.Requests), provider)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.GaugeValue, float64(u.Failures), provider)
+	}
+}
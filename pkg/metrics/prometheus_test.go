@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusHook(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prom, err := metrics.NewPrometheus(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheus() error = %v", err)
+	}
+
+	prom.IncCounter(metrics.ImagesGenerated, nil)
+	prom.IncCounter(metrics.ImagesGenerated, nil)
+	prom.IncCounter(metrics.ImageErrors, map[string]string{"workflow": "processor", "class": "permanent"})
+	// Unknown names and labels are dropped instead of panicking, so no failed image is exported
+	prom.IncCounter("unknown_total", nil)
+	prom.IncCounter(metrics.ImagesFailed, map[string]string{"unexpected": "label"})
+	prom.ObserveDuration(metrics.APIRequestDuration, 300*time.Millisecond, map[string]string{"provider": "fusionbrain", "endpoint": "run", "status_code": "201"})
+	prom.ObserveDuration("unknown_seconds", time.Second, nil)
+
+	want := `
+# HELP xiang_image_errors_total
+# TYPE xiang_image_errors_total counter
+xiang_image_errors_total{class="permanent",workflow="processor"} 1
+# HELP xiang_images_generated_total
+# TYPE xiang_images_generated_total counter
+xiang_images_generated_total 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "xiang_images_generated_total", "xiang_images_failed_total", "xiang_image_errors_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "xiang_api_request_duration_seconds"); n != 1 {
+		t.Errorf("api_request_duration_seconds has %d series, want 1", n)
+	}
+
+	// Registering twice fails instead of exporting duplicate metrics
+	if _, err := metrics.NewPrometheus(reg); err == nil {
+		t.Error("NewPrometheus() on the same registry succeeded, want an error")
+	}
+}
+
+func TestQueueCollector(t *testing.T) {
+	counts := map[string]int{"Pending": 3, "Generate": 1}
+	collector := metrics.NewQueueCollector(func(ctx context.Context) (map[string]int, error) {
+		return counts, nil
+	})
+
+	want := `
+# HELP xiang_images_queue_depth Number of images per status
+# TYPE xiang_images_queue_depth gauge
+xiang_images_queue_depth{status="Generate"} 1
+xiang_images_queue_depth{status="Pending"} 3
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// The depth is read again on every scrape
+	counts = map[string]int{"Pending": 0}
+	if got := testutil.ToFloat64(collector); got != 0 {
+		t.Errorf("queue depth after the queue drained = %v, want 0", got)
+	}
+
+	failing := metrics.NewQueueCollector(func(ctx context.Context) (map[string]int, error) {
+		return nil, errors.New("database unreachable")
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(failing)
+	if _, err := reg.Gather(); err == nil || !strings.Contains(err.Error(), "database unreachable") {
+		t.Errorf("Gather() error = %v, want the count error", err)
+	}
+}
+
+func TestUsageCollector(t *testing.T) {
+	usage := map[string]metrics.Usage{"fusionbrain": {Requests: 42, Failures: 3}, "fallback": {Requests: 5}}
+	collector := metrics.NewUsageCollector(func(ctx context.Context) (map[string]metrics.Usage, error) {
+		return usage, nil
+	})
+
+	want := `
+# HELP xiang_provider_failures_today Generation requests the provider did not accept today (UTC)
+# TYPE xiang_provider_failures_today gauge
+xiang_provider_failures_today{provider="fallback"} 0
+xiang_provider_failures_today{provider="fusionbrain"} 3
+# HELP xiang_provider_requests_today Generation requests submitted to the provider today (UTC)
+# TYPE xiang_provider_requests_today gauge
+xiang_provider_requests_today{provider="fallback"} 5
+xiang_provider_requests_today{provider="fusionbrain"} 42
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// A new day has no usage until the first request, so its providers disappear
+	usage = map[string]metrics.Usage{}
+	if n := testutil.CollectAndCount(collector); n != 0 {
+		t.Errorf("CollectAndCount() after midnight = %d, want 0", n)
+	}
+}
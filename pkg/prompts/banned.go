@@ -0,0 +1,52 @@
+package prompts
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// ErrEmptyPrompt is returned when nothing is left of a prompt after processing
+var ErrEmptyPrompt = errors.New("prompt is empty after processing")
+
+// BannedWords removes banned words from prompts, matching whole words case-insensitively
+type BannedWords struct {
+	re *regexp.Regexp
+}
+
+// NewBannedWords creates a processor that strips the given words; empty entries are ignored
+func NewBannedWords(words []string) *BannedWords {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+
+	b := &BannedWords{}
+	if len(quoted) > 0 {
+		b.re = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return b
+}
+
+// Process strips banned words and tidies the whitespace and commas left behind
+func (b *BannedWords) Process(ctx context.Context, prompt string) (string, error) {
+	if b.re == nil {
+		return prompt, nil
+	}
+
+	stripped := b.re.ReplaceAllString(prompt, "")
+	stripped = emptyClauses.ReplaceAllString(stripped, ",")
+	stripped = strings.Trim(domain.NormalizePrompt(stripped), ", ")
+	if stripped == "" {
+		return "", ErrEmptyPrompt
+	}
+	return stripped, nil
+}
+
+// emptyClauses matches commas left next to each other once a word between them is removed
+var emptyClauses = regexp.MustCompile(`,(?:\s*,)+`)
@@ -0,0 +1,58 @@
+package prompts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// Filter rejects prompts containing banned terms. Plain terms match whole words or phrases,
+// regex terms match anywhere; both ignore case and work on any script.
+type Filter struct {
+	terms []filterTerm
+}
+
+// filterTerm is a banned term compiled to a regular expression
+type filterTerm struct {
+	term string
+	re   *regexp.Regexp
+}
+
+// NewFilter compiles the banned terms; an invalid regex term is an error
+func NewFilter(terms []domain.BannedTerm) (*Filter, error) {
+	f := &Filter{}
+	for _, t := range terms {
+		pattern := t.Term
+		if strings.TrimSpace(pattern) == "" {
+			// An empty regex would match every prompt
+			continue
+		}
+		if !t.Regex {
+			words := strings.Fields(t.Term)
+			for i, w := range words {
+				words[i] = regexp.QuoteMeta(w)
+			}
+			// \b only knows ASCII word characters, so spell out Unicode word boundaries
+			pattern = `(?:^|[^\p{L}\p{N}_])` + strings.Join(words, `\s+`) + `(?:$|[^\p{L}\p{N}_])`
+		}
+
+		re, err := regexp.Compile(`(?i)` + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid banned term %q: %w", t.Term, err)
+		}
+		f.terms = append(f.terms, filterTerm{term: t.Term, re: re})
+	}
+	return f, nil
+}
+
+// Match returns the first banned term found in prompt
+func (f *Filter) Match(prompt string) (string, bool) {
+	for _, t := range f.terms {
+		if t.re.MatchString(prompt) {
+			return t.term, true
+		}
+	}
+	return "", false
+}
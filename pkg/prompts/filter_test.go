@@ -0,0 +1,82 @@
+package prompts_test
+
+import (
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+func TestFilterMatch(t *testing.T) {
+	filter, err := prompts.NewFilter([]domain.BannedTerm{
+		{Term: "gore"},
+		{Term: "blood  bath"},
+		{Term: "кровь"},
+		{Term: "nud(e|ity)", Regex: true},
+		{Term: `^\s*draft\b`, Regex: true},
+		{Term: "   "},
+		{Term: "", Regex: true},
+	})
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{prompt: "a lighthouse at dusk", want: ""},
+		{prompt: "gore", want: "gore"},
+		{prompt: "a GORE scene", want: "gore"},
+		{prompt: "gore, at dusk", want: "gore"},
+		{prompt: "gorey details", want: ""},
+		{prompt: "folklore", want: ""},
+		{prompt: "a blood bath", want: "blood  bath"},
+		{prompt: "a Blood\tBath at noon", want: "blood  bath"},
+		{prompt: "a blood-bath", want: ""},
+		{prompt: "a bath of blood", want: ""},
+		{prompt: "КРОВЬ на снегу", want: "кровь"},
+		{prompt: "кровью", want: ""},
+		{prompt: "a nude statue", want: "nud(e|ity)"},
+		{prompt: "NUDITY", want: "nud(e|ity)"},
+		{prompt: "denuded hills", want: "nud(e|ity)"},
+		{prompt: "  draft: a fox", want: `^\s*draft\b`},
+		{prompt: "a fox draft", want: ""},
+	}
+	for _, tt := range tests {
+		term, ok := filter.Match(tt.prompt)
+		if ok != (tt.want != "") || term != tt.want {
+			t.Errorf("Match(%q) = %q, %v, want %q", tt.prompt, term, ok, tt.want)
+		}
+	}
+}
+
+func TestFilterFirstTermWins(t *testing.T) {
+	filter, err := prompts.NewFilter([]domain.BannedTerm{{Term: "blood"}, {Term: "blood bath"}})
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+	if term, _ := filter.Match("a blood bath"); term != "blood" {
+		t.Errorf("Match() = %q, want the first term listed", term)
+	}
+}
+
+func TestFilterEmpty(t *testing.T) {
+	filter, err := prompts.NewFilter(nil)
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+	if term, ok := filter.Match("anything at all"); ok {
+		t.Errorf("Match() = %q, want no match without terms", term)
+	}
+}
+
+func TestNewFilterInvalidRegex(t *testing.T) {
+	if _, err := prompts.NewFilter([]domain.BannedTerm{{Term: "nud(e", Regex: true}}); err == nil {
+		t.Error("NewFilter() with an invalid regex succeeded")
+	}
+	// The same text as a plain term is quoted
+	if _, err := prompts.NewFilter([]domain.BannedTerm{{Term: "nud(e"}}); err != nil {
+		t.Errorf("NewFilter() with a plain term error = %v", err)
+	}
+}
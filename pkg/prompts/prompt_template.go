@@ -0,0 +1,123 @@
+package prompts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PromptTemplate is a prompt with {name} placeholders, e.g. "A {animal} in the style of {artist}".
+// Literal braces are written as {{ and }}.
+type PromptTemplate struct {
+	// Strict makes Expand fail on variables missing from vars instead of leaving them unexpanded
+	Strict bool
+
+	parts []templatePart
+}
+
+// templatePart is either literal text or, when isVar is set, the name of a variable
+type templatePart struct {
+	text  string
+	isVar bool
+}
+
+// ParseTemplate parses a prompt template; the returned template is strict
+func ParseTemplate(text string) (*PromptTemplate, error) {
+	t := &PromptTemplate{Strict: true}
+
+	var literal strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '{' && i+1 < len(text) && text[i+1] == '{':
+			literal.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(text) && text[i+1] == '}':
+			literal.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(text[i+1:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed placeholder at offset %d", i)
+			}
+			name := text[i+1 : i+1+end]
+			if !validVariableName(name) {
+				return nil, fmt.Errorf("invalid variable name %q at offset %d", name, i)
+			}
+			if literal.Len() > 0 {
+				t.parts = append(t.parts, templatePart{text: literal.String()})
+				literal.Reset()
+			}
+			t.parts = append(t.parts, templatePart{text: name, isVar: true})
+			i += end + 1
+		case c == '}':
+			return nil, fmt.Errorf("unexpected } at offset %d, use }} for a literal brace", i)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: literal.String()})
+	}
+
+	return t, nil
+}
+
+// validVariableName reports whether name is a non-empty run of letters, digits and underscores
+func validVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Variables returns the names of the variables used in the template, in order of appearance
+func (t *PromptTemplate) Variables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range t.parts {
+		if p.isVar && !seen[p.text] {
+			seen[p.text] = true
+			names = append(names, p.text)
+		}
+	}
+	return names
+}
+
+// Expand substitutes vars into the template
+func (t *PromptTemplate) Expand(vars map[string]string) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if !p.isVar {
+			b.WriteString(p.text)
+			continue
+		}
+
+		value, ok := vars[p.text]
+		if !ok {
+			if t.Strict {
+				return "", fmt.Errorf("missing template variable %q", p.text)
+			}
+			value = "{" + p.text + "}"
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+// ExpandAll expands the template once for every variable set, failing on the first error
+func (t *PromptTemplate) ExpandAll(varSets []map[string]string) ([]string, error) {
+	prompts := make([]string, 0, len(varSets))
+	for i, vars := range varSets {
+		prompt, err := t.Expand(vars)
+		if err != nil {
+			return nil, fmt.Errorf("variable set %d: %w", i, err)
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, nil
+}
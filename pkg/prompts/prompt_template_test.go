@@ -0,0 +1,121 @@
+package prompts_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+func TestPromptTemplateExpand(t *testing.T) {
+	vars := map[string]string{"animal": "fox", "artist": "Hokusai", "n_2": "two"}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "variables", template: "A {animal} in the style of {artist}", want: "A fox in the style of Hokusai"},
+		{name: "repeated variable", template: "{animal} and {animal}", want: "fox and fox"},
+		{name: "digits and underscores", template: "{n_2} foxes", want: "two foxes"},
+		{name: "no variables", template: "a lighthouse", want: "a lighthouse"},
+		{name: "empty", template: "", want: ""},
+		{name: "escaped braces", template: "{{literal}} {animal}", want: "{literal} fox"},
+		{name: "escaped brace next to a variable", template: "{{{animal}}}", want: "{fox}"},
+		{name: "lone escaped closing brace", template: "a }} b", want: "a } b"},
+		{name: "values are not expanded", template: "{animal}", want: "fox"},
+		{name: "unicode text", template: "Лиса {animal} 🦊", want: "Лиса fox 🦊"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := prompts.ParseTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate(%q) error = %v", tt.template, err)
+			}
+			got, err := tmpl.Expand(vars)
+			if err != nil {
+				t.Fatalf("Expand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplateValuesWithBraces(t *testing.T) {
+	tmpl, err := prompts.ParseTemplate("A {animal}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	// Values are inserted as they are, never parsed as a template
+	if got, err := tmpl.Expand(map[string]string{"animal": "{artist}}"}); err != nil || got != "A {artist}}" {
+		t.Errorf("Expand() = %q, %v, want the value unchanged", got, err)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, template := range []string{
+		"A {animal",
+		"A {}",
+		"A {two words}",
+		"A {animal-name}",
+		"A } alone",
+		"{{animal}",
+	} {
+		if _, err := prompts.ParseTemplate(template); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, want an error", template)
+		}
+	}
+}
+
+func TestPromptTemplateMissingVariables(t *testing.T) {
+	tmpl, err := prompts.ParseTemplate("A {animal} in the style of {artist}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	if !tmpl.Strict {
+		t.Fatal("ParseTemplate() returned a template that is not strict")
+	}
+
+	_, err = tmpl.Expand(map[string]string{"animal": "fox"})
+	if err == nil || !strings.Contains(err.Error(), `"artist"`) {
+		t.Errorf("Expand() error = %v, want the missing artist named", err)
+	}
+	// An empty value is set, not missing
+	if got, err := tmpl.Expand(map[string]string{"animal": "fox", "artist": ""}); err != nil || got != "A fox in the style of " {
+		t.Errorf("Expand() with an empty value = %q, %v", got, err)
+	}
+
+	tmpl.Strict = false
+	if got, err := tmpl.Expand(map[string]string{"animal": "fox"}); err != nil || got != "A fox in the style of {artist}" {
+		t.Errorf("lenient Expand() = %q, %v, want the placeholder left in place", got, err)
+	}
+}
+
+func TestPromptTemplateVariables(t *testing.T) {
+	tmpl, err := prompts.ParseTemplate("{b} {{a}} {a} {b} {c}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	if got, want := tmpl.Variables(), []string{"b", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %v, want %v", got, want)
+	}
+}
+
+func TestPromptTemplateExpandAll(t *testing.T) {
+	tmpl, err := prompts.ParseTemplate("A {animal}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	got, err := tmpl.ExpandAll([]map[string]string{{"animal": "fox"}, {"animal": "owl"}, {"animal": "cat"}})
+	if want := []string{"A fox", "A owl", "A cat"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandAll() = %q, %v, want %q", got, err, want)
+	}
+
+	_, err = tmpl.ExpandAll([]map[string]string{{"animal": "fox"}, {"color": "red"}})
+	if err == nil || !strings.Contains(err.Error(), "variable set 1") {
+		t.Errorf("ExpandAll() error = %v, want the failing variable set named", err)
+	}
+}
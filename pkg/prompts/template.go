@@ -0,0 +1,32 @@
+// Package prompts provides built-in domain.PromptProcessor implementations.
+package prompts
+
+import (
+	"context"
+	"strings"
+)
+
+// Template wraps prompts in a fixed prefix and suffix, e.g. a house style
+type Template struct {
+	Prefix string
+	Suffix string
+}
+
+// NewTemplate creates a processor that adds prefix before and suffix after every prompt
+func NewTemplate(prefix, suffix string) *Template {
+	return &Template{
+		Prefix: strings.TrimSpace(prefix),
+		Suffix: strings.TrimSpace(suffix),
+	}
+}
+
+// Process joins the prefix, prompt and suffix with commas, skipping empty parts
+func (t *Template) Process(ctx context.Context, prompt string) (string, error) {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{t.Prefix, strings.TrimSpace(prompt), t.Suffix} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// TryAdvisoryLock takes the session-level Postgres advisory lock key without waiting and
+// reports whether it was acquired. The lock lives on a connection reserved until
+// AdvisoryUnlock, so it is also released when that connection drops. A key this repository
+// already holds is reported as not acquired.
+func (r *PostgresImageRepository) TryAdvisoryLock(ctx context.Context, key int64) (bool, error) {
+	r.locksMu.Lock()
+	defer r.locksMu.Unlock()
+
+	if _, held := r.locks[key]; held {
+		return false, nil
+	}
+
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	if r.locks == nil {
+		r.locks = make(map[int64]*sql.Conn)
+	}
+	r.locks[key] = conn
+	return true, nil
+}
+
+// AdvisoryUnlock releases an advisory lock taken by TryAdvisoryLock and returns its connection
+// to the pool. Releasing a key that is not held does nothing.
+func (r *PostgresImageRepository) AdvisoryUnlock(ctx context.Context, key int64) error {
+	r.locksMu.Lock()
+	conn, held := r.locks[key]
+	delete(r.locks, key)
+	r.locksMu.Unlock()
+
+	if !held {
+		return nil
+	}
+	defer conn.Close()
+
+	var released bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_advisory_unlock($1)`, key).Scan(&released); err != nil {
+		// Discarding the connection instead of returning it to the pool ends the session, which
+		// releases the lock as well
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		return err
+	}
+	if !released {
+		return fmt.Errorf("advisory lock %d was not held", key)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// replica returns a repository with a pool of its own on the test database, like a second
+// instance of the service
+func replica(t *testing.T, dsn string) *repository.PostgresImageRepository {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return repository.NewPostgresImageRepository(db)
+}
+
+func TestAdvisoryLockExcludesReplicas(t *testing.T) {
+	_, dsn := openTestDB(t)
+	ctx := context.Background()
+	first := replica(t, dsn)
+	second := replica(t, dsn)
+	const key = 7_100_001
+
+	if acquired, err := first.TryAdvisoryLock(ctx, key); !acquired || err != nil {
+		t.Fatalf("first TryAdvisoryLock() = %v, %v, want the lock", acquired, err)
+	}
+	if acquired, err := second.TryAdvisoryLock(ctx, key); acquired || err != nil {
+		t.Errorf("second TryAdvisoryLock() while held = %v, %v, want it skipped", acquired, err)
+	}
+	// Other keys are independent
+	if acquired, err := second.TryAdvisoryLock(ctx, key+1); !acquired || err != nil {
+		t.Errorf("second TryAdvisoryLock() of another key = %v, %v, want the lock", acquired, err)
+	}
+	second.AdvisoryUnlock(ctx, key+1)
+
+	if err := first.AdvisoryUnlock(ctx, key); err != nil {
+		t.Fatalf("AdvisoryUnlock() error = %v", err)
+	}
+	// The lock is re-acquired on every trigger, by whichever replica comes first
+	if acquired, err := second.TryAdvisoryLock(ctx, key); !acquired || err != nil {
+		t.Errorf("second TryAdvisoryLock() after the release = %v, %v, want the lock", acquired, err)
+	}
+	if acquired, err := first.TryAdvisoryLock(ctx, key); acquired || err != nil {
+		t.Errorf("first TryAdvisoryLock() while the second holds it = %v, %v, want it skipped", acquired, err)
+	}
+	second.AdvisoryUnlock(ctx, key)
+}
+
+func TestAdvisoryLockConcurrentTriggers(t *testing.T) {
+	_, dsn := openTestDB(t)
+	ctx := context.Background()
+	const key = 7_100_002
+	replicas := make([]*repository.PostgresImageRepository, 8)
+	for i := range replicas {
+		replicas[i] = replica(t, dsn)
+	}
+
+	// All replicas fire the same schedule at once and exactly one of them runs it
+	var wg sync.WaitGroup
+	results := make([]bool, len(replicas))
+	for i, repo := range replicas {
+		wg.Add(1)
+		go func(i int, repo *repository.PostgresImageRepository) {
+			defer wg.Done()
+			acquired, err := repo.TryAdvisoryLock(ctx, key)
+			if err != nil {
+				t.Errorf("TryAdvisoryLock() error = %v", err)
+			}
+			results[i] = acquired
+		}(i, repo)
+	}
+	wg.Wait()
+
+	leaders := 0
+	for i, acquired := range results {
+		if acquired {
+			leaders++
+			defer replicas[i].AdvisoryUnlock(ctx, key)
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("%d replicas acquired the lock, want exactly one", leaders)
+	}
+}
+
+func TestAdvisoryLockReleasedWhenReplicaDies(t *testing.T) {
+	db, dsn := openTestDB(t)
+	ctx := context.Background()
+	const key = 7_100_003
+	dying := replica(t, dsn)
+	survivor := replica(t, dsn)
+
+	if acquired, err := dying.TryAdvisoryLock(ctx, key); !acquired || err != nil {
+		t.Fatalf("TryAdvisoryLock() = %v, %v, want the lock", acquired, err)
+	}
+	// Ending the session holding the lock is what happens when a replica crashes
+	if _, err := db.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_locks
+		WHERE locktype = 'advisory' AND classid = 0 AND objid = $1 AND granted`, key); err != nil {
+		t.Fatalf("failed to terminate the holder: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		acquired, err := survivor.TryAdvisoryLock(ctx, key)
+		if err != nil {
+			t.Fatalf("TryAdvisoryLock() error = %v", err)
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock still held 5s after its session ended")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	survivor.AdvisoryUnlock(ctx, key)
+}
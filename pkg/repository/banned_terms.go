@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// ListBannedTerms retrieves the banned terms that reject prompts before generation
+func (r *PostgresImageRepository) ListBannedTerms(ctx context.Context) ([]domain.BannedTerm, error) {
+	query := `
+		SELECT id, term, is_regex
+		FROM banned_terms
+		ORDER BY id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []domain.BannedTerm
+	for rows.Next() {
+		var t domain.BannedTerm
+		if err := rows.Scan(&t.ID, &t.Term, &t.Regex); err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return terms, nil
+}
+
+// AddBannedTerm adds a banned word, phrase or, with regex set, regular expression and returns its ID
+func (r *PostgresImageRepository) AddBannedTerm(ctx context.Context, term string, regex bool) (int, error) {
+	query := `
+		INSERT INTO banned_terms (term, is_regex)
+		VALUES ($1, $2)
+		ON CONFLICT (term) DO UPDATE SET is_regex = EXCLUDED.is_regex
+		RETURNING id
+	`
+
+	var id int
+	if err := r.db.QueryRowContext(ctx, query, term, regex).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteBannedTerm removes a banned term
+func (r *PostgresImageRepository) DeleteBannedTerm(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM banned_terms WHERE id = $1`, id)
+	return err
+}
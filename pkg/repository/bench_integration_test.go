@@ -0,0 +1,327 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// benchRows is the size of the images table the benchmarks run against
+const benchRows = 1_000_000
+
+// seedBenchTable fills the test database with benchRows images unless it already holds them:
+// 1% queued, 1% generating, 3% failed and the rest published with their image data, created a
+// second apart. Seeding takes a while, so consecutive benchmarks share the table.
+func seedBenchTable(b *testing.B, db *sql.DB) {
+	b.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM images`).Scan(&n); err != nil {
+		b.Fatalf("failed to count images: %v", err)
+	}
+	if n == benchRows {
+		return
+	}
+
+	emptyTestDB(b, db)
+	query := `
+		INSERT INTO images (prompt, status, uuid, base64, priority, created_at, updated_at)
+		SELECT 'a lighthouse ' || g,
+			CASE WHEN g % 100 = 0 THEN 'ReadyToGenerate'
+				WHEN g % 100 = 1 THEN 'Generate'
+				WHEN g % 100 < 5 THEN 'Failed'
+				ELSE 'Published' END,
+			CASE WHEN g % 100 = 1 THEN 'uuid-' || g END,
+			CASE WHEN g % 100 >= 5 THEN repeat('iVBORw0KGgo=', 16) END,
+			g % 3,
+			now() - (($1 - g) * INTERVAL '1 second'),
+			now() - (($1 - g) * INTERVAL '1 second')
+		FROM generate_series(1, $1) AS g
+	`
+	if _, err := db.Exec(query, benchRows); err != nil {
+		b.Fatalf("failed to seed images: %v", err)
+	}
+	if _, err := db.Exec(`ANALYZE images`); err != nil {
+		b.Fatalf("failed to analyze images: %v", err)
+	}
+}
+
+// withoutPartialIndex drops the partial index on (status, created_at) until the benchmark ends,
+// leaving the queries to the plain status index
+func withoutPartialIndex(b *testing.B, db *sql.DB) {
+	b.Helper()
+	if _, err := db.Exec(`DROP INDEX IF EXISTS idx_images_status_created_at`); err != nil {
+		b.Fatalf("failed to drop the index: %v", err)
+	}
+	b.Cleanup(func() {
+		if err := repository.Migrate(context.Background(), db); err != nil {
+			b.Errorf("failed to restore the index: %v", err)
+		}
+	})
+}
+
+// benchIndexes runs bench with and without the partial index
+func benchIndexes(b *testing.B, db *sql.DB, bench func(b *testing.B)) {
+	b.Run("partial index", bench)
+	b.Run("status index", func(b *testing.B) {
+		withoutPartialIndex(b, db)
+		bench(b)
+	})
+}
+
+func BenchmarkGetAllReadyToGenerate(b *testing.B) {
+	db, _ := openTestDB(b)
+	seedBenchTable(b, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	benchIndexes(b, db, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			images, err := repo.GetAllReadyToGenerate(ctx, 50)
+			if err != nil || len(images) != 50 {
+				b.Fatalf("GetAllReadyToGenerate() = %d images, %v, want 50", len(images), err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetAllReadyToCheck(b *testing.B) {
+	db, _ := openTestDB(b)
+	seedBenchTable(b, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	benchIndexes(b, db, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			images, err := repo.GetAllReadyToCheck(ctx, 50)
+			if err != nil || len(images) != 50 {
+				b.Fatalf("GetAllReadyToCheck() = %d images, %v, want 50", len(images), err)
+			}
+		}
+	})
+}
+
+func BenchmarkClaimForGeneration(b *testing.B) {
+	db, _ := openTestDB(b)
+	seedBenchTable(b, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	benchIndexes(b, db, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			claimed, err := repo.ClaimForGeneration(ctx, 50, "bench", time.Minute)
+			if err != nil || len(claimed) != 50 {
+				b.Fatalf("ClaimForGeneration() = %d images, %v, want 50", len(claimed), err)
+			}
+
+			// Return the claims outside the measurement so every iteration sees the same queue
+			b.StopTimer()
+			for _, img := range claimed {
+				if err := repo.ReleaseClaim(ctx, img.ID, "bench"); err != nil {
+					b.Fatalf("ReleaseClaim() error = %v", err)
+				}
+			}
+			b.StartTimer()
+		}
+	})
+}
+
+func BenchmarkListImages(b *testing.B) {
+	db, _ := openTestDB(b)
+	seedBenchTable(b, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	filters := []struct {
+		name   string
+		filter repository.ListFilter
+	}{
+		{name: "newest", filter: repository.ListFilter{Limit: 50}},
+		{name: "failed", filter: repository.ListFilter{Status: domain.StatusFailed, Limit: 50}},
+		{name: "queued oldest first", filter: repository.ListFilter{Status: domain.StatusReadyToGenerate, SortBy: repository.SortByCreatedAt, Ascending: true, Limit: 50}},
+		{name: "deep page", filter: repository.ListFilter{Limit: 50, Offset: 500_000}},
+	}
+	for _, f := range filters {
+		b.Run(f.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				images, err := repo.ListImages(ctx, f.filter)
+				if err != nil || len(images) != 50 {
+					b.Fatalf("ListImages() = %d images, %v, want 50", len(images), err)
+				}
+			}
+		})
+	}
+
+	b.Run("count failed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := repo.CountImages(ctx, repository.ListFilter{Status: domain.StatusFailed}); err != nil {
+				b.Fatalf("CountImages() error = %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkSaveResult(b *testing.B) {
+	const files, size = 10, 8 << 20
+	db, _ := openTestDB(b)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+	data := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, size/4)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	ids := make([]int, files)
+	for i := range ids {
+		var err error
+		if ids[i], err = repo.CreateImage(ctx, "a lighthouse"); err != nil {
+			b.Fatalf("CreateImage() error = %v", err)
+		}
+	}
+
+	// Both save the same files concurrently; SaveVariants is handed the whole encoding, as the
+	// processor did before results were streamed
+	saves := []struct {
+		name string
+		save func(id int) error
+	}{
+		{name: "SaveVariants", save: func(id int) error {
+			return repo.SaveVariants(ctx, id, []domain.ImageVariant{{Data: encoded}})
+		}},
+		{name: "SaveResultStream", save: func(id int) error {
+			return repo.SaveResultStream(ctx, id, bytes.NewReader(data))
+		}},
+	}
+	for _, s := range saves {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(files * size)
+			for n := 0; n < b.N; n++ {
+				var wg sync.WaitGroup
+				errs := make([]error, files)
+				for i, id := range ids {
+					wg.Add(1)
+					go func(i, id int) {
+						defer wg.Done()
+						errs[i] = s.save(id)
+					}(i, id)
+				}
+				wg.Wait()
+				for _, err := range errs {
+					if err != nil {
+						b.Fatalf("%s() error = %v", s.name, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// seedBlobTable replaces the images with blobRows published images whose base64 column and
+// single variant each hold blobSize characters. It empties the table seedBenchTable fills, so
+// the next benchmark on that table seeds it again.
+func seedBlobTable(b *testing.B, db *sql.DB, blobRows, blobSize int) {
+	b.Helper()
+	emptyTestDB(b, db)
+	query := `
+		WITH inserted AS (
+			INSERT INTO images (prompt, status, uuid, base64, created_at, updated_at)
+			SELECT 'a lighthouse ' || g, 'Published', 'uuid-' || g, repeat('A', $2), now(), now()
+			FROM generate_series(1, $1) AS g
+			RETURNING id
+		)
+		INSERT INTO image_results (image_id, index, data)
+		SELECT id, 0, repeat('A', $2) FROM inserted
+	`
+	if _, err := db.Exec(query, blobRows, blobSize); err != nil {
+		b.Fatalf("failed to seed images: %v", err)
+	}
+	if _, err := db.Exec(`ANALYZE images`); err != nil {
+		b.Fatalf("failed to analyze images: %v", err)
+	}
+}
+
+func BenchmarkBlobColumns(b *testing.B) {
+	const rows, blobSize = 1000, 256 << 10
+	db, _ := openTestDB(b)
+	seedBlobTable(b, db, rows, blobSize)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	// A SELECT * of the same page, which drags every blob across the wire
+	b.Run("select star", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rows, err := db.QueryContext(ctx, `SELECT * FROM images ORDER BY created_at DESC, id DESC LIMIT 50`)
+			if err != nil {
+				b.Fatalf("SELECT * error = %v", err)
+			}
+			columns, _ := rows.Columns()
+			values := make([]any, len(columns))
+			for j := range values {
+				values[j] = new(sql.RawBytes)
+			}
+			n := 0
+			for rows.Next() {
+				if err := rows.Scan(values...); err != nil {
+					b.Fatalf("Scan() error = %v", err)
+				}
+				n++
+			}
+			rows.Close()
+			if n != 50 {
+				b.Fatalf("SELECT * returned %d rows, want 50", n)
+			}
+		}
+	})
+	b.Run("ListImages", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			images, err := repo.ListImages(ctx, repository.ListFilter{Limit: 50})
+			if err != nil || len(images) != 50 || !images[0].HasResult() {
+				b.Fatalf("ListImages() = %d images, %v, want 50 with results", len(images), err)
+			}
+		}
+	})
+
+	b.Run("GetImage", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if img, err := repo.GetImage(ctx, 1+i%rows); err != nil || !img.LegacyResult {
+				b.Fatalf("GetImage() = %+v, %v, want an image with a legacy result", img, err)
+			}
+		}
+	})
+	b.Run("GetImageData", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(blobSize)
+		for i := 0; i < b.N; i++ {
+			if data, err := repo.GetImageData(ctx, 1+i%rows); err != nil || len(data) != blobSize {
+				b.Fatalf("GetImageData() = %d characters, %v, want %d", len(data), err, blobSize)
+			}
+		}
+	})
+
+	b.Run("ListVariants", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if variants, err := repo.ListVariants(ctx, 1+i%rows); err != nil || len(variants) != 1 {
+				b.Fatalf("ListVariants() = %d variants, %v, want 1", len(variants), err)
+			}
+		}
+	})
+	b.Run("GetResult", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(blobSize)
+		for i := 0; i < b.N; i++ {
+			if v, err := repo.GetResult(ctx, 1+i%rows); err != nil || v == nil || len(v.Data) != blobSize {
+				b.Fatalf("GetResult() = %+v, %v, want the variant with its data", v, err)
+			}
+		}
+	})
+}
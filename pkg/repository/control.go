@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// SetPaused pauses or resumes workflow in every process using the database
+func (r *PostgresImageRepository) SetPaused(ctx context.Context, workflow string, paused bool) error {
+	query := `
+		INSERT INTO pipeline_control (workflow, paused)
+		VALUES ($1, $2)
+		ON CONFLICT (workflow) DO UPDATE
+		SET paused = EXCLUDED.paused, updated_at = now()
+	`
+	_, err := r.db.ExecContext(ctx, query, workflow, paused)
+	return err
+}
+
+// GetControls returns the pause state of the workflows that were ever paused, ordered by
+// workflow; the others are running
+func (r *PostgresImageRepository) GetControls(ctx context.Context) ([]domain.WorkflowControl, error) {
+	query := `
+		SELECT workflow, paused, updated_at
+		FROM pipeline_control
+		ORDER BY workflow
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var controls []domain.WorkflowControl
+	for rows.Next() {
+		var c domain.WorkflowControl
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&c.Workflow, &c.Paused, &updatedAt); err != nil {
+			return nil, err
+		}
+		c.UpdatedAt = updatedAt.Time
+		controls = append(controls, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return controls, nil
+}
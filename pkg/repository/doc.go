@@ -0,0 +1,15 @@
+// Package repository stores images and their generation state. ImageRepository is the
+// interface the workflows depend on; PostgresImageRepository implements it on a database whose
+// schema is applied with Migrate:
+//
+//	if err := repository.Migrate(ctx, db); err != nil {
+//		return err
+//	}
+//	repo := repository.NewPostgresImageRepository(db)
+//	id, err := repo.CreateImage(ctx, "a lighthouse at dawn", repository.WithPriority(5))
+//
+// Columns that may be NULL are read with COALESCE or into sql.Null types, so unset values come
+// back as the zero value of their field rather than failing the scan.
+//
+// Package repositorytest checks other implementations against the same contracts.
+package repository
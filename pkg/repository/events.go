@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// actorKey is the context key of the actor set by ContextWithActor
+type actorKey struct{}
+
+// ContextWithActor attributes the status transitions made with ctx to actor in the event log,
+// e.g. the workflow or command making them
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor set by ContextWithActor, empty if there is none
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// inEventTx runs fn in a transaction whose status transitions are recorded in image_events with
+// the actor of ctx and reason
+func (r *PostgresImageRepository) inEventTx(ctx context.Context, reason string, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT set_config('xiang.event_actor', $1, true), set_config('xiang.event_reason', $2, true)`
+	if _, err := tx.ExecContext(ctx, query, actorFrom(ctx), reason); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execEvent runs a status update like ExecContext within inEventTx
+func (r *PostgresImageRepository) execEvent(ctx context.Context, reason string, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := r.inEventTx(ctx, reason, func(tx *sql.Tx) error {
+		var err error
+		res, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// GetEvents returns the status transitions of an image, oldest first
+func (r *PostgresImageRepository) GetEvents(ctx context.Context, imageID int) ([]domain.ImageEvent, error) {
+	query := `
+		SELECT id, image_id, from_status, to_status, COALESCE(actor, ''), COALESCE(reason, ''), created_at
+		FROM image_events
+		WHERE image_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.ImageEvent
+	for rows.Next() {
+		var e domain.ImageEvent
+		var createdAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.ImageID, &e.From, &e.To, &e.Actor, &e.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = createdAt.Time
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PruneEvents deletes the status transitions recorded at least olderThan ago, of any image, and
+// returns the number of events deleted
+func (r *PostgresImageRepository) PruneEvents(ctx context.Context, olderThan time.Duration) (int, error) {
+	query := `DELETE FROM image_events WHERE created_at <= now() - $1 * INTERVAL '1 millisecond'`
+
+	res, err := r.db.ExecContext(ctx, query, olderThan.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
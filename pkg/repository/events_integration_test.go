@@ -0,0 +1,179 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// eventCount returns the number of events recorded for an image
+func eventCount(t *testing.T, db *sql.DB, id int) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM image_events WHERE image_id = $1`, id).Scan(&n); err != nil {
+		t.Fatalf("failed to count events: %v", err)
+	}
+	return n
+}
+
+func TestEventTriggerRecordsEveryStatusChange(t *testing.T) {
+	db, dsn := openTestDB(t)
+	emptyTestDB(t, db)
+	// A single connection shows whether the actor of one transaction leaks into the next
+	single, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer single.Close()
+	single.SetMaxOpenConns(1)
+	repo := repository.NewPostgresImageRepository(single)
+	ctx := context.Background()
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+
+	// Creating an image and changing other columns record nothing
+	if _, err := single.Exec(`UPDATE images SET priority = 3, updated_at = now() WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to update the priority: %v", err)
+	}
+	// Writing the status an image already has records nothing either
+	if _, err := single.Exec(`UPDATE images SET status = status WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to rewrite the status: %v", err)
+	}
+	if n := eventCount(t, single, id); n != 0 {
+		t.Errorf("%d events before the first status change, want none", n)
+	}
+
+	if err := repo.MarkFailed(repository.ContextWithActor(ctx, "processor"), id, "internal error", "permanent"); err != nil {
+		t.Fatalf("MarkFailed() error = %v", err)
+	}
+	// A status change made outside the repository, e.g. by hand in psql, is recorded without an
+	// actor or reason rather than with the ones of the previous transaction
+	if _, err := single.Exec(`UPDATE images SET status = 'Published' WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to update the status: %v", err)
+	}
+	// A transition rolled back with its transaction leaves no event
+	tx, err := single.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if _, err := tx.Exec(`UPDATE images SET status = 'ReadyToGenerate' WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to update the status: %v", err)
+	}
+	tx.Rollback()
+
+	events, err := repo.GetEvents(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	type step struct {
+		From, To      domain.ImageStatus
+		Actor, Reason string
+	}
+	want := []step{
+		{domain.StatusReadyToGenerate, domain.StatusFailed, "processor", "internal error"},
+		{domain.StatusFailed, domain.StatusPublished, "", ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("GetEvents() = %+v, want %d events", events, len(want))
+	}
+	for i, e := range events {
+		if got := (step{e.From, e.To, e.Actor, e.Reason}); got != want[i] || e.CreatedAt.IsZero() {
+			t.Errorf("event %d = %+v at %v, want %+v with a time", i, got, e.CreatedAt, want[i])
+		}
+	}
+
+	// The events go with their image
+	if _, err := single.Exec(`DELETE FROM images WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to delete the image: %v", err)
+	}
+	if n := eventCount(t, single, id); n != 0 {
+		t.Errorf("%d events left after deleting the image, want none", n)
+	}
+}
+
+func TestEventTriggerRecordsBulkUpdates(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := repository.ContextWithActor(context.Background(), "requeue")
+	ids, err := repo.BulkCreate(ctx, []string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("BulkCreate() error = %v", err)
+	}
+	for _, id := range ids {
+		if err := repo.MarkFailed(ctx, id, "model overloaded", "transient"); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+	}
+
+	// One statement moving several images records an event for each of them
+	if n, err := repo.RequeueFailed(ctx, 0, 3); n != len(ids) || err != nil {
+		t.Fatalf("RequeueFailed() = %d, %v, want %d", n, err, len(ids))
+	}
+	for _, id := range ids {
+		events, err := repo.GetEvents(ctx, id)
+		if err != nil {
+			t.Fatalf("GetEvents() error = %v", err)
+		}
+		last := events[len(events)-1]
+		if len(events) != 2 || last.To != domain.StatusReadyToGenerate || last.Actor != "requeue" || last.Reason != "requeued after a transient failure" {
+			t.Errorf("events of image %d = %+v, want the failure and the requeue", id, events)
+		}
+	}
+}
+
+func TestEventTrailOfGeneration(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	cfg := workflows.DefaultConfig()
+	cfg.WorkerID = "test"
+	cfg.CheckInterval = time.Millisecond
+	cfg.PollMaxInterval = time.Millisecond
+	logger := workflows.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if _, err := workflows.NewGenerator(repo, fake, cfg, logger).RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := workflows.NewProcessor(repo, fake, cfg, logger).RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	events, err := repo.GetEvents(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	type transition struct {
+		From, To domain.ImageStatus
+		Actor    string
+	}
+	var got []transition
+	for i, e := range events {
+		if i > 0 && (e.CreatedAt.Before(events[i-1].CreatedAt) || e.ID < events[i-1].ID) {
+			t.Errorf("event %+v precedes the one before, %+v", e, events[i-1])
+		}
+		got = append(got, transition{From: e.From, To: e.To, Actor: e.Actor})
+	}
+	want := []transition{
+		{From: domain.StatusReadyToGenerate, To: domain.StatusClaimed, Actor: "generator"},
+		{From: domain.StatusClaimed, To: domain.StatusGenerate, Actor: "generator"},
+		{From: domain.StatusGenerate, To: domain.StatusReadyToPublish, Actor: "processor"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trail = %+v, want %+v", got, want)
+	}
+}
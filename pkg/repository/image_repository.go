@@ -0,0 +1,1155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+	"github.com/lib/pq"
+)
+
+// ImageRepository defines the interface for image data access
+type ImageRepository interface {
+	GetReadyToGenerate(ctx context.Context) (*domain.Image, error)
+	GetReadyToCheck(ctx context.Context) (*domain.Image, error)
+	UpdateStatus(ctx context.Context, id int, status domain.ImageStatus, opts ...StatusOption) error
+	UpdateStatusWithError(ctx context.Context, id int, status domain.ImageStatus, errorDescription string, opts ...StatusOption) error
+	UpdateUUID(ctx context.Context, id int, uuid string) error
+	UpdateProvider(ctx context.Context, id int, provider string) error
+	UpdateSeed(ctx context.Context, id int, seed int64) error
+	UpdateCensored(ctx context.Context, id int, censored bool) error
+	SaveRawResponse(ctx context.Context, id int, raw []byte) error
+	UpdateBase64(ctx context.Context, id int, base64 string) error
+	UpdateFilePath(ctx context.Context, id int, path string) error
+	UpdateFileURL(ctx context.Context, id int, url string) error
+	UpdateThumbnail(ctx context.Context, id int, thumbnail string) error
+	UpdateThumbnailPath(ctx context.Context, id int, path string) error
+	GetThumbnail(ctx context.Context, id int) ([]byte, string, error)
+	GetImageData(ctx context.Context, id int) (string, error)
+	UpdateMetadata(ctx context.Context, id int, meta domain.ImageMetadata) error
+	ListImages(ctx context.Context, filter ListFilter) ([]*domain.Image, error)
+	CountImages(ctx context.Context, filter ListFilter) (int, error)
+	CountByStatus(ctx context.Context) (map[domain.ImageStatus]int, error)
+	RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) error
+	GetUsage(ctx context.Context, day time.Time) ([]domain.ProviderUsage, error)
+	SetPaused(ctx context.Context, workflow string, paused bool) error
+	GetControls(ctx context.Context) ([]domain.WorkflowControl, error)
+	ListBannedTerms(ctx context.Context) ([]domain.BannedTerm, error)
+	AddBannedTerm(ctx context.Context, term string, regex bool) (int, error)
+	DeleteBannedTerm(ctx context.Context, id int) error
+	GetAllReadyToPublish(ctx context.Context, limit int) ([]*domain.Image, error)
+	MarkPublished(ctx context.Context, id int, url string) error
+	RecordPublishFailure(ctx context.Context, id int, errorDescription string, retryAfter time.Duration) error
+	UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) error
+	SaveVariants(ctx context.Context, id int, variants []domain.ImageVariant) error
+	SaveResultStream(ctx context.Context, id int, r io.Reader) error
+	ListVariants(ctx context.Context, id int) ([]domain.ImageVariant, error)
+	GetVariant(ctx context.Context, id int, index int) (*domain.ImageVariant, error)
+	GetResult(ctx context.Context, id int) (*domain.ImageVariant, error)
+	DeleteVariant(ctx context.Context, id int, index int) error
+	SelectVariant(ctx context.Context, id int, index int) (bool, error)
+	AppendPromptRevision(ctx context.Context, id int, text string, source domain.PromptSource) (int, error)
+	GetPromptHistory(ctx context.Context, id int) ([]domain.PromptRevision, error)
+	GetEvents(ctx context.Context, imageID int) ([]domain.ImageEvent, error)
+	PruneEvents(ctx context.Context, olderThan time.Duration) (int, error)
+	GetAllReadyToGenerate(ctx context.Context, limit int) ([]*domain.Image, error)
+	GetAllReadyToCheck(ctx context.Context, limit int) ([]*domain.Image, error)
+	ClaimForGeneration(ctx context.Context, limit int, worker string, lease time.Duration) ([]*domain.Image, error)
+	ExtendLease(ctx context.Context, id int, worker string, lease time.Duration) (bool, error)
+	ReleaseClaim(ctx context.Context, id int, worker string) error
+	ReclaimExpiredLeases(ctx context.Context) (int, error)
+	CreateImage(ctx context.Context, prompt string, opts ...CreateOption) (int, error)
+	BulkCreate(ctx context.Context, prompts []string, opts ...CreateOption) ([]int, error)
+	CreateImages(ctx context.Context, images []*domain.Image) ([]int, error)
+	CreateFromTemplate(ctx context.Context, tmpl *prompts.PromptTemplate, varSets []map[string]string, opts ...CreateOption) ([]int, error)
+	UpdatePromptHash(ctx context.Context, id int, hash string) error
+	FindByPromptHash(ctx context.Context, hash string, beforeID int) (*domain.Image, error)
+	FindPromptHashes(ctx context.Context, hashes []string) (map[string]int, error)
+	GetImage(ctx context.Context, id int) (*domain.Image, error)
+	Requeue(ctx context.Context, id int) (bool, error)
+	RecordReset(ctx context.Context, id int) error
+	MarkFailed(ctx context.Context, id int, errorDescription string, errorClass string) error
+	RequeueFailed(ctx context.Context, olderThan time.Duration, maxAttempts int) (int, error)
+	TryAdvisoryLock(ctx context.Context, key int64) (bool, error)
+	AdvisoryUnlock(ctx context.Context, key int64) error
+	ListenForNewImages(ctx context.Context) (<-chan struct{}, error)
+	Ping(ctx context.Context) error
+}
+
+// PostgresImageRepository implements ImageRepository for PostgreSQL
+type PostgresImageRepository struct {
+	db *sql.DB
+
+	// locks holds the connections of the advisory locks taken by TryAdvisoryLock
+	locksMu sync.Mutex
+	locks   map[int64]*sql.Conn
+
+	// listenDSN is the connection string of ListenForNewImages; empty disables it
+	listenDSN string
+	// defaultWidth and defaultHeight complete the prompt hashes of images without a size
+	defaultWidth, defaultHeight int
+}
+
+// NewPostgresImageRepository creates a new PostgreSQL image repository
+func NewPostgresImageRepository(db *sql.DB, opts ...PostgresOption) *PostgresImageRepository {
+	r := &PostgresImageRepository{db: db}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithDefaultSize sets the size the prompt hashes of images without their own are computed
+// with, which should be the default size images are generated at
+func WithDefaultSize(width, height int) PostgresOption {
+	return func(r *PostgresImageRepository) {
+		r.defaultWidth, r.defaultHeight = width, height
+	}
+}
+
+// Ping checks that the database is reachable
+func (r *PostgresImageRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// GetReadyToGenerate retrieves an image ready for generation
+func (r *PostgresImageRepository) GetReadyToGenerate(ctx context.Context) (*domain.Image, error) {
+	query := `
+		SELECT id, prompt, requeue_attempts, created_at, updated_at
+		FROM images
+		WHERE status = 'ReadyToGenerate'
+		AND prompt IS NOT NULL
+		AND prompt != ''
+		AND (scheduled_at IS NULL OR scheduled_at <= now())
+		ORDER BY created_at ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	var img domain.Image
+	var createdAt, updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query).Scan(
+		&img.ID,
+		&img.Prompt,
+		&img.Attempts,
+		&createdAt,
+		&updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.CreatedAt = createdAt.Time
+	img.UpdatedAt = updatedAt.Time
+
+	return &img, nil
+}
+
+// GetReadyToCheck retrieves an image ready for status check
+func (r *PostgresImageRepository) GetReadyToCheck(ctx context.Context) (*domain.Image, error) {
+	query := `
+		SELECT id, COALESCE(uuid, ''), requeue_attempts, created_at, updated_at
+		FROM images
+		WHERE status = 'Generate'
+		AND uuid IS NOT NULL
+		AND uuid != ''
+		ORDER BY created_at ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	var img domain.Image
+	var createdAt, updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query).Scan(&img.ID, &img.UUID, &img.Attempts, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.CreatedAt = createdAt.Time
+	img.UpdatedAt = updatedAt.Time
+
+	return &img, nil
+}
+
+// UpdateStatus updates the status of an image; moving it to Generate records when generation
+// started. Transitions domain.ValidTransitions does not allow fail with a *domain.TransitionError
+// unless the update is forced.
+func (r *PostgresImageRepository) UpdateStatus(ctx context.Context, id int, status domain.ImageStatus, opts ...StatusOption) error {
+	query := `
+		UPDATE images
+		SET status = $1, updated_at = now(),
+			generation_started_at = CASE WHEN $1 = 'Generate' THEN now() ELSE generation_started_at END
+		WHERE id = $2
+		AND status = ANY($3)
+	`
+
+	o := newStatusOptions(opts)
+	res, err := r.execEvent(ctx, o.reason, query, status, id, allowedFrom(status, o))
+	if err != nil {
+		return err
+	}
+	return r.checkTransition(ctx, res, id, status)
+}
+
+// UpdateStatusWithError updates the status of an image and records why it changed, validating
+// the transition like UpdateStatus
+func (r *PostgresImageRepository) UpdateStatusWithError(ctx context.Context, id int, status domain.ImageStatus, errorDescription string, opts ...StatusOption) error {
+	query := `
+		UPDATE images
+		SET status = $1, error_description = $2, updated_at = now()
+		WHERE id = $3
+		AND status = ANY($4)
+	`
+
+	o := newStatusOptions(opts)
+	reason := o.reason
+	if reason == "" {
+		reason = errorDescription
+	}
+	res, err := r.execEvent(ctx, reason, query, status, errorDescription, id, allowedFrom(status, o))
+	if err != nil {
+		return err
+	}
+	return r.checkTransition(ctx, res, id, status)
+}
+
+// UpdateUUID updates the UUID of an image
+func (r *PostgresImageRepository) UpdateUUID(ctx context.Context, id int, uuid string) error {
+	query := `
+		UPDATE images
+		SET uuid = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, uuid, id)
+	return err
+}
+
+// UpdateProvider records which provider handled the generation of an image
+func (r *PostgresImageRepository) UpdateProvider(ctx context.Context, id int, provider string) error {
+	query := `
+		UPDATE images
+		SET provider = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, provider, id)
+	return err
+}
+
+// UpdateSeed records the seed used to generate an image
+func (r *PostgresImageRepository) UpdateSeed(ctx context.Context, id int, seed int64) error {
+	query := `
+		UPDATE images
+		SET seed = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, seed, id)
+	return err
+}
+
+// UpdateCensored records whether a generation of an image was censored
+func (r *PostgresImageRepository) UpdateCensored(ctx context.Context, id int, censored bool) error {
+	query := `
+		UPDATE images
+		SET censored = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, censored, id)
+	return err
+}
+
+// SaveRawResponse stores the provider's status response of a failed or censored generation,
+// which must be JSON; see rawresponse.Sanitize
+func (r *PostgresImageRepository) SaveRawResponse(ctx context.Context, id int, raw []byte) error {
+	query := `
+		UPDATE images
+		SET raw_response = $1::jsonb, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, string(raw), id)
+	return err
+}
+
+// UpdateBase64 updates the base64 data of an image
+func (r *PostgresImageRepository) UpdateBase64(ctx context.Context, id int, base64 string) error {
+	query := `
+		UPDATE images
+		SET base64 = NULLIF($1, ''), updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, base64, id)
+	return err
+}
+
+// UpdateFilePath records where the first generated file of an image is stored
+func (r *PostgresImageRepository) UpdateFilePath(ctx context.Context, id int, path string) error {
+	query := `
+		UPDATE images
+		SET file_path = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, path, id)
+	return err
+}
+
+// UpdateFileURL records a URL the first generated file of an image can be downloaded from
+func (r *PostgresImageRepository) UpdateFileURL(ctx context.Context, id int, url string) error {
+	query := `
+		UPDATE images
+		SET file_url = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, url, id)
+	return err
+}
+
+// UpdateThumbnail stores the base64 JPEG thumbnail of an image
+func (r *PostgresImageRepository) UpdateThumbnail(ctx context.Context, id int, thumbnail string) error {
+	query := `
+		UPDATE images
+		SET thumbnail = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, thumbnail, id)
+	return err
+}
+
+// UpdateThumbnailPath records where the thumbnail of an image is stored
+func (r *PostgresImageRepository) UpdateThumbnailPath(ctx context.Context, id int, path string) error {
+	query := `
+		UPDATE images
+		SET thumbnail_path = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, path, id)
+	return err
+}
+
+// GetThumbnail retrieves the thumbnail of an image: the decoded JPEG when it is stored in the
+// database, otherwise its storage location. Both are empty when the image has no thumbnail.
+func (r *PostgresImageRepository) GetThumbnail(ctx context.Context, id int) ([]byte, string, error) {
+	query := `
+		SELECT COALESCE(thumbnail, ''), COALESCE(thumbnail_path, '')
+		FROM images
+		WHERE id = $1
+	`
+
+	var thumbnail, path string
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&thumbnail, &path)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if thumbnail == "" {
+		return nil, path, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(thumbnail)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode thumbnail: %w", err)
+	}
+	return data, path, nil
+}
+
+// GetImageData retrieves the legacy base64 copy of the first generated file of an image, empty
+// when there is none. Every other query leaves the column out; see domain.Image.LegacyResult.
+func (r *PostgresImageRepository) GetImageData(ctx context.Context, id int) (string, error) {
+	var data string
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(base64, '') FROM images WHERE id = $1`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return data, err
+}
+
+// UpdateMetadata records the dimensions, size, format and generation time of an image's result
+func (r *PostgresImageRepository) UpdateMetadata(ctx context.Context, id int, meta domain.ImageMetadata) error {
+	query := `
+		UPDATE images
+		SET output_width = NULLIF($1, 0), output_height = NULLIF($2, 0), byte_size = $3,
+			format = NULLIF($4, ''), generation_duration_ms = NULLIF($5, 0), updated_at = now()
+		WHERE id = $6
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		meta.Width,
+		meta.Height,
+		meta.ByteSize,
+		meta.Format,
+		meta.GenerationDuration.Milliseconds(),
+		id,
+	)
+	return err
+}
+
+// ListFilter narrows down and orders the images returned by ListImages
+type ListFilter struct {
+	Status domain.ImageStatus // empty matches every status
+	Tag    string             // empty matches every tag
+	Limit  int                // zero means no limit
+	Offset int
+	SortBy string // SortByID (default), SortByCreatedAt or SortByUpdatedAt
+	// Ascending sorts oldest first instead of newest first
+	Ascending bool
+}
+
+// Columns ListImages can sort by
+const (
+	SortByID        = "id"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+// orderBy returns the ORDER BY clause of f; the column is checked against the known ones
+// since it cannot be passed as a query argument
+func (f ListFilter) orderBy() (string, error) {
+	column := f.SortBy
+	switch column {
+	case "":
+		column = SortByID
+	case SortByID, SortByCreatedAt, SortByUpdatedAt:
+	default:
+		return "", fmt.Errorf("cannot sort images by %q", f.SortBy)
+	}
+
+	direction := "DESC"
+	if f.Ascending {
+		direction = "ASC"
+	}
+	// Ties on the timestamps are broken by ID so pages do not overlap
+	return fmt.Sprintf("%s %s, id %s", column, direction, direction), nil
+}
+
+// ListImages retrieves the images matching filter, newest first unless sorted otherwise, with
+// every field except their image data
+func (r *PostgresImageRepository) ListImages(ctx context.Context, filter ListFilter) ([]*domain.Image, error) {
+	orderBy, err := filter.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT id, prompt, COALESCE(uuid, ''), status, base64 IS NOT NULL, COALESCE(seed, 0),
+			COALESCE(width, 0), COALESCE(height, 0), COALESCE(style, ''), COALESCE(negative_prompt, ''),
+			skip_watermark, censored, (SELECT COUNT(*) FROM image_results WHERE image_id = images.id), selected_variant,
+			generation_started_at, COALESCE(output_width, 0), COALESCE(output_height, 0), COALESCE(byte_size, 0),
+			COALESCE(format, ''), COALESCE(generation_duration_ms, 0),
+			COALESCE(callback_url, ''), COALESCE(file_url, ''),
+			publish_attempts, published_at, COALESCE(published_url, ''),
+			priority, scheduled_at, tags, params, COALESCE(provider, ''), COALESCE(error_description, ''),
+			requeue_attempts, reset_count, created_at, updated_at
+		FROM images
+		WHERE ($1 = '' OR status = $1)
+		AND ($2 = '' OR $2 = ANY(tags))
+		ORDER BY ` + orderBy + `
+		LIMIT NULLIF($3, 0) OFFSET $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, filter.Status, filter.Tag, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*domain.Image
+	for rows.Next() {
+		var img domain.Image
+		var durationMS int64
+		var startedAt, publishedAt, scheduledAt, createdAt, updatedAt sql.NullTime
+		err := rows.Scan(
+			&img.ID,
+			&img.Prompt,
+			&img.UUID,
+			&img.Status,
+			&img.LegacyResult,
+			&img.Seed,
+			&img.Width,
+			&img.Height,
+			&img.Style,
+			&img.NegativePrompt,
+			&img.SkipWatermark,
+			&img.Censored,
+			&img.Variants,
+			&img.SelectedVariant,
+			&startedAt,
+			&img.Metadata.Width,
+			&img.Metadata.Height,
+			&img.Metadata.ByteSize,
+			&img.Metadata.Format,
+			&durationMS,
+			&img.CallbackURL,
+			&img.FileURL,
+			&img.PublishAttempts,
+			&publishedAt,
+			&img.PublishedURL,
+			&img.Priority,
+			&scheduledAt,
+			pq.Array(&img.Tags),
+			(*jsonParams)(&img.Params),
+			&img.Provider,
+			&img.ErrorDescription,
+			&img.Attempts,
+			&img.ResetCount,
+			&createdAt,
+			&updatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		img.Metadata.GenerationDuration = time.Duration(durationMS) * time.Millisecond
+		img.GenerationStartedAt = startedAt.Time
+		img.PublishedAt = publishedAt.Time
+		img.ScheduledAt = scheduledAt.Time
+		img.CreatedAt = createdAt.Time
+		img.UpdatedAt = updatedAt.Time
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// CountImages returns how many images match the status and tag of filter, ignoring its
+// pagination
+func (r *PostgresImageRepository) CountImages(ctx context.Context, filter ListFilter) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM images
+		WHERE ($1 = '' OR status = $1)
+		AND ($2 = '' OR $2 = ANY(tags))
+	`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, filter.Status, filter.Tag).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetImage retrieves a single image with its metadata, or nil if it does not exist
+func (r *PostgresImageRepository) GetImage(ctx context.Context, id int) (*domain.Image, error) {
+	query := `
+		SELECT id, prompt, COALESCE(uuid, ''), status, base64 IS NOT NULL, COALESCE(seed, 0),
+			COALESCE(width, 0), COALESCE(height, 0), COALESCE(style, ''), COALESCE(negative_prompt, ''),
+			skip_watermark, censored, (SELECT COUNT(*) FROM image_results WHERE image_id = images.id), selected_variant,
+			generation_started_at, COALESCE(output_width, 0), COALESCE(output_height, 0), COALESCE(byte_size, 0),
+			COALESCE(format, ''), COALESCE(generation_duration_ms, 0),
+			COALESCE(callback_url, ''), COALESCE(file_url, ''),
+			publish_attempts, published_at, COALESCE(published_url, ''),
+			priority, scheduled_at, tags, params, COALESCE(provider, ''), COALESCE(worker_id, ''), lease_expires_at,
+			COALESCE(error_description, ''), COALESCE(raw_response::text, ''), requeue_attempts,
+			reset_count, created_at, updated_at
+		FROM images
+		WHERE id = $1
+	`
+
+	var img domain.Image
+	var durationMS int64
+	var rawResponse string
+	var startedAt, publishedAt, scheduledAt, leaseExpiresAt, createdAt, updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&img.ID,
+		&img.Prompt,
+		&img.UUID,
+		&img.Status,
+		&img.LegacyResult,
+		&img.Seed,
+		&img.Width,
+		&img.Height,
+		&img.Style,
+		&img.NegativePrompt,
+		&img.SkipWatermark,
+		&img.Censored,
+		&img.Variants,
+		&img.SelectedVariant,
+		&startedAt,
+		&img.Metadata.Width,
+		&img.Metadata.Height,
+		&img.Metadata.ByteSize,
+		&img.Metadata.Format,
+		&durationMS,
+		&img.CallbackURL,
+		&img.FileURL,
+		&img.PublishAttempts,
+		&publishedAt,
+		&img.PublishedURL,
+		&img.Priority,
+		&scheduledAt,
+		pq.Array(&img.Tags),
+		(*jsonParams)(&img.Params),
+		&img.Provider,
+		&img.WorkerID,
+		&leaseExpiresAt,
+		&img.ErrorDescription,
+		&rawResponse,
+		&img.Attempts,
+		&img.ResetCount,
+		&createdAt,
+		&updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rawResponse != "" {
+		img.RawResponse = json.RawMessage(rawResponse)
+	}
+	img.Metadata.GenerationDuration = time.Duration(durationMS) * time.Millisecond
+	img.GenerationStartedAt = startedAt.Time
+	img.PublishedAt = publishedAt.Time
+	img.ScheduledAt = scheduledAt.Time
+	img.LeaseExpiresAt = leaseExpiresAt.Time
+	img.CreatedAt = createdAt.Time
+	img.UpdatedAt = updatedAt.Time
+
+	return &img, nil
+}
+
+// Requeue moves an image back to ReadyToGenerate, clearing its UUID, error and reset count. It
+// reports false when the image does not exist or is still queued, claimed or being generated.
+func (r *PostgresImageRepository) Requeue(ctx context.Context, id int) (bool, error) {
+	query := `
+		UPDATE images
+		SET status = 'ReadyToGenerate', uuid = NULL, error_description = NULL, reset_count = 0, updated_at = now()
+		WHERE id = $1
+		AND status NOT IN ('ReadyToGenerate', 'Claimed', 'Generate')
+	`
+
+	res, err := r.execEvent(ctx, "requeued", query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RecordReset counts a reset of an image to ReadyToGenerate after the provider lost its
+// generation
+func (r *PostgresImageRepository) RecordReset(ctx context.Context, id int) error {
+	query := `
+		UPDATE images
+		SET reset_count = reset_count + 1, updated_at = now()
+		WHERE id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed marks an image Failed with the description and errclass class of the error,
+// validating the transition like UpdateStatus
+func (r *PostgresImageRepository) MarkFailed(ctx context.Context, id int, errorDescription string, errorClass string) error {
+	query := `
+		UPDATE images
+		SET status = 'Failed', error_description = $1, error_class = $2, updated_at = now()
+		WHERE id = $3
+		AND status = ANY($4)
+	`
+
+	res, err := r.execEvent(ctx, errorDescription, query, errorDescription, errorClass, id, allowedFrom(domain.StatusFailed, statusOptions{}))
+	if err != nil {
+		return err
+	}
+	return r.checkTransition(ctx, res, id, domain.StatusFailed)
+}
+
+// RequeueFailed moves images that failed at least olderThan ago with one of the
+// errclass.RequeueableClasses back to ReadyToGenerate, clearing their UUID and error. Images
+// already requeued maxAttempts times this way are left Failed. It returns the number of
+// images requeued.
+func (r *PostgresImageRepository) RequeueFailed(ctx context.Context, olderThan time.Duration, maxAttempts int) (int, error) {
+	query := `
+		UPDATE images
+		SET status = 'ReadyToGenerate', uuid = NULL, error_description = NULL, error_class = NULL,
+			requeue_attempts = requeue_attempts + 1, updated_at = now()
+		WHERE status = 'Failed'
+		AND error_class = ANY($1)
+		AND updated_at <= now() - $2 * INTERVAL '1 millisecond'
+		AND requeue_attempts < $3
+	`
+
+	res, err := r.execEvent(ctx, "requeued after a transient failure", query, pq.Array(errclass.RequeueableClasses), olderThan.Milliseconds(), maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// CountByStatus returns the number of images in each status; statuses without images are omitted
+func (r *PostgresImageRepository) CountByStatus(ctx context.Context) (map[domain.ImageStatus]int, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM images GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[domain.ImageStatus]int)
+	for rows.Next() {
+		var status domain.ImageStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// UpdateWebhookStatus records the outcome of the webhook delivery for an image
+func (r *PostgresImageRepository) UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) error {
+	query := `
+		UPDATE images
+		SET webhook_status = $1, webhook_attempts = $2, updated_at = now()
+		WHERE id = $3
+	`
+
+	_, err := r.db.ExecContext(ctx, query, status, attempts, id)
+	return err
+}
+
+// GetAllReadyToGenerate retrieves up to limit images ready for generation, highest priority
+// first; zero means no limit
+func (r *PostgresImageRepository) GetAllReadyToGenerate(ctx context.Context, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT id, prompt, COALESCE(seed, 0), censored, COALESCE(width, 0), COALESCE(height, 0),
+			COALESCE(style, ''), COALESCE(negative_prompt, ''), skip_watermark, COALESCE(callback_url, ''),
+			params, requeue_attempts, created_at, updated_at
+		FROM images
+		WHERE status = 'ReadyToGenerate'
+		AND prompt IS NOT NULL
+		AND prompt != ''
+		AND (scheduled_at IS NULL OR scheduled_at <= now())
+		ORDER BY priority DESC, created_at ASC
+		LIMIT NULLIF($1, 0)
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*domain.Image
+	for rows.Next() {
+		var img domain.Image
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(
+			&img.ID,
+			&img.Prompt,
+			&img.Seed,
+			&img.Censored,
+			&img.Width,
+			&img.Height,
+			&img.Style,
+			&img.NegativePrompt,
+			&img.SkipWatermark,
+			&img.CallbackURL,
+			(*jsonParams)(&img.Params),
+			&img.Attempts,
+			&createdAt,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		img.CreatedAt = createdAt.Time
+		img.UpdatedAt = updatedAt.Time
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// GetAllReadyToCheck retrieves up to limit images ready for status check, oldest first; zero
+// means no limit
+func (r *PostgresImageRepository) GetAllReadyToCheck(ctx context.Context, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT id, COALESCE(uuid, ''), censored, skip_watermark, generation_started_at, COALESCE(callback_url, ''),
+			COALESCE(provider, ''), requeue_attempts, reset_count, created_at, updated_at
+		FROM images
+		WHERE status = 'Generate'
+		AND uuid IS NOT NULL
+		AND uuid != ''
+		ORDER BY created_at ASC
+		LIMIT NULLIF($1, 0)
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*domain.Image
+	for rows.Next() {
+		var img domain.Image
+		var startedAt, createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(
+			&img.ID,
+			&img.UUID,
+			&img.Censored,
+			&img.SkipWatermark,
+			&startedAt,
+			&img.CallbackURL,
+			&img.Provider,
+			&img.Attempts,
+			&img.ResetCount,
+			&createdAt,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		img.GenerationStartedAt = startedAt.Time
+		img.CreatedAt = createdAt.Time
+		img.UpdatedAt = updatedAt.Time
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// CreateOption sets optional per-image generation parameters in CreateImage
+type CreateOption func(*domain.Image)
+
+// WithStyle overrides the configured default style
+func WithStyle(style string) CreateOption {
+	return func(img *domain.Image) {
+		img.Style = style
+	}
+}
+
+// WithNegativePrompt overrides the configured default negative prompt
+func WithNegativePrompt(negativePrompt string) CreateOption {
+	return func(img *domain.Image) {
+		img.NegativePrompt = negativePrompt
+	}
+}
+
+// WithSize overrides the configured default width and height
+func WithSize(width, height int) CreateOption {
+	return func(img *domain.Image) {
+		img.Width = width
+		img.Height = height
+	}
+}
+
+// WithSeed pins the seed used to generate the image
+func WithSeed(seed int64) CreateOption {
+	return func(img *domain.Image) {
+		img.Seed = seed
+	}
+}
+
+// WithoutWatermark excludes the image from the configured watermark
+func WithoutWatermark() CreateOption {
+	return func(img *domain.Image) {
+		img.SkipWatermark = true
+	}
+}
+
+// WithCallbackURL notifies url when the image finishes or fails
+func WithCallbackURL(url string) CreateOption {
+	return func(img *domain.Image) {
+		img.CallbackURL = url
+	}
+}
+
+// WithPriority submits the image before queued images of lower priority
+func WithPriority(priority int) CreateOption {
+	return func(img *domain.Image) {
+		img.Priority = priority
+	}
+}
+
+// WithTags labels the image with tags
+func WithTags(tags ...string) CreateOption {
+	return func(img *domain.Image) {
+		img.Tags = tags
+	}
+}
+
+// WithParams passes extra generation parameters verbatim to the provider
+func WithParams(params map[string]interface{}) CreateOption {
+	return func(img *domain.Image) {
+		img.Params = params
+	}
+}
+
+// WithScheduledAt holds the image back from generation until t
+func WithScheduledAt(t time.Time) CreateOption {
+	return func(img *domain.Image) {
+		img.ScheduledAt = t
+	}
+}
+
+// createColumns are the columns set when an image is queued, in the order of createValues
+const createColumns = `prompt, status, prompt_hash, style, negative_prompt, width, height, seed, skip_watermark, callback_url, priority, tags, params, scheduled_at`
+
+// createArgCount is the number of arguments createArgs returns per image
+const createArgCount = 13
+
+// createBatchSize is the number of images a statement of CreateImages inserts, keeping its
+// arguments below the limit of 65535 of Postgres
+const createBatchSize = 1000
+
+// createValues returns the VALUES row of an image queued for generation, with the parameters
+// of its createArgs numbered from n+1
+func createValues(n int) string {
+	p := make([]interface{}, createArgCount)
+	for i := range p {
+		p[i] = n + i + 1
+	}
+	return fmt.Sprintf("($%d, 'ReadyToGenerate', $%d, NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, 0), NULLIF($%d, 0), NULLIF($%d, 0), $%d, NULLIF($%d, ''), $%d, COALESCE($%d::TEXT[], '{}'), $%d::JSONB, $%d::TIMESTAMPTZ)", p...)
+}
+
+// createImageQuery inserts an image queued for generation
+var createImageQuery = `INSERT INTO images (` + createColumns + `) VALUES ` + createValues(0) + ` RETURNING id`
+
+// newImage builds an image for prompt with the given options applied
+func newImage(prompt string, opts []CreateOption) *domain.Image {
+	img := &domain.Image{Prompt: prompt}
+	for _, opt := range opts {
+		opt(img)
+	}
+	return img
+}
+
+// createArgs returns the arguments of createImageQuery for img
+func (r *PostgresImageRepository) createArgs(img *domain.Image) []interface{} {
+	return []interface{}{
+		img.Prompt,
+		img.PromptHash(r.defaultWidth, r.defaultHeight),
+		img.Style,
+		img.NegativePrompt,
+		img.Width,
+		img.Height,
+		img.Seed,
+		img.SkipWatermark,
+		img.CallbackURL,
+		img.Priority,
+		pq.Array(img.Tags),
+		jsonParams(img.Params),
+		sql.NullTime{Time: img.ScheduledAt, Valid: !img.ScheduledAt.IsZero()},
+	}
+}
+
+// CreateImage queues a new image for generation and returns its ID
+func (r *PostgresImageRepository) CreateImage(ctx context.Context, prompt string, opts ...CreateOption) (int, error) {
+	var id int
+	if err := r.db.QueryRowContext(ctx, createImageQuery, r.createArgs(newImage(prompt, opts))...).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// BulkCreate queues an image for every prompt in a single transaction and returns their IDs
+// in the same order; the options apply to all of them
+func (r *PostgresImageRepository) BulkCreate(ctx context.Context, prompts []string, opts ...CreateOption) ([]int, error) {
+	images := make([]*domain.Image, 0, len(prompts))
+	for _, prompt := range prompts {
+		images = append(images, newImage(prompt, opts))
+	}
+	return r.CreateImages(ctx, images)
+}
+
+// CreateImages queues images with their own prompt and parameters in a single transaction and
+// returns their IDs in the same order. The images are inserted with multi-row INSERTs of up
+// to createBatchSize rows.
+func (r *PostgresImageRepository) CreateImages(ctx context.Context, images []*domain.Image) ([]int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, 0, len(images))
+	for start := 0; start < len(images); start += createBatchSize {
+		batch := images[start:min(start+createBatchSize, len(images))]
+		var query strings.Builder
+		args := make([]interface{}, 0, len(batch)*createArgCount)
+		query.WriteString("INSERT INTO images (" + createColumns + ") VALUES ")
+		for i, img := range batch {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString(createValues(len(args)))
+			args = append(args, r.createArgs(img)...)
+		}
+		query.WriteString(" RETURNING id")
+
+		batchIDs, err := queryIDs(ctx, tx, query.String(), args...)
+		if err != nil {
+			return nil, err
+		}
+		// The rows of a statement draw their IDs from the sequence in order, while RETURNING
+		// does not promise any order
+		sort.Ints(batchIDs)
+		ids = append(ids, batchIDs...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// queryIDs runs a query in tx returning a single column of IDs
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateFromTemplate expands tmpl against every variable set and queues the resulting prompts.
+// Nothing is inserted when any expansion fails.
+func (r *PostgresImageRepository) CreateFromTemplate(ctx context.Context, tmpl *prompts.PromptTemplate, varSets []map[string]string, opts ...CreateOption) ([]int, error) {
+	expanded, err := tmpl.ExpandAll(varSets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand template: %w", err)
+	}
+	return r.BulkCreate(ctx, expanded, opts...)
+}
+
+// UpdatePromptHash updates the prompt hash of an image
+func (r *PostgresImageRepository) UpdatePromptHash(ctx context.Context, id int, hash string) error {
+	query := `
+		UPDATE images
+		SET prompt_hash = $1, updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query, hash, id)
+	return err
+}
+
+// FindPromptHashes returns the ID of the oldest image with each of hashes that has not failed
+// or been marked as a duplicate; hashes without such an image are left out
+func (r *PostgresImageRepository) FindPromptHashes(ctx context.Context, hashes []string) (map[string]int, error) {
+	query := `
+		SELECT prompt_hash, MIN(id)
+		FROM images
+		WHERE prompt_hash = ANY($1)
+		AND status NOT IN ('Failed', 'TimedOut', 'Duplicate', 'Censored', 'Rejected')
+		GROUP BY prompt_hash
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]int)
+	for rows.Next() {
+		var hash string
+		var id int
+		if err := rows.Scan(&hash, &id); err != nil {
+			return nil, err
+		}
+		found[hash] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// FindByPromptHash retrieves the oldest image created before beforeID with the same
+// prompt hash that has not failed or been marked as a duplicate itself
+func (r *PostgresImageRepository) FindByPromptHash(ctx context.Context, hash string, beforeID int) (*domain.Image, error) {
+	query := `
+		SELECT id, prompt, COALESCE(uuid, ''), status, base64 IS NOT NULL,
+			(SELECT COUNT(*) FROM image_results WHERE image_id = images.id), selected_variant,
+			requeue_attempts, created_at, updated_at
+		FROM images
+		WHERE prompt_hash = $1
+		AND id < $2
+		AND status NOT IN ('Failed', 'TimedOut', 'Duplicate', 'Censored', 'Rejected')
+		ORDER BY id ASC
+		LIMIT 1
+	`
+
+	var img domain.Image
+	var createdAt, updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, hash, beforeID).Scan(
+		&img.ID,
+		&img.Prompt,
+		&img.UUID,
+		&img.Status,
+		&img.LegacyResult,
+		&img.Variants,
+		&img.SelectedVariant,
+		&img.Attempts,
+		&createdAt,
+		&updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.CreatedAt = createdAt.Time
+	img.UpdatedAt = updatedAt.Time
+
+	return &img, nil
+}
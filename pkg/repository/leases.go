@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// ClaimForGeneration moves up to limit images ready for generation to Claimed, highest priority
+// first, on behalf of worker, which holds them for lease; zero means no limit. Images claimed by
+// one worker are not returned to another until ReclaimExpiredLeases gives them back.
+func (r *PostgresImageRepository) ClaimForGeneration(ctx context.Context, limit int, worker string, lease time.Duration) ([]*domain.Image, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE images
+			SET status = 'Claimed', worker_id = $2,
+				lease_expires_at = now() + $3 * INTERVAL '1 millisecond', updated_at = now()
+			WHERE id IN (
+				SELECT id
+				FROM images
+				WHERE status = 'ReadyToGenerate'
+				AND prompt IS NOT NULL
+				AND prompt != ''
+				AND (scheduled_at IS NULL OR scheduled_at <= now())
+				ORDER BY priority DESC, created_at ASC
+				LIMIT NULLIF($1, 0)
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, prompt, seed, censored, width, height, style, negative_prompt,
+				skip_watermark, callback_url, params, priority, worker_id, lease_expires_at,
+				requeue_attempts, created_at, updated_at
+		)
+		SELECT id, prompt, COALESCE(seed, 0), censored, COALESCE(width, 0), COALESCE(height, 0),
+			COALESCE(style, ''), COALESCE(negative_prompt, ''), skip_watermark, COALESCE(callback_url, ''),
+			params, priority, COALESCE(worker_id, ''), lease_expires_at, requeue_attempts, created_at, updated_at
+		FROM claimed
+		ORDER BY priority DESC, created_at ASC
+	`
+
+	var images []*domain.Image
+	err := r.inEventTx(ctx, "claimed by "+worker, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, limit, worker, lease.Milliseconds())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		images, err = scanClaimed(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// scanClaimed reads the images returned by the claim of ClaimForGeneration
+func scanClaimed(rows *sql.Rows) ([]*domain.Image, error) {
+	var images []*domain.Image
+	for rows.Next() {
+		img := domain.Image{Status: domain.StatusClaimed}
+		var leaseExpiresAt, createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(
+			&img.ID,
+			&img.Prompt,
+			&img.Seed,
+			&img.Censored,
+			&img.Width,
+			&img.Height,
+			&img.Style,
+			&img.NegativePrompt,
+			&img.SkipWatermark,
+			&img.CallbackURL,
+			(*jsonParams)(&img.Params),
+			&img.Priority,
+			&img.WorkerID,
+			&leaseExpiresAt,
+			&img.Attempts,
+			&createdAt,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		img.LeaseExpiresAt = leaseExpiresAt.Time
+		img.CreatedAt = createdAt.Time
+		img.UpdatedAt = updatedAt.Time
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// ExtendLease renews the claim of worker on an image for another lease from now. It reports
+// false when the image is no longer Claimed by worker, e.g. because the lease lapsed and another
+// worker claimed it.
+func (r *PostgresImageRepository) ExtendLease(ctx context.Context, id int, worker string, lease time.Duration) (bool, error) {
+	query := `
+		UPDATE images
+		SET lease_expires_at = now() + $3 * INTERVAL '1 millisecond'
+		WHERE id = $1
+		AND status = 'Claimed'
+		AND worker_id = $2
+	`
+
+	res, err := r.db.ExecContext(ctx, query, id, worker, lease.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ReleaseClaim returns an image still Claimed by worker to ReadyToGenerate, so the next pass
+// picks it up again without waiting for the lease to lapse. Images that moved on are left alone.
+func (r *PostgresImageRepository) ReleaseClaim(ctx context.Context, id int, worker string) error {
+	query := `
+		UPDATE images
+		SET status = 'ReadyToGenerate', lease_expires_at = NULL, updated_at = now()
+		WHERE id = $1
+		AND status = 'Claimed'
+		AND worker_id = $2
+	`
+
+	_, err := r.execEvent(ctx, "claim released", query, id, worker)
+	return err
+}
+
+// ReclaimExpiredLeases returns Claimed images whose lease has lapsed to ReadyToGenerate and
+// reports how many there were. Their workers stopped renewing the claims, most likely because
+// they crashed.
+func (r *PostgresImageRepository) ReclaimExpiredLeases(ctx context.Context) (int, error) {
+	query := `
+		UPDATE images
+		SET status = 'ReadyToGenerate', lease_expires_at = NULL, updated_at = now()
+		WHERE status = 'Claimed'
+		AND lease_expires_at < now()
+	`
+
+	res, err := r.execEvent(ctx, "lease expired", query)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
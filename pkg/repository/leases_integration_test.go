@@ -0,0 +1,178 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// claimedIDs returns the IDs of the claimed images in order
+func claimedIDs(images []*domain.Image) []int {
+	ids := make([]int, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	return ids
+}
+
+func TestClaimForGenerationAcrossReplicas(t *testing.T) {
+	db, dsn := openTestDB(t)
+	emptyTestDB(t, db)
+	ctx := context.Background()
+	const images = 40
+	prompts := make([]string, images)
+	for i := range prompts {
+		prompts[i] = "a lighthouse"
+	}
+	if _, err := repository.NewPostgresImageRepository(db).BulkCreate(ctx, prompts); err != nil {
+		t.Fatalf("BulkCreate() error = %v", err)
+	}
+
+	// Replicas claim small batches at the same time until the queue is empty; SKIP LOCKED hands
+	// every image to exactly one of them without blocking the others
+	workers := []string{"worker-a", "worker-b", "worker-c", "worker-d"}
+	var mu sync.Mutex
+	claimedBy := make(map[int][]string)
+	var wg sync.WaitGroup
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(worker string, repo *repository.PostgresImageRepository) {
+			defer wg.Done()
+			for {
+				claimed, err := repo.ClaimForGeneration(ctx, 3, worker, time.Minute)
+				if err != nil {
+					t.Errorf("ClaimForGeneration(%s) error = %v", worker, err)
+					return
+				}
+				if len(claimed) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, img := range claimed {
+					claimedBy[img.ID] = append(claimedBy[img.ID], worker)
+				}
+				mu.Unlock()
+			}
+		}(worker, replica(t, dsn))
+	}
+	wg.Wait()
+
+	if len(claimedBy) != images {
+		t.Errorf("%d images claimed, want all %d", len(claimedBy), images)
+	}
+	check := repository.NewPostgresImageRepository(db)
+	for id, by := range claimedBy {
+		if len(by) != 1 {
+			t.Errorf("image %d claimed by %v, want a single worker", id, by)
+			continue
+		}
+		img, err := check.GetImage(ctx, id)
+		if err != nil {
+			t.Fatalf("GetImage(%d) error = %v", id, err)
+		}
+		if img.Status != domain.StatusClaimed || img.WorkerID != by[0] {
+			t.Errorf("image %d is %s by %q, want Claimed by %s", id, img.Status, img.WorkerID, by[0])
+		}
+	}
+}
+
+func TestClaimForGenerationSkipsLockedRows(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	ctx := context.Background()
+	repo := repository.NewPostgresImageRepository(db)
+	locked, _ := repo.CreateImage(ctx, "a locked lighthouse", repository.WithPriority(9))
+	free, _ := repo.CreateImage(ctx, "a lighthouse")
+
+	// Another transaction holds the row of the most urgent image, e.g. an admin editing it
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM images WHERE id = $1 FOR UPDATE`, locked); err != nil {
+		t.Fatalf("failed to lock the row: %v", err)
+	}
+
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	claimed, err := repo.ClaimForGeneration(claimCtx, 0, "worker-a", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration() error = %v, want it not to wait for the lock", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != free {
+		t.Errorf("ClaimForGeneration() claimed %v, want only image %d", claimedIDs(claimed), free)
+	}
+
+	tx.Rollback()
+	claimed, err = repo.ClaimForGeneration(ctx, 0, "worker-a", time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != locked {
+		t.Errorf("ClaimForGeneration() after the unlock = %v, %v, want image %d", claimedIDs(claimed), err, locked)
+	}
+}
+
+func TestLeasesFollowTheDatabaseClock(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	ctx := context.Background()
+	repo := repository.NewPostgresImageRepository(db)
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+
+	before := dbNow(t, db)
+	claimed, err := repo.ClaimForGeneration(ctx, 0, "worker-a", 90*time.Second)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimForGeneration() = %v, %v, want the image", claimedIDs(claimed), err)
+	}
+	after := dbNow(t, db)
+	lease := claimed[0].LeaseExpiresAt
+	if lease.Before(before.Add(90*time.Second)) || lease.After(after.Add(90*time.Second)) {
+		t.Errorf("lease expires at %v, want 90s after the claim at %v", lease, before)
+	}
+
+	// Backdating the lease on the database stands in for a worker that stopped renewing it
+	if _, err := db.Exec(`UPDATE images SET lease_expires_at = now() - INTERVAL '1 second' WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to backdate the lease: %v", err)
+	}
+	if ok, err := repo.ExtendLease(ctx, id, "worker-a", time.Minute); !ok || err != nil {
+		t.Errorf("ExtendLease() of a lapsed but unreclaimed lease = %v, %v, want it renewed", ok, err)
+	}
+	if n, err := repo.ReclaimExpiredLeases(ctx); n != 0 || err != nil {
+		t.Errorf("ReclaimExpiredLeases() after the renewal = %d, %v, want 0", n, err)
+	}
+
+	if _, err := db.Exec(`UPDATE images SET lease_expires_at = now() - INTERVAL '1 second' WHERE id = $1`, id); err != nil {
+		t.Fatalf("failed to backdate the lease: %v", err)
+	}
+	if n, err := repo.ReclaimExpiredLeases(ctx); n != 1 || err != nil {
+		t.Fatalf("ReclaimExpiredLeases() = %d, %v, want 1", n, err)
+	}
+	// The worker that lost the lease can no longer renew it
+	if ok, err := repo.ExtendLease(ctx, id, "worker-a", time.Minute); ok || err != nil {
+		t.Errorf("ExtendLease() after the reclaim = %v, %v, want false", ok, err)
+	}
+	img, err := repo.GetImage(ctx, id)
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+	if img.Status != domain.StatusReadyToGenerate || !img.LeaseExpiresAt.IsZero() {
+		t.Errorf("reclaimed image is %s with lease %v, want ReadyToGenerate without a lease", img.Status, img.LeaseExpiresAt)
+	}
+
+	events, err := repo.GetEvents(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	var reasons []string
+	for _, e := range events {
+		reasons = append(reasons, e.Reason)
+	}
+	if len(reasons) != 2 || reasons[0] != "claimed by worker-a" || reasons[1] != "lease expired" {
+		t.Errorf("event reasons = %q, want the claim and the expiry", reasons)
+	}
+}
@@ -0,0 +1,1116 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+// memoryImage is an image with the columns domain.Image does not carry
+type memoryImage struct {
+	domain.Image
+	promptHash    string
+	base64        string
+	filePath      string
+	thumbnail     string
+	thumbnailPath string
+	errorClass    string
+	nextPublishAt time.Time
+	webhookStatus string
+	webhookTries  int
+}
+
+// usageKey identifies the usage of a provider on a UTC day
+type usageKey struct {
+	provider string
+	day      time.Time
+}
+
+// MemoryImageRepository implements ImageRepository in memory, for tests and the self-test. It
+// follows the semantics of PostgresImageRepository, including the status transition checks.
+// The images it returns are copies; it is safe for concurrent use.
+type MemoryImageRepository struct {
+	mu        sync.Mutex
+	images    map[int]*memoryImage
+	variants  map[int][]domain.ImageVariant
+	revisions map[int][]domain.PromptRevision
+	events    map[int][]domain.ImageEvent
+	usage     map[usageKey]*domain.ProviderUsage
+	controls  map[string]domain.WorkflowControl
+	terms     []domain.BannedTerm
+	locks     map[int64]bool
+	listeners map[chan struct{}]bool
+
+	nextImageID   int
+	nextVariantID int
+	nextTermID    int
+	nextEventID   int
+
+	// defaultWidth and defaultHeight complete the prompt hashes of images without a size
+	defaultWidth, defaultHeight int
+}
+
+// MemoryOption configures optional settings of a MemoryImageRepository
+type MemoryOption func(*MemoryImageRepository)
+
+// WithMemoryDefaultSize is WithDefaultSize for a MemoryImageRepository
+func WithMemoryDefaultSize(width, height int) MemoryOption {
+	return func(r *MemoryImageRepository) {
+		r.defaultWidth, r.defaultHeight = width, height
+	}
+}
+
+// NewMemoryImageRepository creates an empty in-memory image repository
+func NewMemoryImageRepository(opts ...MemoryOption) *MemoryImageRepository {
+	r := &MemoryImageRepository{
+		images:    make(map[int]*memoryImage),
+		variants:  make(map[int][]domain.ImageVariant),
+		revisions: make(map[int][]domain.PromptRevision),
+		events:    make(map[int][]domain.ImageEvent),
+		usage:     make(map[usageKey]*domain.ProviderUsage),
+		controls:  make(map[string]domain.WorkflowControl),
+		locks:     make(map[int64]bool),
+		listeners: make(map[chan struct{}]bool),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// snapshot returns a copy of m that the caller may modify
+func (r *MemoryImageRepository) snapshot(m *memoryImage) *domain.Image {
+	img := m.Image
+	img.Tags = append([]string{}, m.Tags...)
+	img.RawResponse = nil
+	if m.Params != nil {
+		img.Params = make(map[string]interface{}, len(m.Params))
+		for k, v := range m.Params {
+			img.Params[k] = v
+		}
+	}
+	img.Variants = len(r.variants[m.ID])
+	img.LegacyResult = m.base64 != ""
+	return &img
+}
+
+// update applies fn to the image with the given ID and touches its updated_at; missing images
+// are ignored
+func (r *MemoryImageRepository) update(id int, fn func(m *memoryImage)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.images[id]; ok {
+		fn(m)
+		m.UpdatedAt = time.Now()
+	}
+}
+
+// setStatus moves m to status and records the change in the event log like the images trigger
+// of the schema; callers hold r.mu
+func (r *MemoryImageRepository) setStatus(ctx context.Context, m *memoryImage, status domain.ImageStatus, reason string) {
+	if m.Status == status {
+		return
+	}
+	r.nextEventID++
+	r.events[m.ID] = append(r.events[m.ID], domain.ImageEvent{
+		ID:        r.nextEventID,
+		ImageID:   m.ID,
+		From:      m.Status,
+		To:        status,
+		Actor:     actorFrom(ctx),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	m.Status = status
+	if status == domain.StatusReadyToGenerate {
+		r.notifyListeners()
+	}
+}
+
+// transition applies fn to the image with the given ID when it may move to status, like the
+// status checks of the Postgres queries. Updates of missing images are no-ops.
+func (r *MemoryImageRepository) transition(ctx context.Context, id int, status domain.ImageStatus, o statusOptions, fn func(m *memoryImage)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.images[id]
+	if !ok {
+		return nil
+	}
+	if !o.force && !domain.CanTransition(m.Status, status) {
+		return &domain.TransitionError{ID: id, From: m.Status, To: status}
+	}
+	r.setStatus(ctx, m, status, o.reason)
+	if fn != nil {
+		fn(m)
+	}
+	m.UpdatedAt = time.Now()
+	return nil
+}
+
+// oldest returns the images matching keep ordered by creation, oldest first, up to limit;
+// zero means no limit
+func (r *MemoryImageRepository) oldest(limit int, keep func(m *memoryImage) bool) []*domain.Image {
+	var matched []*memoryImage
+	for _, m := range r.images {
+		if keep(m) {
+			matched = append(matched, m)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	return r.snapshots(matched, limit)
+}
+
+// snapshots copies up to limit images; zero means no limit
+func (r *MemoryImageRepository) snapshots(images []*memoryImage, limit int) []*domain.Image {
+	if limit > 0 && len(images) > limit {
+		images = images[:limit]
+	}
+	var out []*domain.Image
+	for _, m := range images {
+		out = append(out, r.snapshot(m))
+	}
+	return out
+}
+
+// GetReadyToGenerate retrieves the oldest image ready for generation
+func (r *MemoryImageRepository) GetReadyToGenerate(ctx context.Context) (*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	images := r.oldest(1, (*memoryImage).generatable)
+	if len(images) == 0 {
+		return nil, nil
+	}
+	return images[0], nil
+}
+
+// GetReadyToCheck retrieves the oldest image ready for status check
+func (r *MemoryImageRepository) GetReadyToCheck(ctx context.Context) (*domain.Image, error) {
+	images, err := r.GetAllReadyToCheck(ctx, 1)
+	if err != nil || len(images) == 0 {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+// UpdateStatus updates the status of an image, validating the transition like
+// PostgresImageRepository.UpdateStatus
+func (r *MemoryImageRepository) UpdateStatus(ctx context.Context, id int, status domain.ImageStatus, opts ...StatusOption) error {
+	return r.transition(ctx, id, status, newStatusOptions(opts), func(m *memoryImage) {
+		if status == domain.StatusGenerate {
+			m.GenerationStartedAt = time.Now()
+		}
+	})
+}
+
+// UpdateStatusWithError updates the status of an image and records why it changed
+func (r *MemoryImageRepository) UpdateStatusWithError(ctx context.Context, id int, status domain.ImageStatus, errorDescription string, opts ...StatusOption) error {
+	o := newStatusOptions(opts)
+	if o.reason == "" {
+		o.reason = errorDescription
+	}
+	return r.transition(ctx, id, status, o, func(m *memoryImage) {
+		m.ErrorDescription = errorDescription
+	})
+}
+
+// UpdateUUID updates the UUID of an image
+func (r *MemoryImageRepository) UpdateUUID(ctx context.Context, id int, uuid string) error {
+	r.update(id, func(m *memoryImage) { m.UUID = uuid })
+	return nil
+}
+
+// UpdateProvider records which provider handled the generation of an image
+func (r *MemoryImageRepository) UpdateProvider(ctx context.Context, id int, provider string) error {
+	r.update(id, func(m *memoryImage) { m.Provider = provider })
+	return nil
+}
+
+// UpdateSeed records the seed used to generate an image
+func (r *MemoryImageRepository) UpdateSeed(ctx context.Context, id int, seed int64) error {
+	r.update(id, func(m *memoryImage) { m.Seed = seed })
+	return nil
+}
+
+// UpdateCensored records whether a generation of an image was censored
+func (r *MemoryImageRepository) UpdateCensored(ctx context.Context, id int, censored bool) error {
+	r.update(id, func(m *memoryImage) { m.Censored = censored })
+	return nil
+}
+
+// SaveRawResponse stores the provider's status response of a failed or censored generation
+func (r *MemoryImageRepository) SaveRawResponse(ctx context.Context, id int, raw []byte) error {
+	r.update(id, func(m *memoryImage) { m.RawResponse = append(json.RawMessage(nil), raw...) })
+	return nil
+}
+
+// UpdateBase64 updates the base64 data of an image
+func (r *MemoryImageRepository) UpdateBase64(ctx context.Context, id int, data string) error {
+	r.update(id, func(m *memoryImage) { m.base64 = data })
+	return nil
+}
+
+// UpdateFilePath records where the first generated file of an image is stored
+func (r *MemoryImageRepository) UpdateFilePath(ctx context.Context, id int, path string) error {
+	r.update(id, func(m *memoryImage) { m.filePath = path })
+	return nil
+}
+
+// UpdateFileURL records a URL the first generated file of an image can be downloaded from
+func (r *MemoryImageRepository) UpdateFileURL(ctx context.Context, id int, url string) error {
+	r.update(id, func(m *memoryImage) { m.FileURL = url })
+	return nil
+}
+
+// UpdateThumbnail stores the base64 JPEG thumbnail of an image
+func (r *MemoryImageRepository) UpdateThumbnail(ctx context.Context, id int, thumbnail string) error {
+	r.update(id, func(m *memoryImage) { m.thumbnail = thumbnail })
+	return nil
+}
+
+// UpdateThumbnailPath records where the thumbnail of an image is stored
+func (r *MemoryImageRepository) UpdateThumbnailPath(ctx context.Context, id int, path string) error {
+	r.update(id, func(m *memoryImage) { m.thumbnailPath = path })
+	return nil
+}
+
+// GetThumbnail retrieves the decoded thumbnail of an image or its storage location
+func (r *MemoryImageRepository) GetThumbnail(ctx context.Context, id int) ([]byte, string, error) {
+	r.mu.Lock()
+	m, ok := r.images[id]
+	var thumbnail, path string
+	if ok {
+		thumbnail, path = m.thumbnail, m.thumbnailPath
+	}
+	r.mu.Unlock()
+
+	if thumbnail == "" {
+		return nil, path, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(thumbnail)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode thumbnail: %w", err)
+	}
+	return data, path, nil
+}
+
+// GetImageData retrieves the legacy base64 copy of the first generated file of an image, empty
+// when there is none
+func (r *MemoryImageRepository) GetImageData(ctx context.Context, id int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.images[id]; ok {
+		return m.base64, nil
+	}
+	return "", nil
+}
+
+// UpdateMetadata records the dimensions, size, format and generation time of an image's result
+func (r *MemoryImageRepository) UpdateMetadata(ctx context.Context, id int, meta domain.ImageMetadata) error {
+	r.update(id, func(m *memoryImage) {
+		m.Metadata = meta
+		m.Metadata.GenerationDuration = meta.GenerationDuration.Truncate(time.Millisecond)
+	})
+	return nil
+}
+
+// matches reports whether m matches the status and tag of filter
+func (f ListFilter) matches(m *memoryImage) bool {
+	if f.Status != "" && m.Status != f.Status {
+		return false
+	}
+	if f.Tag == "" {
+		return true
+	}
+	for _, tag := range m.Tags {
+		if tag == f.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ListImages retrieves the images matching filter, newest first unless sorted otherwise
+func (r *MemoryImageRepository) ListImages(ctx context.Context, filter ListFilter) ([]*domain.Image, error) {
+	if _, err := filter.orderBy(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matched []*memoryImage
+	for _, m := range r.images {
+		if filter.matches(m) {
+			matched = append(matched, m)
+		}
+	}
+
+	key := func(m *memoryImage) time.Time {
+		switch filter.SortBy {
+		case SortByCreatedAt:
+			return m.CreatedAt
+		case SortByUpdatedAt:
+			return m.UpdatedAt
+		}
+		return time.Time{}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if !filter.Ascending {
+			a, b = b, a
+		}
+		if ka, kb := key(a), key(b); !ka.Equal(kb) {
+			return ka.Before(kb)
+		}
+		return a.ID < b.ID
+	})
+
+	if filter.Offset >= len(matched) {
+		return nil, nil
+	}
+	return r.snapshots(matched[filter.Offset:], filter.Limit), nil
+}
+
+// CountImages returns how many images match the status and tag of filter, ignoring its
+// pagination
+func (r *MemoryImageRepository) CountImages(ctx context.Context, filter ListFilter) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, m := range r.images {
+		if filter.matches(m) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountByStatus returns the number of images in each status; statuses without images are omitted
+func (r *MemoryImageRepository) CountByStatus(ctx context.Context) (map[domain.ImageStatus]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[domain.ImageStatus]int)
+	for _, m := range r.images {
+		counts[m.Status]++
+	}
+	return counts, nil
+}
+
+// RecordUsage counts a generation request submitted to provider on the UTC day of day, a failed
+// one if failed is set
+func (r *MemoryImageRepository) RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := usageKey{provider: provider, day: domain.UsageDay(day)}
+	u, ok := r.usage[key]
+	if !ok {
+		u = &domain.ProviderUsage{Provider: provider, Day: key.day}
+		r.usage[key] = u
+	}
+	u.Requests++
+	if failed {
+		u.Failures++
+	}
+	return nil
+}
+
+// GetUsage returns the requests submitted to each provider on the UTC day of day, ordered by
+// provider
+func (r *MemoryImageRepository) GetUsage(ctx context.Context, day time.Time) ([]domain.ProviderUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	day = domain.UsageDay(day)
+	var usage []domain.ProviderUsage
+	for key, u := range r.usage {
+		if key.day.Equal(day) {
+			usage = append(usage, *u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Provider < usage[j].Provider })
+	return usage, nil
+}
+
+// SetPaused pauses or resumes workflow
+func (r *MemoryImageRepository) SetPaused(ctx context.Context, workflow string, paused bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.controls[workflow] = domain.WorkflowControl{Workflow: workflow, Paused: paused, UpdatedAt: time.Now()}
+	return nil
+}
+
+// GetControls returns the pause state of the workflows that were ever paused, ordered by
+// workflow
+func (r *MemoryImageRepository) GetControls(ctx context.Context) ([]domain.WorkflowControl, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var controls []domain.WorkflowControl
+	for _, c := range r.controls {
+		controls = append(controls, c)
+	}
+	sort.Slice(controls, func(i, j int) bool { return controls[i].Workflow < controls[j].Workflow })
+	return controls, nil
+}
+
+// ListBannedTerms retrieves the banned terms that reject prompts before generation
+func (r *MemoryImageRepository) ListBannedTerms(ctx context.Context) ([]domain.BannedTerm, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]domain.BannedTerm(nil), r.terms...), nil
+}
+
+// AddBannedTerm adds a banned term and returns its ID; adding a known term updates it
+func (r *MemoryImageRepository) AddBannedTerm(ctx context.Context, term string, regex bool) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.terms {
+		if t.Term == term {
+			r.terms[i].Regex = regex
+			return t.ID, nil
+		}
+	}
+	r.nextTermID++
+	r.terms = append(r.terms, domain.BannedTerm{ID: r.nextTermID, Term: term, Regex: regex})
+	return r.nextTermID, nil
+}
+
+// DeleteBannedTerm removes a banned term
+func (r *MemoryImageRepository) DeleteBannedTerm(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.terms {
+		if t.ID == id {
+			r.terms = append(r.terms[:i], r.terms[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetAllReadyToPublish retrieves up to limit images ready to be published whose retry delay has
+// passed, oldest first; zero means no limit
+func (r *MemoryImageRepository) GetAllReadyToPublish(ctx context.Context, limit int) ([]*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	return r.oldest(limit, func(m *memoryImage) bool {
+		return m.Status == domain.StatusReadyToPublish && !m.nextPublishAt.After(now)
+	}), nil
+}
+
+// MarkPublished sets the status of an image to Published and records when and where it was
+// published
+func (r *MemoryImageRepository) MarkPublished(ctx context.Context, id int, url string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.images[id]; ok {
+		r.setStatus(ctx, m, domain.StatusPublished, "")
+		m.PublishedAt = time.Now()
+		m.PublishedURL = url
+		m.UpdatedAt = m.PublishedAt
+	}
+	return nil
+}
+
+// RecordPublishFailure counts a failed publishing attempt and delays the next one by retryAfter
+func (r *MemoryImageRepository) RecordPublishFailure(ctx context.Context, id int, errorDescription string, retryAfter time.Duration) error {
+	r.update(id, func(m *memoryImage) {
+		m.PublishAttempts++
+		m.nextPublishAt = time.Now().Add(retryAfter)
+		m.ErrorDescription = errorDescription
+	})
+	return nil
+}
+
+// UpdateWebhookStatus records the outcome of the webhook delivery for an image
+func (r *MemoryImageRepository) UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) error {
+	r.update(id, func(m *memoryImage) {
+		m.webhookStatus = status
+		m.webhookTries = attempts
+	})
+	return nil
+}
+
+// SaveVariants stores every file generated for an image, replacing any earlier variants and
+// selecting the first one again
+func (r *MemoryImageRepository) SaveVariants(ctx context.Context, id int, variants []domain.ImageVariant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored := make([]domain.ImageVariant, 0, len(variants))
+	for _, v := range variants {
+		for _, s := range stored {
+			if s.Index == v.Index {
+				return fmt.Errorf("duplicate variant index %d", v.Index)
+			}
+		}
+		r.nextVariantID++
+		v.ID = r.nextVariantID
+		v.ImageID = id
+		v.CreatedAt = now
+		stored = append(stored, v)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Index < stored[j].Index })
+	r.variants[id] = stored
+
+	if m, ok := r.images[id]; ok {
+		m.SelectedVariant = 0
+		m.UpdatedAt = now
+	}
+	return nil
+}
+
+// SaveResultStream stores the file read from r as the only variant of an image, replacing any
+// earlier variants and selecting it. Images that do not exist are left without variants.
+func (r *MemoryImageRepository) SaveResultStream(ctx context.Context, id int, src io.Reader) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("failed to read result: %w", err)
+	}
+
+	r.mu.Lock()
+	m, ok := r.images[id]
+	var provider string
+	if ok {
+		provider = m.Provider
+	}
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return r.SaveVariants(ctx, id, []domain.ImageVariant{{Provider: provider, Data: base64.StdEncoding.EncodeToString(data)}})
+}
+
+// ListVariants retrieves the variants stored for an image in the order they were returned,
+// without their inline data
+func (r *MemoryImageRepository) ListVariants(ctx context.Context, id int) ([]domain.ImageVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	variants := append([]domain.ImageVariant(nil), r.variants[id]...)
+	for i := range variants {
+		variants[i].Data = ""
+	}
+	return variants, nil
+}
+
+// GetVariant retrieves the variant of an image with the given index including its inline data,
+// or nil if it does not exist
+func (r *MemoryImageRepository) GetVariant(ctx context.Context, id int, index int) (*domain.ImageVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.variants[id] {
+		if v.Index == index {
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetResult retrieves the selected variant of an image including its inline data, the first one
+// when the selected variant is gone, or nil when the image has no variants
+func (r *MemoryImageRepository) GetResult(ctx context.Context, id int) (*domain.ImageVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	variants := r.variants[id]
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	selected := variants[0]
+	if m, ok := r.images[id]; ok {
+		for _, v := range variants {
+			if v.Index == m.SelectedVariant {
+				selected = v
+				break
+			}
+		}
+	}
+	return &selected, nil
+}
+
+// DeleteVariant removes a variant of an image. An image whose selected variant is removed
+// selects its first remaining one.
+func (r *MemoryImageRepository) DeleteVariant(ctx context.Context, id int, index int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	variants := r.variants[id]
+	for i, v := range variants {
+		if v.Index == index {
+			r.variants[id] = append(variants[:i:i], variants[i+1:]...)
+			break
+		}
+	}
+
+	if m, ok := r.images[id]; ok && m.SelectedVariant == index {
+		m.SelectedVariant = 0
+		if remaining := r.variants[id]; len(remaining) > 0 {
+			m.SelectedVariant = remaining[0].Index
+		}
+		m.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// SelectVariant makes the variant with the given index the one that is published. It reports
+// false when the image has no such variant.
+func (r *MemoryImageRepository) SelectVariant(ctx context.Context, id int, index int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.images[id]
+	if !ok {
+		return false, nil
+	}
+	for _, v := range r.variants[id] {
+		if v.Index == index {
+			m.SelectedVariant = index
+			m.UpdatedAt = time.Now()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AppendPromptRevision records text as the next revision of the prompt of an image and returns
+// its number, like PostgresImageRepository.AppendPromptRevision
+func (r *MemoryImageRepository) AppendPromptRevision(ctx context.Context, id int, text string, source domain.PromptSource) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.images[id]
+	if !ok {
+		return 0, fmt.Errorf("image %d not found", id)
+	}
+
+	history := r.revisions[id]
+	if len(history) == 0 {
+		history = append(history, domain.PromptRevision{
+			ImageID: id, Revision: 1, Text: m.Prompt, Source: domain.PromptSourceAuthor, CreatedAt: time.Now(),
+		})
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Text == text && history[i].Source == source {
+			r.revisions[id] = history
+			return history[i].Revision, nil
+		}
+	}
+
+	revision := history[len(history)-1].Revision + 1
+	r.revisions[id] = append(history, domain.PromptRevision{
+		ImageID: id, Revision: revision, Text: text, Source: source, CreatedAt: time.Now(),
+	})
+	return revision, nil
+}
+
+// GetPromptHistory retrieves every revision of the prompt of an image, oldest first
+func (r *MemoryImageRepository) GetPromptHistory(ctx context.Context, id int) ([]domain.PromptRevision, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if history := r.revisions[id]; len(history) > 0 {
+		return append([]domain.PromptRevision(nil), history...), nil
+	}
+	m, ok := r.images[id]
+	if !ok {
+		return nil, nil
+	}
+	return []domain.PromptRevision{{
+		ImageID: id, Revision: 1, Text: m.Prompt, Source: domain.PromptSourceAuthor, CreatedAt: m.CreatedAt,
+	}}, nil
+}
+
+// GetEvents returns the status transitions of an image, oldest first
+func (r *MemoryImageRepository) GetEvents(ctx context.Context, imageID int) ([]domain.ImageEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]domain.ImageEvent(nil), r.events[imageID]...), nil
+}
+
+// PruneEvents deletes the status transitions recorded at least olderThan ago, of any image, and
+// returns the number of events deleted
+func (r *MemoryImageRepository) PruneEvents(ctx context.Context, olderThan time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	pruned := 0
+	for id, events := range r.events {
+		kept := events[:0]
+		for _, e := range events {
+			if e.CreatedAt.After(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+		pruned += len(events) - len(kept)
+		if len(kept) == 0 {
+			delete(r.events, id)
+		} else {
+			r.events[id] = kept
+		}
+	}
+	return pruned, nil
+}
+
+// generatable reports whether the image is ready for generation and not scheduled for later
+func (m *memoryImage) generatable() bool {
+	return m.Status == domain.StatusReadyToGenerate && m.Prompt != "" && !m.ScheduledAt.After(time.Now())
+}
+
+// GetAllReadyToGenerate retrieves up to limit images ready for generation, highest priority
+// first; zero means no limit
+func (r *MemoryImageRepository) GetAllReadyToGenerate(ctx context.Context, limit int) ([]*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readyToGenerate(limit), nil
+}
+
+// readyToGenerate implements GetAllReadyToGenerate; callers hold r.mu
+func (r *MemoryImageRepository) readyToGenerate(limit int) []*domain.Image {
+	images := r.oldest(0, (*memoryImage).generatable)
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Priority > images[j].Priority })
+	if limit > 0 && len(images) > limit {
+		images = images[:limit]
+	}
+	return images
+}
+
+// GetAllReadyToCheck retrieves up to limit images ready for status check, oldest first; zero
+// means no limit
+func (r *MemoryImageRepository) GetAllReadyToCheck(ctx context.Context, limit int) ([]*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.oldest(limit, func(m *memoryImage) bool {
+		return m.Status == domain.StatusGenerate && m.UUID != ""
+	}), nil
+}
+
+// ClaimForGeneration moves up to limit images ready for generation to Claimed, highest priority
+// first, on behalf of worker, which holds them for lease; zero means no limit
+func (r *MemoryImageRepository) ClaimForGeneration(ctx context.Context, limit int, worker string, lease time.Duration) ([]*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	images := r.readyToGenerate(limit)
+	now := time.Now()
+	for _, img := range images {
+		m := r.images[img.ID]
+		r.setStatus(ctx, m, domain.StatusClaimed, "claimed by "+worker)
+		m.WorkerID = worker
+		m.LeaseExpiresAt = now.Add(lease)
+		m.UpdatedAt = now
+		img.Status, img.WorkerID, img.LeaseExpiresAt, img.UpdatedAt = m.Status, m.WorkerID, m.LeaseExpiresAt, m.UpdatedAt
+	}
+	return images, nil
+}
+
+// claimedBy returns the image with the given ID if it is Claimed by worker; callers hold r.mu
+func (r *MemoryImageRepository) claimedBy(id int, worker string) *memoryImage {
+	m, ok := r.images[id]
+	if !ok || m.Status != domain.StatusClaimed || m.WorkerID != worker {
+		return nil
+	}
+	return m
+}
+
+// ExtendLease renews the claim of worker on an image for another lease from now. It reports
+// false when the image is no longer Claimed by worker.
+func (r *MemoryImageRepository) ExtendLease(ctx context.Context, id int, worker string, lease time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.claimedBy(id, worker)
+	if m == nil {
+		return false, nil
+	}
+	m.LeaseExpiresAt = time.Now().Add(lease)
+	return true, nil
+}
+
+// ReleaseClaim returns an image still Claimed by worker to ReadyToGenerate
+func (r *MemoryImageRepository) ReleaseClaim(ctx context.Context, id int, worker string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m := r.claimedBy(id, worker); m != nil {
+		r.setStatus(ctx, m, domain.StatusReadyToGenerate, "claim released")
+		m.LeaseExpiresAt = time.Time{}
+		m.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// ReclaimExpiredLeases returns Claimed images whose lease has lapsed to ReadyToGenerate and
+// reports how many there were
+func (r *MemoryImageRepository) ReclaimExpiredLeases(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for _, m := range r.images {
+		if m.Status == domain.StatusClaimed && m.LeaseExpiresAt.Before(now) {
+			r.setStatus(ctx, m, domain.StatusReadyToGenerate, "lease expired")
+			m.LeaseExpiresAt = time.Time{}
+			m.UpdatedAt = now
+			n++
+		}
+	}
+	return n, nil
+}
+
+// create stores img as a new image queued for generation and returns its ID
+func (r *MemoryImageRepository) create(img *domain.Image) int {
+	r.nextImageID++
+	now := time.Now()
+	img.ID = r.nextImageID
+	img.Status = domain.StatusReadyToGenerate
+	img.CreatedAt = now
+	img.UpdatedAt = now
+	m := &memoryImage{Image: *img, promptHash: img.PromptHash(r.defaultWidth, r.defaultHeight)}
+	m.Tags = append([]string{}, img.Tags...)
+	r.images[img.ID] = m
+	r.notifyListeners()
+	return img.ID
+}
+
+// CreateImage queues a new image for generation and returns its ID
+func (r *MemoryImageRepository) CreateImage(ctx context.Context, prompt string, opts ...CreateOption) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(newImage(prompt, opts)), nil
+}
+
+// BulkCreate queues an image for every prompt and returns their IDs in the same order; the
+// options apply to all of them
+func (r *MemoryImageRepository) BulkCreate(ctx context.Context, prompts []string, opts ...CreateOption) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]int, 0, len(prompts))
+	for _, prompt := range prompts {
+		ids = append(ids, r.create(newImage(prompt, opts)))
+	}
+	return ids, nil
+}
+
+// CreateImages queues images with their own prompt and parameters and returns their IDs in the
+// same order
+func (r *MemoryImageRepository) CreateImages(ctx context.Context, images []*domain.Image) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]int, 0, len(images))
+	for _, img := range images {
+		img := *img
+		ids = append(ids, r.create(&img))
+	}
+	return ids, nil
+}
+
+// CreateFromTemplate expands tmpl against every variable set and queues the resulting prompts.
+// Nothing is created when any expansion fails.
+func (r *MemoryImageRepository) CreateFromTemplate(ctx context.Context, tmpl *prompts.PromptTemplate, varSets []map[string]string, opts ...CreateOption) ([]int, error) {
+	expanded, err := tmpl.ExpandAll(varSets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand template: %w", err)
+	}
+	return r.BulkCreate(ctx, expanded, opts...)
+}
+
+// UpdatePromptHash updates the prompt hash of an image
+func (r *MemoryImageRepository) UpdatePromptHash(ctx context.Context, id int, hash string) error {
+	r.update(id, func(m *memoryImage) { m.promptHash = hash })
+	return nil
+}
+
+// FindPromptHashes returns the ID of the oldest image with each of hashes that has not failed
+// or been marked as a duplicate; hashes without such an image are left out
+func (r *MemoryImageRepository) FindPromptHashes(ctx context.Context, hashes []string) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		wanted[hash] = true
+	}
+	found := make(map[string]int)
+	for _, m := range r.images {
+		if !m.duplicateCandidate() || !wanted[m.promptHash] {
+			continue
+		}
+		if id, ok := found[m.promptHash]; !ok || m.ID < id {
+			found[m.promptHash] = m.ID
+		}
+	}
+	return found, nil
+}
+
+// duplicateCandidate reports whether later images with the same prompt hash duplicate this one,
+// which is not the case once it failed or was marked as a duplicate itself
+func (m *memoryImage) duplicateCandidate() bool {
+	switch m.Status {
+	case domain.StatusFailed, domain.StatusTimedOut, domain.StatusDuplicate, domain.StatusCensored, domain.StatusRejected:
+		return false
+	}
+	return true
+}
+
+// FindByPromptHash retrieves the oldest image created before beforeID with the same
+// prompt hash that has not failed or been marked as a duplicate itself
+func (r *MemoryImageRepository) FindByPromptHash(ctx context.Context, hash string, beforeID int) (*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found *memoryImage
+	for _, m := range r.images {
+		if !m.duplicateCandidate() {
+			continue
+		}
+		if m.promptHash == hash && m.ID < beforeID && (found == nil || m.ID < found.ID) {
+			found = m
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return r.snapshot(found), nil
+}
+
+// GetImage retrieves a single image, or nil if it does not exist
+func (r *MemoryImageRepository) GetImage(ctx context.Context, id int) (*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.images[id]
+	if !ok {
+		return nil, nil
+	}
+	img := r.snapshot(m)
+	img.RawResponse = append(json.RawMessage(nil), m.RawResponse...)
+	return img, nil
+}
+
+// Requeue moves an image back to ReadyToGenerate, clearing its UUID, error and reset count. It
+// reports false when the image does not exist or is still queued or being generated.
+func (r *MemoryImageRepository) Requeue(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.images[id]
+	if !ok || m.Status == domain.StatusReadyToGenerate || m.Status == domain.StatusClaimed || m.Status == domain.StatusGenerate {
+		return false, nil
+	}
+	r.setStatus(ctx, m, domain.StatusReadyToGenerate, "requeued")
+	m.UUID = ""
+	m.ErrorDescription = ""
+	m.ResetCount = 0
+	m.UpdatedAt = time.Now()
+	return true, nil
+}
+
+// RecordReset counts a reset of an image to ReadyToGenerate after the provider lost its
+// generation
+func (r *MemoryImageRepository) RecordReset(ctx context.Context, id int) error {
+	r.update(id, func(m *memoryImage) {
+		m.ResetCount++
+	})
+	return nil
+}
+
+// MarkFailed marks an image Failed with the description and errclass class of the error,
+// validating the transition like UpdateStatus
+func (r *MemoryImageRepository) MarkFailed(ctx context.Context, id int, errorDescription string, errorClass string) error {
+	return r.transition(ctx, id, domain.StatusFailed, statusOptions{reason: errorDescription}, func(m *memoryImage) {
+		m.ErrorDescription = errorDescription
+		m.errorClass = errorClass
+	})
+}
+
+// RequeueFailed moves images that failed at least olderThan ago with one of the
+// errclass.RequeueableClasses back to ReadyToGenerate, up to maxAttempts times per image. It
+// returns the number of images requeued.
+func (r *MemoryImageRepository) RequeueFailed(ctx context.Context, olderThan time.Duration, maxAttempts int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requeueable := make(map[string]bool)
+	for _, class := range errclass.RequeueableClasses {
+		requeueable[class] = true
+	}
+
+	now := time.Now()
+	requeued := 0
+	for _, m := range r.images {
+		if m.Status != domain.StatusFailed || !requeueable[m.errorClass] ||
+			m.UpdatedAt.After(now.Add(-olderThan)) || m.Attempts >= maxAttempts {
+			continue
+		}
+		r.setStatus(ctx, m, domain.StatusReadyToGenerate, "requeued after a transient failure")
+		m.UUID = ""
+		m.ErrorDescription = ""
+		m.errorClass = ""
+		m.Attempts++
+		m.UpdatedAt = now
+		requeued++
+	}
+	return requeued, nil
+}
+
+// TryAdvisoryLock takes the lock key without waiting and reports whether it was acquired
+func (r *MemoryImageRepository) TryAdvisoryLock(ctx context.Context, key int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locks[key] {
+		return false, nil
+	}
+	r.locks[key] = true
+	return true, nil
+}
+
+// AdvisoryUnlock releases a lock taken by TryAdvisoryLock; releasing a key that is not held
+// does nothing
+func (r *MemoryImageRepository) AdvisoryUnlock(ctx context.Context, key int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.locks, key)
+	return nil
+}
+
+// Ping always succeeds
+func (r *MemoryImageRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ListenForNewImages returns a channel that receives a value whenever an image is created or
+// becomes ready for generation again, coalescing bursts like the Postgres listener. The
+// channel is closed once ctx is done.
+func (r *MemoryImageRepository) ListenForNewImages(ctx context.Context) (<-chan struct{}, error) {
+	wake := make(chan struct{}, 1)
+	r.mu.Lock()
+	r.listeners[wake] = true
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		delete(r.listeners, wake)
+		r.mu.Unlock()
+		close(wake)
+	}()
+	return wake, nil
+}
+
+// notifyListeners wakes the channels of ListenForNewImages; callers hold r.mu
+func (r *MemoryImageRepository) notifyListeners() {
+	for wake := range r.listeners {
+		signal(wake)
+	}
+}
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+)
+
+// Schema creates every table and column the repositories use. It is idempotent, so it can be
+// applied to existing databases after upgrading.
+//
+//go:embed schema.sql
+var Schema string
+
+// Migrate applies Schema to db
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, Schema); err != nil {
+		return fmt.Errorf("failed to apply schema: %w", err)
+	}
+	return nil
+}
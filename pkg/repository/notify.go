@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewImagesChannel is the channel the images_ready_notify trigger of the schema notifies when
+// an image becomes ready for generation
+const NewImagesChannel = "images_ready"
+
+// ErrNotificationsUnavailable is returned by ListenForNewImages when the repository cannot
+// notify about new images, so callers poll instead
+var ErrNotificationsUnavailable = errors.New("new image notifications are not available")
+
+const (
+	// listenMinReconnect and listenMaxReconnect bound the wait between reconnects of a dropped
+	// listener connection
+	listenMinReconnect = time.Second
+	listenMaxReconnect = time.Minute
+	// listenPingInterval is how often an idle listener checks that its connection is alive
+	listenPingInterval = 90 * time.Second
+)
+
+// PostgresOption configures optional settings of a PostgresImageRepository
+type PostgresOption func(*PostgresImageRepository)
+
+// WithNotifications enables ListenForNewImages, which listens for new images on a dedicated
+// connection to dsn outside of the pool
+func WithNotifications(dsn string) PostgresOption {
+	return func(r *PostgresImageRepository) {
+		r.listenDSN = dsn
+	}
+}
+
+// ListenForNewImages returns a channel that receives a value whenever images may have become
+// ready for generation: on inserts and on changes to ReadyToGenerate. Bursts of notifications
+// are coalesced into a single pending value, so receivers must query for all ready images. The
+// dropped connection is reconnected in the background and the channel receives a value after
+// every reconnect, since notifications may have been missed meanwhile. The channel is closed
+// once ctx is done. Without WithNotifications it fails with ErrNotificationsUnavailable.
+func (r *PostgresImageRepository) ListenForNewImages(ctx context.Context) (<-chan struct{}, error) {
+	if r.listenDSN == "" {
+		return nil, ErrNotificationsUnavailable
+	}
+
+	listener := pq.NewListener(r.listenDSN, listenMinReconnect, listenMaxReconnect, nil)
+	if err := listener.Listen(NewImagesChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", NewImagesChannel, err)
+	}
+
+	wake := make(chan struct{}, 1)
+	go func() {
+		defer close(wake)
+		defer listener.Close()
+
+		pings := time.NewTicker(listenPingInterval)
+		defer pings.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// A nil notification follows a reconnect; wake the receiver either way
+				signal(wake)
+			case <-pings.C:
+				// A failed ping makes the listener notice a dead connection and reconnect
+				listener.Ping()
+			}
+		}
+	}()
+	return wake, nil
+}
+
+// signal sends to wake unless a value is already pending
+func signal(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,141 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// maxPickup is how soon a notification must arrive after an image was queued
+const maxPickup = 100 * time.Millisecond
+
+func TestListenForNewImagesPickup(t *testing.T) {
+	db, dsn := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db, repository.WithNotifications(dsn))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wake, err := repo.ListenForNewImages(ctx)
+	if err != nil {
+		t.Fatalf("ListenForNewImages() error = %v", err)
+	}
+
+	// picked waits for a notification and fails the test unless it arrives within maxPickup of
+	// start
+	picked := func(what string, start time.Time) {
+		t.Helper()
+		select {
+		case <-wake:
+			if d := time.Since(start); d > maxPickup {
+				t.Errorf("%s notified after %v, want within %v", what, d, maxPickup)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not notified within 5s", what)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		id, err := repo.CreateImage(ctx, "a lighthouse")
+		if err != nil {
+			t.Fatalf("CreateImage() error = %v", err)
+		}
+		picked("insert", start)
+
+		// A change back to ReadyToGenerate notifies as well, other changes don't
+		if err := repo.UpdateStatus(ctx, id, domain.StatusGenerate); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		start = time.Now()
+		if err := repo.UpdateStatus(ctx, id, domain.StatusReadyToGenerate); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		picked("requeue", start)
+	}
+
+	// A burst is coalesced into a single pending wake-up
+	for i := 0; i < 10; i++ {
+		if _, err := repo.CreateImage(ctx, "a harbour"); err != nil {
+			t.Fatalf("CreateImage() error = %v", err)
+		}
+	}
+	picked("burst", time.Now())
+	time.Sleep(maxPickup)
+	select {
+	case <-wake:
+	default:
+	}
+	select {
+	case <-wake:
+		t.Error("burst left more than one wake-up pending")
+	case <-time.After(maxPickup):
+	}
+
+	// The channel is closed once ctx is done, after any pending wake-up
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-wake:
+		case <-timeout:
+			t.Fatal("channel not closed within 5s of the cancellation")
+		}
+	}
+}
+
+func TestGeneratorWokenByNewImage(t *testing.T) {
+	db, dsn := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db, repository.WithNotifications(dsn))
+	cfg := workflows.DefaultConfig()
+	// Without notifications a new image would wait for the next pass an hour later
+	cfg.GeneratorInterval = time.Hour
+	cfg.IdleBackoffMax = time.Hour
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.Timeout()
+	g := workflows.NewGenerator(repo, fake, cfg, workflows.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// Let the generator finish its first pass and start listening
+	time.Sleep(500 * time.Millisecond)
+
+	start := time.Now()
+	id, err := repo.CreateImage(ctx, "a lighthouse")
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	for {
+		img, err := repo.GetImage(ctx, id)
+		if err != nil {
+			t.Fatalf("GetImage() error = %v", err)
+		}
+		if img.Status != domain.StatusReadyToGenerate {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("new image not picked up within 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d := time.Since(start); d > maxPickup {
+		t.Errorf("new image picked up after %v, want within %v", d, maxPickup)
+	}
+}
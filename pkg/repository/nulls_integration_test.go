@@ -0,0 +1,167 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// nullStatuses are the statuses of the rows every read runs against, one for each query that
+// picks images by status
+var nullStatuses = []domain.ImageStatus{domain.StatusReadyToGenerate, domain.StatusGenerate, domain.StatusReadyToPublish, domain.StatusFailed}
+
+// nullableColumns returns the name and type of every column of images that allows NULL
+func nullableColumns(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'images' AND is_nullable = 'YES'
+	`)
+	if err != nil {
+		t.Fatalf("failed to list the columns: %v", err)
+	}
+	defer rows.Close()
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			t.Fatalf("failed to scan a column: %v", err)
+		}
+		columns[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to list the columns: %v", err)
+	}
+	return columns
+}
+
+// filledValue returns an SQL literal that fills a column of the given type with a value every
+// read accepts
+func filledValue(t *testing.T, column, typ string) string {
+	t.Helper()
+	switch {
+	case column == "base64" || column == "thumbnail":
+		return `'aGk='`
+	case typ == "text":
+		return `'x'`
+	case typ == "integer" || typ == "bigint":
+		return `1`
+	case typ == "boolean":
+		return `TRUE`
+	case strings.HasPrefix(typ, "timestamp"):
+		return `now() - INTERVAL '1 hour'`
+	case typ == "jsonb":
+		return `'{}'`
+	case typ == "ARRAY":
+		return `'{}'`
+	}
+	t.Fatalf("column %s has type %s, which the test cannot fill", column, typ)
+	return ""
+}
+
+// insertNullRows inserts an image of every status in nullStatuses with only prompt and status
+// set and returns their IDs
+func insertNullRows(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	ids := make([]int, len(nullStatuses))
+	for i, status := range nullStatuses {
+		if err := db.QueryRow(`INSERT INTO images (prompt, status) VALUES ('a lighthouse', $1) RETURNING id`, status).Scan(&ids[i]); err != nil {
+			t.Fatalf("failed to insert an image: %v", err)
+		}
+	}
+	return ids
+}
+
+// readAll runs every read of the repository over the images with the given IDs, failing the test
+// on any error
+func readAll(t *testing.T, repo *repository.PostgresImageRepository, ids []int) {
+	t.Helper()
+	ctx := context.Background()
+	check := func(name string, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s error = %v", name, err)
+		}
+	}
+
+	for _, id := range ids {
+		img, err := repo.GetImage(ctx, id)
+		check(fmt.Sprintf("GetImage(%d)", id), err)
+		if err == nil && img == nil {
+			t.Errorf("GetImage(%d) = nil, want the image", id)
+		}
+		_, err = repo.GetImageData(ctx, id)
+		check("GetImageData()", err)
+		_, _, err = repo.GetThumbnail(ctx, id)
+		check("GetThumbnail()", err)
+		_, err = repo.GetEvents(ctx, id)
+		check("GetEvents()", err)
+		_, err = repo.ListVariants(ctx, id)
+		check("ListVariants()", err)
+		_, err = repo.FindByPromptHash(ctx, "x", id)
+		check("FindByPromptHash()", err)
+	}
+
+	images, err := repo.ListImages(ctx, repository.ListFilter{})
+	check("ListImages()", err)
+	if err == nil && len(images) != len(ids) {
+		t.Errorf("ListImages() = %d images, want %d", len(images), len(ids))
+	}
+	_, err = repo.CountImages(ctx, repository.ListFilter{})
+	check("CountImages()", err)
+	_, err = repo.CountByStatus(ctx)
+	check("CountByStatus()", err)
+	_, err = repo.GetReadyToGenerate(ctx)
+	check("GetReadyToGenerate()", err)
+	_, err = repo.GetReadyToCheck(ctx)
+	check("GetReadyToCheck()", err)
+	_, err = repo.GetAllReadyToGenerate(ctx, 0)
+	check("GetAllReadyToGenerate()", err)
+	_, err = repo.GetAllReadyToCheck(ctx, 0)
+	check("GetAllReadyToCheck()", err)
+	_, err = repo.GetAllReadyToPublish(ctx, 0)
+	check("GetAllReadyToPublish()", err)
+	// Claiming writes as well, so it runs last
+	_, err = repo.ClaimForGeneration(ctx, 0, "worker-a", 0)
+	check("ClaimForGeneration()", err)
+}
+
+func TestReadsOfRowsWithoutOptionalColumns(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	readAll(t, repository.NewPostgresImageRepository(db), insertNullRows(t, db))
+}
+
+func TestReadsOfRowsWithEachOptionalColumnNull(t *testing.T) {
+	db, _ := openTestDB(t)
+	columns := nullableColumns(t, db)
+	for _, required := range []string{"uuid", "base64", "style", "negative_prompt", "created_at", "updated_at"} {
+		if _, ok := columns[required]; !ok {
+			t.Errorf("column %s is not nullable, want it among the columns tested", required)
+		}
+	}
+
+	// Every other optional column is filled, so each read sees a NULL in one column only
+	var fill []string
+	for column, typ := range columns {
+		fill = append(fill, column+" = "+filledValue(t, column, typ))
+	}
+	for column := range columns {
+		t.Run(column, func(t *testing.T) {
+			emptyTestDB(t, db)
+			ids := insertNullRows(t, db)
+			query := `UPDATE images SET ` + strings.Join(fill, ", ") + `, ` + column + ` = NULL`
+			if _, err := db.Exec(query); err != nil {
+				t.Fatalf("failed to fill the columns: %v", err)
+			}
+			readAll(t, repository.NewPostgresImageRepository(db), ids)
+		})
+	}
+}
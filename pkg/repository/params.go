@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonParams stores extra generation parameters in the JSONB params column, NULL when empty.
+// Numbers are kept as json.Number, so they round-trip without losing precision.
+type jsonParams map[string]interface{}
+
+// Value implements driver.Valuer
+func (p jsonParams) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]interface{}(p))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+	// lib/pq would send []byte as bytea
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (p *jsonParams) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into params", src)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var params map[string]interface{}
+	if err := dec.Decode(&params); err != nil {
+		return fmt.Errorf("failed to unmarshal params: %w", err)
+	}
+	*p = params
+	return nil
+}
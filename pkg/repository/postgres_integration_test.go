@@ -0,0 +1,53 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/repository/repositorytest"
+	_ "github.com/lib/pq"
+)
+
+// testTables are emptied before every test, in an order the foreign keys allow
+const testTables = `image_results, prompt_revisions, image_events, images, generation_cache,
+	banned_terms, provider_usage, pipeline_control`
+
+// openTestDB connects to the database at TEST_DATABASE_URL and applies the schema, skipping
+// the test when it is not set. The database must be disposable: every test empties it.
+func openTestDB(t testing.TB) (*sql.DB, string) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := repository.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	return db, dsn
+}
+
+// emptyTestDB removes the rows of every test table and restarts the IDs
+func emptyTestDB(t testing.TB, db *sql.DB) {
+	t.Helper()
+	if _, err := db.Exec(`TRUNCATE ` + testTables + ` RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("failed to empty the test database: %v", err)
+	}
+}
+
+func TestPostgresConformance(t *testing.T) {
+	db, dsn := openTestDB(t)
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.ImageRepository {
+		emptyTestDB(t, db)
+		return repository.NewPostgresImageRepository(db, repository.WithNotifications(dsn))
+	})
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// AppendPromptRevision records text as the next revision of the prompt of an image and returns
+// its number. The prompt stored on the image is recorded first as the author's revision when the
+// image has none yet. A revision repeating an earlier one with the same source is not recorded
+// again, so requeued images do not grow their history; its number is returned instead.
+func (r *PostgresImageRepository) AppendPromptRevision(ctx context.Context, id int, text string, source domain.PromptSource) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Serializes concurrent appends to the same image
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM images WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO prompt_revisions (image_id, revision, text, source)
+		SELECT id, 1, prompt, 'author'
+		FROM images
+		WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM prompt_revisions WHERE image_id = $1)
+	`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return 0, err
+	}
+
+	var revision int
+	query = `
+		SELECT revision
+		FROM prompt_revisions
+		WHERE image_id = $1 AND text = $2 AND source = $3
+		ORDER BY revision DESC
+		LIMIT 1
+	`
+	err = tx.QueryRowContext(ctx, query, id, text, source).Scan(&revision)
+	if err == nil {
+		return revision, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO prompt_revisions (image_id, revision, text, source)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3
+		FROM prompt_revisions
+		WHERE image_id = $1
+		RETURNING revision
+	`
+	if err := tx.QueryRowContext(ctx, query, id, text, source).Scan(&revision); err != nil {
+		return 0, err
+	}
+
+	return revision, tx.Commit()
+}
+
+// GetPromptHistory retrieves every revision of the prompt of an image, oldest first. An image
+// whose prompt was never revised has only the author's revision; an unknown image has none.
+func (r *PostgresImageRepository) GetPromptHistory(ctx context.Context, id int) ([]domain.PromptRevision, error) {
+	query := `
+		SELECT image_id, revision, text, source, created_at
+		FROM prompt_revisions
+		WHERE image_id = $1
+		UNION ALL
+		SELECT id, 1, prompt, 'author', created_at
+		FROM images
+		WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM prompt_revisions WHERE image_id = $1)
+		ORDER BY revision ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []domain.PromptRevision
+	for rows.Next() {
+		var rev domain.PromptRevision
+		var createdAt sql.NullTime
+		if err := rows.Scan(&rev.ImageID, &rev.Revision, &rev.Text, &rev.Source, &createdAt); err != nil {
+			return nil, err
+		}
+		rev.CreatedAt = createdAt.Time
+		history = append(history, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
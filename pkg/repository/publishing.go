@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// GetAllReadyToPublish retrieves up to limit images ready to be published whose retry delay has
+// passed, oldest first; zero means no limit. The base64 column is not loaded, as most images
+// keep their data in variants.
+func (r *PostgresImageRepository) GetAllReadyToPublish(ctx context.Context, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT id, prompt, COALESCE(uuid, ''), selected_variant, publish_attempts,
+			requeue_attempts, created_at, updated_at
+		FROM images
+		WHERE status = 'ReadyToPublish'
+		AND (next_publish_at IS NULL OR next_publish_at <= now())
+		ORDER BY created_at ASC
+		LIMIT NULLIF($1, 0)
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*domain.Image
+	for rows.Next() {
+		var img domain.Image
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(
+			&img.ID,
+			&img.Prompt,
+			&img.UUID,
+			&img.SelectedVariant,
+			&img.PublishAttempts,
+			&img.Attempts,
+			&createdAt,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		img.CreatedAt = createdAt.Time
+		img.UpdatedAt = updatedAt.Time
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// MarkPublished sets the status of an image to Published and records when and where it was
+// published; an empty url leaves published_url unset
+func (r *PostgresImageRepository) MarkPublished(ctx context.Context, id int, url string) error {
+	query := `
+		UPDATE images
+		SET status = 'Published', published_at = now(), published_url = NULLIF($1, ''), updated_at = now()
+		WHERE id = $2
+	`
+
+	_, err := r.execEvent(ctx, "", query, url, id)
+	return err
+}
+
+// RecordPublishFailure counts a failed publishing attempt and delays the next one by retryAfter
+func (r *PostgresImageRepository) RecordPublishFailure(ctx context.Context, id int, errorDescription string, retryAfter time.Duration) error {
+	query := `
+		UPDATE images
+		SET publish_attempts = publish_attempts + 1, next_publish_at = now() + $1 * INTERVAL '1 millisecond',
+			error_description = $2, updated_at = now()
+		WHERE id = $3
+	`
+
+	_, err := r.db.ExecContext(ctx, query, retryAfter.Milliseconds(), errorDescription, id)
+	return err
+}
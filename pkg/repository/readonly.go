@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+// ErrReadOnly is returned by the methods of a ReadOnlyRepository that create rows
+var ErrReadOnly = errors.New("repository is read-only")
+
+// ReadOnlyRepository passes reads on to the wrapped repository and skips every write, logging
+// it instead. Updates report success so callers carry on as if they had been applied, while
+// creating rows fails with ErrReadOnly since there is no ID to return.
+type ReadOnlyRepository struct {
+	ImageRepository
+	logger *slog.Logger
+}
+
+// NewReadOnly wraps repo so that nothing is written; skipped writes are logged to logger
+func NewReadOnly(repo ImageRepository, logger *slog.Logger) *ReadOnlyRepository {
+	return &ReadOnlyRepository{ImageRepository: repo, logger: logger}
+}
+
+// skip logs a write that was not applied
+func (r *ReadOnlyRepository) skip(method string, id int, args ...any) {
+	r.logger.Info("Dry run: skipped write", append([]any{"method", method, "image_id", id}, args...)...)
+}
+
+// UpdateStatus logs the status change without applying it
+func (r *ReadOnlyRepository) UpdateStatus(ctx context.Context, id int, status domain.ImageStatus, opts ...StatusOption) error {
+	r.skip("UpdateStatus", id, "status", status)
+	return nil
+}
+
+// UpdateStatusWithError logs the status change without applying it
+func (r *ReadOnlyRepository) UpdateStatusWithError(ctx context.Context, id int, status domain.ImageStatus, errorDescription string, opts ...StatusOption) error {
+	r.skip("UpdateStatusWithError", id, "status", status, "error_description", errorDescription)
+	return nil
+}
+
+// UpdateUUID logs the UUID change without applying it
+func (r *ReadOnlyRepository) UpdateUUID(ctx context.Context, id int, uuid string) error {
+	r.skip("UpdateUUID", id, "uuid", uuid)
+	return nil
+}
+
+// UpdateProvider logs the provider change without applying it
+func (r *ReadOnlyRepository) UpdateProvider(ctx context.Context, id int, provider string) error {
+	r.skip("UpdateProvider", id, "provider", provider)
+	return nil
+}
+
+// UpdateSeed logs the seed change without applying it
+func (r *ReadOnlyRepository) UpdateSeed(ctx context.Context, id int, seed int64) error {
+	r.skip("UpdateSeed", id, "seed", seed)
+	return nil
+}
+
+// UpdateCensored logs the censored flag change without applying it
+func (r *ReadOnlyRepository) UpdateCensored(ctx context.Context, id int, censored bool) error {
+	r.skip("UpdateCensored", id, "censored", censored)
+	return nil
+}
+
+// SaveRawResponse logs the raw response without storing it
+func (r *ReadOnlyRepository) SaveRawResponse(ctx context.Context, id int, raw []byte) error {
+	r.skip("SaveRawResponse", id, "bytes", len(raw))
+	return nil
+}
+
+// UpdateBase64 logs the image data change without applying it
+func (r *ReadOnlyRepository) UpdateBase64(ctx context.Context, id int, base64 string) error {
+	r.skip("UpdateBase64", id)
+	return nil
+}
+
+// UpdateFilePath logs the file path change without applying it
+func (r *ReadOnlyRepository) UpdateFilePath(ctx context.Context, id int, path string) error {
+	r.skip("UpdateFilePath", id, "path", path)
+	return nil
+}
+
+// UpdateFileURL logs the file URL change without applying it
+func (r *ReadOnlyRepository) UpdateFileURL(ctx context.Context, id int, url string) error {
+	r.skip("UpdateFileURL", id, "url", url)
+	return nil
+}
+
+// UpdateThumbnail logs the thumbnail change without applying it
+func (r *ReadOnlyRepository) UpdateThumbnail(ctx context.Context, id int, thumbnail string) error {
+	r.skip("UpdateThumbnail", id)
+	return nil
+}
+
+// UpdateThumbnailPath logs the thumbnail path change without applying it
+func (r *ReadOnlyRepository) UpdateThumbnailPath(ctx context.Context, id int, path string) error {
+	r.skip("UpdateThumbnailPath", id, "path", path)
+	return nil
+}
+
+// UpdateMetadata logs the metadata change without applying it
+func (r *ReadOnlyRepository) UpdateMetadata(ctx context.Context, id int, meta domain.ImageMetadata) error {
+	r.skip("UpdateMetadata", id)
+	return nil
+}
+
+// RecordUsage logs the request without counting it
+func (r *ReadOnlyRepository) RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) error {
+	r.logger.Info("Dry run: skipped write", "method", "RecordUsage", "provider", provider, "failed", failed)
+	return nil
+}
+
+// SetPaused fails with ErrReadOnly
+func (r *ReadOnlyRepository) SetPaused(ctx context.Context, workflow string, paused bool) error {
+	return ErrReadOnly
+}
+
+// AddBannedTerm fails with ErrReadOnly
+func (r *ReadOnlyRepository) AddBannedTerm(ctx context.Context, term string, regex bool) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// DeleteBannedTerm logs the deletion without applying it
+func (r *ReadOnlyRepository) DeleteBannedTerm(ctx context.Context, id int) error {
+	r.logger.Info("Dry run: skipped write", "method", "DeleteBannedTerm", "term_id", id)
+	return nil
+}
+
+// MarkPublished logs the publication without recording it
+func (r *ReadOnlyRepository) MarkPublished(ctx context.Context, id int, url string) error {
+	r.skip("MarkPublished", id, "url", url)
+	return nil
+}
+
+// RecordPublishFailure logs the failure without recording it
+func (r *ReadOnlyRepository) RecordPublishFailure(ctx context.Context, id int, errorDescription string, retryAfter time.Duration) error {
+	r.skip("RecordPublishFailure", id, "error_description", errorDescription)
+	return nil
+}
+
+// UpdateWebhookStatus logs the delivery outcome without recording it
+func (r *ReadOnlyRepository) UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) error {
+	r.skip("UpdateWebhookStatus", id, "status", status)
+	return nil
+}
+
+// SaveVariants logs the variants without storing them
+func (r *ReadOnlyRepository) SaveVariants(ctx context.Context, id int, variants []domain.ImageVariant) error {
+	r.skip("SaveVariants", id, "variants", len(variants))
+	return nil
+}
+
+// SaveResultStream reads the result to its end and logs it without storing it
+func (r *ReadOnlyRepository) SaveResultStream(ctx context.Context, id int, src io.Reader) error {
+	n, err := io.Copy(io.Discard, src)
+	if err != nil {
+		return fmt.Errorf("failed to read result: %w", err)
+	}
+	r.skip("SaveResultStream", id, "bytes", n)
+	return nil
+}
+
+// DeleteVariant logs the deletion without applying it
+func (r *ReadOnlyRepository) DeleteVariant(ctx context.Context, id int, index int) error {
+	r.skip("DeleteVariant", id, "index", index)
+	return nil
+}
+
+// SelectVariant fails with ErrReadOnly since it must report whether the variant exists
+func (r *ReadOnlyRepository) SelectVariant(ctx context.Context, id int, index int) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// AppendPromptRevision logs the revision without recording it
+func (r *ReadOnlyRepository) AppendPromptRevision(ctx context.Context, id int, text string, source domain.PromptSource) (int, error) {
+	r.skip("AppendPromptRevision", id, "source", source)
+	return 0, nil
+}
+
+// CreateImage fails with ErrReadOnly
+func (r *ReadOnlyRepository) CreateImage(ctx context.Context, prompt string, opts ...CreateOption) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// BulkCreate fails with ErrReadOnly
+func (r *ReadOnlyRepository) BulkCreate(ctx context.Context, prompts []string, opts ...CreateOption) ([]int, error) {
+	return nil, ErrReadOnly
+}
+
+// CreateImages fails with ErrReadOnly
+func (r *ReadOnlyRepository) CreateImages(ctx context.Context, images []*domain.Image) ([]int, error) {
+	return nil, ErrReadOnly
+}
+
+// CreateFromTemplate fails with ErrReadOnly
+func (r *ReadOnlyRepository) CreateFromTemplate(ctx context.Context, tmpl *prompts.PromptTemplate, varSets []map[string]string, opts ...CreateOption) ([]int, error) {
+	return nil, ErrReadOnly
+}
+
+// UpdatePromptHash logs the prompt hash without storing it
+func (r *ReadOnlyRepository) UpdatePromptHash(ctx context.Context, id int, hash string) error {
+	r.skip("UpdatePromptHash", id, "hash", hash)
+	return nil
+}
+
+// MarkFailed logs the failure without recording it
+func (r *ReadOnlyRepository) MarkFailed(ctx context.Context, id int, errorDescription string, errorClass string) error {
+	r.skip("MarkFailed", id, "error_description", errorDescription, "error_class", errorClass)
+	return nil
+}
+
+// RecordReset logs the reset without counting it
+func (r *ReadOnlyRepository) RecordReset(ctx context.Context, id int) error {
+	r.skip("RecordReset", id)
+	return nil
+}
+
+// ClaimForGeneration returns the images ready for generation without claiming them
+func (r *ReadOnlyRepository) ClaimForGeneration(ctx context.Context, limit int, worker string, lease time.Duration) ([]*domain.Image, error) {
+	r.logger.Info("Dry run: skipped write", "method", "ClaimForGeneration", "worker_id", worker)
+	return r.ImageRepository.GetAllReadyToGenerate(ctx, limit)
+}
+
+// ExtendLease logs the renewal without applying it and reports the claim as held
+func (r *ReadOnlyRepository) ExtendLease(ctx context.Context, id int, worker string, lease time.Duration) (bool, error) {
+	r.skip("ExtendLease", id, "worker_id", worker)
+	return true, nil
+}
+
+// ReleaseClaim logs the release without applying it
+func (r *ReadOnlyRepository) ReleaseClaim(ctx context.Context, id int, worker string) error {
+	r.skip("ReleaseClaim", id, "worker_id", worker)
+	return nil
+}
+
+// ReclaimExpiredLeases reclaims nothing and reports no images
+func (r *ReadOnlyRepository) ReclaimExpiredLeases(ctx context.Context) (int, error) {
+	r.logger.Info("Dry run: skipped write", "method", "ReclaimExpiredLeases")
+	return 0, nil
+}
+
+// RequeueFailed requeues nothing and reports no images
+func (r *ReadOnlyRepository) RequeueFailed(ctx context.Context, olderThan time.Duration, maxAttempts int) (int, error) {
+	r.logger.Info("Dry run: skipped write", "method", "RequeueFailed")
+	return 0, nil
+}
+
+// PruneEvents deletes nothing and reports no events
+func (r *ReadOnlyRepository) PruneEvents(ctx context.Context, olderThan time.Duration) (int, error) {
+	r.logger.Info("Dry run: skipped write", "method", "PruneEvents")
+	return 0, nil
+}
+
+// Requeue fails with ErrReadOnly
+func (r *ReadOnlyRepository) Requeue(ctx context.Context, id int) (bool, error) {
+	return false, ErrReadOnly
+}
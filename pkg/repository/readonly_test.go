@@ -0,0 +1,62 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+func TestReadOnlySkipsWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryImageRepository()
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+	before, _ := repo.GetImage(ctx, id)
+	ro := repository.NewReadOnly(repo, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	updates := map[string]error{
+		"UpdateStatus":         ro.UpdateStatus(ctx, id, domain.StatusGenerate),
+		"UpdateUUID":           ro.UpdateUUID(ctx, id, "uuid-1"),
+		"UpdateProvider":       ro.UpdateProvider(ctx, id, "fusionbrain"),
+		"SaveVariants":         ro.SaveVariants(ctx, id, []domain.ImageVariant{{Index: 0, Data: "iVBORw0KGgo="}}),
+		"MarkFailed":           ro.MarkFailed(ctx, id, "internal error", "permanent"),
+		"RecordUsage":          ro.RecordUsage(ctx, "fusionbrain", time.Now(), false),
+		"UpdatePromptHash":     ro.UpdatePromptHash(ctx, id, "hash"),
+		"RecordPublishFailure": ro.RecordPublishFailure(ctx, id, "timeout", time.Minute),
+	}
+	for method, err := range updates {
+		if err != nil {
+			t.Errorf("%s() error = %v, want the write skipped silently", method, err)
+		}
+	}
+	if _, err := ro.ClaimForGeneration(ctx, 10, "test", time.Minute); err != nil {
+		t.Errorf("ClaimForGeneration() error = %v", err)
+	}
+	after, _ := repo.GetImage(ctx, id)
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("image after skipped writes = %+v, want %+v", after, before)
+	}
+	if usage, _ := repo.GetUsage(ctx, time.Now()); len(usage) != 0 {
+		t.Errorf("usage = %v, want nothing recorded", usage)
+	}
+
+	// Writes that must return something from the database fail instead
+	if _, err := ro.CreateImage(ctx, "a harbour"); !errors.Is(err, repository.ErrReadOnly) {
+		t.Errorf("CreateImage() error = %v, want %v", err, repository.ErrReadOnly)
+	}
+	if _, err := ro.Requeue(ctx, id); !errors.Is(err, repository.ErrReadOnly) {
+		t.Errorf("Requeue() error = %v, want %v", err, repository.ErrReadOnly)
+	}
+	if err := ro.SetPaused(ctx, "generator", true); !errors.Is(err, repository.ErrReadOnly) {
+		t.Errorf("SetPaused() error = %v, want %v", err, repository.ErrReadOnly)
+	}
+	if images, _ := repo.ListImages(ctx, repository.ListFilter{}); len(images) != 1 {
+		t.Errorf("repository holds %d images, want 1", len(images))
+	}
+}
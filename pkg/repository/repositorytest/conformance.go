@@ -0,0 +1,1280 @@
+// Package repositorytest checks that an implementation of repository.ImageRepository keeps the
+// contracts the workflows rely on. Each backend runs the suite from a thin test of its own:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.RunConformanceTests(t, func(t *testing.T) repository.ImageRepository {
+//			return newEmptyRepository(t)
+//		})
+//	}
+package repositorytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// RunConformanceTests runs every contract as a subtest named after it. factory is called once
+// per subtest, with the subtest, and must return a repository over empty storage.
+func RunConformanceTests(t *testing.T, factory func(t *testing.T) repository.ImageRepository) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo repository.ImageRepository)
+	}{
+		{"CreateImageRoundTrip", testCreateImageRoundTrip},
+		{"NotFound", testNotFound},
+		{"ReadyToGenerateOrder", testReadyToGenerateOrder},
+		{"ReadyToCheckNeedsUUID", testReadyToCheckNeedsUUID},
+		{"StatusTransitions", testStatusTransitions},
+		{"UpdatedAtAdvances", testUpdatedAtAdvances},
+		{"FailAndRequeue", testFailAndRequeue},
+		{"RequeueFailedAttemptCap", testRequeueFailedAttemptCap},
+		{"BulkCreateKeepsOrder", testBulkCreateKeepsOrder},
+		{"CreateImagesKeepsOrder", testCreateImagesKeepsOrder},
+		{"CreateFromTemplate", testCreateFromTemplate},
+		{"ScheduledImagesWait", testScheduledImagesWait},
+		{"FindPromptHashes", testFindPromptHashes},
+		{"ListAndCount", testListAndCount},
+		{"Publishing", testPublishing},
+		{"Variants", testVariants},
+		{"VariantsPerImage", testVariantsPerImage},
+		{"ResultStream", testResultStream},
+		{"PayloadsLoadedExplicitly", testPayloadsLoadedExplicitly},
+		{"PromptHistory", testPromptHistory},
+		{"AdvisoryLockExclusive", testAdvisoryLockExclusive},
+		{"ClaimForGeneration", testClaimForGeneration},
+		{"ExpiredLeaseReclaimed", testExpiredLeaseReclaimed},
+		{"EventTrail", testEventTrail},
+		{"PruneEvents", testPruneEvents},
+		{"RawResponse", testRawResponse},
+		{"UsagePerDay", testUsagePerDay},
+		{"OptionalColumnsUnset", testOptionalColumnsUnset},
+		{"PauseControl", testPauseControl},
+		{"ResetCount", testResetCount},
+		{"NewImagesNotified", testNewImagesNotified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, factory(t))
+		})
+	}
+}
+
+// create queues prompt, failing the test on errors
+func create(t *testing.T, repo repository.ImageRepository, prompt string, opts ...repository.CreateOption) int {
+	t.Helper()
+	id, err := repo.CreateImage(context.Background(), prompt, opts...)
+	if err != nil {
+		t.Fatalf("CreateImage(%q): %v", prompt, err)
+	}
+	return id
+}
+
+// get loads an image that must exist
+func get(t *testing.T, repo repository.ImageRepository, id int) *domain.Image {
+	t.Helper()
+	img, err := repo.GetImage(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetImage(%d): %v", id, err)
+	}
+	if img == nil {
+		t.Fatalf("GetImage(%d) = nil, want the image", id)
+	}
+	return img
+}
+
+// moveTo updates the status of an image, failing the test on errors
+func moveTo(t *testing.T, repo repository.ImageRepository, id int, status domain.ImageStatus) {
+	t.Helper()
+	if err := repo.UpdateStatus(context.Background(), id, status); err != nil {
+		t.Fatalf("UpdateStatus(%d, %s): %v", id, status, err)
+	}
+}
+
+// ids returns the IDs of images
+func ids(images []*domain.Image) []int {
+	out := []int{}
+	for _, img := range images {
+		out = append(out, img.ID)
+	}
+	return out
+}
+
+// testCreateImageRoundTrip checks that every creation option is stored and read back
+func testCreateImageRoundTrip(t *testing.T, repo repository.ImageRepository) {
+	params := map[string]interface{}{"mode": "fast"}
+	id := create(t, repo, "a lighthouse at dawn",
+		repository.WithSize(1024, 768),
+		repository.WithStyle("ANIME"),
+		repository.WithNegativePrompt("blurry"),
+		repository.WithSeed(42),
+		repository.WithPriority(7),
+		repository.WithCallbackURL("https://example.com/hook"),
+		repository.WithTags("campaign", "test"),
+		repository.WithParams(params),
+	)
+
+	img := get(t, repo, id)
+	want := domain.Image{
+		ID:             id,
+		Prompt:         "a lighthouse at dawn",
+		Status:         domain.StatusReadyToGenerate,
+		Width:          1024,
+		Height:         768,
+		Style:          "ANIME",
+		NegativePrompt: "blurry",
+		Seed:           42,
+		Priority:       7,
+		CallbackURL:    "https://example.com/hook",
+		Tags:           []string{"campaign", "test"},
+		Params:         params,
+	}
+	got := domain.Image{
+		ID:             img.ID,
+		Prompt:         img.Prompt,
+		Status:         img.Status,
+		Width:          img.Width,
+		Height:         img.Height,
+		Style:          img.Style,
+		NegativePrompt: img.NegativePrompt,
+		Seed:           img.Seed,
+		Priority:       img.Priority,
+		CallbackURL:    img.CallbackURL,
+		Tags:           img.Tags,
+		Params:         img.Params,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetImage = %+v, want %+v", got, want)
+	}
+	if img.CreatedAt.IsZero() || img.UpdatedAt.IsZero() {
+		t.Errorf("timestamps not set: created_at %v, updated_at %v", img.CreatedAt, img.UpdatedAt)
+	}
+	if img.HasResult() || img.Variants != 0 {
+		t.Errorf("new image has a result: %d variants", img.Variants)
+	}
+}
+
+// testNotFound checks that reads of missing rows return nil without an error and updates of
+// missing images are no-ops
+func testNotFound(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	const missing = 1 << 30
+
+	if img, err := repo.GetImage(ctx, missing); img != nil || err != nil {
+		t.Errorf("GetImage(missing) = %v, %v; want nil, nil", img, err)
+	}
+	if v, err := repo.GetVariant(ctx, missing, 0); v != nil || err != nil {
+		t.Errorf("GetVariant(missing) = %v, %v; want nil, nil", v, err)
+	}
+	if history, err := repo.GetPromptHistory(ctx, missing); len(history) != 0 || err != nil {
+		t.Errorf("GetPromptHistory(missing) = %v, %v; want none", history, err)
+	}
+	if err := repo.UpdateStatus(ctx, missing, domain.StatusGenerate); err != nil {
+		t.Errorf("UpdateStatus(missing) = %v, want nil", err)
+	}
+	if requeued, err := repo.Requeue(ctx, missing); requeued || err != nil {
+		t.Errorf("Requeue(missing) = %v, %v; want false, nil", requeued, err)
+	}
+	if selected, err := repo.SelectVariant(ctx, missing, 0); selected || err != nil {
+		t.Errorf("SelectVariant(missing) = %v, %v; want false, nil", selected, err)
+	}
+}
+
+// testReadyToGenerateOrder checks that images are submitted highest priority first, then oldest
+// first, within the limit
+func testReadyToGenerateOrder(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	first := create(t, repo, "first")
+	urgent := create(t, repo, "urgent", repository.WithPriority(5))
+	second := create(t, repo, "second")
+	started := create(t, repo, "started")
+	moveTo(t, repo, started, domain.StatusGenerate)
+
+	images, err := repo.GetAllReadyToGenerate(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetAllReadyToGenerate: %v", err)
+	}
+	if got, want := ids(images), []int{urgent, first, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllReadyToGenerate(0) = %v, want %v", got, want)
+	}
+
+	images, err = repo.GetAllReadyToGenerate(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetAllReadyToGenerate: %v", err)
+	}
+	if got, want := ids(images), []int{urgent, first}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllReadyToGenerate(2) = %v, want %v", got, want)
+	}
+}
+
+// testReadyToCheckNeedsUUID checks that only submitted images with a UUID are polled
+func testReadyToCheckNeedsUUID(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	submitted := create(t, repo, "submitted")
+	if err := repo.UpdateUUID(ctx, submitted, "uuid-1"); err != nil {
+		t.Fatalf("UpdateUUID: %v", err)
+	}
+	moveTo(t, repo, submitted, domain.StatusGenerate)
+	withoutUUID := create(t, repo, "without uuid")
+	moveTo(t, repo, withoutUUID, domain.StatusGenerate)
+
+	images, err := repo.GetAllReadyToCheck(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetAllReadyToCheck: %v", err)
+	}
+	if got, want := ids(images), []int{submitted}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllReadyToCheck = %v, want %v", got, want)
+	}
+	if len(images) == 1 && images[0].UUID != "uuid-1" {
+		t.Errorf("GetAllReadyToCheck UUID = %q, want uuid-1", images[0].UUID)
+	}
+}
+
+// testStatusTransitions checks that valid transitions persist, invalid ones fail with a
+// domain.TransitionError without changing the image, and Force overrides the check
+func testStatusTransitions(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "transitions")
+
+	moveTo(t, repo, id, domain.StatusGenerate)
+	if img := get(t, repo, id); img.Status != domain.StatusGenerate {
+		t.Errorf("status = %s, want %s", img.Status, domain.StatusGenerate)
+	}
+
+	err := repo.UpdateStatus(ctx, id, domain.StatusPublished)
+	var transitionErr *domain.TransitionError
+	if !errors.As(err, &transitionErr) || !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Errorf("UpdateStatus(Generate -> Published) = %v, want a *domain.TransitionError", err)
+	} else if transitionErr.From != domain.StatusGenerate || transitionErr.To != domain.StatusPublished {
+		t.Errorf("TransitionError = %+v, want Generate -> Published", transitionErr)
+	}
+	if img := get(t, repo, id); img.Status != domain.StatusGenerate {
+		t.Errorf("status after invalid transition = %s, want %s", img.Status, domain.StatusGenerate)
+	}
+
+	if err := repo.UpdateStatus(ctx, id, domain.StatusPublished, repository.Force()); err != nil {
+		t.Errorf("UpdateStatus(Force) = %v", err)
+	}
+	if img := get(t, repo, id); img.Status != domain.StatusPublished {
+		t.Errorf("status after forced transition = %s, want %s", img.Status, domain.StatusPublished)
+	}
+
+	if err := repo.UpdateStatusWithError(ctx, id, domain.StatusReadyToGenerate, "retry"); err != nil {
+		t.Fatalf("UpdateStatusWithError: %v", err)
+	}
+	if img := get(t, repo, id); img.ErrorDescription != "retry" {
+		t.Errorf("error_description = %q, want retry", img.ErrorDescription)
+	}
+}
+
+// testUpdatedAtAdvances checks that updates move updated_at forward and leave created_at alone
+func testUpdatedAtAdvances(t *testing.T, repo repository.ImageRepository) {
+	id := create(t, repo, "timestamps")
+	before := get(t, repo, id)
+
+	time.Sleep(20 * time.Millisecond)
+	moveTo(t, repo, id, domain.StatusGenerate)
+
+	after := get(t, repo, id)
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("updated_at = %v after update, want later than %v", after.UpdatedAt, before.UpdatedAt)
+	}
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("created_at changed from %v to %v", before.CreatedAt, after.CreatedAt)
+	}
+}
+
+// testFailAndRequeue checks MarkFailed and that Requeue only applies to finished images
+func testFailAndRequeue(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "fails")
+	if err := repo.UpdateUUID(ctx, id, "uuid-1"); err != nil {
+		t.Fatalf("UpdateUUID: %v", err)
+	}
+	moveTo(t, repo, id, domain.StatusGenerate)
+
+	if requeued, err := repo.Requeue(ctx, id); requeued || err != nil {
+		t.Errorf("Requeue(Generate) = %v, %v; want false, nil", requeued, err)
+	}
+
+	if err := repo.MarkFailed(ctx, id, "boom", "permanent"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	img := get(t, repo, id)
+	if img.Status != domain.StatusFailed || img.ErrorDescription != "boom" {
+		t.Errorf("after MarkFailed status = %s, error = %q; want Failed, boom", img.Status, img.ErrorDescription)
+	}
+
+	if requeued, err := repo.Requeue(ctx, id); !requeued || err != nil {
+		t.Fatalf("Requeue(Failed) = %v, %v; want true, nil", requeued, err)
+	}
+	img = get(t, repo, id)
+	if img.Status != domain.StatusReadyToGenerate || img.UUID != "" || img.ErrorDescription != "" {
+		t.Errorf("after Requeue status = %s, uuid = %q, error = %q; want ReadyToGenerate without uuid and error", img.Status, img.UUID, img.ErrorDescription)
+	}
+}
+
+// testRequeueFailedAttemptCap checks that RequeueFailed only requeues images that failed
+// transiently, clears their generation, and stops once an image reached maxAttempts
+func testRequeueFailedAttemptCap(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	fail := func(id int, class string) {
+		t.Helper()
+		if err := repo.UpdateUUID(ctx, id, fmt.Sprintf("uuid-%d", id)); err != nil {
+			t.Fatalf("UpdateUUID: %v", err)
+		}
+		moveTo(t, repo, id, domain.StatusGenerate)
+		if err := repo.MarkFailed(ctx, id, "failed as "+class, class); err != nil {
+			t.Fatalf("MarkFailed: %v", err)
+		}
+	}
+	transient := create(t, repo, "transient")
+	permanent := map[int]string{
+		create(t, repo, "censored"):  errclass.ClassCensored,
+		create(t, repo, "invalid"):   errclass.ClassInvalid,
+		create(t, repo, "permanent"): errclass.ClassPermanent,
+	}
+	fail(transient, errclass.ClassTransient)
+	for id, class := range permanent {
+		fail(id, class)
+	}
+
+	// Failures more recent than the cutoff wait
+	if n, err := repo.RequeueFailed(ctx, time.Hour, 2); n != 0 || err != nil {
+		t.Errorf("RequeueFailed(1h) = %d, %v; want 0, nil", n, err)
+	}
+
+	const maxAttempts = 2
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if n, err := repo.RequeueFailed(ctx, 0, maxAttempts); n != 1 || err != nil {
+			t.Fatalf("RequeueFailed attempt %d = %d, %v; want 1, nil", attempt, n, err)
+		}
+		img := get(t, repo, transient)
+		if img.Status != domain.StatusReadyToGenerate || img.UUID != "" || img.ErrorDescription != "" || img.Attempts != attempt {
+			t.Errorf("after requeue %d status = %s, uuid = %q, error = %q, attempts = %d; want ReadyToGenerate without uuid and error, %d attempts",
+				attempt, img.Status, img.UUID, img.ErrorDescription, img.Attempts, attempt)
+		}
+		fail(transient, errclass.ClassTransient)
+	}
+
+	// The image used up its attempts and stays Failed, like the permanent failures
+	if n, err := repo.RequeueFailed(ctx, 0, maxAttempts); n != 0 || err != nil {
+		t.Errorf("RequeueFailed at the cap = %d, %v; want 0, nil", n, err)
+	}
+	if img := get(t, repo, transient); img.Status != domain.StatusFailed || img.Attempts != maxAttempts {
+		t.Errorf("capped image status = %s, attempts = %d; want Failed, %d", img.Status, img.Attempts, maxAttempts)
+	}
+	for id, class := range permanent {
+		if img := get(t, repo, id); img.Status != domain.StatusFailed || img.Attempts != 0 {
+			t.Errorf("%s image status = %s, attempts = %d; want it left Failed", class, img.Status, img.Attempts)
+		}
+	}
+	// A higher cap lets the image through again
+	if n, err := repo.RequeueFailed(ctx, 0, maxAttempts+1); n != 1 || err != nil {
+		t.Errorf("RequeueFailed with a higher cap = %d, %v; want 1, nil", n, err)
+	}
+}
+
+// testBulkCreateKeepsOrder checks that BulkCreate returns the IDs in the order of the prompts
+// and applies the options to every image
+func testBulkCreateKeepsOrder(t *testing.T, repo repository.ImageRepository) {
+	prompts := []string{"one", "two", "three"}
+	created, err := repo.BulkCreate(context.Background(), prompts, repository.WithTags("bulk"))
+	if err != nil {
+		t.Fatalf("BulkCreate: %v", err)
+	}
+	if len(created) != len(prompts) {
+		t.Fatalf("BulkCreate returned %d IDs, want %d", len(created), len(prompts))
+	}
+	for i, id := range created {
+		img := get(t, repo, id)
+		if img.Prompt != prompts[i] || !reflect.DeepEqual(img.Tags, []string{"bulk"}) {
+			t.Errorf("image %d = %q with tags %v, want %q with tags [bulk]", id, img.Prompt, img.Tags, prompts[i])
+		}
+	}
+}
+
+// testCreateFromTemplate checks that CreateFromTemplate queues one image per variable set and
+// nothing when any set misses a variable
+func testCreateFromTemplate(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	tmpl, err := prompts.ParseTemplate("A {animal} by {artist}, {{draft}}")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+
+	varSets := []map[string]string{
+		{"animal": "fox", "artist": "Hokusai"},
+		{"animal": "owl", "artist": "Monet"},
+		{"animal": "cat", "artist": "Klimt"},
+	}
+	created, err := repo.CreateFromTemplate(ctx, tmpl, varSets, repository.WithTags("series"))
+	if err != nil {
+		t.Fatalf("CreateFromTemplate: %v", err)
+	}
+	if len(created) != len(varSets) {
+		t.Fatalf("CreateFromTemplate returned %d IDs, want %d", len(created), len(varSets))
+	}
+	for i, id := range created {
+		want := fmt.Sprintf("A %s by %s, {draft}", varSets[i]["animal"], varSets[i]["artist"])
+		if img := get(t, repo, id); img.Prompt != want || !reflect.DeepEqual(img.Tags, []string{"series"}) {
+			t.Errorf("image %d = %q with tags %v, want %q with tags [series]", id, img.Prompt, img.Tags, want)
+		}
+	}
+
+	_, err = repo.CreateFromTemplate(ctx, tmpl, []map[string]string{{"animal": "fox", "artist": "Hokusai"}, {"animal": "owl"}})
+	if err == nil {
+		t.Fatal("CreateFromTemplate with a missing variable succeeded")
+	}
+	if n, err := repo.CountImages(ctx, repository.ListFilter{}); n != len(varSets) || err != nil {
+		t.Errorf("CountImages = %d, %v; want only the %d images of the first template", n, err, len(varSets))
+	}
+}
+
+// testCreateImagesKeepsOrder checks that CreateImages stores the parameters of every image and
+// returns the IDs in their order
+func testCreateImagesKeepsOrder(t *testing.T, repo repository.ImageRepository) {
+	images := []*domain.Image{
+		{Prompt: "first", Style: "ANIME", Priority: 2, Tags: []string{"import"}},
+		{Prompt: "second", Width: 512, Height: 768, NegativePrompt: "blur"},
+		{Prompt: "third"},
+	}
+	created, err := repo.CreateImages(context.Background(), images)
+	if err != nil {
+		t.Fatalf("CreateImages: %v", err)
+	}
+	if len(created) != len(images) {
+		t.Fatalf("CreateImages returned %d IDs, want %d", len(created), len(images))
+	}
+	for i, id := range created {
+		img := get(t, repo, id)
+		want := images[i]
+		if img.Prompt != want.Prompt || img.Style != want.Style || img.Priority != want.Priority ||
+			img.Width != want.Width || img.Height != want.Height || img.NegativePrompt != want.NegativePrompt ||
+			len(img.Tags) != len(want.Tags) {
+			t.Errorf("image %d = %+v, want the parameters of %+v", id, img, want)
+		}
+		if img.Status != domain.StatusReadyToGenerate {
+			t.Errorf("image %d status = %s, want ReadyToGenerate", id, img.Status)
+		}
+	}
+}
+
+// testScheduledImagesWait checks that images scheduled for later are neither returned nor
+// claimed for generation
+func testScheduledImagesWait(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	later := create(t, repo, "later", repository.WithScheduledAt(time.Now().Add(time.Hour)))
+	due := create(t, repo, "due", repository.WithScheduledAt(time.Now().Add(-time.Minute)))
+
+	if img := get(t, repo, later); img.ScheduledAt.IsZero() {
+		t.Errorf("GetImage(%d).ScheduledAt is zero, want the scheduled time", later)
+	}
+	ready, err := repo.GetAllReadyToGenerate(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetAllReadyToGenerate: %v", err)
+	}
+	if got, want := ids(ready), []int{due}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllReadyToGenerate = %v, want %v", got, want)
+	}
+	claimed, err := repo.ClaimForGeneration(ctx, 0, "worker-a", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if got, want := ids(claimed), []int{due}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClaimForGeneration = %v, want %v", got, want)
+	}
+	if img, err := repo.GetReadyToGenerate(ctx); err != nil || img != nil {
+		t.Errorf("GetReadyToGenerate = %v, %v; want nil while the remaining image is scheduled", img, err)
+	}
+}
+
+// testFindPromptHashes checks that FindPromptHashes returns the oldest live image per hash and
+// leaves out failed images and unknown hashes
+func testFindPromptHashes(t *testing.T, repo repository.ImageRepository) {
+	first := create(t, repo, "a  red fox")
+	create(t, repo, "a red fox")
+	failed := create(t, repo, "a blue fox")
+	moveTo(t, repo, failed, domain.StatusFailed)
+
+	hash := func(prompt string) string {
+		return (&domain.Image{Prompt: prompt}).PromptHash(0, 0)
+	}
+	found, err := repo.FindPromptHashes(context.Background(), []string{hash("a red fox"), hash("a blue fox"), hash("a green fox")})
+	if err != nil {
+		t.Fatalf("FindPromptHashes: %v", err)
+	}
+	if want := map[string]int{hash("a red fox"): first}; !reflect.DeepEqual(found, want) {
+		t.Errorf("FindPromptHashes = %v, want %v", found, want)
+	}
+}
+
+// testListAndCount checks the filters, sorting and pagination of ListImages and the counts
+func testListAndCount(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	a := create(t, repo, "a", repository.WithTags("red"))
+	b := create(t, repo, "b")
+	c := create(t, repo, "c", repository.WithTags("red"))
+	moveTo(t, repo, b, domain.StatusGenerate)
+
+	cases := []struct {
+		name   string
+		filter repository.ListFilter
+		want   []int
+	}{
+		{"newest first", repository.ListFilter{}, []int{c, b, a}},
+		{"ascending", repository.ListFilter{Ascending: true}, []int{a, b, c}},
+		{"status", repository.ListFilter{Status: domain.StatusReadyToGenerate}, []int{c, a}},
+		{"tag", repository.ListFilter{Tag: "red"}, []int{c, a}},
+		{"page", repository.ListFilter{Limit: 1, Offset: 1}, []int{b}},
+	}
+	for _, tc := range cases {
+		images, err := repo.ListImages(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("ListImages(%s): %v", tc.name, err)
+		}
+		if got := ids(images); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ListImages(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if n, err := repo.CountImages(ctx, repository.ListFilter{Tag: "red", Limit: 1}); n != 2 || err != nil {
+		t.Errorf("CountImages(tag red) = %d, %v; want 2 ignoring the limit", n, err)
+	}
+	counts, err := repo.CountByStatus(ctx)
+	if err != nil {
+		t.Fatalf("CountByStatus: %v", err)
+	}
+	if counts[domain.StatusReadyToGenerate] != 2 || counts[domain.StatusGenerate] != 1 {
+		t.Errorf("CountByStatus = %v, want 2 ReadyToGenerate and 1 Generate", counts)
+	}
+}
+
+// testPublishing checks that ready images are published once and failures delay retries
+func testPublishing(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	ready := create(t, repo, "ready")
+	moveTo(t, repo, ready, domain.StatusReadyToPublish)
+	delayed := create(t, repo, "delayed")
+	moveTo(t, repo, delayed, domain.StatusReadyToPublish)
+
+	if err := repo.RecordPublishFailure(ctx, delayed, "offline", time.Hour); err != nil {
+		t.Fatalf("RecordPublishFailure: %v", err)
+	}
+	images, err := repo.GetAllReadyToPublish(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetAllReadyToPublish: %v", err)
+	}
+	if got, want := ids(images), []int{ready}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllReadyToPublish = %v, want %v without the delayed image", got, want)
+	}
+
+	if err := repo.MarkPublished(ctx, ready, "https://example.com/post/1"); err != nil {
+		t.Fatalf("MarkPublished: %v", err)
+	}
+	img := get(t, repo, ready)
+	if img.Status != domain.StatusPublished || img.PublishedURL != "https://example.com/post/1" || img.PublishedAt.IsZero() {
+		t.Errorf("after MarkPublished status = %s, url = %q, published_at = %v", img.Status, img.PublishedURL, img.PublishedAt)
+	}
+	if img := get(t, repo, delayed); img.PublishAttempts != 1 {
+		t.Errorf("publish_attempts = %d, want 1", img.PublishAttempts)
+	}
+
+	if err := repo.UpdateStatusWithError(ctx, delayed, domain.StatusPublishFailed, "offline"); err != nil {
+		t.Fatalf("UpdateStatusWithError: %v", err)
+	}
+	if images, err := repo.GetAllReadyToPublish(ctx, 0); err != nil || len(images) != 0 {
+		t.Errorf("GetAllReadyToPublish = %v, %v; want nothing left to publish", ids(images), err)
+	}
+	for status, want := range map[domain.ImageStatus]int{domain.StatusPublished: ready, domain.StatusPublishFailed: delayed} {
+		images, err := repo.ListImages(ctx, repository.ListFilter{Status: status})
+		if err != nil {
+			t.Fatalf("ListImages(%s): %v", status, err)
+		}
+		if got := ids(images); !reflect.DeepEqual(got, []int{want}) {
+			t.Errorf("ListImages(%s) = %v, want [%d]", status, got, want)
+		}
+	}
+	counts, err := repo.CountByStatus(ctx)
+	if err != nil {
+		t.Fatalf("CountByStatus: %v", err)
+	}
+	if counts[domain.StatusPublished] != 1 || counts[domain.StatusPublishFailed] != 1 || counts[domain.StatusReadyToPublish] != 0 {
+		t.Errorf("CountByStatus = %v, want 1 Published and 1 PublishFailed", counts)
+	}
+}
+
+// testVariants checks that variants replace each other, are counted and can be selected
+func testVariants(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "variants")
+
+	variants := []domain.ImageVariant{
+		{Index: 0, Provider: "fusionbrain", Data: "AAAA", Width: 64, Height: 64},
+		{Index: 1, Provider: "fusionbrain", FilePath: "1_uuid_1.png", Width: 64, Height: 32},
+	}
+	if err := repo.SaveVariants(ctx, id, variants); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+	stored, err := repo.ListVariants(ctx, id)
+	if err != nil {
+		t.Fatalf("ListVariants: %v", err)
+	}
+	if len(stored) != 2 || stored[0].Index != 0 || stored[1].Index != 1 {
+		t.Fatalf("ListVariants = %+v, want indexes 0 and 1", stored)
+	}
+	if v := stored[1]; v.ImageID != id || v.FilePath != "1_uuid_1.png" || v.Width != 64 || v.Height != 32 || v.Provider != "fusionbrain" {
+		t.Errorf("variant 1 = %+v", v)
+	}
+	if img := get(t, repo, id); img.Variants != 2 || img.SelectedVariant != 0 {
+		t.Errorf("image has %d variants, selected %d; want 2, 0", img.Variants, img.SelectedVariant)
+	}
+
+	if selected, err := repo.SelectVariant(ctx, id, 1); !selected || err != nil {
+		t.Errorf("SelectVariant(1) = %v, %v; want true, nil", selected, err)
+	}
+	if selected, err := repo.SelectVariant(ctx, id, 5); selected || err != nil {
+		t.Errorf("SelectVariant(5) = %v, %v; want false, nil", selected, err)
+	}
+	if img := get(t, repo, id); img.SelectedVariant != 1 {
+		t.Errorf("selected variant = %d, want 1", img.SelectedVariant)
+	}
+
+	if err := repo.DeleteVariant(ctx, id, 1); err != nil {
+		t.Fatalf("DeleteVariant: %v", err)
+	}
+	if v, err := repo.GetVariant(ctx, id, 1); v != nil || err != nil {
+		t.Errorf("GetVariant(deleted) = %v, %v; want nil, nil", v, err)
+	}
+	if img := get(t, repo, id); img.Variants != 1 || img.SelectedVariant != 0 {
+		t.Errorf("after deleting the selected variant: %d variants, selected %d; want 1, 0", img.Variants, img.SelectedVariant)
+	}
+
+	if err := repo.SaveVariants(ctx, id, variants[:1]); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+	if img := get(t, repo, id); img.Variants != 1 {
+		t.Errorf("SaveVariants kept %d variants, want them replaced by 1", img.Variants)
+	}
+}
+
+// testVariantsPerImage checks that variants belong to their image, are counted by ListImages
+// and are replaced atomically
+func testVariantsPerImage(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	first := create(t, repo, "three variants")
+	second := create(t, repo, "one variant")
+	none := create(t, repo, "no variants")
+
+	before := time.Now().Add(-time.Minute)
+	if err := repo.SaveVariants(ctx, first, []domain.ImageVariant{{Index: 0, Data: "AAAA"}, {Index: 1, Data: "BBBB"}, {Index: 2, Data: "CCCC"}}); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+	if err := repo.SaveVariants(ctx, second, []domain.ImageVariant{{Index: 0, Provider: "openai", FilePath: "2_0.png"}}); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+
+	images, err := repo.ListImages(ctx, repository.ListFilter{})
+	if err != nil {
+		t.Fatalf("ListImages: %v", err)
+	}
+	counts := map[int]int{}
+	for _, img := range images {
+		counts[img.ID] = img.Variants
+	}
+	if counts[first] != 3 || counts[second] != 1 || counts[none] != 0 {
+		t.Errorf("ListImages variant counts = %v, want 3, 1 and 0", counts)
+	}
+
+	// Variant IDs are unique across images and the variants of one image do not leak into another
+	seen := map[int]bool{}
+	for _, id := range []int{first, second} {
+		variants, err := repo.ListVariants(ctx, id)
+		if err != nil {
+			t.Fatalf("ListVariants: %v", err)
+		}
+		for _, v := range variants {
+			if v.ImageID != id || seen[v.ID] || v.ID == 0 || v.CreatedAt.Before(before) {
+				t.Errorf("variant %+v of image %d, want a new unique ID, the image and its creation time", v, id)
+			}
+			seen[v.ID] = true
+		}
+	}
+	if v, err := repo.GetVariant(ctx, second, 1); v != nil || err != nil {
+		t.Errorf("GetVariant(second, 1) = %+v, %v; want nil, nil", v, err)
+	}
+	if v, err := repo.GetVariant(ctx, first, 2); err != nil || v == nil || v.Data != "CCCC" {
+		t.Errorf("GetVariant(first, 2) = %+v, %v; want it with its data", v, err)
+	}
+
+	// A batch repeating an index is rejected without losing the stored variants
+	if err := repo.SaveVariants(ctx, first, []domain.ImageVariant{{Index: 0, Data: "DDDD"}, {Index: 0, Data: "EEEE"}}); err == nil {
+		t.Error("SaveVariants with a repeated index succeeded, want an error")
+	}
+	if img := get(t, repo, first); img.Variants != 3 {
+		t.Errorf("image has %d variants after a rejected SaveVariants, want the 3 stored before", img.Variants)
+	}
+	if v, err := repo.GetResult(ctx, first); err != nil || v == nil || v.Data != "AAAA" {
+		t.Errorf("GetResult after a rejected SaveVariants = %+v, %v; want the variant stored before", v, err)
+	}
+}
+
+// testResultStream checks that SaveResultStream replaces the variants with the streamed file,
+// base64 encoded intact across chunks
+func testResultStream(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "a streamed result")
+	if err := repo.SaveVariants(ctx, id, []domain.ImageVariant{{Index: 0, Data: "AAAA"}, {Index: 1, Data: "BBBB"}}); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+
+	// Long enough to span several chunks, with a length that leaves base64 padding at the end
+	data := bytes.Repeat([]byte("0123456789abcdefghij"), 150001)
+	if err := repo.SaveResultStream(ctx, id, bytes.NewReader(data)); err != nil {
+		t.Fatalf("SaveResultStream: %v", err)
+	}
+	variants, err := repo.ListVariants(ctx, id)
+	if err != nil {
+		t.Fatalf("ListVariants: %v", err)
+	}
+	if len(variants) != 1 || variants[0].Index != 0 {
+		t.Fatalf("ListVariants returned %d variants, want only index 0", len(variants))
+	}
+	v, err := repo.GetVariant(ctx, id, 0)
+	if err != nil || v == nil {
+		t.Fatalf("GetVariant(0) = %v, %v", v, err)
+	}
+	if got := v.Data; got != base64.StdEncoding.EncodeToString(data) {
+		t.Errorf("stored data has %d characters, want the %d of the encoded file", len(got), base64.StdEncoding.EncodedLen(len(data)))
+	}
+	if img := get(t, repo, id); img.SelectedVariant != 0 {
+		t.Errorf("SelectedVariant = %d, want 0", img.SelectedVariant)
+	}
+}
+
+// testPayloadsLoadedExplicitly checks that only GetImageData, GetVariant and GetResult return
+// stored files, while images still report that they have a result
+func testPayloadsLoadedExplicitly(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	legacy := create(t, repo, "a result in the base64 column")
+	if err := repo.UpdateBase64(ctx, legacy, "AAAA"); err != nil {
+		t.Fatalf("UpdateBase64: %v", err)
+	}
+	if img := get(t, repo, legacy); !img.LegacyResult || !img.HasResult() {
+		t.Errorf("GetImage of an image with base64 data: LegacyResult %v, HasResult %v; want true", img.LegacyResult, img.HasResult())
+	}
+	images, err := repo.ListImages(ctx, repository.ListFilter{})
+	if err != nil || len(images) != 1 || !images[0].HasResult() {
+		t.Errorf("ListImages = %v, %v; want the image with a result", images, err)
+	}
+	if data, err := repo.GetImageData(ctx, legacy); data != "AAAA" || err != nil {
+		t.Errorf("GetImageData = %q, %v; want AAAA", data, err)
+	}
+	if v, err := repo.GetResult(ctx, legacy); v != nil || err != nil {
+		t.Errorf("GetResult without variants = %v, %v; want nil, nil", v, err)
+	}
+
+	id := create(t, repo, "a result in variants")
+	if data, err := repo.GetImageData(ctx, id); data != "" || err != nil {
+		t.Errorf("GetImageData without a result = %q, %v; want empty", data, err)
+	}
+	if err := repo.SaveVariants(ctx, id, []domain.ImageVariant{{Index: 0, Data: "AAAA"}, {Index: 1, Data: "BBBB"}}); err != nil {
+		t.Fatalf("SaveVariants: %v", err)
+	}
+	variants, err := repo.ListVariants(ctx, id)
+	if err != nil {
+		t.Fatalf("ListVariants: %v", err)
+	}
+	for _, v := range variants {
+		if v.Data != "" {
+			t.Errorf("ListVariants returned the data of variant %d", v.Index)
+		}
+	}
+	if v, err := repo.GetResult(ctx, id); err != nil || v == nil || v.Data != "AAAA" {
+		t.Errorf("GetResult = %+v, %v; want variant 0 with its data", v, err)
+	}
+	if _, err := repo.SelectVariant(ctx, id, 1); err != nil {
+		t.Fatalf("SelectVariant: %v", err)
+	}
+	if v, err := repo.GetResult(ctx, id); err != nil || v == nil || v.Index != 1 || v.Data != "BBBB" {
+		t.Errorf("GetResult after selecting variant 1 = %+v, %v; want it with its data", v, err)
+	}
+}
+
+// testPromptHistory checks that the author's prompt is the first revision and repeated
+// revisions are not recorded twice
+func testPromptHistory(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "a very long prompt")
+
+	history, err := repo.GetPromptHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("GetPromptHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].Source != domain.PromptSourceAuthor || history[0].Text != "a very long prompt" {
+		t.Errorf("history of a new image = %+v, want the author's revision", history)
+	}
+
+	revision, err := repo.AppendPromptRevision(ctx, id, "a very", domain.PromptSourceTruncation)
+	if err != nil || revision != 2 {
+		t.Fatalf("AppendPromptRevision = %d, %v; want 2, nil", revision, err)
+	}
+	if again, err := repo.AppendPromptRevision(ctx, id, "a very", domain.PromptSourceTruncation); again != 2 || err != nil {
+		t.Errorf("repeated AppendPromptRevision = %d, %v; want 2, nil", again, err)
+	}
+
+	history, err = repo.GetPromptHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("GetPromptHistory: %v", err)
+	}
+	var sources []domain.PromptSource
+	for _, rev := range history {
+		sources = append(sources, rev.Source)
+	}
+	if want := []domain.PromptSource{domain.PromptSourceAuthor, domain.PromptSourceTruncation}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("history sources = %v, want %v", sources, want)
+	}
+	if img := get(t, repo, id); img.Prompt != "a very long prompt" {
+		t.Errorf("prompt = %q, want the author's to be kept", img.Prompt)
+	}
+}
+
+// testAdvisoryLockExclusive checks that a held lock cannot be taken again until released
+func testAdvisoryLockExclusive(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	const key = 4242
+
+	if acquired, err := repo.TryAdvisoryLock(ctx, key); !acquired || err != nil {
+		t.Fatalf("TryAdvisoryLock = %v, %v; want true, nil", acquired, err)
+	}
+	if acquired, err := repo.TryAdvisoryLock(ctx, key); acquired || err != nil {
+		t.Errorf("TryAdvisoryLock while held = %v, %v; want false, nil", acquired, err)
+	}
+	if err := repo.AdvisoryUnlock(ctx, key); err != nil {
+		t.Fatalf("AdvisoryUnlock: %v", err)
+	}
+	if acquired, err := repo.TryAdvisoryLock(ctx, key); !acquired || err != nil {
+		t.Errorf("TryAdvisoryLock after release = %v, %v; want true, nil", acquired, err)
+	}
+	if err := repo.AdvisoryUnlock(ctx, key); err != nil {
+		t.Errorf("AdvisoryUnlock: %v", err)
+	}
+}
+
+// testClaimForGeneration checks that claims follow the generation order, record the worker and
+// are not handed to another worker, and that extending and releasing a claim needs its worker
+func testClaimForGeneration(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	first := create(t, repo, "first")
+	urgent := create(t, repo, "urgent", repository.WithPriority(5))
+	second := create(t, repo, "second")
+
+	claimed, err := repo.ClaimForGeneration(ctx, 2, "worker-a", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if got, want := ids(claimed), []int{urgent, first}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ClaimForGeneration(2) = %v, want %v", got, want)
+	}
+	img := get(t, repo, urgent)
+	if img.Status != domain.StatusClaimed || img.WorkerID != "worker-a" || img.LeaseExpiresAt.IsZero() {
+		t.Errorf("claimed image: status %s, worker %q, lease %v; want Claimed by worker-a with a lease", img.Status, img.WorkerID, img.LeaseExpiresAt)
+	}
+
+	claimed, err = repo.ClaimForGeneration(ctx, 0, "worker-b", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if got, want := ids(claimed), []int{second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClaimForGeneration by another worker = %v, want %v", got, want)
+	}
+
+	if ok, err := repo.ExtendLease(ctx, urgent, "worker-b", time.Minute); ok || err != nil {
+		t.Errorf("ExtendLease by another worker = %v, %v; want false, nil", ok, err)
+	}
+	if ok, err := repo.ExtendLease(ctx, urgent, "worker-a", time.Minute); !ok || err != nil {
+		t.Errorf("ExtendLease = %v, %v; want true, nil", ok, err)
+	}
+	if err := repo.ReleaseClaim(ctx, urgent, "worker-b"); err != nil {
+		t.Fatalf("ReleaseClaim: %v", err)
+	}
+	if status := get(t, repo, urgent).Status; status != domain.StatusClaimed {
+		t.Errorf("status after release by another worker = %s, want %s", status, domain.StatusClaimed)
+	}
+	if err := repo.ReleaseClaim(ctx, urgent, "worker-a"); err != nil {
+		t.Fatalf("ReleaseClaim: %v", err)
+	}
+	if status := get(t, repo, urgent).Status; status != domain.StatusReadyToGenerate {
+		t.Errorf("status after release = %s, want %s", status, domain.StatusReadyToGenerate)
+	}
+
+	// A submitted image is no longer claimed, so neither renewing nor releasing affects it
+	moveTo(t, repo, first, domain.StatusGenerate)
+	if ok, err := repo.ExtendLease(ctx, first, "worker-a", time.Minute); ok || err != nil {
+		t.Errorf("ExtendLease of a submitted image = %v, %v; want false, nil", ok, err)
+	}
+	if err := repo.ReleaseClaim(ctx, first, "worker-a"); err != nil {
+		t.Fatalf("ReleaseClaim: %v", err)
+	}
+	if status := get(t, repo, first).Status; status != domain.StatusGenerate {
+		t.Errorf("status after releasing a submitted image = %s, want %s", status, domain.StatusGenerate)
+	}
+}
+
+// testExpiredLeaseReclaimed simulates a worker that claims an image and disappears: the image
+// stays reserved while the lease runs and goes to another worker once it lapsed
+func testExpiredLeaseReclaimed(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	const lease = 50 * time.Millisecond
+	id := create(t, repo, "abandoned")
+	renewed := create(t, repo, "renewed")
+
+	if _, err := repo.ClaimForGeneration(ctx, 0, "crashed", lease); err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if n, err := repo.ReclaimExpiredLeases(ctx); n != 0 || err != nil {
+		t.Errorf("ReclaimExpiredLeases before the lease lapsed = %d, %v; want 0, nil", n, err)
+	}
+	if claimed, err := repo.ClaimForGeneration(ctx, 0, "survivor", time.Minute); len(claimed) != 0 || err != nil {
+		t.Errorf("ClaimForGeneration while leased = %v, %v; want none", ids(claimed), err)
+	}
+
+	// The second image keeps being renewed by a worker that is still alive
+	if ok, err := repo.ExtendLease(ctx, renewed, "crashed", time.Minute); !ok || err != nil {
+		t.Fatalf("ExtendLease = %v, %v; want true, nil", ok, err)
+	}
+	time.Sleep(2 * lease)
+
+	if n, err := repo.ReclaimExpiredLeases(ctx); n != 1 || err != nil {
+		t.Errorf("ReclaimExpiredLeases = %d, %v; want 1, nil", n, err)
+	}
+	claimed, err := repo.ClaimForGeneration(ctx, 0, "survivor", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if got, want := ids(claimed), []int{id}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClaimForGeneration after the lease lapsed = %v, want %v", got, want)
+	}
+	if img := get(t, repo, id); img.WorkerID != "survivor" {
+		t.Errorf("worker = %q, want survivor", img.WorkerID)
+	}
+}
+
+// testEventTrail checks that every status transition is recorded in order with its actor and
+// reason, and that rejected transitions record nothing
+func testEventTrail(t *testing.T, repo repository.ImageRepository) {
+	ctx := repository.ContextWithActor(context.Background(), "generator")
+	id := create(t, repo, "audited")
+
+	if _, err := repo.ClaimForGeneration(ctx, 0, "worker-1", time.Minute); err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if err := repo.UpdateStatus(ctx, id, domain.StatusGenerate); err != nil {
+		t.Fatalf("UpdateStatus(Generate): %v", err)
+	}
+	if err := repo.UpdateStatus(ctx, id, domain.StatusPublished); err == nil {
+		t.Errorf("UpdateStatus(Generate -> Published) = nil, want an error")
+	}
+	if err := repo.UpdateStatus(ctx, id, domain.StatusReadyToPublish, repository.WithReason("done")); err != nil {
+		t.Fatalf("UpdateStatus(ReadyToPublish): %v", err)
+	}
+
+	events, err := repo.GetEvents(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+	type step struct {
+		From, To domain.ImageStatus
+		Actor    string
+		Reason   string
+	}
+	var got []step
+	for _, e := range events {
+		if e.ImageID != id {
+			t.Errorf("event %d belongs to image %d, want %d", e.ID, e.ImageID, id)
+		}
+		got = append(got, step{e.From, e.To, e.Actor, e.Reason})
+	}
+	want := []step{
+		{domain.StatusReadyToGenerate, domain.StatusClaimed, "generator", "claimed by worker-1"},
+		{domain.StatusClaimed, domain.StatusGenerate, "generator", ""},
+		{domain.StatusGenerate, domain.StatusReadyToPublish, "generator", "done"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+
+	if events, err := repo.GetEvents(ctx, id+1000); len(events) != 0 || err != nil {
+		t.Errorf("GetEvents(missing) = %v, %v; want none", events, err)
+	}
+}
+
+// testPruneEvents checks that PruneEvents deletes the events older than its cutoff of every
+// image, while the images themselves are kept
+func testPruneEvents(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	first, second := create(t, repo, "pruned"), create(t, repo, "pruned too")
+	for _, id := range []int{first, second} {
+		if err := repo.UpdateStatus(ctx, id, domain.StatusGenerate); err != nil {
+			t.Fatalf("UpdateStatus(Generate): %v", err)
+		}
+	}
+
+	if n, err := repo.PruneEvents(ctx, time.Hour); n != 0 || err != nil {
+		t.Errorf("PruneEvents(1h) = %d, %v; want nothing pruned", n, err)
+	}
+	if events, err := repo.GetEvents(ctx, first); len(events) != 1 || err != nil {
+		t.Fatalf("GetEvents after PruneEvents(1h) = %v, %v; want the event kept", events, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if n, err := repo.PruneEvents(ctx, time.Millisecond); n != 2 || err != nil {
+		t.Errorf("PruneEvents(1ms) = %d, %v; want 2", n, err)
+	}
+	for _, id := range []int{first, second} {
+		if events, err := repo.GetEvents(ctx, id); len(events) != 0 || err != nil {
+			t.Errorf("GetEvents(%d) after PruneEvents = %v, %v; want none", id, events, err)
+		}
+		if img := get(t, repo, id); img.Status != domain.StatusGenerate {
+			t.Errorf("image %d status = %s after PruneEvents, want it kept as Generate", id, img.Status)
+		}
+	}
+}
+
+// testRawResponse checks that a stored provider response is returned by GetImage
+func testRawResponse(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "failed")
+	if img := get(t, repo, id); img.RawResponse != nil {
+		t.Errorf("raw response before SaveRawResponse = %s, want none", img.RawResponse)
+	}
+
+	raw := []byte(`{"uuid": "u-1", "status": "FAIL", "errorDescription": "model overloaded"}`)
+	if err := repo.SaveRawResponse(ctx, id, raw); err != nil {
+		t.Fatalf("SaveRawResponse: %v", err)
+	}
+
+	var got, want interface{}
+	if err := json.Unmarshal(get(t, repo, id).RawResponse, &got); err != nil {
+		t.Fatalf("stored raw response is not JSON: %v", err)
+	}
+	if err := json.Unmarshal(raw, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("raw response = %v, want %v", got, want)
+	}
+}
+
+// testUsagePerDay checks that requests are counted per provider and UTC day, so a new day starts
+// from zero
+func testUsagePerDay(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	beforeMidnight := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	afterMidnight := beforeMidnight.Add(2 * time.Minute)
+
+	record := func(provider string, at time.Time, failed bool) {
+		t.Helper()
+		if err := repo.RecordUsage(ctx, provider, at, failed); err != nil {
+			t.Fatalf("RecordUsage(%s, %s): %v", provider, at, err)
+		}
+	}
+	record("openai", beforeMidnight, false)
+	record("fusionbrain", beforeMidnight, false)
+	record("fusionbrain", beforeMidnight.Add(-time.Hour), true)
+	// Local times count on the UTC day they fall on
+	record("fusionbrain", afterMidnight.In(time.FixedZone("UTC-5", -5*3600)), false)
+
+	day := domain.UsageDay(beforeMidnight)
+	usage, err := repo.GetUsage(ctx, beforeMidnight)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	want := []domain.ProviderUsage{
+		{Provider: "fusionbrain", Day: day, Requests: 2, Failures: 1},
+		{Provider: "openai", Day: day, Requests: 1},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("usage before midnight = %+v, want %+v", usage, want)
+	}
+
+	usage, err = repo.GetUsage(ctx, afterMidnight)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	want = []domain.ProviderUsage{{Provider: "fusionbrain", Day: domain.UsageDay(afterMidnight), Requests: 1}}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("usage after midnight = %+v, want %+v", usage, want)
+	}
+}
+
+// testOptionalColumnsUnset checks that an image created without any option, whose optional
+// columns are all unset, can be read by every query that returns images
+func testOptionalColumnsUnset(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "bare")
+
+	img := get(t, repo, id)
+	if img.UUID != "" || img.Style != "" || img.NegativePrompt != "" || img.LegacyResult || img.Provider != "" || img.ErrorDescription != "" {
+		t.Errorf("GetImage of a bare image = %+v, want empty optional fields", img)
+	}
+	if _, err := repo.ListImages(ctx, repository.ListFilter{}); err != nil {
+		t.Errorf("ListImages: %v", err)
+	}
+	if _, err := repo.GetReadyToGenerate(ctx); err != nil {
+		t.Errorf("GetReadyToGenerate: %v", err)
+	}
+	if _, err := repo.GetAllReadyToGenerate(ctx, 0); err != nil {
+		t.Errorf("GetAllReadyToGenerate: %v", err)
+	}
+	if err := repo.UpdatePromptHash(ctx, id, "bare-hash"); err != nil {
+		t.Fatalf("UpdatePromptHash: %v", err)
+	}
+	if _, err := repo.FindByPromptHash(ctx, "bare-hash", id+1); err != nil {
+		t.Errorf("FindByPromptHash: %v", err)
+	}
+	if _, _, err := repo.GetThumbnail(ctx, id); err != nil {
+		t.Errorf("GetThumbnail: %v", err)
+	}
+
+	claimed, err := repo.ClaimForGeneration(ctx, 0, "worker-a", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if got, want := ids(claimed), []int{id}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClaimForGeneration = %v, want %v", got, want)
+	}
+
+	moveTo(t, repo, id, domain.StatusReadyToPublish)
+	if _, err := repo.GetAllReadyToPublish(ctx, 0); err != nil {
+		t.Errorf("GetAllReadyToPublish: %v", err)
+	}
+	if _, err := repo.GetEvents(ctx, id); err != nil {
+		t.Errorf("GetEvents: %v", err)
+	}
+}
+
+// testPauseControl checks that pausing and resuming a workflow is stored per workflow
+func testPauseControl(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	if controls, err := repo.GetControls(ctx); len(controls) != 0 || err != nil {
+		t.Fatalf("GetControls before any pause = %v, %v; want none", controls, err)
+	}
+
+	for _, workflow := range []string{"processor", "generator"} {
+		if err := repo.SetPaused(ctx, workflow, true); err != nil {
+			t.Fatalf("SetPaused(%s): %v", workflow, err)
+		}
+	}
+	if err := repo.SetPaused(ctx, "processor", false); err != nil {
+		t.Fatalf("SetPaused(processor, false): %v", err)
+	}
+
+	controls, err := repo.GetControls(ctx)
+	if err != nil {
+		t.Fatalf("GetControls: %v", err)
+	}
+	var got []string
+	for _, c := range controls {
+		got = append(got, fmt.Sprintf("%s=%v", c.Workflow, c.Paused))
+		if c.UpdatedAt.IsZero() {
+			t.Errorf("control of %s has no updated_at", c.Workflow)
+		}
+	}
+	if want := []string{"generator=true", "processor=false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetControls = %v, want %v", got, want)
+	}
+}
+
+// testResetCount checks that resets are counted, visible to the processor, and cleared by a
+// manual requeue
+func testResetCount(t *testing.T, repo repository.ImageRepository) {
+	ctx := context.Background()
+	id := create(t, repo, "lost")
+	for i := 0; i < 2; i++ {
+		if err := repo.RecordReset(ctx, id); err != nil {
+			t.Fatalf("RecordReset: %v", err)
+		}
+	}
+	if err := repo.UpdateUUID(ctx, id, "uuid-1"); err != nil {
+		t.Fatalf("UpdateUUID: %v", err)
+	}
+	moveTo(t, repo, id, domain.StatusGenerate)
+
+	checks, err := repo.GetAllReadyToCheck(ctx, 0)
+	if err != nil || len(checks) != 1 {
+		t.Fatalf("GetAllReadyToCheck = %d images, %v; want 1", len(checks), err)
+	}
+	if checks[0].ResetCount != 2 {
+		t.Errorf("GetAllReadyToCheck reset count = %d, want 2", checks[0].ResetCount)
+	}
+
+	if err := repo.MarkFailed(ctx, id, "result expired repeatedly", "permanent"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	if img := get(t, repo, id); img.ResetCount != 2 {
+		t.Errorf("GetImage reset count = %d, want 2", img.ResetCount)
+	}
+	if requeued, err := repo.Requeue(ctx, id); !requeued || err != nil {
+		t.Fatalf("Requeue = %v, %v; want true, nil", requeued, err)
+	}
+	if img := get(t, repo, id); img.ResetCount != 0 {
+		t.Errorf("after Requeue reset count = %d, want 0", img.ResetCount)
+	}
+}
+
+// notifyLatency is how quickly a listener must learn about a queued image
+const notifyLatency = 100 * time.Millisecond
+
+// testNewImagesNotified checks that listeners are woken by new images and by images returned
+// to the queue, and that their channel is closed when they stop listening. Repositories
+// without notifications skip it.
+func testNewImagesNotified(t *testing.T, repo repository.ImageRepository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wake, err := repo.ListenForNewImages(ctx)
+	if errors.Is(err, repository.ErrNotificationsUnavailable) {
+		t.Skip("repository does not notify about new images")
+	}
+	if err != nil {
+		t.Fatalf("ListenForNewImages: %v", err)
+	}
+
+	waitForWake := func(event string) {
+		t.Helper()
+		select {
+		case _, ok := <-wake:
+			if !ok {
+				t.Fatalf("channel closed while waiting for %s", event)
+			}
+		case <-time.After(notifyLatency):
+			t.Fatalf("no notification within %v of %s", notifyLatency, event)
+		}
+	}
+
+	id := create(t, repo, "queued")
+	waitForWake("CreateImage")
+
+	if _, err := repo.ClaimForGeneration(ctx, 0, "worker-a", time.Minute); err != nil {
+		t.Fatalf("ClaimForGeneration: %v", err)
+	}
+	if err := repo.ReleaseClaim(ctx, id, "worker-a"); err != nil {
+		t.Fatalf("ReleaseClaim: %v", err)
+	}
+	waitForWake("ReleaseClaim")
+
+	cancel()
+	for range wake {
+	}
+}
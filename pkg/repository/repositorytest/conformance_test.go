@@ -0,0 +1,78 @@
+package repositorytest_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/repository/repositorytest"
+)
+
+// brokenEnv makes TestBrokenRepository run the suite, in the child process started by
+// TestSuiteNamesViolatedContracts
+const brokenEnv = "REPOSITORYTEST_BROKEN"
+
+// brokenRepository breaks two contracts of the memory repository: it hands out the newest
+// images first and treats a missing image as an error
+type brokenRepository struct {
+	*repository.MemoryImageRepository
+}
+
+func (r brokenRepository) GetAllReadyToGenerate(ctx context.Context, limit int) ([]*domain.Image, error) {
+	images, err := r.MemoryImageRepository.GetAllReadyToGenerate(ctx, 0)
+	for i, j := 0, len(images)-1; i < j; i, j = i+1, j-1 {
+		images[i], images[j] = images[j], images[i]
+	}
+	if limit > 0 && len(images) > limit {
+		images = images[:limit]
+	}
+	return images, err
+}
+
+func (r brokenRepository) GetImage(ctx context.Context, id int) (*domain.Image, error) {
+	img, err := r.MemoryImageRepository.GetImage(ctx, id)
+	if img == nil && err == nil {
+		return nil, errors.New("image not found")
+	}
+	return img, err
+}
+
+func TestBrokenRepository(t *testing.T) {
+	if os.Getenv(brokenEnv) == "" {
+		t.Skip("only runs in the child process of TestSuiteNamesViolatedContracts")
+	}
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.ImageRepository {
+		return brokenRepository{repository.NewMemoryImageRepository()}
+	})
+}
+
+func TestSuiteNamesViolatedContracts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the suite in a child process")
+	}
+	// A failing suite fails its test, so it runs in a copy of this test binary
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBrokenRepository$", "-test.v")
+	cmd.Env = append(os.Environ(), brokenEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("suite against a broken repository = %v, want it to fail\n%s", err, out)
+	}
+
+	// Each failure names the contract that was broken, and unrelated contracts still pass
+	for _, want := range []string{
+		"--- FAIL: TestBrokenRepository/ReadyToGenerateOrder",
+		"--- FAIL: TestBrokenRepository/NotFound",
+		"--- PASS: TestBrokenRepository/CreateImageRoundTrip",
+		"--- PASS: TestBrokenRepository/Variants",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("suite output has no %q\n%s", want, out)
+		}
+	}
+}
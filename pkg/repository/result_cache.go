@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// cachedResponse is the stored form of a cached response. Unlike the JSON form of
+// domain.ImageGenerationResponse it keeps the files, under the field names used since the
+// cache was introduced.
+type cachedResponse struct {
+	UUID             string
+	Status           string
+	Files            []string
+	Censored         bool
+	ErrorDescription string
+	Provider         string
+	Seed             int64
+}
+
+// PostgresResultCache implements domain.ResultCache on the generation_cache table
+type PostgresResultCache struct {
+	db *sql.DB
+}
+
+// NewPostgresResultCache creates a new PostgreSQL result cache
+func NewPostgresResultCache(db *sql.DB) *PostgresResultCache {
+	return &PostgresResultCache{db: db}
+}
+
+// Get returns the cached response for key, or nil if there is none or it expired
+func (c *PostgresResultCache) Get(ctx context.Context, key string) (*domain.ImageGenerationResponse, error) {
+	query := `
+		SELECT response
+		FROM generation_cache
+		WHERE key = $1
+		AND expires_at > now()
+	`
+
+	var data []byte
+	err := c.db.QueryRowContext(ctx, query, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+
+	return &domain.ImageGenerationResponse{
+		UUID:             cached.UUID,
+		Status:           cached.Status,
+		Files:            cached.Files,
+		Censored:         cached.Censored,
+		ErrorDescription: cached.ErrorDescription,
+		Provider:         cached.Provider,
+		Seed:             cached.Seed,
+	}, nil
+}
+
+// Set stores the response for key for the given time to live
+func (c *PostgresResultCache) Set(ctx context.Context, key string, resp *domain.ImageGenerationResponse, ttl time.Duration) error {
+	data, err := json.Marshal(cachedResponse{
+		UUID:             resp.UUID,
+		Status:           resp.Status,
+		Files:            resp.Files,
+		Censored:         resp.Censored,
+		ErrorDescription: resp.ErrorDescription,
+		Provider:         resp.Provider,
+		Seed:             resp.Seed,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	query := `
+		INSERT INTO generation_cache (key, response, expires_at)
+		VALUES ($1, $2, now() + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE
+		SET response = EXCLUDED.response, expires_at = EXCLUDED.expires_at
+	`
+
+	_, err = c.db.ExecContext(ctx, query, key, data, ttl.Milliseconds())
+	return err
+}
+
+// EvictExpired deletes the expired entries and returns how many there were
+func (c *PostgresResultCache) EvictExpired(ctx context.Context) (int, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM generation_cache WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict expired entries: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,204 @@
+CREATE TABLE IF NOT EXISTS images (
+    id SERIAL PRIMARY KEY,
+    prompt TEXT NOT NULL,
+    uuid TEXT,
+    status TEXT NOT NULL DEFAULT 'ReadyToGenerate',
+    base64 TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_images_status ON images(status);
+CREATE INDEX IF NOT EXISTS idx_images_uuid ON images(uuid);
+
+-- Columns added after the initial release; safe to re-run on existing databases
+ALTER TABLE images ADD COLUMN IF NOT EXISTS error_description TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS prompt_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_images_prompt_hash ON images(prompt_hash);
+
+CREATE TABLE IF NOT EXISTS generation_cache (
+    key TEXT PRIMARY KEY,
+    response JSONB NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_generation_cache_expires_at ON generation_cache(expires_at);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS seed BIGINT;
+
+CREATE TABLE IF NOT EXISTS image_results (
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    index INTEGER NOT NULL,
+    data TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (image_id, index)
+);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS censored BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS height INTEGER;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS style TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS negative_prompt TEXT;
+
+-- STORAGE=fs keeps generated files on disk and only records their paths
+ALTER TABLE images ADD COLUMN IF NOT EXISTS file_path TEXT;
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS file_path TEXT;
+ALTER TABLE image_results ALTER COLUMN data DROP NOT NULL;
+
+-- Presigned download URL of the first file when STORAGE=s3 and S3_PRESIGN_TTL is set
+ALTER TABLE images ADD COLUMN IF NOT EXISTS file_url TEXT;
+
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS processed_path TEXT;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS skip_watermark BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- JPEG thumbnail of the first file, inline for STORAGE=db or as a storage location otherwise
+ALTER TABLE images ADD COLUMN IF NOT EXISTS thumbnail TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS thumbnail_path TEXT;
+
+-- Metadata of the first generated file, recorded when generation completes
+ALTER TABLE images ADD COLUMN IF NOT EXISTS generation_started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS output_width INTEGER;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS output_height INTEGER;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS byte_size INTEGER;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS format TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS generation_duration_ms BIGINT;
+
+CREATE TABLE IF NOT EXISTS banned_terms (
+    id SERIAL PRIMARY KEY,
+    term TEXT NOT NULL UNIQUE,
+    is_regex BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS published_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS publish_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS next_publish_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS callback_url TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS webhook_status TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS webhook_attempts INTEGER;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS published_url TEXT;
+
+-- Images waiting for generation are submitted highest priority first
+ALTER TABLE images ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_images_tags ON images USING GIN (tags);
+
+-- Failed images remember the class of their error; transient failures are requeued
+-- automatically up to REQUEUE_FAILED_MAX_ATTEMPTS times
+ALTER TABLE images ADD COLUMN IF NOT EXISTS error_class TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS requeue_attempts INTEGER NOT NULL DEFAULT 0;
+
+-- Extra generation parameters passed verbatim to the provider
+ALTER TABLE images ADD COLUMN IF NOT EXISTS params JSONB;
+
+-- Every generated file is a variant of its image; selected_variant is the index of the one
+-- that is published
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS id SERIAL;
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE image_results ADD COLUMN IF NOT EXISTS height INTEGER;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_image_results_id ON image_results (id);
+ALTER TABLE images ADD COLUMN IF NOT EXISTS selected_variant INTEGER NOT NULL DEFAULT 0;
+
+-- Every version of a prompt, starting with the author's, so rewrites before submission can be
+-- reconstructed
+CREATE TABLE IF NOT EXISTS prompt_revisions (
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    source TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (image_id, revision)
+);
+
+-- The generator and processor scan the queued and submitted images oldest first; a partial
+-- index keeps those scans small however many finished images the table holds
+CREATE INDEX IF NOT EXISTS idx_images_status_created_at ON images (status, created_at)
+    WHERE status IN ('ReadyToGenerate', 'Generate');
+
+-- Generators claim images under a lease; claims of workers that stopped renewing them are
+-- returned to ReadyToGenerate once lease_expires_at has passed
+ALTER TABLE images ADD COLUMN IF NOT EXISTS worker_id TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_images_lease_expires_at ON images (lease_expires_at)
+    WHERE status = 'Claimed';
+
+-- Every status transition of an image with the actor and reason the repository passes in the
+-- transaction-local settings xiang.event_actor and xiang.event_reason. Transitions made by hand
+-- are recorded too, without them. Events are deleted with their image.
+CREATE TABLE IF NOT EXISTS image_events (
+    id SERIAL PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    actor TEXT,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_image_events_image_id ON image_events (image_id, id);
+
+CREATE OR REPLACE FUNCTION record_image_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO image_events (image_id, from_status, to_status, actor, reason)
+    VALUES (NEW.id, OLD.status, NEW.status,
+        NULLIF(current_setting('xiang.event_actor', true), ''),
+        NULLIF(current_setting('xiang.event_reason', true), ''));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS images_status_event ON images;
+CREATE TRIGGER images_status_event
+    AFTER UPDATE OF status ON images
+    FOR EACH ROW
+    WHEN (OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION record_image_event();
+
+-- The provider's status response of the latest failed or censored generation, kept with
+-- CAPTURE_RAW_RESPONSES
+ALTER TABLE images ADD COLUMN IF NOT EXISTS raw_response JSONB;
+
+-- Generation requests submitted to each provider per UTC day, for the daily caps
+CREATE TABLE IF NOT EXISTS provider_usage (
+    provider TEXT NOT NULL,
+    day DATE NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, day)
+);
+
+-- Times the processor reset an image because the provider no longer knew its generation
+ALTER TABLE images ADD COLUMN IF NOT EXISTS reset_count INTEGER NOT NULL DEFAULT 0;
+
+-- Workflows paused with the pause command or POST /control/pause; a workflow without a row runs
+CREATE TABLE IF NOT EXISTS pipeline_control (
+    workflow TEXT PRIMARY KEY,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Images held back from generation until a given time, e.g. by import
+ALTER TABLE images ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMP WITH TIME ZONE;
+
+-- Wakes the listeners of ListenForNewImages when an image becomes ready for generation.
+-- Postgres folds identical notifications of a transaction into one, so bulk inserts notify once.
+CREATE OR REPLACE FUNCTION notify_image_ready() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('images_ready', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS images_ready_notify ON images;
+CREATE TRIGGER images_ready_notify
+    AFTER INSERT OR UPDATE OF status ON images
+    FOR EACH ROW
+    WHEN (NEW.status = 'ReadyToGenerate')
+    EXECUTE FUNCTION notify_image_ready();
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/lib/pq"
+)
+
+// StatusOption modifies a status update in UpdateStatus and UpdateStatusWithError
+type StatusOption func(*statusOptions)
+
+type statusOptions struct {
+	force  bool
+	reason string
+}
+
+// Force applies a status update even when domain.ValidTransitions does not allow it, for admin
+// tooling repairing images by hand
+func Force() StatusOption {
+	return func(o *statusOptions) {
+		o.force = true
+	}
+}
+
+// WithReason records why the status changed in the event log; UpdateStatusWithError records its
+// error description unless a reason is given
+func WithReason(reason string) StatusOption {
+	return func(o *statusOptions) {
+		o.reason = reason
+	}
+}
+
+// newStatusOptions applies opts
+func newStatusOptions(opts []StatusOption) statusOptions {
+	var o statusOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// allowedFrom returns the statuses an update to status may start from as a query argument, or
+// every status when the update is forced
+func allowedFrom(status domain.ImageStatus, o statusOptions) interface{} {
+	from := domain.TransitionsTo(status)
+	if o.force {
+		from = domain.Statuses
+	}
+	names := make([]string, len(from))
+	for i, s := range from {
+		names[i] = string(s)
+	}
+	return pq.Array(names)
+}
+
+// checkTransition turns a status update that matched no row into a *domain.TransitionError when
+// the image exists in a status that does not allow it. Updates of missing images are no-ops.
+func (r *PostgresImageRepository) checkTransition(ctx context.Context, res sql.Result, id int, status domain.ImageStatus) error {
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var current domain.ImageStatus
+	err = r.db.QueryRowContext(ctx, `SELECT status FROM images WHERE id = $1`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &domain.TransitionError{ID: id, From: current, To: status}
+}
@@ -0,0 +1,204 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// setStatus moves an image to status behind the back of the repository
+func setStatus(t *testing.T, db *sql.DB, id int, status domain.ImageStatus) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE images SET status = $1 WHERE id = $2`, status, id); err != nil {
+		t.Fatalf("failed to set the status: %v", err)
+	}
+}
+
+// backdate moves the last update of an image into the past by interval, a Postgres interval
+func backdate(t *testing.T, db *sql.DB, id int, interval string) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE images SET updated_at = now() - $1::interval WHERE id = $2`, interval, id); err != nil {
+		t.Fatalf("failed to backdate the image: %v", err)
+	}
+}
+
+func TestStatusTransitionsEnforcedByQueries(t *testing.T) {
+	db, _ := openTestDB(t)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	// Every pair of statuses goes through the same query, so the rules of the domain are the
+	// rules of the database
+	for _, from := range domain.Statuses {
+		for _, to := range domain.Statuses {
+			if from == to {
+				continue
+			}
+			emptyTestDB(t, db)
+			id, _ := repo.CreateImage(ctx, "a lighthouse")
+			setStatus(t, db, id, from)
+			events := eventCount(t, db, id)
+
+			err := repo.UpdateStatus(ctx, id, to)
+			img, getErr := repo.GetImage(ctx, id)
+			if getErr != nil {
+				t.Fatalf("GetImage() error = %v", getErr)
+			}
+			if domain.CanTransition(from, to) {
+				if err != nil || img.Status != to {
+					t.Errorf("UpdateStatus(%s -> %s) = %v, status %s, want the move", from, to, err, img.Status)
+				}
+				continue
+			}
+			var transitionErr *domain.TransitionError
+			if !errors.As(err, &transitionErr) || transitionErr.From != from || transitionErr.To != to {
+				t.Errorf("UpdateStatus(%s -> %s) error = %v, want a TransitionError", from, to, err)
+			}
+			if img.Status != from || eventCount(t, db, id) != events {
+				t.Errorf("UpdateStatus(%s -> %s) left status %s and recorded events, want nothing changed", from, to, img.Status)
+			}
+		}
+	}
+}
+
+func TestStatusUpdatesOfMissingImages(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	if err := repo.UpdateStatus(ctx, 404, domain.StatusGenerate); err != nil {
+		t.Errorf("UpdateStatus() of a missing image error = %v, want a no-op", err)
+	}
+	if err := repo.MarkFailed(ctx, 404, "internal error", errclass.ClassPermanent); err != nil {
+		t.Errorf("MarkFailed() of a missing image error = %v, want a no-op", err)
+	}
+	if err := repo.UpdateStatus(ctx, 404, domain.StatusPublished, repository.Force()); err != nil {
+		t.Errorf("forced UpdateStatus() of a missing image error = %v, want a no-op", err)
+	}
+}
+
+func TestForcedStatusUpdate(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := repository.ContextWithActor(context.Background(), "admin")
+	id, _ := repo.CreateImage(ctx, "a lighthouse")
+	setStatus(t, db, id, domain.StatusPublished)
+
+	err := repo.UpdateStatus(ctx, id, domain.StatusReadyToPublish, repository.Force(), repository.WithReason("published to the wrong channel"))
+	if err != nil {
+		t.Fatalf("forced UpdateStatus() error = %v", err)
+	}
+	events, _ := repo.GetEvents(ctx, id)
+	if len(events) != 2 || events[1].From != domain.StatusPublished || events[1].Actor != "admin" || events[1].Reason != "published to the wrong channel" {
+		t.Errorf("events = %+v, want the forced move with its actor and reason", events)
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	generating, _ := repo.CreateImage(ctx, "a lighthouse")
+	if err := repo.UpdateStatus(ctx, generating, domain.StatusGenerate); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	if err := repo.UpdateUUID(ctx, generating, "u-1"); err != nil {
+		t.Fatalf("UpdateUUID() error = %v", err)
+	}
+	if err := repo.MarkFailed(ctx, generating, "content policy violation", errclass.ClassPermanent); err != nil {
+		t.Fatalf("MarkFailed() error = %v", err)
+	}
+	img, _ := repo.GetImage(ctx, generating)
+	// The UUID is kept so the failed generation can be looked up at the provider
+	if img.Status != domain.StatusFailed || img.ErrorDescription != "content policy violation" || img.UUID != "u-1" {
+		t.Errorf("failed image = %s %q uuid %q, want Failed with the description and the UUID", img.Status, img.ErrorDescription, img.UUID)
+	}
+	var class sql.NullString
+	if err := db.QueryRow(`SELECT error_class FROM images WHERE id = $1`, generating).Scan(&class); err != nil || class.String != errclass.ClassPermanent {
+		t.Errorf("error_class = %v, %v, want %s", class, err, errclass.ClassPermanent)
+	}
+
+	// A published image cannot fail any more, and keeps its state
+	published, _ := repo.CreateImage(ctx, "a published lighthouse")
+	setStatus(t, db, published, domain.StatusPublished)
+	var transitionErr *domain.TransitionError
+	if err := repo.MarkFailed(ctx, published, "late error", errclass.ClassTransient); !errors.As(err, &transitionErr) {
+		t.Errorf("MarkFailed() of a published image error = %v, want a TransitionError", err)
+	}
+	if img, _ := repo.GetImage(ctx, published); img.Status != domain.StatusPublished || img.ErrorDescription != "" {
+		t.Errorf("published image = %s %q, want it unchanged", img.Status, img.ErrorDescription)
+	}
+}
+
+func TestRequeueFailed(t *testing.T) {
+	db, _ := openTestDB(t)
+	emptyTestDB(t, db)
+	repo := repository.NewPostgresImageRepository(db)
+	ctx := context.Background()
+
+	failed := func(prompt, class, age string) int {
+		t.Helper()
+		id, _ := repo.CreateImage(ctx, prompt)
+		if err := repo.UpdateStatus(ctx, id, domain.StatusGenerate); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		repo.UpdateUUID(ctx, id, "u-"+prompt)
+		if err := repo.MarkFailed(ctx, id, "model overloaded", class); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+		backdate(t, db, id, age)
+		return id
+	}
+	old := failed("old", errclass.ClassTransient, "2 hours")
+	recent := failed("recent", errclass.ClassTransient, "10 minutes")
+	permanent := failed("permanent", errclass.ClassPermanent, "2 hours")
+	unclassified := failed("unclassified", "", "2 hours")
+
+	// The age is measured on the database clock, so only the old transient failure qualifies
+	n, err := repo.RequeueFailed(ctx, time.Hour, 2)
+	if err != nil || n != 1 {
+		t.Fatalf("RequeueFailed() = %d, %v, want 1", n, err)
+	}
+	img, _ := repo.GetImage(ctx, old)
+	if img.Status != domain.StatusReadyToGenerate || img.UUID != "" || img.ErrorDescription != "" || img.Attempts != 1 {
+		t.Errorf("requeued image = %s uuid %q error %q attempts %d, want a fresh queued image on its first requeue", img.Status, img.UUID, img.ErrorDescription, img.Attempts)
+	}
+	for _, id := range []int{recent, permanent, unclassified} {
+		if img, _ := repo.GetImage(ctx, id); img.Status != domain.StatusFailed {
+			t.Errorf("image %d = %s, want it left Failed", id, img.Status)
+		}
+	}
+
+	// The attempt cap counts requeues across failures
+	for attempt := 2; attempt <= 3; attempt++ {
+		if err := repo.UpdateStatus(ctx, old, domain.StatusGenerate); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		if err := repo.MarkFailed(ctx, old, "model overloaded", errclass.ClassTransient); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+		backdate(t, db, old, "2 hours")
+		want := 1
+		if attempt > 2 {
+			want = 0
+		}
+		if n, err := repo.RequeueFailed(ctx, time.Hour, 2); n != want || err != nil {
+			t.Errorf("RequeueFailed() on failure %d = %d, %v, want %d", attempt, n, err, want)
+		}
+	}
+	if img, _ := repo.GetImage(ctx, old); img.Status != domain.StatusFailed || img.Attempts != 2 {
+		t.Errorf("image past the cap = %s with %d attempts, want Failed after 2", img.Status, img.Attempts)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// inTimeZone returns a single-connection pool on the test database whose session runs in zone
+func inTimeZone(t *testing.T, dsn, zone string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// The setting belongs to the session, so every query has to use the same connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`SET TIME ZONE '` + zone + `'`); err != nil {
+		t.Fatalf("failed to set the time zone: %v", err)
+	}
+	return db
+}
+
+// dbNow returns the clock of the database
+func dbNow(t *testing.T, db *sql.DB) time.Time {
+	t.Helper()
+	var now time.Time
+	if err := db.QueryRow(`SELECT clock_timestamp()`).Scan(&now); err != nil {
+		t.Fatalf("failed to read the database clock: %v", err)
+	}
+	return now
+}
+
+func TestTimestampsRoundTripAcrossTimeZones(t *testing.T) {
+	_, dsn := openTestDB(t)
+	ctx := context.Background()
+	kathmandu := time.FixedZone("+0545", 5*3600+45*60)
+	scheduled := time.Date(2024, 3, 31, 1, 30, 0, 123456000, kathmandu)
+
+	var ids []int
+	// Sessions on either side of UTC, one of them in the middle of a DST change, write and read
+	// the same instants
+	for _, zone := range []string{"UTC", "America/New_York", "Asia/Kathmandu", "Europe/Berlin"} {
+		t.Run(zone, func(t *testing.T) {
+			db := inTimeZone(t, dsn, zone)
+			repo := repository.NewPostgresImageRepository(db)
+
+			before := dbNow(t, db)
+			id, err := repo.CreateImage(ctx, "a lighthouse", repository.WithScheduledAt(scheduled))
+			if err != nil {
+				t.Fatalf("CreateImage() error = %v", err)
+			}
+			after := dbNow(t, db)
+			ids = append(ids, id)
+
+			img, err := repo.GetImage(ctx, id)
+			if err != nil {
+				t.Fatalf("GetImage() error = %v", err)
+			}
+			if !img.ScheduledAt.Equal(scheduled) {
+				t.Errorf("ScheduledAt = %s, want %s", img.ScheduledAt, scheduled)
+			}
+			if img.CreatedAt.Before(before) || img.CreatedAt.After(after) || !img.UpdatedAt.Equal(img.CreatedAt) {
+				t.Errorf("CreatedAt = %s, UpdatedAt = %s, want the database clock between %s and %s", img.CreatedAt, img.UpdatedAt, before, after)
+			}
+
+			// Updates take the time from the database as well
+			before = dbNow(t, db)
+			if err := repo.UpdateStatus(ctx, id, domain.StatusFailed); err != nil {
+				t.Fatalf("UpdateStatus() error = %v", err)
+			}
+			after = dbNow(t, db)
+			img, _ = repo.GetImage(ctx, id)
+			if img.UpdatedAt.Before(before) || img.UpdatedAt.After(after) {
+				t.Errorf("UpdatedAt = %s, want the database clock between %s and %s", img.UpdatedAt, before, after)
+			}
+		})
+	}
+
+	// A session in another zone reads the instants the others wrote
+	db := inTimeZone(t, dsn, "Pacific/Chatham")
+	repo := repository.NewPostgresImageRepository(db)
+	for _, id := range ids {
+		img, err := repo.GetImage(ctx, id)
+		if err != nil {
+			t.Fatalf("GetImage() error = %v", err)
+		}
+		var epoch float64
+		if err := db.QueryRow(`SELECT extract(epoch FROM created_at) FROM images WHERE id = $1`, id).Scan(&epoch); err != nil {
+			t.Fatalf("failed to read created_at: %v", err)
+		}
+		if got := float64(img.CreatedAt.UnixMicro()) / 1e6; got-epoch > 1e-6 || epoch-got > 1e-6 {
+			t.Errorf("image %d CreatedAt = %f, want %f seconds since the epoch", id, got, epoch)
+		}
+		if !img.ScheduledAt.Equal(scheduled) {
+			t.Errorf("image %d ScheduledAt = %s, want %s", id, img.ScheduledAt, scheduled)
+		}
+	}
+}
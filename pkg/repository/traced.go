@@ -0,0 +1,470 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+)
+
+// TracedRepository records a span around every call to the wrapped repository, named after the
+// method, e.g. repository.ClaimForGeneration
+type TracedRepository struct {
+	repo ImageRepository
+}
+
+// NewTraced wraps repo so that each of its methods is traced
+func NewTraced(repo ImageRepository) *TracedRepository {
+	return &TracedRepository{repo: repo}
+}
+
+// GetReadyToGenerate implements ImageRepository
+func (r *TracedRepository) GetReadyToGenerate(ctx context.Context) (_ *domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetReadyToGenerate")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetReadyToGenerate(ctx)
+}
+
+// GetReadyToCheck implements ImageRepository
+func (r *TracedRepository) GetReadyToCheck(ctx context.Context) (_ *domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetReadyToCheck")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetReadyToCheck(ctx)
+}
+
+// UpdateStatus implements ImageRepository
+func (r *TracedRepository) UpdateStatus(ctx context.Context, id int, status domain.ImageStatus, opts ...StatusOption) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateStatus")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateStatus(ctx, id, status, opts...)
+}
+
+// UpdateStatusWithError implements ImageRepository
+func (r *TracedRepository) UpdateStatusWithError(ctx context.Context, id int, status domain.ImageStatus, errorDescription string, opts ...StatusOption) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateStatusWithError")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateStatusWithError(ctx, id, status, errorDescription, opts...)
+}
+
+// UpdateUUID implements ImageRepository
+func (r *TracedRepository) UpdateUUID(ctx context.Context, id int, uuid string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateUUID")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateUUID(ctx, id, uuid)
+}
+
+// UpdateProvider implements ImageRepository
+func (r *TracedRepository) UpdateProvider(ctx context.Context, id int, provider string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateProvider")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateProvider(ctx, id, provider)
+}
+
+// UpdateSeed implements ImageRepository
+func (r *TracedRepository) UpdateSeed(ctx context.Context, id int, seed int64) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateSeed")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateSeed(ctx, id, seed)
+}
+
+// UpdateCensored implements ImageRepository
+func (r *TracedRepository) UpdateCensored(ctx context.Context, id int, censored bool) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateCensored")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateCensored(ctx, id, censored)
+}
+
+// SaveRawResponse implements ImageRepository
+func (r *TracedRepository) SaveRawResponse(ctx context.Context, id int, raw []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.SaveRawResponse")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.SaveRawResponse(ctx, id, raw)
+}
+
+// UpdateBase64 implements ImageRepository
+func (r *TracedRepository) UpdateBase64(ctx context.Context, id int, base64 string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateBase64")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateBase64(ctx, id, base64)
+}
+
+// UpdateFilePath implements ImageRepository
+func (r *TracedRepository) UpdateFilePath(ctx context.Context, id int, path string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateFilePath")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateFilePath(ctx, id, path)
+}
+
+// UpdateFileURL implements ImageRepository
+func (r *TracedRepository) UpdateFileURL(ctx context.Context, id int, url string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateFileURL")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateFileURL(ctx, id, url)
+}
+
+// UpdateThumbnail implements ImageRepository
+func (r *TracedRepository) UpdateThumbnail(ctx context.Context, id int, thumbnail string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateThumbnail")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateThumbnail(ctx, id, thumbnail)
+}
+
+// UpdateThumbnailPath implements ImageRepository
+func (r *TracedRepository) UpdateThumbnailPath(ctx context.Context, id int, path string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateThumbnailPath")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateThumbnailPath(ctx, id, path)
+}
+
+// GetThumbnail implements ImageRepository
+func (r *TracedRepository) GetThumbnail(ctx context.Context, id int) (_ []byte, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetThumbnail")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetThumbnail(ctx, id)
+}
+
+// GetImageData implements ImageRepository
+func (r *TracedRepository) GetImageData(ctx context.Context, id int) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetImageData")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetImageData(ctx, id)
+}
+
+// UpdateMetadata implements ImageRepository
+func (r *TracedRepository) UpdateMetadata(ctx context.Context, id int, meta domain.ImageMetadata) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateMetadata")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateMetadata(ctx, id, meta)
+}
+
+// ListImages implements ImageRepository
+func (r *TracedRepository) ListImages(ctx context.Context, filter ListFilter) (_ []*domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ListImages")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ListImages(ctx, filter)
+}
+
+// CountImages implements ImageRepository
+func (r *TracedRepository) CountImages(ctx context.Context, filter ListFilter) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CountImages")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.CountImages(ctx, filter)
+}
+
+// CountByStatus implements ImageRepository
+func (r *TracedRepository) CountByStatus(ctx context.Context) (_ map[domain.ImageStatus]int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CountByStatus")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.CountByStatus(ctx)
+}
+
+// RecordUsage implements ImageRepository
+func (r *TracedRepository) RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.RecordUsage")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.RecordUsage(ctx, provider, day, failed)
+}
+
+// GetUsage implements ImageRepository
+func (r *TracedRepository) GetUsage(ctx context.Context, day time.Time) (_ []domain.ProviderUsage, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetUsage")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetUsage(ctx, day)
+}
+
+// SetPaused implements ImageRepository
+func (r *TracedRepository) SetPaused(ctx context.Context, workflow string, paused bool) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.SetPaused")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.SetPaused(ctx, workflow, paused)
+}
+
+// GetControls implements ImageRepository
+func (r *TracedRepository) GetControls(ctx context.Context) (_ []domain.WorkflowControl, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetControls")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetControls(ctx)
+}
+
+// ListBannedTerms implements ImageRepository
+func (r *TracedRepository) ListBannedTerms(ctx context.Context) (_ []domain.BannedTerm, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ListBannedTerms")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ListBannedTerms(ctx)
+}
+
+// AddBannedTerm implements ImageRepository
+func (r *TracedRepository) AddBannedTerm(ctx context.Context, term string, regex bool) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.AddBannedTerm")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.AddBannedTerm(ctx, term, regex)
+}
+
+// DeleteBannedTerm implements ImageRepository
+func (r *TracedRepository) DeleteBannedTerm(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.DeleteBannedTerm")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.DeleteBannedTerm(ctx, id)
+}
+
+// GetAllReadyToPublish implements ImageRepository
+func (r *TracedRepository) GetAllReadyToPublish(ctx context.Context, limit int) (_ []*domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetAllReadyToPublish")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetAllReadyToPublish(ctx, limit)
+}
+
+// MarkPublished implements ImageRepository
+func (r *TracedRepository) MarkPublished(ctx context.Context, id int, url string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.MarkPublished")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.MarkPublished(ctx, id, url)
+}
+
+// RecordPublishFailure implements ImageRepository
+func (r *TracedRepository) RecordPublishFailure(ctx context.Context, id int, errorDescription string, retryAfter time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.RecordPublishFailure")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.RecordPublishFailure(ctx, id, errorDescription, retryAfter)
+}
+
+// UpdateWebhookStatus implements ImageRepository
+func (r *TracedRepository) UpdateWebhookStatus(ctx context.Context, id int, status string, attempts int) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdateWebhookStatus")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdateWebhookStatus(ctx, id, status, attempts)
+}
+
+// SaveVariants implements ImageRepository
+func (r *TracedRepository) SaveVariants(ctx context.Context, id int, variants []domain.ImageVariant) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.SaveVariants")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.SaveVariants(ctx, id, variants)
+}
+
+// SaveResultStream implements ImageRepository
+func (r *TracedRepository) SaveResultStream(ctx context.Context, id int, src io.Reader) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.SaveResultStream")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.SaveResultStream(ctx, id, src)
+}
+
+// ListVariants implements ImageRepository
+func (r *TracedRepository) ListVariants(ctx context.Context, id int) (_ []domain.ImageVariant, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ListVariants")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ListVariants(ctx, id)
+}
+
+// GetVariant implements ImageRepository
+func (r *TracedRepository) GetVariant(ctx context.Context, id int, index int) (_ *domain.ImageVariant, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetVariant")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetVariant(ctx, id, index)
+}
+
+// GetResult implements ImageRepository
+func (r *TracedRepository) GetResult(ctx context.Context, id int) (_ *domain.ImageVariant, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetResult")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetResult(ctx, id)
+}
+
+// DeleteVariant implements ImageRepository
+func (r *TracedRepository) DeleteVariant(ctx context.Context, id int, index int) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.DeleteVariant")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.DeleteVariant(ctx, id, index)
+}
+
+// SelectVariant implements ImageRepository
+func (r *TracedRepository) SelectVariant(ctx context.Context, id int, index int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "repository.SelectVariant")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.SelectVariant(ctx, id, index)
+}
+
+// AppendPromptRevision implements ImageRepository
+func (r *TracedRepository) AppendPromptRevision(ctx context.Context, id int, text string, source domain.PromptSource) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.AppendPromptRevision")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.AppendPromptRevision(ctx, id, text, source)
+}
+
+// GetPromptHistory implements ImageRepository
+func (r *TracedRepository) GetPromptHistory(ctx context.Context, id int) (_ []domain.PromptRevision, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetPromptHistory")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetPromptHistory(ctx, id)
+}
+
+// GetEvents implements ImageRepository
+func (r *TracedRepository) GetEvents(ctx context.Context, imageID int) (_ []domain.ImageEvent, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetEvents")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetEvents(ctx, imageID)
+}
+
+// PruneEvents implements ImageRepository
+func (r *TracedRepository) PruneEvents(ctx context.Context, olderThan time.Duration) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.PruneEvents")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.PruneEvents(ctx, olderThan)
+}
+
+// GetAllReadyToGenerate implements ImageRepository
+func (r *TracedRepository) GetAllReadyToGenerate(ctx context.Context, limit int) (_ []*domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetAllReadyToGenerate")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetAllReadyToGenerate(ctx, limit)
+}
+
+// GetAllReadyToCheck implements ImageRepository
+func (r *TracedRepository) GetAllReadyToCheck(ctx context.Context, limit int) (_ []*domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetAllReadyToCheck")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetAllReadyToCheck(ctx, limit)
+}
+
+// ClaimForGeneration implements ImageRepository
+func (r *TracedRepository) ClaimForGeneration(ctx context.Context, limit int, worker string, lease time.Duration) (_ []*domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ClaimForGeneration")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ClaimForGeneration(ctx, limit, worker, lease)
+}
+
+// ExtendLease implements ImageRepository
+func (r *TracedRepository) ExtendLease(ctx context.Context, id int, worker string, lease time.Duration) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ExtendLease")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ExtendLease(ctx, id, worker, lease)
+}
+
+// ReleaseClaim implements ImageRepository
+func (r *TracedRepository) ReleaseClaim(ctx context.Context, id int, worker string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.ReleaseClaim")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ReleaseClaim(ctx, id, worker)
+}
+
+// ReclaimExpiredLeases implements ImageRepository
+func (r *TracedRepository) ReclaimExpiredLeases(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ReclaimExpiredLeases")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ReclaimExpiredLeases(ctx)
+}
+
+// CreateImage implements ImageRepository
+func (r *TracedRepository) CreateImage(ctx context.Context, prompt string, opts ...CreateOption) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CreateImage")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.CreateImage(ctx, prompt, opts...)
+}
+
+// BulkCreate implements ImageRepository
+func (r *TracedRepository) BulkCreate(ctx context.Context, prompts []string, opts ...CreateOption) (_ []int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.BulkCreate")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.BulkCreate(ctx, prompts, opts...)
+}
+
+// CreateImages implements ImageRepository
+func (r *TracedRepository) CreateImages(ctx context.Context, images []*domain.Image) (_ []int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CreateImages")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.CreateImages(ctx, images)
+}
+
+// CreateFromTemplate implements ImageRepository
+func (r *TracedRepository) CreateFromTemplate(ctx context.Context, tmpl *prompts.PromptTemplate, varSets []map[string]string, opts ...CreateOption) (_ []int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CreateFromTemplate")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.CreateFromTemplate(ctx, tmpl, varSets, opts...)
+}
+
+// UpdatePromptHash implements ImageRepository
+func (r *TracedRepository) UpdatePromptHash(ctx context.Context, id int, hash string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.UpdatePromptHash")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.UpdatePromptHash(ctx, id, hash)
+}
+
+// FindByPromptHash implements ImageRepository
+func (r *TracedRepository) FindByPromptHash(ctx context.Context, hash string, beforeID int) (_ *domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.FindByPromptHash")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.FindByPromptHash(ctx, hash, beforeID)
+}
+
+// FindPromptHashes implements ImageRepository
+func (r *TracedRepository) FindPromptHashes(ctx context.Context, hashes []string) (_ map[string]int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.FindPromptHashes")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.FindPromptHashes(ctx, hashes)
+}
+
+// GetImage implements ImageRepository
+func (r *TracedRepository) GetImage(ctx context.Context, id int) (_ *domain.Image, err error) {
+	ctx, span := tracing.Start(ctx, "repository.GetImage")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.GetImage(ctx, id)
+}
+
+// Requeue implements ImageRepository
+func (r *TracedRepository) Requeue(ctx context.Context, id int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "repository.Requeue")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.Requeue(ctx, id)
+}
+
+// RecordReset implements ImageRepository
+func (r *TracedRepository) RecordReset(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.RecordReset")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.RecordReset(ctx, id)
+}
+
+// MarkFailed implements ImageRepository
+func (r *TracedRepository) MarkFailed(ctx context.Context, id int, errorDescription string, errorClass string) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.MarkFailed")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.MarkFailed(ctx, id, errorDescription, errorClass)
+}
+
+// RequeueFailed implements ImageRepository
+func (r *TracedRepository) RequeueFailed(ctx context.Context, olderThan time.Duration, maxAttempts int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "repository.RequeueFailed")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.RequeueFailed(ctx, olderThan, maxAttempts)
+}
+
+// TryAdvisoryLock implements ImageRepository
+func (r *TracedRepository) TryAdvisoryLock(ctx context.Context, key int64) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "repository.TryAdvisoryLock")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.TryAdvisoryLock(ctx, key)
+}
+
+// AdvisoryUnlock implements ImageRepository
+func (r *TracedRepository) AdvisoryUnlock(ctx context.Context, key int64) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.AdvisoryUnlock")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.AdvisoryUnlock(ctx, key)
+}
+
+// ListenForNewImages implements ImageRepository
+func (r *TracedRepository) ListenForNewImages(ctx context.Context) (_ <-chan struct{}, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ListenForNewImages")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.ListenForNewImages(ctx)
+}
+
+// Ping implements ImageRepository
+func (r *TracedRepository) Ping(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.Ping")
+	defer func() { tracing.End(span, err) }()
+	return r.repo.Ping(ctx)
+}
@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/repository/repositorytest"
+)
+
+func TestTracedConformance(t *testing.T) {
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.ImageRepository {
+		return repository.NewTraced(repository.NewMemoryImageRepository())
+	})
+}
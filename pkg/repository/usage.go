@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// RecordUsage counts a generation request submitted to provider on the UTC day of day, a failed
+// one if failed is set
+func (r *PostgresImageRepository) RecordUsage(ctx context.Context, provider string, day time.Time, failed bool) error {
+	query := `
+		INSERT INTO provider_usage (provider, day, requests, failures)
+		VALUES ($1, $2::date, 1, $3)
+		ON CONFLICT (provider, day) DO UPDATE
+		SET requests = provider_usage.requests + 1,
+			failures = provider_usage.failures + EXCLUDED.failures,
+			updated_at = now()
+	`
+
+	failures := 0
+	if failed {
+		failures = 1
+	}
+	_, err := r.db.ExecContext(ctx, query, provider, domain.UsageDay(day).Format(time.DateOnly), failures)
+	return err
+}
+
+// GetUsage returns the requests submitted to each provider on the UTC day of day, ordered by
+// provider; providers without requests are left out
+func (r *PostgresImageRepository) GetUsage(ctx context.Context, day time.Time) ([]domain.ProviderUsage, error) {
+	query := `
+		SELECT provider, requests, failures
+		FROM provider_usage
+		WHERE day = $1::date
+		ORDER BY provider
+	`
+
+	day = domain.UsageDay(day)
+	rows, err := r.db.QueryContext(ctx, query, day.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []domain.ProviderUsage
+	for rows.Next() {
+		u := domain.ProviderUsage{Day: day}
+		if err := rows.Scan(&u.Provider, &u.Requests, &u.Failures); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// resultChunkSize is how many bytes of a file SaveResultStream encodes and appends per
+// statement; a multiple of 3, so the base64 of consecutive chunks joins without padding
+const resultChunkSize = 3 << 18
+
+// variantColumns are the metadata columns of a variant scanned by scanVariant. The inline file
+// is only loaded by GetVariant and GetResult, which add the data column.
+const variantColumns = `
+	id, image_id, index, COALESCE(provider, ''), COALESCE(file_path, ''),
+	COALESCE(processed_path, ''), COALESCE(width, 0), COALESCE(height, 0), created_at
+`
+
+// scanVariant scans a row of variantColumns, followed by the data column when withData is set
+func scanVariant(row interface{ Scan(...any) error }, withData bool) (domain.ImageVariant, error) {
+	var v domain.ImageVariant
+	var createdAt sql.NullTime
+	dest := []any{&v.ID, &v.ImageID, &v.Index, &v.Provider, &v.FilePath,
+		&v.ProcessedPath, &v.Width, &v.Height, &createdAt}
+	if withData {
+		dest = append(dest, &v.Data)
+	}
+	err := row.Scan(dest...)
+	v.CreatedAt = createdAt.Time
+	return v, err
+}
+
+// SaveVariants stores every file generated for an image, replacing any earlier variants and
+// selecting the first one again
+func (r *PostgresImageRepository) SaveVariants(ctx context.Context, id int, variants []domain.ImageVariant) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM image_results WHERE image_id = $1`, id); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO image_results (image_id, index, provider, data, file_path, processed_path, width, height)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, 0), NULLIF($8, 0))
+	`
+	for _, v := range variants {
+		if _, err := tx.ExecContext(ctx, query, id, v.Index, v.Provider, v.Data, v.FilePath, v.ProcessedPath, v.Width, v.Height); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET selected_variant = 0, updated_at = now() WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveResultStream stores the file read from r as the only variant of an image, replacing any
+// earlier variants and selecting it. The file is base64 encoded into the database in chunks of
+// resultChunkSize, so neither the file nor its encoding is held in memory at once.
+func (r *PostgresImageRepository) SaveResultStream(ctx context.Context, id int, src io.Reader) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM image_results WHERE image_id = $1`, id); err != nil {
+		return err
+	}
+	insert := `
+		INSERT INTO image_results (image_id, index, provider, data)
+		SELECT id, 0, provider, '' FROM images WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, insert, id); err != nil {
+		return err
+	}
+
+	appendChunk := `UPDATE image_results SET data = data || $2 WHERE image_id = $1 AND index = 0`
+	buf := make([]byte, resultChunkSize)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(resultChunkSize))
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			base64.StdEncoding.Encode(encoded, buf[:n])
+			chunk := string(encoded[:base64.StdEncoding.EncodedLen(n)])
+			if _, err := tx.ExecContext(ctx, appendChunk, id, chunk); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read result: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET selected_variant = 0, updated_at = now() WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListVariants retrieves the variants stored for an image in the order they were returned,
+// without their inline data
+func (r *PostgresImageRepository) ListVariants(ctx context.Context, id int) ([]domain.ImageVariant, error) {
+	query := `
+		SELECT ` + variantColumns + `
+		FROM image_results
+		WHERE image_id = $1
+		ORDER BY index ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []domain.ImageVariant
+	for rows.Next() {
+		v, err := scanVariant(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// GetVariant retrieves the variant of an image with the given index including its inline data,
+// or nil if it does not exist
+func (r *PostgresImageRepository) GetVariant(ctx context.Context, id int, index int) (*domain.ImageVariant, error) {
+	query := `
+		SELECT ` + variantColumns + `, COALESCE(data, '')
+		FROM image_results
+		WHERE image_id = $1 AND index = $2
+	`
+
+	v, err := scanVariant(r.db.QueryRowContext(ctx, query, id, index), true)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetResult retrieves the selected variant of an image including its inline data, the first one
+// when the selected variant is gone, or nil when the image has no variants
+func (r *PostgresImageRepository) GetResult(ctx context.Context, id int) (*domain.ImageVariant, error) {
+	query := `
+		SELECT ` + variantColumns + `, COALESCE(data, '')
+		FROM image_results
+		WHERE image_id = $1
+		ORDER BY index = (SELECT selected_variant FROM images WHERE id = $1) DESC, index ASC
+		LIMIT 1
+	`
+
+	v, err := scanVariant(r.db.QueryRowContext(ctx, query, id), true)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// DeleteVariant removes a variant of an image. An image whose selected variant is removed
+// selects its first remaining one.
+func (r *PostgresImageRepository) DeleteVariant(ctx context.Context, id int, index int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM image_results WHERE image_id = $1 AND index = $2`, id, index); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE images
+		SET selected_variant = COALESCE((SELECT MIN(index) FROM image_results WHERE image_id = $1), 0),
+			updated_at = now()
+		WHERE id = $1
+		AND selected_variant = $2
+	`
+	if _, err := tx.ExecContext(ctx, query, id, index); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SelectVariant makes the variant with the given index the one that is published. It reports
+// false when the image has no such variant.
+func (r *PostgresImageRepository) SelectVariant(ctx context.Context, id int, index int) (bool, error) {
+	query := `
+		UPDATE images
+		SET selected_variant = $2, updated_at = now()
+		WHERE id = $1
+		AND EXISTS (SELECT 1 FROM image_results WHERE image_id = $1 AND index = $2)
+	`
+
+	res, err := r.db.ExecContext(ctx, query, id, index)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+// timedProvider records the time of the clock at every status check
+type timedProvider struct {
+	*fakeProvider
+	clock clock.Clock
+
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (p *timedProvider) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	p.mu.Lock()
+	p.times = append(p.times, p.clock.Now())
+	p.mu.Unlock()
+	return p.fakeProvider.CheckGenerationStatus(ctx, uuid)
+}
+
+// checkTimes returns the times of the status checks
+func (p *timedProvider) checkTimes() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Time(nil), p.times...)
+}
+
+func TestWaitForGenerationBacksOff(t *testing.T) {
+	// step is the resolution at which the fake clock moves, and so at which checks are timed
+	const step = 50 * time.Millisecond
+
+	clk := clock.NewFake(epoch)
+	provider := &timedProvider{fakeProvider: newFakeProvider("fake", status("PROCESSING")), clock: clk}
+	svc := service.NewImageGenerationService(provider, testConfig(), service.WithClock(clk))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := svc.WaitForGeneration(context.Background(), "uuid-1")
+		errs <- err
+	}()
+	// The wait holds a timer for GenerationTimeout and one for the delay before the next check
+	for clk.Now().Sub(epoch) < testConfig().GenerationTimeout {
+		clk.BlockUntil(2)
+		clk.Advance(step)
+	}
+
+	// The whole wait is bounded by GenerationTimeout rather than a number of attempts
+	if err := <-errs; !errors.Is(err, domain.ErrGenerationTimeout) {
+		t.Fatalf("WaitForGeneration() error = %v, want %v", err, domain.ErrGenerationTimeout)
+	}
+
+	// The delays grow by half from CheckInterval up to PollMaxInterval, each within the jitter of
+	// ±20%
+	times := provider.checkTimes()
+	if len(times) < 2 || !times[0].Equal(epoch) {
+		t.Fatalf("status checks at %v, want the first right away and more after it", times)
+	}
+	want := []time.Duration{time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond, 3375 * time.Millisecond, 4 * time.Second}
+	for i := 1; i < len(times); i++ {
+		delay := want[min(i-1, len(want)-1)]
+		lo := time.Duration(float64(delay)*0.8) - step
+		hi := time.Duration(float64(delay)*1.2) + step
+		if got := times[i].Sub(times[i-1]); got < lo || got > hi {
+			t.Errorf("delay before check %d = %v, want %v ±20%%", i+1, got, delay)
+		}
+	}
+	// Roughly 60s of delays of about 4s after the first few
+	if n := len(times); n < 14 || n > 22 {
+		t.Errorf("%d status checks within the timeout, want about 17", n)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// ErrBatchFailed is returned by GenerateBatch when every request of the batch failed
+var ErrBatchFailed = errors.New("every request of the batch failed")
+
+// BatchResult is the outcome of a single request of GenerateBatch
+type BatchResult struct {
+	// Index is the position of the request in the batch
+	Index int
+	// UUID identifies the submitted generation, empty if it was not submitted
+	UUID string
+	// Response is the provider response, nil if the request failed before one was received
+	Response *domain.ImageGenerationResponse
+	// Err is why the request failed; censored generations wrap domain.ErrCensored
+	Err error
+}
+
+// GenerateBatch submits every request through GenerateImage with at most Config.Concurrency of
+// them in flight, paced by the rate limiter of the provider. A failed request does not stop the
+// others. The results are in request order; the error is only set when every request failed and
+// then wraps ErrBatchFailed and the error of each request.
+func (s *ImageGenerationService) GenerateBatch(ctx context.Context, reqs []domain.ImageGenerationRequest) ([]BatchResult, error) {
+	results := make([]BatchResult, len(reqs))
+	if len(reqs) == 0 {
+		return results, nil
+	}
+
+	concurrency := s.config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(reqs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results[index] = s.generateBatchItem(ctx, index, reqs[index])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range reqs {
+		select {
+		case <-ctx.Done():
+			// Requests that were never handed out fail with the reason of the cancellation
+			for j := i; j < len(reqs); j++ {
+				results[j] = BatchResult{Index: j, Err: ctx.Err()}
+			}
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	errs := make([]error, 0, len(results))
+	for _, r := range results {
+		if r.Err == nil {
+			return results, nil
+		}
+		errs = append(errs, fmt.Errorf("request %d: %w", r.Index, r.Err))
+	}
+	return results, fmt.Errorf("%w: %w", ErrBatchFailed, errors.Join(errs...))
+}
+
+// generateBatchItem submits the request at index of a batch
+func (s *ImageGenerationService) generateBatchItem(ctx context.Context, index int, req domain.ImageGenerationRequest) BatchResult {
+	result := BatchResult{Index: index}
+
+	resp, err := s.GenerateImage(ctx, req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: %w", domain.ErrGenerationTimeout, err)
+		}
+		result.Err = err
+		return result
+	}
+
+	result.UUID = resp.UUID
+	result.Response = resp
+	if resp.Censored {
+		result.Err = fmt.Errorf("%w: generation %s", domain.ErrCensored, resp.UUID)
+	}
+	return result
+}
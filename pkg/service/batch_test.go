@@ -0,0 +1,164 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+// batchProvider answers each request by its prompt: "censored" is censored right away, "slow"
+// blocks until the request is canceled, "network timeout" fails with a network timeout and
+// anything else is submitted. It records how many requests were in flight at once.
+type batchProvider struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	submits     int
+}
+
+func (p *batchProvider) Name() string { return "batch" }
+
+func (p *batchProvider) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	p.mu.Lock()
+	p.submits++
+	id := p.submits
+	p.inFlight++
+	p.maxInFlight = max(p.maxInFlight, p.inFlight)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	// Like an HTTP client, nothing is sent once the request is canceled
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch req.Prompt {
+	case "censored":
+		return &domain.ImageGenerationResponse{UUID: fmt.Sprintf("uuid-%d", id), Status: "DONE", Censored: true}, nil
+	case "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case "network timeout":
+		return nil, timeoutError{}
+	}
+	// Give the other workers a chance to overlap
+	time.Sleep(5 * time.Millisecond)
+	return &domain.ImageGenerationResponse{UUID: fmt.Sprintf("uuid-%d", id), Status: "INITIAL"}, nil
+}
+
+func (p *batchProvider) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	return nil, errors.New("not used by batches")
+}
+
+// batch returns requests with the given prompts
+func batch(prompts ...string) []domain.ImageGenerationRequest {
+	reqs := make([]domain.ImageGenerationRequest, len(prompts))
+	for i, p := range prompts {
+		reqs[i] = domain.ImageGenerationRequest{Prompt: p}
+	}
+	return reqs
+}
+
+func TestGenerateBatchMixedResults(t *testing.T) {
+	provider := &batchProvider{}
+	cfg := testConfig()
+	cfg.Concurrency = 3
+	svc := service.NewImageGenerationService(provider, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	reqs := batch("a lighthouse", "censored", "slow", "network timeout", "a harbour", "censored", "a meadow", "")
+	results, err := svc.GenerateBatch(ctx, reqs)
+	if err != nil {
+		t.Fatalf("GenerateBatch() error = %v, want nil while some requests succeed", err)
+	}
+	if len(results) != len(reqs) {
+		t.Fatalf("GenerateBatch() returned %d results, want %d", len(results), len(reqs))
+	}
+
+	tests := []struct {
+		index    int
+		wantUUID bool
+		wantErr  error
+	}{
+		{index: 0, wantUUID: true},
+		{index: 1, wantUUID: true, wantErr: domain.ErrCensored},
+		{index: 2, wantErr: domain.ErrGenerationTimeout},
+		{index: 3, wantErr: domain.ErrProviderUnavailable},
+		{index: 4, wantUUID: true},
+		{index: 5, wantUUID: true, wantErr: domain.ErrCensored},
+		{index: 6, wantUUID: true},
+		{index: 7, wantErr: domain.ErrInvalidPrompt},
+	}
+	for _, tt := range tests {
+		r := results[tt.index]
+		if r.Index != tt.index {
+			t.Errorf("results[%d].Index = %d, want the results in request order", tt.index, r.Index)
+		}
+		if (r.UUID != "") != tt.wantUUID || (r.Response != nil) != tt.wantUUID {
+			t.Errorf("results[%d] = UUID %q, response %v, want a submission %v", tt.index, r.UUID, r.Response, tt.wantUUID)
+		}
+		if tt.wantErr == nil && r.Err != nil || tt.wantErr != nil && !errors.Is(r.Err, tt.wantErr) {
+			t.Errorf("results[%d].Err = %v, want %v", tt.index, r.Err, tt.wantErr)
+		}
+	}
+
+	if provider.maxInFlight > 3 {
+		t.Errorf("%d requests were in flight at once, want at most the concurrency of 3", provider.maxInFlight)
+	}
+	// Every valid request reached the provider despite the failures before it
+	if provider.submits != 7 {
+		t.Errorf("provider received %d requests, want 7", provider.submits)
+	}
+}
+
+func TestGenerateBatchAllFailed(t *testing.T) {
+	svc := service.NewImageGenerationService(&batchProvider{}, testConfig())
+
+	results, err := svc.GenerateBatch(context.Background(), batch("censored", "network timeout", ""))
+	if !errors.Is(err, service.ErrBatchFailed) {
+		t.Fatalf("GenerateBatch() error = %v, want ErrBatchFailed", err)
+	}
+	// The summary keeps the error of every request
+	for _, want := range []error{domain.ErrCensored, domain.ErrProviderUnavailable, domain.ErrInvalidPrompt} {
+		if !errors.Is(err, want) {
+			t.Errorf("GenerateBatch() error = %v, want it to wrap %v", err, want)
+		}
+	}
+	if len(results) != 3 || results[0].UUID == "" {
+		t.Errorf("GenerateBatch() = %+v, want a result per request, the censored one with its UUID", results)
+	}
+}
+
+func TestGenerateBatchCanceled(t *testing.T) {
+	provider := &batchProvider{}
+	svc := service.NewImageGenerationService(provider, testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := svc.GenerateBatch(ctx, batch("a lighthouse", "a harbour"))
+	if !errors.Is(err, service.ErrBatchFailed) || !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateBatch() error = %v, want every request canceled", err)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("results[%d].Err = %v, want context.Canceled", r.Index, r.Err)
+		}
+	}
+}
+
+func TestGenerateBatchEmpty(t *testing.T) {
+	results, err := service.NewImageGenerationService(&batchProvider{}, testConfig()).GenerateBatch(context.Background(), nil)
+	if err != nil || len(results) != 0 {
+		t.Errorf("GenerateBatch(nil) = %v, %v, want no results and no error", results, err)
+	}
+}
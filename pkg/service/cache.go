@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// cacheKey identifies a request by every parameter that influences the generated image. It is
+// computed before the prompt processors run, from the parameters stored with an image, so that
+// a generation finished in a later pass or another process is cached under the same key.
+func cacheKey(req domain.ImageGenerationRequest) string {
+	key := fmt.Sprintf("%s\n%dx%d\n%d\n%s\n%s\n%d",
+		domain.NormalizePrompt(req.Prompt), req.Width, req.Height, req.NumImages, req.Style, req.NegativePrompt, req.Seed)
+	if len(req.Extra) > 0 {
+		// Maps are marshaled with sorted keys, so equal parameters give equal keys
+		extra, _ := json.Marshal(req.Extra)
+		key += "\n" + string(extra)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultCacheMaxEntries is the number of responses a MemoryCache holds unless configured
+// otherwise
+const DefaultCacheMaxEntries = 1000
+
+// MemoryCache is an in-process domain.ResultCache holding up to a maximum number of entries
+type MemoryCache struct {
+	clock      clock.Clock
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	resp      domain.ImageGenerationResponse
+	expiresAt time.Time
+}
+
+// MemoryCacheOption configures optional MemoryCache settings
+type MemoryCacheOption func(*MemoryCache)
+
+// WithCacheClock expires entries on the given clock
+func WithCacheClock(c clock.Clock) MemoryCacheOption {
+	return func(m *MemoryCache) {
+		m.clock = c
+	}
+}
+
+// WithMaxEntries caps the number of entries; when the cache is full, storing a response evicts
+// the expired entries, or else the one closest to expiry
+func WithMaxEntries(n int) MemoryCacheOption {
+	return func(m *MemoryCache) {
+		if n > 0 {
+			m.maxEntries = n
+		}
+	}
+}
+
+// NewMemoryCache creates an empty in-memory result cache
+func NewMemoryCache(opts ...MemoryCacheOption) *MemoryCache {
+	c := &MemoryCache{
+		clock:      clock.Real(),
+		maxEntries: DefaultCacheMaxEntries,
+		entries:    make(map[string]memoryCacheEntry),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get returns the cached response for key, or nil if there is none or it expired
+func (c *MemoryCache) Get(ctx context.Context, key string) (*domain.ImageGenerationResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !c.clock.Now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, nil
+	}
+
+	resp := entry.resp
+	return &resp, nil
+}
+
+// Set stores the response for key for the given time to live
+func (c *MemoryCache) Set(ctx context.Context, key string, resp *domain.ImageGenerationResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		if c.evictExpired(now) == 0 {
+			c.evictSoonest()
+		}
+	}
+	c.entries[key] = memoryCacheEntry{
+		resp:      *resp,
+		expiresAt: now.Add(ttl),
+	}
+	return nil
+}
+
+// EvictExpired removes the expired entries and returns how many there were
+func (c *MemoryCache) EvictExpired(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictExpired(c.clock.Now()), nil
+}
+
+// Len returns the number of entries, including expired ones not evicted yet
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// evictExpired removes the entries expired at now; c.mu must be held
+func (c *MemoryCache) evictExpired(now time.Time) int {
+	n := 0
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// evictSoonest removes the entry closest to expiry; c.mu must be held
+func (c *MemoryCache) evictSoonest() {
+	var soonest string
+	var expiresAt time.Time
+	for key, entry := range c.entries {
+		if soonest == "" || entry.expiresAt.Before(expiresAt) {
+			soonest, expiresAt = key, entry.expiresAt
+		}
+	}
+	delete(c.entries, soonest)
+}
+
+// RunCacheEviction removes the expired entries of cache every interval on clk until ctx is
+// cancelled, so that entries that are never read again don't pile up
+func RunCacheEviction(ctx context.Context, cache domain.ResultCache, clk clock.Clock, interval time.Duration) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			// A failed eviction is retried on the next tick
+			_, _ = cache.EvictExpired(ctx)
+		}
+	}
+}
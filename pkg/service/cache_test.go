@@ -0,0 +1,220 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+// suffixProcessor is a prompt processor appending itself to every prompt
+type suffixProcessor string
+
+func (p suffixProcessor) Process(ctx context.Context, prompt string) (string, error) {
+	return prompt + string(p), nil
+}
+
+// cached reports whether cache holds a response for key
+func cached(t *testing.T, cache *service.MemoryCache, key string) bool {
+	t.Helper()
+	resp, err := cache.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	return resp != nil
+}
+
+// set stores a finished response for key
+func set(t *testing.T, cache *service.MemoryCache, key string, ttl time.Duration) {
+	t.Helper()
+	if err := cache.Set(context.Background(), key, &domain.ImageGenerationResponse{UUID: key, Status: "DONE"}, ttl); err != nil {
+		t.Fatalf("Set(%q) error = %v", key, err)
+	}
+}
+
+func TestMemoryCacheExpiresOnItsClock(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	cache := service.NewMemoryCache(service.WithCacheClock(clk))
+	set(t, cache, "a", time.Minute)
+
+	clk.Advance(time.Minute - time.Second)
+	if !cached(t, cache, "a") {
+		t.Fatal("entry expired before its time to live")
+	}
+	clk.Advance(time.Second)
+	if cached(t, cache, "a") {
+		t.Error("entry outlived its time to live")
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %d after reading the expired entry, want 0", n)
+	}
+}
+
+func TestMemoryCacheEvictExpired(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	cache := service.NewMemoryCache(service.WithCacheClock(clk))
+	set(t, cache, "short", time.Minute)
+	set(t, cache, "long", time.Hour)
+
+	clk.Advance(time.Minute)
+	n, err := cache.EvictExpired(context.Background())
+	if err != nil {
+		t.Fatalf("EvictExpired() error = %v", err)
+	}
+	// The expired entry goes without ever being read
+	if n != 1 || cache.Len() != 1 || !cached(t, cache, "long") {
+		t.Errorf("EvictExpired() = %d leaving %d entries, want only the long-lived entry left", n, cache.Len())
+	}
+}
+
+func TestMemoryCacheMaxEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    []string
+		evicted string
+	}{
+		// A full cache makes room by evicting the entry closest to expiry
+		{name: "evicts the soonest expiring", want: []string{"b", "c", "new"}, evicted: "a"},
+		// Expired entries go first, however long the others live
+		{name: "evicts expired entries", advance: 2 * time.Minute, want: []string{"c", "new"}, evicted: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			cache := service.NewMemoryCache(service.WithCacheClock(clk), service.WithMaxEntries(3))
+			set(t, cache, "b", 2*time.Minute)
+			set(t, cache, "a", time.Minute)
+			set(t, cache, "c", time.Hour)
+
+			clk.Advance(tt.advance)
+			set(t, cache, "new", time.Minute)
+
+			for _, key := range tt.want {
+				if !cached(t, cache, key) {
+					t.Errorf("entry %q was evicted", key)
+				}
+			}
+			if cached(t, cache, tt.evicted) {
+				t.Errorf("entry %q was kept", tt.evicted)
+			}
+			if n := cache.Len(); n > 3 {
+				t.Errorf("Len() = %d, want at most 3", n)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheReplacingKeepsOtherEntries(t *testing.T) {
+	cache := service.NewMemoryCache(service.WithCacheClock(clock.NewFake(epoch)), service.WithMaxEntries(2))
+	set(t, cache, "a", time.Minute)
+	set(t, cache, "b", time.Hour)
+	set(t, cache, "a", time.Hour)
+
+	if !cached(t, cache, "a") || !cached(t, cache, "b") {
+		t.Error("replacing an entry of a full cache evicted another one")
+	}
+}
+
+func TestRunCacheEviction(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	cache := service.NewMemoryCache(service.WithCacheClock(clk))
+	set(t, cache, "a", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		service.RunCacheEviction(ctx, cache, clk, time.Minute)
+	}()
+
+	// The ticker fires once the entry expired, and the entry goes without being read
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for cache.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %d after an eviction interval, want 0", n)
+	}
+
+	cancel()
+	<-stopped
+}
+
+func TestCacheResultOfPendingGeneration(t *testing.T) {
+	cache := service.NewMemoryCache(service.WithCacheClock(clock.NewFake(epoch)))
+	provider := newFakeProvider("fake", done())
+	newService := func() *service.ImageGenerationService {
+		return service.NewImageGenerationService(provider, testConfig(),
+			service.WithClock(clock.NewFake(epoch)),
+			service.WithCache(cache, time.Hour),
+			service.WithPromptProcessors(suffixProcessor(", highly detailed")),
+		)
+	}
+	req := domain.ImageGenerationRequest{Prompt: "a lighthouse"}
+
+	svc := newService()
+	resp, err := svc.GenerateImage(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	status, err := svc.CheckGenerationStatus(context.Background(), resp.UUID)
+	if err != nil {
+		t.Fatalf("CheckGenerationStatus() error = %v", err)
+	}
+	// The service keeps nothing about the generation it submitted, so a status check caches nothing
+	if cache.Len() != 0 {
+		t.Fatalf("cache holds %d entries after the status check, want none", cache.Len())
+	}
+
+	// Another process caches the result under the request the image holds, before the processor
+	// rewrote its prompt
+	newService().CacheResult(context.Background(), req, status)
+	again, err := newService().GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: " a  lighthouse", Width: 1024})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if again.Status != "DONE" || again.UUID != resp.UUID {
+		t.Errorf("GenerateImage() = %+v, want the cached result of %s", again, resp.UUID)
+	}
+	if n := len(provider.submitted()); n != 1 {
+		t.Errorf("provider received %d requests, want 1", n)
+	}
+}
+
+func TestCacheResultSkipsUncacheableResults(t *testing.T) {
+	tests := []struct {
+		name string
+		resp domain.ImageGenerationResponse
+	}{
+		{name: "censored", resp: domain.ImageGenerationResponse{Status: "DONE", Files: []string{pixel}, Censored: true}},
+		{name: "no files", resp: domain.ImageGenerationResponse{Status: "DONE"}},
+		{name: "unfinished", resp: domain.ImageGenerationResponse{Status: "PROCESSING", Files: []string{pixel}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := service.NewMemoryCache()
+			svc := service.NewImageGenerationService(newFakeProvider("fake"), testConfig(), service.WithCache(cache, time.Hour))
+			svc.CacheResult(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse"}, &tt.resp)
+			if n := cache.Len(); n != 0 {
+				t.Errorf("cache holds %d entries, want none", n)
+			}
+		})
+	}
+}
+
+func TestGenerateAndWaitCachesResult(t *testing.T) {
+	cache := service.NewMemoryCache()
+	provider := newFakeProvider("fake", status("PROCESSING"), done())
+	if _, _, err := generateAndWait(t, provider, 1, service.WithCache(cache, time.Hour)); err != nil {
+		t.Fatalf("GenerateAndWait() error = %v", err)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("cache holds %d entries after the generation finished, want 1", n)
+	}
+}
@@ -0,0 +1,20 @@
+// Package service generates images through any domain.ImageProvider, filling in request
+// defaults, rewriting prompts, caching results and waiting for generations to finish:
+//
+//	svc := service.NewImageGenerationService(fusionbrain.NewClient(apiKey, secretKey), service.Config{
+//		DefaultImageWidth:  1024,
+//		DefaultImageHeight: 1024,
+//		DefaultNumImages:   1,
+//		MaxPromptLength:    1000,
+//		CheckInterval:      2 * time.Second,
+//		PollMaxInterval:    30 * time.Second,
+//		GenerationTimeout:  5 * time.Minute,
+//	})
+//	result, err := svc.GenerateAndWait(ctx, domain.ImageGenerationRequest{Prompt: "a lighthouse at dawn"})
+//	if err != nil {
+//		return err
+//	}
+//	os.WriteFile("lighthouse.png", result.Data, 0o644)
+//
+// Several providers are tried in order by wrapping them in a FallbackProvider.
+package service
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// DryRunProviderName identifies the DryRunProvider
+const DryRunProviderName = "dry-run"
+
+// DryRunProvider logs the requests it receives instead of sending them anywhere. Submissions
+// are accepted under a made-up UUID and never finish.
+type DryRunProvider struct {
+	logger *slog.Logger
+	next   atomic.Int64
+}
+
+// NewDryRunProvider creates a DryRunProvider logging to logger
+func NewDryRunProvider(logger *slog.Logger) *DryRunProvider {
+	return &DryRunProvider{logger: logger}
+}
+
+// Name returns DryRunProviderName
+func (p *DryRunProvider) Name() string {
+	return DryRunProviderName
+}
+
+// GenerateImage logs req as it would have been submitted
+func (p *DryRunProvider) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	uuid := fmt.Sprintf("dry-run-%d", p.next.Add(1))
+	p.logger.Info("Dry run: would submit request",
+		"uuid", uuid,
+		"prompt", req.Prompt,
+		"negative_prompt", req.NegativePrompt,
+		"style", req.Style,
+		"width", req.Width,
+		"height", req.Height,
+		"num_images", req.NumImages,
+		"seed", req.Seed,
+	)
+	return &domain.ImageGenerationResponse{UUID: uuid, Status: "INITIAL", Provider: DryRunProviderName}, nil
+}
+
+// CheckGenerationStatus logs the check and reports domain.DryRunStatus
+func (p *DryRunProvider) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	p.logger.Info("Dry run: would check generation status", "uuid", uuid)
+	return &domain.ImageGenerationResponse{UUID: uuid, Status: domain.DryRunStatus, Provider: DryRunProviderName}, nil
+}
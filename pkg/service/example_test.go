@@ -0,0 +1,40 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+func ExampleImageGenerationService_GenerateAndWait() {
+	// A provider whose generation is still processing at the first poll and done at the second
+	provider := newFakeProvider("fake", status("PROCESSING"), done())
+	svc := service.NewImageGenerationService(provider, service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      10 * time.Millisecond,
+		PollMaxInterval:    50 * time.Millisecond,
+		GenerationTimeout:  time.Minute,
+	})
+
+	result, err := svc.GenerateAndWait(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse at dawn"})
+	switch {
+	case errors.Is(err, domain.ErrGenerationTimeout):
+		fmt.Println("timed out")
+	case errors.Is(err, domain.ErrCensored):
+		fmt.Println("censored")
+	case errors.Is(err, domain.ErrGenerationFailed):
+		fmt.Println("failed")
+	case err != nil:
+		fmt.Println("error:", err)
+	default:
+		fmt.Printf("%s from %s: %d bytes of PNG after %d polls\n", result.UUID, result.Provider, len(result.Data), provider.statusChecks())
+	}
+	// Output: uuid-1 from fake: 75 bytes of PNG after 2 polls
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// FallbackProvider tries an ordered chain of providers, advancing to the next one when a
+// provider censors the prompt or is unavailable. It keeps no state: the response names the
+// provider that handled the request, which the caller stores with the image and passes back
+// with domain.ContextWithProvider to route its status checks, or to resubmit a generation that
+// was censored or failed later to the next provider.
+type FallbackProvider struct {
+	providers []domain.ImageProvider
+}
+
+// NewFallbackProvider creates a provider chain tried in the given order
+func NewFallbackProvider(providers ...domain.ImageProvider) *FallbackProvider {
+	return &FallbackProvider{providers: providers}
+}
+
+// Name returns the provider name
+func (f *FallbackProvider) Name() string {
+	return "fallback"
+}
+
+// Providers returns the providers of the chain in the order they are tried
+func (f *FallbackProvider) Providers() []domain.ImageProvider {
+	return append([]domain.ImageProvider(nil), f.providers...)
+}
+
+// NextProvider returns the provider tried after the named one, if there is one
+func (f *FallbackProvider) NextProvider(name string) (string, bool) {
+	i := f.index(name)
+	if i < 0 || i+1 >= len(f.providers) {
+		return "", false
+	}
+	return f.providers[i+1].Name(), true
+}
+
+// GenerateImage submits the request to the first provider that accepts it, starting at the
+// provider of ctx, if any. A provider that censors the prompt right away, or is unavailable, is
+// skipped; any other error is returned, since the next provider would fare no better or the
+// request is retried anyway.
+func (f *FallbackProvider) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	start := max(f.index(domain.ProviderFromContext(ctx)), 0)
+
+	var lastErr error
+	for i := start; i < len(f.providers); i++ {
+		provider := f.providers[i]
+		last := i+1 == len(f.providers)
+
+		resp, err := provider.GenerateImage(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", provider.Name(), err)
+			if ctx.Err() != nil || !fallsBack(err) || last {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+
+		resp.Provider = provider.Name()
+		if resp.Censored && !last {
+			lastErr = fmt.Errorf("%s: %w", provider.Name(), domain.ErrCensored)
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("all providers failed: %w", lastErr)
+}
+
+// CheckGenerationStatus checks the status with the provider of ctx. Without one, e.g. for
+// images submitted before the provider was stored, every provider is asked in turn.
+func (f *FallbackProvider) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	i := f.index(domain.ProviderFromContext(ctx))
+	if i < 0 {
+		return f.probe(ctx, uuid)
+	}
+
+	provider := f.providers[i]
+	resp, err := provider.CheckGenerationStatus(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	resp.Provider = provider.Name()
+	return resp, nil
+}
+
+// index returns the position of the named provider in the chain, or -1
+func (f *FallbackProvider) index(name string) int {
+	for i, provider := range f.providers {
+		if name != "" && provider.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// probe asks every provider for an unknown UUID, skipping those that don't know it
+func (f *FallbackProvider) probe(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	var lastErr error
+	for _, provider := range f.providers {
+		resp, err := provider.CheckGenerationStatus(ctx, uuid)
+		if err != nil {
+			if errclass.IsNotFound(err) {
+				lastErr = err
+				continue
+			}
+			return nil, err
+		}
+
+		resp.Provider = provider.Name()
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// fallsBack reports whether a provider failed to accept a request in a way the next provider
+// may not: it censored the prompt, or is unavailable with a 5xx status or a network failure.
+// Rate limits, timeouts and invalid requests don't fall back.
+func fallsBack(err error) bool {
+	if errclass.IsCensored(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(translateError(err), domain.ErrProviderUnavailable)
+}
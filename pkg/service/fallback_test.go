@@ -0,0 +1,145 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+func TestFallbackProviderFallsBackOnPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantFallback bool
+	}{
+		{name: "censored", err: domain.ErrCensored, wantFallback: true},
+		{name: "503", err: statusError(http.StatusServiceUnavailable), wantFallback: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, wantFallback: true},
+		{name: "429", err: statusError(http.StatusTooManyRequests)},
+		{name: "400", err: statusError(http.StatusBadRequest)},
+		{name: "401", err: statusError(http.StatusUnauthorized)},
+		{name: "network timeout", err: &net.OpError{Op: "read", Err: timeoutError{}}},
+		{name: "deadline exceeded", err: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := testsupport.NewFakeProvider("first")
+			first.Default = testsupport.SubmitError(tt.err)
+			second := testsupport.NewFakeProvider("second")
+			chain := service.NewFallbackProvider(first, second)
+
+			resp, err := chain.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"})
+			if tt.wantFallback {
+				if err != nil || resp.Provider != "second" {
+					t.Fatalf("GenerateImage() = %+v, %v, want the response of second", resp, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("GenerateImage() error = %v, want it to wrap %v", err, tt.err)
+			}
+			if calls := len(second.Calls()); calls != 0 {
+				t.Errorf("second provider received %d calls, want none", calls)
+			}
+		})
+	}
+}
+
+func TestFallbackProviderSkipsSynchronousCensoring(t *testing.T) {
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.Censored()
+	second := testsupport.NewFakeProvider("second")
+	chain := service.NewFallbackProvider(first, second)
+
+	resp, err := chain.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if resp.Provider != "second" || resp.Censored {
+		t.Errorf("GenerateImage() = %+v, want the uncensored result of second", resp)
+	}
+
+	// The last provider's verdict stands
+	second.Default = testsupport.Censored()
+	resp, err = chain.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"})
+	if err != nil || resp.Provider != "second" || !resp.Censored {
+		t.Errorf("GenerateImage() = %+v, %v, want the censored result of second", resp, err)
+	}
+}
+
+func TestFallbackProviderAllFail(t *testing.T) {
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.SubmitError(domain.ErrProviderUnavailable)
+	second := testsupport.NewFakeProvider("second")
+	second.Default = testsupport.SubmitError(domain.ErrProviderUnavailable)
+
+	_, err := service.NewFallbackProvider(first, second).GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"})
+	if !errors.Is(err, domain.ErrProviderUnavailable) {
+		t.Errorf("GenerateImage() error = %v, want %v", err, domain.ErrProviderUnavailable)
+	}
+}
+
+func TestFallbackProviderRoutesByContext(t *testing.T) {
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.Failed("checked with the wrong provider")
+	second := testsupport.NewFakeProvider("second")
+	second.Default = testsupport.DoneAfter(1)
+	chain := service.NewFallbackProvider(first, second)
+
+	// A generation is submitted to the provider of the context first
+	ctx := domain.ContextWithProvider(context.Background(), "second")
+	resp, err := chain.GenerateImage(ctx, domain.ImageGenerationRequest{Prompt: "p"})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if resp.Provider != "second" || len(first.Calls()) != 0 {
+		t.Fatalf("GenerateImage() = %+v with %d calls to first, want it submitted to second only", resp, len(first.Calls()))
+	}
+
+	// Its status is checked with the same provider, although both know the UUID
+	if _, err := first.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"}); err != nil {
+		t.Fatalf("first GenerateImage() error = %v", err)
+	}
+	status, err := chain.CheckGenerationStatus(ctx, resp.UUID)
+	if err != nil {
+		t.Fatalf("CheckGenerationStatus() error = %v", err)
+	}
+	if status.Provider != "second" || status.Status != "PROCESSING" {
+		t.Errorf("CheckGenerationStatus() = %+v, want the PROCESSING status of second", status)
+	}
+}
+
+func TestFallbackProviderNextProvider(t *testing.T) {
+	chain := service.NewFallbackProvider(testsupport.NewFakeProvider("first"), testsupport.NewFakeProvider("second"))
+	tests := []struct {
+		name     string
+		wantNext string
+		wantOK   bool
+	}{
+		{name: "first", wantNext: "second", wantOK: true},
+		{name: "second"},
+		{name: "unknown"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		if next, ok := chain.NextProvider(tt.name); next != tt.wantNext || ok != tt.wantOK {
+			t.Errorf("NextProvider(%q) = %q, %v, want %q, %v", tt.name, next, ok, tt.wantNext, tt.wantOK)
+		}
+	}
+
+	// The service exposes the chain of its provider, and no chain for a single provider
+	svc := service.NewImageGenerationService(chain, testConfig())
+	if next, ok := svc.NextProvider("first"); next != "second" || !ok {
+		t.Errorf("service NextProvider(first) = %q, %v, want second", next, ok)
+	}
+	single := service.NewImageGenerationService(testsupport.NewFakeProvider("first"), testConfig())
+	if _, ok := single.NextProvider("first"); ok {
+		t.Error("service of a single provider reports a next provider")
+	}
+}
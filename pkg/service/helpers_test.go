@@ -0,0 +1,94 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+)
+
+// pixel is the 1x1 PNG of the fake server as base64, the file of every finished generation
+var pixel = fakeserver.Pixel
+
+// fakeProvider is a domain.ImageProvider whose generations report the scripted statuses in
+// order, the last one repeating
+type fakeProvider struct {
+	name string
+
+	mu       sync.Mutex
+	statuses []domain.ImageGenerationResponse
+	submits  []domain.ImageGenerationRequest
+	checks   int
+	err      error
+	next     int
+}
+
+// newFakeProvider returns a provider reporting statuses for every status check
+func newFakeProvider(name string, statuses ...domain.ImageGenerationResponse) *fakeProvider {
+	return &fakeProvider{name: name, statuses: statuses}
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.submits = append(p.submits, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.next++
+	return &domain.ImageGenerationResponse{UUID: fmt.Sprintf("uuid-%d", p.next), Status: "INITIAL"}, nil
+}
+
+func (p *fakeProvider) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.statuses) == 0 {
+		return nil, fmt.Errorf("no status scripted for %s", uuid)
+	}
+	resp := p.statuses[min(p.checks, len(p.statuses)-1)]
+	p.checks++
+	resp.UUID = uuid
+	return &resp, nil
+}
+
+// submitted returns the requests the provider received
+func (p *fakeProvider) submitted() []domain.ImageGenerationRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]domain.ImageGenerationRequest(nil), p.submits...)
+}
+
+// statusChecks returns how many status checks the provider answered
+func (p *fakeProvider) statusChecks() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checks
+}
+
+// status is a status response of a generation
+func status(s string) domain.ImageGenerationResponse {
+	return domain.ImageGenerationResponse{Status: s}
+}
+
+// done is the status response of a generation that finished with the pixel
+func done() domain.ImageGenerationResponse {
+	return domain.ImageGenerationResponse{Status: "DONE", Files: []string{pixel}}
+}
+
+// statusError is a provider error carrying an HTTP status, like the API errors of the clients
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("unexpected status code: %d", int(e)) }
+func (e statusError) HTTPStatus() int { return int(e) }
+func (e statusError) Unwrap() error   { return domain.ErrorForStatus(int(e)) }
+
+// timeoutError is a network timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
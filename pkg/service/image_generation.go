@@ -0,0 +1,398 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/backoff"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+)
+
+// Config holds the request defaults and timing of an ImageGenerationService
+type Config struct {
+	// DefaultImageWidth, DefaultImageHeight and DefaultNumImages fill in requests that leave
+	// them unset
+	DefaultImageWidth  int
+	DefaultImageHeight int
+	DefaultNumImages   int
+	// SnapDimensions rounds invalid sizes to the nearest supported one instead of rejecting them
+	SnapDimensions  bool
+	MaxPromptLength int
+	// CheckInterval is the first wait between status checks of GenerateAndWait and bounds a
+	// single check, growing by half with every check up to PollMaxInterval
+	CheckInterval     time.Duration
+	PollMaxInterval   time.Duration
+	GenerationTimeout time.Duration
+	// Concurrency is the number of requests GenerateBatch submits in parallel
+	Concurrency int
+}
+
+// ImageGenerationService implements the domain.ImageGenerationService interface
+type ImageGenerationService struct {
+	provider   domain.ImageProvider
+	config     Config
+	metrics    metrics.Hook
+	processors []domain.PromptProcessor
+	clock      clock.Clock
+	usage      domain.UsageRecorder
+
+	cache    domain.ResultCache
+	cacheTTL time.Duration
+}
+
+// Option configures optional ImageGenerationService settings
+type Option func(*ImageGenerationService)
+
+// WithCache serves repeated requests from the cache and stores finished, uncensored results in
+// it: those returned by GenerateImage or GenerateAndWait, and those passed to CacheResult
+func WithCache(cache domain.ResultCache, ttl time.Duration) Option {
+	return func(s *ImageGenerationService) {
+		s.cache = cache
+		s.cacheTTL = ttl
+	}
+}
+
+// WithMetrics reports instrumentation events to the given hook
+func WithMetrics(hook metrics.Hook) Option {
+	return func(s *ImageGenerationService) {
+		s.metrics = hook
+	}
+}
+
+// WithClock measures the polling delays, timeouts and generation times on the given clock
+func WithClock(c clock.Clock) Option {
+	return func(s *ImageGenerationService) {
+		s.clock = c
+	}
+}
+
+// WithUsageRecorder counts every request submitted to the provider, accepted or not, on the
+// UTC day of the clock; requests served from the cache are not counted
+func WithUsageRecorder(usage domain.UsageRecorder) Option {
+	return func(s *ImageGenerationService) {
+		s.usage = usage
+	}
+}
+
+// WithPromptProcessors rewrites every prompt with the given processors, applied in order
+func WithPromptProcessors(processors ...domain.PromptProcessor) Option {
+	return func(s *ImageGenerationService) {
+		s.processors = append(s.processors, processors...)
+	}
+}
+
+// NewImageGenerationService creates a new image generation service backed by the given provider
+func NewImageGenerationService(provider domain.ImageProvider, cfg Config, opts ...Option) *ImageGenerationService {
+	s := &ImageGenerationService{
+		provider: provider,
+		config:   cfg,
+		metrics:  metrics.Nop{},
+		clock:    clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// GenerateImage implements the image generation request
+func (s *ImageGenerationService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (resp *domain.ImageGenerationResponse, err error) {
+	ctx, span := tracing.Start(ctx, "service.GenerateImage")
+	defer func() { tracing.End(span, err) }()
+
+	req = s.withDefaults(req)
+	if err = req.Validate(domain.WithMaxPromptLength(s.config.MaxPromptLength)); err != nil {
+		return nil, err
+	}
+
+	// The key is computed before the processors rewrite the prompt, so that CacheResult gets to
+	// it from the request alone
+	key := cacheKey(req)
+	if cached := s.cachedResult(ctx, key); cached != nil {
+		return cached, nil
+	}
+
+	for _, p := range s.processors {
+		prompt, err := p.Process(ctx, req.Prompt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process prompt: %w", err)
+		}
+		req.Prompt = prompt
+	}
+	// A template can push the prompt past the length it was validated at
+	if len(s.processors) > 0 {
+		if err = req.Validate(domain.WithMaxPromptLength(s.config.MaxPromptLength)); err != nil {
+			return nil, fmt.Errorf("processed prompt: %w", err)
+		}
+	}
+
+	// Generate the image
+	submittedAt := s.clock.Now()
+	resp, err = s.provider.GenerateImage(ctx, req)
+	s.recordUsage(ctx, resp, err, submittedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate image: %w", translateError(err))
+	}
+	if resp.Provider == "" {
+		resp.Provider = s.provider.Name()
+	}
+	resp.Prompt = req.Prompt
+	s.setTiming(resp, submittedAt)
+
+	if resp.Status == "DONE" {
+		s.storeResult(ctx, key, resp)
+	}
+
+	return resp, nil
+}
+
+// CacheResult stores resp, a finished generation of req, in the cache, unless caching is
+// disabled or the result is censored or empty. req is the request as passed to GenerateImage.
+func (s *ImageGenerationService) CacheResult(ctx context.Context, req domain.ImageGenerationRequest, resp *domain.ImageGenerationResponse) {
+	if resp.Status != "DONE" {
+		return
+	}
+	s.storeResult(ctx, cacheKey(s.withDefaults(req)), resp)
+}
+
+// withDefaults fills in the size and number of images req leaves unset
+func (s *ImageGenerationService) withDefaults(req domain.ImageGenerationRequest) domain.ImageGenerationRequest {
+	if req.Width == 0 {
+		req.Width = s.config.DefaultImageWidth
+	}
+	if req.Height == 0 {
+		req.Height = s.config.DefaultImageHeight
+	}
+	if req.NumImages == 0 {
+		req.NumImages = s.config.DefaultNumImages
+	}
+	if s.config.SnapDimensions {
+		req.Width = domain.SnapDimension(req.Width)
+		req.Height = domain.SnapDimension(req.Height)
+	}
+	return req
+}
+
+// Provider returns the provider the service submits to, a FallbackProvider with several
+func (s *ImageGenerationService) Provider() domain.ImageProvider {
+	return s.provider
+}
+
+// NextProvider returns the provider tried after the named one when the provider is a chain
+func (s *ImageGenerationService) NextProvider(name string) (string, bool) {
+	if chain, ok := s.provider.(domain.ProviderChain); ok {
+		return chain.NextProvider(name)
+	}
+	return "", false
+}
+
+// recordUsage counts a request submitted at submittedAt under the provider that handled it
+func (s *ImageGenerationService) recordUsage(ctx context.Context, resp *domain.ImageGenerationResponse, err error, submittedAt time.Time) {
+	if s.usage == nil {
+		return
+	}
+	provider := s.provider.Name()
+	if err == nil && resp.Provider != "" {
+		provider = resp.Provider
+	}
+	// A failed write only loses a count, so it does not fail the request
+	_ = s.usage.RecordUsage(ctx, provider, submittedAt, err != nil)
+}
+
+// CheckGenerationStatus checks the status of an image generation request
+func (s *ImageGenerationService) CheckGenerationStatus(ctx context.Context, uuid string) (resp *domain.ImageGenerationResponse, err error) {
+	ctx, span := tracing.Start(ctx, "service.CheckGenerationStatus")
+	defer func() { tracing.End(span, err) }()
+
+	// Add timeout to context
+	ctx, cancel := context.WithTimeout(ctx, s.config.CheckInterval)
+	defer cancel()
+
+	resp, err = s.provider.CheckGenerationStatus(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check generation status: %w", translateError(err))
+	}
+	if resp.Provider == "" {
+		resp.Provider = s.provider.Name()
+	}
+	s.setTiming(resp, time.Time{})
+
+	return resp, nil
+}
+
+// translateError maps provider errors that match none of the errors of the domain yet onto
+// them, keeping the original: network failures become domain.ErrProviderUnavailable
+func translateError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, domain.ErrProviderUnavailable) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", domain.ErrProviderUnavailable, err)
+	}
+	return err
+}
+
+// cachedResult returns the cached response for key, or nil on a miss or when caching is disabled
+func (s *ImageGenerationService) cachedResult(ctx context.Context, key string) *domain.ImageGenerationResponse {
+	if s.cache == nil {
+		return nil
+	}
+
+	cached, err := s.cache.Get(ctx, key)
+	if err != nil || cached == nil {
+		s.metrics.IncCounter(metrics.CacheMisses, nil)
+		return nil
+	}
+
+	s.metrics.IncCounter(metrics.CacheHits, nil)
+	return cached
+}
+
+// setTiming fills in the timing the provider did not report: SubmittedAt from submittedAt, which
+// may be zero if unknown, and for finished generations CompletedAt and GenerationTime
+func (s *ImageGenerationService) setTiming(resp *domain.ImageGenerationResponse, submittedAt time.Time) {
+	if resp.SubmittedAt.IsZero() {
+		resp.SubmittedAt = submittedAt
+	}
+	if resp.Status != "DONE" {
+		return
+	}
+	if resp.CompletedAt.IsZero() {
+		resp.CompletedAt = s.clock.Now()
+	}
+	if resp.GenerationTime == 0 && !resp.SubmittedAt.IsZero() {
+		resp.GenerationTime = resp.CompletedAt.Sub(resp.SubmittedAt)
+	}
+}
+
+// storeResult caches a finished generation; censored or empty results are never cached
+func (s *ImageGenerationService) storeResult(ctx context.Context, key string, resp *domain.ImageGenerationResponse) {
+	if s.cache == nil || resp.Censored || len(resp.Files) == 0 {
+		return
+	}
+	// A failed cache write only costs a future regeneration
+	_ = s.cache.Set(ctx, key, resp, s.cacheTTL)
+}
+
+// maxUnknownStatuses is how many consecutive unrecognized statuses WaitForGeneration tolerates,
+// since the API occasionally reports transient values
+const maxUnknownStatuses = 3
+
+// WaitForGeneration waits for the image generation to complete.
+// Polling starts at CheckInterval and backs off exponentially up to PollMaxInterval;
+// the whole wait is bounded by GenerationTimeout, after which the error wraps
+// domain.ErrGenerationTimeout; a generation the provider failed is a *domain.GenerationFailedError
+// matching domain.ErrGenerationFailed.
+// Unless the provider reports when the generation was submitted, its GenerationTime is measured
+// from the start of the wait.
+func (s *ImageGenerationService) WaitForGeneration(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	return s.waitForGeneration(ctx, uuid, s.clock.Now())
+}
+
+// waitForGeneration implements WaitForGeneration for a generation submitted at submittedAt
+func (s *ImageGenerationService) waitForGeneration(ctx context.Context, uuid string, submittedAt time.Time) (*domain.ImageGenerationResponse, error) {
+	ctx, cancel := clock.WithTimeout(ctx, s.clock, s.config.GenerationTimeout)
+	defer cancel()
+
+	delay := s.config.CheckInterval
+	unknown := 0
+	for {
+		resp, err := s.CheckGenerationStatus(ctx, uuid)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, waitError(ctx)
+			}
+			return nil, fmt.Errorf("failed to check generation status: %w", err)
+		}
+
+		switch resp.Status {
+		case "DONE":
+			s.setTiming(resp, submittedAt)
+			return resp, nil
+		case "FAIL":
+			return nil, &domain.GenerationFailedError{UUID: uuid, Description: resp.ErrorDescription, RawResponse: resp.RawResponse}
+		case "INITIAL", "PROCESSING":
+			unknown = 0
+		default:
+			unknown++
+			if unknown > maxUnknownStatuses {
+				return nil, fmt.Errorf("unknown status: %s", resp.Status)
+			}
+		}
+
+		// Wait before next attempt
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx)
+		case <-s.clock.After(backoff.Jitter(delay)):
+		}
+		delay = backoff.Next(delay, s.config.PollMaxInterval)
+	}
+}
+
+// waitError describes why waiting for a generation stopped once ctx is done: a deadline wraps
+// domain.ErrGenerationTimeout, while a cancellation is reported as such
+func waitError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %w", domain.ErrGenerationTimeout, ctx.Err())
+	}
+	return fmt.Errorf("stopped waiting for generation: %w", ctx.Err())
+}
+
+// GenerateAndWait submits the request, waits for it to finish within GenerationTimeout and
+// returns the first image decoded. The generation time is reported to the metrics hook. Errors
+// wrap domain.ErrGenerationTimeout, domain.ErrCensored or domain.ErrGenerationFailed.
+func (s *ImageGenerationService) GenerateAndWait(ctx context.Context, req domain.ImageGenerationRequest) (*domain.GenerationResult, error) {
+	start := s.clock.Now()
+
+	ctx, cancel := clock.WithTimeout(ctx, s.clock, s.config.GenerationTimeout)
+	defer cancel()
+
+	resp, err := s.GenerateImage(ctx, req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%w: %w", domain.ErrGenerationTimeout, err)
+		}
+		return nil, err
+	}
+
+	if resp.Status != "DONE" {
+		resp, err = s.waitForGeneration(ctx, resp.UUID, resp.SubmittedAt)
+		if err != nil {
+			return nil, err
+		}
+		s.CacheResult(ctx, req, resp)
+	}
+	s.metrics.ObserveDuration(metrics.GenerationDuration, resp.GenerationTime, map[string]string{"provider": resp.Provider})
+
+	if resp.Censored {
+		return nil, fmt.Errorf("%w: generation %s", domain.ErrCensored, resp.UUID)
+	}
+	if len(resp.Files) == 0 {
+		return nil, fmt.Errorf("%w: no files returned for generation %s", domain.ErrGenerationFailed, resp.UUID)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Files[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return &domain.GenerationResult{
+		Data:     data,
+		UUID:     resp.UUID,
+		Provider: resp.Provider,
+		Censored: resp.Censored,
+		Elapsed:  s.clock.Now().Sub(start),
+	}, nil
+}
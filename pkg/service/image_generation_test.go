@@ -0,0 +1,236 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/service"
+)
+
+var epoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testConfig returns a configuration polling every 1s to 4s for at most a minute
+func testConfig() service.Config {
+	return service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      time.Second,
+		PollMaxInterval:    4 * time.Second,
+		GenerationTimeout:  time.Minute,
+		Concurrency:        1,
+	}
+}
+
+// pollStep advances a fake clock past any jittered polling delay of testConfig
+const pollStep = 5 * time.Second
+
+func TestGenerateImageAppliesDefaults(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	provider := newFakeProvider("fake")
+	svc := service.NewImageGenerationService(provider, testConfig(), service.WithClock(clk))
+
+	resp, err := svc.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse"})
+	if err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if resp.Provider != "fake" || resp.Prompt != "a lighthouse" || !resp.SubmittedAt.Equal(epoch) {
+		t.Errorf("GenerateImage() = %+v, want provider fake, the prompt and the submission time", resp)
+	}
+
+	submits := provider.submitted()
+	if len(submits) != 1 {
+		t.Fatalf("provider received %d requests, want 1", len(submits))
+	}
+	if got := submits[0]; got.Width != 1024 || got.Height != 1024 || got.NumImages != 1 {
+		t.Errorf("provider received %dx%d x%d, want the defaults 1024x1024 x1", got.Width, got.Height, got.NumImages)
+	}
+}
+
+func TestGenerateImageRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		req  domain.ImageGenerationRequest
+		want error
+	}{
+		{name: "empty prompt", req: domain.ImageGenerationRequest{}, want: domain.ErrInvalidPrompt},
+		{name: "width not a multiple of 64", req: domain.ImageGenerationRequest{Prompt: "p", Width: 1000}, want: domain.ErrInvalidDimensions},
+		{name: "width below minimum", req: domain.ImageGenerationRequest{Prompt: "p", Width: 64}, want: domain.ErrInvalidDimensions},
+		{name: "height above maximum", req: domain.ImageGenerationRequest{Prompt: "p", Height: 2112}, want: domain.ErrInvalidDimensions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider("fake")
+			svc := service.NewImageGenerationService(provider, testConfig())
+			if _, err := svc.GenerateImage(context.Background(), tt.req); !errors.Is(err, tt.want) {
+				t.Errorf("GenerateImage() error = %v, want %v", err, tt.want)
+			}
+			if n := len(provider.submitted()); n != 0 {
+				t.Errorf("provider received %d requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestGenerateImageValidatesProcessedPrompt(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxPromptLength = 20
+	provider := newFakeProvider("fake")
+	svc := service.NewImageGenerationService(provider, cfg, service.WithPromptProcessors(suffixProcessor(", highly detailed")))
+
+	// The prompt fits the limit only until the suffix is added
+	_, err := svc.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse"})
+	if !errors.Is(err, domain.ErrInvalidPrompt) {
+		t.Errorf("GenerateImage() error = %v, want %v", err, domain.ErrInvalidPrompt)
+	}
+	if n := len(provider.submitted()); n != 0 {
+		t.Errorf("provider received %d requests, want none", n)
+	}
+}
+
+func TestGenerateImageSnapsDimensions(t *testing.T) {
+	tests := []struct {
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{width: 1000, height: 600, wantWidth: 1024, wantHeight: 576},
+		{width: 100, height: 127, wantWidth: 128, wantHeight: 128},
+		{width: 2049, height: 4096, wantWidth: 2048, wantHeight: 2048},
+		{width: 128, height: 2048, wantWidth: 128, wantHeight: 2048},
+	}
+	for _, tt := range tests {
+		provider := newFakeProvider("fake")
+		cfg := testConfig()
+		cfg.SnapDimensions = true
+		svc := service.NewImageGenerationService(provider, cfg)
+		if _, err := svc.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p", Width: tt.width, Height: tt.height}); err != nil {
+			t.Fatalf("GenerateImage(%dx%d) error = %v", tt.width, tt.height, err)
+		}
+		if got := provider.submitted()[0]; got.Width != tt.wantWidth || got.Height != tt.wantHeight {
+			t.Errorf("GenerateImage(%dx%d) submitted %dx%d, want %dx%d", tt.width, tt.height, got.Width, got.Height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestGenerateImageTranslatesNetworkErrors(t *testing.T) {
+	provider := newFakeProvider("fake")
+	provider.err = &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	svc := service.NewImageGenerationService(provider, testConfig())
+
+	_, err := svc.GenerateImage(context.Background(), domain.ImageGenerationRequest{Prompt: "p"})
+	if !errors.Is(err, domain.ErrProviderUnavailable) {
+		t.Errorf("GenerateImage() error = %v, want it to wrap domain.ErrProviderUnavailable", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("GenerateImage() error = %v, want it to keep the network error", err)
+	}
+}
+
+func TestCheckGenerationStatusReportsProvider(t *testing.T) {
+	svc := service.NewImageGenerationService(newFakeProvider("fake", done()), testConfig(), service.WithClock(clock.NewFake(epoch)))
+
+	resp, err := svc.CheckGenerationStatus(context.Background(), "uuid-1")
+	if err != nil {
+		t.Fatalf("CheckGenerationStatus() error = %v", err)
+	}
+	if resp.UUID != "uuid-1" || resp.Provider != "fake" || !resp.CompletedAt.Equal(epoch) {
+		t.Errorf("CheckGenerationStatus() = %+v, want uuid-1 of provider fake completed now", resp)
+	}
+}
+
+// generateAndWait runs GenerateAndWait on a fake clock, advancing it by pollStep once for every
+// one of polls waits between status checks
+func generateAndWait(t *testing.T, provider *fakeProvider, polls int, opts ...service.Option) (*domain.GenerationResult, time.Duration, error) {
+	t.Helper()
+	clk := clock.NewFake(epoch)
+	svc := service.NewImageGenerationService(provider, testConfig(), append([]service.Option{service.WithClock(clk)}, opts...)...)
+
+	type result struct {
+		res *domain.GenerationResult
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		res, err := svc.GenerateAndWait(context.Background(), domain.ImageGenerationRequest{Prompt: "a lighthouse"})
+		results <- result{res, err}
+	}()
+	for i := 0; i < polls; i++ {
+		// The timeouts of the request and of the wait, and the polling delay
+		clk.BlockUntil(3)
+		clk.Advance(pollStep)
+	}
+	r := <-results
+	return r.res, clk.Now().Sub(epoch), r.err
+}
+
+func TestGenerateAndWait(t *testing.T) {
+	provider := newFakeProvider("fake", status("INITIAL"), status("PROCESSING"), done())
+	res, waited, err := generateAndWait(t, provider, 2)
+	if err != nil {
+		t.Fatalf("GenerateAndWait() error = %v", err)
+	}
+	if res.UUID != "uuid-1" || res.Provider != "fake" || len(res.Data) == 0 {
+		t.Errorf("GenerateAndWait() = %+v, want the decoded image of uuid-1 from fake", res)
+	}
+	if res.Elapsed != waited {
+		t.Errorf("Elapsed = %v, want %v", res.Elapsed, waited)
+	}
+	if checks := provider.statusChecks(); checks != 3 {
+		t.Errorf("provider answered %d status checks, want 3", checks)
+	}
+}
+
+func TestGenerateAndWaitErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []domain.ImageGenerationResponse
+		polls    int
+		want     error
+	}{
+		{name: "failed", statuses: []domain.ImageGenerationResponse{status("PROCESSING"), {Status: "FAIL", ErrorDescription: "internal"}}, polls: 1, want: domain.ErrGenerationFailed},
+		{name: "censored", statuses: []domain.ImageGenerationResponse{{Status: "DONE", Censored: true, Files: []string{pixel}}}, want: domain.ErrCensored},
+		{name: "no files", statuses: []domain.ImageGenerationResponse{status("DONE")}, want: domain.ErrGenerationFailed},
+		{name: "timeout", statuses: []domain.ImageGenerationResponse{status("PROCESSING")}, polls: 12, want: domain.ErrGenerationTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := generateAndWait(t, newFakeProvider("fake", tt.statuses...), tt.polls)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GenerateAndWait() error = %v, want %v", err, tt.want)
+			}
+			// A timeout is never reported as a failure of the provider, nor the other way round
+			if timedOut := errors.Is(err, domain.ErrGenerationTimeout); timedOut != (tt.want == domain.ErrGenerationTimeout) || timedOut && errors.Is(err, domain.ErrGenerationFailed) {
+				t.Errorf("GenerateAndWait() error = %v, want timeouts told apart from failures", err)
+			}
+		})
+	}
+}
+
+func TestWaitForGenerationToleratesUnknownStatuses(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	provider := newFakeProvider("fake", status("QUEUED"), status("QUEUED"), status("QUEUED"), status("QUEUED"))
+	svc := service.NewImageGenerationService(provider, testConfig(), service.WithClock(clk))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := svc.WaitForGeneration(context.Background(), "uuid-1")
+		errs <- err
+	}()
+	for i := 0; i < 3; i++ {
+		clk.BlockUntil(2)
+		clk.Advance(pollStep)
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("WaitForGeneration() error = nil, want the fourth unknown status to fail it")
+	}
+	if checks := provider.statusChecks(); checks != 4 {
+		t.Errorf("provider answered %d status checks, want 4", checks)
+	}
+}
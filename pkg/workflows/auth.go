@@ -0,0 +1,47 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// authGate stops a pass at the first error of the provider rejecting the credentials. With a
+// revoked or misconfigured key every other image would fail the same way, so the images are
+// left as they are and the pass fails instead, which degrades the workflow and backs it off
+// until the key is fixed.
+type authGate struct {
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// newAuthGate returns a gate and a copy of ctx that is cancelled when the gate trips, to stop
+// dispatching more images
+func newAuthGate(ctx context.Context) (context.Context, *authGate) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &authGate{cancel: cancel}
+}
+
+// trip records err, the first one wins, and stops the dispatch of more images
+func (a *authGate) trip(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+	a.cancel()
+}
+
+// close releases the dispatch context and returns the error the pass fails with, if the gate
+// tripped
+func (a *authGate) close() error {
+	a.cancel()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		return nil
+	}
+	return fmt.Errorf("provider rejected the credentials: %w", a.err)
+}
@@ -0,0 +1,81 @@
+package workflows_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestGeneratorLeavesImagesQueuedOnAuthErrors(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			ids := createImages(t, repo, "first", "second", "third")
+			svc := testsupport.NewFakeImageGenerationService()
+			svc.Default = testsupport.SubmitError(statusError(status))
+
+			g := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...)
+			summary, err := g.RunOnce(context.Background())
+			if err == nil {
+				t.Fatal("RunOnce() error = nil, want the pass to fail")
+			}
+			var se statusError
+			if !errors.As(err, &se) || int(se) != status {
+				t.Errorf("RunOnce() error = %v, want it to wrap the %d", err, status)
+			}
+
+			// The first rejection stops the pass, and no image is failed
+			if calls := len(svc.Calls()); calls != 1 {
+				t.Errorf("service received %d calls, want 1", calls)
+			}
+			for _, id := range ids {
+				wantStatus(t, repo, id, domain.StatusReadyToGenerate)
+			}
+			if summary.Failed != 0 || summary.Skipped != 1 {
+				t.Errorf("summary = %+v, want 1 skipped and none failed", summary)
+			}
+
+			// Once the key works again the images are generated
+			svc.Default = testsupport.Done()
+			if _, err := g.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce() after fixing the key error = %v", err)
+			}
+			for _, id := range ids {
+				wantStatus(t, repo, id, domain.StatusReadyToPublish)
+			}
+		})
+	}
+}
+
+// authFailingService rejects the credentials of every status check
+type authFailingService struct {
+	*testsupport.FakeImageGenerationService
+}
+
+func (authFailingService) CheckGenerationStatus(context.Context, string) (*domain.ImageGenerationResponse, error) {
+	return nil, statusError(http.StatusUnauthorized)
+}
+
+func TestProcessorLeavesImagesGeneratingOnAuthErrors(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "first", "second")
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	if _, err := workflows.NewGenerator(repo, fake, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+
+	p := workflows.NewProcessor(repo, authFailingService{fake}, testConfig(), testOptions()...)
+	if _, err := p.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce() error = nil, want the pass to fail")
+	}
+	for _, id := range ids {
+		wantStatus(t, repo, id, domain.StatusGenerate)
+	}
+}
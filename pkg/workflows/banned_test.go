@@ -0,0 +1,78 @@
+package workflows_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestGeneratorRejectsBannedTerms(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	if _, err := repo.AddBannedTerm(context.Background(), "blood bath", false); err != nil {
+		t.Fatalf("AddBannedTerm() error = %v", err)
+	}
+	ids := createImages(t, repo, "a lighthouse", "a Blood Bath at dusk", "a denuded hill", "Gore")
+	svc := testsupport.NewFakeImageGenerationService()
+	cfg := testConfig()
+	cfg.PromptRejectTerms = []domain.BannedTerm{{Term: "gore"}, {Term: "nud(e|ity)", Regex: true}}
+
+	if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+	for i, term := range map[int]string{1: "blood bath", 2: "nud(e|ity)", 3: "gore"} {
+		img := wantStatus(t, repo, ids[i], domain.StatusRejected)
+		if !strings.Contains(img.ErrorDescription, `"`+term+`"`) {
+			t.Errorf("image %d error = %q, want the matched term %q", ids[i], img.ErrorDescription, term)
+		}
+	}
+	calls := svc.Calls()
+	if len(calls) != 1 || calls[0].Request.Prompt != "a lighthouse" {
+		t.Errorf("service received %+v, want only the allowed prompt submitted", calls)
+	}
+}
+
+func TestGeneratorReloadsBannedTerms(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := testsupport.NewFakeImageGenerationService()
+	g := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...)
+
+	first := createImages(t, repo, "a stormy sea")
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, first[0], domain.StatusReadyToPublish)
+
+	// A term added while running applies from the next pass
+	if _, err := repo.AddBannedTerm(context.Background(), "storm(y|s)?", true); err != nil {
+		t.Fatalf("AddBannedTerm() error = %v", err)
+	}
+	second := createImages(t, repo, "a STORMY sea")
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, second[0], domain.StatusRejected)
+}
+
+func TestGeneratorFailsOnInvalidBannedRegex(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse")
+	svc := testsupport.NewFakeImageGenerationService()
+	cfg := testConfig()
+	cfg.PromptRejectTerms = []domain.BannedTerm{{Term: "nud(e", Regex: true}}
+
+	if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce() with an invalid regex succeeded")
+	}
+	// Nothing is submitted unfiltered
+	wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+	if calls := svc.Calls(); len(calls) != 0 {
+		t.Errorf("service received %d calls, want none", len(calls))
+	}
+}
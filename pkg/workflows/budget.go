@@ -0,0 +1,77 @@
+package workflows
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// dailyBudget pauses the generator until midnight UTC once the requests submitted today, as
+// counted in provider_usage, reach DailyRequestCap or cost DailySpendCap. A nil budget never
+// pauses.
+type dailyBudget struct {
+	repo repository.ImageRepository
+	cfg  Config
+	clk  clock.Clock
+	log  *slog.Logger
+
+	// pausedOn is the day the budget ran out, zero while it lasts, so the pause is logged once
+	mu       sync.Mutex
+	pausedOn time.Time
+}
+
+// newDailyBudget returns the budget of the configured caps, or nil when none is set
+func newDailyBudget(r *runner) *dailyBudget {
+	if r.cfg.DailyRequestCap == 0 && r.cfg.DailySpendCap == 0 {
+		return nil
+	}
+	return &dailyBudget{repo: r.repo, cfg: r.cfg, clk: r.clk, log: r.log}
+}
+
+// remaining returns how many generation requests may still be submitted today, or -1 when
+// there is no cap. The first time it returns 0 on a day, and the first time it returns more
+// again, the change is logged.
+func (b *dailyBudget) remaining(ctx context.Context) (int, error) {
+	if b == nil {
+		return -1, nil
+	}
+
+	day := domain.UsageDay(b.clk.Now())
+	usage, err := b.repo.GetUsage(ctx, day)
+	if err != nil {
+		return 0, err
+	}
+	requests := 0
+	for _, u := range usage {
+		requests += u.Requests
+	}
+
+	left := math.MaxInt
+	if b.cfg.DailyRequestCap > 0 {
+		left = b.cfg.DailyRequestCap - requests
+	}
+	if b.cfg.DailySpendCap > 0 {
+		affordable := int(math.Floor((b.cfg.DailySpendCap - float64(requests)*b.cfg.RequestCost) / b.cfg.RequestCost))
+		left = min(left, affordable)
+	}
+	left = max(left, 0)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case left == 0 && !b.pausedOn.Equal(day):
+		b.pausedOn = day
+		b.log.Warn("Daily budget used up, pausing generation until midnight UTC",
+			"workflow", "generator", "requests", requests, "resumes_at", day.AddDate(0, 0, 1))
+	case left > 0 && !b.pausedOn.IsZero():
+		b.pausedOn = time.Time{}
+		b.log.Info("Daily budget available again, resuming generation", "workflow", "generator", "remaining", left)
+	}
+	return left, nil
+}
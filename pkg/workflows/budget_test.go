@@ -0,0 +1,92 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestDailyBudgetAcrossMidnight(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	clk := clock.NewFake(time.Date(2024, 5, 1, 23, 58, 0, 0, time.UTC))
+	svc := service.NewImageGenerationService(testsupport.NewFakeProvider("fake"), service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      time.Millisecond,
+		PollMaxInterval:    time.Millisecond,
+		GenerationTimeout:  time.Minute,
+	}, service.WithClock(clk), service.WithUsageRecorder(repo))
+	createImages(t, repo, "one", "two", "three", "four", "five")
+
+	var logs bytes.Buffer
+	cfg := testConfig()
+	cfg.DailyRequestCap = 2
+	g := workflows.NewGenerator(repo, svc, cfg,
+		testOptions(workflows.WithClock(clk), workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))...)
+	ctx := context.Background()
+
+	// pass runs a generator pass and checks how many images it submitted
+	pass := func(want int) {
+		t.Helper()
+		summary, err := g.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("RunOnce() error = %v", err)
+		}
+		if summary.Submitted != want {
+			t.Errorf("RunOnce() at %v = %s, want %d submitted", clk.Now(), summary, want)
+		}
+	}
+	// usage checks the requests counted on the day of the clock
+	usage := func(want int) {
+		t.Helper()
+		day := domain.UsageDay(clk.Now())
+		got, err := repo.GetUsage(ctx, day)
+		if err != nil {
+			t.Fatalf("GetUsage() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, []domain.ProviderUsage{{Provider: "fake", Day: day, Requests: want}}) {
+			t.Errorf("GetUsage(%v) = %+v, want %d requests of fake", day, got, want)
+		}
+	}
+
+	pass(2)
+	usage(2)
+	// Once the cap is reached the queue waits, however often the generator runs
+	pass(0)
+	clk.Advance(time.Minute + 59*time.Second)
+	pass(0)
+	usage(2)
+	if n := strings.Count(logs.String(), "Daily budget used up"); n != 1 {
+		t.Errorf("logged the pause %d times, want once\n%s", n, logs.String())
+	}
+
+	// Midnight UTC starts a new day with a new budget
+	clk.Advance(time.Second)
+	pass(2)
+	usage(2)
+	if !strings.Contains(logs.String(), "Daily budget available again") {
+		t.Errorf("logs = %s, want the resumption logged", logs.String())
+	}
+	pass(0)
+
+	queued, err := repo.CountByStatus(ctx)
+	if err != nil {
+		t.Fatalf("CountByStatus() error = %v", err)
+	}
+	if queued[domain.StatusReadyToGenerate] != 1 {
+		t.Errorf("CountByStatus() = %v, want one image left for the next day", queued)
+	}
+}
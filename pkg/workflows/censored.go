@@ -0,0 +1,49 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// handleCensored records that the generation of img was censored. With CensoredRequeue the
+// image is queued again once, otherwise it is moved to the Censored status.
+func (r *runner) handleCensored(ctx context.Context, outcomes *outcomeReporter, img *domain.Image, resp *domain.ImageGenerationResponse) error {
+	reason := resp.ErrorDescription
+	if reason == "" {
+		reason = fmt.Sprintf("generation %s was censored by %s", resp.UUID, resp.Provider)
+	}
+
+	if err := r.repo.UpdateCensored(ctx, img.ID, true); err != nil {
+		return fmt.Errorf("failed to update censored flag: %w", err)
+	}
+	r.saveRawResponse(ctx, img, resp)
+
+	if r.cfg.CensoredRequeue && !img.Censored {
+		r.log.Info("Image was censored, requeueing it with a strengthened negative prompt", "image_id", img.ID, "uuid", resp.UUID, "reason", reason)
+		if err := r.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusReadyToGenerate, reason); err != nil {
+			return fmt.Errorf("failed to update status: %w", err)
+		}
+		return nil
+	}
+
+	r.log.Warn("Image was censored", "image_id", img.ID, "uuid", resp.UUID, "reason", reason)
+	if err := r.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusCensored, reason); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	outcomes.report(ctx, img, domain.StatusCensored, reason)
+	return nil
+}
+
+// strengthenNegativePrompt appends extra terms to a negative prompt
+func strengthenNegativePrompt(negativePrompt, extra string) string {
+	if extra == "" {
+		return negativePrompt
+	}
+	if strings.TrimSpace(negativePrompt) == "" {
+		return extra
+	}
+	return negativePrompt + ", " + extra
+}
@@ -0,0 +1,95 @@
+package workflows_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// censoredOutcomes are the censored generations the workflows handle: found by the generator or
+// by the processor, with or without files
+var censoredOutcomes = []struct {
+	name    string
+	outcome testsupport.Outcome
+}{
+	{name: "right away with files", outcome: testsupport.Outcome{Files: []string{"aGk="}, Censored: true}},
+	{name: "right away without files", outcome: testsupport.Outcome{Censored: true}},
+	{name: "after polling with files", outcome: testsupport.Outcome{Polls: 1, Files: []string{"aGk="}, Censored: true}},
+	{name: "after polling without files", outcome: testsupport.Outcome{Polls: 1, Censored: true}},
+}
+
+// runPipeline runs a pass of the generator and then of the processor
+func runPipeline(t *testing.T, g *workflows.Generator, p *workflows.Processor) {
+	t.Helper()
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+}
+
+func TestCensoredImagesAreNeverPublished(t *testing.T) {
+	for _, tt := range censoredOutcomes {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			ids := createImages(t, repo, "a lighthouse")
+			svc := testsupport.NewFakeImageGenerationService()
+			svc.Default = tt.outcome
+			cfg := testConfig()
+			cfg.CaptureRawResponses = true
+			runPipeline(t, workflows.NewGenerator(repo, svc, cfg, testOptions()...), workflows.NewProcessor(repo, svc, cfg, testOptions()...))
+
+			img := wantStatus(t, repo, ids[0], domain.StatusCensored)
+			if !img.Censored || img.ErrorDescription == "" || len(img.RawResponse) == 0 {
+				t.Errorf("image = %+v, want it flagged censored with the reason and the raw response", img)
+			}
+			if result, err := repo.GetResult(context.Background(), ids[0]); err != nil || result != nil {
+				t.Errorf("GetResult() = %v, %v, want no result saved", result, err)
+			}
+		})
+	}
+}
+
+func TestCensoredRequeue(t *testing.T) {
+	for _, tt := range censoredOutcomes {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			ids := createImages(t, repo, "a lighthouse")
+			svc := testsupport.NewFakeImageGenerationService()
+			svc.Default = tt.outcome
+			cfg := testConfig()
+			cfg.CensoredRequeue = true
+			g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+			p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+
+			// The first censoring queues the image once more
+			runPipeline(t, g, p)
+			if img := wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate); !img.Censored {
+				t.Error("requeued image is not flagged censored")
+			}
+
+			// It is submitted with the strengthened negative prompt, and a second censoring is final
+			runPipeline(t, g, p)
+			wantStatus(t, repo, ids[0], domain.StatusCensored)
+
+			var negativePrompts []string
+			for _, call := range svc.Calls() {
+				if call.Method == testsupport.MethodGenerateImage {
+					negativePrompts = append(negativePrompts, call.Request.NegativePrompt)
+				}
+			}
+			if len(negativePrompts) != 2 {
+				t.Fatalf("service received %d generations, want 2", len(negativePrompts))
+			}
+			if strings.Contains(negativePrompts[0], cfg.CensoredNegativePrompt) || !strings.Contains(negativePrompts[1], cfg.CensoredNegativePrompt) {
+				t.Errorf("negative prompts = %q, want only the second strengthened with %q", negativePrompts, cfg.CensoredNegativePrompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/backoff"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// untimedService is a fake service whose responses carry no completion time, like FusionBrain,
+// so that generation durations are measured by the workflow
+type untimedService struct {
+	*testsupport.FakeImageGenerationService
+}
+
+func (s untimedService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	resp, err := s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid)
+	if resp != nil {
+		resp.CompletedAt = time.Time{}
+	}
+	return resp, err
+}
+
+// fakeClockRun is a processor pass running on a fake clock
+type fakeClockRun struct {
+	t       *testing.T
+	clk     *clock.Fake
+	fake    *testsupport.FakeImageGenerationService
+	repo    repository.ImageRepository
+	id      int
+	started time.Time
+	done    chan struct{}
+	summary workflows.Summary
+	err     error
+}
+
+// startFakeClockRun submits one image whose status checks follow outcome and starts a processor
+// pass with cfg on a fake clock set to when the image was submitted
+func startFakeClockRun(t *testing.T, cfg workflows.Config, outcome testsupport.Outcome) *fakeClockRun {
+	t.Helper()
+	r := &fakeClockRun{t: t, fake: testsupport.NewFakeImageGenerationService(), repo: repository.NewMemoryImageRepository(), done: make(chan struct{})}
+	r.fake.Default = outcome
+	svc := untimedService{r.fake}
+	r.id = createImages(t, r.repo, "a lighthouse")[0]
+	if _, err := workflows.NewGenerator(r.repo, svc, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	r.started = wantStatus(t, r.repo, r.id, domain.StatusGenerate).GenerationStartedAt
+	r.clk = clock.NewFake(r.started)
+
+	p := workflows.NewProcessor(r.repo, svc, cfg, testOptions(workflows.WithClock(r.clk))...)
+	checks := r.checks()
+	go func() {
+		defer close(r.done)
+		r.summary, r.err = p.RunOnce(context.Background())
+	}()
+	r.waitChecks(checks + 1)
+	return r
+}
+
+// checks returns the number of status checks so far
+func (r *fakeClockRun) checks() int {
+	n := 0
+	for _, call := range r.fake.Calls() {
+		if call.Method == testsupport.MethodCheckGenerationStatus {
+			n++
+		}
+	}
+	return n
+}
+
+// waitChecks waits until the pass made n status checks
+func (r *fakeClockRun) waitChecks(n int) {
+	r.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.checks() < n {
+		if time.Now().After(deadline) {
+			r.t.Fatalf("%d status checks within 5s, want %d", r.checks(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// wait lets the pass wait out a backoff of d, once it is waiting between checks. Checking that
+// no status check runs before the shortest wait the jitter allows, it advances the clock to the
+// longest and returns it.
+func (r *fakeClockRun) wait(d time.Duration) time.Duration {
+	r.t.Helper()
+	lo := time.Duration(float64(d) * (1 - backoff.JitterFraction))
+	hi := time.Duration(float64(d) * (1 + backoff.JitterFraction))
+
+	// The per-image deadline and the backoff between checks
+	r.clk.BlockUntil(2)
+	checks := r.checks()
+	r.clk.Advance(lo - time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if got := r.checks(); got != checks {
+		r.t.Fatalf("status checked %d times before the wait of %v passed, want %d", got, d, checks)
+	}
+	r.clk.Advance(hi - lo + time.Millisecond)
+	r.waitChecks(checks + 1)
+	return hi
+}
+
+// finish waits for the pass to end and returns its summary
+func (r *fakeClockRun) finish() workflows.Summary {
+	r.t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		r.t.Fatal("processor pass did not finish within 5s")
+	}
+	if r.err != nil {
+		r.t.Fatalf("processor RunOnce() error = %v", r.err)
+	}
+	return r.summary
+}
+
+func TestProcessorWaitsOnTheClock(t *testing.T) {
+	cfg := testConfig()
+	cfg.CheckInterval = 2 * time.Second
+	cfg.PollMaxInterval = 3 * time.Second
+	cfg.MaxAttempts = 5
+	cfg.PerImageTimeout = time.Minute
+	r := startFakeClockRun(t, cfg, testsupport.DoneAfter(2))
+
+	// The wait grows by half from CheckInterval up to PollMaxInterval
+	elapsed := r.wait(2 * time.Second)
+	elapsed += r.wait(3 * time.Second)
+	summary := r.finish()
+
+	if summary.Generated != 1 || summary.Duration != elapsed {
+		t.Errorf("RunOnce() = %s, want the image generated in %v of clock time", summary, elapsed)
+	}
+	img := wantStatus(t, r.repo, r.id, domain.StatusReadyToPublish)
+	if img.Metadata.GenerationDuration != elapsed {
+		t.Errorf("generation took %v, want the %v the clock advanced since the submission", img.Metadata.GenerationDuration, elapsed)
+	}
+}
+
+func TestProcessorGenerationTimeoutOnTheClock(t *testing.T) {
+	cfg := testConfig()
+	cfg.CheckInterval = 2 * time.Second
+	cfg.PollMaxInterval = 2 * time.Second
+	cfg.MaxAttempts = 100
+	cfg.PerImageTimeout = time.Hour
+	cfg.GenerationTimeout = 10 * time.Second
+	r := startFakeClockRun(t, cfg, testsupport.Timeout())
+
+	var elapsed time.Duration
+	for i := 0; i < 5; i++ {
+		elapsed += r.wait(2 * time.Second)
+	}
+	summary := r.finish()
+
+	// Checked about every 2.4s, the sixth check finds the generation ran for GenerationTimeout
+	if summary.TimedOut != 1 || r.checks() != 6 || summary.Duration != elapsed {
+		t.Errorf("RunOnce() = %s after %d checks, want the image timed out at the sixth check, %v in", summary, r.checks(), elapsed)
+	}
+	wantStatus(t, r.repo, r.id, domain.StatusTimedOut)
+}
+
+func TestProcessorChecksWithinPerImageTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.CheckInterval = 2 * time.Second
+	cfg.PollMaxInterval = 2 * time.Second
+	cfg.MaxAttempts = 100
+	cfg.PerImageTimeout = 5 * time.Second
+	cfg.GenerationTimeout = time.Hour
+	r := startFakeClockRun(t, cfg, testsupport.Timeout())
+
+	elapsed := r.wait(2 * time.Second)
+	elapsed += r.wait(2 * time.Second)
+	// A third wait of at least 1.6s would end past PerImageTimeout, so the checks stop instead
+	// of running into the deadline
+	summary := r.finish()
+
+	if summary.Images != 1 || summary.Skipped != 0 || summary.Errors != 0 || r.checks() != 3 || summary.Duration != elapsed {
+		t.Errorf("RunOnce() = %s after %d checks, want the image left after the third check, %v in", summary, r.checks(), elapsed)
+	}
+	wantStatus(t, r.repo, r.id, domain.StatusGenerate)
+}
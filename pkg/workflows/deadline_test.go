@@ -0,0 +1,109 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// stuckPrompt is the prompt whose submission never returns
+const stuckPrompt = "a lighthouse the provider never answers for"
+
+// stuckService is a fake service whose calls for stuckPrompt and the generations in stuckUUIDs
+// hang until their context ends, like a provider that stopped responding
+type stuckService struct {
+	*testsupport.FakeImageGenerationService
+	stuckUUIDs map[string]bool
+}
+
+func (s *stuckService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	if req.Prompt == stuckPrompt {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.FakeImageGenerationService.GenerateImage(ctx, req)
+}
+
+func (s *stuckService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	if s.stuckUUIDs[uuid] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid)
+}
+
+// deadlineConfig handles one image at a time, so a stuck image would hold up the rest
+func deadlineConfig() workflows.Config {
+	cfg := testConfig()
+	cfg.PerImageTimeout = 100 * time.Millisecond
+	return cfg
+}
+
+func TestGeneratorPerImageDeadline(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := &stuckService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService()}
+	g := workflows.NewGenerator(repo, svc, deadlineConfig(), testOptions()...)
+	ids := createImages(t, repo, "a lighthouse", stuckPrompt, "a harbour", "a lighthouse at night")
+
+	start := time.Now()
+	summary, err := g.RunOnce(context.Background())
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	// One deadline plus the quick images, far below a stall on the stuck one
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("RunOnce() took %v, want about one PerImageTimeout", elapsed)
+	}
+	if summary.Skipped != 1 || summary.Failed != 0 || summary.Generated != 3 {
+		t.Errorf("RunOnce() = %s, want the stuck image skipped and the rest generated", summary)
+	}
+
+	// The stuck image is left for the next pass rather than failed
+	if img := wantStatus(t, repo, ids[1], domain.StatusReadyToGenerate); img.UUID != "" || img.ErrorDescription != "" {
+		t.Errorf("stuck image = %+v, want it requeued untouched", img)
+	}
+	for _, id := range []int{ids[0], ids[2], ids[3]} {
+		wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	}
+}
+
+func TestProcessorPerImageDeadline(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	svc := &stuckService{FakeImageGenerationService: fake, stuckUUIDs: map[string]bool{}}
+	cfg := deadlineConfig()
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+	ids := createImages(t, repo, "a lighthouse", "a harbour", "a lighthouse at night")
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	stuck := wantStatus(t, repo, ids[0], domain.StatusGenerate)
+	svc.stuckUUIDs[stuck.UUID] = true
+
+	start := time.Now()
+	summary, err := p.RunOnce(context.Background())
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	// The status checks of the stuck image share one deadline instead of each waiting it out
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("RunOnce() took %v, want about one PerImageTimeout", elapsed)
+	}
+	if summary.Skipped != 1 || summary.Generated != 2 {
+		t.Errorf("RunOnce() = %s, want the stuck image skipped and the rest generated", summary)
+	}
+	if img := wantStatus(t, repo, ids[0], domain.StatusGenerate); img.UUID != stuck.UUID {
+		t.Errorf("stuck image has UUID %q, want %q kept for the next check", img.UUID, stuck.UUID)
+	}
+	wantStatus(t, repo, ids[1], domain.StatusReadyToPublish)
+	wantStatus(t, repo, ids[2], domain.StatusReadyToPublish)
+}
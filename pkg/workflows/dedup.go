@@ -0,0 +1,118 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// deduplicateImage stores the prompt hash of img and, depending on DEDUP_MODE, resolves it
+// against an earlier image with the same hash. It reports whether img must not be submitted.
+// Failing to look up duplicates only risks a duplicate generation, so it is logged and ignored.
+func (r *runner) deduplicateImage(ctx context.Context, img *domain.Image) (bool, error) {
+	hash := img.PromptHash(r.cfg.DefaultImageWidth, r.cfg.DefaultImageHeight)
+	if err := r.repo.UpdatePromptHash(ctx, img.ID, hash); err != nil {
+		r.log.Error("Error updating prompt hash", "workflow", "generator", "image_id", img.ID, "error", err)
+		return false, nil
+	}
+
+	if r.cfg.DedupMode == "off" {
+		return false, nil
+	}
+
+	original, err := r.repo.FindByPromptHash(ctx, hash, img.ID)
+	if err != nil {
+		r.log.Error("Error looking up duplicates", "workflow", "generator", "image_id", img.ID, "error", err)
+		return false, nil
+	}
+	if original == nil {
+		return false, nil
+	}
+
+	if r.cfg.DedupMode == "mark" {
+		r.log.Info("Image is a duplicate, marking it as Duplicate", "workflow", "generator", "image_id", img.ID, "original_id", original.ID)
+		reason := fmt.Sprintf("duplicate of image %d", original.ID)
+		if err := r.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusDuplicate, reason); err != nil {
+			return true, fmt.Errorf("failed to update status: %w", err)
+		}
+		return true, nil
+	}
+
+	return r.reuseResult(ctx, img, original)
+}
+
+// reuseResult copies the result or the pending generation of original onto img.
+// It reports whether img must not be submitted.
+func (r *runner) reuseResult(ctx context.Context, img, original *domain.Image) (bool, error) {
+	log := r.log.With("workflow", "generator", "image_id", img.ID, "original_id", original.ID)
+	switch {
+	case original.HasResult():
+		log.Info("Image is a duplicate, reusing its result")
+		variants, err := r.repo.ListVariants(ctx, original.ID)
+		if err != nil {
+			return true, fmt.Errorf("failed to load variants of image ID %d: %w", original.ID, err)
+		}
+		// Listed variants leave out their inline data, which is copied one by one
+		for i, v := range variants {
+			if v.FilePath != "" {
+				continue
+			}
+			full, err := r.repo.GetVariant(ctx, original.ID, v.Index)
+			if err != nil {
+				return true, fmt.Errorf("failed to load variant %d of image ID %d: %w", v.Index, original.ID, err)
+			}
+			if full != nil {
+				variants[i].Data = full.Data
+			}
+		}
+		// Images generated before image_results existed only have the base64 column
+		if len(variants) == 0 {
+			data, err := r.repo.GetImageData(ctx, original.ID)
+			if err != nil {
+				return true, fmt.Errorf("failed to load data of image ID %d: %w", original.ID, err)
+			}
+			variants = []domain.ImageVariant{{Data: data}}
+		}
+		if err := r.repo.UpdateUUID(ctx, img.ID, original.UUID); err != nil {
+			return true, fmt.Errorf("failed to update UUID: %w", err)
+		}
+		if err := results.SaveVariants(ctx, r.repo, img.ID, variants, r.cfg.LegacyBase64); err != nil {
+			return true, err
+		}
+		if original.SelectedVariant != 0 {
+			if _, err := r.repo.SelectVariant(ctx, img.ID, original.SelectedVariant); err != nil {
+				return true, fmt.Errorf("failed to select variant: %w", err)
+			}
+		}
+		if err := r.repo.UpdateStatus(ctx, img.ID, domain.StatusReadyToPublish, repository.WithReason(fmt.Sprintf("reused the result of image %d", original.ID))); err != nil {
+			return true, fmt.Errorf("failed to update status: %w", err)
+		}
+		return true, nil
+
+	case original.Status == domain.StatusGenerate && original.UUID != "":
+		// Both images are completed by the processor when the shared UUID is done
+		log.Info("Image is a duplicate, sharing its UUID", "uuid", original.UUID)
+		if err := r.repo.UpdateUUID(ctx, img.ID, original.UUID); err != nil {
+			return true, fmt.Errorf("failed to update UUID: %w", err)
+		}
+		// The provider routes the status checks of a provider chain
+		if err := r.repo.UpdateProvider(ctx, img.ID, original.Provider); err != nil {
+			return true, fmt.Errorf("failed to update provider: %w", err)
+		}
+		if err := r.repo.UpdateStatus(ctx, img.ID, domain.StatusGenerate, repository.WithReason(fmt.Sprintf("shares the generation of image %d", original.ID))); err != nil {
+			return true, fmt.Errorf("failed to update status: %w", err)
+		}
+		return true, nil
+
+	case original.Status == domain.StatusReadyToGenerate || original.Status == domain.StatusClaimed:
+		log.Info("Image is a duplicate of a queued image, waiting for its result")
+		return true, nil
+
+	default:
+		// The original finished without a reusable result, so generate this one
+		return false, nil
+	}
+}
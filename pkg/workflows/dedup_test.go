@@ -0,0 +1,137 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// dedupConfig returns testConfig with the given DedupMode
+func dedupConfig(mode string) workflows.Config {
+	cfg := testConfig()
+	cfg.DedupMode = mode
+	return cfg
+}
+
+// submissions returns how many generations svc received
+func submissions(svc *testsupport.FakeImageGenerationService) int {
+	n := 0
+	for _, call := range svc.Calls() {
+		if call.Method == testsupport.MethodGenerateImage {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDedupModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		duplicate   string
+		opts        []repository.CreateOption
+		wantStatus  domain.ImageStatus
+		wantSubmits int
+	}{
+		{name: "off", mode: "off", duplicate: "a lighthouse", wantStatus: domain.StatusReadyToPublish, wantSubmits: 2},
+		{name: "mark", mode: "mark", duplicate: "a lighthouse", wantStatus: domain.StatusDuplicate, wantSubmits: 1},
+		{name: "mark normalized", mode: "mark", duplicate: "  a   lighthouse ", wantStatus: domain.StatusDuplicate, wantSubmits: 1},
+		{name: "mark other style", mode: "mark", duplicate: "a lighthouse", opts: []repository.CreateOption{repository.WithStyle("ANIME")}, wantStatus: domain.StatusReadyToPublish, wantSubmits: 2},
+		{name: "mark other size", mode: "mark", duplicate: "a lighthouse", opts: []repository.CreateOption{repository.WithSize(512, 512)}, wantStatus: domain.StatusReadyToPublish, wantSubmits: 2},
+		{name: "mark default size stated", mode: "mark", duplicate: "a lighthouse", opts: []repository.CreateOption{repository.WithSize(1024, 1024)}, wantStatus: domain.StatusDuplicate, wantSubmits: 1},
+		{name: "mark other seed", mode: "mark", duplicate: "a lighthouse", opts: []repository.CreateOption{repository.WithSeed(42)}, wantStatus: domain.StatusReadyToPublish, wantSubmits: 2},
+		{name: "mark other params", mode: "mark", duplicate: "a lighthouse", opts: []repository.CreateOption{repository.WithParams(map[string]interface{}{"steps": 30})}, wantStatus: domain.StatusReadyToPublish, wantSubmits: 2},
+		{name: "reuse", mode: "reuse", duplicate: "a lighthouse", wantStatus: domain.StatusReadyToPublish, wantSubmits: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			svc := testsupport.NewFakeImageGenerationService()
+			g := workflows.NewGenerator(repo, svc, dedupConfig(tt.mode), testOptions()...)
+
+			original := createImages(t, repo, "a lighthouse")[0]
+			if _, err := g.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce() error = %v", err)
+			}
+			duplicate, err := repo.CreateImage(context.Background(), tt.duplicate, tt.opts...)
+			if err != nil {
+				t.Fatalf("CreateImage() error = %v", err)
+			}
+			if _, err := g.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce() error = %v", err)
+			}
+
+			wantStatus(t, repo, original, domain.StatusReadyToPublish)
+			wantStatus(t, repo, duplicate, tt.wantStatus)
+			if n := submissions(svc); n != tt.wantSubmits {
+				t.Errorf("service received %d generations, want %d", n, tt.wantSubmits)
+			}
+		})
+	}
+}
+
+func TestDedupReuseCopiesResult(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := testsupport.NewFakeImageGenerationService()
+	g := workflows.NewGenerator(repo, svc, dedupConfig("reuse"), testOptions()...)
+
+	original := createImages(t, repo, "a lighthouse")[0]
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	duplicate := createImages(t, repo, "a lighthouse")[0]
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	want, err := repo.GetResult(context.Background(), original)
+	if err != nil || want == nil {
+		t.Fatalf("GetResult(original) = %v, %v", want, err)
+	}
+	got, err := repo.GetResult(context.Background(), duplicate)
+	if err != nil || got == nil {
+		t.Fatalf("GetResult(duplicate) = %v, %v, want the copied result", got, err)
+	}
+	if string(got.Data) != string(want.Data) {
+		t.Error("the duplicate's result differs from the original's")
+	}
+	if img := getImage(t, repo, duplicate); img.UUID != getImage(t, repo, original).UUID {
+		t.Errorf("duplicate UUID = %q, want the original's", img.UUID)
+	}
+}
+
+func TestDedupReuseSharesPendingGeneration(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := testsupport.NewFakeImageGenerationService()
+	svc.Default = testsupport.DoneAfter(1)
+	cfg := dedupConfig("reuse")
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+
+	original := createImages(t, repo, "a lighthouse")[0]
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	duplicate := createImages(t, repo, "a lighthouse")[0]
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	shared := wantStatus(t, repo, duplicate, domain.StatusGenerate)
+	if orig := getImage(t, repo, original); shared.UUID != orig.UUID || shared.Provider != orig.Provider {
+		t.Errorf("duplicate shares %q of %q, want %q of %q", shared.UUID, shared.Provider, orig.UUID, orig.Provider)
+	}
+
+	// The processor completes both images from the one generation
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, original, domain.StatusReadyToPublish)
+	wantStatus(t, repo, duplicate, domain.StatusReadyToPublish)
+	if n := submissions(svc); n != 1 {
+		t.Errorf("service received %d generations, want 1", n)
+	}
+}
@@ -0,0 +1,178 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+var errDatabaseDown = errors.New("database unreachable")
+
+// downRepository is a repository whose database can be taken down: passes, which start by
+// reclaiming leases, and pings fail while it is down. Every pass and ping is sent to events.
+type downRepository struct {
+	*repository.MemoryImageRepository
+	events chan string
+
+	mu   sync.Mutex
+	down bool
+}
+
+func (r *downRepository) setDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+// event sends what happened and returns the database error, if any
+func (r *downRepository) event(what string) error {
+	r.mu.Lock()
+	down := r.down
+	r.mu.Unlock()
+	r.events <- what
+	if down {
+		return errDatabaseDown
+	}
+	return nil
+}
+
+func (r *downRepository) ReclaimExpiredLeases(ctx context.Context) (int, error) {
+	if err := r.event("pass"); err != nil {
+		return 0, err
+	}
+	return r.MemoryImageRepository.ReclaimExpiredLeases(ctx)
+}
+
+func (r *downRepository) Ping(ctx context.Context) error {
+	return r.event("ping")
+}
+
+// degradedRecorder is a healthRecorder that records degradations instead of failing the test
+type degradedRecorder struct {
+	*healthRecorder
+
+	mu       sync.Mutex
+	degraded []error
+}
+
+func (h *degradedRecorder) Degraded(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.degraded = append(h.degraded, err)
+}
+
+func TestErrorBackoff(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	repo := &downRepository{MemoryImageRepository: repository.NewMemoryImageRepository(), events: make(chan string, 16), down: true}
+	health := &degradedRecorder{healthRecorder: newHealthRecorder(t)}
+	var logs bytes.Buffer
+	cfg := testConfig()
+	cfg.GeneratorInterval = time.Second
+	cfg.ErrorBackoffMax = 4 * time.Second
+	g := workflows.NewGenerator(repo, testsupport.NewFakeImageGenerationService(), cfg, testOptions(workflows.WithClock(clk),
+		workflows.WithHealth(health), workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	health.next()
+
+	// step advances the clock by a second and checks what the workflow did then, in any order
+	second := 0
+	step := func(want ...string) {
+		t.Helper()
+		second++
+		clk.Advance(time.Second)
+		var got []string
+		for range want {
+			select {
+			case e := <-repo.events:
+				got = append(got, e)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("at %ds got %v within 5s, want %v", second, got, want)
+			}
+		}
+		select {
+		case e := <-repo.events:
+			t.Fatalf("at %ds got %v and %s, want %v", second, got, e, want)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if !sameEvents(got, want) {
+			t.Fatalf("at %ds got %v, want %v", second, got, want)
+		}
+		// Wait for the next pass to be scheduled again, beside the pings
+		clk.BlockUntil(2)
+	}
+
+	// Failing passes double the interval up to ErrorBackoffMax, with a ping every second
+	clk.BlockUntil(1)
+	step("pass")
+	step("ping")
+	step("ping", "pass")
+	step("ping")
+	step("ping")
+	step("ping")
+	step("ping", "pass")
+
+	// Once the database is back, the next ping runs a pass instead of waiting out the backoff
+	repo.setDown(false)
+	clk.Advance(time.Second)
+	for _, want := range []string{"ping", "pass"} {
+		select {
+		case e := <-repo.events:
+			if e != want {
+				t.Fatalf("after the recovery got %s, want %s", e, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s within 5s of the recovery", want)
+		}
+	}
+	if d := health.next(); d != time.Second {
+		t.Errorf("next pass after the recovery due in %v, want the base interval", d)
+	}
+
+	health.mu.Lock()
+	if len(health.degraded) != 1 || !errors.Is(health.degraded[0], errDatabaseDown) {
+		t.Errorf("reported degradations %v, want one for the unreachable database", health.degraded)
+	}
+	health.mu.Unlock()
+	for _, msg := range []string{"Workflow degraded", "Workflow recovered"} {
+		if n := strings.Count(logs.String(), msg); n != 1 {
+			t.Errorf("logged %q %d times, want once\n%s", msg, n, logs.String())
+		}
+	}
+}
+
+// sameEvents reports whether got holds the events of want, in any order
+func sameEvents(got, want []string) bool {
+	count := make(map[string]int)
+	for _, e := range got {
+		count[e]++
+	}
+	for _, e := range want {
+		count[e]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package workflows_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestGeneratorFailsInvalidSizeOverrides(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		snap          bool
+		want          domain.ImageStatus
+	}{
+		{name: "valid", width: 512, height: 512, want: domain.StatusReadyToPublish},
+		{name: "boundaries", width: 128, height: 2048, want: domain.StatusReadyToPublish},
+		{name: "off the grid", width: 500, height: 512, want: domain.StatusFailed},
+		{name: "too large", width: 512, height: 2112, want: domain.StatusFailed},
+		{name: "snapped", width: 500, height: 2112, snap: true, want: domain.StatusReadyToPublish},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			provider := testsupport.NewFakeProvider("fake")
+			svc := service.NewImageGenerationService(provider, service.Config{
+				DefaultImageWidth:  1024,
+				DefaultImageHeight: 1024,
+				DefaultNumImages:   1,
+				MaxPromptLength:    1000,
+				SnapDimensions:     tt.snap,
+				CheckInterval:      time.Second,
+				GenerationTimeout:  time.Minute,
+			})
+			id, err := repo.CreateImage(context.Background(), "a lighthouse", repository.WithSize(tt.width, tt.height))
+			if err != nil {
+				t.Fatalf("CreateImage() error = %v", err)
+			}
+			workflows.NewGenerator(repo, svc, testConfig(), testOptions()...).RunOnce(context.Background())
+
+			img := wantStatus(t, repo, id, tt.want)
+			submitted := len(provider.Calls())
+			if tt.want == domain.StatusFailed {
+				// The image fails with a clear message before any request is sent
+				if !strings.Contains(img.ErrorDescription, "invalid image dimensions") || submitted != 0 {
+					t.Errorf("image failed with %q after %d requests, want the dimensions named and no request", img.ErrorDescription, submitted)
+				}
+				return
+			}
+			if submitted != 1 {
+				t.Errorf("provider received %d requests, want 1", submitted)
+			}
+		})
+	}
+}
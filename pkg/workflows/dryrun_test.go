@@ -0,0 +1,97 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// repoState is everything the workflows could write for a set of images
+type repoState struct {
+	Images   []*domain.Image
+	Events   [][]domain.ImageEvent
+	History  [][]domain.PromptRevision
+	Variants [][]domain.ImageVariant
+	Usage    []domain.ProviderUsage
+	Controls []domain.WorkflowControl
+}
+
+// snapshot reads the state of the images with the given IDs
+func snapshot(t *testing.T, repo *repository.MemoryImageRepository, ids []int) repoState {
+	t.Helper()
+	ctx := context.Background()
+	var state repoState
+	for _, id := range ids {
+		state.Images = append(state.Images, getImage(t, repo, id))
+		events, _ := repo.GetEvents(ctx, id)
+		history, _ := repo.GetPromptHistory(ctx, id)
+		variants, _ := repo.ListVariants(ctx, id)
+		state.Events = append(state.Events, events)
+		state.History = append(state.History, history)
+		state.Variants = append(state.Variants, variants)
+	}
+	state.Usage, _ = repo.GetUsage(ctx, time.Now())
+	state.Controls, _ = repo.GetControls(ctx)
+	return state
+}
+
+func TestDryRunMakesNoWrites(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ctx := context.Background()
+	queued := createImages(t, repo, "a lighthouse", strings.Repeat("a very long prompt ", 100))
+	generating := createImages(t, repo, "a harbour")
+	repo.UpdateStatus(ctx, generating[0], domain.StatusGenerate)
+	repo.UpdateUUID(ctx, generating[0], "submitted-before-the-dry-run")
+	ids := append(queued, generating...)
+	before := snapshot(t, repo, ids)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	readOnly := repository.NewReadOnly(repo, logger)
+	cfg := testConfig()
+	cfg.MaxPromptLength = 100
+	svc := service.NewImageGenerationService(service.NewDryRunProvider(logger), service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    100,
+		CheckInterval:      time.Millisecond,
+		PollMaxInterval:    time.Millisecond,
+		GenerationTimeout:  time.Minute,
+	})
+
+	// The generator and the processor run their real code paths against the decorator
+	if _, err := workflows.NewGenerator(readOnly, svc, cfg, testOptions()...).RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := workflows.NewProcessor(readOnly, svc, cfg, testOptions()...).RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	if after := snapshot(t, repo, ids); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run changed the repository:\nbefore %+v\nafter  %+v", before, after)
+	}
+	out := logs.String()
+	if n := strings.Count(out, "would submit request"); n != len(queued) {
+		t.Errorf("logged %d submissions, want %d:\n%s", n, len(queued), out)
+	}
+	// The request is logged the way it would be sent, after truncation
+	if !strings.Contains(out, `prompt="a lighthouse"`) || strings.Contains(out, strings.Repeat("a very long prompt ", 10)) {
+		t.Errorf("logged requests are not the truncated prompts:\n%s", out)
+	}
+	if !strings.Contains(out, `would check generation status" uuid=submitted-before-the-dry-run`) {
+		t.Errorf("processor did not log the UUID it would check:\n%s", out)
+	}
+	if !strings.Contains(out, "Dry run: skipped write") {
+		t.Errorf("no skipped writes logged:\n%s", out)
+	}
+}
@@ -0,0 +1,148 @@
+package workflows_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// capturedError is an error sent to a reportRecorder with its tags
+type capturedError struct {
+	err  error
+	tags map[string]string
+}
+
+// reportRecorder is an ErrorReporter that records the errors it is sent
+type reportRecorder struct {
+	mu       sync.Mutex
+	captured []capturedError
+}
+
+func (r *reportRecorder) CaptureError(_ context.Context, err error, tags map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.captured = append(r.captured, capturedError{err: err, tags: tags})
+}
+
+// only returns the single error recorded, failing the test if there is not exactly one
+func (r *reportRecorder) only(t *testing.T) capturedError {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.captured) != 1 {
+		t.Fatalf("captured %d errors, want 1: %v", len(r.captured), r.captured)
+	}
+	return r.captured[0]
+}
+
+// wantReported checks that got was reported by workflow for image id of provider, within one of
+// traces and without prompt in its message
+func wantReported(t *testing.T, got capturedError, workflow string, id int, provider, prompt string, traces map[string]bool) {
+	t.Helper()
+	if msg := got.err.Error(); strings.Contains(msg, prompt) {
+		t.Errorf("reported error %q, want the prompt cut", msg)
+	}
+	want := map[string]string{"workflow": workflow, "image_id": strconv.Itoa(id), "provider": provider}
+	for key, value := range want {
+		if got.tags[key] != value {
+			t.Errorf("reported tag %s = %q, want %q", key, got.tags[key], value)
+		}
+	}
+	if trace := got.tags["trace_id"]; !traces[trace] {
+		t.Errorf("reported trace_id = %q, want the trace of the pass", trace)
+	}
+	if len(got.tags) != len(want)+1 {
+		t.Errorf("reported tags = %v, want only workflow, image_id, provider and trace_id", got.tags)
+	}
+}
+
+// rejectedChecks is a fake service whose status checks fail for good, quoting the prompt of the
+// generation as some providers do
+type rejectedChecks struct {
+	*testsupport.FakeImageGenerationService
+	prompt string
+}
+
+func (s *rejectedChecks) CheckGenerationStatus(context.Context, string) (*domain.ImageGenerationResponse, error) {
+	return nil, fmt.Errorf("%w: cannot render %s", domain.ErrInvalidRequest, s.prompt)
+}
+
+// traceIDs returns the IDs of the traces spans belong to
+func traceIDs(spans tracetest.SpanStubs) map[string]bool {
+	ids := make(map[string]bool)
+	for _, s := range spans {
+		ids[s.SpanContext.TraceID().String()] = true
+	}
+	return ids
+}
+
+func TestErrorReportTags(t *testing.T) {
+	const prompt = "a lighthouse by the harbour"
+
+	t.Run("generator", func(t *testing.T) {
+		exporter := recordSpans(t)
+		repo := repository.NewMemoryImageRepository()
+		svc := testsupport.NewFakeImageGenerationService()
+		svc.On(prompt, testsupport.SubmitError(fmt.Errorf("%w: %s is not allowed", domain.ErrInvalidPrompt, prompt)))
+		reports := &reportRecorder{}
+		g := workflows.NewGenerator(repo, svc, testConfig(), testOptions(workflows.WithErrorReporter(reports))...)
+		id := createImages(t, repo, prompt)[0]
+
+		g.RunOnce(context.Background())
+		wantStatus(t, repo, id, domain.StatusFailed)
+		got := reports.only(t)
+		if !strings.Contains(got.err.Error(), "[prompt] is not allowed") {
+			t.Errorf("reported error %q, want the prompt replaced", got.err)
+		}
+		wantReported(t, got, "generator", id, "", prompt, traceIDs(exporter.GetSpans()))
+	})
+
+	t.Run("processor", func(t *testing.T) {
+		exporter := recordSpans(t)
+		repo := repository.NewMemoryImageRepository()
+		fake := testsupport.NewFakeImageGenerationService()
+		fake.Default = testsupport.DoneAfter(1)
+		svc := &rejectedChecks{FakeImageGenerationService: fake, prompt: prompt}
+		reports := &reportRecorder{}
+		opts := testOptions(workflows.WithErrorReporter(reports))
+		g := workflows.NewGenerator(repo, svc, testConfig(), opts...)
+		p := workflows.NewProcessor(repo, svc, testConfig(), opts...)
+		id := createImages(t, repo, prompt)[0]
+
+		if _, err := g.RunOnce(context.Background()); err != nil {
+			t.Fatalf("generator RunOnce() error = %v", err)
+		}
+		p.RunOnce(context.Background())
+		wantStatus(t, repo, id, domain.StatusFailed)
+		got := reports.only(t)
+		if !strings.Contains(got.err.Error(), "cannot render [prompt]") {
+			t.Errorf("reported error %q, want the prompt replaced", got.err)
+		}
+		wantReported(t, got, "processor", id, "fake", prompt, traceIDs(exporter.GetSpans()))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		exporter := recordSpans(t)
+		repo := repository.NewMemoryImageRepository()
+		svc := &panickingService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService()}
+		reports := &reportRecorder{}
+		g := workflows.NewGenerator(repo, svc, testConfig(), testOptions(workflows.WithErrorReporter(reports))...)
+		id := createImages(t, repo, panicPrompt)[0]
+
+		g.RunOnce(context.Background())
+		got := reports.only(t)
+		if !strings.HasPrefix(got.err.Error(), "panic: ") {
+			t.Errorf("reported error %q, want the panic", got.err)
+		}
+		wantReported(t, got, "generator", id, "", panicPrompt, traceIDs(exporter.GetSpans()))
+	})
+}
@@ -0,0 +1,83 @@
+package workflows_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// transition is a status event without its IDs, reason and time
+type transition struct {
+	From, To domain.ImageStatus
+	Actor    string
+}
+
+// trail returns the status transitions of the image with the given ID, checking they are
+// ordered by time
+func trail(t *testing.T, repo repository.ImageRepository, id int) []transition {
+	t.Helper()
+	events, err := repo.GetEvents(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetEvents(%d) error = %v", id, err)
+	}
+	var got []transition
+	for i, e := range events {
+		if e.ImageID != id {
+			t.Errorf("event %d of image %d belongs to image %d", i, id, e.ImageID)
+		}
+		if i > 0 && e.CreatedAt.Before(events[i-1].CreatedAt) {
+			t.Errorf("event %d of image %d at %v precedes the one before at %v", i, id, e.CreatedAt, events[i-1].CreatedAt)
+		}
+		got = append(got, transition{From: e.From, To: e.To, Actor: e.Actor})
+	}
+	return got
+}
+
+func TestEventTrailOfGeneration(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.On("a lighthouse", testsupport.DoneAfter(1))
+	fake.On("a harbour", testsupport.Failed("internal error"))
+	ids := createImages(t, repo, "a lighthouse", "a harbour")
+	cfg := testConfig()
+	ctx := context.Background()
+
+	if _, err := workflows.NewGenerator(repo, fake, cfg, testOptions()...).RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := workflows.NewProcessor(repo, fake, cfg, testOptions()...).RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	tests := []struct {
+		id   int
+		want []transition
+	}{
+		{id: ids[0], want: []transition{
+			{From: domain.StatusReadyToGenerate, To: domain.StatusClaimed, Actor: "generator"},
+			{From: domain.StatusClaimed, To: domain.StatusGenerate, Actor: "generator"},
+			{From: domain.StatusGenerate, To: domain.StatusReadyToPublish, Actor: "processor"},
+		}},
+		{id: ids[1], want: []transition{
+			{From: domain.StatusReadyToGenerate, To: domain.StatusClaimed, Actor: "generator"},
+			{From: domain.StatusClaimed, To: domain.StatusGenerate, Actor: "generator"},
+			{From: domain.StatusGenerate, To: domain.StatusFailed, Actor: "processor"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := trail(t, repo, tt.id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("image %d trail = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+
+	// The failure records its reason
+	events, _ := repo.GetEvents(ctx, ids[1])
+	if last := events[len(events)-1]; last.Reason == "" {
+		t.Errorf("failure event = %+v, want the error as its reason", last)
+	}
+}
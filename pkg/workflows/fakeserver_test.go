@@ -0,0 +1,82 @@
+package workflows_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// fakeServerPipeline returns a generator and a processor using the real client against srv
+func fakeServerPipeline(srv *fakeserver.Server, repo repository.ImageRepository) (*workflows.Generator, *workflows.Processor) {
+	client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL))
+	svc := service.NewImageGenerationService(client, service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		// Each status request is bounded by CheckInterval, well above the latency under test
+		CheckInterval:     time.Second,
+		PollMaxInterval:   time.Second,
+		GenerationTimeout: time.Minute,
+	})
+	cfg := testConfig()
+	return workflows.NewGenerator(repo, svc, cfg, testOptions()...), workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+}
+
+func TestPipelineAgainstFakeServer(t *testing.T) {
+	srv := fakeserver.New(fakeserver.WithCredentials("key", "secret"))
+	defer srv.Close()
+	srv.Enqueue(fakeserver.Initial(), fakeserver.Processing(), fakeserver.Done(fakeserver.Pixel, fakeserver.Pixel))
+	srv.Enqueue(fakeserver.Initial(), fakeserver.Fail("internal error"))
+	// The first image is throttled on its first submission and takes no progression
+	srv.FailNext(fakeserver.EndpointRun, http.StatusTooManyRequests, 1)
+	srv.SetLatency(fakeserver.EndpointStatus, 2*time.Millisecond)
+
+	repo := repository.NewMemoryImageRepository()
+	g, p := fakeServerPipeline(srv, repo)
+	ids := createImages(t, repo, "a throttled lighthouse", "a lighthouse", "a broken lighthouse")
+
+	ctx := context.Background()
+	if _, err := g.RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+	// The next pass submits the throttled image
+	if _, err := g.RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	// A single pass checks every generation through to its last step
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	if img := wantStatus(t, repo, ids[0], domain.StatusReadyToPublish); img.Variants != 1 || img.UUID != "fake-3" {
+		t.Errorf("image %d = %d variants of %s, want 1 of fake-3", ids[0], img.Variants, img.UUID)
+	}
+	if img := wantStatus(t, repo, ids[1], domain.StatusReadyToPublish); img.Variants != 2 || img.UUID != "fake-1" {
+		t.Errorf("image %d = %d variants of %s, want 2 of fake-1", ids[1], img.Variants, img.UUID)
+	}
+	if img := wantStatus(t, repo, ids[2], domain.StatusFailed); img.ErrorDescription == "" {
+		t.Errorf("image %d has no error description, want the failure of the provider", ids[2])
+	}
+
+	// Every run request carried the prompt of its image
+	runs := srv.Runs()
+	if len(runs) != 4 {
+		t.Fatalf("server received %d run requests, want 4 with the throttled one", len(runs))
+	}
+	for i, want := range []string{"a throttled lighthouse", "a lighthouse", "a broken lighthouse", "a throttled lighthouse"} {
+		params, _ := runs[i].Params["generateParams"].(map[string]interface{})
+		if params["query"] != want {
+			t.Errorf("run %d query = %v, want %q", i, params["query"], want)
+		}
+	}
+}
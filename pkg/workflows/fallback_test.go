@@ -0,0 +1,145 @@
+package workflows_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// chainService returns the service of a chain of the given providers
+func chainService(providers ...domain.ImageProvider) *service.ImageGenerationService {
+	return service.NewImageGenerationService(service.NewFallbackProvider(providers...), service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      time.Second,
+		PollMaxInterval:    time.Second,
+		GenerationTimeout:  time.Minute,
+	})
+}
+
+// wantResult fails the test unless the image with the given ID was generated by provider and its
+// result saved
+func wantResult(t *testing.T, repo *repository.MemoryImageRepository, id int, provider string) {
+	t.Helper()
+	img := wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	if img.Provider != provider {
+		t.Errorf("image %d was generated by %q, want %q", id, img.Provider, provider)
+	}
+	if result, err := repo.GetResult(context.Background(), id); err != nil || result == nil {
+		t.Errorf("GetResult(%d) = %v, %v, want the saved result", id, result, err)
+	}
+}
+
+func TestFallbackWhenFirstProviderIsUnavailable(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse")
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.SubmitError(statusError(http.StatusServiceUnavailable))
+	second := testsupport.NewFakeProvider("second")
+
+	g := workflows.NewGenerator(repo, chainService(first, second), testConfig(), testOptions()...)
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	wantResult(t, repo, ids[0], "second")
+}
+
+func TestFallbackWhenFirstProviderIsRateLimited(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse")
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.SubmitError(statusError(http.StatusTooManyRequests))
+	second := testsupport.NewFakeProvider("second")
+
+	g := workflows.NewGenerator(repo, chainService(first, second), testConfig(), testOptions()...)
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	// A rate limit is waited out instead of spending the quota of the next provider
+	wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+	if calls := len(second.Calls()); calls != 0 {
+		t.Errorf("second provider received %d calls, want none", calls)
+	}
+}
+
+func TestFallbackAfterGenerationFails(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		outcome testsupport.Outcome
+		// calls is the number of calls to the first provider: the submission and status checks
+		calls int
+	}{
+		{name: "failed", outcome: testsupport.Failed("internal error"), calls: 2},
+		{name: "censored", outcome: testsupport.Outcome{Polls: 1, Files: []string{"aGk="}, Censored: true}, calls: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			ids := createImages(t, repo, "a lighthouse")
+			first := testsupport.NewFakeProvider("first")
+			first.Default = tt.outcome
+			second := testsupport.NewFakeProvider("second")
+			second.Default = testsupport.DoneAfter(1)
+			svc := chainService(first, second)
+			g := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...)
+			p := workflows.NewProcessor(repo, svc, testConfig(), testOptions()...)
+
+			if _, err := g.RunOnce(context.Background()); err != nil {
+				t.Fatalf("generator RunOnce() error = %v", err)
+			}
+			if img := wantStatus(t, repo, ids[0], domain.StatusGenerate); img.Provider != "first" {
+				t.Fatalf("image submitted to %q, want first", img.Provider)
+			}
+
+			// The processor requeues the image for the next provider instead of failing it
+			if _, err := p.RunOnce(context.Background()); err != nil {
+				t.Fatalf("processor RunOnce() error = %v", err)
+			}
+			img := wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+			if img.Provider != "second" || img.UUID != "" || img.Censored {
+				t.Fatalf("requeued image = %+v, want it queued for second without a UUID", img)
+			}
+
+			if _, err := g.RunOnce(context.Background()); err != nil {
+				t.Fatalf("generator RunOnce() error = %v", err)
+			}
+			if _, err := p.RunOnce(context.Background()); err != nil {
+				t.Fatalf("processor RunOnce() error = %v", err)
+			}
+			wantResult(t, repo, ids[0], "second")
+			if calls := len(first.Calls()); calls != tt.calls {
+				t.Errorf("first provider received %d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestFallbackEndsAtLastProvider(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse")
+	first := testsupport.NewFakeProvider("first")
+	first.Default = testsupport.Failed("internal error")
+	second := testsupport.NewFakeProvider("second")
+	second.Default = testsupport.Failed("internal error")
+	svc := chainService(first, second)
+	g := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...)
+	p := workflows.NewProcessor(repo, svc, testConfig(), testOptions()...)
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.RunOnce(context.Background()); err != nil {
+			t.Fatalf("generator RunOnce() error = %v", err)
+		}
+		p.RunOnce(context.Background())
+	}
+	if img := wantStatus(t, repo, ids[0], domain.StatusFailed); img.Provider != "second" {
+		t.Errorf("image failed at %q, want second", img.Provider)
+	}
+}
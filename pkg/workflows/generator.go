@@ -0,0 +1,283 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/internal/textutil"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/prompts"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Generator submits the images ready for generation to the image generation service
+type Generator struct {
+	*runner
+	service domain.ImageGenerationService
+	budget  *dailyBudget
+}
+
+// NewGenerator creates the generator workflow, submitting the images queued in repo to service
+func NewGenerator(repo repository.ImageRepository, service domain.ImageGenerationService, cfg Config, opts ...Option) *Generator {
+	r := newRunner("generator", repo, cfg, opts)
+	return &Generator{runner: r, service: service, budget: newDailyBudget(r)}
+}
+
+// Run runs a pass every GeneratorInterval until ctx is cancelled, backing off while idle or
+// failing. When the repository notifies about new images, a pass also runs as soon as they are
+// queued; without notifications the generator only polls.
+func (g *Generator) Run(ctx context.Context) {
+	wake, err := g.repo.ListenForNewImages(ctx)
+	switch {
+	case errors.Is(err, repository.ErrNotificationsUnavailable):
+		g.log.Debug("New image notifications unavailable, polling only", "workflow", g.name)
+	case err != nil:
+		g.log.Warn("Error listening for new images, polling only", "workflow", g.name, "error", err)
+	}
+
+	g.pollLoop(ctx, g.cfg.GeneratorInterval, wake, func() (Summary, error) {
+		return g.RunOnce(ctx)
+	})
+	g.log.Info("Image generation workflow stopped", "workflow", g.name)
+}
+
+// RunOnce claims up to BatchSize images ready for generation and submits them once, unless the
+// generator is paused. Claims of workers that stopped renewing them are returned to the queue
+// first. No more images are claimed than the daily budget allows today. Cancelling ctx stops
+// submitting more images, while the submissions in flight finish under its WorkContext.
+func (g *Generator) RunOnce(ctx context.Context) (Summary, error) {
+	return g.runUnlessPaused(ctx, func() (Summary, error) {
+		return g.runOnce(ctx)
+	})
+}
+
+// runOnce runs a single pass of the generator
+func (g *Generator) runOnce(ctx context.Context) (summary Summary, err error) {
+	outcomes := g.outcomes.startPass(g.name)
+	defer func() { summary = outcomes.finishPass() }()
+	work, span := tracing.Start(repository.ContextWithActor(WorkContext(ctx), g.name), g.passSpan)
+	defer func() { tracing.End(span, err) }()
+
+	if n, err := g.repo.ReclaimExpiredLeases(work); err != nil {
+		return summary, fmt.Errorf("failed to reclaim expired leases: %w", err)
+	} else if n > 0 {
+		g.log.Warn("Returned images with expired claims to the queue", "workflow", g.name, "images", n)
+	}
+
+	// Once the daily budget is used up, images stay queued until midnight UTC
+	limit := g.cfg.BatchSize
+	left, err := g.budget.remaining(work)
+	if err != nil {
+		return summary, fmt.Errorf("failed to check the daily budget: %w", err)
+	}
+	if left == 0 {
+		return summary, nil
+	}
+	if left > 0 && (limit == 0 || left < limit) {
+		limit = left
+	}
+
+	// Claim the images ready for generation; the claims are renewed until each image is handled
+	// and released when the pass ends early
+	images, err := g.repo.ClaimForGeneration(work, limit, g.cfg.WorkerID, g.cfg.ClaimLease)
+	if err != nil {
+		return summary, fmt.Errorf("failed to claim ready images: %w", err)
+	}
+
+	if len(images) == 0 {
+		return summary, nil
+	}
+	leases := g.holdLeases(work, images)
+	defer leases.close(work)
+
+	// Banned terms are reloaded every run so edits to the table apply without a restart
+	filter, err := g.loadPromptFilter(work)
+	if err != nil {
+		return summary, fmt.Errorf("failed to load banned terms: %w", err)
+	}
+
+	// Submit images in parallel; each image is handled independently within PerImageTimeout.
+	// Cancelling ctx, or the provider rejecting the credentials, stops submitting more images,
+	// while submissions in flight finish under the work context.
+	dispatch, auth := newAuthGate(ctx)
+	runPool(dispatch, images, g.cfg.Concurrency, func(img *domain.Image) {
+		err := g.handleRecovered(work, outcomes, img, func(ctx context.Context) error {
+			return g.handleWithDeadline(ctx, outcomes, img, func(ctx context.Context) error {
+				return g.generateImage(ctx, outcomes, auth, filter, img)
+			})
+		})
+		leases.release(work, img)
+		outcomes.record(img, err)
+	})
+
+	return summary, auth.close()
+}
+
+// loadPromptFilter compiles the banned terms of PromptRejectTerms and the banned_terms table
+func (g *Generator) loadPromptFilter(ctx context.Context) (*prompts.Filter, error) {
+	terms, err := g.repo.ListBannedTerms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return prompts.NewFilter(append(terms, g.cfg.PromptRejectTerms...))
+}
+
+// generateImage submits a single image to the provider and records the outcome.
+// It returns an error when the image could not be handled, including permanent provider failures.
+// Rejected credentials leave the image queued and trip auth.
+func (g *Generator) generateImage(ctx context.Context, outcomes *outcomeReporter, auth *authGate, filter *prompts.Filter, img *domain.Image) error {
+	log := tracing.Logger(ctx, g.log).With("workflow", g.name, "image_id", img.ID)
+
+	// Reject prompts the provider is known to censor before spending quota on them
+	if term, ok := filter.Match(img.Prompt); ok {
+		log.Info("Image rejected, prompt contains banned term", "term", term)
+		if err := g.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusRejected, fmt.Sprintf("prompt contains banned term %q", term)); err != nil {
+			return fmt.Errorf("failed to update status: %w", err)
+		}
+		return nil
+	}
+
+	// Skip prompts that were already requested
+	if skip, err := g.deduplicateImage(ctx, img); skip || err != nil {
+		return err
+	}
+
+	// Truncate prompt if it exceeds the maximum length
+	if length := utf8.RuneCountInString(img.Prompt); length > g.cfg.MaxPromptLength {
+		img.Prompt = textutil.TruncateRunes(img.Prompt, g.cfg.MaxPromptLength)
+		log.Info("Prompt was truncated", "from_characters", length, "to_characters", utf8.RuneCountInString(img.Prompt))
+		g.recordPromptRevision(ctx, img, img.Prompt, domain.PromptSourceTruncation)
+	}
+
+	log.Info("Processing image", "prompt", img.Prompt)
+
+	// Create image generation request
+	req := g.generationRequest(img)
+	if img.Censored {
+		log.Info("Image was censored before, retrying with a strengthened negative prompt")
+	}
+
+	// Generate image; a provider chain starts at the provider stored with the image, the next
+	// one after a generation that was censored or failed
+	img.GenerationStartedAt = g.clk.Now()
+	resp, err := g.service.GenerateImage(domain.ContextWithProvider(ctx, img.Provider), req)
+	if err != nil {
+		if errclass.IsRetryable(err) {
+			log.Warn("Retryable error generating image, leaving it queued", "error", err)
+			outcomes.skipped()
+			return nil
+		}
+		if errclass.IsAuth(err) {
+			log.Error("Provider rejected the credentials, leaving the image queued", "error", err)
+			outcomes.skipped()
+			auth.trip(err)
+			return nil
+		}
+
+		if updateErr := g.repo.MarkFailed(ctx, img.ID, err.Error(), errclass.Class(err)); updateErr != nil {
+			return fmt.Errorf("failed to update status after %v: %w", err, updateErr)
+		}
+		outcomes.report(ctx, img, domain.StatusFailed, err.Error())
+		g.captureError(ctx, img, err)
+		return err
+	}
+	outcomes.submitted()
+
+	if err := g.repo.UpdateProvider(ctx, img.ID, resp.Provider); err != nil {
+		return fmt.Errorf("failed to update provider: %w", err)
+	}
+	if resp.Prompt != "" && resp.Prompt != img.Prompt {
+		g.recordPromptRevision(ctx, img, resp.Prompt, domain.PromptSourceProcessor)
+	}
+
+	if resp.Censored {
+		if err := g.repo.UpdateUUID(ctx, img.ID, resp.UUID); err != nil {
+			return fmt.Errorf("failed to update UUID: %w", err)
+		}
+		return g.handleCensored(ctx, outcomes, img, resp)
+	}
+
+	// Synchronous providers return the finished image right away
+	if resp.Status == "DONE" && len(resp.Files) > 0 {
+		if err := g.repo.UpdateUUID(ctx, img.ID, resp.UUID); err != nil {
+			return fmt.Errorf("failed to update UUID: %w", err)
+		}
+		img.Provider = resp.Provider
+		img.Metadata.GenerationDuration = g.generationTime(img, resp)
+		if err := g.results.Save(ctx, img, resp.UUID, resp.Files); err != nil {
+			return fmt.Errorf("failed to save results: %w", err)
+		}
+		if err := g.repo.UpdateStatus(ctx, img.ID, domain.StatusReadyToPublish); err != nil {
+			return fmt.Errorf("failed to update status: %w", err)
+		}
+		img.UUID = resp.UUID
+		outcomes.observeGeneration(img.Provider, img.Metadata.GenerationDuration)
+		outcomes.report(ctx, img, domain.StatusReadyToPublish, "")
+		log.Info("Image generated synchronously and marked as ready to publish", "uuid", img.UUID)
+		return nil
+	}
+
+	// Handle successful response with UUID
+	log.Debug("Image generation initiated", "uuid", resp.UUID)
+
+	// Update image UUID
+	if err := g.repo.UpdateUUID(ctx, img.ID, resp.UUID); err != nil {
+		return fmt.Errorf("failed to update UUID: %w", err)
+	}
+
+	// Update status to Generate
+	if err := g.repo.UpdateStatus(ctx, img.ID, domain.StatusGenerate); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+
+	log.Info("Successfully initiated generation", "uuid", resp.UUID)
+	return nil
+}
+
+// recordPromptRevision records text, the prompt of img rewritten by source, in its prompt
+// history. Failures are only logged since the history does not affect generation.
+func (r *runner) recordPromptRevision(ctx context.Context, img *domain.Image, text string, source domain.PromptSource) {
+	revision, err := r.repo.AppendPromptRevision(ctx, img.ID, text, source)
+	if err != nil {
+		r.log.Warn("Error recording prompt revision", "image_id", img.ID, "source", source, "error", err)
+		return
+	}
+	r.log.Debug("Prompt revision recorded", "image_id", img.ID, "source", source, "revision", revision)
+}
+
+// generationRequest returns the request img is submitted with: its prompt, truncated to
+// MaxPromptLength, and its parameters, falling back to the configured defaults. An image that
+// was censored before gets a strengthened negative prompt. The processor rebuilds the request of
+// a finished generation from the image to cache its result.
+func (r *runner) generationRequest(img *domain.Image) domain.ImageGenerationRequest {
+	req := domain.ImageGenerationRequest{
+		Prompt:         textutil.TruncateRunes(img.Prompt, r.cfg.MaxPromptLength),
+		Width:          r.cfg.DefaultImageWidth,
+		Height:         r.cfg.DefaultImageHeight,
+		NumImages:      r.cfg.DefaultNumImages,
+		Style:          r.cfg.DefaultStyle,
+		NegativePrompt: r.cfg.DefaultNegativePrompt,
+		Seed:           img.Seed,
+		Extra:          img.Params,
+	}
+	if img.Width != 0 {
+		req.Width = img.Width
+	}
+	if img.Height != 0 {
+		req.Height = img.Height
+	}
+	if img.Style != "" {
+		req.Style = img.Style
+	}
+	if img.NegativePrompt != "" {
+		req.NegativePrompt = img.NegativePrompt
+	}
+	if img.Censored {
+		req.NegativePrompt = strengthenNegativePrompt(req.NegativePrompt, r.cfg.CensoredNegativePrompt)
+	}
+	return req
+}
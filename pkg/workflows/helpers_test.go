@@ -0,0 +1,71 @@
+package workflows_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// statusError is a provider error carrying an HTTP status, like the API errors of the clients
+type statusError int
+
+func (e statusError) Error() string   { return "unexpected status code" }
+func (e statusError) HTTPStatus() int { return int(e) }
+func (e statusError) Unwrap() error   { return domain.ErrorForStatus(int(e)) }
+
+// testConfig returns the default configuration with waits short enough for tests
+func testConfig() workflows.Config {
+	cfg := workflows.DefaultConfig()
+	cfg.WorkerID = "test"
+	cfg.Concurrency = 1
+	cfg.CheckInterval = time.Millisecond
+	cfg.PollMaxInterval = time.Millisecond
+	cfg.MaxAttempts = 3
+	cfg.PerImageTimeout = 5 * time.Second
+	return cfg
+}
+
+// testOptions are the options of every workflow under test, with logging discarded
+func testOptions(opts ...workflows.Option) []workflows.Option {
+	return append([]workflows.Option{workflows.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+}
+
+// createImages queues an image per prompt and returns their IDs
+func createImages(t *testing.T, repo repository.ImageRepository, prompts ...string) []int {
+	t.Helper()
+	ids := make([]int, len(prompts))
+	for i, prompt := range prompts {
+		id, err := repo.CreateImage(context.Background(), prompt)
+		if err != nil {
+			t.Fatalf("CreateImage(%q) error = %v", prompt, err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// getImage returns the image with the given ID
+func getImage(t *testing.T, repo repository.ImageRepository, id int) *domain.Image {
+	t.Helper()
+	img, err := repo.GetImage(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetImage(%d) error = %v", id, err)
+	}
+	return img
+}
+
+// wantStatus fails the test unless the image with the given ID has status want
+func wantStatus(t *testing.T, repo repository.ImageRepository, id int, want domain.ImageStatus) *domain.Image {
+	t.Helper()
+	img := getImage(t, repo, id)
+	if img.Status != want {
+		t.Fatalf("image %d has status %s (%s), want %s", id, img.Status, img.ErrorDescription, want)
+	}
+	return img
+}
@@ -0,0 +1,120 @@
+package workflows_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// revision is a prompt revision without its IDs and time
+type revision struct {
+	Source domain.PromptSource
+	Text   string
+}
+
+// history returns the prompt revisions of the image with the given ID, checking they are
+// numbered from 1
+func history(t *testing.T, repo repository.ImageRepository, id int) []revision {
+	t.Helper()
+	revisions, err := repo.GetPromptHistory(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetPromptHistory(%d) error = %v", id, err)
+	}
+	var got []revision
+	for i, r := range revisions {
+		if r.Revision != i+1 {
+			t.Errorf("revision %d of image %d is numbered %d, want the revisions numbered from 1", i, id, r.Revision)
+		}
+		got = append(got, revision{Source: r.Source, Text: r.Text})
+	}
+	return got
+}
+
+func TestGeneratorRecordsTruncation(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := testsupport.NewFakeImageGenerationService()
+	// The limit counts characters: the Cyrillic prompt has 12 of them in 23 bytes
+	ids := createImages(t, repo, "a lighthouse on a cliff at dawn", "a harbour", "маяк у скалы")
+	cfg := testConfig()
+	cfg.MaxPromptLength = 12
+	if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	tests := []struct {
+		id   int
+		want []revision
+	}{
+		{id: ids[0], want: []revision{
+			{Source: domain.PromptSourceAuthor, Text: "a lighthouse on a cliff at dawn"},
+			{Source: domain.PromptSourceTruncation, Text: "a lighthouse"},
+		}},
+		{id: ids[1], want: []revision{{Source: domain.PromptSourceAuthor, Text: "a harbour"}}},
+		{id: ids[2], want: []revision{{Source: domain.PromptSourceAuthor, Text: "маяк у скалы"}}},
+	}
+	for _, tt := range tests {
+		if got := history(t, repo, tt.id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("image %d history = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+
+	// The truncated prompt is the one submitted
+	if calls := svc.Calls(); len(calls) != 3 || calls[0].Request.Prompt != "a lighthouse" {
+		t.Errorf("service received %+v, want the truncated prompt submitted first", calls)
+	}
+}
+
+// suffixProcessor appends its text to every prompt
+type suffixProcessor string
+
+func (p suffixProcessor) Process(ctx context.Context, prompt string) (string, error) {
+	return prompt + string(p), nil
+}
+
+func TestGeneratorRecordsProcessedPrompt(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	provider := testsupport.NewFakeProvider("fake")
+	svc := service.NewImageGenerationService(provider, service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      time.Millisecond,
+		PollMaxInterval:    time.Millisecond,
+		GenerationTimeout:  time.Minute,
+	}, service.WithPromptProcessors(suffixProcessor(", oil painting")))
+	ids := createImages(t, repo, "a lighthouse on a cliff at dawn")
+	cfg := testConfig()
+	cfg.MaxPromptLength = 12
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	want := []revision{
+		{Source: domain.PromptSourceAuthor, Text: "a lighthouse on a cliff at dawn"},
+		{Source: domain.PromptSourceTruncation, Text: "a lighthouse"},
+		{Source: domain.PromptSourceProcessor, Text: "a lighthouse, oil painting"},
+	}
+	if got := history(t, repo, ids[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %+v, want %+v", got, want)
+	}
+
+	// Generating the image again rewrites the prompt the same way without repeating revisions
+	if err := repo.UpdateStatus(context.Background(), ids[0], domain.StatusReadyToGenerate, repository.Force()); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if got := history(t, repo, ids[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("history after generating again = %+v, want %+v", got, want)
+	}
+}
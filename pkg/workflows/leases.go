@@ -0,0 +1,109 @@
+package workflows
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// leaseHolder renews the claims of a generator pass on its images every third of ClaimLease,
+// so images waiting for their turn in a long batch are not reclaimed by another worker
+type leaseHolder struct {
+	repo repository.ImageRepository
+	cfg  Config
+	log  *slog.Logger
+
+	mu  sync.Mutex
+	ids map[int]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// holdLeases starts renewing the claims on images until close is called
+func (r *runner) holdLeases(ctx context.Context, images []*domain.Image) *leaseHolder {
+	h := &leaseHolder{
+		repo: r.repo,
+		cfg:  r.cfg,
+		log:  r.log,
+		ids:  make(map[int]bool, len(images)),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, img := range images {
+		h.ids[img.ID] = true
+	}
+
+	ticker := r.clk.NewTicker(max(r.cfg.ClaimLease/3, 1))
+	go func() {
+		defer close(h.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.stop:
+				return
+			case <-ticker.C():
+				h.renew(ctx)
+			}
+		}
+	}()
+	return h
+}
+
+// renew extends the claims still held; a claim that was lost is no longer renewed
+func (h *leaseHolder) renew(ctx context.Context) {
+	h.mu.Lock()
+	ids := make([]int, 0, len(h.ids))
+	for id := range h.ids {
+		ids = append(ids, id)
+	}
+	h.mu.Unlock()
+
+	for _, id := range ids {
+		ok, err := h.repo.ExtendLease(ctx, id, h.cfg.WorkerID, h.cfg.ClaimLease)
+		if err != nil {
+			h.log.Error("Error extending lease", "workflow", "generator", "image_id", id, "error", err)
+			continue
+		}
+		if !ok {
+			// The image was submitted, or its lease lapsed and another worker reclaimed it
+			h.mu.Lock()
+			delete(h.ids, id)
+			h.mu.Unlock()
+		}
+	}
+}
+
+// release stops renewing the claim on img and returns the image to ReadyToGenerate if it is
+// still Claimed, e.g. after a retryable error
+func (h *leaseHolder) release(ctx context.Context, img *domain.Image) {
+	h.mu.Lock()
+	delete(h.ids, img.ID)
+	h.mu.Unlock()
+
+	if err := h.repo.ReleaseClaim(ctx, img.ID, h.cfg.WorkerID); err != nil {
+		h.log.Error("Error releasing claim", "workflow", "generator", "image_id", img.ID, "error", err)
+	}
+}
+
+// close stops the renewals and releases the images that were never handled, e.g. because the
+// pass was cancelled
+func (h *leaseHolder) close(ctx context.Context) {
+	close(h.stop)
+	<-h.done
+
+	h.mu.Lock()
+	ids := h.ids
+	h.ids = nil
+	h.mu.Unlock()
+	for id := range ids {
+		if err := h.repo.ReleaseClaim(ctx, id, h.cfg.WorkerID); err != nil {
+			h.log.Error("Error releasing claim", "workflow", "generator", "image_id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestCrashedWorkerImagesPickedUpAfterLease(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse", "a harbour")
+	ctx := context.Background()
+
+	// A worker claims both images and disappears without submitting or releasing them
+	const lease = 50 * time.Millisecond
+	if claimed, err := repo.ClaimForGeneration(ctx, 0, "crashed", lease); err != nil || len(claimed) != 2 {
+		t.Fatalf("ClaimForGeneration() = %d images, %v, want 2", len(claimed), err)
+	}
+
+	g := workflows.NewGenerator(repo, testsupport.NewFakeImageGenerationService(), testConfig(), testOptions()...)
+	summary, err := g.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Images != 0 {
+		t.Errorf("RunOnce() during the lease = %s, want the claimed images left alone", summary)
+	}
+	for _, id := range ids {
+		if img := wantStatus(t, repo, id, domain.StatusClaimed); img.WorkerID != "crashed" {
+			t.Errorf("image %d claimed by %q, want the crashed worker", id, img.WorkerID)
+		}
+	}
+
+	time.Sleep(lease + 10*time.Millisecond)
+	summary, err = g.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Images != 2 || summary.Generated != 2 {
+		t.Errorf("RunOnce() after the lease lapsed = %s, want both images generated", summary)
+	}
+	for _, id := range ids {
+		wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	}
+}
+
+// delayedService is a fake service whose submissions take delay, so a generator pass outlives the
+// lease of its claims unless it renews them
+type delayedService struct {
+	*testsupport.FakeImageGenerationService
+	delay time.Duration
+}
+
+func (s delayedService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+	}
+	return s.FakeImageGenerationService.GenerateImage(ctx, req)
+}
+
+func TestGeneratorRenewsLeasesDuringLongPass(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse", "a harbour", "a lighthouse at night")
+	cfg := testConfig()
+	cfg.ClaimLease = 60 * time.Millisecond
+	svc := delayedService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService(), delay: 50 * time.Millisecond}
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+
+	done := make(chan struct{})
+	var summary workflows.Summary
+	var err error
+	go func() {
+		defer close(done)
+		summary, err = g.RunOnce(context.Background())
+	}()
+
+	// Another worker keeps trying to take over the images while the pass handles them one at
+	// a time, long after the first lease would have lapsed
+	ctx := context.Background()
+	for {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Millisecond):
+			if n, err := repo.ReclaimExpiredLeases(ctx); n != 0 || err != nil {
+				t.Fatalf("ReclaimExpiredLeases() = %d, %v, want the renewed claims kept", n, err)
+			}
+			if claimed, err := repo.ClaimForGeneration(ctx, 0, "other", time.Minute); len(claimed) != 0 || err != nil {
+				t.Fatalf("ClaimForGeneration() by another worker = %d images, %v, want none", len(claimed), err)
+			}
+			continue
+		}
+		break
+	}
+
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Generated != 3 {
+		t.Errorf("RunOnce() = %s, want every image generated by the worker that claimed it", summary)
+	}
+	for _, id := range ids {
+		wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	}
+}
@@ -0,0 +1,120 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// series returns the value of the counter, or the sample count of the histogram, named name
+// with the given labels in reg; zero when the series does not exist
+func series(t *testing.T, reg prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != metrics.Namespace+"_"+name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// hasLabels reports whether m has exactly the given labels
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	if len(m.GetLabel()) != len(labels) {
+		return false
+	}
+	for _, l := range m.GetLabel() {
+		if labels[l.GetName()] != l.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMetricsOfSimulatedGeneration(t *testing.T) {
+	srv := fakeserver.New()
+	defer srv.Close()
+	srv.Enqueue(fakeserver.Initial(), fakeserver.Done(fakeserver.Pixel))
+	srv.Enqueue(fakeserver.Initial(), fakeserver.Censored())
+	srv.Enqueue(fakeserver.Fail("internal error"))
+
+	reg := prometheus.NewRegistry()
+	prom, err := metrics.NewPrometheus(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheus() error = %v", err)
+	}
+	client := fusionbrain.NewClient("key", "secret", fusionbrain.WithBaseURL(srv.URL), fusionbrain.WithMetrics(prom))
+	svc := service.NewImageGenerationService(client, service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		// CheckInterval also bounds every status request, which must not time out here
+		CheckInterval:     time.Second,
+		PollMaxInterval:   time.Second,
+		GenerationTimeout: time.Minute,
+	}, service.WithMetrics(prom))
+	repo := repository.NewMemoryImageRepository()
+	cfg := testConfig()
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions(workflows.WithMetrics(prom))...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions(workflows.WithMetrics(prom))...)
+
+	ids := createImages(t, repo, "a lighthouse", "a forbidden lighthouse", "a broken lighthouse")
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+	wantStatus(t, repo, ids[1], domain.StatusCensored)
+	wantStatus(t, repo, ids[2], domain.StatusFailed)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: metrics.ImagesSubmitted, want: 3},
+		{name: metrics.ImagesGenerated, want: 1},
+		{name: metrics.ImagesCensored, want: 1},
+		{name: metrics.ImagesFailed, want: 1},
+		{name: metrics.ImagesTimedOut, want: 0},
+		{name: metrics.APIRequestDuration, labels: map[string]string{"provider": fusionbrain.ProviderName, "endpoint": "run", "status_code": "201"}, want: 3},
+		{name: metrics.WorkflowPassDuration, labels: map[string]string{"workflow": "generator"}, want: 1},
+		{name: metrics.WorkflowPassDuration, labels: map[string]string{"workflow": "processor"}, want: 1},
+	}
+	for _, tt := range tests {
+		if got := series(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+	// Every status check is observed, labelled with the status code of its response
+	checks := series(t, reg, metrics.APIRequestDuration, map[string]string{"provider": fusionbrain.ProviderName, "endpoint": "status", "status_code": "200"})
+	if want := srv.Requests(fakeserver.EndpointStatus); checks != float64(want) || want < 3 {
+		t.Errorf("status request observations = %v, want one per request (%d)", checks, want)
+	}
+}
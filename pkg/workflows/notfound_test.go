@@ -0,0 +1,65 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestNotFoundResetsAreCapped(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.Timeout()
+	svc := checkErrorService{fake, statusError(404)}
+	id := createImages(t, repo, "a lighthouse")[0]
+	cfg := testConfig()
+	cfg.NotFoundMaxResets = 2
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+	ctx := context.Background()
+
+	// round submits the image and runs a processor pass that finds its generation gone
+	round := func() *domain.Image {
+		t.Helper()
+		if _, err := g.RunOnce(ctx); err != nil {
+			t.Fatalf("generator RunOnce() error = %v", err)
+		}
+		wantStatus(t, repo, id, domain.StatusGenerate)
+		if _, err := p.RunOnce(ctx); err != nil {
+			t.Fatalf("processor RunOnce() error = %v", err)
+		}
+		return getImage(t, repo, id)
+	}
+
+	// Every 404 up to the cap requeues the image
+	for i := 1; i <= cfg.NotFoundMaxResets; i++ {
+		img := round()
+		if img.Status != domain.StatusReadyToGenerate || img.ResetCount != i || img.UUID != "" {
+			t.Fatalf("after 404 %d the image is %s with %d resets and UUID %q, want it requeued with %d resets", i, img.Status, img.ResetCount, img.UUID, i)
+		}
+	}
+
+	// The next 404 fails it instead of requeuing it once more
+	img := round()
+	if img.Status != domain.StatusFailed || img.ErrorDescription != "result expired repeatedly" || img.ResetCount != cfg.NotFoundMaxResets {
+		t.Fatalf("after the 404 past the cap the image is %s (%q) with %d resets, want it failed", img.Status, img.ErrorDescription, img.ResetCount)
+	}
+	if _, err := g.RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if n := submissions(fake); n != cfg.NotFoundMaxResets+1 {
+		t.Errorf("%d submissions, want one per reset and the first", n)
+	}
+
+	// A manual requeue starts the count over
+	if ok, err := repo.Requeue(ctx, id); err != nil || !ok {
+		t.Fatalf("Requeue() = %v, %v, want the image requeued", ok, err)
+	}
+	if img := round(); img.Status != domain.StatusReadyToGenerate || img.ResetCount != 1 {
+		t.Errorf("after a requeue and a 404 the image is %s with %d resets, want it requeued with 1 reset", img.Status, img.ResetCount)
+	}
+}
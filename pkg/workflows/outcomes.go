@@ -0,0 +1,143 @@
+package workflows
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+)
+
+// outcomeCounters maps the final statuses reported to an outcomeReporter to their counters
+var outcomeCounters = map[domain.ImageStatus]string{
+	domain.StatusReadyToPublish: metrics.ImagesGenerated,
+	domain.StatusFailed:         metrics.ImagesFailed,
+	domain.StatusTimedOut:       metrics.ImagesTimedOut,
+	domain.StatusCensored:       metrics.ImagesCensored,
+	domain.StatusPublished:      metrics.ImagesPublished,
+}
+
+// outcomeReporter announces the final status of images: it counts them and passes them on to
+// the OnOutcome hook
+type outcomeReporter struct {
+	metrics metrics.Hook
+	hooks   Hooks
+	log     *slog.Logger
+	// clk times the passes
+	clk clock.Clock
+	// pass tallies the images of the workflow pass the reporter was started for, if any
+	pass *passTally
+}
+
+// passTally accumulates the summary of a workflow pass; it is safe for concurrent use
+type passTally struct {
+	workflow string
+	start    time.Time
+
+	mu      sync.Mutex
+	summary Summary
+}
+
+// update applies fn to the summary, unless there is no pass
+func (t *passTally) update(fn func(s *Summary)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.summary)
+}
+
+// startPass returns a reporter for a single pass of workflow that also counts the images of the
+// pass in the summary returned by finishPass. Every count goes along with its counter metric,
+// so the summaries and the metrics agree.
+func (o *outcomeReporter) startPass(workflow string) *outcomeReporter {
+	pass := *o
+	pass.pass = &passTally{workflow: workflow, start: o.clk.Now()}
+	return &pass
+}
+
+// finishPass records the duration of the pass, passes it on to the AfterPass hook and returns
+// its summary
+func (o *outcomeReporter) finishPass() Summary {
+	var summary Summary
+	o.pass.update(func(s *Summary) {
+		s.Duration = o.clk.Now().Sub(o.pass.start)
+		summary = *s
+	})
+	o.metrics.ObserveDuration(metrics.WorkflowPassDuration, summary.Duration, map[string]string{"workflow": o.pass.workflow})
+	if o.hooks.AfterPass != nil {
+		o.hooks.AfterPass(o.pass.workflow, summary)
+	}
+	return summary
+}
+
+// record counts an image picked up by the pass and logs its error, if any
+func (o *outcomeReporter) record(img *domain.Image, err error) {
+	var class string
+	if err != nil {
+		class = errclass.Class(err)
+		o.log.Error("Error handling image", "workflow", o.pass.workflow, "image_id", img.ID, "uuid", img.UUID, "error_class", class, "error", err)
+		o.metrics.IncCounter(metrics.ImageErrors, map[string]string{"workflow": o.pass.workflow, "class": class})
+	}
+	o.pass.update(func(s *Summary) {
+		s.Images++
+		if err == nil {
+			return
+		}
+		s.Errors++
+		if s.ErrorClasses == nil {
+			s.ErrorClasses = make(map[string]int)
+		}
+		s.ErrorClasses[class]++
+	})
+}
+
+// submitted counts an image a provider accepted for generation
+func (o *outcomeReporter) submitted() {
+	o.metrics.IncCounter(metrics.ImagesSubmitted, nil)
+	o.pass.update(func(s *Summary) { s.Submitted++ })
+}
+
+// skipped counts an image left for a later pass
+func (o *outcomeReporter) skipped() {
+	o.metrics.IncCounter(metrics.ImagesSkipped, map[string]string{"workflow": o.pass.workflow})
+	o.pass.update(func(s *Summary) { s.Skipped++ })
+}
+
+// report counts img under its final status and passes the status on to the OnOutcome hook
+func (o *outcomeReporter) report(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string) {
+	if counter, ok := outcomeCounters[status]; ok {
+		o.metrics.IncCounter(counter, nil)
+	}
+	o.pass.update(func(s *Summary) {
+		switch status {
+		case domain.StatusReadyToPublish:
+			s.Generated++
+		case domain.StatusFailed:
+			s.Failed++
+		case domain.StatusTimedOut:
+			s.TimedOut++
+		case domain.StatusCensored:
+			s.Censored++
+		case domain.StatusPublished:
+			s.Published++
+		}
+	})
+
+	if o.hooks.OnOutcome != nil {
+		o.hooks.OnOutcome(ctx, img, status, errorDescription)
+	}
+}
+
+// observeGeneration records how long a finished generation took at provider, unless unknown
+func (o *outcomeReporter) observeGeneration(provider string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	o.metrics.ObserveDuration(metrics.GenerationDuration, d, map[string]string{"provider": provider})
+}
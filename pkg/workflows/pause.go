@@ -0,0 +1,55 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// pauseState is the pause state of a workflow as last read from pipeline_control
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+}
+
+// runUnlessPaused runs pass unless the workflow is paused in pipeline_control. The pause state
+// is read at the start of every pass, so a pause takes effect on the next one, and every change
+// of it is logged once.
+func (r *runner) runUnlessPaused(ctx context.Context, pass func() (Summary, error)) (Summary, error) {
+	now, err := isPaused(WorkContext(ctx), r.repo, r.name)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to read pause state: %w", err)
+	}
+
+	r.pause.mu.Lock()
+	changed := now != r.pause.paused
+	r.pause.paused = now
+	r.pause.mu.Unlock()
+	switch {
+	case changed && now:
+		r.log.Warn("Workflow paused, skipping its passes until it is resumed", "workflow", r.name)
+	case changed:
+		r.log.Info("Workflow resumed", "workflow", r.name)
+	}
+
+	if now {
+		return Summary{}, nil
+	}
+	return pass()
+}
+
+// isPaused reports whether workflow is paused
+func isPaused(ctx context.Context, repo repository.ImageRepository, workflow string) (bool, error) {
+	controls, err := repo.GetControls(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range controls {
+		if c.Workflow == workflow {
+			return c.Paused, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,115 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestPauseMidRun(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse", "a harbour")
+	svc := newSlowService()
+	svc.Default = testsupport.DoneAfter(1)
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	health := newHealthRecorder(t)
+	var logs bytes.Buffer
+	cfg := testConfig()
+	cfg.GeneratorInterval = time.Second
+	cfg.IdleBackoffMax = time.Second
+	cfg.BatchSize = 1
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions(workflows.WithClock(clk), workflows.WithHealth(health),
+		workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))...)
+	ctx := context.Background()
+
+	stop, cancel := context.WithCancel(ctx)
+	done := runGenerator(g, stop, ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// pass runs the next pass once it is due and waits for it to end
+	pass := func() {
+		t.Helper()
+		clk.BlockUntil(1)
+		clk.Advance(time.Second)
+		if d := health.next(); d != time.Second {
+			t.Fatalf("next pass due in %v, want the base interval", d)
+		}
+	}
+	health.next()
+
+	// Pausing while a pass submits lets the pass finish its batch
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	if prompt := waitStarted(t, svc); prompt != "a lighthouse" {
+		t.Fatalf("first submission is %q, want the oldest image", prompt)
+	}
+	if err := repo.SetPaused(ctx, "generator", true); err != nil {
+		t.Fatalf("SetPaused() error = %v", err)
+	}
+	close(svc.release)
+	health.next()
+	wantStatus(t, repo, ids[0], domain.StatusGenerate)
+
+	// No pass submits anything while paused
+	pass()
+	pass()
+	pass()
+	if n := submissions(svc.FakeImageGenerationService); n != 1 {
+		t.Errorf("%d submissions while paused, want only the one before the pause", n)
+	}
+	wantStatus(t, repo, ids[1], domain.StatusReadyToGenerate)
+	if n := strings.Count(logs.String(), "Workflow paused"); n != 1 {
+		t.Errorf("logged the pause %d times, want once\n%s", n, logs.String())
+	}
+
+	// The processor is not paused with the generator, so the submitted image still finishes
+	p := workflows.NewProcessor(repo, svc, testConfig(), testOptions()...)
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+
+	// Resuming takes effect on the next pass
+	if err := repo.SetPaused(ctx, "generator", false); err != nil {
+		t.Fatalf("SetPaused() error = %v", err)
+	}
+	pass()
+	if n := submissions(svc.FakeImageGenerationService); n != 2 {
+		t.Errorf("%d submissions after the resume, want the queued image submitted", n)
+	}
+	wantStatus(t, repo, ids[1], domain.StatusGenerate)
+	if n := strings.Count(logs.String(), "Workflow resumed"); n != 1 {
+		t.Errorf("logged the resumption %d times, want once\n%s", n, logs.String())
+	}
+}
+
+func TestPauseSurvivesRestart(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "a lighthouse")
+	if err := repo.SetPaused(context.Background(), "generator", true); err != nil {
+		t.Fatalf("SetPaused() error = %v", err)
+	}
+
+	// A generator started after the pause, as after a restart, reads it on its first pass
+	fake := testsupport.NewFakeImageGenerationService()
+	g := workflows.NewGenerator(repo, fake, testConfig(), testOptions()...)
+	summary, err := g.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Images != 0 || submissions(fake) != 0 {
+		t.Errorf("RunOnce() = %s with %d submissions, want nothing done while paused", summary, submissions(fake))
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+}
@@ -0,0 +1,161 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/clock"
+)
+
+// idleThreshold is how many consecutive passes without images keep the base polling interval
+// before the interval starts to grow
+const idleThreshold = 3
+
+// pollLoop runs pass of the workflow every interval until ctx is cancelled. Once idleThreshold
+// consecutive passes found no images the interval doubles with every further idle pass, up to
+// IdleBackoffMax, and it drops back to interval as soon as a pass finds work. Successful
+// passes are reported to the health reporter.
+//
+// A failing pass, usually the database being unreachable, marks the workflow degraded and
+// doubles the interval with every further failure, up to ErrorBackoffMax. The first failure
+// and the recovery are logged once. While backing off, the repository is pinged every
+// interval, and the next pass runs as soon as it answers.
+//
+// A value received from wake, when it is not nil, runs the next pass right away and resets the
+// idle backoff, unless the workflow is backing off after failures. Once wake is closed the
+// workflow keeps polling.
+func (r *runner) pollLoop(ctx context.Context, interval time.Duration, wake <-chan struct{}, pass func() (Summary, error)) {
+	name, clk := r.name, r.clk
+	timer := clk.NewTimer(interval)
+	defer timer.Stop()
+	r.health.Tick(name, interval)
+
+	// pings fires while the workflow backs off after failures; it is nil otherwise
+	var pings clock.Ticker
+	defer func() {
+		if pings != nil {
+			pings.Stop()
+		}
+	}()
+	stopPings := func() {
+		if pings != nil {
+			pings.Stop()
+			pings = nil
+		}
+	}
+
+	delay := interval
+	idle := 0
+	failures := 0
+	for {
+		var pinged <-chan time.Time
+		if pings != nil {
+			pinged = pings.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-wake:
+			if !ok {
+				if ctx.Err() == nil {
+					r.log.Warn("Stopped listening for new images, falling back to polling", "workflow", name)
+				}
+				wake = nil
+				continue
+			}
+			if failures > 0 {
+				continue
+			}
+			r.log.Debug("Woken up by new images, running the next pass now", "workflow", name)
+			idle = 0
+			delay = interval
+			timer.Reset(0)
+		case <-pinged:
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := r.repo.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				r.log.Debug("Database still unreachable", "workflow", name, "error", err)
+				continue
+			}
+			r.log.Debug("Database reachable again, running the next pass now", "workflow", name)
+			stopPings()
+			timer.Reset(0)
+		case <-timer.C():
+			summary, err := r.runPassRecovered(pass)
+			if err != nil {
+				failures++
+				delay = errorBackoff(interval, r.cfg.ErrorBackoffMax, failures)
+				if failures == 1 {
+					r.log.Error("Workflow degraded, backing off until its passes succeed again", "workflow", name, "error", err)
+					r.health.Degraded(name, err)
+				} else {
+					r.log.Debug("Error running workflow pass", "workflow", name, "failures", failures, "next_in", delay, "error", err)
+				}
+				if pings == nil && delay > interval {
+					pings = clk.NewTicker(interval)
+				}
+				timer.Reset(delay)
+				continue
+			}
+
+			stopPings()
+			if failures > 0 {
+				r.log.Info("Workflow recovered", "workflow", name, "failures", failures)
+				failures = 0
+				delay = interval
+			}
+			if summary.Images == 0 {
+				r.log.Debug("Workflow pass finished", append([]any{"workflow", name}, summary.LogAttrs()...)...)
+				idle++
+			} else {
+				r.log.Info("Workflow pass finished", append([]any{"workflow", name}, summary.LogAttrs()...)...)
+				idle = 0
+			}
+			delay = nextPollInterval(delay, interval, r.cfg.IdleBackoffMax, idle)
+			r.health.Tick(name, delay)
+			timer.Reset(delay)
+		}
+	}
+}
+
+// nextPollInterval returns the interval to wait after a pass, given the current interval and
+// the number of consecutive idle passes
+func nextPollInterval(current, interval, maxInterval time.Duration, idle int) time.Duration {
+	if idle < idleThreshold {
+		return interval
+	}
+	next := current * 2
+	if next > maxInterval || next <= 0 {
+		next = maxInterval
+	}
+	return max(next, interval)
+}
+
+// errorBackoff returns the interval to wait after the given number of consecutive failed
+// passes: interval doubled for every failure, up to maxInterval
+func errorBackoff(interval, maxInterval time.Duration, failures int) time.Duration {
+	next := interval
+	for i := 0; i < failures && next < maxInterval; i++ {
+		next *= 2
+	}
+	if next > maxInterval || next <= 0 {
+		next = maxInterval
+	}
+	return max(next, interval)
+}
+
+// runPassRecovered runs pass and turns a panic outside of the handling of a single image into
+// an error, so the workflow keeps polling
+func (r *runner) runPassRecovered(pass func() (Summary, error)) (summary Summary, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.log.Error("Panic running workflow pass", "workflow", r.name, "panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return pass()
+}
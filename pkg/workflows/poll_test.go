@@ -0,0 +1,96 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// healthRecorder is a HealthReporter sending the intervals the workflow reports to ticks
+type healthRecorder struct {
+	t     *testing.T
+	ticks chan time.Duration
+}
+
+func newHealthRecorder(t *testing.T) *healthRecorder {
+	return &healthRecorder{t: t, ticks: make(chan time.Duration, 16)}
+}
+
+func (h *healthRecorder) Tick(name string, next time.Duration) { h.ticks <- next }
+
+func (h *healthRecorder) Degraded(name string, err error) {
+	h.t.Errorf("workflow %s degraded: %v", name, err)
+}
+
+// next returns the interval reported after the next pass
+func (h *healthRecorder) next() time.Duration {
+	h.t.Helper()
+	select {
+	case d := <-h.ticks:
+		return d
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("no pass reported within 5s")
+		return 0
+	}
+}
+
+func TestIdleBackoff(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	health := newHealthRecorder(t)
+	cfg := testConfig()
+	cfg.GeneratorInterval = time.Second
+	cfg.IdleBackoffMax = 8 * time.Second
+	g := workflows.NewGenerator(repo, testsupport.NewFakeImageGenerationService(), cfg,
+		testOptions(workflows.WithClock(clk), workflows.WithHealth(health))...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if d := health.next(); d != time.Second {
+		t.Fatalf("first pass due in %v, want the base interval", d)
+	}
+	// passes runs a pass whenever it is due and checks the interval reported after each,
+	// making sure no pass runs before its interval passed
+	delay := time.Second
+	passes := func(want ...time.Duration) {
+		t.Helper()
+		for i, w := range want {
+			clk.BlockUntil(1)
+			clk.Advance(delay - time.Millisecond)
+			select {
+			case d := <-health.ticks:
+				t.Fatalf("pass %d ran early, reporting %v", i+1, d)
+			case <-time.After(10 * time.Millisecond):
+			}
+			clk.Advance(time.Millisecond)
+			if delay = health.next(); delay != w {
+				t.Fatalf("pass %d: next pass due in %v, want %v", i+1, delay, w)
+			}
+		}
+	}
+
+	// Three idle passes keep the base interval, then it doubles up to IdleBackoffMax
+	passes(time.Second, time.Second, 2*time.Second, 4*time.Second, 8*time.Second, 8*time.Second)
+
+	// A new image wakes the generator, and the pass finding it resets the interval
+	createImages(t, repo, "a lighthouse")
+	if delay = health.next(); delay != time.Second {
+		t.Fatalf("pass finding an image sets the next pass in %v, want the base interval", delay)
+	}
+	// The idle count starts over as well
+	passes(time.Second, time.Second, 2*time.Second)
+}
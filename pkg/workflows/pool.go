@@ -0,0 +1,44 @@
+package workflows
+
+import (
+	"context"
+	"sync"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// runPool hands images to at most concurrency workers and waits until all of them are done.
+// Once ctx is cancelled no further images are dispatched; images already handed out finish.
+func runPool(ctx context.Context, images []*domain.Image, concurrency int, fn func(img *domain.Image)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan *domain.Image)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range jobs {
+				// The dispatch may race the cancellation; an image received after it is dropped
+				if ctx.Err() != nil {
+					continue
+				}
+				fn(img)
+			}
+		}()
+	}
+
+dispatch:
+	for _, img := range images {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- img:
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+}
@@ -0,0 +1,230 @@
+package workflows_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// parallelService records the largest number of submissions in flight at once. Every submission
+// waits until limit submissions are in flight, or for a second, so that a pool that is not
+// bounded runs over the limit instead of finishing its submissions one by one.
+type parallelService struct {
+	*testsupport.FakeImageGenerationService
+	limit int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	max      int
+	started  chan struct{}
+	released bool
+}
+
+func newParallelService(limit int) *parallelService {
+	s := &parallelService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService(), limit: limit, started: make(chan struct{}, 100)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *parallelService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	s.mu.Lock()
+	s.active++
+	s.max = max(s.max, s.active)
+	s.started <- struct{}{}
+	timer := time.AfterFunc(time.Second, func() {
+		s.mu.Lock()
+		s.released = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	s.cond.Broadcast()
+	for s.active < s.limit && !s.released {
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+	timer.Stop()
+
+	// Hold the slot a little longer, so that the submissions of the pool overlap
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	return s.FakeImageGenerationService.GenerateImage(ctx, req)
+}
+
+// maxParallel returns the largest number of submissions seen in flight at once
+func (s *parallelService) maxParallel() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.max
+}
+
+func TestGeneratorBoundsParallelism(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			prompts := make([]string, 12)
+			for i := range prompts {
+				prompts[i] = fmt.Sprintf("prompt %d", i)
+			}
+			ids := createImages(t, repo, prompts...)
+
+			svc := newParallelService(concurrency)
+			cfg := testConfig()
+			cfg.Concurrency = concurrency
+			summary, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background())
+			if err != nil {
+				t.Fatalf("RunOnce() error = %v", err)
+			}
+
+			if got := svc.maxParallel(); got != concurrency {
+				t.Errorf("max parallel submissions = %d, want %d", got, concurrency)
+			}
+			if summary.Images != len(ids) || summary.Generated != len(ids) {
+				t.Errorf("summary = %+v, want all %d images generated", summary, len(ids))
+			}
+			for _, id := range ids {
+				wantStatus(t, repo, id, domain.StatusReadyToPublish)
+			}
+		})
+	}
+}
+
+func TestGeneratorContinuesAfterFailures(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ids := createImages(t, repo, "first", "broken", "third")
+	svc := testsupport.NewFakeImageGenerationService().On("broken", testsupport.SubmitError(domain.ErrInvalidPrompt))
+
+	cfg := testConfig()
+	cfg.Concurrency = 2
+	summary, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+	wantStatus(t, repo, ids[1], domain.StatusFailed)
+	wantStatus(t, repo, ids[2], domain.StatusReadyToPublish)
+	if summary.Failed != 1 || summary.Generated != 2 {
+		t.Errorf("summary = %+v, want 1 failed and 2 generated", summary)
+	}
+}
+
+func TestGeneratorDrainsOnCancel(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	prompts := make([]string, 8)
+	for i := range prompts {
+		prompts[i] = fmt.Sprintf("prompt %d", i)
+	}
+	ids := createImages(t, repo, prompts...)
+
+	// The pass is cancelled while both workers are submitting, so no further image is dispatched,
+	// and the images in flight are handled under the hard-kill context, which is never cancelled
+	svc := newParallelService(2)
+	cfg := testConfig()
+	cfg.Concurrency = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = workflows.WithHardKill(ctx, context.Background())
+	go func() {
+		<-svc.started
+		<-svc.started
+		cancel()
+	}()
+	summary, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	// The submissions in flight finished, the rest of the batch is back in the queue
+	if summary.Generated != 2 {
+		t.Errorf("summary = %+v, want the 2 submissions in flight generated", summary)
+	}
+	generated := 0
+	for _, id := range ids {
+		switch img := getImage(t, repo, id); img.Status {
+		case domain.StatusReadyToPublish:
+			generated++
+		case domain.StatusReadyToGenerate:
+		default:
+			t.Errorf("image %d has status %s, want it generated or released to the queue", id, img.Status)
+		}
+	}
+	if generated != 2 {
+		t.Errorf("%d images generated, want 2", generated)
+	}
+}
+
+// latentService is a fake service whose status checks take latency, recording the largest
+// number of checks in flight at once
+type latentService struct {
+	*testsupport.FakeImageGenerationService
+	latency time.Duration
+
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (s *latentService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	s.mu.Lock()
+	s.active++
+	s.max = max(s.max, s.active)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.latency)
+	return s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid)
+}
+
+func TestProcessorChecksInParallel(t *testing.T) {
+	const images, concurrency = 50, 10
+	repo := repository.NewMemoryImageRepository()
+	prompts := make([]string, images)
+	for i := range prompts {
+		prompts[i] = fmt.Sprintf("prompt %d", i)
+	}
+	ids := createImages(t, repo, prompts...)
+	svc := &latentService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService(), latency: 20 * time.Millisecond}
+	svc.Default = testsupport.DoneAfter(1)
+	if _, err := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+
+	cfg := testConfig()
+	cfg.Concurrency = concurrency
+	start := time.Now()
+	summary, err := workflows.NewProcessor(repo, svc, cfg, testOptions()...).RunOnce(context.Background())
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	// Each image takes two checks; the workers get through the batch in ceil(50/10) rounds of
+	// them rather than 50, with room for a slow machine
+	perImage := 2 * svc.latency
+	rounds := (images + concurrency - 1) / concurrency
+	if limit := time.Duration(3*rounds) * perImage; elapsed > limit {
+		t.Errorf("pass took %v, want at most %v for %d rounds of checks instead of %v for all images in turn", elapsed, limit, rounds, images*perImage)
+	}
+	if got := svc.max; got > concurrency || got < 2 {
+		t.Errorf("max parallel status checks = %d, want up to %d", got, concurrency)
+	}
+	if summary.Images != images || summary.Generated != images || summary.Errors != 0 {
+		t.Errorf("summary = %+v, want all %d images generated", summary, images)
+	}
+	for _, id := range ids {
+		wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	}
+}
@@ -0,0 +1,325 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/backoff"
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Processor checks on the images being generated and saves the results of finished generations
+type Processor struct {
+	*runner
+	service domain.ImageGenerationService
+}
+
+// NewProcessor creates the processor workflow, checking the generations of the images in repo
+// with service
+func NewProcessor(repo repository.ImageRepository, service domain.ImageGenerationService, cfg Config, opts ...Option) *Processor {
+	return &Processor{runner: newRunner("processor", repo, cfg, opts), service: service}
+}
+
+// Run runs a pass every ProcessorInterval until ctx is cancelled, backing off while idle or
+// failing
+func (p *Processor) Run(ctx context.Context) {
+	p.pollLoop(ctx, p.cfg.ProcessorInterval, nil, func() (Summary, error) {
+		return p.RunOnce(ctx)
+	})
+	p.log.Info("Image processing workflow stopped", "workflow", p.name)
+}
+
+// RunOnce checks the status of up to BatchSize images being generated once, up to Concurrency
+// of them at a time, unless the processor is paused. Cancelling ctx stops checking more images,
+// while the images in flight finish under its WorkContext.
+func (p *Processor) RunOnce(ctx context.Context) (Summary, error) {
+	return p.runUnlessPaused(ctx, func() (Summary, error) {
+		return p.runOnce(ctx)
+	})
+}
+
+// runOnce runs a single pass of the processor
+func (p *Processor) runOnce(ctx context.Context) (summary Summary, err error) {
+	outcomes := p.outcomes.startPass(p.name)
+	defer func() { summary = outcomes.finishPass() }()
+	work, span := tracing.Start(repository.ContextWithActor(WorkContext(ctx), p.name), p.passSpan)
+	defer func() { tracing.End(span, err) }()
+
+	// Get the images ready for status check
+	images, err := p.repo.GetAllReadyToCheck(work, p.cfg.BatchSize)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get images ready for check: %w", err)
+	}
+
+	// Check images in parallel; each image is checked and updated independently within
+	// PerImageTimeout, and the provider requests share the rate limiter. Cancelling ctx, or the
+	// provider rejecting the credentials, stops checking more images, while the images in
+	// flight finish.
+	dispatch, auth := newAuthGate(ctx)
+	runPool(dispatch, images, p.cfg.Concurrency, func(img *domain.Image) {
+		outcomes.record(img, p.handleRecovered(work, outcomes, img, func(ctx context.Context) error {
+			return p.handleWithDeadline(ctx, outcomes, img, func(ctx context.Context) error {
+				return p.processImage(ctx, outcomes, auth, img)
+			})
+		}))
+	})
+
+	return summary, auth.close()
+}
+
+// processImage checks the generation status of a single image until it finishes, up to
+// MaxAttempts times, and records the outcome. The wait between checks starts at CheckInterval
+// and grows by half, with jitter, up to PollMaxInterval. The checks stop early once the next
+// wait would run past PerImageTimeout, so the deadline of ctx only cuts short a check that
+// hangs. An image still in progress GenerationTimeout after it was submitted is marked
+// TimedOut; any other unfinished image is left for the next pass. It returns an error when the
+// image could not be handled, including failed generations.
+func (p *Processor) processImage(ctx context.Context, outcomes *outcomeReporter, auth *authGate, img *domain.Image) error {
+	log := tracing.Logger(ctx, p.log).With("workflow", p.name, "image_id", img.ID, "uuid", img.UUID)
+	log.Info("Starting status checks")
+
+	// lastErr is the error of the latest check that is retried by the next one
+	var lastErr error
+
+	start := p.clk.Now()
+	delay := p.cfg.CheckInterval
+	for attempt := 1; attempt <= p.cfg.MaxAttempts && ctx.Err() == nil; attempt++ {
+		done, err := p.checkImage(ctx, log.With("attempt", attempt), outcomes, auth, img)
+		if done {
+			return err
+		}
+		lastErr = err
+
+		// Only a generation the provider still reports in progress can time out, so a result
+		// that failed to save is retried instead
+		if lastErr == nil && p.generationExpired(img) {
+			return p.markTimedOut(ctx, outcomes, img)
+		}
+
+		if attempt < p.cfg.MaxAttempts {
+			wait := backoff.Jitter(delay)
+			if p.clk.Now().Sub(start)+wait >= p.cfg.PerImageTimeout {
+				log.Info("No time left for another check in this pass", "attempt", attempt)
+				break
+			}
+			_ = clock.Sleep(ctx, p.clk, wait)
+			delay = backoff.Next(delay, p.cfg.PollMaxInterval)
+		}
+	}
+
+	// Report a deadline that stopped the checks early, unless a check already failed
+	if lastErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return lastErr
+}
+
+// checkImage checks the generation status of img once. It reports done when the image needs no
+// more checks in this pass, with the error to return for it, and otherwise the error, if any,
+// that the next check retries.
+func (p *Processor) checkImage(ctx context.Context, log *slog.Logger, outcomes *outcomeReporter, auth *authGate, img *domain.Image) (bool, error) {
+	log.Debug("Checking generation status")
+
+	// A provider chain asks the provider that accepted the generation
+	resp, err := p.service.CheckGenerationStatus(domain.ContextWithProvider(ctx, img.Provider), img.UUID)
+	if err != nil {
+		if errclass.IsNotFound(err) {
+			return p.resetNotFound(ctx, log, outcomes, img)
+		}
+		if errclass.IsRetryable(err) {
+			log.Warn("Error getting status", "error", err)
+			return false, nil
+		}
+		if errclass.IsAuth(err) {
+			log.Error("Provider rejected the credentials, leaving the image generating", "error", err)
+			outcomes.skipped()
+			auth.trip(err)
+			return true, nil
+		}
+
+		if updateErr := p.repo.MarkFailed(ctx, img.ID, err.Error(), errclass.Class(err)); updateErr != nil {
+			return true, fmt.Errorf("failed to update status after %v: %w", err, updateErr)
+		}
+		outcomes.report(ctx, img, domain.StatusFailed, err.Error())
+		p.captureError(ctx, img, err)
+		return true, err
+	}
+
+	log.Info("Generation status", "status", resp.Status)
+
+	if resp.Status == "FAIL" {
+		p.saveRawResponse(ctx, img, resp)
+	}
+
+	// A generation censored or failed by a provider of a chain goes to the next provider
+	if resp.Censored || resp.Status == "FAIL" {
+		if next, ok := p.nextProvider(img.Provider); ok {
+			return true, p.fallBack(ctx, log, img, resp, next)
+		}
+	}
+
+	// Censored generations are never published, whether or not files came back
+	if resp.Censored {
+		return true, p.handleCensored(ctx, outcomes, img, resp)
+	}
+
+	switch {
+	case resp.Status == "DONE" && len(resp.Files) > 0:
+		log.Info("Generation completed, saving results", "files", len(resp.Files))
+		if resp.Seed != 0 {
+			if err := p.repo.UpdateSeed(ctx, img.ID, resp.Seed); err != nil {
+				log.Error("Error saving seed", "error", err)
+			}
+		}
+		if resp.Provider != "" && resp.Provider != img.Provider {
+			if err := p.repo.UpdateProvider(ctx, img.ID, resp.Provider); err != nil {
+				log.Error("Error saving provider", "error", err)
+			}
+			img.Provider = resp.Provider
+		}
+		img.Metadata.GenerationDuration = p.generationTime(img, resp)
+		if err := p.results.Save(ctx, img, img.UUID, resp.Files); err != nil {
+			log.Error("Error saving results", "error", err)
+			return false, fmt.Errorf("failed to save results: %w", err)
+		}
+		if err := p.repo.UpdateStatus(ctx, img.ID, domain.StatusReadyToPublish); err != nil {
+			log.Error("Error updating status", "error", err)
+			return false, fmt.Errorf("failed to update status: %w", err)
+		}
+		if cacher, ok := p.service.(domain.ResultCacher); ok {
+			cacher.CacheResult(ctx, p.generationRequest(img), resp)
+		}
+		outcomes.observeGeneration(img.Provider, img.Metadata.GenerationDuration)
+		outcomes.report(ctx, img, domain.StatusReadyToPublish, "")
+		log.Info("Successfully saved and marked as ready to publish", "generation_time", img.Metadata.GenerationDuration)
+		return true, nil
+
+	case resp.Status == "FAIL":
+		log.Warn("Generation failed", "reason", resp.ErrorDescription)
+		// The provider failing a generation is usually transient, so the image may be requeued
+		if err := p.repo.MarkFailed(ctx, img.ID, resp.ErrorDescription, errclass.ClassTransient); err != nil {
+			return true, fmt.Errorf("failed to update status: %w", err)
+		}
+		outcomes.report(ctx, img, domain.StatusFailed, resp.ErrorDescription)
+		return true, fmt.Errorf("generation failed: %s", resp.ErrorDescription)
+
+	case resp.Status == domain.DryRunStatus:
+		return true, nil // Nothing was submitted, so there is nothing to wait for
+
+	default:
+		log.Debug("Generation still in progress")
+		return false, nil
+	}
+}
+
+// nextProvider returns the provider of the chain of the service tried after the named one, if
+// the service is a chain and there is one
+func (p *Processor) nextProvider(name string) (string, bool) {
+	chain, ok := p.service.(domain.ProviderChain)
+	if !ok || name == "" {
+		return "", false
+	}
+	return chain.NextProvider(name)
+}
+
+// fallBack requeues img, whose generation was censored or failed by its provider, for the
+// generator to submit it to the provider next. The generator submits it outside the short
+// deadline of the status check, and the provider is stored with the image so that a restart
+// does not lose it.
+func (p *Processor) fallBack(ctx context.Context, log *slog.Logger, img *domain.Image, resp *domain.ImageGenerationResponse, next string) error {
+	reason := fmt.Sprintf("generation %s was censored by %s", img.UUID, img.Provider)
+	if resp.Status == "FAIL" {
+		reason = fmt.Sprintf("generation %s failed at %s: %s", img.UUID, img.Provider, resp.ErrorDescription)
+	} else {
+		p.saveRawResponse(ctx, img, resp)
+	}
+	log.Info("Falling back to the next provider", "provider", img.Provider, "next", next, "reason", reason)
+
+	if err := p.repo.UpdateUUID(ctx, img.ID, ""); err != nil {
+		return fmt.Errorf("failed to reset UUID: %w", err)
+	}
+	if err := p.repo.UpdateProvider(ctx, img.ID, next); err != nil {
+		return fmt.Errorf("failed to update provider: %w", err)
+	}
+	if err := p.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusReadyToGenerate, reason); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+// resetNotFound handles a generation the provider no longer knows by resetting img to
+// ReadyToGenerate to be submitted again. An image already reset NotFoundMaxResets times is
+// marked Failed instead, so that a result that keeps expiring cannot use up the quota.
+func (p *Processor) resetNotFound(ctx context.Context, log *slog.Logger, outcomes *outcomeReporter, img *domain.Image) (bool, error) {
+	const reason = "result expired repeatedly"
+	if img.ResetCount >= p.cfg.NotFoundMaxResets {
+		log.Warn("API returned 404 again, giving up on the image", "resets", img.ResetCount)
+		if err := p.repo.MarkFailed(ctx, img.ID, reason, errclass.ClassPermanent); err != nil {
+			return true, fmt.Errorf("failed to update status: %w", err)
+		}
+		outcomes.report(ctx, img, domain.StatusFailed, reason)
+		err := errors.New(reason)
+		p.captureError(ctx, img, err)
+		return true, err
+	}
+
+	log.Warn("API returned 404, resetting UUID and status", "resets", img.ResetCount+1)
+	if err := p.repo.UpdateUUID(ctx, img.ID, ""); err != nil {
+		log.Error("Error resetting UUID", "error", err)
+		return false, fmt.Errorf("failed to reset UUID: %w", err)
+	}
+	if err := p.repo.UpdateStatus(ctx, img.ID, domain.StatusReadyToGenerate, repository.WithReason("generation not found by the provider")); err != nil {
+		log.Error("Error updating status", "error", err)
+		return false, fmt.Errorf("failed to update status: %w", err)
+	}
+	if err := p.repo.RecordReset(ctx, img.ID); err != nil {
+		return true, fmt.Errorf("failed to record reset: %w", err)
+	}
+	log.Info("Image reset to ReadyToGenerate due to 404 status")
+	return true, nil
+}
+
+// generationExpired reports whether img has been generating for GenerationTimeout,
+// counted from its submission or, when that is unknown, from its creation
+func (p *Processor) generationExpired(img *domain.Image) bool {
+	started := img.GenerationStartedAt
+	if started.IsZero() {
+		started = img.CreatedAt
+	}
+	return !started.IsZero() && p.clk.Now().Sub(started) >= p.cfg.GenerationTimeout
+}
+
+// markTimedOut marks img TimedOut after its generation ran past GenerationTimeout
+func (p *Processor) markTimedOut(ctx context.Context, outcomes *outcomeReporter, img *domain.Image) error {
+	reason := fmt.Sprintf("generation did not finish within %s", p.cfg.GenerationTimeout)
+	p.log.Warn("Generation timed out", "workflow", p.name, "image_id", img.ID, "uuid", img.UUID, "timeout", p.cfg.GenerationTimeout)
+	if err := p.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusTimedOut, reason); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	outcomes.report(ctx, img, domain.StatusTimedOut, reason)
+	return nil
+}
+
+// generationTime returns how long the generation of img took: as reported in resp, or else from
+// when the image was submitted until resp completed, or until now when resp has no completion
+// time. It is zero when neither is known.
+func (r *runner) generationTime(img *domain.Image, resp *domain.ImageGenerationResponse) time.Duration {
+	if resp.GenerationTime > 0 {
+		return resp.GenerationTime
+	}
+	if img.GenerationStartedAt.IsZero() {
+		return 0
+	}
+	completedAt := resp.CompletedAt
+	if completedAt.IsZero() {
+		completedAt = r.clk.Now()
+	}
+	return completedAt.Sub(img.GenerationStartedAt)
+}
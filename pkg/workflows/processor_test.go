@@ -0,0 +1,151 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/service"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestProcessorCachesFinishedGenerations(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	provider := testsupport.NewFakeProvider("fake")
+	provider.Default = testsupport.DoneAfter(1)
+	cache := service.NewMemoryCache()
+	svc := service.NewImageGenerationService(provider, service.Config{
+		DefaultImageWidth:  1024,
+		DefaultImageHeight: 1024,
+		DefaultNumImages:   1,
+		MaxPromptLength:    1000,
+		CheckInterval:      time.Second,
+		PollMaxInterval:    time.Second,
+		GenerationTimeout:  time.Minute,
+	}, service.WithCache(cache, time.Hour))
+	cfg := dedupConfig("off")
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+
+	opts := []repository.CreateOption{repository.WithStyle("ANIME"), repository.WithSize(512, 512)}
+	first, err := repo.CreateImage(context.Background(), "a lighthouse", opts...)
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, first, domain.StatusReadyToPublish)
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("cache holds %d entries after the processor saved the result, want 1", n)
+	}
+
+	// The generator serves the same request from the result the processor cached
+	second, err := repo.CreateImage(context.Background(), "a lighthouse", opts...)
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, second, domain.StatusReadyToPublish)
+	if n := submissions(provider.FakeImageGenerationService); n != 1 {
+		t.Errorf("provider received %d generations, want 1", n)
+	}
+}
+
+// solidPNG returns a base64 PNG of width by 1 pixels, so that files of different widths differ
+func solidPNG(t *testing.T, width int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, 1))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestProcessorStoresEveryFile(t *testing.T) {
+	for _, legacy := range []bool{true, false} {
+		t.Run(fmt.Sprintf("legacy base64 %v", legacy), func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			files := []string{solidPNG(t, 1), solidPNG(t, 2), solidPNG(t, 3)}
+			svc := testsupport.NewFakeImageGenerationService()
+			svc.Default = testsupport.DoneAfter(1, files...)
+			id := createImages(t, repo, "three lighthouses")[0]
+			cfg := testConfig()
+			cfg.LegacyBase64 = legacy
+			if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+				t.Fatalf("generator RunOnce() error = %v", err)
+			}
+			if _, err := workflows.NewProcessor(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+				t.Fatalf("processor RunOnce() error = %v", err)
+			}
+
+			// Every file of the DONE response is kept, in the order of the response
+			if img := wantStatus(t, repo, id, domain.StatusReadyToPublish); img.Variants != len(files) {
+				t.Errorf("image has %d variants, want %d", img.Variants, len(files))
+			}
+			for index, want := range files {
+				v, err := repo.GetVariant(context.Background(), id, index)
+				if err != nil {
+					t.Fatalf("GetVariant(%d) error = %v", index, err)
+				}
+				if v.Data != want || v.Width != index+1 {
+					t.Errorf("variant %d = %d pixels wide, want file %d", index, v.Width, index)
+				}
+			}
+
+			// The images row keeps a copy of the first file only for the legacy readers
+			data, err := repo.GetImageData(context.Background(), id)
+			if err != nil {
+				t.Fatalf("GetImageData() error = %v", err)
+			}
+			if want := map[bool]string{true: files[0], false: ""}[legacy]; data != want {
+				t.Errorf("GetImageData() = %d bytes, want %d", len(data), len(want))
+			}
+		})
+	}
+}
+
+func TestProcessorStoresOneVariantPerFile(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := testsupport.NewFakeImageGenerationService()
+	svc.Provider = "fusionbrain"
+	svc.On("three lighthouses", testsupport.DoneAfter(1, fakeserver.Pixel, fakeserver.Pixel, fakeserver.Pixel))
+	ids := createImages(t, repo, "three lighthouses", "a lighthouse")
+	cfg := testConfig()
+	if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := workflows.NewProcessor(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	for i, want := range []int{3, 1} {
+		img := wantStatus(t, repo, ids[i], domain.StatusReadyToPublish)
+		if img.Variants != want || img.SelectedVariant != 0 {
+			t.Errorf("image %d has %d variants, selected %d, want %d, 0", ids[i], img.Variants, img.SelectedVariant, want)
+		}
+		variants, err := repo.ListVariants(context.Background(), ids[i])
+		if err != nil {
+			t.Fatalf("ListVariants() error = %v", err)
+		}
+		for index, v := range variants {
+			if v.Index != index || v.Provider != "fusionbrain" || v.Width != 1 || v.Height != 1 {
+				t.Errorf("variant %d of image %d = %+v, want index %d of the provider with its dimensions", index, ids[i], v, index)
+			}
+		}
+	}
+}
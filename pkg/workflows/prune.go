@@ -0,0 +1,35 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Pruner deletes old entries of the event log of image status transitions
+type Pruner struct {
+	*runner
+}
+
+// NewPruner creates the pruner workflow for the images in repo. It has no polling loop; run its
+// passes on a schedule.
+func NewPruner(repo repository.ImageRepository, cfg Config, opts ...Option) *Pruner {
+	return &Pruner{runner: newRunner("pruner", repo, cfg, opts)}
+}
+
+// RunOnce deletes the status transitions recorded at least EventRetention ago, unless the
+// pruner is paused
+func (p *Pruner) RunOnce(ctx context.Context) (Summary, error) {
+	return p.runUnlessPaused(ctx, func() (Summary, error) {
+		work, span := tracing.Start(WorkContext(ctx), p.passSpan)
+		n, err := p.repo.PruneEvents(work, p.cfg.EventRetention)
+		tracing.End(span, err)
+		if err != nil {
+			return Summary{}, fmt.Errorf("failed to prune image events: %w", err)
+		}
+		p.log.Info("Pruned image events", "workflow", p.name, "events", n)
+		return Summary{}, nil
+	})
+}
@@ -0,0 +1,47 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestPrunerDeletesOldEvents(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ctx := context.Background()
+	id := createImages(t, repo, "a lighthouse")[0]
+	repo.UpdateStatus(ctx, id, domain.StatusGenerate)
+
+	cfg := testConfig()
+	var logs bytes.Buffer
+	p := workflows.NewPruner(repo, cfg, workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	// Events younger than the retention are kept
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if events, _ := repo.GetEvents(ctx, id); len(events) != 1 {
+		t.Fatalf("events after a pass = %v, want the recent event kept", events)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	cfg.EventRetention = time.Millisecond
+	p = workflows.NewPruner(repo, cfg, workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if events, _ := repo.GetEvents(ctx, id); len(events) != 0 {
+		t.Errorf("events after a pass = %v, want the old event pruned", events)
+	}
+	if !strings.Contains(logs.String(), `msg="Pruned image events" workflow=pruner events=1`) {
+		t.Errorf("pruned count not logged:\n%s", logs.String())
+	}
+	wantStatus(t, repo, id, domain.StatusGenerate)
+}
@@ -0,0 +1,117 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/infrastructure/telegram"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Publisher ships the images that are ready to publish
+type Publisher struct {
+	*runner
+	publisher domain.Publisher
+}
+
+// NewPublisher creates the publisher workflow, shipping the images in repo with publisher
+func NewPublisher(repo repository.ImageRepository, publisher domain.Publisher, cfg Config, opts ...Option) *Publisher {
+	return &Publisher{runner: newRunner("publisher", repo, cfg, opts), publisher: publisher}
+}
+
+// Run runs a pass every PublisherInterval until ctx is cancelled, backing off while idle or
+// failing
+func (p *Publisher) Run(ctx context.Context) {
+	p.pollLoop(ctx, p.cfg.PublisherInterval, nil, func() (Summary, error) {
+		return p.RunOnce(ctx)
+	})
+	p.log.Info("Image publishing workflow stopped", "workflow", p.name)
+}
+
+// RunOnce publishes up to BatchSize images that are ready to publish, unless the publisher is
+// paused. Cancelling ctx stops publishing more images, while the image in flight finishes.
+func (p *Publisher) RunOnce(ctx context.Context) (Summary, error) {
+	return p.runUnlessPaused(ctx, func() (Summary, error) {
+		return p.runOnce(ctx)
+	})
+}
+
+// runOnce runs a single pass of the publisher
+func (p *Publisher) runOnce(ctx context.Context) (summary Summary, err error) {
+	outcomes := p.outcomes.startPass(p.name)
+	defer func() { summary = outcomes.finishPass() }()
+	work, span := tracing.Start(repository.ContextWithActor(WorkContext(ctx), p.name), p.passSpan)
+	defer func() { tracing.End(span, err) }()
+
+	images, err := p.repo.GetAllReadyToPublish(work, p.cfg.BatchSize)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get images ready to publish: %w", err)
+	}
+
+	// Cancelling ctx stops publishing more images, while the image in flight finishes
+	for _, img := range images {
+		if ctx.Err() != nil {
+			break
+		}
+		outcomes.record(img, p.handleRecovered(work, outcomes, img, func(ctx context.Context) error {
+			return p.publishImage(ctx, outcomes, img)
+		}))
+	}
+
+	return summary, nil
+}
+
+// publishImage posts a single image and records the outcome.
+// It returns an error when the image could not be published.
+func (p *Publisher) publishImage(ctx context.Context, outcomes *outcomeReporter, img *domain.Image) error {
+	data, err := p.results.Load(ctx, img)
+	if err != nil {
+		p.recordPublishFailure(ctx, img, err)
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+
+	url, err := p.publisher.Publish(ctx, img, data)
+	if err != nil {
+		p.recordPublishFailure(ctx, img, err)
+		return fmt.Errorf("failed to publish (attempt %d/%d): %w", img.PublishAttempts+1, p.cfg.PublishMaxAttempts, err)
+	}
+
+	if err := p.repo.MarkPublished(ctx, img.ID, url); err != nil {
+		return fmt.Errorf("failed to mark as published: %w", err)
+	}
+	img.PublishedURL = url
+	outcomes.report(ctx, img, domain.StatusPublished, "")
+	p.log.Info("Published image", "workflow", p.name, "image_id", img.ID, "uuid", img.UUID, "url", url)
+	return nil
+}
+
+// recordPublishFailure schedules another publishing attempt with exponential backoff, or marks
+// the image PublishFailed once PublishMaxAttempts is reached
+func (p *Publisher) recordPublishFailure(ctx context.Context, img *domain.Image, publishErr error) {
+	attempts := img.PublishAttempts + 1
+	log := p.log.With("workflow", p.name, "image_id", img.ID, "attempt", attempts)
+	if attempts >= p.cfg.PublishMaxAttempts {
+		log.Warn("Giving up publishing image", "error", publishErr)
+		if err := p.repo.UpdateStatusWithError(ctx, img.ID, domain.StatusPublishFailed, publishErr.Error()); err != nil {
+			log.Error("Error updating status", "error", err)
+		}
+		return
+	}
+
+	delay := p.cfg.RetryBaseDelay << (attempts - 1)
+	if delay > p.cfg.RetryMaxDelay || delay <= 0 {
+		delay = p.cfg.RetryMaxDelay
+	}
+	// Honour the wait Telegram asks for when throttling
+	var apiErr *telegram.APIError
+	if errors.As(publishErr, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+
+	if err := p.repo.RecordPublishFailure(ctx, img.ID, publishErr.Error(), delay); err != nil {
+		log.Error("Error recording publish failure", "error", err)
+	}
+}
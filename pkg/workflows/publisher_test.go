@@ -0,0 +1,166 @@
+package workflows_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/infrastructure/telegram"
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// botAPI is a fake Telegram Bot API answering sendPhoto with the scripted statuses in turn,
+// and successfully once they run out
+type botAPI struct {
+	mu       sync.Mutex
+	statuses []int
+	photos   [][]byte
+	requests int
+}
+
+func (b *botAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	if len(b.statuses) > 0 {
+		status := b.statuses[0]
+		b.statuses = b.statuses[1:]
+		w.WriteHeader(status)
+		if status == http.StatusTooManyRequests {
+			io.WriteString(w, `{"ok":false,"description":"Too Many Requests: retry after 60","parameters":{"retry_after":60}}`)
+		} else {
+			io.WriteString(w, `{"ok":false,"description":"Internal Server Error"}`)
+		}
+		return
+	}
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		http.Error(w, `{"ok":false,"description":"no photo"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	photo, _ := io.ReadAll(file)
+	b.photos = append(b.photos, photo)
+	io.WriteString(w, `{"ok":true,"result":{"message_id":42,"chat":{"username":"channel"}}}`)
+}
+
+// readyToPublish queues a prompt and runs it through the generator, returning its ID
+func readyToPublish(t *testing.T, repo repository.ImageRepository) int {
+	t.Helper()
+	ids := createImages(t, repo, "a lighthouse")
+	svc := testsupport.NewFakeImageGenerationService()
+	if _, err := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+	return ids[0]
+}
+
+// newTelegramPublisher returns a publisher workflow posting to api
+func newTelegramPublisher(t *testing.T, repo repository.ImageRepository, api *botAPI, cfg workflows.Config) *workflows.Publisher {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	client := telegram.NewClient("token", "@channel", telegram.WithBaseURL(srv.URL))
+	return workflows.NewPublisher(repo, client, cfg, testOptions()...)
+}
+
+func TestPublisherPostsToTelegram(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	id := readyToPublish(t, repo)
+	api := &botAPI{}
+
+	if _, err := newTelegramPublisher(t, repo, api, testConfig()).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	img := wantStatus(t, repo, id, domain.StatusPublished)
+	if img.PublishedURL != "https://t.me/channel/42" || img.PublishedAt.IsZero() {
+		t.Errorf("image published at %v to %q, want the message link recorded", img.PublishedAt, img.PublishedURL)
+	}
+	want, _ := base64.StdEncoding.DecodeString(fakeserver.Pixel)
+	if len(api.photos) != 1 || string(api.photos[0]) != string(want) {
+		t.Errorf("Bot API received %d photos, want the decoded result", len(api.photos))
+	}
+}
+
+func TestPublisherRetriesThenGivesUp(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	id := readyToPublish(t, repo)
+	api := &botAPI{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}}
+	cfg := testConfig()
+	cfg.PublishMaxAttempts = 3
+	cfg.RetryBaseDelay = time.Nanosecond
+	cfg.RetryMaxDelay = time.Nanosecond
+	p := newTelegramPublisher(t, repo, api, cfg)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if _, err := p.RunOnce(context.Background()); err != nil {
+			t.Fatalf("RunOnce() #%d error = %v", attempt, err)
+		}
+		if attempt < 3 {
+			img := wantStatus(t, repo, id, domain.StatusReadyToPublish)
+			if img.PublishAttempts != attempt || !strings.Contains(img.ErrorDescription, "500") {
+				t.Errorf("after attempt %d the image has %d attempts and error %q, want the failure recorded", attempt, img.PublishAttempts, img.ErrorDescription)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	img := wantStatus(t, repo, id, domain.StatusPublishFailed)
+	if !strings.Contains(img.ErrorDescription, "Internal Server Error") {
+		t.Errorf("error = %q, want the last Bot API error", img.ErrorDescription)
+	}
+	if api.requests != 3 {
+		t.Errorf("Bot API received %d requests, want 3", api.requests)
+	}
+}
+
+func TestPublisherRecoversAfterFailure(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	id := readyToPublish(t, repo)
+	api := &botAPI{statuses: []int{http.StatusInternalServerError}}
+	cfg := testConfig()
+	cfg.RetryBaseDelay = time.Nanosecond
+	p := newTelegramPublisher(t, repo, api, cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.RunOnce(context.Background()); err != nil {
+			t.Fatalf("RunOnce() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	wantStatus(t, repo, id, domain.StatusPublished)
+}
+
+func TestPublisherHonoursRetryAfter(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	id := readyToPublish(t, repo)
+	api := &botAPI{statuses: []int{http.StatusTooManyRequests}}
+	cfg := testConfig()
+	cfg.RetryBaseDelay = time.Nanosecond
+	p := newTelegramPublisher(t, repo, api, cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.RunOnce(context.Background()); err != nil {
+			t.Fatalf("RunOnce() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The image waits the minute Telegram asked for instead of the short retry delay
+	wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	if api.requests != 1 {
+		t.Errorf("Bot API received %d requests, want the throttled one only", api.requests)
+	}
+}
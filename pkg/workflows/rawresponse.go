@@ -0,0 +1,21 @@
+package workflows
+
+import (
+	"context"
+
+	"github.com/basel-ax/2xiang/internal/rawresponse"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// saveRawResponse stores the provider's status response of a failed or censored generation of
+// img when CaptureRawResponses is on. Failing to store it is only logged, since the outcome
+// of the image is recorded regardless.
+func (r *runner) saveRawResponse(ctx context.Context, img *domain.Image, resp *domain.ImageGenerationResponse) {
+	if !r.cfg.CaptureRawResponses || len(resp.RawResponse) == 0 {
+		return
+	}
+	raw := rawresponse.Sanitize(resp.RawResponse, r.cfg.RawResponseMaxBytes)
+	if err := r.repo.SaveRawResponse(ctx, img.ID, raw); err != nil {
+		r.log.Error("Error saving raw response", "image_id", img.ID, "uuid", resp.UUID, "error", err)
+	}
+}
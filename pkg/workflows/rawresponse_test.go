@@ -0,0 +1,88 @@
+package workflows_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// verboseService is a fake service whose failed generations come with a large status response
+// that carries the API key
+type verboseService struct {
+	*testsupport.FakeImageGenerationService
+}
+
+// verboseResponse is the status response of every failed generation of a verboseService
+var verboseResponse = func() []byte {
+	details := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		details["step"+strconv.Itoa(i)] = "the model reported an error"
+	}
+	data, _ := json.Marshal(map[string]interface{}{"status": "FAIL", "apiKey": "key-123", "details": details})
+	return data
+}()
+
+func (s verboseService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	resp, err := s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid)
+	if resp != nil && resp.Status == "FAIL" {
+		resp.RawResponse = verboseResponse
+	}
+	return resp, err
+}
+
+func TestRawResponseCapture(t *testing.T) {
+	const maxBytes = 2048
+	tests := []struct {
+		name    string
+		capture bool
+	}{
+		{name: "on", capture: true},
+		{name: "off", capture: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			ids := createImages(t, repo, "a lighthouse")
+			fake := testsupport.NewFakeImageGenerationService()
+			fake.Default = testsupport.Outcome{Polls: 1, Fail: true, ErrorDescription: "internal error"}
+			svc := verboseService{fake}
+			cfg := testConfig()
+			cfg.CaptureRawResponses = tt.capture
+			cfg.RawResponseMaxBytes = maxBytes
+			runPipeline(t, workflows.NewGenerator(repo, svc, cfg, testOptions()...), workflows.NewProcessor(repo, svc, cfg, testOptions()...))
+
+			img := wantStatus(t, repo, ids[0], domain.StatusFailed)
+			if !tt.capture {
+				if len(img.RawResponse) != 0 {
+					t.Errorf("raw response = %s, want none stored with capturing off", img.RawResponse)
+				}
+				return
+			}
+
+			// The oversized response is cut to the limit before it is stored, after the key
+			// was redacted
+			var stored struct {
+				Truncated bool   `json:"truncated"`
+				Size      int    `json:"size"`
+				Body      string `json:"body"`
+			}
+			if err := json.Unmarshal(img.RawResponse, &stored); err != nil {
+				t.Fatalf("raw response %.100s is not a JSON object: %v", img.RawResponse, err)
+			}
+			if !stored.Truncated || stored.Size <= maxBytes || len(stored.Body) > maxBytes || stored.Body == "" {
+				t.Errorf("raw response = truncated %v, size %d, body of %d bytes, want the %d bytes of the response cut to %d",
+					stored.Truncated, stored.Size, len(stored.Body), len(verboseResponse), maxBytes)
+			}
+			if strings.Contains(string(img.RawResponse), "key-123") || !strings.Contains(stored.Body, `"apiKey":"[REDACTED]"`) {
+				t.Errorf("raw response = %.200s, want the API key redacted", img.RawResponse)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+)
+
+// internalError is the error description of images whose handling panicked
+const internalError = "internal error"
+
+// handleRecovered runs handle for img under a span of its own and recovers a panic in it: the
+// panic is logged with its stack trace, the image is marked Failed and the panic is returned as
+// an error, so the workflow carries on with the next image
+func (r *runner) handleRecovered(ctx context.Context, outcomes *outcomeReporter, img *domain.Image, handle func(ctx context.Context) error) (err error) {
+	ctx, span := tracing.Start(ctx, r.imageSpan)
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Int("image_id", img.ID))
+	}
+	defer func() { tracing.End(span, err) }()
+
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+
+		log := r.log.With("workflow", r.name, "image_id", img.ID, "uuid", img.UUID)
+		log.Error("Panic handling image", "panic", p, "stack", string(debug.Stack()))
+		if updateErr := r.repo.MarkFailed(ctx, img.ID, internalError, errclass.ClassPermanent); updateErr != nil {
+			log.Error("Error updating status", "error", updateErr)
+		} else {
+			outcomes.report(ctx, img, domain.StatusFailed, internalError)
+		}
+		err = fmt.Errorf("panic: %v", p)
+		r.captureError(ctx, img, err)
+	}()
+
+	return handle(ctx)
+}
+
+// captureError sends err, which failed img for good, to the ErrorReporter. The prompt of img is
+// never sent: it is cut from the message of err, which some providers quote it in.
+func (r *runner) captureError(ctx context.Context, img *domain.Image, err error) {
+	if img.Prompt != "" && strings.Contains(err.Error(), img.Prompt) {
+		err = errors.New(strings.ReplaceAll(err.Error(), img.Prompt, "[prompt]"))
+	}
+	r.errors.CaptureError(ctx, err, map[string]string{
+		"workflow": r.name,
+		"image_id": strconv.Itoa(img.ID),
+		"provider": img.Provider,
+		"trace_id": tracing.TraceID(ctx),
+	})
+}
+
+// handleWithDeadline runs handle for img under a context that expires after PerImageTimeout.
+// When the deadline cuts the handling short the image is left in whatever retryable state it
+// reached and the timeout is logged and counted as skipped rather than returned, so the next
+// pass picks the image up again.
+func (r *runner) handleWithDeadline(ctx context.Context, outcomes *outcomeReporter, img *domain.Image, handle func(ctx context.Context) error) error {
+	timeout := r.cfg.PerImageTimeout
+	imgCtx, cancel := clock.WithTimeout(ctx, r.clk, timeout)
+	defer cancel()
+
+	err := handle(imgCtx)
+	if err != nil && errors.Is(imgCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		r.log.Warn("Image timed out, leaving it for the next pass", "workflow", r.name, "image_id", img.ID, "uuid", img.UUID, "timeout", timeout, "error", err)
+		outcomes.skipped()
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// panicPrompt is the prompt the panicking service cannot handle
+const panicPrompt = "a malformed lighthouse"
+
+// panickingService is a fake service that panics on submitting panicPrompt and on checking the
+// status of the generations in panicUUIDs
+type panickingService struct {
+	*testsupport.FakeImageGenerationService
+	panicUUIDs map[string]bool
+}
+
+func (s *panickingService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	if req.Prompt == panicPrompt {
+		var resp *domain.ImageGenerationResponse
+		_ = resp.UUID // a nil response, like a provider answer the client did not expect
+	}
+	return s.FakeImageGenerationService.GenerateImage(ctx, req)
+}
+
+func (s *panickingService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	if s.panicUUIDs[uuid] {
+		panic("unexpected status response")
+	}
+	return s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid)
+}
+
+func TestGeneratorRecoversPanics(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := &panickingService{FakeImageGenerationService: testsupport.NewFakeImageGenerationService()}
+	g := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...)
+	ids := createImages(t, repo, "a lighthouse", panicPrompt, "a harbour")
+
+	summary, err := g.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Failed != 1 || summary.Errors != 1 {
+		t.Errorf("RunOnce() = %s, want the panic counted as a failed image", summary)
+	}
+
+	// The panic fails its image only, and the images after it are still handled
+	if img := wantStatus(t, repo, ids[1], domain.StatusFailed); img.ErrorDescription != "internal error" {
+		t.Errorf("image failed with %q, want internal error", img.ErrorDescription)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToPublish)
+	wantStatus(t, repo, ids[2], domain.StatusReadyToPublish)
+
+	// The workflow keeps working after the panic
+	next := createImages(t, repo, "a lighthouse at night")
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() after a panic error = %v", err)
+	}
+	wantStatus(t, repo, next[0], domain.StatusReadyToPublish)
+}
+
+func TestProcessorRecoversPanics(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	svc := &panickingService{FakeImageGenerationService: fake, panicUUIDs: map[string]bool{}}
+	cfg := testConfig()
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	p := workflows.NewProcessor(repo, svc, cfg, testOptions()...)
+	ids := createImages(t, repo, "a lighthouse", "a harbour")
+
+	if _, err := g.RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	broken := wantStatus(t, repo, ids[0], domain.StatusGenerate)
+	svc.panicUUIDs[broken.UUID] = true
+
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	if img := wantStatus(t, repo, ids[0], domain.StatusFailed); img.ErrorDescription != "internal error" {
+		t.Errorf("image failed with %q, want internal error", img.ErrorDescription)
+	}
+	wantStatus(t, repo, ids[1], domain.StatusReadyToPublish)
+}
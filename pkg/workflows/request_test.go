@@ -0,0 +1,100 @@
+package workflows_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestGeneratorAppliesImageOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []repository.CreateOption
+		want domain.ImageGenerationRequest
+	}{
+		{
+			name: "defaults",
+			want: domain.ImageGenerationRequest{Style: "UHD", NegativePrompt: "blurry", Width: 1024, Height: 1024},
+		},
+		{
+			name: "style",
+			opts: []repository.CreateOption{repository.WithStyle("ANIME")},
+			want: domain.ImageGenerationRequest{Style: "ANIME", NegativePrompt: "blurry", Width: 1024, Height: 1024},
+		},
+		{
+			name: "negative prompt",
+			opts: []repository.CreateOption{repository.WithNegativePrompt("text, watermark")},
+			want: domain.ImageGenerationRequest{Style: "UHD", NegativePrompt: "text, watermark", Width: 1024, Height: 1024},
+		},
+		{
+			name: "every override",
+			opts: []repository.CreateOption{repository.WithStyle("ANIME"), repository.WithNegativePrompt("text"), repository.WithSize(512, 768), repository.WithSeed(42)},
+			want: domain.ImageGenerationRequest{Style: "ANIME", NegativePrompt: "text", Width: 512, Height: 768, Seed: 42},
+		},
+		{
+			// An empty override is stored as NULL and falls back to the default
+			name: "empty overrides",
+			opts: []repository.CreateOption{repository.WithStyle(""), repository.WithNegativePrompt("")},
+			want: domain.ImageGenerationRequest{Style: "UHD", NegativePrompt: "blurry", Width: 1024, Height: 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			if _, err := repo.CreateImage(context.Background(), "a lighthouse", tt.opts...); err != nil {
+				t.Fatalf("CreateImage() error = %v", err)
+			}
+			svc := testsupport.NewFakeImageGenerationService()
+			cfg := testConfig()
+			cfg.DefaultStyle = "UHD"
+			cfg.DefaultNegativePrompt = "blurry"
+			cfg.DefaultImageWidth = 1024
+			cfg.DefaultImageHeight = 1024
+			if _, err := workflows.NewGenerator(repo, svc, cfg, testOptions()...).RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce() error = %v", err)
+			}
+
+			calls := svc.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("service received %d calls, want 1", len(calls))
+			}
+			got := calls[0].Request
+			if got.Style != tt.want.Style || got.NegativePrompt != tt.want.NegativePrompt || got.Width != tt.want.Width || got.Height != tt.want.Height || got.Seed != tt.want.Seed {
+				t.Errorf("request = style %q, negative prompt %q, %dx%d, seed %d, want style %q, negative prompt %q, %dx%d, seed %d",
+					got.Style, got.NegativePrompt, got.Width, got.Height, got.Seed,
+					tt.want.Style, tt.want.NegativePrompt, tt.want.Width, tt.want.Height, tt.want.Seed)
+			}
+		})
+	}
+}
+
+func TestGeneratorForwardsExtraParams(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	params := map[string]interface{}{"promptWeights": []interface{}{"lighthouse:1.5"}, "futureFlag": true}
+	if _, err := repo.CreateImage(context.Background(), "a lighthouse", repository.WithParams(params)); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	if _, err := repo.CreateImage(context.Background(), "a harbour"); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	svc := testsupport.NewFakeImageGenerationService()
+	if _, err := workflows.NewGenerator(repo, svc, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	calls := svc.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("service received %d calls, want 2", len(calls))
+	}
+	if !reflect.DeepEqual(calls[0].Request.Extra, params) {
+		t.Errorf("Extra = %v, want the params of the image %v", calls[0].Request.Extra, params)
+	}
+	if calls[1].Request.Extra != nil {
+		t.Errorf("Extra = %v, want none for an image without params", calls[1].Request.Extra)
+	}
+}
@@ -0,0 +1,35 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Requeuer queues transiently failed images for generation again
+type Requeuer struct {
+	*runner
+}
+
+// NewRequeuer creates the requeuer workflow for the images in repo. It has no polling loop;
+// run its passes on a schedule.
+func NewRequeuer(repo repository.ImageRepository, cfg Config, opts ...Option) *Requeuer {
+	return &Requeuer{runner: newRunner("requeuer", repo, cfg, opts)}
+}
+
+// RunOnce moves images that failed transiently at least RequeueFailedAfter ago back to
+// ReadyToGenerate, up to RequeueFailedMaxAttempts times per image, unless the requeuer is paused
+func (q *Requeuer) RunOnce(ctx context.Context) (Summary, error) {
+	return q.runUnlessPaused(ctx, func() (Summary, error) {
+		work, span := tracing.Start(repository.ContextWithActor(WorkContext(ctx), q.name), q.passSpan)
+		n, err := q.repo.RequeueFailed(work, q.cfg.RequeueFailedAfter, q.cfg.RequeueFailedMaxAttempts)
+		tracing.End(span, err)
+		if err != nil {
+			return Summary{}, fmt.Errorf("failed to requeue failed images: %w", err)
+		}
+		q.log.Info("Requeued failed images", "workflow", q.name, "images", n)
+		return Summary{Images: n}, nil
+	})
+}
@@ -0,0 +1,55 @@
+package workflows_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/errclass"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+func TestRequeuerRespectsAttemptCap(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	ctx := context.Background()
+	ids := createImages(t, repo, "a lighthouse", "a censored lighthouse")
+	fail := func(id int, class string) {
+		t.Helper()
+		repo.UpdateStatus(ctx, id, domain.StatusGenerate)
+		if err := repo.MarkFailed(ctx, id, "failed as "+class, class); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+	}
+	fail(ids[0], errclass.ClassTransient)
+	fail(ids[1], errclass.ClassCensored)
+
+	cfg := testConfig()
+	cfg.RequeueFailedAfter = 0
+	cfg.RequeueFailedMaxAttempts = 1
+	var logs bytes.Buffer
+	q := workflows.NewRequeuer(repo, cfg, workflows.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	summary, err := q.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Images != 1 {
+		t.Errorf("RunOnce() = %s, want the transient failure requeued", summary)
+	}
+	if !strings.Contains(logs.String(), `msg="Requeued failed images" workflow=requeuer images=1`) {
+		t.Errorf("requeue count not logged:\n%s", logs.String())
+	}
+	wantStatus(t, repo, ids[0], domain.StatusReadyToGenerate)
+	wantStatus(t, repo, ids[1], domain.StatusFailed)
+
+	// Failing again after the last attempt leaves the image Failed for good
+	fail(ids[0], errclass.ClassTransient)
+	if summary, err := q.RunOnce(ctx); err != nil || summary.Images != 0 {
+		t.Errorf("RunOnce() at the cap = %s, %v, want nothing requeued", summary, err)
+	}
+	wantStatus(t, repo, ids[0], domain.StatusFailed)
+}
@@ -0,0 +1,133 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// slowService is a fake service whose submissions block until released, like a provider
+// call that is still in flight when the process is asked to stop
+type slowService struct {
+	*testsupport.FakeImageGenerationService
+	started chan string
+	release chan struct{}
+}
+
+func newSlowService() *slowService {
+	return &slowService{
+		FakeImageGenerationService: testsupport.NewFakeImageGenerationService(),
+		started:                    make(chan string, 10),
+		release:                    make(chan struct{}),
+	}
+}
+
+func (s *slowService) GenerateImage(ctx context.Context, req domain.ImageGenerationRequest) (*domain.ImageGenerationResponse, error) {
+	s.started <- req.Prompt
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.FakeImageGenerationService.GenerateImage(ctx, req)
+}
+
+// runGenerator runs g until stop is cancelled, handling images in flight under kill, and
+// returns a channel closed once Run returned
+func runGenerator(g *workflows.Generator, stop, kill context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(workflows.WithHardKill(stop, kill))
+	}()
+	return done
+}
+
+// waitStarted waits for the next submission to reach svc and returns its prompt
+func waitStarted(t *testing.T, svc *slowService) string {
+	t.Helper()
+	select {
+	case prompt := <-svc.started:
+		return prompt
+	case <-time.After(5 * time.Second):
+		t.Fatal("no submission within 5s")
+		return ""
+	}
+}
+
+func TestShutdownFinishesImagesInFlight(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := newSlowService()
+	cfg := testConfig()
+	cfg.GeneratorInterval = time.Millisecond
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	ids := createImages(t, repo, "a lighthouse", "a harbour")
+
+	kill, hardKill := context.WithCancel(context.Background())
+	defer hardKill()
+	stop, cancel := context.WithCancel(kill)
+	done := runGenerator(g, stop, kill)
+
+	first := waitStarted(t, svc)
+	cancel()
+	// The workflow waits for the submission in flight instead of returning right away
+	select {
+	case <-done:
+		t.Fatal("Run() returned while an image was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(svc.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return once the image in flight finished")
+	}
+
+	// The submitted image was saved with its UUID, and no other image was picked up
+	inFlight, other := ids[0], ids[1]
+	if first != "a lighthouse" {
+		inFlight, other = other, inFlight
+	}
+	if img := wantStatus(t, repo, inFlight, domain.StatusReadyToPublish); img.UUID == "" {
+		t.Errorf("image %d finished without its generation UUID", inFlight)
+	}
+	wantStatus(t, repo, other, domain.StatusReadyToGenerate)
+	if n := len(svc.Calls()); n != 1 {
+		t.Errorf("service received %d calls, want only the one in flight", n)
+	}
+}
+
+func TestShutdownHardKillAbortsImagesInFlight(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	svc := newSlowService()
+	cfg := testConfig()
+	cfg.GeneratorInterval = time.Millisecond
+	g := workflows.NewGenerator(repo, svc, cfg, testOptions()...)
+	ids := createImages(t, repo, "a lighthouse")
+
+	kill, hardKill := context.WithCancel(context.Background())
+	stop, cancel := context.WithCancel(kill)
+	done := runGenerator(g, stop, kill)
+
+	waitStarted(t, svc)
+	cancel()
+	// The grace period ends before the provider answers
+	hardKill()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the hard kill")
+	}
+
+	// The aborted submission left no generation behind, so the image is generated again later
+	img := getImage(t, repo, ids[0])
+	if img.UUID != "" || img.Status == domain.StatusGenerate || img.Status == domain.StatusReadyToPublish {
+		t.Errorf("image has status %s and UUID %q after the hard kill, want it left to generate again", img.Status, img.UUID)
+	}
+}
@@ -0,0 +1,90 @@
+package workflows
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary counts what a single workflow pass did with the images it picked up
+type Summary struct {
+	// Images is the number of images the pass picked up
+	Images int
+	// Submitted counts images a provider accepted for generation
+	Submitted int
+	// Generated, Failed, TimedOut, Censored and Published count images that reached that final
+	// status
+	Generated int
+	Failed    int
+	TimedOut  int
+	Censored  int
+	Published int
+	// Skipped counts images left for a later pass, after a retryable error or a timeout
+	Skipped int
+	// Errors counts images that hit an error, broken down by error class in ErrorClasses
+	Errors       int
+	ErrorClasses map[string]int
+	Duration     time.Duration
+}
+
+// LogAttrs returns the summary as structured log attributes
+func (s Summary) LogAttrs() []any {
+	attrs := []any{
+		"images", s.Images,
+		"submitted", s.Submitted,
+		"generated", s.Generated,
+		"failed", s.Failed,
+		"timed_out", s.TimedOut,
+		"censored", s.Censored,
+		"published", s.Published,
+		"skipped", s.Skipped,
+		"errors", s.Errors,
+		"duration", s.Duration,
+	}
+	if len(s.ErrorClasses) > 0 {
+		attrs = append(attrs, "error_classes", s.ErrorClasses)
+	}
+	return attrs
+}
+
+// String describes the summary for people, leaving out counts that are zero
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d images", s.Images)
+
+	var counts []string
+	for _, c := range []struct {
+		n    int
+		name string
+	}{
+		{s.Submitted, "submitted"},
+		{s.Generated, "generated"},
+		{s.Failed, "failed"},
+		{s.TimedOut, "timed out"},
+		{s.Censored, "censored"},
+		{s.Published, "published"},
+		{s.Skipped, "skipped"},
+		{s.Errors, "errors"},
+	} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.name))
+		}
+	}
+	if len(counts) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(counts, ", "))
+	}
+
+	if len(s.ErrorClasses) > 0 {
+		classes := make([]string, 0, len(s.ErrorClasses))
+		for class, n := range s.ErrorClasses {
+			classes = append(classes, fmt.Sprintf("%s %d", class, n))
+		}
+		sort.Strings(classes)
+		fmt.Fprintf(&b, " (%s)", strings.Join(classes, ", "))
+	}
+	if s.Duration > 0 {
+		fmt.Fprintf(&b, " in %s", s.Duration.Round(time.Millisecond))
+	}
+	return b.String()
+}
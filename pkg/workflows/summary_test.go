@@ -0,0 +1,134 @@
+package workflows_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSummaryOfScriptedRun(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.On("done at once", testsupport.Done())
+	fake.On("done later", testsupport.DoneAfter(1))
+	fake.On("also done later", testsupport.DoneAfter(2))
+	fake.On("failed", testsupport.Failed("internal error"))
+	fake.On("censored", testsupport.Censored())
+	fake.On("censored later", testsupport.Outcome{Polls: 1, Censored: true})
+	fake.On("overloaded", testsupport.SubmitError(statusError(503)))
+	fake.On("rejected", testsupport.SubmitError(statusError(400)))
+	fake.On("stuck", testsupport.Timeout())
+	createImages(t, repo, "done at once", "done later", "also done later", "failed", "censored", "censored later", "overloaded", "rejected", "stuck")
+
+	reg := prometheus.NewRegistry()
+	prom, err := metrics.NewPrometheus(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheus() error = %v", err)
+	}
+	cfg := testConfig()
+	g := workflows.NewGenerator(repo, fake, cfg, testOptions(workflows.WithMetrics(prom))...)
+	p := workflows.NewProcessor(repo, fake, cfg, testOptions(workflows.WithMetrics(prom))...)
+	ctx := context.Background()
+
+	// run runs a pass and compares its summary, apart from the duration, with want
+	run := func(name string, pass func(context.Context) (workflows.Summary, error), want workflows.Summary) {
+		t.Helper()
+		got, err := pass(ctx)
+		if err != nil {
+			t.Fatalf("%s RunOnce() error = %v", name, err)
+		}
+		if got.Duration <= 0 {
+			t.Errorf("%s summary has duration %v, want the time the pass took", name, got.Duration)
+		}
+		got.Duration = 0
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s summary = %+v, want %+v", name, got, want)
+		}
+	}
+
+	// The overloaded image is left for the next pass, the rejected one fails
+	run("generator", g.RunOnce, workflows.Summary{
+		Images:       9,
+		Submitted:    7,
+		Generated:    1,
+		Censored:     1,
+		Failed:       1,
+		Skipped:      1,
+		Errors:       1,
+		ErrorClasses: map[string]int{"invalid": 1},
+	})
+	// The stuck image uses up its checks and stays generating, without an error
+	run("processor", p.RunOnce, workflows.Summary{
+		Images:       5,
+		Generated:    2,
+		Censored:     1,
+		Failed:       1,
+		Errors:       1,
+		ErrorClasses: map[string]int{"permanent": 1},
+	})
+
+	// The counters add up the summaries of both passes
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: metrics.ImagesSubmitted, want: 7},
+		{name: metrics.ImagesGenerated, want: 3},
+		{name: metrics.ImagesCensored, want: 2},
+		{name: metrics.ImagesFailed, want: 2},
+		{name: metrics.ImagesTimedOut, want: 0},
+		{name: metrics.ImagesSkipped, labels: map[string]string{"workflow": "generator"}, want: 1},
+		{name: metrics.ImageErrors, labels: map[string]string{"workflow": "generator", "class": "invalid"}, want: 1},
+		{name: metrics.ImageErrors, labels: map[string]string{"workflow": "processor", "class": "permanent"}, want: 1},
+	}
+	for _, tt := range tests {
+		if got := series(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestSummaryString(t *testing.T) {
+	tests := []struct {
+		summary workflows.Summary
+		want    string
+	}{
+		{summary: workflows.Summary{}, want: "0 images"},
+		{summary: workflows.Summary{Images: 3, Submitted: 3, Duration: 1500 * time.Microsecond}, want: "3 images: 3 submitted in 2ms"},
+		{
+			summary: workflows.Summary{Images: 9, Submitted: 7, Generated: 1, Failed: 1, TimedOut: 1, Censored: 1, Published: 1, Skipped: 1, Errors: 3,
+				ErrorClasses: map[string]int{"transient": 2, "permanent": 1}, Duration: time.Second},
+			want: "9 images: 7 submitted, 1 generated, 1 failed, 1 timed out, 1 censored, 1 published, 1 skipped, 3 errors (permanent 1, transient 2) in 1s",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.summary.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSummaryLogAttrs(t *testing.T) {
+	s := workflows.Summary{Images: 2, Generated: 1, Errors: 1, ErrorClasses: map[string]int{"permanent": 1}, Duration: time.Second}
+	want := []any{
+		"images", 2, "submitted", 0, "generated", 1, "failed", 0, "timed_out", 0, "censored", 0,
+		"published", 0, "skipped", 0, "errors", 1, "duration", time.Second,
+		"error_classes", map[string]int{"permanent": 1},
+	}
+	if got := s.LogAttrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LogAttrs() = %v, want %v", got, want)
+	}
+	// Without errors the classes are left out
+	s = workflows.Summary{Images: 1}
+	if got := s.LogAttrs(); len(got) != len(want)-2 {
+		t.Errorf("LogAttrs() = %v, want no error classes", got)
+	}
+}
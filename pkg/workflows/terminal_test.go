@@ -0,0 +1,154 @@
+package workflows_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// checkErrorService is a fake service whose status checks fail with err
+type checkErrorService struct {
+	*testsupport.FakeImageGenerationService
+	err error
+}
+
+func (s checkErrorService) CheckGenerationStatus(ctx context.Context, uuid string) (*domain.ImageGenerationResponse, error) {
+	if _, err := s.FakeImageGenerationService.CheckGenerationStatus(ctx, uuid); err != nil {
+		return nil, err
+	}
+	return nil, s.err
+}
+
+func TestProcessorTerminalPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome testsupport.Outcome
+		// checkErr fails every status check
+		checkErr error
+		config   func(*workflows.Config)
+		want     domain.ImageStatus
+		// checks is the number of status checks the pass makes
+		checks  int
+		summary workflows.Summary
+		// wantErr is whether the pass ends with an error
+		wantErr bool
+	}{
+		{
+			name:    "done",
+			outcome: testsupport.DoneAfter(1),
+			want:    domain.StatusReadyToPublish,
+			checks:  2,
+			summary: workflows.Summary{Images: 1, Generated: 1},
+		},
+		{
+			name:    "failed",
+			outcome: testsupport.Failed("internal error"),
+			want:    domain.StatusFailed,
+			checks:  1,
+			summary: workflows.Summary{Images: 1, Failed: 1, Errors: 1, ErrorClasses: map[string]int{"permanent": 1}},
+		},
+		{
+			name:    "censored",
+			outcome: testsupport.Outcome{Polls: 1, Censored: true},
+			want:    domain.StatusCensored,
+			checks:  2,
+			summary: workflows.Summary{Images: 1, Censored: 1},
+		},
+		{
+			name:    "generation timeout",
+			outcome: testsupport.Timeout(),
+			config:  func(cfg *workflows.Config) { cfg.GenerationTimeout = time.Nanosecond },
+			want:    domain.StatusTimedOut,
+			checks:  1,
+			summary: workflows.Summary{Images: 1, TimedOut: 1},
+		},
+		{
+			name:     "not found",
+			outcome:  testsupport.Timeout(),
+			checkErr: statusError(404),
+			want:     domain.StatusReadyToGenerate,
+			checks:   1,
+			summary:  workflows.Summary{Images: 1},
+		},
+		{
+			name:     "not found after the last reset",
+			outcome:  testsupport.Timeout(),
+			checkErr: statusError(404),
+			config:   func(cfg *workflows.Config) { cfg.NotFoundMaxResets = 0 },
+			want:     domain.StatusFailed,
+			checks:   1,
+			summary:  workflows.Summary{Images: 1, Failed: 1, Errors: 1, ErrorClasses: map[string]int{"permanent": 1}},
+		},
+		{
+			name:     "rejected credentials",
+			outcome:  testsupport.Timeout(),
+			checkErr: statusError(401),
+			want:     domain.StatusGenerate,
+			checks:   1,
+			summary:  workflows.Summary{Images: 1, Skipped: 1},
+			wantErr:  true,
+		},
+		{
+			name:     "rejected check",
+			outcome:  testsupport.Timeout(),
+			checkErr: statusError(400),
+			want:     domain.StatusFailed,
+			checks:   1,
+			summary:  workflows.Summary{Images: 1, Failed: 1, Errors: 1, ErrorClasses: map[string]int{"invalid": 1}},
+		},
+		{
+			name:    "attempts used up",
+			outcome: testsupport.Timeout(),
+			want:    domain.StatusGenerate,
+			checks:  3,
+			summary: workflows.Summary{Images: 1},
+		},
+		{
+			name:     "attempts used up by retryable errors",
+			outcome:  testsupport.Timeout(),
+			checkErr: statusError(503),
+			want:     domain.StatusGenerate,
+			checks:   3,
+			summary:  workflows.Summary{Images: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemoryImageRepository()
+			fake := testsupport.NewFakeImageGenerationService()
+			fake.Default = tt.outcome
+			id := createImages(t, repo, "a lighthouse")[0]
+			if _, err := workflows.NewGenerator(repo, fake, testConfig(), testOptions()...).RunOnce(context.Background()); err != nil {
+				t.Fatalf("generator RunOnce() error = %v", err)
+			}
+			wantStatus(t, repo, id, domain.StatusGenerate)
+
+			cfg := testConfig()
+			if tt.config != nil {
+				tt.config(&cfg)
+			}
+			var svc domain.ImageGenerationService = fake
+			if tt.checkErr != nil {
+				svc = checkErrorService{fake, tt.checkErr}
+			}
+			summary, err := workflows.NewProcessor(repo, svc, cfg, testOptions()...).RunOnce(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processor RunOnce() error = %v, want error %v", err, tt.wantErr)
+			}
+			summary.Duration = 0
+			if !reflect.DeepEqual(summary, tt.summary) {
+				t.Errorf("RunOnce() = %+v, want %+v", summary, tt.summary)
+			}
+			if n := len(fake.Calls()) - submissions(fake); n != tt.checks {
+				t.Errorf("%d status checks, want %d", n, tt.checks)
+			}
+			wantStatus(t, repo, id, tt.want)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package workflows_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/basel-ax/2xiang/internal/tracing"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/fusionbrain/fakeserver"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans records the spans started until the test ends in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracing.Use(provider)
+	t.Cleanup(func() {
+		tracing.Use(nil)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// spanTree indexes recorded spans by name and ID
+type spanTree struct {
+	spans []tracetest.SpanStub
+	byID  map[string]tracetest.SpanStub
+}
+
+// newSpanTree indexes spans
+func newSpanTree(spans tracetest.SpanStubs) spanTree {
+	tree := spanTree{spans: spans, byID: make(map[string]tracetest.SpanStub)}
+	for _, s := range spans {
+		tree.byID[s.SpanContext.SpanID().String()] = s
+	}
+	return tree
+}
+
+// named returns the spans called name
+func (tree spanTree) named(name string) []tracetest.SpanStub {
+	var found []tracetest.SpanStub
+	for _, s := range tree.spans {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// parent returns the name of the parent of s, or "" for a root span
+func (tree spanTree) parent(s tracetest.SpanStub) string {
+	return tree.byID[s.Parent.SpanID().String()].Name
+}
+
+// attributes returns the attributes of s by key
+func attributes(s tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value, len(s.Attributes))
+	for _, kv := range s.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+// wantChildren checks that every span called child has a parent called parent, and that there
+// is at least one such span
+func wantChildren(t *testing.T, tree spanTree, parent string, children ...string) {
+	t.Helper()
+	for _, child := range children {
+		spans := tree.named(child)
+		if len(spans) == 0 {
+			t.Errorf("no %s span recorded", child)
+		}
+		for _, s := range spans {
+			if got := tree.parent(s); got != parent {
+				t.Errorf("%s span is a child of %q, want %s", child, got, parent)
+			}
+		}
+	}
+}
+
+func TestTracingOneGeneration(t *testing.T) {
+	srv := fakeserver.New(fakeserver.WithCredentials("key", "secret"))
+	defer srv.Close()
+	repo := repository.NewMemoryImageRepository()
+	id := createImages(t, repo, "a lighthouse")[0]
+	exporter := recordSpans(t)
+	g, p := fakeServerPipeline(srv, repository.NewTraced(repo))
+
+	ctx := context.Background()
+	if _, err := g.RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	tree := newSpanTree(exporter.GetSpans())
+
+	// Each pass is the root of a trace holding everything done for its images
+	for _, workflow := range []string{"generator", "processor"} {
+		passes := tree.named(workflow + ".pass")
+		if len(passes) != 1 || passes[0].Parent.IsValid() {
+			t.Fatalf("recorded %d %s.pass spans, want a single root span", len(passes), workflow)
+		}
+		images := tree.named(workflow + ".image")
+		if len(images) != 1 {
+			t.Fatalf("recorded %d %s.image spans, want one for the image", len(images), workflow)
+		}
+		if got := attributes(images[0])["image_id"]; got.AsInt64() != int64(id) {
+			t.Errorf("%s.image image_id = %v, want %d", workflow, got.Emit(), id)
+		}
+		trace := passes[0].SpanContext.TraceID()
+		for _, s := range tree.spans {
+			if s.SpanContext.TraceID() == trace && s.Name != passes[0].Name && !s.Parent.IsValid() {
+				t.Errorf("%s span is a second root in the trace of %s.pass", s.Name, workflow)
+			}
+		}
+	}
+
+	wantChildren(t, tree, "generator.pass", "generator.image", "repository.ReclaimExpiredLeases", "repository.ClaimForGeneration", "repository.ReleaseClaim")
+	wantChildren(t, tree, "generator.image", "service.GenerateImage", "repository.UpdateUUID", "repository.UpdateProvider")
+	wantChildren(t, tree, "processor.pass", "processor.image", "repository.GetAllReadyToCheck")
+	wantChildren(t, tree, "processor.image", "service.CheckGenerationStatus", "repository.SaveVariants")
+
+	// Every provider request is a child of the service call that made it, with its endpoint and
+	// status code
+	requests := map[string]int64{}
+	for _, s := range tree.named("fusionbrain.request") {
+		attrs := attributes(s)
+		endpoint := attrs["endpoint"].AsString()
+		want := map[string]string{"pipelines": "service.GenerateImage", "run": "service.GenerateImage", "status": "service.CheckGenerationStatus"}[endpoint]
+		if got := tree.parent(s); want == "" || got != want {
+			t.Errorf("fusionbrain.request to %q is a child of %q, want %q", endpoint, got, want)
+		}
+		if attrs["provider"].AsString() != "fusionbrain" || attrs["http.request.method"].AsString() == "" {
+			t.Errorf("fusionbrain.request attributes = %v, want the provider and method", s.Attributes)
+		}
+		requests[endpoint] = attrs["http.response.status_code"].AsInt64()
+		if s.Status.Code == codes.Error {
+			t.Errorf("fusionbrain.request to %q failed: %v", endpoint, s.Status)
+		}
+	}
+	if want := map[string]int64{"pipelines": 200, "run": 201, "status": 200}; len(requests) != len(want) ||
+		requests["pipelines"] != want["pipelines"] || requests["run"] != want["run"] || requests["status"] != want["status"] {
+		t.Errorf("status codes of the provider requests = %v, want %v", requests, want)
+	}
+}
+
+func TestTracingMarksFailedRequests(t *testing.T) {
+	srv := fakeserver.New(fakeserver.WithCredentials("key", "secret"))
+	defer srv.Close()
+	repo := repository.NewMemoryImageRepository()
+	createImages(t, repo, "a lighthouse")
+	g, p := fakeServerPipeline(srv, repository.NewTraced(repo))
+	ctx := context.Background()
+	if _, err := g.RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+
+	// The generation is gone by the time its status is checked
+	exporter := recordSpans(t)
+	srv.FailNext(fakeserver.EndpointStatus, http.StatusNotFound, 1)
+	p.RunOnce(ctx)
+	tree := newSpanTree(exporter.GetSpans())
+
+	requests := tree.named("fusionbrain.request")
+	if len(requests) != 1 {
+		t.Fatalf("recorded %d fusionbrain.request spans, want the failed status request", len(requests))
+	}
+	if got := attributes(requests[0])["http.response.status_code"].AsInt64(); got != http.StatusNotFound || requests[0].Status.Code != codes.Error {
+		t.Errorf("fusionbrain.request = status code %d, %v, want 404 marked as an error", got, requests[0].Status)
+	}
+	checks := tree.named("service.CheckGenerationStatus")
+	if len(checks) != 1 || checks[0].Status.Code != codes.Error || len(checks[0].Events) == 0 {
+		t.Errorf("service.CheckGenerationStatus spans = %+v, want one failed with the error recorded", checks)
+	}
+}
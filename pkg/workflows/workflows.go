@@ -0,0 +1,323 @@
+// Package workflows runs the image pipeline against an image repository: the generator submits
+// queued prompts, the processor waits for their generations to finish, the publisher ships the
+// results, the requeuer retries transient failures and the pruner deletes old events. Every
+// workflow runs a single pass with RunOnce, or polls with Run until its context is cancelled, so
+// it can be embedded in any binary; cmd/example is one of them.
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/results"
+	"github.com/basel-ax/2xiang/pkg/clock"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/metrics"
+	"github.com/basel-ax/2xiang/pkg/repository"
+)
+
+// Config holds the settings of the workflows. DefaultConfig returns the defaults of cmd/example.
+type Config struct {
+	// GeneratorInterval, ProcessorInterval and PublisherInterval are the polling intervals of
+	// Run while images are queued
+	GeneratorInterval time.Duration
+	ProcessorInterval time.Duration
+	PublisherInterval time.Duration
+	// IdleBackoffMax bounds the polling interval after passes without images, and
+	// ErrorBackoffMax after failed passes
+	IdleBackoffMax  time.Duration
+	ErrorBackoffMax time.Duration
+	// BatchSize is the most images a pass picks up; zero means no limit
+	BatchSize int
+	// Concurrency is the number of images the generator and processor handle in parallel
+	Concurrency int
+	// PerImageTimeout bounds the handling of a single image
+	PerImageTimeout time.Duration
+	// WorkerID identifies the process in the claims of the generator, which other workers
+	// reclaim unless they are renewed within ClaimLease
+	WorkerID   string
+	ClaimLease time.Duration
+
+	// The request defaults of the generator, overridden by the settings of each image
+	DefaultImageWidth     int
+	DefaultImageHeight    int
+	DefaultNumImages      int
+	DefaultStyle          string
+	DefaultNegativePrompt string
+	// MaxPromptLength is the longest prompt submitted, in characters; longer ones are truncated
+	MaxPromptLength int
+	// PromptRejectTerms reject prompts before submission, along with the banned_terms table
+	PromptRejectTerms []domain.BannedTerm
+	// DedupMode is off, reuse (copy the earlier image's result) or mark (set status Duplicate)
+	DedupMode string
+	// DailyRequestCap and DailySpendCap, at RequestCost per request, pause the generator until
+	// midnight UTC once used up; zero disables them
+	DailyRequestCap int
+	DailySpendCap   float64
+	RequestCost     float64
+	// CensoredRequeue queues a censored image once more, with CensoredNegativePrompt added
+	CensoredRequeue        bool
+	CensoredNegativePrompt string
+	// CaptureRawResponses stores the provider responses of failed and censored generations, up
+	// to RawResponseMaxBytes
+	CaptureRawResponses bool
+	RawResponseMaxBytes int
+	// LegacyBase64 also stores the base64 data of the first variant in the images row
+	LegacyBase64 bool
+
+	// CheckInterval is the first wait between the status checks of the processor, growing by
+	// half up to PollMaxInterval, for at most MaxAttempts checks of an image per pass and as
+	// many as fit into PerImageTimeout
+	CheckInterval   time.Duration
+	PollMaxInterval time.Duration
+	MaxAttempts     int
+	// GenerationTimeout is how long a generation may take before its image is marked TimedOut
+	GenerationTimeout time.Duration
+	// NotFoundMaxResets is how often an image whose generation the provider no longer knows is
+	// submitted again before it is marked Failed
+	NotFoundMaxResets int
+
+	// PublishMaxAttempts is how often the publisher tries an image, waiting RetryBaseDelay
+	// doubled with every failed attempt, up to RetryMaxDelay, in between
+	PublishMaxAttempts int
+	RetryBaseDelay     time.Duration
+	RetryMaxDelay      time.Duration
+
+	// RequeueFailedAfter is how long a transiently failed image waits before the requeuer
+	// queues it again, up to RequeueFailedMaxAttempts times
+	RequeueFailedAfter       time.Duration
+	RequeueFailedMaxAttempts int
+
+	// EventRetention is how long the event log of status transitions is kept before the
+	// pruner deletes it
+	EventRetention time.Duration
+}
+
+// DefaultConfig returns the configuration cmd/example runs with when nothing is set
+func DefaultConfig() Config {
+	return Config{
+		GeneratorInterval:        10 * time.Second,
+		ProcessorInterval:        15 * time.Second,
+		PublisherInterval:        5 * time.Second,
+		IdleBackoffMax:           2 * time.Minute,
+		ErrorBackoffMax:          2 * time.Minute,
+		BatchSize:                100,
+		Concurrency:              4,
+		PerImageTimeout:          60 * time.Second,
+		WorkerID:                 defaultWorkerID(),
+		ClaimLease:               2 * time.Minute,
+		DefaultImageWidth:        1024,
+		DefaultImageHeight:       1024,
+		DefaultNumImages:         1,
+		MaxPromptLength:          999,
+		DedupMode:                "off",
+		CensoredNegativePrompt:   "nsfw, nudity, explicit, violence, gore, blood",
+		RawResponseMaxBytes:      16384,
+		LegacyBase64:             true,
+		CheckInterval:            2 * time.Second,
+		PollMaxInterval:          30 * time.Second,
+		MaxAttempts:              30,
+		GenerationTimeout:        5 * time.Minute,
+		NotFoundMaxResets:        3,
+		PublishMaxAttempts:       5,
+		RetryBaseDelay:           30 * time.Second,
+		RetryMaxDelay:            time.Hour,
+		RequeueFailedAfter:       30 * time.Minute,
+		RequeueFailedMaxAttempts: 3,
+		EventRetention:           90 * 24 * time.Hour,
+	}
+}
+
+// Results persists the files of finished generations and loads them back for publishing.
+// Implementations must be safe for concurrent use.
+type Results interface {
+	// Save stores the base64 files of the generation uuid of img as its variants
+	Save(ctx context.Context, img *domain.Image, uuid string, files []string) error
+	// Load returns the selected variant of img decoded
+	Load(ctx context.Context, img *domain.Image) ([]byte, error)
+}
+
+// HealthReporter is told about the progress of the polling loops, e.g. to serve readiness
+// checks. Implementations must be safe for concurrent use.
+type HealthReporter interface {
+	// Tick reports a successful pass of the named workflow and when the next one is due
+	Tick(name string, next time.Duration)
+	// Degraded reports that passes of the named workflow fail with err
+	Degraded(name string, err error)
+}
+
+// ErrorReporter is sent the failures nobody expects: panics and images failing permanently.
+// Implementations must be safe for concurrent use and must not block.
+type ErrorReporter interface {
+	// CaptureError reports err with tags such as image_id, workflow, provider and trace_id
+	CaptureError(ctx context.Context, err error, tags map[string]string)
+}
+
+// Hooks are called as the workflows handle images. They are called from the workflow
+// goroutines, possibly concurrently, so they must be safe for concurrent use and return
+// quickly. Nil hooks are skipped.
+type Hooks struct {
+	// OnOutcome is called when an image reaches a final status: ReadyToPublish, Failed,
+	// TimedOut, Censored or Published
+	OnOutcome func(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string)
+	// AfterPass is called with the summary of every finished pass of the named workflow
+	AfterPass func(workflow string, summary Summary)
+}
+
+// options holds the settings of Option
+type options struct {
+	logger  *slog.Logger
+	clock   clock.Clock
+	metrics metrics.Hook
+	health  HealthReporter
+	hooks   Hooks
+	results Results
+	errors  ErrorReporter
+}
+
+// Option configures optional workflow settings
+type Option func(*options)
+
+// WithLogger sets the logger of the workflow; the default is slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithClock sets the clock of the polling, backoff and timeouts of the workflow
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithMetrics sets the hook the workflow reports its counters and durations to
+func WithMetrics(hook metrics.Hook) Option {
+	return func(o *options) {
+		o.metrics = hook
+	}
+}
+
+// WithHealth reports the progress of Run to health
+func WithHealth(health HealthReporter) Option {
+	return func(o *options) {
+		o.health = health
+	}
+}
+
+// WithHooks sets the hooks called as the workflow handles images
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+// WithResults sets where finished generations are saved and loaded from. By default their
+// files are kept base64 encoded in the database.
+func WithResults(results Results) Option {
+	return func(o *options) {
+		o.results = results
+	}
+}
+
+// WithErrorReporter sends panics and permanently failed images to errors; by default they are
+// only logged
+func WithErrorReporter(errors ErrorReporter) Option {
+	return func(o *options) {
+		o.errors = errors
+	}
+}
+
+// runner holds what every workflow needs: its name, settings, dependencies and pause state
+type runner struct {
+	name     string
+	repo     repository.ImageRepository
+	cfg      Config
+	clk      clock.Clock
+	log      *slog.Logger
+	health   HealthReporter
+	results  Results
+	errors   ErrorReporter
+	outcomes *outcomeReporter
+	pause    pauseState
+	// passSpan and imageSpan name the trace spans of a pass and of each image it handles
+	passSpan  string
+	imageSpan string
+}
+
+// newRunner creates the runner of the named workflow
+func newRunner(name string, repo repository.ImageRepository, cfg Config, opts []Option) *runner {
+	o := options{
+		logger:  slog.Default(),
+		clock:   clock.Real(),
+		metrics: metrics.Nop{},
+		health:  nopHealth{},
+		errors:  nopErrors{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.results == nil {
+		o.results = results.NewWriter(repo, results.Config{LegacyBase64: cfg.LegacyBase64}, results.WithLogger(o.logger))
+	}
+
+	return &runner{
+		name:      name,
+		repo:      repo,
+		cfg:       cfg,
+		clk:       o.clock,
+		log:       o.logger,
+		health:    o.health,
+		results:   o.results,
+		errors:    o.errors,
+		outcomes:  &outcomeReporter{metrics: o.metrics, hooks: o.hooks, log: o.logger, clk: o.clock},
+		passSpan:  name + ".pass",
+		imageSpan: name + ".image",
+	}
+}
+
+// defaultWorkerID identifies the process by its host name and process ID
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// nopHealth is the HealthReporter of workflows without one
+type nopHealth struct{}
+
+// Tick implements HealthReporter
+func (nopHealth) Tick(string, time.Duration) {}
+
+// Degraded implements HealthReporter
+func (nopHealth) Degraded(string, error) {}
+
+// nopErrors is the ErrorReporter of workflows without one
+type nopErrors struct{}
+
+// CaptureError implements ErrorReporter
+func (nopErrors) CaptureError(context.Context, error, map[string]string) {}
+
+// hardKillKey is the context key of the context that aborts work in flight
+type hardKillKey struct{}
+
+// WithHardKill returns a copy of ctx that carries kill. Cancelling ctx only stops workflows
+// from picking up more images; images already in flight are handled under kill.
+func WithHardKill(ctx, kill context.Context) context.Context {
+	return context.WithValue(ctx, hardKillKey{}, kill)
+}
+
+// WorkContext returns the context to handle a picked up image with: the hard-kill context
+// carried by ctx, or ctx itself when it carries none
+func WorkContext(ctx context.Context) context.Context {
+	if kill, ok := ctx.Value(hardKillKey{}).(context.Context); ok {
+		return kill
+	}
+	return ctx
+}
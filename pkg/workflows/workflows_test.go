@@ -0,0 +1,185 @@
+package workflows_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basel-ax/2xiang/internal/testsupport"
+	"github.com/basel-ax/2xiang/pkg/domain"
+	"github.com/basel-ax/2xiang/pkg/repository"
+	"github.com/basel-ax/2xiang/pkg/workflows"
+)
+
+// hookRecorder records the calls of the workflow hooks
+type hookRecorder struct {
+	mu       sync.Mutex
+	outcomes map[int][]domain.ImageStatus
+	errors   map[int]string
+	passes   map[string]int
+}
+
+func newHookRecorder() *hookRecorder {
+	return &hookRecorder{outcomes: make(map[int][]domain.ImageStatus), errors: make(map[int]string), passes: make(map[string]int)}
+}
+
+// hooks returns the hooks recording to h
+func (h *hookRecorder) hooks() workflows.Hooks {
+	return workflows.Hooks{
+		OnOutcome: func(ctx context.Context, img *domain.Image, status domain.ImageStatus, errorDescription string) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.outcomes[img.ID] = append(h.outcomes[img.ID], status)
+			h.errors[img.ID] = errorDescription
+		},
+		AfterPass: func(workflow string, summary workflows.Summary) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.passes[workflow]++
+		},
+	}
+}
+
+func TestGeneratorRunOnceSubmits(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	id, err := repo.CreateImage(context.Background(), "a lighthouse", repository.WithStyle("ANIME"), repository.WithSize(512, 768))
+	if err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.Timeout()
+
+	summary, err := workflows.NewGenerator(repo, fake, testConfig(), testOptions()...).RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if summary.Images != 1 || summary.Submitted != 1 {
+		t.Errorf("RunOnce() = %s, want the image submitted", summary)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].Method != testsupport.MethodGenerateImage {
+		t.Fatalf("calls = %+v, want a single submission", calls)
+	}
+	req := calls[0].Request
+	if req.Prompt != "a lighthouse" || req.Style != "ANIME" || req.Width != 512 || req.Height != 768 {
+		t.Errorf("submitted %+v, want the prompt, style and size of the image", req)
+	}
+	img := wantStatus(t, repo, id, domain.StatusGenerate)
+	if img.UUID == "" || img.Provider != "fake" || img.GenerationStartedAt.IsZero() {
+		t.Errorf("submitted image has UUID %q, provider %q and start %v, want the generation recorded", img.UUID, img.Provider, img.GenerationStartedAt)
+	}
+
+	// A second pass finds nothing left to submit
+	summary, err = workflows.NewGenerator(repo, fake, testConfig(), testOptions()...).RunOnce(context.Background())
+	if err != nil || summary.Images != 0 {
+		t.Errorf("second RunOnce() = %s, %v, want no images", summary, err)
+	}
+}
+
+func TestProcessorRunOnceSavesResults(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	id := createImages(t, repo, "a lighthouse")[0]
+	hooks := newHookRecorder()
+	opts := testOptions(workflows.WithHooks(hooks.hooks()))
+	if _, err := workflows.NewGenerator(repo, fake, testConfig(), opts...).RunOnce(context.Background()); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+
+	summary, err := workflows.NewProcessor(repo, fake, testConfig(), opts...).RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+	if summary.Images != 1 || summary.Generated != 1 {
+		t.Errorf("RunOnce() = %s, want the image generated", summary)
+	}
+	img := wantStatus(t, repo, id, domain.StatusReadyToPublish)
+	if img.Variants != 1 {
+		t.Errorf("generated image has %d variants, want its file stored", img.Variants)
+	}
+	if got := hooks.outcomes[id]; len(got) != 1 || got[0] != domain.StatusReadyToPublish {
+		t.Errorf("OnOutcome reported %v, want ReadyToPublish once", got)
+	}
+	if hooks.passes["generator"] != 1 || hooks.passes["processor"] != 1 {
+		t.Errorf("AfterPass called %v, want once for each workflow", hooks.passes)
+	}
+}
+
+func TestHooksReportFailures(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.On("rejected", testsupport.SubmitError(statusError(400)))
+	fake.On("failed", testsupport.Outcome{Polls: 1, Fail: true, ErrorDescription: "internal error"})
+	ids := createImages(t, repo, "rejected", "failed")
+	hooks := newHookRecorder()
+	opts := testOptions(workflows.WithHooks(hooks.hooks()))
+	ctx := context.Background()
+	if _, err := workflows.NewGenerator(repo, fake, testConfig(), opts...).RunOnce(ctx); err != nil {
+		t.Fatalf("generator RunOnce() error = %v", err)
+	}
+	if _, err := workflows.NewProcessor(repo, fake, testConfig(), opts...).RunOnce(ctx); err != nil {
+		t.Fatalf("processor RunOnce() error = %v", err)
+	}
+
+	for _, id := range ids {
+		wantStatus(t, repo, id, domain.StatusFailed)
+		if got := hooks.outcomes[id]; len(got) != 1 || got[0] != domain.StatusFailed || hooks.errors[id] == "" {
+			t.Errorf("OnOutcome of image %d reported %v (%q), want Failed with its error once", id, got, hooks.errors[id])
+		}
+	}
+}
+
+func TestRunUntilCancelled(t *testing.T) {
+	repo := repository.NewMemoryImageRepository()
+	fake := testsupport.NewFakeImageGenerationService()
+	fake.Default = testsupport.DoneAfter(1)
+	id := createImages(t, repo, "a lighthouse")[0]
+	cfg := testConfig()
+	cfg.GeneratorInterval = 5 * time.Millisecond
+	cfg.ProcessorInterval = 5 * time.Millisecond
+	hooks := newHookRecorder()
+	opts := testOptions(workflows.WithHooks(hooks.hooks()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, run := range []func(context.Context){
+		workflows.NewGenerator(repo, fake, cfg, opts...).Run,
+		workflows.NewProcessor(repo, fake, cfg, opts...).Run,
+	} {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(run)
+	}
+
+	// The loops take the image through generation on their own
+	deadline := time.Now().Add(5 * time.Second)
+	for getImage(t, repo, id).Status != domain.StatusReadyToPublish {
+		if time.Now().After(deadline) {
+			cancel()
+			wg.Wait()
+			t.Fatalf("image is %s after 5s, want it generated by the running workflows", getImage(t, repo, id).Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return within 5s of the cancellation")
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if hooks.passes["generator"] == 0 || hooks.passes["processor"] == 0 {
+		t.Errorf("AfterPass called %v, want passes of both workflows", hooks.passes)
+	}
+}